@@ -45,11 +45,38 @@ type Config struct {
 	IsInit               bool              `json:"isInit"`
 	WebPushUrl           string            `json:"webPushUrl"`
 	WebPushToken         string            `json:"webPushToken"`
+	ClamdAddress         string            `json:"clamdAddress"`        // clamd地址，tcp://127.0.0.1:3310 或 unix:///var/run/clamav/clamd.ctl，为空不启用病毒扫描
+	ClamdTimeout         int               `json:"clamdTimeout"`        // clamd扫描超时时间，单位秒，默认30
+	VirusAction          string            `json:"virusAction"`         // 发现病毒后的处理方式，reject拒收(554)，quarantine隔离，strip删除附件并添加提示，默认reject
+	Milters              []*MilterConfig   `json:"milters"`             // milter过滤器，按顺序依次执行
+	LmtpAddress          string            `json:"lmtpAddress"`         // LMTP监听地址，unix:///run/pmail/lmtp.sock 或 tcp://127.0.0.1:24，为空不启用
+	LmtpAuthServID       string            `json:"lmtpAuthServId"`      // 只信任该authserv-id添加的Authentication-Results头，为空时信任最上面的一个
+	SubaddressSeparator  string            `json:"subaddressSeparator"` // 子地址分隔符，比如 alice+github@domain 中的+，可以同时填写多个如"+-"，默认+
+	ManageSieveAddress   string            `json:"manageSieveAddress"`  // ManageSieve监听地址，比如 :4190，为空不启用
+	BayesThreshold       float64           `json:"bayesThreshold"`      // 贝叶斯分类器判定为垃圾邮件的概率阈值，默认0.9，大于等于1时不启用分类（仍然会训练）
+	QuarantineEnabled    bool              `json:"quarantineEnabled"`   // 垃圾邮件和伪造发件人的邮件进入隔离区，每天给用户发送隔离邮件摘要
+	QuarantineDays       int               `json:"quarantineDays"`      // 隔离邮件保留天数，过期自动删除，默认30
+	ImapAddress          string            `json:"imapAddress"`         // IMAP监听地址，支持STARTTLS，比如:143，为空或者填off不启用
+	ImapTLSAddress       string            `json:"imapTLSAddress"`      // IMAP TLS监听地址，默认:993，填off不启用
+	ImapPlaintext        bool              `json:"imapPlaintext"`       // imapAddress允许不加密登录，仅用于可信内网或前面有TLS代理的情况
+	ImapExpungeToTrash   bool              `json:"imapExpungeToTrash"`  // IMAP EXPUNGE 时把邮件移到已删除文件夹，默认彻底删除。已删除文件夹中的邮件总是彻底删除
+	QuotaDefault         int64             `json:"quotaDefault"`        // 用户默认存储配额，单位字节，0不限制
+	QuotaWarnPercents    []int             `json:"quotaWarnPercents"`   // 用量达到配额的这些百分比时给用户发送警告邮件，默认[80,95]
 	Tables               map[string]string `json:"-"`
 	TablesInitData       map[string]string `json:"-"`
 	setupPort            int               // 初始化阶段端口
 }
 
+// MilterConfig 一个milter过滤器的配置
+type MilterConfig struct {
+	Name       string `json:"name"`
+	Address    string `json:"address"`    // tcp://127.0.0.1:8891 或 unix:///run/opendkim/opendkim.sock
+	Timeout    int    `json:"timeout"`    // 超时时间，单位秒，默认10
+	FailAction string `json:"failAction"` // milter不可用时的处理方式，accept跳过(默认)，tempfail临时拒绝，reject拒收
+	Inbound    bool   `json:"inbound"`    // 是否处理25端口收到的邮件
+	Submission bool   `json:"submission"` // 是否处理465/587端口提交的邮件
+}
+
 var ROOT_PATH = ""
 
 func init() {
@@ -0,0 +1,135 @@
+package controllers
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/Jinnrry/pmail/db"
+	"github.com/Jinnrry/pmail/dto"
+	"github.com/Jinnrry/pmail/dto/response"
+	"github.com/Jinnrry/pmail/i18n"
+	"github.com/Jinnrry/pmail/models"
+	"github.com/Jinnrry/pmail/services/alias"
+	"github.com/Jinnrry/pmail/utils/address"
+	"github.com/Jinnrry/pmail/utils/context"
+	log "github.com/sirupsen/logrus"
+)
+
+func AliasList(ctx *context.Context, w http.ResponseWriter, req *http.Request) {
+	if !ctx.IsAdmin {
+		response.NewErrorResponse(response.NoAccessPrivileges, "No Access Privileges", "").FPrint(w)
+		return
+	}
+
+	var list []*models.Alias
+	err := db.Instance.OrderBy("address").Find(&list)
+	if err != nil {
+		log.WithContext(ctx).Errorf("SQL Error: %+v", err)
+		response.NewErrorResponse(response.ServerError, "server error", err).FPrint(w)
+		return
+	}
+
+	ret := []*dto.Alias{}
+	for _, a := range list {
+		ret = append(ret, (&dto.Alias{}).Decode(a))
+	}
+	response.NewSuccessResponse(ret).FPrint(w)
+}
+
+func UpsertAlias(ctx *context.Context, w http.ResponseWriter, req *http.Request) {
+	if !ctx.IsAdmin {
+		response.NewErrorResponse(response.NoAccessPrivileges, "No Access Privileges", "").FPrint(w)
+		return
+	}
+
+	requestBody, err := io.ReadAll(req.Body)
+	if err != nil {
+		log.WithContext(ctx).Errorf("ReadError:%v", err)
+		return
+	}
+
+	var data *dto.Alias
+	err = json.Unmarshal(requestBody, &data)
+	if err != nil || data == nil {
+		response.NewErrorResponse(response.ParamsError, "params error", err).FPrint(w)
+		return
+	}
+
+	// 别名地址必须是本地域名，* 表示catch-all
+	data.Address = strings.ToLower(strings.TrimSpace(data.Address))
+	account, domain, _ := strings.Cut(data.Address, "@")
+	if account == "" || !alias.IsLocalDomain(domain) || (account != "*" && !address.IsValidEmailAddress(data.Address)) {
+		response.NewErrorResponse(response.ParamsError, "params error", i18n.GetText(ctx.Lang, "invalid_email_address")).FPrint(w)
+		return
+	}
+
+	// 目标可以是本地账号或者邮箱地址
+	var targets []string
+	for _, t := range data.Targets {
+		t = strings.ToLower(strings.TrimSpace(t))
+		if t == "" {
+			continue
+		}
+		if strings.Contains(t, "@") && !address.IsValidEmailAddress(t) {
+			response.NewErrorResponse(response.ParamsError, "params error", i18n.GetText(ctx.Lang, "invalid_email_address")).FPrint(w)
+			return
+		}
+		targets = append(targets, t)
+	}
+	if len(targets) == 0 {
+		response.NewErrorResponse(response.ParamsError, "params error", "targets is empty").FPrint(w)
+		return
+	}
+	data.Targets = targets
+
+	m := data.Encode()
+	if m.Id > 0 {
+		_, err = db.Instance.ID(m.Id).Cols("address", "targets", "disabled").Update(m)
+	} else {
+		_, err = db.Instance.Insert(m)
+	}
+	if err != nil {
+		response.NewErrorResponse(response.ServerError, "server error", err.Error()).FPrint(w)
+		return
+	}
+	response.NewSuccessResponse((&dto.Alias{}).Decode(m)).FPrint(w)
+}
+
+type delAliasReq struct {
+	Id int `json:"id"`
+}
+
+func DelAlias(ctx *context.Context, w http.ResponseWriter, req *http.Request) {
+	if !ctx.IsAdmin {
+		response.NewErrorResponse(response.NoAccessPrivileges, "No Access Privileges", "").FPrint(w)
+		return
+	}
+
+	requestBody, err := io.ReadAll(req.Body)
+	if err != nil {
+		log.WithContext(ctx).Errorf("ReadError:%v", err)
+		return
+	}
+
+	var data delAliasReq
+	err = json.Unmarshal(requestBody, &data)
+	if err != nil {
+		response.NewErrorResponse(response.ParamsError, "params error", err).FPrint(w)
+		return
+	}
+
+	if data.Id <= 0 {
+		response.NewErrorResponse(response.ParamsError, "params error", "id is empty").FPrint(w)
+		return
+	}
+
+	_, err = db.Instance.Exec(db.WithContext(ctx, "delete from alias where id =?"), data.Id)
+	if err != nil {
+		response.NewErrorResponse(response.ServerError, "unknown error", err).FPrint(w)
+		return
+	}
+
+	response.NewSuccessResponse("succ").FPrint(w)
+}
@@ -5,6 +5,7 @@ import (
 	"github.com/Jinnrry/pmail/dto"
 	"github.com/Jinnrry/pmail/dto/response"
 	"github.com/Jinnrry/pmail/services/list"
+	"github.com/Jinnrry/pmail/services/search"
 	"github.com/Jinnrry/pmail/utils/context"
 	log "github.com/sirupsen/logrus"
 	"github.com/spf13/cast"
@@ -25,10 +26,15 @@ type emilItem struct {
 	Desc      string `json:"desc"`
 	Datetime  string `json:"datetime"`
 	IsRead    bool   `json:"is_read"`
+	Starred   bool   `json:"starred"`
+	Answered  bool   `json:"answered"`
 	Sender    User   `json:"sender"`
 	To        []User `json:"to"`
 	Dangerous bool   `json:"dangerous"`
 	Error     string `json:"error"`
+	// ThreadId 会话id，ThreadCount 按会话分组时会话在文件夹中的邮件数量
+	ThreadId    int `json:"thread_id"`
+	ThreadCount int `json:"thread_count,omitempty"`
 }
 
 type User struct {
@@ -41,6 +47,8 @@ type emailRequest struct {
 	Tag         string `json:"tag"`
 	CurrentPage int    `json:"current_page"`
 	PageSize    int    `json:"page_size"`
+	// Thread 按会话分组
+	Thread bool `json:"thread"`
 }
 
 func EmailList(ctx *context.Context, w http.ResponseWriter, req *http.Request) {
@@ -71,7 +79,24 @@ func EmailList(ctx *context.Context, w http.ResponseWriter, req *http.Request) {
 	}
 	_ = json.Unmarshal([]byte(retData.Tag), &tagInfo)
 
-	emailList, total := list.GetEmailList(ctx, tagInfo, retData.Keyword, false, offset, retData.PageSize)
+	// 搜索语句有语法错误时把错误位置返回给前端
+	query, err := search.Parse(retData.Keyword)
+	var filter *search.Filter
+	if err == nil {
+		filter, err = query.Compile(ctx)
+	}
+	if err != nil {
+		response.NewErrorResponse(response.ParamsError, err.Error(), "").FPrint(w)
+		return
+	}
+
+	var emailList []*response.EmailResponseData
+	var total int64
+	if retData.Thread {
+		emailList, total = list.GetThreadList(ctx, tagInfo, filter, offset, retData.PageSize)
+	} else {
+		emailList, total = list.GetEmailList(ctx, tagInfo, filter, false, offset, retData.PageSize)
+	}
 
 	for _, email := range emailList {
 		var sender User
@@ -86,15 +111,19 @@ func EmailList(ctx *context.Context, w http.ResponseWriter, req *http.Request) {
 		_ = json.Unmarshal([]byte(email.To), &tos)
 
 		lst = append(lst, &emilItem{
-			ID:        email.Id,
-			Title:     email.Subject,
-			Desc:      email.Text.String,
-			Datetime:  email.SendDate.Format("2006-01-02 15:04:05"),
-			IsRead:    email.IsRead == 1,
-			Sender:    sender,
-			To:        tos,
-			Dangerous: email.SPFCheck == 0 && email.DKIMCheck == 0,
-			Error:     email.Error.String,
+			ID:          email.Id,
+			Title:       email.Subject,
+			Desc:        email.Text.String,
+			Datetime:    email.SendDate.Format("2006-01-02 15:04:05"),
+			IsRead:      email.IsRead == 1,
+			Starred:     email.Flagged == 1,
+			Answered:    email.Answered == 1,
+			Sender:      sender,
+			To:          tos,
+			Dangerous:   email.SPFCheck == 0 && email.DKIMCheck == 0,
+			Error:       email.Error.String,
+			ThreadId:    email.ThreadId,
+			ThreadCount: email.ThreadCount,
 		})
 	}
 
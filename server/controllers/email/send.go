@@ -12,6 +12,12 @@ import (
 	"github.com/Jinnrry/pmail/hooks/framework"
 	"github.com/Jinnrry/pmail/i18n"
 	"github.com/Jinnrry/pmail/models"
+	"github.com/Jinnrry/pmail/services/antivirus"
+	"github.com/Jinnrry/pmail/services/detail"
+	"github.com/Jinnrry/pmail/services/flag"
+	"github.com/Jinnrry/pmail/services/fulltext"
+	"github.com/Jinnrry/pmail/services/modseq"
+	"github.com/Jinnrry/pmail/services/thread"
 	"github.com/Jinnrry/pmail/utils/array"
 	"github.com/Jinnrry/pmail/utils/async"
 	"github.com/Jinnrry/pmail/utils/context"
@@ -22,6 +28,7 @@ import (
 	"net/http"
 	"strings"
 	"time"
+	"xorm.io/builder"
 )
 
 type sendRequest struct {
@@ -36,6 +43,8 @@ type sendRequest struct {
 	Sender      user         `json:"sender"` // override From as SMTP envelope sender (optional)
 	ReadReceipt []string     `json:"read_receipt"`
 	Attachments []attachment `json:"attrs"`
+	// InReplyTo 回复的邮件id，用于设置In-Reply-To、References头
+	InReplyTo int `json:"in_reply_to"`
 }
 
 type user struct {
@@ -140,6 +149,18 @@ func Send(ctx *context.Context, w http.ResponseWriter, req *http.Request) {
 		}
 	}
 
+	if reqData.InReplyTo > 0 {
+		parent, err := detail.GetEmailDetail(ctx, reqData.InReplyTo, false)
+		if err != nil {
+			response.NewErrorResponse(response.ParamsError, "params error", err.Error()).FPrint(w)
+			return
+		}
+		if parent.MsgID != "" {
+			e.InReplyTo = parent.MsgID
+			e.References = append(strings.Fields(parent.References), parent.MsgID)
+		}
+	}
+
 	e.Text = []byte(reqData.Text)
 	e.HTML = []byte(reqData.HTML)
 	e.Subject = reqData.Subject
@@ -171,6 +192,18 @@ func Send(ctx *context.Context, w http.ResponseWriter, req *http.Request) {
 	}
 	log.WithContext(ctx).Debugf("插件执行--SendBefore End")
 
+	if antivirus.Enabled() {
+		verdict, err := antivirus.ScanMessage(ctx, e.BuildBytes(ctx, false))
+		if err != nil {
+			response.NewErrorResponse(response.ServerError, i18n.GetText(ctx.Lang, "virus_scan_fail"), err.Error()).FPrint(w)
+			return
+		}
+		if err = antivirus.Apply(ctx, verdict, e, true); err != nil {
+			response.NewErrorResponse(response.ParamsError, i18n.GetText(ctx.Lang, "virus_found"), err.Error()).FPrint(w)
+			return
+		}
+	}
+
 	modelEmail := models.Email{
 		Type:         1,
 		Subject:      e.Subject,
@@ -184,6 +217,7 @@ func Send(ctx *context.Context, w http.ResponseWriter, req *http.Request) {
 		Html:         sql.NullString{String: string(e.HTML), Valid: true},
 		Sender:       json2string(e.Sender),
 		Attachments:  json2string(e.Attachments),
+		Size:         e.ContentSize(),
 		SPFCheck:     1,
 		DKIMCheck:    1,
 		SendUserID:   ctx.UserID,
@@ -192,6 +226,8 @@ func Send(ctx *context.Context, w http.ResponseWriter, req *http.Request) {
 		Status:       1,
 		CreateTime:   time.Now(),
 		MsgID:        parsemail.GenerateMsgID(config.Instance.Domain),
+		InReplyTo:    e.InReplyTo,
+		References:   strings.Join(e.References, " "),
 	}
 
 	_, err = db.Instance.Insert(&modelEmail)
@@ -204,6 +240,8 @@ func Send(ctx *context.Context, w http.ResponseWriter, req *http.Request) {
 
 	e.MessageId = cast.ToInt64(modelEmail.Id)
 	e.MsgID = modelEmail.MsgID
+	thread.Assign(ctx, &modelEmail)
+	fulltext.Index(ctx, &modelEmail)
 
 	async.New(ctx).Process(func(p any) {
 		errMsg := ""
@@ -251,6 +289,12 @@ func Send(ctx *context.Context, w http.ResponseWriter, req *http.Request) {
 				IsRead:  1,
 			}
 			db.Instance.Insert(&ue)
+			modseq.Touch(ctx, nil, builder.Eq{"id": ue.ID})
+
+			// 回复成功后给原邮件加上 \Answered 标志
+			if reqData.InReplyTo > 0 {
+				flag.StoreByEmail(ctx, []int{reqData.InReplyTo}, flag.OpAdd, []string{flag.Answered})
+			}
 		}
 
 	}, nil)
@@ -0,0 +1,45 @@
+package email
+
+import (
+	"encoding/json"
+	"github.com/Jinnrry/pmail/dto/response"
+	"github.com/Jinnrry/pmail/services/flag"
+	"github.com/Jinnrry/pmail/utils/context"
+	log "github.com/sirupsen/logrus"
+	"io"
+	"net/http"
+)
+
+type starRequest struct {
+	IDs     []int `json:"ids"`
+	Starred bool  `json:"starred"`
+}
+
+// Star 添加或者取消星标，与IMAP的 \Flagged 标志相同
+func Star(ctx *context.Context, w http.ResponseWriter, req *http.Request) {
+	reqBytes, err := io.ReadAll(req.Body)
+	if err != nil {
+		log.WithContext(ctx).Errorf("%+v", err)
+	}
+	var reqData starRequest
+	err = json.Unmarshal(reqBytes, &reqData)
+	if err != nil {
+		log.WithContext(ctx).Errorf("%+v", err)
+	}
+
+	if len(reqData.IDs) <= 0 {
+		response.NewErrorResponse(response.ParamsError, "ID错误", "").FPrint(w)
+		return
+	}
+
+	op := flag.OpRemove
+	if reqData.Starred {
+		op = flag.OpAdd
+	}
+	err = flag.StoreByEmail(ctx, reqData.IDs, op, []string{flag.Flagged})
+	if err != nil {
+		response.NewErrorResponse(response.ServerError, err.Error(), "").FPrint(w)
+		return
+	}
+	response.NewSuccessResponse("success").FPrint(w)
+}
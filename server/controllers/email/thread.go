@@ -0,0 +1,46 @@
+package email
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+
+	"github.com/Jinnrry/pmail/dto/response"
+	"github.com/Jinnrry/pmail/services/detail"
+	"github.com/Jinnrry/pmail/services/thread"
+	"github.com/Jinnrry/pmail/utils/context"
+	log "github.com/sirupsen/logrus"
+)
+
+type emailThreadRequest struct {
+	// ID 会话中任意一封邮件的id
+	ID int `json:"id"`
+}
+
+// EmailThread 返回邮件所在会话中的全部邮件，包括其他文件夹中的邮件
+func EmailThread(ctx *context.Context, w http.ResponseWriter, req *http.Request) {
+	reqBytes, err := io.ReadAll(req.Body)
+	if err != nil {
+		log.WithContext(ctx).Errorf("%+v", err)
+	}
+	var retData emailThreadRequest
+	err = json.Unmarshal(reqBytes, &retData)
+	if err != nil {
+		log.WithContext(ctx).Errorf("%+v", err)
+	}
+
+	threadId := 0
+	if retData.ID > 0 {
+		threadId = thread.Of(ctx, retData.ID)
+	}
+	if threadId == 0 {
+		response.NewErrorResponse(response.ParamsError, "ID错误", "").FPrint(w)
+		return
+	}
+
+	emails := thread.List(ctx, threadId)
+	for _, email := range emails {
+		detail.ReplaceCid(email)
+	}
+	response.NewSuccessResponse(emails).FPrint(w)
+}
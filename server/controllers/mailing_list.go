@@ -0,0 +1,248 @@
+package controllers
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/Jinnrry/pmail/db"
+	"github.com/Jinnrry/pmail/dto/response"
+	"github.com/Jinnrry/pmail/i18n"
+	"github.com/Jinnrry/pmail/models"
+	"github.com/Jinnrry/pmail/services/alias"
+	"github.com/Jinnrry/pmail/services/mailinglist"
+	"github.com/Jinnrry/pmail/utils/address"
+	"github.com/Jinnrry/pmail/utils/context"
+	log "github.com/sirupsen/logrus"
+)
+
+func MailingLists(ctx *context.Context, w http.ResponseWriter, req *http.Request) {
+	if !ctx.IsAdmin {
+		response.NewErrorResponse(response.NoAccessPrivileges, "No Access Privileges", "").FPrint(w)
+		return
+	}
+
+	ret := []*models.MailingList{}
+	err := db.Instance.OrderBy("address").Find(&ret)
+	if err != nil {
+		log.WithContext(ctx).Errorf("SQL Error: %+v", err)
+		response.NewErrorResponse(response.ServerError, "server error", err).FPrint(w)
+		return
+	}
+	response.NewSuccessResponse(ret).FPrint(w)
+}
+
+func UpsertMailingList(ctx *context.Context, w http.ResponseWriter, req *http.Request) {
+	if !ctx.IsAdmin {
+		response.NewErrorResponse(response.NoAccessPrivileges, "No Access Privileges", "").FPrint(w)
+		return
+	}
+
+	requestBody, err := io.ReadAll(req.Body)
+	if err != nil {
+		log.WithContext(ctx).Errorf("ReadError:%v", err)
+		return
+	}
+
+	var data *models.MailingList
+	err = json.Unmarshal(requestBody, &data)
+	if err != nil || data == nil {
+		response.NewErrorResponse(response.ParamsError, "params error", err).FPrint(w)
+		return
+	}
+
+	data.Address = strings.ToLower(strings.TrimSpace(data.Address))
+	_, domain, _ := strings.Cut(data.Address, "@")
+	if !address.IsValidEmailAddress(data.Address) || !alias.IsLocalDomain(domain) {
+		response.NewErrorResponse(response.ParamsError, "params error", i18n.GetText(ctx.Lang, "invalid_email_address")).FPrint(w)
+		return
+	}
+	if data.PostPolicy < models.ListPolicyMembers || data.PostPolicy > models.ListPolicyModerated {
+		response.NewErrorResponse(response.ParamsError, "params error", "post_policy error").FPrint(w)
+		return
+	}
+
+	if data.Id > 0 {
+		_, err = db.Instance.ID(data.Id).Cols("address", "name", "description", "post_policy", "reply_to_list", "allow_subscribe", "disabled").Update(data)
+	} else {
+		_, err = db.Instance.Insert(data)
+	}
+	if err != nil {
+		response.NewErrorResponse(response.ServerError, "server error", err.Error()).FPrint(w)
+		return
+	}
+	response.NewSuccessResponse(data).FPrint(w)
+}
+
+type mailingListReq struct {
+	Id      int    `json:"id"`
+	ListId  int    `json:"list_id"`
+	Address string `json:"address"`
+	// Moderator 添加成员时是否设为审核员
+	Moderator int `json:"moderator"`
+	// Approve 审核时是否通过
+	Approve bool `json:"approve"`
+}
+
+func readMailingListReq(ctx *context.Context, w http.ResponseWriter, req *http.Request) (*mailingListReq, bool) {
+	requestBody, err := io.ReadAll(req.Body)
+	if err != nil {
+		log.WithContext(ctx).Errorf("ReadError:%v", err)
+		return nil, false
+	}
+	var data mailingListReq
+	err = json.Unmarshal(requestBody, &data)
+	if err != nil {
+		response.NewErrorResponse(response.ParamsError, "params error", err).FPrint(w)
+		return nil, false
+	}
+	return &data, true
+}
+
+func DelMailingList(ctx *context.Context, w http.ResponseWriter, req *http.Request) {
+	if !ctx.IsAdmin {
+		response.NewErrorResponse(response.NoAccessPrivileges, "No Access Privileges", "").FPrint(w)
+		return
+	}
+	data, ok := readMailingListReq(ctx, w, req)
+	if !ok {
+		return
+	}
+	if data.Id <= 0 {
+		response.NewErrorResponse(response.ParamsError, "params error", "id is empty").FPrint(w)
+		return
+	}
+
+	for _, sql := range []string{
+		"delete from mailing_list_member where list_id =?",
+		"delete from mailing_list_pending where list_id =?",
+		"delete from mailing_list_confirm where list_id =?",
+		"delete from mailing_list where id =?",
+	} {
+		_, err := db.Instance.Exec(db.WithContext(ctx, sql), data.Id)
+		if err != nil {
+			response.NewErrorResponse(response.ServerError, "unknown error", err).FPrint(w)
+			return
+		}
+	}
+	response.NewSuccessResponse("succ").FPrint(w)
+}
+
+func MailingListMembers(ctx *context.Context, w http.ResponseWriter, req *http.Request) {
+	if !ctx.IsAdmin {
+		response.NewErrorResponse(response.NoAccessPrivileges, "No Access Privileges", "").FPrint(w)
+		return
+	}
+	data, ok := readMailingListReq(ctx, w, req)
+	if !ok {
+		return
+	}
+
+	ret := []*models.MailingListMember{}
+	err := db.Instance.Where("list_id=?", data.ListId).OrderBy("address").Find(&ret)
+	if err != nil {
+		log.WithContext(ctx).Errorf("SQL Error: %+v", err)
+		response.NewErrorResponse(response.ServerError, "server error", err).FPrint(w)
+		return
+	}
+	response.NewSuccessResponse(ret).FPrint(w)
+}
+
+func AddMailingListMember(ctx *context.Context, w http.ResponseWriter, req *http.Request) {
+	if !ctx.IsAdmin {
+		response.NewErrorResponse(response.NoAccessPrivileges, "No Access Privileges", "").FPrint(w)
+		return
+	}
+	data, ok := readMailingListReq(ctx, w, req)
+	if !ok {
+		return
+	}
+
+	data.Address = strings.ToLower(strings.TrimSpace(data.Address))
+	if data.ListId <= 0 || !address.IsValidEmailAddress(data.Address) {
+		response.NewErrorResponse(response.ParamsError, "params error", i18n.GetText(ctx.Lang, "invalid_email_address")).FPrint(w)
+		return
+	}
+
+	member := &models.MailingListMember{ListId: data.ListId, Address: data.Address, Moderator: data.Moderator}
+	var err error
+	if mailinglist.IsMember(ctx, data.ListId, data.Address) {
+		_, err = db.Instance.Where("list_id=? and address=?", data.ListId, data.Address).Cols("moderator").Update(member)
+	} else {
+		_, err = db.Instance.Insert(member)
+	}
+	if err != nil {
+		response.NewErrorResponse(response.ServerError, "server error", err.Error()).FPrint(w)
+		return
+	}
+	response.NewSuccessResponse("succ").FPrint(w)
+}
+
+func DelMailingListMember(ctx *context.Context, w http.ResponseWriter, req *http.Request) {
+	if !ctx.IsAdmin {
+		response.NewErrorResponse(response.NoAccessPrivileges, "No Access Privileges", "").FPrint(w)
+		return
+	}
+	data, ok := readMailingListReq(ctx, w, req)
+	if !ok {
+		return
+	}
+	if data.Id <= 0 {
+		response.NewErrorResponse(response.ParamsError, "params error", "id is empty").FPrint(w)
+		return
+	}
+
+	_, err := db.Instance.Exec(db.WithContext(ctx, "delete from mailing_list_member where id =?"), data.Id)
+	if err != nil {
+		response.NewErrorResponse(response.ServerError, "unknown error", err).FPrint(w)
+		return
+	}
+	response.NewSuccessResponse("succ").FPrint(w)
+}
+
+// MailingListPending 待审核的邮件，管理员或者列表审核员可以查看
+func MailingListPending(ctx *context.Context, w http.ResponseWriter, req *http.Request) {
+	data, ok := readMailingListReq(ctx, w, req)
+	if !ok {
+		return
+	}
+	if !ctx.IsAdmin && !mailinglist.IsModerator(ctx, data.ListId, ctx.UserAccount) {
+		response.NewErrorResponse(response.NoAccessPrivileges, "No Access Privileges", "").FPrint(w)
+		return
+	}
+
+	ret := []*models.MailingListPending{}
+	err := db.Instance.Where("list_id=?", data.ListId).OrderBy("id").Find(&ret)
+	if err != nil {
+		log.WithContext(ctx).Errorf("SQL Error: %+v", err)
+		response.NewErrorResponse(response.ServerError, "server error", err).FPrint(w)
+		return
+	}
+	response.NewSuccessResponse(ret).FPrint(w)
+}
+
+// ModerateMailingList 通过或者拒绝待审核的邮件
+func ModerateMailingList(ctx *context.Context, w http.ResponseWriter, req *http.Request) {
+	data, ok := readMailingListReq(ctx, w, req)
+	if !ok {
+		return
+	}
+
+	var pending models.MailingListPending
+	has, err := db.Instance.ID(data.Id).Cols("id", "list_id").Get(&pending)
+	if err != nil || !has {
+		response.NewErrorResponse(response.ParamsError, "params error", "id error").FPrint(w)
+		return
+	}
+	if !ctx.IsAdmin && !mailinglist.IsModerator(ctx, pending.ListId, ctx.UserAccount) {
+		response.NewErrorResponse(response.NoAccessPrivileges, "No Access Privileges", "").FPrint(w)
+		return
+	}
+
+	if err := mailinglist.Moderate(ctx, pending.Id, data.Approve); err != nil {
+		response.NewErrorResponse(response.ServerError, "server error", err.Error()).FPrint(w)
+		return
+	}
+	response.NewSuccessResponse("succ").FPrint(w)
+}
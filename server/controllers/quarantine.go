@@ -0,0 +1,110 @@
+package controllers
+
+import (
+	"encoding/json"
+	"fmt"
+	"html"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/Jinnrry/pmail/dto/response"
+	"github.com/Jinnrry/pmail/services/quarantine"
+	"github.com/Jinnrry/pmail/utils/context"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cast"
+)
+
+// QuarantineList 当前用户隔离区中的邮件
+func QuarantineList(ctx *context.Context, w http.ResponseWriter, req *http.Request) {
+	ret, err := quarantine.List(ctx, ctx.UserID)
+	if err != nil {
+		response.NewErrorResponse(response.ServerError, "server error", err.Error()).FPrint(w)
+		return
+	}
+	response.NewSuccessResponse(ret).FPrint(w)
+}
+
+type releaseQuarantineReq struct {
+	Id int `json:"id"`
+	// Allow 同时信任发件人
+	Allow bool `json:"allow"`
+}
+
+// ReleaseQuarantine 把隔离的邮件放回收件箱
+func ReleaseQuarantine(ctx *context.Context, w http.ResponseWriter, req *http.Request) {
+	requestBody, err := io.ReadAll(req.Body)
+	if err != nil {
+		log.WithContext(ctx).Errorf("ReadError:%v", err)
+		return
+	}
+	var data releaseQuarantineReq
+	if err = json.Unmarshal(requestBody, &data); err != nil {
+		response.NewErrorResponse(response.ParamsError, "params error", err).FPrint(w)
+		return
+	}
+
+	q := quarantine.Get(ctx, ctx.UserID, data.Id)
+	if q == nil {
+		response.NewErrorResponse(response.ParamsError, "params error", "quarantined email not found").FPrint(w)
+		return
+	}
+	if data.Allow {
+		err = quarantine.Allow(ctx, q)
+	} else {
+		err = quarantine.Release(ctx, q)
+	}
+	if err != nil {
+		response.NewErrorResponse(response.ServerError, "server error", err.Error()).FPrint(w)
+		return
+	}
+	response.NewSuccessResponse("succ").FPrint(w)
+}
+
+func quarantinePage(w http.ResponseWriter, status int, body string) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
+	fmt.Fprintf(w, `<!DOCTYPE html><html><head><meta charset="utf-8"><title>PMail Quarantine</title></head><body>%s</body></html>`, body)
+}
+
+// QuarantineLink 摘要邮件中的一键链接，不需要登录，通过签名校验。
+// GET请求只显示确认按钮，避免邮件安全网关预取链接时误操作，提交后才执行
+func QuarantineLink(ctx *context.Context, w http.ResponseWriter, req *http.Request) {
+	action := strings.TrimPrefix(req.URL.Path, "/api/quarantine/link/")
+	if action != quarantine.ActionRelease && action != quarantine.ActionAllow {
+		quarantinePage(w, http.StatusNotFound, "<p>Not found</p>")
+		return
+	}
+	if err := req.ParseForm(); err != nil {
+		quarantinePage(w, http.StatusBadRequest, "<p>Invalid request</p>")
+		return
+	}
+	q := quarantine.Get(ctx, 0, cast.ToInt(req.Form.Get("id")))
+	if !quarantine.Verify(q, action, req.Form.Get("sign")) || (action == quarantine.ActionAllow && q.Reason == quarantine.ReasonForged) {
+		quarantinePage(w, http.StatusNotFound, "<p>This message is no longer in quarantine or the link is invalid.</p>")
+		return
+	}
+
+	title := "Release this message to your inbox?"
+	if action == quarantine.ActionAllow {
+		title = fmt.Sprintf("Always accept mail from %s and release its quarantined messages?", html.EscapeString(q.Sender))
+	}
+	if req.Method != http.MethodPost {
+		quarantinePage(w, http.StatusOK, fmt.Sprintf(`<p>%s</p><p>%s</p><form method="post"><input type="hidden" name="id" value="%d"><input type="hidden" name="sign" value="%s"><button type="submit">Confirm</button></form>`,
+			title, html.EscapeString(q.Subject), q.Id, html.EscapeString(req.Form.Get("sign"))))
+		return
+	}
+
+	var err error
+	if action == quarantine.ActionAllow {
+		err = quarantine.Allow(ctx, q)
+	} else {
+		err = quarantine.Release(ctx, q)
+	}
+	if err != nil {
+		log.WithContext(ctx).Errorf("Quarantine Release Error:%v", err)
+		quarantinePage(w, http.StatusInternalServerError, "<p>Release failed, please try again later.</p>")
+		return
+	}
+	quarantinePage(w, http.StatusOK, "<p>The message has been released to your inbox.</p>")
+}
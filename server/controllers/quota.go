@@ -0,0 +1,138 @@
+package controllers
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/Jinnrry/pmail/db"
+	"github.com/Jinnrry/pmail/dto/response"
+	"github.com/Jinnrry/pmail/models"
+	"github.com/Jinnrry/pmail/services/alias"
+	"github.com/Jinnrry/pmail/services/quota"
+	"github.com/Jinnrry/pmail/utils/context"
+	log "github.com/sirupsen/logrus"
+)
+
+// QuotaInfo 当前用户的配额用量
+func QuotaInfo(ctx *context.Context, w http.ResponseWriter, req *http.Request) {
+	var user models.User
+	has, err := db.Instance.ID(ctx.UserID).Get(&user)
+	if err != nil || !has {
+		log.WithContext(ctx).Errorf("SQL Error:%v", err)
+		response.NewErrorResponse(response.ServerError, "server error", "user not found").FPrint(w)
+		return
+	}
+	response.NewSuccessResponse(quota.Roots(ctx, &user)).FPrint(w)
+}
+
+type userQuota struct {
+	Id      int    `json:"id"`
+	Account string `json:"account"`
+	Name    string `json:"name"`
+	// Quota 用户的配额设置，0使用默认配额，-1不限制
+	Quota int64 `json:"quota"`
+	Usage int64 `json:"usage"`
+	Limit int64 `json:"limit"`
+}
+
+// QuotaList 所有用户与域名的配额
+func QuotaList(ctx *context.Context, w http.ResponseWriter, req *http.Request) {
+	if !ctx.IsAdmin {
+		response.NewErrorResponse(response.NoAccessPrivileges, "No Access Privileges", "").FPrint(w)
+		return
+	}
+
+	var users []*models.User
+	err := db.Instance.OrderBy("id").Find(&users)
+	if err != nil {
+		log.WithContext(ctx).Errorf("SQL Error:%v", err)
+		response.NewErrorResponse(response.ServerError, "server error", err.Error()).FPrint(w)
+		return
+	}
+	userList := []*userQuota{}
+	for _, user := range users {
+		q := quota.User(ctx, user)
+		userList = append(userList, &userQuota{
+			Id:      user.ID,
+			Account: user.Account,
+			Name:    user.Name,
+			Quota:   user.Quota,
+			Usage:   q.Usage,
+			Limit:   q.Limit,
+		})
+	}
+
+	domainList := []*quota.Quota{}
+	for _, dq := range quota.Domains(ctx) {
+		domainList = append(domainList, quota.Domain(ctx, dq.Domain))
+	}
+
+	response.NewSuccessResponse(map[string]any{
+		"users":   userList,
+		"domains": domainList,
+	}).FPrint(w)
+}
+
+type setQuotaReq struct {
+	Id     int    `json:"id"`
+	Domain string `json:"domain"`
+	// Quota 单位字节
+	Quota int64 `json:"quota"`
+}
+
+// SetUserQuota 设置用户配额，0使用默认配额，-1不限制
+func SetUserQuota(ctx *context.Context, w http.ResponseWriter, req *http.Request) {
+	if !ctx.IsAdmin {
+		response.NewErrorResponse(response.NoAccessPrivileges, "No Access Privileges", "").FPrint(w)
+		return
+	}
+
+	requestBody, err := io.ReadAll(req.Body)
+	if err != nil {
+		log.WithContext(ctx).Errorf("ReadError:%v", err)
+		return
+	}
+	var data setQuotaReq
+	if err = json.Unmarshal(requestBody, &data); err != nil || data.Id == 0 || data.Quota < quota.Unlimited {
+		response.NewErrorResponse(response.ParamsError, "params error", "").FPrint(w)
+		return
+	}
+
+	if err = quota.SetUser(ctx, data.Id, data.Quota); err != nil {
+		response.NewErrorResponse(response.ServerError, "server error", err.Error()).FPrint(w)
+		return
+	}
+	response.NewSuccessResponse("succ").FPrint(w)
+}
+
+// SetDomainQuota 设置域名配额，0删除配额
+func SetDomainQuota(ctx *context.Context, w http.ResponseWriter, req *http.Request) {
+	if !ctx.IsAdmin {
+		response.NewErrorResponse(response.NoAccessPrivileges, "No Access Privileges", "").FPrint(w)
+		return
+	}
+
+	requestBody, err := io.ReadAll(req.Body)
+	if err != nil {
+		log.WithContext(ctx).Errorf("ReadError:%v", err)
+		return
+	}
+	var data setQuotaReq
+	if err = json.Unmarshal(requestBody, &data); err != nil || data.Quota < 0 {
+		response.NewErrorResponse(response.ParamsError, "params error", "").FPrint(w)
+		return
+	}
+	data.Domain = strings.ToLower(strings.TrimSpace(data.Domain))
+	if !alias.IsLocalDomain(data.Domain) {
+		response.NewErrorResponse(response.ParamsError, "params error", "unknown domain").FPrint(w)
+		return
+	}
+
+	if err = quota.SetDomain(ctx, data.Domain, data.Quota); err != nil {
+		response.NewErrorResponse(response.ServerError, "server error", err.Error()).FPrint(w)
+		return
+	}
+	response.NewSuccessResponse("succ").FPrint(w)
+}
@@ -7,14 +7,17 @@ import (
 	"github.com/Jinnrry/pmail/dto/response"
 	"github.com/Jinnrry/pmail/i18n"
 	"github.com/Jinnrry/pmail/models"
+	"github.com/Jinnrry/pmail/services/flag"
 	"github.com/Jinnrry/pmail/services/rule"
+	"github.com/Jinnrry/pmail/services/rule/match"
 	"github.com/Jinnrry/pmail/utils/address"
-	"github.com/Jinnrry/pmail/utils/array"
 	"github.com/Jinnrry/pmail/utils/context"
 	"github.com/Jinnrry/pmail/utils/errors"
 	log "github.com/sirupsen/logrus"
 	"io"
 	"net/http"
+	"strconv"
+	"time"
 )
 
 func GetRule(ctx *context.Context, w http.ResponseWriter, req *http.Request) {
@@ -37,17 +40,14 @@ func UpsertRule(ctx *context.Context, w http.ResponseWriter, req *http.Request)
 		return
 	}
 
-	if data.Action == dto.FORWARD && !address.IsValidEmailAddress(data.Params) {
-
-		response.NewErrorResponse(response.ParamsError, "ParamsError error", i18n.GetText(ctx.Lang, "invalid_email_address")).FPrint(w)
+	if msg := validateActions(ctx, data.GetActions()); msg != "" {
+		response.NewErrorResponse(response.ParamsError, "ParamsError error", msg).FPrint(w)
 		return
 	}
 
-	for _, r := range data.Rules {
-		if !array.InArray(r.Field, []string{"From", "Subject", "To", "Cc", "Text", "Html", "Content"}) {
-			response.NewErrorResponse(response.ParamsError, "ParamsError error", "params error! Rule Field Error!").FPrint(w)
-			return
-		}
+	if err = match.Validate(data.Rules); err != nil {
+		response.NewErrorResponse(response.ParamsError, "ParamsError error", err.Error()).FPrint(w)
+		return
 	}
 
 	err = save(ctx, data.Encode())
@@ -58,16 +58,55 @@ func UpsertRule(ctx *context.Context, w http.ResponseWriter, req *http.Request)
 	response.NewSuccessResponse("succ").FPrint(w)
 }
 
+// validateActions 检查规则动作的参数，返回错误信息，参数正确时返回空字符串
+func validateActions(ctx *context.Context, actions []*dto.RuleAction) string {
+	for _, action := range actions {
+		switch action.Action {
+		case dto.READ, dto.DELETE:
+		case dto.FORWARD:
+			addresses := rule.SplitAddresses(action.Params)
+			if len(addresses) == 0 {
+				return i18n.GetText(ctx.Lang, "invalid_email_address")
+			}
+			for _, addr := range addresses {
+				if !address.IsValidEmailAddress(addr) {
+					return i18n.GetText(ctx.Lang, "invalid_email_address")
+				}
+			}
+		case dto.MOVE:
+			if _, err := strconv.Atoi(action.Params); err != nil {
+				return "invalid group id"
+			}
+		case dto.FLAG:
+			if len(flag.Parse(action.Params)) == 0 {
+				return "invalid flags"
+			}
+		case dto.REPLY:
+			id, _ := strconv.Atoi(action.Params)
+			if rule.GetTemplate(ctx, ctx.UserID, id) == nil {
+				return "reply template not found"
+			}
+		case dto.WEBHOOK:
+			if !rule.ValidWebhook(action.Params) {
+				return "invalid webhook url"
+			}
+		default:
+			return "unknown action"
+		}
+	}
+	return ""
+}
+
 func save(ctx *context.Context, p *models.Rule) error {
 
 	if p.Id > 0 {
-		_, err := db.Instance.Exec(db.WithContext(ctx, "update rule set name=? ,value = ? ,action = ?,params = ?,sort = ? where id = ? and user_id = ?"), p.Name, p.Value, p.Action, p.Params, p.Sort, p.Id, ctx.UserID)
+		_, err := db.Instance.Exec(db.WithContext(ctx, "update rule set name=? ,value = ? ,action = ?,params = ?,sort = ?,actions = ?,stop = ? where id = ? and user_id = ?"), p.Name, p.Value, p.Action, p.Params, p.Sort, p.Actions, p.Stop, p.Id, ctx.UserID)
 		if err != nil {
 			return errors.Wrap(err)
 		}
 		return nil
 	} else {
-		_, err := db.Instance.Exec(db.WithContext(ctx, "insert into rule (name,value,user_id,action,params,sort) values (?,?,?,?,?,?)"), p.Name, p.Value, ctx.UserID, p.Action, p.Params, p.Sort)
+		_, err := db.Instance.Exec(db.WithContext(ctx, "insert into rule (name,value,user_id,action,params,sort,actions,stop) values (?,?,?,?,?,?,?,?)"), p.Name, p.Value, ctx.UserID, p.Action, p.Params, p.Sort, p.Actions, p.Stop)
 		if err != nil {
 			return errors.Wrap(err)
 		}
@@ -107,3 +146,180 @@ func DelRule(ctx *context.Context, w http.ResponseWriter, req *http.Request) {
 
 	response.NewSuccessResponse("succ").FPrint(w)
 }
+
+type runRuleReq struct {
+	// Id 已保存的规则id，Rule不为空时使用Rule，可以测试还没有保存的规则
+	Id   int       `json:"id"`
+	Rule *dto.Rule `json:"rule"`
+	// Tag 邮件所在的文件夹，格式与邮件列表接口相同
+	Tag string `json:"tag"`
+	// Start End 收信日期范围，格式2006-01-02，包含结束日期当天
+	Start string `json:"start"`
+	End   string `json:"end"`
+	Limit int    `json:"limit"`
+}
+
+// parseRunRule 解析测试或执行规则的请求，参数错误时返回错误信息
+func parseRunRule(ctx *context.Context, req *http.Request) (*dto.Rule, *rule.Scope, *runRuleReq, string) {
+	requestBody, err := io.ReadAll(req.Body)
+	if err != nil {
+		log.WithContext(ctx).Errorf("ReadError:%v", err)
+		return nil, nil, nil, "params error"
+	}
+
+	var data runRuleReq
+	err = json.Unmarshal(requestBody, &data)
+	if err != nil {
+		return nil, nil, nil, "params error"
+	}
+
+	r := data.Rule
+	if r == nil {
+		r = rule.GetRule(ctx, ctx.UserID, data.Id)
+		if r == nil {
+			return nil, nil, nil, "rule not found"
+		}
+	}
+	r.UserId = ctx.UserID
+	if err = match.Validate(r.Rules); err != nil {
+		return nil, nil, nil, err.Error()
+	}
+
+	scope := &rule.Scope{Tag: dto.SearchTag{Type: -1, Status: -1, GroupId: -1}}
+	if data.Tag != "" {
+		if err = json.Unmarshal([]byte(data.Tag), &scope.Tag); err != nil {
+			return nil, nil, nil, "invalid tag"
+		}
+	}
+	if data.Start != "" {
+		scope.Start, err = time.ParseInLocation("2006-01-02", data.Start, time.Local)
+		if err != nil {
+			return nil, nil, nil, "invalid start date"
+		}
+	}
+	if data.End != "" {
+		end, err := time.ParseInLocation("2006-01-02", data.End, time.Local)
+		if err != nil {
+			return nil, nil, nil, "invalid end date"
+		}
+		scope.End = end.AddDate(0, 0, 1)
+	}
+	return r, scope, &data, ""
+}
+
+type testRuleResponse struct {
+	Total   int             `json:"total"`
+	Matched int             `json:"matched"`
+	List    []*rule.Matched `json:"list"`
+}
+
+// TestRule 用规则匹配已有邮件并返回命中的邮件，不执行规则动作
+func TestRule(ctx *context.Context, w http.ResponseWriter, req *http.Request) {
+	r, scope, data, msg := parseRunRule(ctx, req)
+	if msg != "" {
+		response.NewErrorResponse(response.ParamsError, "params error", msg).FPrint(w)
+		return
+	}
+
+	limit := data.Limit
+	if limit <= 0 || limit > 500 {
+		limit = 50
+	}
+	total, matched, lst := rule.Test(ctx, r, scope, limit)
+	response.NewSuccessResponse(testRuleResponse{Total: total, Matched: matched, List: lst}).FPrint(w)
+}
+
+// ApplyRule 在后台对已有邮件执行规则动作，通过ApplyRuleStatus查询进度
+func ApplyRule(ctx *context.Context, w http.ResponseWriter, req *http.Request) {
+	r, scope, _, msg := parseRunRule(ctx, req)
+	if msg == "" {
+		msg = validateActions(ctx, r.GetActions())
+	}
+	if msg != "" {
+		response.NewErrorResponse(response.ParamsError, "params error", msg).FPrint(w)
+		return
+	}
+
+	job, err := rule.Apply(ctx, r, scope)
+	if err != nil {
+		response.NewErrorResponse(response.ServerError, err.Error(), err).FPrint(w)
+		return
+	}
+	response.NewSuccessResponse(job).FPrint(w)
+}
+
+// ApplyRuleStatus 当前用户最近一次执行规则任务的进度
+func ApplyRuleStatus(ctx *context.Context, w http.ResponseWriter, req *http.Request) {
+	response.NewSuccessResponse(rule.GetJob(ctx.UserID)).FPrint(w)
+}
+
+// ReplyTemplates 当前用户的回复模板
+func ReplyTemplates(ctx *context.Context, w http.ResponseWriter, req *http.Request) {
+	ret := []*models.ReplyTemplate{}
+	err := db.Instance.Where("user_id=?", ctx.UserID).Asc("id").Find(&ret)
+	if err != nil {
+		log.WithContext(ctx).Errorf("sqlERror :%v", err)
+		response.NewErrorResponse(response.ServerError, "server error", err).FPrint(w)
+		return
+	}
+	response.NewSuccessResponse(ret).FPrint(w)
+}
+
+// SaveReplyTemplate 新增或修改回复模板，id为0时新增
+func SaveReplyTemplate(ctx *context.Context, w http.ResponseWriter, req *http.Request) {
+	requestBody, err := io.ReadAll(req.Body)
+	if err != nil {
+		log.WithContext(ctx).Errorf("ReadError:%v", err)
+		return
+	}
+
+	var data models.ReplyTemplate
+	err = json.Unmarshal(requestBody, &data)
+	if err != nil {
+		response.NewErrorResponse(response.ParamsError, "params error", err).FPrint(w)
+		return
+	}
+	if data.Name == "" || data.Content == "" {
+		response.NewErrorResponse(response.ParamsError, "params error", "name or content is empty").FPrint(w)
+		return
+	}
+
+	data.UserId = ctx.UserID
+	if data.Id > 0 {
+		if rule.GetTemplate(ctx, ctx.UserID, data.Id) == nil {
+			response.NewErrorResponse(response.ParamsError, "params error", "reply template not found").FPrint(w)
+			return
+		}
+		_, err = db.Instance.Where("id=? and user_id=?", data.Id, ctx.UserID).Cols("name", "subject", "content").Update(&data)
+	} else {
+		_, err = db.Instance.Insert(&data)
+	}
+	if err != nil {
+		log.WithContext(ctx).Errorf("sqlERror :%v", err)
+		response.NewErrorResponse(response.ServerError, "server error", err).FPrint(w)
+		return
+	}
+	response.NewSuccessResponse(data.Id).FPrint(w)
+}
+
+func DelReplyTemplate(ctx *context.Context, w http.ResponseWriter, req *http.Request) {
+	requestBody, err := io.ReadAll(req.Body)
+	if err != nil {
+		log.WithContext(ctx).Errorf("ReadError:%v", err)
+		return
+	}
+
+	var data delRuleReq
+	err = json.Unmarshal(requestBody, &data)
+	if err != nil {
+		response.NewErrorResponse(response.ParamsError, "params error", err).FPrint(w)
+		return
+	}
+
+	_, err = db.Instance.Where("id=? and user_id=?", data.Id, ctx.UserID).Delete(&models.ReplyTemplate{})
+	if err != nil {
+		response.NewErrorResponse(response.ServerError, "unknown error", err).FPrint(w)
+		return
+	}
+	response.NewSuccessResponse("succ").FPrint(w)
+}
@@ -5,11 +5,15 @@ import (
 	"github.com/Jinnrry/pmail/db"
 	"github.com/Jinnrry/pmail/dto/response"
 	"github.com/Jinnrry/pmail/i18n"
+	"github.com/Jinnrry/pmail/models"
+	"github.com/Jinnrry/pmail/services/alias"
+	"github.com/Jinnrry/pmail/services/vacation"
 	"github.com/Jinnrry/pmail/utils/context"
 	"github.com/Jinnrry/pmail/utils/password"
 	log "github.com/sirupsen/logrus"
 	"io"
 	"net/http"
+	"time"
 )
 
 type modifyPasswordRequest struct {
@@ -40,3 +44,125 @@ func ModifyPassword(ctx *context.Context, w http.ResponseWriter, req *http.Reque
 
 	response.NewSuccessResponse(i18n.GetText(ctx.Lang, "succ")).FPrint(w)
 }
+
+type subaddressRequest struct {
+	SubaddressFolder *int `json:"subaddress_folder"`
+}
+
+// Subaddress 查询或修改子地址自动归档设置，不传subaddress_folder时只查询
+func Subaddress(ctx *context.Context, w http.ResponseWriter, req *http.Request) {
+	reqBytes, err := io.ReadAll(req.Body)
+	if err != nil {
+		log.Errorf("%+v", err)
+	}
+	var reqData subaddressRequest
+	if len(reqBytes) > 0 {
+		err = json.Unmarshal(reqBytes, &reqData)
+		if err != nil {
+			response.NewErrorResponse(response.ParamsError, "params error", err.Error()).FPrint(w)
+			return
+		}
+	}
+
+	if reqData.SubaddressFolder != nil {
+		value := 0
+		if *reqData.SubaddressFolder == 1 {
+			value = 1
+		}
+		_, err := db.Instance.Table("user").Where("id=?", ctx.UserID).Update(map[string]interface{}{"subaddress_folder": value})
+		if err != nil {
+			response.NewErrorResponse(response.ServerError, i18n.GetText(ctx.Lang, "unknowError"), "").FPrint(w)
+			return
+		}
+	}
+
+	var user models.User
+	_, err = db.Instance.Where("id=?", ctx.UserID).Get(&user)
+	if err != nil {
+		response.NewErrorResponse(response.ServerError, i18n.GetText(ctx.Lang, "unknowError"), "").FPrint(w)
+		return
+	}
+
+	response.NewSuccessResponse(map[string]any{
+		"subaddress_folder":    user.SubaddressFolder,
+		"subaddress_separator": alias.SubaddressSeparator(),
+	}).FPrint(w)
+}
+
+type vacationRequest struct {
+	Enabled   int    `json:"enabled"`
+	Subject   string `json:"subject"`
+	Content   string `json:"content"`
+	StartTime string `json:"start_time"`
+	EndTime   string `json:"end_time"`
+	Interval  int    `json:"interval"`
+}
+
+func formatVacationTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.Format(time.DateTime)
+}
+
+func parseVacationTime(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	return time.ParseInLocation(time.DateTime, value, time.Local)
+}
+
+// Vacation 查询或修改自动回复设置，请求体为空时只查询
+func Vacation(ctx *context.Context, w http.ResponseWriter, req *http.Request) {
+	reqBytes, err := io.ReadAll(req.Body)
+	if err != nil {
+		log.Errorf("%+v", err)
+	}
+
+	if len(reqBytes) > 0 {
+		var reqData vacationRequest
+		err = json.Unmarshal(reqBytes, &reqData)
+		if err != nil {
+			response.NewErrorResponse(response.ParamsError, "params error", err.Error()).FPrint(w)
+			return
+		}
+		v := &models.Vacation{
+			UserId:   ctx.UserID,
+			Enabled:  reqData.Enabled,
+			Subject:  reqData.Subject,
+			Content:  reqData.Content,
+			Interval: reqData.Interval,
+		}
+		if v.Interval == 0 {
+			v.Interval = vacation.DefaultInterval
+		}
+		v.StartTime, err = parseVacationTime(reqData.StartTime)
+		if err != nil {
+			response.NewErrorResponse(response.ParamsError, "params error", "start_time error").FPrint(w)
+			return
+		}
+		v.EndTime, err = parseVacationTime(reqData.EndTime)
+		if err != nil || (!v.EndTime.IsZero() && v.EndTime.Before(v.StartTime)) {
+			response.NewErrorResponse(response.ParamsError, "params error", "end_time error").FPrint(w)
+			return
+		}
+		if err := vacation.Save(ctx, v); err != nil {
+			response.NewErrorResponse(response.ServerError, i18n.GetText(ctx.Lang, "unknowError"), "").FPrint(w)
+			return
+		}
+	}
+
+	v, err := vacation.Get(ctx, ctx.UserID)
+	if err != nil {
+		response.NewErrorResponse(response.ServerError, i18n.GetText(ctx.Lang, "unknowError"), "").FPrint(w)
+		return
+	}
+	response.NewSuccessResponse(vacationRequest{
+		Enabled:   v.Enabled,
+		Subject:   v.Subject,
+		Content:   v.Content,
+		StartTime: formatVacationTime(v.StartTime),
+		EndTime:   formatVacationTime(v.EndTime),
+		Interval:  v.Interval,
+	}).FPrint(w)
+}
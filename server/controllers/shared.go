@@ -0,0 +1,232 @@
+package controllers
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/Jinnrry/pmail/db"
+	"github.com/Jinnrry/pmail/dto/response"
+	"github.com/Jinnrry/pmail/models"
+	"github.com/Jinnrry/pmail/services/acl"
+	"github.com/Jinnrry/pmail/utils/context"
+	"github.com/Jinnrry/pmail/utils/password"
+	log "github.com/sirupsen/logrus"
+)
+
+type sharedMailbox struct {
+	Id      int    `json:"id"`
+	Account string `json:"account"`
+	Name    string `json:"name"`
+	Shared  bool   `json:"shared"`
+	// Rights 当前用户对整个邮箱的权限
+	Rights string `json:"rights"`
+}
+
+// SharedList 当前用户可以访问的共享邮箱和其他用户的邮箱，管理员可以看到所有共享邮箱
+func SharedList(ctx *context.Context, w http.ResponseWriter, req *http.Request) {
+	owners := acl.Owners(ctx, ctx.UserID)
+	if ctx.IsAdmin {
+		var all []*models.User
+		err := db.Instance.Where("shared=1").OrderBy("account").Find(&all)
+		if err != nil {
+			log.WithContext(ctx).Errorf("SQL Error:%v", err)
+		}
+		for _, owner := range all {
+			if !containsUser(owners, owner.ID) {
+				owners = append(owners, owner)
+			}
+		}
+	}
+
+	ret := []*sharedMailbox{}
+	for _, owner := range owners {
+		ret = append(ret, &sharedMailbox{
+			Id:      owner.ID,
+			Account: owner.Account,
+			Name:    owner.Name,
+			Shared:  owner.Shared == 1,
+			Rights:  acl.Rights(ctx, owner, "", ctx.UserID),
+		})
+	}
+	response.NewSuccessResponse(ret).FPrint(w)
+}
+
+func containsUser(users []*models.User, id int) bool {
+	for _, user := range users {
+		if user.ID == id {
+			return true
+		}
+	}
+	return false
+}
+
+type sharedCreateRequest struct {
+	Account string `json:"account"`
+	Name    string `json:"name"`
+}
+
+// CreateShared 创建共享邮箱，共享邮箱使用随机密码，不能直接登录
+func CreateShared(ctx *context.Context, w http.ResponseWriter, req *http.Request) {
+	if !ctx.IsAdmin {
+		response.NewErrorResponse(response.NoAccessPrivileges, "No Access Privileges", "").FPrint(w)
+		return
+	}
+
+	reqBytes, err := io.ReadAll(req.Body)
+	if err != nil {
+		log.WithContext(ctx).Errorf("ReadError:%v", err)
+		return
+	}
+	var reqData sharedCreateRequest
+	if err = json.Unmarshal(reqBytes, &reqData); err != nil || reqData.Account == "" || strings.ContainsAny(reqData.Account, "@/") {
+		response.NewErrorResponse(response.ParamsError, "Params Error", "").FPrint(w)
+		return
+	}
+	if reqData.Name == "" {
+		reqData.Name = reqData.Account
+	}
+
+	has, err := db.Instance.Where("LOWER(account)=?", strings.ToLower(reqData.Account)).Exist(&models.User{})
+	if err != nil {
+		log.WithContext(ctx).Errorf("SQL Error:%v", err)
+	}
+	if has {
+		response.NewErrorResponse(response.ParamsError, "Account already exists", "").FPrint(w)
+		return
+	}
+
+	secret := make([]byte, 32)
+	if _, err = rand.Read(secret); err != nil {
+		response.NewErrorResponse(response.ServerError, err.Error(), "").FPrint(w)
+		return
+	}
+	user := models.User{
+		Account:  reqData.Account,
+		Name:     reqData.Name,
+		Password: password.Encode(hex.EncodeToString(secret)),
+		Shared:   1,
+	}
+	if _, err = db.Instance.Insert(&user); err != nil {
+		response.NewErrorResponse(response.ServerError, err.Error(), "").FPrint(w)
+		return
+	}
+	response.NewSuccessResponse(&sharedMailbox{
+		Id:      user.ID,
+		Account: user.Account,
+		Name:    user.Name,
+		Shared:  true,
+	}).FPrint(w)
+}
+
+type aclRequest struct {
+	// Id 邮箱所有者的用户id，0表示当前用户自己的邮箱
+	Id int `json:"id"`
+	// Account 被授权的账号，anyone 表示所有用户
+	Account string `json:"account"`
+	// Folder 文件夹完整路径，为空表示整个邮箱
+	Folder string `json:"folder"`
+	Rights string `json:"rights"`
+}
+
+type aclEntry struct {
+	Account string `json:"account"`
+	Folder  string `json:"folder"`
+	Rights  string `json:"rights"`
+}
+
+// aclOwner 查找请求的邮箱，只有管理员、普通用户自己和拥有a权限的用户可以管理授权
+func aclOwner(ctx *context.Context, w http.ResponseWriter, req *http.Request) (*aclRequest, *models.User) {
+	reqBytes, err := io.ReadAll(req.Body)
+	if err != nil {
+		log.WithContext(ctx).Errorf("ReadError:%v", err)
+		return nil, nil
+	}
+	var reqData aclRequest
+	if err = json.Unmarshal(reqBytes, &reqData); err != nil {
+		response.NewErrorResponse(response.ParamsError, "Params Error", "").FPrint(w)
+		return nil, nil
+	}
+	if reqData.Id == 0 {
+		reqData.Id = ctx.UserID
+	}
+
+	var owner models.User
+	has, err := db.Instance.ID(reqData.Id).Get(&owner)
+	if err != nil {
+		log.WithContext(ctx).Errorf("SQL Error:%v", err)
+	}
+	if !has {
+		response.NewErrorResponse(response.ParamsError, "Mailbox not found", "").FPrint(w)
+		return nil, nil
+	}
+	if !(ctx.IsAdmin && owner.Shared == 1) && !acl.Has(acl.Rights(ctx, &owner, reqData.Folder, ctx.UserID), acl.Admin) {
+		response.NewErrorResponse(response.NoAccessPrivileges, "No Access Privileges", "").FPrint(w)
+		return nil, nil
+	}
+	return &reqData, &owner
+}
+
+// ACLList 邮箱中所有的授权
+func ACLList(ctx *context.Context, w http.ResponseWriter, req *http.Request) {
+	_, owner := aclOwner(ctx, w, req)
+	if owner == nil {
+		return
+	}
+
+	var entries []*models.MailboxAcl
+	err := db.Instance.Where("owner_id=?", owner.ID).OrderBy("mailbox,user_id").Find(&entries)
+	if err != nil {
+		log.WithContext(ctx).Errorf("SQL Error:%v", err)
+		response.NewErrorResponse(response.ServerError, "server error", err.Error()).FPrint(w)
+		return
+	}
+	ret := []*aclEntry{}
+	for _, e := range entries {
+		ret = append(ret, &aclEntry{
+			Account: acl.IdentifierName(ctx, e.UserId),
+			Folder:  e.Mailbox,
+			Rights:  e.Rights,
+		})
+	}
+	response.NewSuccessResponse(ret).FPrint(w)
+}
+
+// SetACL 设置账号对邮箱或文件夹的权限，整个邮箱的权限为空时删除授权
+func SetACL(ctx *context.Context, w http.ResponseWriter, req *http.Request) {
+	reqData, owner := aclOwner(ctx, w, req)
+	if owner == nil {
+		return
+	}
+	userId := acl.Identifier(ctx, reqData.Account)
+	if userId < 0 || userId == owner.ID {
+		response.NewErrorResponse(response.ParamsError, "Account not found", "").FPrint(w)
+		return
+	}
+	if err := acl.Set(ctx, owner.ID, reqData.Folder, userId, reqData.Rights); err != nil {
+		response.NewErrorResponse(response.ParamsError, err.Error(), "").FPrint(w)
+		return
+	}
+	response.NewSuccessResponse("succ").FPrint(w)
+}
+
+// DelACL 删除账号对邮箱或文件夹的授权，文件夹恢复使用整个邮箱的权限
+func DelACL(ctx *context.Context, w http.ResponseWriter, req *http.Request) {
+	reqData, owner := aclOwner(ctx, w, req)
+	if owner == nil {
+		return
+	}
+	userId := acl.Identifier(ctx, reqData.Account)
+	if userId < 0 {
+		response.NewErrorResponse(response.ParamsError, "Account not found", "").FPrint(w)
+		return
+	}
+	if err := acl.Delete(ctx, owner.ID, reqData.Folder, userId); err != nil {
+		response.NewErrorResponse(response.ServerError, "server error", err.Error()).FPrint(w)
+		return
+	}
+	response.NewSuccessResponse("succ").FPrint(w)
+}
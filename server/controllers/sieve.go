@@ -0,0 +1,120 @@
+package controllers
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+
+	"github.com/Jinnrry/pmail/dto/response"
+	"github.com/Jinnrry/pmail/services/rule"
+	"github.com/Jinnrry/pmail/services/sieve"
+	"github.com/Jinnrry/pmail/utils/context"
+	"github.com/Jinnrry/pmail/utils/errors"
+	sievelib "github.com/Jinnrry/pmail/utils/sieve"
+	log "github.com/sirupsen/logrus"
+)
+
+type sieveReq struct {
+	Name    string `json:"name"`
+	NewName string `json:"new_name"`
+	Content string `json:"content"`
+	// Active 保存后是否启用该脚本
+	Active bool `json:"active"`
+}
+
+func readSieveReq(ctx *context.Context, w http.ResponseWriter, req *http.Request) (*sieveReq, bool) {
+	requestBody, err := io.ReadAll(req.Body)
+	if err != nil {
+		log.WithContext(ctx).Errorf("ReadError:%v", err)
+		return nil, false
+	}
+	var data sieveReq
+	if len(requestBody) > 0 {
+		if err = json.Unmarshal(requestBody, &data); err != nil {
+			response.NewErrorResponse(response.ParamsError, "params error", err).FPrint(w)
+			return nil, false
+		}
+	}
+	return &data, true
+}
+
+// sieveError 把脚本服务的错误转换为接口响应，语法错误等参数错误直接返回错误信息
+func sieveError(w http.ResponseWriter, err error) {
+	var syntaxErr *sievelib.Error
+	switch {
+	case errors.As(err, &syntaxErr):
+		response.NewErrorResponse(response.ParamsError, "params error", syntaxErr.Error()).FPrint(w)
+	case errors.Is(err, sieve.ErrNotFound), errors.Is(err, sieve.ErrActive), errors.Is(err, sieve.ErrExists),
+		errors.Is(err, sieve.ErrQuota), errors.Is(err, sieve.ErrInvalidName):
+		response.NewErrorResponse(response.ParamsError, "params error", err.Error()).FPrint(w)
+	default:
+		response.NewErrorResponse(response.ServerError, "server error", err.Error()).FPrint(w)
+	}
+}
+
+func SieveScripts(ctx *context.Context, w http.ResponseWriter, req *http.Request) {
+	ret, err := sieve.List(ctx, ctx.UserID)
+	if err != nil {
+		response.NewErrorResponse(response.ServerError, "server error", err.Error()).FPrint(w)
+		return
+	}
+	response.NewSuccessResponse(ret).FPrint(w)
+}
+
+// SaveSieveScript 保存脚本，new_name不为空时重命名，active为true时启用
+func SaveSieveScript(ctx *context.Context, w http.ResponseWriter, req *http.Request) {
+	data, ok := readSieveReq(ctx, w, req)
+	if !ok {
+		return
+	}
+	name := data.Name
+	if data.NewName != "" && data.NewName != data.Name {
+		if err := sieve.Rename(ctx, ctx.UserID, data.Name, data.NewName); err != nil {
+			sieveError(w, err)
+			return
+		}
+		name = data.NewName
+	}
+	if err := sieve.Put(ctx, ctx.UserID, name, data.Content); err != nil {
+		sieveError(w, err)
+		return
+	}
+	if data.Active {
+		if err := sieve.SetActive(ctx, ctx.UserID, name); err != nil {
+			sieveError(w, err)
+			return
+		}
+	}
+	response.NewSuccessResponse("succ").FPrint(w)
+}
+
+// ActiveSieveScript 启用脚本，name为空时停用全部脚本
+func ActiveSieveScript(ctx *context.Context, w http.ResponseWriter, req *http.Request) {
+	data, ok := readSieveReq(ctx, w, req)
+	if !ok {
+		return
+	}
+	if err := sieve.SetActive(ctx, ctx.UserID, data.Name); err != nil {
+		sieveError(w, err)
+		return
+	}
+	response.NewSuccessResponse("succ").FPrint(w)
+}
+
+func DelSieveScript(ctx *context.Context, w http.ResponseWriter, req *http.Request) {
+	data, ok := readSieveReq(ctx, w, req)
+	if !ok {
+		return
+	}
+	if err := sieve.Delete(ctx, ctx.UserID, data.Name); err != nil {
+		sieveError(w, err)
+		return
+	}
+	response.NewSuccessResponse("succ").FPrint(w)
+}
+
+// ConvertRulesToSieve 把当前用户的收信规则转换为Sieve脚本，只返回脚本内容不保存
+func ConvertRulesToSieve(ctx *context.Context, w http.ResponseWriter, req *http.Request) {
+	rules := rule.GetAllRules(ctx, ctx.UserID)
+	response.NewSuccessResponse(sieve.ConvertRules(ctx, rules)).FPrint(w)
+}
@@ -0,0 +1,47 @@
+// Package dbtest 给测试创建独立的SQLite数据库
+package dbtest
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/Jinnrry/pmail/config"
+	"github.com/Jinnrry/pmail/db"
+	"github.com/Jinnrry/pmail/models"
+)
+
+// Init 在测试的临时目录中创建新的SQLite数据库并替换 config.Instance 与 db.Instance，
+// 测试结束后关闭数据库并恢复原来的配置。setup 在初始化数据库之前修改配置
+func Init(t testing.TB, setup ...func(c *config.Config)) {
+	t.Helper()
+	c := &config.Config{
+		DbType:  config.DBTypeSQLite,
+		DbDSN:   filepath.Join(t.TempDir(), "pmail.db"),
+		Domain:  "example.com",
+		Domains: []string{"example.com"},
+	}
+	for _, fn := range setup {
+		fn(c)
+	}
+	oldConfig, oldDB := config.Instance, db.Instance
+	config.Instance = c
+	t.Cleanup(func() {
+		if db.Instance != nil && db.Instance != oldDB {
+			db.Instance.Close()
+		}
+		config.Instance, db.Instance = oldConfig, oldDB
+	})
+	if err := db.Init("test"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// User 插入一个测试用户
+func User(t testing.TB, account, name string) *models.User {
+	t.Helper()
+	user := &models.User{Account: account, Name: name}
+	if _, err := db.Instance.Insert(user); err != nil {
+		t.Fatal(err)
+	}
+	return user
+}
@@ -0,0 +1,35 @@
+package db
+
+import (
+	"github.com/Jinnrry/pmail/config"
+)
+
+// FullTextTable 邮件全文索引表，每封邮件一行。header 列为主题、发件人与收件人，body 列为正文与附件名。
+// SQLite 使用 FTS5 虚拟表，rowid 即邮件id；PostgreSQL 使用 tsvector 与 GIN 索引；MySQL 使用 ngram 分词的 FULLTEXT 索引
+const FullTextTable = "email_fts"
+
+func createFullText() {
+	var sqls []string
+	switch config.Instance.DbType {
+	case config.DBTypeSQLite:
+		sqls = []string{
+			`CREATE VIRTUAL TABLE IF NOT EXISTS email_fts USING fts5(header, body, tokenize='unicode61 remove_diacritics 2')`,
+		}
+	case config.DBTypePostgres:
+		sqls = []string{
+			`CREATE TABLE IF NOT EXISTS email_fts (email_id integer PRIMARY KEY, header text NOT NULL DEFAULT '', body text NOT NULL DEFAULT '', tsv tsvector)`,
+			`CREATE INDEX IF NOT EXISTS idx_email_fts_tsv ON email_fts USING GIN (tsv)`,
+		}
+	case config.DBTypeMySQL:
+		sqls = []string{
+			"CREATE TABLE IF NOT EXISTS email_fts (email_id int unsigned NOT NULL PRIMARY KEY, header text NOT NULL, body longtext NOT NULL, " +
+				"FULLTEXT KEY ft_body (body) WITH PARSER ngram, FULLTEXT KEY ft_all (header, body) WITH PARSER ngram) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4",
+		}
+	}
+	for _, sql := range sqls {
+		_, err := Instance.Exec(sql)
+		if err != nil {
+			panic(err)
+		}
+	}
+}
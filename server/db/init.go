@@ -45,9 +45,11 @@ func Init(version string) error {
 	Instance.ShowSQL(config.Instance.LogLevel == "debug")
 	// 同步表结构
 	syncTables()
+	createFullText()
 
 	// 更新历史数据
 	fixHistoryData()
+	fixThreadId()
 
 	// 在数据库中记录程序版本
 	var v models.Version
@@ -109,6 +111,54 @@ func syncTables() {
 	if err != nil {
 		panic(err)
 	}
+	err = Instance.Sync2(&models.Alias{})
+	if err != nil {
+		panic(err)
+	}
+	err = Instance.Sync2(&models.MailingList{}, &models.MailingListMember{}, &models.MailingListPending{}, &models.MailingListConfirm{})
+	if err != nil {
+		panic(err)
+	}
+	err = Instance.Sync2(&models.Vacation{}, &models.VacationReply{})
+	if err != nil {
+		panic(err)
+	}
+	err = Instance.Sync2(&models.SieveScript{})
+	if err != nil {
+		panic(err)
+	}
+	err = Instance.Sync2(&models.ReplyTemplate{})
+	if err != nil {
+		panic(err)
+	}
+	err = Instance.Sync2(&models.BayesToken{}, &models.BayesStat{}, &models.BayesTrained{})
+	if err != nil {
+		panic(err)
+	}
+	err = Instance.Sync2(&models.Quarantine{}, &models.SenderAllow{})
+	if err != nil {
+		panic(err)
+	}
+	err = Instance.Sync2(&models.MailboxState{}, &models.MailboxVanished{})
+	if err != nil {
+		panic(err)
+	}
+	err = Instance.Sync2(&models.DomainQuota{})
+	if err != nil {
+		panic(err)
+	}
+	err = Instance.Sync2(&models.MailboxAcl{})
+	if err != nil {
+		panic(err)
+	}
+}
+
+// fixThreadId 没有计算过会话的历史邮件各自作为一个会话
+func fixThreadId() {
+	_, err := Instance.Exec("update email set thread_id=id where thread_id=0")
+	if err != nil {
+		panic(err)
+	}
 }
 
 func fixHistoryData() {
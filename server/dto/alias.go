@@ -0,0 +1,31 @@
+package dto
+
+import (
+	"encoding/json"
+	"github.com/Jinnrry/pmail/models"
+)
+
+type Alias struct {
+	Id       int      `json:"id"`
+	Address  string   `json:"address"`
+	Targets  []string `json:"targets"`
+	Disabled int      `json:"disabled"`
+}
+
+func (p *Alias) Decode(data *models.Alias) *Alias {
+	json.Unmarshal([]byte(data.Targets), &p.Targets)
+	p.Id = data.Id
+	p.Address = data.Address
+	p.Disabled = data.Disabled
+	return p
+}
+
+func (p *Alias) Encode() *models.Alias {
+	v, _ := json.Marshal(p.Targets)
+	return &models.Alias{
+		Id:       p.Id,
+		Address:  p.Address,
+		Targets:  string(v),
+		Disabled: p.Disabled,
+	}
+}
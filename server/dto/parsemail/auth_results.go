@@ -0,0 +1,107 @@
+package parsemail
+
+import (
+	"bufio"
+	"bytes"
+	"net/textproto"
+	"strings"
+)
+
+// AuthResults Authentication-Results 头（RFC 8601）中的校验结果
+type AuthResults struct {
+	AuthServID string
+	// Results 校验方法到结果的映射，比如 spf -> pass、dkim -> fail
+	Results map[string]string
+}
+
+// Pass 指定的校验方法是否通过
+func (a *AuthResults) Pass(method string) bool {
+	return a.Result(method) == "pass"
+}
+
+// Result 指定校验方法的结果，没有该方法时返回空字符串
+func (a *AuthResults) Result(method string) string {
+	if a == nil {
+		return ""
+	}
+	return a.Results[method]
+}
+
+// ParseAuthResults 解析一个 Authentication-Results 头的值
+func ParseAuthResults(value string) *AuthResults {
+	value = stripComments(value)
+	value = strings.NewReplacer("\r", " ", "\n", " ", "\t", " ").Replace(value)
+	parts := strings.Split(value, ";")
+
+	ret := &AuthResults{Results: map[string]string{}}
+	// authserv-id 后面可能带有版本号
+	fields := strings.Fields(parts[0])
+	if len(fields) > 0 {
+		ret.AuthServID = strings.ToLower(fields[0])
+	}
+	for _, part := range parts[1:] {
+		fields := strings.Fields(part)
+		if len(fields) == 0 {
+			continue
+		}
+		method, result, ok := strings.Cut(fields[0], "=")
+		if !ok {
+			continue
+		}
+		// method 可能带有版本号，比如 dkim/1
+		method, _, _ = strings.Cut(strings.ToLower(method), "/")
+		result = strings.ToLower(result)
+		// 同一方法出现多次时（比如多个DKIM签名），只要有一个通过即视为通过
+		if ret.Results[method] != "pass" {
+			ret.Results[method] = result
+		}
+	}
+	return ret
+}
+
+// stripComments 删除 RFC 5322 注释，即括号中的内容
+func stripComments(value string) string {
+	var b strings.Builder
+	depth := 0
+	quoted := false
+	for i := 0; i < len(value); i++ {
+		c := value[i]
+		switch {
+		case c == '\\' && i+1 < len(value):
+			if depth == 0 {
+				b.WriteByte(c)
+				b.WriteByte(value[i+1])
+			}
+			i++
+			continue
+		case c == '"' && depth == 0:
+			quoted = !quoted
+		case c == '(' && !quoted:
+			depth++
+			continue
+		case c == ')' && !quoted && depth > 0:
+			depth--
+			continue
+		}
+		if depth == 0 {
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
+
+// FindAuthResults 返回邮件中最上面（即最后一跳MTA添加的）Authentication-Results 头，
+// authServID 不为空时只信任 authserv-id 与之相同的头，找不到时返回nil
+func FindAuthResults(raw []byte, authServID string) *AuthResults {
+	header, err := textproto.NewReader(bufio.NewReader(bytes.NewReader(raw))).ReadMIMEHeader()
+	if err != nil && len(header) == 0 {
+		return nil
+	}
+	for _, value := range header.Values("Authentication-Results") {
+		ret := ParseAuthResults(value)
+		if authServID == "" || strings.EqualFold(ret.AuthServID, authServID) {
+			return ret
+		}
+	}
+	return nil
+}
@@ -0,0 +1,39 @@
+package parsemail
+
+import "testing"
+
+func TestParseAuthResults(t *testing.T) {
+	ar := ParseAuthResults(`mx.example.com 1; spf=pass (sender SPF authorized) smtp.mailfrom=a@example.org;
+	dkim=fail (bad signature) header.d=example.org; dkim/1=pass header.d=example.org; dmarc=none`)
+	if ar.AuthServID != "mx.example.com" {
+		t.Errorf("AuthServID = %s", ar.AuthServID)
+	}
+	if !ar.Pass("spf") || !ar.Pass("dkim") {
+		t.Errorf("unexpected results %+v", ar.Results)
+	}
+	if ar.Result("dmarc") != "none" || ar.Result("arc") != "" {
+		t.Errorf("unexpected results %+v", ar.Results)
+	}
+
+	var empty *AuthResults
+	if empty.Pass("spf") || empty.Result("spf") != "" {
+		t.Error("nil results should not pass")
+	}
+}
+
+func TestFindAuthResults(t *testing.T) {
+	raw := []byte("Authentication-Results: evil.example.net; spf=pass\r\n" +
+		"Authentication-Results: mx.example.com;\r\n\tspf=fail smtp.mailfrom=a@example.org\r\n" +
+		"Subject: test\r\n\r\nbody")
+
+	if ar := FindAuthResults(raw, ""); ar == nil || ar.AuthServID != "evil.example.net" {
+		t.Errorf("topmost header not used: %+v", ar)
+	}
+	ar := FindAuthResults(raw, "MX.example.com")
+	if ar == nil || ar.Result("spf") != "fail" {
+		t.Errorf("trusted header not used: %+v", ar)
+	}
+	if ar := FindAuthResults(raw, "other.example.com"); ar != nil {
+		t.Errorf("untrusted header used: %+v", ar)
+	}
+}
@@ -83,7 +83,21 @@ func (p *Dkim) Sign(msgData string) []byte {
 	return b.Bytes()
 }
 
+// DkimSign 使用本机域名的DKIM私钥对原始邮件签名，未加载私钥时原样返回
+func DkimSign(raw []byte) []byte {
+	if instance == nil {
+		return raw
+	}
+	return instance.Sign(string(raw))
+}
+
 func Check(ctx *context.Context, mail io.Reader) bool {
+	pass, _ := CheckAligned(ctx, mail, "")
+	return pass
+}
+
+// CheckAligned 校验DKIM签名，aligned 表示有签名域与fromDomain对齐的有效签名（DMARC宽松对齐）
+func CheckAligned(ctx *context.Context, mail io.Reader, fromDomain string) (pass bool, aligned bool) {
 
 	verifications, err := dkim.Verify(mail)
 	if err != nil {
@@ -91,19 +105,29 @@ func Check(ctx *context.Context, mail io.Reader) bool {
 	}
 
 	if len(verifications) == 0 {
-		return false
+		return false, false
 	}
 
 	for _, v := range verifications {
 		if v.Domain == consts.TEST_DOMAIN {
-			return true
+			return true, AlignedDomain(v.Domain, fromDomain)
 		}
 		if v.Err == nil {
 			log.Println("Valid signature for:", v.Domain)
+			aligned = aligned || AlignedDomain(v.Domain, fromDomain)
 		} else {
 			log.Println("Invalid signature for:", v.Domain, v.Err)
-			return false
+			return false, false
 		}
 	}
-	return true
+	return true, aligned
+}
+
+// AlignedDomain 两个域名相同或者其中一个是另一个的子域名，近似DMARC的宽松对齐
+func AlignedDomain(a, b string) bool {
+	a, b = strings.ToLower(strings.TrimSuffix(a, ".")), strings.ToLower(strings.TrimSuffix(b, "."))
+	if a == "" || b == "" {
+		return false
+	}
+	return a == b || strings.HasSuffix(a, "."+b) || strings.HasSuffix(b, "."+a)
 }
@@ -16,3 +16,22 @@ xxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxx
 	}
 
 }
+
+func TestAlignedDomain(t *testing.T) {
+	tests := []struct {
+		a, b    string
+		aligned bool
+	}{
+		{"example.com", "Example.com", true},
+		{"mail.example.com", "example.com", true},
+		{"example.com", "news.example.com.", true},
+		{"a.example.com", "b.example.com", false},
+		{"badexample.com", "example.com", false},
+		{"example.com", "", false},
+	}
+	for _, tt := range tests {
+		if got := AlignedDomain(tt.a, tt.b); got != tt.aligned {
+			t.Errorf("AlignedDomain(%q, %q) = %v", tt.a, tt.b, got)
+		}
+	}
+}
@@ -54,23 +54,35 @@ type Attachment struct {
 
 // Email is the type used for email messages
 type Email struct {
-	ReplyTo     []*User
-	From        *User
-	To          []*User
-	Bcc         []*User
-	Cc          []*User
-	Subject     string
-	Text        []byte // Plaintext message (optional)
-	HTML        []byte // Html message (optional)
-	Sender      *User  // override From as SMTP envelope sender (optional)
-	Headers     textproto.MIMEHeader
-	Attachments []*Attachment
-	ReadReceipt []string
-	Date        string
-	Status      int // 0未发送，1已发送，2发送失败，3删除，5广告邮件
-	MessageId   int64
-	MsgID       string // RFC-compliant Message-ID, persisted in DB
-	Size        int
+	ReplyTo        []*User
+	From           *User
+	To             []*User
+	Bcc            []*User
+	Cc             []*User
+	Subject        string
+	Text           []byte // Plaintext message (optional)
+	HTML           []byte // Html message (optional)
+	Sender         *User  // override From as SMTP envelope sender (optional)
+	Headers        textproto.MIMEHeader
+	Attachments    []*Attachment
+	ReadReceipt    []string
+	Date           string
+	Status         int // 0未发送，1已发送，2发送失败，3删除，5广告邮件
+	MessageId      int64
+	MsgID          string // RFC-compliant Message-ID, persisted in DB
+	Size           int
+	Tag            string         // 收件地址中的子地址标签，收信时按收件用户设置，用于规则匹配
+	ReceivedHeader message.Header `json:"-"` // 收到邮件的原始邮件头，用于规则匹配任意邮件头
+	EnvelopeFrom   string         // 信封发件人，收信时设置，用于规则自动回复
+	EnvelopeTo     []string       // 信封收件人，收信时按收件用户设置，用于规则匹配
+	SPFPass        bool           // 收信时SPF校验是否通过
+	DKIMPass       bool           // 收信时DKIM校验是否通过
+	AuthAligned    bool           // 收信时SPF或DKIM通过，并且通过校验的域名与From的域名对齐
+	InReplyTo      string         // In-Reply-To中的Message-ID，不带尖括号
+	References     []string       // References中的Message-ID列表，不带尖括号
+	ListId         string         // List-Id中尖括号内的列表标识
+	Precedence     string         // Precedence头，小写
+	AutoSubmitted  string         // Auto-Submitted头，小写
 }
 
 // GenerateMsgID creates an RFC-compliant Message-ID unique enough to avoid spam filters.
@@ -181,6 +193,15 @@ func (e *Email) BuildBcc2String() string {
 	return users2String(e.Bcc)
 }
 
+// ContentSize 正文与附件的大小之和，没有原始邮件时用于计算邮件大小
+func (e *Email) ContentSize() int {
+	size := len(e.Text) + len(e.HTML)
+	for _, att := range e.Attachments {
+		size += len(att.Content)
+	}
+	return size
+}
+
 func NewEmailFromModel(d models.Email) *Email {
 
 	var To []*User
@@ -201,7 +222,7 @@ func NewEmailFromModel(d models.Email) *Email {
 	var Attachments []*Attachment
 	json.Unmarshal([]byte(d.Attachments), &Attachments)
 
-	return &Email{
+	ret := &Email{
 		MessageId: cast.ToInt64(d.Id),
 		MsgID:     d.MsgID,
 		From: &User{
@@ -219,6 +240,13 @@ func NewEmailFromModel(d models.Email) *Email {
 		Attachments: Attachments,
 		Date:        d.SendDate.Format("2006-01-02 15:04:05"),
 	}
+	ret.ReceivedHeader = DecodeHeader(d.Headers)
+	ret.InReplyTo = d.InReplyTo
+	ret.References = strings.Fields(d.References)
+	ret.ListId = d.ListId
+	ret.Precedence = d.Precedence
+	ret.AutoSubmitted = d.AutoSubmitted
+	return ret
 }
 
 func NewEmailFromReader(to []string, r io.Reader, size int) *Email {
@@ -229,6 +257,7 @@ func NewEmailFromReader(to []string, r io.Reader, size int) *Email {
 	}
 
 	ret.Size = size
+	ret.setReceivedHeader(m.Header)
 	// Preserve the original Message-ID from the sender so it is stored and reused consistently.
 	if mid := m.Header.Get("Message-Id"); mid != "" {
 		ret.MsgID = strings.TrimPrefix(strings.TrimSuffix(strings.TrimSpace(mid), ">"), "<")
@@ -622,6 +651,18 @@ func (e *Email) BuildBytes(ctx *context.Context, dkim bool) []byte {
 		}
 		h.SetAddressList("Cc", cc)
 	}
+	if e.InReplyTo != "" && e.Headers.Get("In-Reply-To") == "" {
+		h.SetMsgIDList("In-Reply-To", []string{e.InReplyTo})
+	}
+	if len(e.References) > 0 && e.Headers.Get("References") == "" {
+		h.SetMsgIDList("References", e.References)
+	}
+	// 附加头，比如自动回复的 Auto-Submitted、In-Reply-To
+	for key, values := range e.Headers {
+		for _, v := range values {
+			h.Add(key, v)
+		}
+	}
 
 	// Create a new mail writer
 	mw, err := mail.CreateWriter(&b, h)
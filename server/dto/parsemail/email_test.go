@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"github.com/emersion/go-message"
 	"io"
+	"net/textproto"
 	"strings"
 
 	"testing"
@@ -206,6 +207,21 @@ func TestEmail_builder(t *testing.T) {
 	fmt.Println(string(rest))
 }
 
+func TestEmail_BuildBytesHeaders(t *testing.T) {
+	e := Email{
+		From:    buildUser("i@test.com"),
+		To:      buildUsers([]string{"to@test.com"}),
+		Subject: "Auto: hi",
+		Text:    []byte("away"),
+		Headers: textproto.MIMEHeader{"Auto-Submitted": {"auto-replied"}, "In-Reply-To": {"<abc@test.com>"}},
+	}
+
+	ret := string(e.BuildBytes(nil, false))
+	if !strings.Contains(ret, "Auto-Submitted: auto-replied\r\n") || !strings.Contains(ret, "In-Reply-To: <abc@test.com>\r\n") {
+		t.Errorf("extra headers missing: %s", ret)
+	}
+}
+
 func TestEmail_BuildPart(t *testing.T) {
 	e := Email{
 		Text: []byte("text"),
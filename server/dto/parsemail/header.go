@@ -0,0 +1,94 @@
+package parsemail
+
+import (
+	"bufio"
+	"bytes"
+	"strings"
+
+	"github.com/emersion/go-message"
+	"github.com/emersion/go-message/textproto"
+)
+
+// ParseMsgIDs 解析 In-Reply-To、References 中的 Message-ID 列表，返回不带尖括号的id
+func ParseMsgIDs(v string) []string {
+	var ret []string
+	rest := v
+	for {
+		start := strings.Index(rest, "<")
+		if start < 0 {
+			break
+		}
+		end := strings.Index(rest[start:], ">")
+		if end < 0 {
+			break
+		}
+		if id := strings.TrimSpace(rest[start+1 : start+end]); id != "" {
+			ret = append(ret, id)
+		}
+		rest = rest[start+end+1:]
+	}
+	if ret == nil && strings.TrimSpace(v) != "" {
+		// 不规范的客户端没有尖括号，按空白拆分
+		ret = strings.Fields(v)
+	}
+	return ret
+}
+
+// ParseListId 返回 List-Id: 描述 <list.example.com> 中尖括号内的列表标识
+func ParseListId(v string) string {
+	if start := strings.LastIndex(v, "<"); start >= 0 {
+		if end := strings.Index(v[start:], ">"); end > 0 {
+			v = v[start+1 : start+end]
+		}
+	}
+	return strings.TrimSpace(v)
+}
+
+// EncodeHeader 把邮件头序列化为原始文本，保留原始的顺序与折行，用于保存到数据库
+func EncodeHeader(h message.Header) string {
+	if h.Len() == 0 {
+		return ""
+	}
+	var b bytes.Buffer
+	if err := textproto.WriteHeader(&b, h.Header); err != nil {
+		return ""
+	}
+	return b.String()
+}
+
+// DecodeHeader 解析数据库中保存的原始邮件头
+func DecodeHeader(s string) message.Header {
+	if s == "" {
+		return message.Header{}
+	}
+	h, err := textproto.ReadHeader(bufio.NewReader(strings.NewReader(s)))
+	if err != nil {
+		return message.Header{}
+	}
+	return message.Header{Header: h}
+}
+
+// dropLong 超长的取值无法保存到数据库的索引字段，截断后也没有意义，直接丢弃
+func dropLong(v string, max int) string {
+	if len(v) > max {
+		return ""
+	}
+	return v
+}
+
+// setReceivedHeader 设置收到邮件的原始邮件头，并解析出会话与邮件列表相关的头
+func (e *Email) setReceivedHeader(h message.Header) {
+	e.ReceivedHeader = h
+	if ids := ParseMsgIDs(h.Get("In-Reply-To")); len(ids) > 0 {
+		e.InReplyTo = dropLong(ids[0], 255)
+	}
+	e.References = nil
+	for _, id := range ParseMsgIDs(h.Get("References")) {
+		if id = dropLong(id, 255); id != "" {
+			e.References = append(e.References, id)
+		}
+	}
+	e.ListId = dropLong(ParseListId(h.Get("List-Id")), 255)
+	e.Precedence = dropLong(strings.ToLower(strings.TrimSpace(h.Get("Precedence"))), 50)
+	e.AutoSubmitted = dropLong(strings.ToLower(strings.TrimSpace(h.Get("Auto-Submitted"))), 255)
+}
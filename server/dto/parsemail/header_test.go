@@ -0,0 +1,108 @@
+package parsemail
+
+import (
+	"bytes"
+	"database/sql"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/Jinnrry/pmail/models"
+)
+
+func TestParseMsgIDs(t *testing.T) {
+	tests := []struct {
+		value string
+		want  []string
+	}{
+		{"<a@example.com>", []string{"a@example.com"}},
+		{"<a@example.com>\r\n <b@example.com> (comment)", []string{"a@example.com", "b@example.com"}},
+		{"a@example.com b@example.com", []string{"a@example.com", "b@example.com"}},
+		{"", nil},
+	}
+	for _, tt := range tests {
+		if got := ParseMsgIDs(tt.value); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("ParseMsgIDs(%q) = %q", tt.value, got)
+		}
+	}
+}
+
+func TestThreadHeaders(t *testing.T) {
+	raw := "From: bob@remote.net\r\n" +
+		"To: alice@example.com\r\n" +
+		"Subject: Re: hello\r\n" +
+		"Message-ID: <c@remote.net>\r\n" +
+		"In-Reply-To: <b@example.com>\r\n" +
+		"References: <a@remote.net>\r\n <b@example.com>\r\n" +
+		"List-Id: Dev list <dev.remote.net>\r\n" +
+		"Precedence: List\r\n" +
+		"Auto-Submitted: no\r\n" +
+		"X-Custom: custom value\r\n" +
+		"Content-Type: text/plain\r\n" +
+		"\r\n" +
+		"body\r\n"
+	email := NewEmailFromReader(nil, strings.NewReader(raw), len(raw))
+	if email.InReplyTo != "b@example.com" || !reflect.DeepEqual(email.References, []string{"a@remote.net", "b@example.com"}) {
+		t.Errorf("thread headers = %q %q", email.InReplyTo, email.References)
+	}
+	if email.ListId != "dev.remote.net" || email.Precedence != "list" || email.AutoSubmitted != "no" {
+		t.Errorf("list headers = %q %q %q", email.ListId, email.Precedence, email.AutoSubmitted)
+	}
+
+	// 入库后再读出，原始邮件头保持不变
+	stored := NewEmailFromModel(models.Email{
+		InReplyTo:  email.InReplyTo,
+		References: strings.Join(email.References, " "),
+		ListId:     email.ListId,
+		Headers:    EncodeHeader(email.ReceivedHeader),
+	})
+	if got := stored.ReceivedHeader.Get("X-Custom"); got != "custom value" {
+		t.Errorf("X-Custom = %q", got)
+	}
+	raws, _ := stored.ReceivedHeader.Raw("References")
+	if string(raws) != "References: <a@remote.net>\r\n <b@example.com>\r\n" {
+		t.Errorf("raw References = %q", raws)
+	}
+	if stored.InReplyTo != email.InReplyTo || !reflect.DeepEqual(stored.References, email.References) {
+		t.Errorf("stored thread headers = %q %q", stored.InReplyTo, stored.References)
+	}
+
+	built := string(stored.BuildBytes(nil, false))
+	if !strings.Contains(built, "In-Reply-To: <b@example.com>\r\n") || !strings.Contains(built, "References: <a@remote.net> <b@example.com>\r\n") {
+		t.Errorf("built email headers = %s", built)
+	}
+}
+
+func TestStoredRawMessage(t *testing.T) {
+	h := "From: bob@remote.net\r\n" +
+		"To: alice@example.com\r\n" +
+		"Subject: hello\r\n" +
+		"X-Custom: custom value\r\n" +
+		"MIME-Version: 1.0\r\n" +
+		"Content-Type: text/plain\r\n" +
+		"\r\n"
+	stored := NewEmailFromModel(models.Email{
+		Subject:     "hello",
+		FromAddress: "bob@remote.net",
+		Text:        sql.NullString{String: "stored body", Valid: true},
+		Headers:     h,
+	})
+	raw := stored.StoredRawMessage(nil)
+	email := NewEmailFromReader(nil, bytes.NewReader(raw), len(raw))
+	if got := email.ReceivedHeader.Get("X-Custom"); got != "custom value" {
+		t.Errorf("X-Custom = %q", got)
+	}
+	if string(email.Text) != "stored body" {
+		t.Errorf("text = %q", email.Text)
+	}
+	headers, _ := SplitRawMessage(raw)
+	if ct := GetRawHeader(headers, "Content-Type"); !strings.HasPrefix(ct, "multipart/") {
+		t.Errorf("Content-Type = %q", ct)
+	}
+
+	// 没有保存原始邮件头时返回重新构建的邮件
+	stored.ReceivedHeader = DecodeHeader("")
+	if !bytes.Contains(stored.StoredRawMessage(nil), []byte("Subject: hello")) {
+		t.Error("built email without stored headers")
+	}
+}
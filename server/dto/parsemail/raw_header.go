@@ -0,0 +1,114 @@
+package parsemail
+
+import (
+	"bytes"
+	"strings"
+
+	"github.com/Jinnrry/pmail/utils/context"
+)
+
+// RawHeader 原始邮件中的一个头，Value 保留折行，不包含结尾的换行
+type RawHeader struct {
+	Name  string
+	Value string
+}
+
+// SplitRawMessage 把原始邮件拆分为头列表与正文，用于在不重新构建邮件的情况下修改邮件头
+func SplitRawMessage(raw []byte) ([]*RawHeader, []byte) {
+	var headers []*RawHeader
+	rest := raw
+	for len(rest) > 0 {
+		idx := bytes.IndexByte(rest, '\n')
+		var line []byte
+		if idx < 0 {
+			line, rest = rest, nil
+		} else {
+			line, rest = rest[:idx], rest[idx+1:]
+		}
+		line = bytes.TrimSuffix(line, []byte("\r"))
+		if len(line) == 0 {
+			return headers, rest
+		}
+		if (line[0] == ' ' || line[0] == '\t') && len(headers) > 0 {
+			last := headers[len(headers)-1]
+			last.Value += "\r\n" + string(line)
+			continue
+		}
+		name, value, ok := strings.Cut(string(line), ":")
+		if !ok {
+			// 不是合法的头，剩余部分全部视为正文
+			return headers, append(line, rest...)
+		}
+		headers = append(headers, &RawHeader{Name: name, Value: value})
+	}
+	return headers, nil
+}
+
+// JoinRawMessage 把头与正文重新拼成原始邮件
+func JoinRawMessage(headers []*RawHeader, body []byte) []byte {
+	var b bytes.Buffer
+	for _, h := range headers {
+		b.WriteString(h.Name)
+		b.WriteString(":")
+		b.WriteString(h.Value)
+		b.WriteString("\r\n")
+	}
+	b.WriteString("\r\n")
+	b.Write(body)
+	return b.Bytes()
+}
+
+// GetRawHeader 返回第一个同名头的值，去掉首尾空白
+func GetRawHeader(headers []*RawHeader, name string) string {
+	for _, h := range headers {
+		if strings.EqualFold(h.Name, name) {
+			return strings.TrimSpace(h.Value)
+		}
+	}
+	return ""
+}
+
+// DelRawHeader 删除全部同名头
+func DelRawHeader(headers []*RawHeader, name string) []*RawHeader {
+	var ret []*RawHeader
+	for _, h := range headers {
+		if !strings.EqualFold(h.Name, name) {
+			ret = append(ret, h)
+		}
+	}
+	return ret
+}
+
+// SetRawHeader 删除同名头后在末尾添加
+func SetRawHeader(headers []*RawHeader, name, value string) []*RawHeader {
+	return append(DelRawHeader(headers, name), &RawHeader{Name: name, Value: " " + value})
+}
+
+// StoredRawMessage 已经入库的邮件没有保存原始邮件，用入库时保存的原始邮件头与重新构建的正文拼成原始邮件。
+// MIME相关的头来自重新构建的邮件，与正文保持一致。没有保存原始邮件头时返回重新构建的邮件
+func (e *Email) StoredRawMessage(ctx *context.Context) []byte {
+	built := e.BuildBytes(ctx, false)
+	stored := EncodeHeader(e.ReceivedHeader)
+	if stored == "" {
+		return built
+	}
+	builtHeaders, body := SplitRawMessage(built)
+	storedHeaders, _ := SplitRawMessage([]byte(stored))
+	var headers []*RawHeader
+	for _, h := range storedHeaders {
+		if !isMIMEHeader(h.Name) {
+			headers = append(headers, h)
+		}
+	}
+	for _, h := range builtHeaders {
+		if isMIMEHeader(h.Name) {
+			headers = append(headers, h)
+		}
+	}
+	return JoinRawMessage(headers, body)
+}
+
+func isMIMEHeader(name string) bool {
+	name = strings.ToLower(strings.TrimSpace(name))
+	return name == "mime-version" || strings.HasPrefix(name, "content-")
+}
@@ -4,9 +4,18 @@ import "github.com/Jinnrry/pmail/models"
 
 type EmailResponseData struct {
 	models.Email `xorm:"extends"`
-	IsRead       int8 `json:"is_read"`
-	SerialNumber int  `json:"serial_number"`
-	UeId         int  `json:"ue_id"`
+	IsRead       int8   `json:"is_read"`
+	Flagged      int8   `json:"flagged"`
+	Answered     int8   `json:"answered"`
+	Draft        int8   `json:"draft"`
+	Deleted      int8   `json:"deleted"`
+	Keywords     string `json:"keywords"`
+	SerialNumber int    `json:"serial_number"`
+	UeId         int    `json:"ue_id"`
+	UeStatus     int8   `json:"ue_status"`
+	GroupId      int    `json:"group_id"`
+	ThreadCount  int    `json:"thread_count"`
+	ModSeq       int64  `json:"mod_seq"`
 }
 
 type UserEmailUIDData struct {
@@ -7,14 +7,24 @@ import (
 
 type RuleType int
 
-// 1已读，2转发，3删除
+// 1已读，2转发，3删除，4移动，5添加标志，6模板回复，7Webhook
 var (
 	READ    RuleType = 1
 	FORWARD RuleType = 2
 	DELETE  RuleType = 3
 	MOVE    RuleType = 4
+	FLAG    RuleType = 5
+	REPLY   RuleType = 6
+	WEBHOOK RuleType = 7
 )
 
+// RuleAction 规则的一个执行动作。FORWARD的参数可以是逗号分隔的多个地址，
+// FLAG的参数是空格分隔的IMAP标志或关键字，REPLY的参数是回复模板id，WEBHOOK的参数是URL
+type RuleAction struct {
+	Action RuleType `json:"action"`
+	Params string   `json:"params"`
+}
+
 type Rule struct {
 	Id     int      `json:"id"`
 	UserId int      `json:"user_id"`
@@ -23,12 +33,35 @@ type Rule struct {
 	Action RuleType `json:"action"`
 	Params string   `json:"params"`
 	Sort   int      `json:"sort"`
+	// Actions 多个执行动作，不为空时忽略Action与Params
+	Actions []*RuleAction `json:"actions"`
+	// Stop 匹配后不再执行优先级更低的规则
+	Stop bool `json:"stop"`
 }
 
+// GetActions 返回需要执行的全部动作，兼容只有一个动作的旧规则
+func (p *Rule) GetActions() []*RuleAction {
+	if len(p.Actions) > 0 {
+		return p.Actions
+	}
+	if p.Action > 0 {
+		return []*RuleAction{{Action: p.Action, Params: p.Params}}
+	}
+	return nil
+}
+
+// Value 一个匹配条件。Op不为空时表示条件组，由Children组成
 type Value struct {
 	Field string `json:"field"`
 	Type  string `json:"type"`
 	Rule  string `json:"rule"`
+	// Header Field为Header时匹配的邮件头名称
+	Header string `json:"header,omitempty"`
+	// IgnoreCase 忽略大小写
+	IgnoreCase bool `json:"ignore_case,omitempty"`
+	// Op 条件组类型，all全部满足，any满足任意一个，not全部满足时取反
+	Op       string   `json:"op,omitempty"`
+	Children []*Value `json:"children,omitempty"`
 }
 
 func (p *Rule) Decode(data *models.Rule) *Rule {
@@ -39,6 +72,10 @@ func (p *Rule) Decode(data *models.Rule) *Rule {
 	p.Sort = data.Sort
 	p.Params = data.Params
 	p.UserId = data.UserId
+	if data.Actions != "" {
+		json.Unmarshal([]byte(data.Actions), &p.Actions)
+	}
+	p.Stop = data.Stop == 1
 	return p
 }
 
@@ -52,5 +89,12 @@ func (p *Rule) Encode() *models.Rule {
 		Sort:   p.Sort,
 		Params: p.Params,
 	}
+	if len(p.Actions) > 0 {
+		actions, _ := json.Marshal(p.Actions)
+		ret.Actions = string(actions)
+	}
+	if p.Stop {
+		ret.Stop = 1
+	}
 	return ret
 }
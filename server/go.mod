@@ -60,3 +60,5 @@ require (
 	modernc.org/mathutil v1.7.1 // indirect
 	modernc.org/memory v1.10.0 // indirect
 )
+
+replace github.com/emersion/go-imap/v2 => ./third_party/go-imap
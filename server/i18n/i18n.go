@@ -16,6 +16,8 @@ var (
 		"invalid_email_address": "无效的邮箱地址！",
 		"deleted":               "已删除",
 		"junk":                  "广告箱",
+		"virus_found":           "邮件中包含病毒，已拒绝发送",
+		"virus_scan_fail":       "病毒扫描失败，请稍后重试",
 	}
 	en = map[string]string{
 		"all_email":             "All Email",
@@ -32,6 +34,8 @@ var (
 		"invalid_email_address": "Invalid e-mail address!",
 		"deleted":               "Deleted",
 		"junk":                  "Junk",
+		"virus_found":           "The message contains a virus and was not sent",
+		"virus_scan_fail":       "Virus scan failed, please try again later",
 	}
 )
 
@@ -0,0 +1,32 @@
+package cron_server
+
+import (
+	"time"
+
+	"github.com/Jinnrry/pmail/services/quarantine"
+	"github.com/Jinnrry/pmail/utils/context"
+	"github.com/Jinnrry/pmail/utils/id"
+)
+
+// digestHour 每天发送隔离邮件摘要的时间
+const digestHour = 8
+
+// 每天定时发送隔离邮件摘要，并清理过期的隔离邮件
+func quarantineLoop() {
+	for {
+		now := time.Now()
+		next := time.Date(now.Year(), now.Month(), now.Day(), digestHour, 0, 0, 0, now.Location())
+		if !next.After(now) {
+			next = next.AddDate(0, 0, 1)
+		}
+		time.Sleep(next.Sub(now))
+
+		ctx := &context.Context{}
+		ctx.SetValue(context.LogID, id.GenLogID())
+		if quarantine.Enabled() {
+			quarantine.Digest(ctx)
+		}
+		// 关闭隔离区后仍然清理已经隔离的邮件
+		quarantine.Expire(ctx)
+	}
+}
@@ -31,6 +31,8 @@ func Start() {
 		go sslCheck()
 	}
 
+	go quarantineLoop()
+
 }
 
 // 每天检查一遍SSL证书是否更新，更新就重启
@@ -40,20 +40,60 @@ func router(mux *http.ServeMux) {
 	mux.HandleFunc("/api/email/list", contextIterceptor(email.EmailList))
 	mux.HandleFunc("/api/email/del", contextIterceptor(email.EmailDelete))
 	mux.HandleFunc("/api/email/read", contextIterceptor(email.MarkRead))
+	mux.HandleFunc("/api/email/star", contextIterceptor(email.Star))
 	mux.HandleFunc("/api/email/detail", contextIterceptor(email.EmailDetail))
+	mux.HandleFunc("/api/email/thread", contextIterceptor(email.EmailThread))
 	mux.HandleFunc("/api/email/move", contextIterceptor(email.Move))
 	mux.HandleFunc("/api/email/send", contextIterceptor(email.Send))
 	mux.HandleFunc("/api/settings/modify_password", contextIterceptor(controllers.ModifyPassword))
+	mux.HandleFunc("/api/settings/subaddress", contextIterceptor(controllers.Subaddress))
+	mux.HandleFunc("/api/settings/vacation", contextIterceptor(controllers.Vacation))
 	mux.HandleFunc("/api/rule/get", contextIterceptor(controllers.GetRule))
 	mux.HandleFunc("/api/rule/add", contextIterceptor(controllers.UpsertRule))
 	mux.HandleFunc("/api/rule/update", contextIterceptor(controllers.UpsertRule))
 	mux.HandleFunc("/api/rule/del", contextIterceptor(controllers.DelRule))
+	mux.HandleFunc("/api/rule/test", contextIterceptor(controllers.TestRule))
+	mux.HandleFunc("/api/rule/apply", contextIterceptor(controllers.ApplyRule))
+	mux.HandleFunc("/api/rule/apply/status", contextIterceptor(controllers.ApplyRuleStatus))
+	mux.HandleFunc("/api/rule/template/list", contextIterceptor(controllers.ReplyTemplates))
+	mux.HandleFunc("/api/rule/template/save", contextIterceptor(controllers.SaveReplyTemplate))
+	mux.HandleFunc("/api/rule/template/del", contextIterceptor(controllers.DelReplyTemplate))
+	mux.HandleFunc("/api/sieve/list", contextIterceptor(controllers.SieveScripts))
+	mux.HandleFunc("/api/sieve/save", contextIterceptor(controllers.SaveSieveScript))
+	mux.HandleFunc("/api/sieve/active", contextIterceptor(controllers.ActiveSieveScript))
+	mux.HandleFunc("/api/sieve/del", contextIterceptor(controllers.DelSieveScript))
+	mux.HandleFunc("/api/sieve/convert", contextIterceptor(controllers.ConvertRulesToSieve))
 	mux.HandleFunc("/attachments/", contextIterceptor(controllers.GetAttachments))
 	mux.HandleFunc("/attachments/download/", contextIterceptor(controllers.Download))
 	mux.HandleFunc("/api/user/create", contextIterceptor(controllers.CreateUser))
 	mux.HandleFunc("/api/user/edit", contextIterceptor(controllers.EditUser))
 	mux.HandleFunc("/api/user/info", contextIterceptor(controllers.Info))
 	mux.HandleFunc("/api/user/list", contextIterceptor(controllers.UserList))
+	mux.HandleFunc("/api/alias/list", contextIterceptor(controllers.AliasList))
+	mux.HandleFunc("/api/alias/add", contextIterceptor(controllers.UpsertAlias))
+	mux.HandleFunc("/api/alias/update", contextIterceptor(controllers.UpsertAlias))
+	mux.HandleFunc("/api/alias/del", contextIterceptor(controllers.DelAlias))
+	mux.HandleFunc("/api/mailing_list/list", contextIterceptor(controllers.MailingLists))
+	mux.HandleFunc("/api/mailing_list/add", contextIterceptor(controllers.UpsertMailingList))
+	mux.HandleFunc("/api/mailing_list/update", contextIterceptor(controllers.UpsertMailingList))
+	mux.HandleFunc("/api/mailing_list/del", contextIterceptor(controllers.DelMailingList))
+	mux.HandleFunc("/api/mailing_list/members", contextIterceptor(controllers.MailingListMembers))
+	mux.HandleFunc("/api/mailing_list/member/add", contextIterceptor(controllers.AddMailingListMember))
+	mux.HandleFunc("/api/mailing_list/member/del", contextIterceptor(controllers.DelMailingListMember))
+	mux.HandleFunc("/api/mailing_list/pending", contextIterceptor(controllers.MailingListPending))
+	mux.HandleFunc("/api/mailing_list/moderate", contextIterceptor(controllers.ModerateMailingList))
+	mux.HandleFunc("/api/quarantine/list", contextIterceptor(controllers.QuarantineList))
+	mux.HandleFunc("/api/quarantine/release", contextIterceptor(controllers.ReleaseQuarantine))
+	mux.HandleFunc("/api/quarantine/link/", contextIterceptor(controllers.QuarantineLink))
+	mux.HandleFunc("/api/quota/info", contextIterceptor(controllers.QuotaInfo))
+	mux.HandleFunc("/api/quota/list", contextIterceptor(controllers.QuotaList))
+	mux.HandleFunc("/api/quota/user/set", contextIterceptor(controllers.SetUserQuota))
+	mux.HandleFunc("/api/quota/domain/set", contextIterceptor(controllers.SetDomainQuota))
+	mux.HandleFunc("/api/shared/list", contextIterceptor(controllers.SharedList))
+	mux.HandleFunc("/api/shared/create", contextIterceptor(controllers.CreateShared))
+	mux.HandleFunc("/api/shared/acl/list", contextIterceptor(controllers.ACLList))
+	mux.HandleFunc("/api/shared/acl/set", contextIterceptor(controllers.SetACL))
+	mux.HandleFunc("/api/shared/acl/del", contextIterceptor(controllers.DelACL))
 	mux.HandleFunc("/api/plugin/settings/", contextIterceptor(controllers.SettingsHtml))
 	mux.HandleFunc("/api/plugin/list", contextIterceptor(controllers.GetPluginList))
 }
@@ -0,0 +1,266 @@
+package http_server
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/Jinnrry/pmail/consts"
+	"github.com/Jinnrry/pmail/db"
+	"github.com/Jinnrry/pmail/dto"
+	"github.com/Jinnrry/pmail/models"
+	"github.com/Jinnrry/pmail/services/acl"
+	"github.com/Jinnrry/pmail/services/search"
+	"github.com/Jinnrry/pmail/utils/context"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cast"
+	"xorm.io/builder"
+)
+
+// mailboxRights 通过 Mailbox 头访问其他邮箱时每个接口需要的权限，其他接口只能访问自己的邮箱。
+// 能定位到文件夹的接口检查文件夹上的权限，其他接口检查整个邮箱的权限
+var mailboxRights = map[string]string{
+	"/api/group":        acl.Lookup,
+	"/api/group/list":   acl.Lookup,
+	"/api/group/add":    acl.Create,
+	"/api/group/del":    acl.DeleteMailbox,
+	"/api/email/list":   acl.Read,
+	"/api/email/detail": acl.Read,
+	"/api/email/thread": acl.Read,
+	"/api/email/read":   acl.Seen,
+	"/api/email/star":   acl.Write,
+	"/api/email/move":   acl.DeleteMessages + acl.Expunge,
+	"/api/email/del":    acl.DeleteMessages + acl.Expunge,
+	"/api/email/send":   acl.Post,
+	"/api/quota/info":   acl.Lookup,
+}
+
+// folderCheck 请求需要在某个文件夹上具有的权限，文件夹为空时表示整个邮箱
+type folderCheck struct {
+	folder string
+	rights string
+}
+
+// switchMailbox 请求头 Mailbox 为共享邮箱或其他用户的账号时，检查权限后以该邮箱的身份处理请求
+func switchMailbox(ctx *context.Context, r *http.Request) error {
+	account, _, _ := strings.Cut(r.Header.Get("Mailbox"), "@")
+	if account == "" || strings.EqualFold(account, ctx.UserAccount) {
+		return nil
+	}
+
+	required, ok := mailboxRights[r.URL.Path]
+	if !ok && strings.HasPrefix(r.URL.Path, "/attachments/") {
+		required, ok = acl.Read, true
+	}
+	if !ok {
+		return errors.New("this api does not support shared mailboxes")
+	}
+
+	owner := acl.Owner(ctx, account, true)
+	if owner == nil {
+		owner = acl.Owner(ctx, account, false)
+	}
+	if owner == nil {
+		return errors.New("no access to mailbox " + account)
+	}
+
+	checks, err := requestFolders(ctx, owner, r, required)
+	if err != nil {
+		return err
+	}
+	for _, check := range checks {
+		if !acl.Has(acl.Rights(ctx, owner, check.folder, ctx.UserID), check.rights) {
+			return errors.New("no access to mailbox " + account)
+		}
+	}
+
+	ctx.SetValue(context.OriginUserID, ctx.UserID)
+	ctx.UserID = owner.ID
+	ctx.UserAccount = owner.Account
+	ctx.UserName = owner.Name
+	ctx.IsAdmin = false
+	return nil
+}
+
+// requestFolders 解析请求涉及的文件夹，请求体读取后会重新放回，供后面的接口使用
+func requestFolders(ctx *context.Context, owner *models.User, r *http.Request, required string) ([]*folderCheck, error) {
+	if strings.HasPrefix(r.URL.Path, "/attachments/") {
+		// /attachments/{emailId}/{cid} 或 /attachments/download/{emailId}/{index}
+		parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/attachments/"), "/")
+		if len(parts) > 0 && parts[0] == "download" {
+			parts = parts[1:]
+		}
+		if len(parts) == 0 {
+			return nil, errors.New("invalid attachment path")
+		}
+		return emailFolders(ctx, owner, []int{cast.ToInt(parts[0])}, required)
+	}
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		return nil, err
+	}
+	r.Body = io.NopCloser(bytes.NewReader(body))
+
+	var req struct {
+		Tag     string `json:"tag"`
+		Keyword string `json:"keyword"`
+		ID      int    `json:"id"`
+		IDs     []int  `json:"ids"`
+		GroupId int    `json:"group_id"`
+	}
+	_ = json.Unmarshal(body, &req)
+
+	switch r.URL.Path {
+	case "/api/email/list":
+		tag := dto.SearchTag{Type: -1, Status: -1, GroupId: -1}
+		_ = json.Unmarshal([]byte(req.Tag), &tag)
+		checks := []*folderCheck{{tagFolder(ctx, owner, tag), required}}
+		// 搜索语句中的 in: 可以查询其他文件夹
+		if query, err := search.Parse(req.Keyword); err == nil {
+			for _, term := range query.Terms {
+				if term.Key != search.KeyIn || term.Negate {
+					continue
+				}
+				if lower := strings.ToLower(term.Value); lower == "anywhere" || lower == "all" {
+					checks = append(checks, allFolders(ctx, owner, required)...)
+				} else {
+					checks = append(checks, &folderCheck{searchFolder(ctx, owner, term.Value), required})
+				}
+			}
+		}
+		return checks, nil
+	case "/api/email/thread":
+		// 会话中的邮件可能在任意文件夹
+		return allFolders(ctx, owner, required), nil
+	case "/api/email/detail":
+		return emailFolders(ctx, owner, []int{req.ID}, required)
+	case "/api/email/read", "/api/email/star", "/api/email/del":
+		return emailFolders(ctx, owner, req.IDs, required)
+	case "/api/email/move":
+		checks, err := emailFolders(ctx, owner, req.IDs, required)
+		if err != nil {
+			return nil, err
+		}
+		dest := models.GroupCodeToName[req.GroupId]
+		if dest == "" {
+			dest = groupFolder(ctx, owner, req.GroupId)
+		}
+		return append(checks, &folderCheck{dest, acl.Insert}), nil
+	}
+	return []*folderCheck{{"", required}}, nil
+}
+
+// allFolders 返回所有文件夹中邮件的请求除了整个邮箱的权限，还需要在每个单独设置了授权的文件夹上有权限，
+// 否则文件夹上取消的授权可以通过这些请求绕过
+func allFolders(ctx *context.Context, owner *models.User, required string) []*folderCheck {
+	checks := []*folderCheck{{"", required}}
+	for _, folder := range acl.Overrides(ctx, owner.ID, ctx.UserID) {
+		checks = append(checks, &folderCheck{folder, required})
+	}
+	return checks
+}
+
+// emailFolders 邮件在所有者邮箱中所在的文件夹
+func emailFolders(ctx *context.Context, owner *models.User, emailIds []int, required string) ([]*folderCheck, error) {
+	var rows []*models.UserEmail
+	err := db.Instance.Table(&models.UserEmail{}).Cols("group_id", "status").
+		Where(builder.Eq{"user_id": owner.ID, "email_id": emailIds}).Find(&rows)
+	if err != nil {
+		log.WithContext(ctx).Errorf("SQL Error:%v", err)
+		return nil, errors.New("mailbox lookup failed")
+	}
+	if len(rows) == 0 {
+		return nil, errors.New("email not found")
+	}
+	var ret []*folderCheck
+	seen := map[string]bool{}
+	for _, row := range rows {
+		folder := rowFolder(ctx, owner, row)
+		if !seen[folder] {
+			seen[folder] = true
+			ret = append(ret, &folderCheck{folder, required})
+		}
+	}
+	return ret, nil
+}
+
+// rowFolder user_email 记录对应的 IMAP 文件夹名称，与 IMAP 中各个文件夹的筛选条件一致
+func rowFolder(ctx *context.Context, owner *models.User, row *models.UserEmail) string {
+	if name, ok := models.GroupCodeToName[row.GroupId]; ok {
+		return name
+	}
+	if row.GroupId > 0 {
+		return groupFolder(ctx, owner, row.GroupId)
+	}
+	switch row.Status {
+	case consts.EmailStatusSent, consts.EmailStatusFail:
+		return "Sent Messages"
+	case consts.EmailStatusDel:
+		return "Deleted Messages"
+	case consts.EmailStatusDrafts:
+		return "Drafts"
+	case consts.EmailStatusJunk:
+		return "Junk"
+	}
+	return "INBOX"
+}
+
+// tagFolder 网页端文件夹标签对应的 IMAP 文件夹名称
+func tagFolder(ctx *context.Context, owner *models.User, tag dto.SearchTag) string {
+	if tag.GroupId > 0 {
+		if name, ok := models.GroupCodeToName[tag.GroupId]; ok {
+			return name
+		}
+		return groupFolder(ctx, owner, tag.GroupId)
+	}
+	switch tag.Status {
+	case consts.EmailStatusDel:
+		return "Deleted Messages"
+	case consts.EmailStatusDrafts:
+		return "Drafts"
+	case consts.EmailStatusJunk:
+		return "Junk"
+	}
+	if tag.Type == consts.EmailTypeSend {
+		return "Sent Messages"
+	}
+	return "INBOX"
+}
+
+// searchFolder 搜索语句 in: 指定的文件夹，找不到文件夹时检查整个邮箱的权限
+func searchFolder(ctx *context.Context, owner *models.User, value string) string {
+	lower := strings.ToLower(value)
+	if tag, ok := search.Folders[lower]; ok {
+		return tagFolder(ctx, owner, tag)
+	}
+	var group models.Group
+	has, err := db.Instance.Table("group").Where(builder.Eq{"user_id": owner.ID}.And(
+		builder.Or(builder.Expr("lower(name)=?", lower), builder.Expr("lower(full_path)=?", lower)))).Get(&group)
+	if err != nil {
+		log.WithContext(ctx).Errorf("SQL Error:%v", err)
+	}
+	if !has {
+		return ""
+	}
+	return groupFolder(ctx, owner, group.ID)
+}
+
+// groupFolder 自定义文件夹的完整路径，文件夹不存在时返回空，检查整个邮箱的权限
+func groupFolder(ctx *context.Context, owner *models.User, groupId int) string {
+	var group models.Group
+	has, err := db.Instance.Table("group").Where("id=? and user_id=?", groupId, owner.ID).Get(&group)
+	if err != nil {
+		log.WithContext(ctx).Errorf("SQL Error:%v", err)
+	}
+	if !has {
+		return ""
+	}
+	if group.FullPath != "" {
+		return group.FullPath
+	}
+	return group.Name
+}
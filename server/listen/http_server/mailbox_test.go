@@ -0,0 +1,56 @@
+package http_server
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/Jinnrry/pmail/db"
+	"github.com/Jinnrry/pmail/db/dbtest"
+	"github.com/Jinnrry/pmail/models"
+	"github.com/Jinnrry/pmail/services/acl"
+	"github.com/Jinnrry/pmail/utils/context"
+)
+
+func TestSwitchMailboxFolderOverride(t *testing.T) {
+	dbtest.Init(t)
+	support := &models.User{Account: "support", Name: "Support", Shared: 1}
+	alice := dbtest.User(t, "alice", "Alice")
+	if _, err := db.Instance.Insert(support); err != nil {
+		t.Fatal(err)
+	}
+	ctx := &context.Context{}
+	acl.Set(ctx, support.ID, "", alice.ID, "lr")
+
+	allowed := func(path, body string) bool {
+		r := httptest.NewRequest("POST", path, strings.NewReader(body))
+		r.Header.Set("Mailbox", "support")
+		return switchMailbox(&context.Context{UserID: alice.ID, UserAccount: alice.Account}, r) == nil
+	}
+	requests := [][2]string{
+		{"/api/email/thread", `{"id":1}`},
+		{"/api/email/list", `{"keyword":"in:anywhere invoice"}`},
+		{"/api/email/list", `{"keyword":"in:all invoice"}`},
+	}
+	for _, req := range requests {
+		if !allowed(req[0], req[1]) {
+			t.Errorf("%s %s denied", req[0], req[1])
+		}
+	}
+
+	// 文件夹上取消了读权限时，不能通过会话或者 in:anywhere 读取该文件夹
+	acl.Set(ctx, support.ID, "Archive", alice.ID, "l")
+	for _, req := range requests {
+		if allowed(req[0], req[1]) {
+			t.Errorf("%s %s allowed with Archive override", req[0], req[1])
+		}
+	}
+	if !allowed("/api/email/list", `{"keyword":"invoice"}`) {
+		t.Error("INBOX list denied")
+	}
+
+	acl.Set(ctx, support.ID, "Archive", alice.ID, "lr")
+	if !allowed("/api/email/thread", `{"id":1}`) {
+		t.Error("thread denied with readable override")
+	}
+}
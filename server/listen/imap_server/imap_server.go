@@ -10,24 +10,58 @@ import (
 )
 
 var instanceTLS *imapserver.Server
+var instance *imapserver.Server
 
 func Stop() {
 	if instanceTLS != nil {
 		instanceTLS.Close()
 		instanceTLS = nil
 	}
+	if instance != nil {
+		instance.Close()
+		instance = nil
+	}
+}
+
+// listenAddress 配置为空时使用默认地址，配置为off时返回空表示不启用，默认地址为空的端口需要配置后才会启用
+func listenAddress(address string, defaultAddress string) string {
+	switch address {
+	case "":
+		return defaultAddress
+	case "off":
+		return ""
+	}
+	return address
+}
+
+// Start 启动不加密端口监听，只有配置了imapAddress才启用。客户端需要先执行STARTTLS才能登录，开启imapPlaintext后允许直接登录
+func Start() {
+	address := listenAddress(config.Instance.ImapAddress, "")
+	if address == "" {
+		return
+	}
+	instance = newIMAPServer(config.Instance.ImapPlaintext)
+	log.Infof("IMAP Server Start On %s", address)
+	if err := instance.ListenAndServe(address); err != nil {
+		panic(err)
+	}
 }
 
-// StarTLS 启动TLS端口监听，不加密的代码就懒得写了
+// StarTLS 启动TLS端口监听
 func StarTLS() {
-	instanceTLS = newIMAPServer()
-	log.Infof("IMAP With TLS Server Start On Port :993")
-	if err := instanceTLS.ListenAndServeTLS(":993"); err != nil {
+	address := listenAddress(config.Instance.ImapTLSAddress, ":993")
+	if address == "" {
+		return
+	}
+	instanceTLS = newIMAPServer(false)
+	log.Infof("IMAP With TLS Server Start On %s", address)
+	if err := instanceTLS.ListenAndServeTLS(address); err != nil {
 		panic(err)
 	}
 }
 
-func newIMAPServer() *imapserver.Server {
+// newIMAPServer insecureAuth 为true时允许未加密的连接登录
+func newIMAPServer(insecureAuth bool) *imapserver.Server {
 	crt, err := tls.LoadX509KeyPair(config.Instance.SSLPublicKeyPath, config.Instance.SSLPrivateKeyPath)
 	if err != nil {
 		panic(err)
@@ -43,11 +77,24 @@ func newIMAPServer() *imapserver.Server {
 			return memServer.NewSession(), nil, nil
 		},
 		Caps: imap.CapSet{
-			imap.CapIMAP4rev1: {},
-			imap.CapIdle:      {},
+			imap.CapIMAP4rev1:            {},
+			imap.CapIdle:                 {},
+			imap.CapThreadReferences:     {},
+			imap.CapThreadOrderedSubject: {},
+			imap.CapCondStore:            {},
+			imap.CapQResync:              {},
+			imap.CapUIDPlus:              {},
+			imap.CapListExtended:         {},
+			imap.CapListStatus:           {},
+			imap.CapSpecialUse:           {},
+			imap.CapCreateSpecialUse:     {},
+			imap.CapQuota:                {},
+			"QUOTA=RES-STORAGE":          {},
+			imap.CapACL:                  {},
+			"RIGHTS=kxte":                {},
 		},
 		TLSConfig:    tlsConfig,
-		InsecureAuth: false,
+		InsecureAuth: insecureAuth,
 	}
 
 	if config.Instance.LogLevel == "debug" {
@@ -4,8 +4,13 @@ import (
 	"bufio"
 	"crypto/tls"
 	"github.com/Jinnrry/pmail/config"
+	"github.com/Jinnrry/pmail/consts"
 	"github.com/Jinnrry/pmail/db"
 	"github.com/Jinnrry/pmail/models"
+	"github.com/Jinnrry/pmail/services/acl"
+	"github.com/Jinnrry/pmail/services/del_email"
+	"github.com/Jinnrry/pmail/services/modseq"
+	"github.com/Jinnrry/pmail/services/quota"
 	"github.com/Jinnrry/pmail/utils/array"
 	pcontext "github.com/Jinnrry/pmail/utils/context"
 	"github.com/Jinnrry/pmail/utils/password"
@@ -13,11 +18,14 @@ import (
 	"github.com/emersion/go-imap/v2/imapclient"
 	"github.com/emersion/go-message/charset"
 	"mime"
+	"net"
 	"os"
 	"path/filepath"
+	"reflect"
 	"strings"
 	"testing"
 	"time"
+	"xorm.io/builder"
 )
 
 var clientUnLogin *imapclient.Client
@@ -44,7 +52,7 @@ func TestMain(m *testing.M) {
 		panic(err)
 	}
 	imapTestAddr = ln.Addr().String()
-	instanceTLS = newIMAPServer()
+	instanceTLS = newIMAPServer(false)
 	go func() {
 		if err := instanceTLS.Serve(ln); err != nil {
 			panic(err)
@@ -242,6 +250,68 @@ func TestList(t *testing.T) {
 
 }
 
+func TestSpecialUse(t *testing.T) {
+	res, err := clientLogin.Capability().Wait()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range []imap.Cap{imap.CapSpecialUse, imap.CapCreateSpecialUse, imap.CapListExtended, imap.CapListStatus} {
+		if !res.Has(c) {
+			t.Errorf("missing capability %s", c)
+		}
+	}
+
+	if err = clientLogin.Create("归档", &imap.CreateOptions{SpecialUse: []imap.MailboxAttr{imap.MailboxAttrArchive}}).Wait(); err != nil {
+		t.Fatal(err)
+	}
+	if err = clientLogin.Create("发件箱", &imap.CreateOptions{SpecialUse: []imap.MailboxAttr{imap.MailboxAttrSent}}).Wait(); err == nil {
+		t.Error("CREATE with \\Sent should fail")
+	}
+
+	list, err := clientLogin.List("", "*", &imap.ListOptions{
+		SelectSpecialUse: true,
+		ReturnStatus:     &imap.StatusOptions{NumMessages: true, NumUnseen: true},
+	}).Collect()
+	if err != nil {
+		t.Fatal(err)
+	}
+	uses := map[imap.MailboxAttr]*imap.ListData{}
+	for _, data := range list {
+		for _, attr := range data.Attrs {
+			if isSpecialUse(attr) {
+				uses[attr] = data
+			}
+		}
+		if data.Status == nil || data.Status.NumMessages == nil {
+			t.Errorf("LIST-STATUS missing for %s", data.Mailbox)
+		}
+	}
+	for _, attr := range []imap.MailboxAttr{imap.MailboxAttrSent, imap.MailboxAttrDrafts, imap.MailboxAttrTrash, imap.MailboxAttrJunk, imap.MailboxAttrAll, imap.MailboxAttrArchive} {
+		if uses[attr] == nil {
+			t.Errorf("no mailbox with %s: %+v", attr, list)
+		}
+	}
+	if len(list) != len(uses) {
+		t.Errorf("SPECIAL-USE selection returned %d mailboxes", len(list))
+	}
+
+	all, err := clientLogin.Select("All Mail", nil).Wait()
+	if err != nil {
+		t.Fatal(err)
+	}
+	inbox, err := clientLogin.Status("INBOX", &imap.StatusOptions{NumMessages: true}).Wait()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if all.NumMessages < *inbox.NumMessages || all.NumMessages != *uses[imap.MailboxAttrAll].Status.NumMessages {
+		t.Errorf("All Mail has %d messages, INBOX %d", all.NumMessages, *inbox.NumMessages)
+	}
+	msgs, err := clientLogin.Fetch(imap.SeqSetNum(1), &imap.FetchOptions{UID: true}).Collect()
+	if err != nil || len(msgs) != 1 {
+		t.Errorf("fetch All Mail = %+v %v", msgs, err)
+	}
+}
+
 func TestDelete(t *testing.T) {
 
 	clientLogin.Create("一级菜单/二级菜单", nil).Wait()
@@ -265,8 +335,145 @@ func TestDelete(t *testing.T) {
 
 }
 
+func TestStartTLS(t *testing.T) {
+	serve := func(insecureAuth bool) string {
+		server := newIMAPServer(insecureAuth)
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		go server.Serve(ln)
+		t.Cleanup(func() { server.Close() })
+		return ln.Addr().String()
+	}
+	options := &imapclient.Options{TLSConfig: &tls.Config{InsecureSkipVerify: true}}
+
+	addr := serve(false)
+	client, err := imapclient.DialInsecure(addr, options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	caps := client.Caps()
+	if !caps.Has(imap.CapStartTLS) || !caps.Has(imap.CapLoginDisabled) {
+		t.Errorf("caps = %v", caps)
+	}
+	if err = client.Login("testCase", "testCase").Wait(); err == nil {
+		t.Error("Login without TLS")
+	}
+
+	tlsClient, err := imapclient.DialStartTLS(addr, options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tlsClient.Close()
+	if tlsClient.Caps().Has(imap.CapLoginDisabled) {
+		t.Errorf("caps after STARTTLS = %v", tlsClient.Caps())
+	}
+	if err = tlsClient.Login("testCase", "testCase").Wait(); err != nil {
+		t.Error(err)
+	}
+
+	// 明文模式直接登录
+	plainClient, err := imapclient.DialInsecure(serve(true), options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer plainClient.Close()
+	if err = plainClient.Login("testCase", "testCase").Wait(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestListenAddress(t *testing.T) {
+	if listenAddress("", ":993") != ":993" || listenAddress("off", ":993") != "" || listenAddress("127.0.0.1:1143", "") != "127.0.0.1:1143" {
+		t.Error("listenAddress error")
+	}
+	// 不加密端口没有默认地址，升级后不会自动启用
+	if listenAddress("", "") != "" {
+		t.Error("plain IMAP enabled without imapAddress")
+	}
+}
+
 func TestAppend(t *testing.T) {
+	msg := "From: Bob <bob@remote.net>\r\nTo: testCase@example.com\r\nSubject: append test\r\n" +
+		"Date: Mon, 02 Mar 2026 10:00:00 +0000\r\nMessage-ID: <append-test@remote.net>\r\n\r\nhello append\r\n"
+	internalDate := time.Date(2026, 3, 2, 10, 5, 0, 0, time.Local)
+
+	cmd := clientLogin.Append("Drafts", int64(len(msg)), &imap.AppendOptions{
+		Flags: []imap.Flag{imap.FlagSeen, imap.FlagFlagged, "$work"},
+		Time:  internalDate,
+	})
+	cmd.Write([]byte(msg))
+	cmd.Close()
+	res, err := cmd.Wait()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.UID == 0 || res.UIDValidity != uint32(models.Drafts) {
+		t.Errorf("APPENDUID = %+v", res)
+	}
+
+	var ue models.UserEmail
+	db.Instance.ID(int(res.UID)).Get(&ue)
+	if ue.Status != 4 || ue.GroupId != 0 || ue.IsRead != 1 || ue.Flagged != 1 || ue.Keywords != "$work" {
+		t.Errorf("user_email = %+v", ue)
+	}
+	var email models.Email
+	db.Instance.ID(ue.EmailID).Get(&email)
+	if email.Subject != "append test" || email.FromAddress != "bob@remote.net" || email.MsgID != "append-test@remote.net" ||
+		!email.CreateTime.Equal(internalDate) || !strings.Contains(email.Text.String, "hello append") {
+		t.Errorf("email = %+v", email)
+	}
 
+	cmd = clientLogin.Append("NotExists", int64(len(msg)), nil)
+	cmd.Write([]byte(msg))
+	cmd.Close()
+	if _, err = cmd.Wait(); err == nil || !strings.Contains(err.Error(), "TRYCREATE") {
+		t.Errorf("Append to missing mailbox: %v", err)
+	}
+}
+
+func TestQuota(t *testing.T) {
+	res, err := clientLogin.Capability().Wait()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !res.Has(imap.CapQuota) || !res.Has("QUOTA=RES-STORAGE") {
+		t.Errorf("missing QUOTA capability: %v", res)
+	}
+
+	var user models.User
+	db.Instance.Where("account=?", "testCase").Get(&user)
+	ctx := &pcontext.Context{UserID: user.ID}
+	usage := quota.Usage(ctx, user.ID)
+	// 配额留出的空间不足以存下新邮件，同时用量低于警告线，不会产生警告邮件
+	limit := usage + usage/2 + 2048
+	if err = quota.SetUser(ctx, user.ID, limit); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { quota.SetUser(ctx, user.ID, 0) })
+
+	data, err := clientLogin.GetQuotaRoot("INBOX").Wait()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(data) != 1 || data[0].Root != "" || data[0].Resources[imap.QuotaResourceStorage].Limit != limit/1024 ||
+		data[0].Resources[imap.QuotaResourceStorage].Usage != (usage+1023)/1024 {
+		t.Errorf("GETQUOTAROOT = %+v", data)
+	}
+	if _, err = clientLogin.GetQuota("example.com").Wait(); err == nil {
+		t.Error("GETQUOTA of a root without quota should fail")
+	}
+
+	msg := "From: Bob <bob@remote.net>\r\nTo: testCase@example.com\r\nSubject: quota test\r\n\r\n" +
+		strings.Repeat("x", int(limit-usage)) + "\r\n"
+	cmd := clientLogin.Append("INBOX", int64(len(msg)), nil)
+	cmd.Write([]byte(msg))
+	cmd.Close()
+	if _, err = cmd.Wait(); err == nil || !strings.Contains(err.Error(), "OVERQUOTA") {
+		t.Errorf("Append over quota: %v", err)
+	}
 }
 func TestSelect(t *testing.T) {
 	res, err := clientUnLogin.Select("INBOX", &imap.SelectOptions{}).Wait()
@@ -393,26 +600,267 @@ func TestStore(t *testing.T) {
 	t.Logf("%+v", res)
 
 }
+func TestStoreFlags(t *testing.T) {
+	if _, err := clientLogin.Select("INBOX", &imap.SelectOptions{}).Wait(); err != nil {
+		t.Fatal(err)
+	}
+
+	store := func(op imap.StoreFlagsOp, flags ...imap.Flag) models.UserEmail {
+		t.Helper()
+		_, err := clientLogin.Store(imap.UIDSetNum(3), &imap.StoreFlags{Op: op, Flags: flags}, nil).Collect()
+		if err != nil {
+			t.Fatal(err)
+		}
+		var ue models.UserEmail
+		db.Instance.ID(3).Get(&ue)
+		return ue
+	}
+
+	ue := store(imap.StoreFlagsAdd, imap.FlagSeen, imap.FlagFlagged, "$Forwarded")
+	if ue.IsRead != 1 || ue.Flagged != 1 || ue.Keywords != "$Forwarded" {
+		t.Errorf("add flags = %+v", ue)
+	}
+
+	ue = store(imap.StoreFlagsDel, imap.FlagSeen, "$forwarded")
+	if ue.IsRead != 0 || ue.Flagged != 1 || ue.Keywords != "" {
+		t.Errorf("remove flags = %+v", ue)
+	}
+
+	ue = store(imap.StoreFlagsSet, imap.FlagAnswered, imap.FlagDraft, "work")
+	if ue.IsRead != 0 || ue.Flagged != 0 || ue.Answered != 1 || ue.Draft != 1 || ue.Keywords != "work" {
+		t.Errorf("set flags = %+v", ue)
+	}
+
+	res, err := clientLogin.Fetch(imap.UIDSetNum(3), &imap.FetchOptions{Flags: true}).Collect()
+	if err != nil || len(res) != 1 || len(res[0].Flags) != 3 {
+		t.Errorf("fetch flags = %+v %v", res, err)
+	}
+
+	search, err := clientLogin.UIDSearch(&imap.SearchCriteria{Flag: []imap.Flag{imap.FlagAnswered, "work"}}, nil).Wait()
+	if err != nil || len(search.AllUIDs()) != 1 {
+		t.Errorf("search flags = %+v %v", search, err)
+	}
+
+	store(imap.StoreFlagsSet)
+}
+
 func TestClose(t *testing.T) {
 
 }
 func TestExpunge(t *testing.T) {
+	expunged := make(chan uint32, 10)
+	other, err := imapclient.DialTLS(imapTestAddr, &imapclient.Options{
+		TLSConfig: &tls.Config{InsecureSkipVerify: true},
+		UnilateralDataHandler: &imapclient.UnilateralDataHandler{
+			Expunge: func(seqNum uint32) {
+				expunged <- seqNum
+			},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer other.Close()
+	if err = other.Login("testCase", "testCase").Wait(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = other.Select("INBOX", nil).Wait(); err != nil {
+		t.Fatal(err)
+	}
 
 	clientLogin.Select("INBOX", &imap.SelectOptions{}).Wait()
 
-	res, err := clientLogin.UIDExpunge(imap.UIDSetNum(1, 2)).Collect()
+	// EXPUNGE 之前可以去掉 \Deleted 标志
+	store := func(op imap.StoreFlagsOp, uids ...imap.UID) {
+		t.Helper()
+		err := clientLogin.Store(imap.UIDSetNum(uids...), &imap.StoreFlags{Op: op, Flags: []imap.Flag{imap.FlagDeleted}, Silent: true}, nil).Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	store(imap.StoreFlagsAdd, 2)
+	store(imap.StoreFlagsDel, 2)
+	if _, err = clientLogin.Expunge().Collect(); err != nil {
+		t.Fatal(err)
+	}
+	if has, _ := db.Instance.Exist(&models.UserEmail{ID: 2}); !has {
+		t.Fatal("message without \\Deleted was expunged")
+	}
 
+	store(imap.StoreFlagsAdd, 2, 4)
+	if has, _ := db.Instance.Exist(&models.UserEmail{ID: 2}); !has {
+		t.Fatal("STORE \\Deleted removed the message before EXPUNGE")
+	}
+
+	// UID EXPUNGE 只删除指定的邮件，UID 1 不在收件箱中
+	res, err := clientLogin.UIDExpunge(imap.UIDSetNum(1, 2)).Collect()
 	if err != nil {
-		t.Errorf("%+v", err)
+		t.Fatal(err)
+	}
+	if len(res) != 1 || res[0] != 1 {
+		t.Errorf("UID EXPUNGE responses = %v", res)
 	}
-	t.Logf("%+v", res)
 	var ues []models.UserEmail
-	db.Instance.Table("user_email").Where("id=1 or id=2").Find(&ues)
-	if len(ues) > 0 {
+	db.Instance.Table("user_email").In("id", 1, 2, 4).Find(&ues)
+	if len(ues) != 2 {
 		t.Errorf("TestExpunge Error")
 	}
 
+	// 其他会话在下一个命令后收到 EXPUNGE
+	if err = other.Noop().Wait(); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case seqNum := <-expunged:
+		if seqNum != 1 {
+			t.Errorf("other session EXPUNGE = %d", seqNum)
+		}
+	default:
+		t.Error("other session didn't receive EXPUNGE")
+	}
+
+	// UNSELECT 不删除邮件
+	if err = clientLogin.Unselect().Wait(); err != nil {
+		t.Fatal(err)
+	}
+	if has, _ := db.Instance.Exist(&models.UserEmail{ID: 4}); !has {
+		t.Fatal("UNSELECT expunged the mailbox")
+	}
+	clientLogin.Select("INBOX", &imap.SelectOptions{}).Wait()
+	if _, err = clientLogin.Expunge().Collect(); err != nil {
+		t.Fatal(err)
+	}
+	if has, _ := db.Instance.Exist(&models.UserEmail{ID: 4}); has {
+		t.Error("EXPUNGE didn't remove the \\Deleted message")
+	}
+
+	// CLOSE 时删除 \Deleted 邮件
+	clientLogin.Select("Deleted Messages", &imap.SelectOptions{}).Wait()
+	store(imap.StoreFlagsAdd, 1)
+	if err = clientLogin.UnselectAndExpunge().Wait(); err != nil {
+		t.Fatal(err)
+	}
+	if has, _ := db.Instance.Exist(&models.UserEmail{ID: 1}); has {
+		t.Error("CLOSE didn't remove the \\Deleted message")
+	}
+}
+
+// TestExpungeOutsideIMAP 网页端或规则移走邮件后，选中文件夹的会话收到按原序号的 EXPUNGE；
+// 连接断开不删除 \Deleted 邮件，LOGOUT 时删除
+func TestExpungeOutsideIMAP(t *testing.T) {
+	user := models.User{Account: "expungeCase", Name: "expungeCase", Password: password.Encode("expungeCase")}
+	if _, err := db.Instance.Insert(&user); err != nil {
+		t.Fatal(err)
+	}
+	var emailIds, uids []int
+	for i := 0; i < 4; i++ {
+		email := models.Email{Subject: "expunge"}
+		db.Instance.Insert(&email)
+		ue := models.UserEmail{UserID: user.ID, EmailID: email.Id}
+		db.Instance.Insert(&ue)
+		emailIds = append(emailIds, email.Id)
+		uids = append(uids, ue.ID)
+	}
+	ctx := &pcontext.Context{UserID: user.ID, UserAccount: user.Account}
+
+	dial := func(expunged chan uint32) *imapclient.Client {
+		t.Helper()
+		c, err := imapclient.DialTLS(imapTestAddr, &imapclient.Options{
+			TLSConfig: &tls.Config{InsecureSkipVerify: true},
+			UnilateralDataHandler: &imapclient.UnilateralDataHandler{
+				Expunge: func(seqNum uint32) {
+					if expunged != nil {
+						expunged <- seqNum
+					}
+				},
+			},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = c.Login("expungeCase", "expungeCase").Wait(); err != nil {
+			t.Fatal(err)
+		}
+		if _, err = c.Select("INBOX", nil).Wait(); err != nil {
+			t.Fatal(err)
+		}
+		return c
+	}
+	expunged := make(chan uint32, 10)
+	c := dial(expunged)
+	defer c.Close()
+	expect := func(want ...uint32) {
+		t.Helper()
+		if err := c.Noop().Wait(); err != nil {
+			t.Fatal(err)
+		}
+		for _, seqNum := range want {
+			select {
+			case got := <-expunged:
+				if got != seqNum {
+					t.Errorf("EXPUNGE = %d, want %d", got, seqNum)
+				}
+			default:
+				t.Fatalf("EXPUNGE %d not received", seqNum)
+			}
+		}
+		if len(expunged) > 0 {
+			t.Errorf("unexpected EXPUNGE %d", <-expunged)
+		}
+	}
+
+	// 网页端删除第2封
+	if err := del_email.DelEmail(ctx, []int{emailIds[1]}, true); err != nil {
+		t.Fatal(err)
+	}
+	expect(2)
+	// 规则把第4封移到已删除文件夹，此时是第3封
+	_, _, err := modseq.Relocate(ctx, nil, builder.Eq{"id": uids[3]}, func(ue *models.UserEmail) {
+		ue.Status = consts.EmailStatusDel
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect(3)
+	expect()
+
+	// 连接断开时不删除
+	dropped := dial(nil)
+	if err = dropped.Store(imap.UIDSetNum(imap.UID(uids[0])), &imap.StoreFlags{Op: imap.StoreFlagsAdd, Flags: []imap.Flag{imap.FlagDeleted}, Silent: true}, nil).Close(); err != nil {
+		t.Fatal(err)
+	}
+	dropped.Close()
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) && selectedCount(user.ID) > 1 {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if has, _ := db.Instance.Exist(&models.UserEmail{ID: uids[0]}); !has {
+		t.Fatal("dropped connection expunged the mailbox")
+	}
+
+	// LOGOUT 时删除
+	loggedOut := dial(nil)
+	if err = loggedOut.Logout().Wait(); err != nil {
+		t.Fatal(err)
+	}
+	if has, _ := db.Instance.Exist(&models.UserEmail{ID: uids[0]}); has {
+		t.Error("LOGOUT didn't remove the \\Deleted message")
+	}
+	expect(1)
 }
+
+// selectedCount 选中了该用户文件夹的会话数量
+func selectedCount(userId int) int {
+	setAny, ok := selectedSessions.Load(userId)
+	if !ok {
+		return 0
+	}
+	set := setAny.(*sessionSet)
+	set.mu.Lock()
+	defer set.mu.Unlock()
+	return len(set.sessions)
+}
+
 func TestExamine(t *testing.T) {
 
 }
@@ -450,6 +898,78 @@ func TestSearch(t *testing.T) {
 	}
 	t.Logf("%+v", res)
 }
+func countThread(data imapclient.ThreadData) int {
+	num := len(data.Chain)
+	for _, sub := range data.SubThreads {
+		num += countThread(sub)
+	}
+	return num
+}
+
+func TestThread(t *testing.T) {
+	clientLogin.Select("INBOX", &imap.SelectOptions{}).Wait()
+
+	all, err := clientLogin.Search(&imap.SearchCriteria{}, &imap.SearchOptions{}).Wait()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, algorithm := range []imap.ThreadAlgorithm{imap.ThreadReferences, imap.ThreadOrderedSubject} {
+		res, err := clientLogin.UIDThread(&imapclient.ThreadOptions{Algorithm: algorithm, SearchCriteria: &imap.SearchCriteria{}}).Wait()
+		if err != nil {
+			t.Fatalf("%s: %v", algorithm, err)
+		}
+		// 测试数据的主题相同，合并为一个会话
+		if len(res) != 1 || countThread(res[0]) != len(all.AllSeqNums()) {
+			t.Errorf("%s: %+v", algorithm, res)
+		}
+	}
+}
+
+func TestThreadSentDate(t *testing.T) {
+	user := models.User{Account: "threadCase", Name: "threadCase", Password: password.Encode("threadCase")}
+	if _, err := db.Instance.Insert(&user); err != nil {
+		t.Fatal(err)
+	}
+	// UID越大的邮件发送时间越早，没有发送时间的邮件使用收到的时间
+	var uids []uint32
+	for _, email := range []*models.Email{
+		{Subject: "alpha", SendDate: time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC)},
+		{Subject: "beta", SendDate: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)},
+		{Subject: "gamma"},
+	} {
+		db.Instance.Insert(email)
+		ue := models.UserEmail{UserID: user.ID, EmailID: email.Id}
+		db.Instance.Insert(&ue)
+		uids = append(uids, uint32(ue.ID))
+	}
+
+	c, err := imapclient.DialTLS(imapTestAddr, &imapclient.Options{TLSConfig: &tls.Config{InsecureSkipVerify: true}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if err = c.Login("threadCase", "threadCase").Wait(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = c.Select("INBOX", nil).Wait(); err != nil {
+		t.Fatal(err)
+	}
+	want := []uint32{uids[1], uids[0], uids[2]}
+	for _, algorithm := range []imap.ThreadAlgorithm{imap.ThreadReferences, imap.ThreadOrderedSubject} {
+		res, err := c.UIDThread(&imapclient.ThreadOptions{Algorithm: algorithm, SearchCriteria: &imap.SearchCriteria{}}).Wait()
+		if err != nil {
+			t.Fatalf("%s: %v", algorithm, err)
+		}
+		var got []uint32
+		for _, data := range res {
+			got = append(got, data.Chain...)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: threads = %v, want %v", algorithm, got, want)
+		}
+	}
+}
+
 func TestMove(t *testing.T) {
 	clientLogin.Select("INBOX", &imap.SelectOptions{}).Wait()
 
@@ -474,10 +994,33 @@ func TestMove(t *testing.T) {
 	if len(res) > 0 {
 		uid := res[0].UID
 
-		_, err = clientLogin.Move(imap.UIDSetNum(uid), "Junk").Wait()
+		// EXAMINE 打开的文件夹不能移出邮件
+		clientLogin.Select("INBOX", &imap.SelectOptions{ReadOnly: true}).Wait()
+		if _, err = clientLogin.Move(imap.UIDSetNum(uid), "Junk").Wait(); err == nil {
+			t.Error("MOVE in a read-only mailbox succeeded")
+		}
+		clientLogin.Select("INBOX", &imap.SelectOptions{}).Wait()
+
+		num := clientLogin.Mailbox().NumMessages
+		data, err := clientLogin.Move(imap.UIDSetNum(uid), "Junk").Wait()
 		if err != nil {
 			t.Errorf("%+v", err)
 		}
+		if clientLogin.Mailbox().NumMessages != num-1 {
+			t.Errorf("MOVE didn't send EXPUNGE, messages %d -> %d", num, clientLogin.Mailbox().NumMessages)
+		}
+		// 移到其他文件夹后使用新的UID，原UID不再存在
+		if data == nil {
+			t.Fatal("MOVE didn't return COPYUID")
+		}
+		src, _ := data.SourceUIDs.(imap.UIDSet)
+		dest, _ := data.DestUIDs.(imap.UIDSet)
+		if src.String() != imap.UIDSetNum(uid).String() || len(dest) != 1 || dest[0].Start <= uid {
+			t.Errorf("COPYUID = %v %v", data.SourceUIDs, data.DestUIDs)
+		}
+		if has, _ := db.Instance.Exist(&models.UserEmail{ID: int(uid)}); has {
+			t.Error("moved message kept its old UID")
+		}
 
 	}
 
@@ -513,6 +1056,19 @@ func TestCopy(t *testing.T) {
 		t.Error("No Fetch Result")
 	}
 
+	// 多个范围的邮件都要复制
+	if clientLogin.Mailbox().NumMessages < 3 {
+		t.Fatalf("INBOX has %d messages", clientLogin.Mailbox().NumMessages)
+	}
+	data, err := clientLogin.Copy(imap.SeqSet{{Start: 1, Stop: 1}, {Start: 3, Stop: 3}}, "Junk").Wait()
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	srcUids, _ := data.SourceUIDs.Nums()
+	destUids, _ := data.DestUIDs.Nums()
+	if len(srcUids) != 2 || len(destUids) != 2 {
+		t.Errorf("COPYUID = %v %v", data.SourceUIDs, data.DestUIDs)
+	}
 }
 
 func TestNoop(t *testing.T) {
@@ -579,6 +1135,77 @@ func TestIDLE(t *testing.T) {
 	}
 }
 
+func TestCondStore(t *testing.T) {
+	conn, err := tls.Dial("tcp", imapTestAddr, &tls.Config{InsecureSkipVerify: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	reader := bufio.NewReader(conn)
+	if _, err = reader.ReadString('\n'); err != nil {
+		t.Fatal(err)
+	}
+
+	command := func(tag, cmd string) []string {
+		t.Helper()
+		if _, err := conn.Write([]byte(tag + " " + cmd + "\r\n")); err != nil {
+			t.Fatal(err)
+		}
+		var lines []string
+		for {
+			line, err := reader.ReadString('\n')
+			if err != nil {
+				t.Fatal(err)
+			}
+			lines = append(lines, line)
+			if strings.HasPrefix(line, tag+" ") {
+				if !strings.HasPrefix(line, tag+" OK") {
+					t.Fatalf("unexpected %s response: %s", cmd, line)
+				}
+				return lines
+			}
+		}
+	}
+	contains := func(lines []string, s string) bool {
+		for _, line := range lines {
+			if strings.Contains(line, s) {
+				return true
+			}
+		}
+		return false
+	}
+
+	command("c001", "LOGIN testCase testCase")
+	command("c002", "ENABLE QRESYNC")
+
+	lines := command("c003", "SELECT INBOX (CONDSTORE)")
+	if !contains(lines, "HIGHESTMODSEQ") {
+		t.Fatalf("SELECT missing HIGHESTMODSEQ: %v", lines)
+	}
+
+	lines = command("c004", "FETCH 1 (FLAGS MODSEQ)")
+	if !contains(lines, "MODSEQ (") {
+		t.Fatalf("FETCH missing MODSEQ: %v", lines)
+	}
+
+	lines = command("c005", "STATUS INBOX (HIGHESTMODSEQ)")
+	if !contains(lines, "HIGHESTMODSEQ") {
+		t.Fatalf("STATUS missing HIGHESTMODSEQ: %v", lines)
+	}
+
+	command("c006", "STORE 1 +FLAGS.SILENT (\\Seen)")
+	lines = command("c007", "UID FETCH 1:* (FLAGS) (CHANGEDSINCE 1)")
+	if !contains(lines, "MODSEQ (") {
+		t.Fatalf("FETCH CHANGEDSINCE missing changed message: %v", lines)
+	}
+
+	lines = command("c008", "STORE 1 (UNCHANGEDSINCE 1) +FLAGS.SILENT (\\Seen)")
+	if !contains(lines, "MODIFIED") {
+		t.Fatalf("conditional STORE should fail: %v", lines)
+	}
+}
+
 func TestIdleNotice(t *testing.T) {
 	updates := make(chan uint32, 1)
 	options := &imapclient.Options{
@@ -670,6 +1297,126 @@ func waitIdleConnectionsDeleted(t *testing.T, userId int) {
 	}
 	t.Fatal("idle connections were not cleaned up")
 }
+func TestACL(t *testing.T) {
+	res, err := clientLogin.Capability().Wait()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !res.Has(imap.CapACL) || !res.Has("RIGHTS=kxte") {
+		t.Errorf("missing ACL capability: %v", res)
+	}
+
+	var owner models.User
+	db.Instance.Where("account=?", "testCase").Get(&owner)
+	support := models.User{Account: "aclSupport", Name: "Support", Shared: 1}
+	member := models.User{Account: "aclMember", Name: "Member", Password: password.Encode("aclMember")}
+	db.Instance.Insert(&support)
+	db.Instance.Insert(&member)
+	email := models.Email{Subject: "shared mailbox test", Size: 100}
+	db.Instance.Insert(&email)
+	db.Instance.Insert(&models.UserEmail{UserID: support.ID, EmailID: email.Id})
+	ctx := &pcontext.Context{}
+	t.Cleanup(func() {
+		db.Instance.Where("owner_id in (?,?)", support.ID, owner.ID).Delete(&models.MailboxAcl{})
+		db.Instance.Where("user_id=?", support.ID).Delete(&models.UserEmail{})
+		db.Instance.ID(support.ID).Delete(&models.User{})
+		db.Instance.ID(member.ID).Delete(&models.User{})
+	})
+
+	client, err := imapclient.DialTLS(imapTestAddr, &imapclient.Options{TLSConfig: &tls.Config{InsecureSkipVerify: true}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	if err = client.Login("aclMember", "aclMember").Wait(); err != nil {
+		t.Fatal(err)
+	}
+
+	ns, err := client.Namespace().Wait()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ns.Other) != 1 || ns.Other[0].Prefix != "Other Users/" || len(ns.Shared) != 1 || ns.Shared[0].Prefix != "Shared/" {
+		t.Errorf("NAMESPACE = %+v", ns)
+	}
+
+	// 没有授权时不暴露共享邮箱
+	if _, err = client.Select("Shared/aclSupport/INBOX", nil).Wait(); err == nil || !strings.Contains(err.Error(), "NONEXISTENT") {
+		t.Errorf("Select without rights: %v", err)
+	}
+
+	// 只读授权
+	if err = acl.Set(ctx, support.ID, "", member.ID, "lr"); err != nil {
+		t.Fatal(err)
+	}
+	list, err := client.List("", "*", nil).Collect()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, data := range list {
+		names = append(names, data.Mailbox)
+	}
+	if !array.InArray("Shared", names) || !array.InArray("Shared/aclSupport", names) || !array.InArray("Shared/aclSupport/INBOX", names) {
+		t.Errorf("LIST = %v", names)
+	}
+	rights, err := client.MyRights("Shared/aclSupport/INBOX").Wait()
+	if err != nil || rights.Rights.String() != "lr" {
+		t.Errorf("MYRIGHTS = %+v, %v", rights, err)
+	}
+	selected, err := client.Select("Shared/aclSupport/INBOX", nil).Wait()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if selected.NumMessages != 1 {
+		t.Errorf("shared INBOX messages = %d", selected.NumMessages)
+	}
+	if err = client.Store(imap.SeqSetNum(1), &imap.StoreFlags{Op: imap.StoreFlagsAdd, Flags: []imap.Flag{imap.FlagFlagged}}, nil).Close(); err == nil {
+		t.Error("Store in a read-only shared mailbox should fail")
+	}
+	msg := "From: Bob <bob@remote.net>\r\nTo: aclSupport@example.com\r\nSubject: acl test\r\n\r\nhello\r\n"
+	cmd := client.Append("Shared/aclSupport/INBOX", int64(len(msg)), nil)
+	cmd.Write([]byte(msg))
+	cmd.Close()
+	if _, err = cmd.Wait(); err == nil || !strings.Contains(err.Error(), "NOPERM") {
+		t.Errorf("Append without i right: %v", err)
+	}
+
+	// 邮件写入共享邮箱，属于共享邮箱而不是当前用户
+	if err = acl.Set(ctx, support.ID, "", member.ID, "lrswi"); err != nil {
+		t.Fatal(err)
+	}
+	cmd = client.Append("Shared/aclSupport/INBOX", int64(len(msg)), nil)
+	cmd.Write([]byte(msg))
+	cmd.Close()
+	if _, err = cmd.Wait(); err != nil {
+		t.Fatal(err)
+	}
+	if num, _ := db.Instance.Where("user_id=?", support.ID).Count(&models.UserEmail{}); num != 2 {
+		t.Errorf("shared mailbox messages = %d", num)
+	}
+
+	// 普通用户通过SETACL共享自己的文件夹
+	if err = clientLogin.SetACL("INBOX", "aclMember", imap.RightModificationReplace, imap.RightSet("lr")).Wait(); err != nil {
+		t.Fatal(err)
+	}
+	data, err := clientLogin.GetACL("INBOX").Wait()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data.Rights["aclMember"].String() != "lr" || data.Rights["testCase"].String() != acl.All {
+		t.Errorf("GETACL = %+v", data)
+	}
+	if _, err = client.Status("Other Users/testCase/INBOX", &imap.StatusOptions{NumMessages: true}).Wait(); err != nil {
+		t.Error(err)
+	}
+	if err = client.SetACL("Other Users/testCase/INBOX", "anyone", imap.RightModificationReplace, imap.RightSet("lr")).Wait(); err == nil {
+		t.Error("SETACL without a right should fail")
+	}
+	if _, err = client.Status("Other Users/testCase/Junk", &imap.StatusOptions{NumMessages: true}).Wait(); err == nil {
+		t.Error("Status of a folder that isn't shared should fail")
+	}
+}
 func TestUnselect(t *testing.T) {
 
 }
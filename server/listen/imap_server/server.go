@@ -1,13 +1,13 @@
 package imap_server
 
 import (
+	"github.com/Jinnrry/pmail/services/acl"
 	"github.com/Jinnrry/pmail/utils/context"
 	"github.com/Jinnrry/pmail/utils/id"
-	"github.com/emersion/go-imap/v2"
-	log "github.com/sirupsen/logrus"
 	"sync"
 	"time"
 
+	"github.com/emersion/go-imap/v2"
 	"github.com/emersion/go-imap/v2/imapserver"
 )
 
@@ -38,7 +38,18 @@ type serverSession struct {
 	status         Status
 	currentMailbox string
 	connectTime    time.Time
-	deleteUidList  []int
+	readOnly       bool             // 文件夹通过EXAMINE打开，或者没有修改文件夹的权限
+	box            *context.Context // 选中文件夹所在邮箱所有者的上下文，其他用户或共享邮箱的文件夹为所有者
+	rights         string           // 对选中文件夹的权限
+
+	// mu 保护下面的字段，其他会话删除邮件时会并发修改
+	mu          sync.Mutex
+	expunged    []expungedMessage        // 其他途径移走的邮件，等待通知客户端
+	idleWriter  *imapserver.UpdateWriter // IDLE中时直接通知客户端
+	vanishedSeq int64                    // 已经检查过的 mailbox_vanished 修改序号
+	reported    map[imap.UID]bool        // 已经通知过客户端的被移走的UID
+	// collectMu 保证同一时间只有一个 collectVanished 在计算序号
+	collectMu sync.Mutex
 }
 
 // NewSession creates a new IMAP session.
@@ -53,8 +64,20 @@ func (s *Server) NewSession() imapserver.Session {
 	}
 }
 
+// Close 连接断开时调用，包括网络中断与超时，只取消选中文件夹，不删除带有 \Deleted 标志的邮件
 func (s *serverSession) Close() error {
-	return nil
+	if s.currentMailbox == "" {
+		return nil
+	}
+	return s.Unselect()
+}
+
+// Logout 客户端发送LOGOUT时删除选中文件夹中带有 \Deleted 标志的邮件
+func (s *serverSession) Logout() error {
+	if s.currentMailbox == "" || s.readOnly || !acl.Has(s.rights, acl.Expunge) {
+		return nil
+	}
+	return s.Expunge(&imapserver.ExpungeWriter{}, nil)
 }
 
 func (s *serverSession) Subscribe(mailbox string) error {
@@ -65,12 +88,13 @@ func (s *serverSession) Unsubscribe(mailbox string) error {
 	return nil
 }
 
-func (s *serverSession) Append(mailbox string, r imap.LiteralReader, options *imap.AppendOptions) (*imap.AppendData, error) {
-	log.WithContext(s.ctx).Errorf("Append Not Implemented")
-	return nil, nil
-}
-
 func (s *serverSession) Unselect() error {
+	unregisterSelected(s)
 	s.currentMailbox = ""
+	s.box = nil
+	s.rights = ""
+	s.mu.Lock()
+	s.expunged, s.reported = nil, nil
+	s.mu.Unlock()
 	return nil
 }
@@ -0,0 +1,238 @@
+package imap_server
+
+import (
+	"strings"
+
+	"github.com/Jinnrry/pmail/models"
+	"github.com/Jinnrry/pmail/services/acl"
+	"github.com/Jinnrry/pmail/utils/context"
+	"github.com/emersion/go-imap/v2"
+)
+
+// mailboxRef 命令中的文件夹名解析后对应的邮箱和文件夹
+type mailboxRef struct {
+	ctx    *context.Context // 邮箱所有者的上下文，用户自己的邮箱为会话的上下文
+	owner  *models.User     // 其他用户或共享邮箱的所有者，用户自己的邮箱为nil
+	name   string           // 在所有者邮箱中的文件夹名
+	prefix string           // 命名空间前缀，比如 Shared/support/
+	rights string
+}
+
+// shared 是否为其他用户或共享邮箱中的文件夹
+func (m *mailboxRef) shared() bool {
+	return m.owner != nil
+}
+
+// mailbox 解析文件夹名，Other Users/<账号>/<文件夹> 为其他用户共享的文件夹，Shared/<账号>/<文件夹> 为共享邮箱中的文件夹。
+// 没有任何权限时按文件夹不存在处理，不暴露文件夹是否存在
+func (s *serverSession) mailbox(name string) (*mailboxRef, error) {
+	var shared bool
+	var rest string
+	if strings.HasPrefix(name, acl.NamespaceShared) {
+		shared, rest = true, strings.TrimPrefix(name, acl.NamespaceShared)
+	} else if strings.HasPrefix(name, acl.NamespaceOther) {
+		rest = strings.TrimPrefix(name, acl.NamespaceOther)
+	} else {
+		return &mailboxRef{ctx: s.ctx, name: name, rights: acl.All}, nil
+	}
+
+	account, folder, _ := strings.Cut(rest, "/")
+	var owner *models.User
+	if account != "" && folder != "" {
+		owner = acl.Owner(s.ctx, account, shared)
+	}
+	if owner == nil || owner.ID == s.ctx.UserID {
+		return nil, errNonExistent
+	}
+	ref := &mailboxRef{
+		ctx:    acl.Context(s.ctx, owner),
+		owner:  owner,
+		name:   folder,
+		prefix: acl.Prefix(owner, s.ctx.UserID),
+		rights: acl.Rights(s.ctx, owner, folder, s.ctx.UserID),
+	}
+	if ref.rights == "" {
+		return nil, errNonExistent
+	}
+	return ref, nil
+}
+
+// mailboxWith 解析文件夹名并检查权限
+func (s *serverSession) mailboxWith(name string, required string) (*mailboxRef, error) {
+	ref, err := s.mailbox(name)
+	if err != nil {
+		return nil, err
+	}
+	if !acl.Has(ref.rights, required) {
+		return nil, errNoPerm
+	}
+	return ref, nil
+}
+
+var errNonExistent = &imap.Error{
+	Type: imap.StatusResponseTypeNo,
+	Code: imap.ResponseCodeNonExistent,
+	Text: "Mailbox Not Found",
+}
+
+var errNoPerm = &imap.Error{
+	Type: imap.StatusResponseTypeNo,
+	Code: imap.ResponseCodeNoPerm,
+	Text: "Permission denied",
+}
+
+// ownerOf 文件夹所在邮箱的所有者
+func (s *serverSession) ownerOf(ref *mailboxRef) (*models.User, error) {
+	if ref.shared() {
+		return ref.owner, nil
+	}
+	return s.user()
+}
+
+// allowedFlags 去掉没有权限修改的标志，\Seen 需要s，\Deleted 需要t，其他标志需要w
+func allowedFlags(rights string, flags []imap.Flag) []imap.Flag {
+	var ret []imap.Flag
+	for _, f := range flags {
+		required := acl.Write
+		switch {
+		case strings.EqualFold(string(f), string(imap.FlagSeen)):
+			required = acl.Seen
+		case strings.EqualFold(string(f), string(imap.FlagDeleted)):
+			required = acl.DeleteMessages
+		}
+		if acl.Has(rights, required) {
+			ret = append(ret, f)
+		}
+	}
+	return ret
+}
+
+func (s *serverSession) SetACL(mailbox string, ri imap.RightsIdentifier, rm imap.RightModification, rs imap.RightSet) error {
+	ref, err := s.mailboxWith(mailbox, acl.Admin)
+	if err != nil {
+		return err
+	}
+	owner, err := s.ownerOf(ref)
+	if err != nil {
+		return err
+	}
+	userId := acl.Identifier(s.ctx, string(ri))
+	if userId < 0 || (userId == owner.ID && owner.Shared == 0) {
+		return &imap.Error{
+			Type: imap.StatusResponseTypeNo,
+			Text: "Invalid identifier",
+		}
+	}
+	rights, err := acl.Normalize(string(rs))
+	if err != nil {
+		return &imap.Error{
+			Type: imap.StatusResponseTypeBad,
+			Text: err.Error(),
+		}
+	}
+
+	current := acl.Entries(s.ctx, owner.ID, ref.name)[userId]
+	switch rm {
+	case imap.RightModificationAdd:
+		rights = current + rights
+	case imap.RightModificationRemove:
+		rights = strings.Map(func(r rune) rune {
+			if strings.ContainsRune(rights, r) {
+				return -1
+			}
+			return r
+		}, current)
+	}
+	if err = acl.Set(s.ctx, owner.ID, ref.name, userId, rights); err != nil {
+		return &imap.Error{
+			Type: imap.StatusResponseTypeNo,
+			Text: err.Error(),
+		}
+	}
+	return nil
+}
+
+func (s *serverSession) DeleteACL(mailbox string, ri imap.RightsIdentifier) error {
+	ref, err := s.mailboxWith(mailbox, acl.Admin)
+	if err != nil {
+		return err
+	}
+	owner, err := s.ownerOf(ref)
+	if err != nil {
+		return err
+	}
+	userId := acl.Identifier(s.ctx, string(ri))
+	if userId < 0 {
+		return &imap.Error{
+			Type: imap.StatusResponseTypeNo,
+			Text: "Invalid identifier",
+		}
+	}
+	if err = acl.Delete(s.ctx, owner.ID, ref.name, userId); err != nil {
+		return &imap.Error{
+			Type: imap.StatusResponseTypeNo,
+			Text: err.Error(),
+		}
+	}
+	return nil
+}
+
+// GetACL 返回文件夹上生效的授权，普通用户自己总是拥有全部权限
+func (s *serverSession) GetACL(mailbox string) (*imap.ACLData, error) {
+	ref, err := s.mailboxWith(mailbox, acl.Admin)
+	if err != nil {
+		return nil, err
+	}
+	owner, err := s.ownerOf(ref)
+	if err != nil {
+		return nil, err
+	}
+	data := &imap.ACLData{
+		Mailbox: mailbox,
+		Rights:  map[imap.RightsIdentifier]imap.RightSet{},
+	}
+	if owner.Shared == 0 {
+		data.Rights[imap.RightsIdentifier(owner.Account)] = imap.RightSet(acl.All)
+	}
+	for userId, rights := range acl.Entries(s.ctx, owner.ID, ref.name) {
+		data.Rights[imap.RightsIdentifier(acl.IdentifierName(s.ctx, userId))] = imap.RightSet(rights)
+	}
+	return data, nil
+}
+
+// ListRights 每个权限都可以单独授予，邮箱所有者总是拥有全部权限
+func (s *serverSession) ListRights(mailbox string, ri imap.RightsIdentifier) (*imap.ListRightsData, error) {
+	ref, err := s.mailboxWith(mailbox, acl.Admin)
+	if err != nil {
+		return nil, err
+	}
+	owner, err := s.ownerOf(ref)
+	if err != nil {
+		return nil, err
+	}
+	data := &imap.ListRightsData{
+		Mailbox:    mailbox,
+		Identifier: ri,
+		Required:   imap.RightSet(""),
+	}
+	userId := acl.Identifier(s.ctx, string(ri))
+	if userId == owner.ID && owner.Shared == 0 {
+		data.Required = imap.RightSet(acl.All)
+		return data, nil
+	}
+	for _, r := range acl.All {
+		data.Optional = append(data.Optional, imap.RightSet(string(r)))
+	}
+	return data, nil
+}
+
+func (s *serverSession) MyRights(mailbox string) (*imap.MyRightsData, error) {
+	ref, err := s.mailbox(mailbox)
+	if err != nil {
+		return nil, err
+	}
+	return &imap.MyRightsData{
+		Mailbox: mailbox,
+		Rights:  imap.RightSet(ref.rights),
+	}, nil
+}
@@ -0,0 +1,175 @@
+package imap_server
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/Jinnrry/pmail/config"
+	"github.com/Jinnrry/pmail/consts"
+	"github.com/Jinnrry/pmail/db"
+	"github.com/Jinnrry/pmail/dto/parsemail"
+	"github.com/Jinnrry/pmail/models"
+	"github.com/Jinnrry/pmail/services/acl"
+	"github.com/Jinnrry/pmail/services/flag"
+	"github.com/Jinnrry/pmail/services/fulltext"
+	"github.com/Jinnrry/pmail/services/group"
+	"github.com/Jinnrry/pmail/services/modseq"
+	"github.com/Jinnrry/pmail/services/quota"
+	"github.com/Jinnrry/pmail/services/thread"
+	"github.com/emersion/go-imap/v2"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cast"
+	"xorm.io/builder"
+)
+
+// 默认文件夹中邮件在 user_email 中的状态
+var appendBoxStatus = map[string]int8{
+	"INBOX":            consts.EmailStatusWait,
+	"Sent Messages":    consts.EmailStatusSent,
+	"Drafts":           consts.EmailStatusDrafts,
+	"Deleted Messages": consts.EmailStatusDel,
+	"Junk":             consts.EmailStatusJunk,
+}
+
+func (s *serverSession) Append(mailbox string, r imap.LiteralReader, options *imap.AppendOptions) (*imap.AppendData, error) {
+	ref, err := s.mailboxWith(mailbox, acl.Insert)
+	if err != nil {
+		return nil, err
+	}
+	ctx := ref.ctx
+	mailbox = ref.name
+
+	ue := models.UserEmail{UserID: ctx.UserID}
+	uidValidity := 0
+	status, isDefault := appendBoxStatus[mailbox]
+	if isDefault {
+		ue.Status = status
+		uidValidity = models.GroupNameToCode[mailbox]
+	} else {
+		groupInfo, err := group.GetGroupByFullPath(ctx, mailbox)
+		if err != nil || groupInfo == nil || groupInfo.ID == 0 {
+			return nil, &imap.Error{
+				Type: imap.StatusResponseTypeNo,
+				Code: imap.ResponseCodeTryCreate,
+				Text: "Mailbox Not Found",
+			}
+		}
+		ue.GroupId = groupInfo.ID
+		uidValidity = groupInfo.ID
+	}
+
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	if err = s.overQuota(ctx, int64(len(data))); err != nil {
+		return nil, err
+	}
+	email := parsemail.NewEmailFromReader(nil, bytes.NewReader(data), len(data))
+	if email == nil {
+		return nil, &imap.Error{
+			Type: imap.StatusResponseTypeNo,
+			Text: "Invalid Message",
+		}
+	}
+
+	internalDate := time.Now()
+	if options != nil && !options.Time.IsZero() {
+		internalDate = options.Time
+	}
+	sendDate := internalDate
+	if email.Date != "" {
+		if t, err := time.ParseInLocation(time.DateTime, email.Date, time.Local); err == nil {
+			sendDate = t
+		}
+	}
+	msgID := email.MsgID
+	if msgID == "" {
+		msgID = parsemail.GenerateMsgID(config.Instance.Domain)
+	}
+
+	modelEmail := models.Email{
+		Type:          consts.EmailTypeReceive,
+		Subject:       email.Subject,
+		ReplyTo:       json2string(email.ReplyTo),
+		To:            json2string(email.To),
+		Bcc:           json2string(email.Bcc),
+		Cc:            json2string(email.Cc),
+		Text:          sql.NullString{String: string(email.Text), Valid: true},
+		Html:          sql.NullString{String: string(email.HTML), Valid: true},
+		Sender:        json2string(email.Sender),
+		Attachments:   json2string(email.Attachments),
+		Size:          email.Size,
+		SendDate:      sendDate,
+		CreateTime:    internalDate,
+		CronSendTime:  internalDate,
+		MsgID:         msgID,
+		InReplyTo:     email.InReplyTo,
+		References:    strings.Join(email.References, " "),
+		ListId:        email.ListId,
+		Precedence:    email.Precedence,
+		AutoSubmitted: email.AutoSubmitted,
+		Headers:       parsemail.EncodeHeader(email.ReceivedHeader),
+	}
+	if email.From != nil {
+		modelEmail.FromName = email.From.Name
+		modelEmail.FromAddress = email.From.EmailAddress
+	}
+	// 客户端保存到已发送中的邮件按本人发出的邮件处理
+	if isDefault && mailbox == "Sent Messages" {
+		modelEmail.Type = consts.EmailTypeSend
+		modelEmail.Status = consts.EmailStatusSent
+		modelEmail.SendUserID = ctx.UserID
+	}
+
+	if options != nil {
+		flags := make([]string, 0, len(options.Flags))
+		for _, f := range allowedFlags(ref.rights, options.Flags) {
+			flags = append(flags, string(f))
+		}
+		flag.Apply(&ue, flags)
+	}
+
+	// 保留客户端指定的内部时间
+	session := db.Instance.NewSession()
+	defer session.Close()
+	if err = session.Begin(); err != nil {
+		return nil, err
+	}
+	if _, err = session.NoAutoTime().Insert(&modelEmail); err != nil {
+		log.WithContext(s.ctx).Errorf("db insert error:%+v", err)
+		session.Rollback()
+		return nil, err
+	}
+	ue.EmailID = modelEmail.Id
+	if _, err = session.Insert(&ue); err != nil {
+		log.WithContext(s.ctx).Errorf("db insert error:%+v", err)
+		session.Rollback()
+		return nil, err
+	}
+	if err = session.Commit(); err != nil {
+		return nil, err
+	}
+
+	modseq.Touch(ctx, nil, builder.Eq{"id": ue.ID})
+	thread.Assign(ctx, &modelEmail)
+	fulltext.Index(ctx, &modelEmail)
+	if mailbox == "INBOX" {
+		IdleNotice(ctx, ctx.UserID, &modelEmail)
+	}
+	quota.Warn(ctx, ctx.UserID)
+
+	return &imap.AppendData{
+		UID:         imap.UID(cast.ToUint32(ue.ID)),
+		UIDValidity: cast.ToUint32(uidValidity),
+	}, nil
+}
+
+func json2string(d any) string {
+	by, _ := json.Marshal(d)
+	return string(by)
+}
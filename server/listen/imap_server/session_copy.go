@@ -5,14 +5,22 @@ import (
 	"github.com/Jinnrry/pmail/db"
 	"github.com/Jinnrry/pmail/dto/response"
 	"github.com/Jinnrry/pmail/models"
+	"github.com/Jinnrry/pmail/services/acl"
 	"github.com/Jinnrry/pmail/services/group"
 	"github.com/Jinnrry/pmail/services/list"
+	"github.com/Jinnrry/pmail/services/modseq"
+	"github.com/Jinnrry/pmail/services/quota"
 	"github.com/Jinnrry/pmail/utils/context"
 	"github.com/emersion/go-imap/v2"
 	"github.com/spf13/cast"
+	"xorm.io/builder"
 )
 
 func (s *serverSession) Copy(numSet imap.NumSet, dest string) (*imap.CopyData, error) {
+	ref, err := s.mailboxWith(dest, acl.Insert)
+	if err != nil {
+		return nil, err
+	}
 
 	var emailList []*response.EmailResponseData
 
@@ -20,18 +28,20 @@ func (s *serverSession) Copy(numSet imap.NumSet, dest string) (*imap.CopyData, e
 	case imap.SeqSet:
 		seqSet := numSet.(imap.SeqSet)
 		for _, seq := range seqSet {
-			emailList = list.GetEmailListByGroup(s.ctx, s.currentMailbox, list.ImapListReq{
+			res := list.GetEmailListByGroup(s.box, s.currentMailbox, list.ImapListReq{
 				Star: cast.ToInt(seq.Start),
 				End:  cast.ToInt(seq.Stop),
 			}, false)
+			emailList = append(emailList, res...)
 		}
 	case imap.UIDSet:
 		uidSet := numSet.(imap.UIDSet)
 		for _, uid := range uidSet {
-			emailList = list.GetEmailListByGroup(s.ctx, s.currentMailbox, list.ImapListReq{
+			res := list.GetEmailListByGroup(s.box, s.currentMailbox, list.ImapListReq{
 				Star: cast.ToInt(uint32(uid.Start)),
 				End:  cast.ToInt(uint32(uid.Stop)),
 			}, true)
+			emailList = append(emailList, res...)
 		}
 	}
 
@@ -42,14 +52,7 @@ func (s *serverSession) Copy(numSet imap.NumSet, dest string) (*imap.CopyData, e
 		}
 	}
 
-	var err error
-	destUid := []int{}
-	UIDValidity := 0
-	if group.IsDefaultBox(dest) {
-		UIDValidity, destUid, err = copy2defaultbox(s.ctx, emailList, dest)
-	} else {
-		UIDValidity, destUid, err = copy2userbox(s.ctx, emailList, dest)
-	}
+	UIDValidity, destUid, err := s.copyTo(emailList, ref)
 	data := imap.CopyData{}
 	data.UIDValidity = cast.ToUint32(UIDValidity)
 	data.DestUIDs = imap.UIDSet{}
@@ -65,16 +68,70 @@ func (s *serverSession) Copy(numSet imap.NumSet, dest string) (*imap.CopyData, e
 	return &data, err
 }
 
+// copyTo 复制邮件到目标文件夹，目标文件夹可以在其他用户或共享邮箱中，复制出的邮件占用目标邮箱的配额
+func (s *serverSession) copyTo(emailList []*response.EmailResponseData, ref *mailboxRef) (int, []int, error) {
+	var mailIds, junkIds []int
+	var size int64
+	for _, email := range emailList {
+		mailIds = append(mailIds, email.Id)
+		size += int64(email.Size)
+	}
+	if err := s.overQuota(ref.ctx, size); err != nil {
+		return 0, nil, err
+	}
+	if s.currentMailbox == "Junk" && ref.ctx.UserID == s.box.UserID {
+		junkIds = mailIds
+	}
+	// 没有权限的标志不复制到目标文件夹
+	copied := make([]*response.EmailResponseData, 0, len(emailList))
+	for _, email := range emailList {
+		e := *email
+		if !acl.Has(ref.rights, acl.Seen) {
+			e.IsRead = 0
+		}
+		if !acl.Has(ref.rights, acl.DeleteMessages) {
+			e.Deleted = 0
+		}
+		if !acl.Has(ref.rights, acl.Write) {
+			e.Flagged, e.Answered, e.Draft, e.Keywords = 0, 0, 0, ""
+		}
+		copied = append(copied, &e)
+	}
+
+	var err error
+	destUid := []int{}
+	UIDValidity := 0
+	if group.IsDefaultBox(ref.name) {
+		UIDValidity, destUid, err = copy2defaultbox(ref.ctx, copied, ref.name)
+	} else {
+		UIDValidity, destUid, err = copy2userbox(ref.ctx, copied, ref.name)
+	}
+	if len(destUid) > 0 {
+		modseq.Touch(ref.ctx, nil, builder.Eq{"id": destUid})
+		quota.Warn(ref.ctx, ref.ctx.UserID)
+	}
+	if err == nil {
+		// 客户端通过COPY加删除实现移动，复制进出垃圾箱时同样训练分类器
+		group.TrainJunk(ref.ctx, mailIds, junkIds, ref.name)
+	}
+	return UIDValidity, destUid, err
+}
+
 func copy2defaultbox(ctx *context.Context, mails []*response.EmailResponseData, dest string) (int, []int, error) {
 
 	var destUid []int
 	for _, email := range mails {
 
 		newUe := models.UserEmail{
-			UserID:  ctx.UserID,
-			EmailID: email.Id,
-			IsRead:  email.IsRead,
-			GroupId: 0,
+			UserID:   ctx.UserID,
+			EmailID:  email.Id,
+			IsRead:   email.IsRead,
+			Flagged:  email.Flagged,
+			Answered: email.Answered,
+			Draft:    email.Draft,
+			Deleted:  email.Deleted,
+			Keywords: email.Keywords,
+			GroupId:  0,
 		}
 		switch dest {
 		case "Deleted Messages":
@@ -112,11 +169,16 @@ func copy2userbox(ctx *context.Context, mails []*response.EmailResponseData, des
 	var destUid []int
 	for _, email := range mails {
 		newUe := models.UserEmail{
-			UserID:  ctx.UserID,
-			EmailID: email.Id,
-			IsRead:  email.IsRead,
-			GroupId: groupInfo.ID,
-			Status:  email.Status,
+			UserID:   ctx.UserID,
+			EmailID:  email.Id,
+			IsRead:   email.IsRead,
+			Flagged:  email.Flagged,
+			Answered: email.Answered,
+			Draft:    email.Draft,
+			Deleted:  email.Deleted,
+			Keywords: email.Keywords,
+			GroupId:  groupInfo.ID,
+			Status:   email.Status,
 		}
 		db.Instance.Insert(&newUe)
 		destUid = append(destUid, newUe.ID)
@@ -1,17 +1,46 @@
 package imap_server
 
 import (
+	"github.com/Jinnrry/pmail/services/acl"
 	"github.com/Jinnrry/pmail/services/group"
 	"github.com/emersion/go-imap/v2"
 	"strings"
 )
 
 func (s *serverSession) Create(mailbox string, options *imap.CreateOptions) error {
+	ref, err := s.mailboxWith(mailbox, acl.Create)
+	if err != nil {
+		return err
+	}
+	ctx := ref.ctx
+	mailbox = ref.name
+
+	if group.IsDefaultBox(mailbox) || mailbox == "All Mail" {
+		return &imap.Error{
+			Type: imap.StatusResponseTypeNo,
+			Code: imap.ResponseCodeAlreadyExists,
+			Text: "Mailbox already exists",
+		}
+	}
+
+	// CREATE-SPECIAL-USE：默认文件夹已经占用的用途不能再创建
+	var specialUse []string
+	for _, attr := range options.SpecialUse {
+		if attr != imap.MailboxAttrArchive && attr != imap.MailboxAttrImportant {
+			return &imap.Error{
+				Type: imap.StatusResponseTypeNo,
+				Code: "USEATTR",
+				Text: "Unsupported special-use attribute " + string(attr),
+			}
+		}
+		specialUse = append(specialUse, string(attr))
+	}
+
 	groupPath := strings.Split(mailbox, "/")
 
 	var parentId int
 	for _, path := range groupPath {
-		newGroup, err := group.CreateGroup(s.ctx, path, parentId)
+		newGroup, err := group.CreateGroup(ctx, path, parentId)
 		if err != nil {
 			return &imap.Error{
 				Type: imap.StatusResponseTypeNo,
@@ -21,5 +50,14 @@ func (s *serverSession) Create(mailbox string, options *imap.CreateOptions) erro
 		parentId = newGroup.ID
 	}
 
+	if len(specialUse) > 0 {
+		if err := group.SetSpecialUse(ctx, parentId, strings.Join(specialUse, " ")); err != nil {
+			return &imap.Error{
+				Type: imap.StatusResponseTypeNo,
+				Text: err.Error(),
+			}
+		}
+	}
+
 	return nil
 }
@@ -1,29 +1,35 @@
 package imap_server
 
 import (
+	"github.com/Jinnrry/pmail/services/acl"
 	"github.com/Jinnrry/pmail/services/group"
 	"github.com/emersion/go-imap/v2"
 	"strings"
 )
 
 func (s *serverSession) Delete(mailbox string) error {
-	groupPath := strings.Split(mailbox, "/")
+	ref, err := s.mailboxWith(mailbox, acl.DeleteMailbox)
+	if err != nil {
+		return err
+	}
+	groupPath := strings.Split(ref.name, "/")
 
 	groupName := groupPath[len(groupPath)-1]
-	groupInfo, err := group.GetGroupByName(s.ctx, groupName)
+	groupInfo, err := group.GetGroupByName(ref.ctx, groupName)
 	if err != nil {
 		return &imap.Error{
 			Type: imap.StatusResponseTypeNo,
 			Text: err.Error(),
 		}
 	}
-	_, err = group.DelGroup(s.ctx, groupInfo.ID)
+	_, err = group.DelGroup(ref.ctx, groupInfo.ID)
 	if err != nil {
 		return &imap.Error{
 			Type: imap.StatusResponseTypeNo,
 			Text: err.Error(),
 		}
 	}
+	acl.DeleteFolder(ref.ctx, ref.ctx.UserID, ref.name)
 
 	return nil
 }
@@ -1,31 +1,51 @@
 package imap_server
 
 import (
+	"github.com/Jinnrry/pmail/config"
+	"github.com/Jinnrry/pmail/services/acl"
 	"github.com/Jinnrry/pmail/services/del_email"
+	"github.com/Jinnrry/pmail/services/list"
+	"github.com/Jinnrry/pmail/services/modseq"
 	"github.com/emersion/go-imap/v2"
 	"github.com/emersion/go-imap/v2/imapserver"
 	"github.com/spf13/cast"
 	"log/slog"
+	"sort"
+	"sync"
 )
 
+// expungedMessage 被删除的邮件，SeqNum 为删除前的序号
+type expungedMessage struct {
+	SeqNum uint32
+	UID    imap.UID
+}
+
+// selectedSessions 选中了文件夹的会话，按文件夹所在邮箱的所有者分组，userId -> *sessionSet，用于把删除的邮件通知给同一文件夹的其他会话
+var selectedSessions sync.Map
+var selectedSessionsMu sync.Mutex
+
+type sessionSet struct {
+	mu       sync.Mutex
+	sessions map[*serverSession]string
+}
+
+// Expunge 删除当前文件夹中带有 \Deleted 标志的邮件，uids 不为空时（UID EXPUNGE）只删除其中的邮件。
+// 开启 imapExpungeToTrash 后邮件移到已删除文件夹，已删除文件夹中的邮件总是彻底删除
 func (s *serverSession) Expunge(w *imapserver.ExpungeWriter, uids *imap.UIDSet) error {
-	if uids == nil && len(s.deleteUidList) == 0 {
-		return nil
+	if !acl.Has(s.rights, acl.Expunge) {
+		return errNoPerm
 	}
-	uidList := []int{}
-
-	if uids != nil {
-		for _, uidRange := range *uids {
-			if uidRange.Start > 0 && uidRange.Stop > 0 {
-				for i := uidRange.Start; i <= uidRange.Stop; i++ {
-					uidList = append(uidList, cast.ToInt(uint32(i)))
-				}
-			}
+	var expunged []expungedMessage
+	var uidList []int
+	for _, ue := range list.GetUEListByUID(s.box, s.currentMailbox, 0, 0, nil) {
+		if ue.Deleted != 1 {
+			continue
 		}
-	}
-
-	if len(s.deleteUidList) > 0 {
-		uidList = append(uidList, s.deleteUidList...)
+		if uids != nil && !uids.Contains(imap.UID(cast.ToUint32(ue.ID))) {
+			continue
+		}
+		expunged = append(expunged, expungedMessage{SeqNum: cast.ToUint32(ue.SerialNumber), UID: imap.UID(cast.ToUint32(ue.ID))})
+		uidList = append(uidList, ue.ID)
 	}
 
 	if len(uidList) == 0 {
@@ -34,14 +54,202 @@ func (s *serverSession) Expunge(w *imapserver.ExpungeWriter, uids *imap.UIDSet)
 
 	slog.Debug("DeleteUidList:", slog.Any("uidList", uidList))
 
-	err := del_email.DelByUID(s.ctx, uidList)
-	s.deleteUidList = []int{}
+	var removed []int
+	var err error
+	if config.Instance.ImapExpungeToTrash && s.currentMailbox != "Deleted Messages" {
+		removed, err = del_email.TrashByUID(s.box, uidList)
+	} else {
+		removed, err = del_email.DelByUID(s.box, uidList)
+	}
 	if err != nil {
 		return &imap.Error{
 			Type: imap.StatusResponseTypeNo,
 			Text: err.Error(),
 		}
 	}
+	// 只通知实际删除的邮件，其他会话已经删除的邮件跳过
+	expunged = onlyRemoved(expunged, removed)
+
+	notifyExpunged(s, expunged)
+
+	if w.QResync() {
+		return w.WriteVanished(expungedUIDs(expunged))
+	}
+	// 序号从大到小返回，前面的邮件被删除后不影响后面的序号
+	for i := len(expunged) - 1; i >= 0; i-- {
+		if err := w.WriteExpunge(expunged[i].SeqNum); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func onlyRemoved(expunged []expungedMessage, removed []int) []expungedMessage {
+	ids := map[imap.UID]bool{}
+	for _, id := range removed {
+		ids[imap.UID(cast.ToUint32(id))] = true
+	}
+	ret := expunged[:0]
+	for _, msg := range expunged {
+		if ids[msg.UID] {
+			ret = append(ret, msg)
+		}
+	}
+	return ret
+}
+
+func expungedUIDs(expunged []expungedMessage) imap.UIDSet {
+	uids := imap.UIDSet{}
+	for _, msg := range expunged {
+		uids.AddNum(msg.UID)
+	}
+	return uids
+}
+
+// registerSelected 记录会话当前选中的文件夹
+func registerSelected(s *serverSession) {
+	selectedSessionsMu.Lock()
+	defer selectedSessionsMu.Unlock()
+	setAny, _ := selectedSessions.LoadOrStore(s.box.UserID, &sessionSet{sessions: map[*serverSession]string{}})
+	set := setAny.(*sessionSet)
+	set.mu.Lock()
+	set.sessions[s] = s.currentMailbox
+	set.mu.Unlock()
+}
+
+func unregisterSelected(s *serverSession) {
+	selectedSessionsMu.Lock()
+	defer selectedSessionsMu.Unlock()
+	if s.box == nil {
+		return
+	}
+	setAny, ok := selectedSessions.Load(s.box.UserID)
+	if !ok {
+		return
+	}
+	set := setAny.(*sessionSet)
+	set.mu.Lock()
+	delete(set.sessions, s)
+	if len(set.sessions) == 0 {
+		selectedSessions.Delete(s.box.UserID)
+	}
+	set.mu.Unlock()
+}
+
+// notifyExpunged 当前会话移走邮件后调用：记录这些邮件已经通知过客户端，并让选中了同一文件夹、正在IDLE的其他会话立即发送。
+// 其他会话在下一次Poll时从 mailbox_vanished 中查找，网页端、规则等移走的邮件同样通过这种方式通知
+func notifyExpunged(source *serverSession, expunged []expungedMessage) {
+	source.mu.Lock()
+	for _, msg := range expunged {
+		if source.reported != nil {
+			source.reported[msg.UID] = true
+		}
+	}
+	source.mu.Unlock()
 
+	setAny, ok := selectedSessions.Load(source.box.UserID)
+	if !ok {
+		return
+	}
+	set := setAny.(*sessionSet)
+	var others []*serverSession
+	set.mu.Lock()
+	for s, mailbox := range set.sessions {
+		if s != source && mailbox == source.currentMailbox {
+			others = append(others, s)
+		}
+	}
+	set.mu.Unlock()
+
+	for _, s := range others {
+		s.mu.Lock()
+		w := s.idleWriter
+		s.mu.Unlock()
+		if w != nil {
+			s.collectVanished()
+			s.writeExpunged(w)
+		}
+	}
+}
+
+// collectVanished 从 mailbox_vanished 中查找还没有通知客户端的、移出选中文件夹的邮件，
+// 按客户端看到的序号（移走之前的序号）加入待通知列表
+func (s *serverSession) collectVanished() {
+	s.collectMu.Lock()
+	defer s.collectMu.Unlock()
+	box, name := s.box, s.currentMailbox
+	if box == nil || name == "" {
+		return
+	}
+	mailbox := modseq.MailboxByName(box, name)
+	if mailbox == 0 {
+		return
+	}
+	s.mu.Lock()
+	since := s.vanishedSeq
+	s.mu.Unlock()
+	uids, modSeq, err := modseq.VanishedSince(box, box.UserID, mailbox, since)
+	if err != nil {
+		slog.Error("Vanished Error", slog.Any("err", err))
+		return
+	}
+	s.mu.Lock()
+	s.vanishedSeq = modSeq
+	var pending []int
+	for _, uid := range uids {
+		if !s.reported[imap.UID(cast.ToUint32(uid))] {
+			pending = append(pending, uid)
+		}
+	}
+	s.mu.Unlock()
+	if len(pending) == 0 {
+		return
+	}
+
+	var current []int
+	for _, ue := range list.GetUEListByUID(box, name, 0, 0, nil) {
+		current = append(current, ue.ID)
+	}
+	sort.Ints(current)
+	var gone []int
+	for _, uid := range pending {
+		// 在原地移回文件夹的邮件UID不变，不需要通知
+		if i := sort.SearchInts(current, uid); i >= len(current) || current[i] != uid {
+			gone = append(gone, uid)
+		}
+	}
+
+	// 客户端看到的文件夹为当前的邮件加上这些被移走的邮件，序号从大到小保存
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.reported == nil {
+		return
+	}
+	for i := len(gone) - 1; i >= 0; i-- {
+		uid := imap.UID(cast.ToUint32(gone[i]))
+		seqNum := sort.SearchInts(current, gone[i]) + i + 1
+		s.expunged = append(s.expunged, expungedMessage{SeqNum: cast.ToUint32(seqNum), UID: uid})
+		s.reported[uid] = true
+	}
+}
+
+// writeExpunged 发送其他会话删除的邮件
+func (s *serverSession) writeExpunged(w *imapserver.UpdateWriter) error {
+	s.mu.Lock()
+	expunged := s.expunged
+	s.expunged = nil
+	s.mu.Unlock()
+	if len(expunged) == 0 {
+		return nil
+	}
+
+	if w.QResync() {
+		return w.WriteVanished(expungedUIDs(expunged))
+	}
+	for _, msg := range expunged {
+		if err := w.WriteExpunge(msg.SeqNum); err != nil {
+			return err
+		}
+	}
 	return nil
 }
@@ -10,11 +10,15 @@ import (
 	"github.com/Jinnrry/pmail/config"
 	"github.com/Jinnrry/pmail/dto/parsemail"
 	"github.com/Jinnrry/pmail/dto/response"
+	"github.com/Jinnrry/pmail/models"
+	"github.com/Jinnrry/pmail/services/acl"
 	"github.com/Jinnrry/pmail/services/detail"
 	"github.com/Jinnrry/pmail/services/list"
+	"github.com/Jinnrry/pmail/services/modseq"
 	"github.com/Jinnrry/pmail/utils/context"
 	"github.com/emersion/go-imap/v2"
 	"github.com/emersion/go-imap/v2/imapserver"
+	log "github.com/sirupsen/logrus"
 	"github.com/spf13/cast"
 )
 
@@ -96,6 +100,11 @@ func buildEnvelope(email *response.EmailResponseData, traEmail *parsemail.Email)
 		messageID = fmt.Sprintf("<%d@%s>", email.Id, config.Instance.Domain)
 	}
 
+	var inReplyTo []string
+	if traEmail.InReplyTo != "" {
+		inReplyTo = []string{traEmail.InReplyTo}
+	}
+
 	return &imap.Envelope{
 		Date:      email.CreateTime,
 		Subject:   email.Subject,
@@ -105,36 +114,70 @@ func buildEnvelope(email *response.EmailResponseData, traEmail *parsemail.Email)
 		To:        usersToAddresses(traEmail.To),
 		Cc:        usersToAddresses(traEmail.Cc),
 		Bcc:       usersToAddresses(traEmail.Bcc),
+		InReplyTo: inReplyTo,
 		MessageID: messageID,
-		// InReplyTo 和 References 暂不支持
 	}
 }
 
 func (s *serverSession) Fetch(w *imapserver.FetchWriter, numSet imap.NumSet, options *imap.FetchOptions) error {
+	// 只读打开或者没有修改 \Seen 的权限时不标记已读
+	if s.readOnly || !acl.Has(s.rights, acl.Seen) {
+		for _, section := range options.BodySection {
+			section.Peek = true
+		}
+	}
+
+	if options.Vanished {
+		// QRESYNC，先返回 CHANGEDSINCE 之后被移出文件夹的邮件
+		if err := s.writeVanished(w, numSet, options.ChangedSince); err != nil {
+			return err
+		}
+	}
+
 	switch numSet.(type) {
 	case imap.SeqSet:
 		seqSet := numSet.(imap.SeqSet)
 		for _, seq := range seqSet {
-			emailList := list.GetEmailListByGroup(s.ctx, s.currentMailbox, list.ImapListReq{
+			emailList := list.GetEmailListByGroup(s.box, s.currentMailbox, list.ImapListReq{
 				Star: cast.ToInt(seq.Start),
 				End:  cast.ToInt(seq.Stop),
 			}, false)
-			write(s.ctx, w, emailList, options)
+			write(s.box, w, emailList, options)
 		}
 
 	case imap.UIDSet:
 		uidSet := numSet.(imap.UIDSet)
 		for _, uid := range uidSet {
-			emailList := list.GetEmailListByGroup(s.ctx, s.currentMailbox, list.ImapListReq{
+			emailList := list.GetEmailListByGroup(s.box, s.currentMailbox, list.ImapListReq{
 				Star: cast.ToInt(uint32(uid.Start)),
 				End:  cast.ToInt(uint32(uid.Stop)),
 			}, true)
-			write(s.ctx, w, emailList, options)
+			write(s.box, w, emailList, options)
 		}
 	}
 	return nil
 }
 
+// writeVanished 返回 modSeq 之后被移出当前文件夹并且在 numSet 中的UID
+func (s *serverSession) writeVanished(w *imapserver.FetchWriter, numSet imap.NumSet, modSeq uint64) error {
+	uidSet, ok := numSet.(imap.UIDSet)
+	if !ok {
+		return nil
+	}
+	uids, err := modseq.Vanished(s.box, s.box.UserID, modseq.MailboxByName(s.box, s.currentMailbox), cast.ToInt64(modSeq))
+	if err != nil {
+		log.WithContext(s.box).Errorf("SQL Error:%v", err)
+		return err
+	}
+	vanished := imap.UIDSet{}
+	for _, uid := range uids {
+		if uidSet.Contains(imap.UID(uid)) {
+			vanished.AddNum(imap.UID(uid))
+		}
+	}
+	return w.WriteVanished(vanished)
+}
+
 // 纯文本 text/plain
 func bsTextPlain(size uint32, numLines int64) *imap.BodyStructureSinglePart {
 	return &imap.BodyStructureSinglePart{
@@ -223,8 +266,25 @@ func bsMixedWithAttachments(alt *imap.BodyStructureMultiPart, extend bool, atts
 	}
 }
 
+// writeRawHeader 按原始格式输出收到邮件时保存的同名头
+func writeRawHeader(b *bytes.Buffer, traEmail *parsemail.Email, field string) {
+	fields := traEmail.ReceivedHeader.FieldsByKey(field)
+	for fields.Next() {
+		raw, err := fields.Raw()
+		if err != nil {
+			continue
+		}
+		b.Write(raw)
+	}
+}
+
 func write(ctx *context.Context, w *imapserver.FetchWriter, emailList []*response.EmailResponseData, options *imap.FetchOptions) {
 	for _, email := range emailList {
+		// 没有修改序号的邮件按1处理
+		modSeq := uint64(max(email.ModSeq, 1))
+		if options.ChangedSince > 0 && modSeq <= options.ChangedSince {
+			continue
+		}
 		writer := w.CreateMessage(cast.ToUint32(email.SerialNumber))
 
 		traEmail := parsemail.NewEmailFromModel(email.Email)
@@ -261,15 +321,21 @@ func write(ctx *context.Context, w *imapserver.FetchWriter, emailList []*respons
 			writer.WriteRFC822Size(cast.ToInt64(len(emailContent)))
 		}
 		if options.Flags {
-			if email.IsRead == 1 {
-				writer.WriteFlags([]imap.Flag{imap.FlagSeen})
-			} else {
-				writer.WriteFlags([]imap.Flag{})
-			}
+			writer.WriteFlags(imapFlags(&models.UserEmail{
+				IsRead:   email.IsRead,
+				Flagged:  email.Flagged,
+				Answered: email.Answered,
+				Draft:    email.Draft,
+				Deleted:  email.Deleted,
+				Keywords: email.Keywords,
+			}))
 		}
 		if options.InternalDate {
 			writer.WriteInternalDate(email.CreateTime)
 		}
+		if options.ModSeq {
+			writer.WriteModSeq(modSeq)
+		}
 		for _, section := range options.BodySection {
 			if !section.Peek {
 				detail.MakeRead(ctx, email.Id, true)
@@ -284,7 +350,7 @@ func write(ctx *context.Context, w *imapserver.FetchWriter, emailList []*respons
 				if fields == nil || len(fields) == 0 {
 					// 没有指定字段，返回所有常见头部
 					fields = []string{
-						"date", "subject", "from", "to", "cc", "message-id", "content-type",
+						"date", "subject", "from", "to", "cc", "message-id", "in-reply-to", "references", "content-type",
 					}
 				}
 
@@ -329,16 +395,27 @@ func write(ctx *context.Context, w *imapserver.FetchWriter, emailList []*respons
 							fmt.Fprintf(&b, "Bcc: %s\r\n", traEmail.BuildBcc2String())
 						}
 					case "message-id":
-						fmt.Fprintf(&b, "Message-ID: <%d@%s>\r\n", email.Id, config.Instance.Domain)
+						if email.MsgID != "" {
+							fmt.Fprintf(&b, "Message-ID: <%s>\r\n", email.MsgID)
+						} else {
+							fmt.Fprintf(&b, "Message-ID: <%d@%s>\r\n", email.Id, config.Instance.Domain)
+						}
 					case "content-type":
 						args := strings.SplitN(string(emailContent), "\r\n", 3)
 						if len(args) >= 2 {
 							fmt.Fprintf(&b, "%s%s\r\n", args[0], args[1])
 						}
-					case "references", "in-reply-to", "thread-topic", "thread-index", "x-priority", "x-mailer", "x-android-message-id":
-						// 这些头部我们目前不存储，跳过
+					case "in-reply-to":
+						if traEmail.InReplyTo != "" {
+							fmt.Fprintf(&b, "In-Reply-To: <%s>\r\n", traEmail.InReplyTo)
+						}
+					case "references":
+						if len(traEmail.References) > 0 {
+							fmt.Fprintf(&b, "References: <%s>\r\n", strings.Join(traEmail.References, "> <"))
+						}
 					default:
-						// 其他未知头部，忽略
+						// 其他头部使用入库时保存的原始邮件头，没有保存时忽略
+						writeRawHeader(&b, traEmail, field)
 					}
 				}
 
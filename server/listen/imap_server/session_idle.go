@@ -23,7 +23,11 @@ var userConnects sync.Map
 var userConnectsMu sync.Mutex
 
 func (s *serverSession) Idle(w *imapserver.UpdateWriter, stop <-chan struct{}) error {
+	// 按选中文件夹所在邮箱的所有者记录，投递到共享邮箱的邮件同样通知
 	userId := s.ctx.UserID
+	if s.box != nil {
+		userId = s.box.UserID
+	}
 	logId := cast.ToString(s.ctx.GetValue(context.LogID))
 
 	userConnectsMu.Lock()
@@ -39,9 +43,19 @@ func (s *serverSession) Idle(w *imapserver.UpdateWriter, stop <-chan struct{}) e
 	connects.mu.Unlock()
 	userConnectsMu.Unlock()
 
+	s.mu.Lock()
+	s.idleWriter = w
+	s.mu.Unlock()
+	s.collectVanished()
+	s.writeExpunged(w)
+
 	go func() {
 		<-stop
 
+		s.mu.Lock()
+		s.idleWriter = nil
+		s.mu.Unlock()
+
 		userConnectsMu.Lock()
 		connects.mu.Lock()
 		delete(connects.writers, logId)
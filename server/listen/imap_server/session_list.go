@@ -3,48 +3,48 @@ package imap_server
 import (
 	"github.com/Jinnrry/pmail/db"
 	"github.com/Jinnrry/pmail/models"
+	"github.com/Jinnrry/pmail/services/acl"
 	"github.com/Jinnrry/pmail/utils/context"
 	"github.com/emersion/go-imap/v2"
 	"github.com/emersion/go-imap/v2/imapserver"
 	log "github.com/sirupsen/logrus"
+	"slices"
 	"strings"
 )
 
-func matchGroup(ctx *context.Context, w *imapserver.ListWriter, basePath, pattern string) {
-	var groups []*models.Group
-	if basePath == "" && pattern == "*" {
-		db.Instance.Table("group").Where("user_id=?", ctx.UserID).Find(&groups)
-		//w.WriteList(&imap.ListData{
-		//	Attrs:   []imap.MailboxAttr{imap.MailboxAttrNoSelect, imap.MailboxAttrHasChildren},
-		//	Delim:   '/',
-		//	Mailbox: "[PMail]",
-		//})
-		w.WriteList(&imap.ListData{
+// defaultBoxes 默认文件夹与对应的特殊用途（RFC 6154），All Mail 为包含全部邮件的虚拟文件夹
+var defaultBoxes = []struct {
+	name string
+	attr imap.MailboxAttr
+}{
+	{"INBOX", ""},
+	{"Sent Messages", imap.MailboxAttrSent},
+	{"Drafts", imap.MailboxAttrDrafts},
+	{"Deleted Messages", imap.MailboxAttrTrash},
+	{"Junk", imap.MailboxAttrJunk},
+	{"All Mail", imap.MailboxAttrAll},
+}
+
+func matchGroup(ctx *context.Context, basePath, pattern string) []*imap.ListData {
+	var ret []*imap.ListData
+	for _, box := range defaultBoxes {
+		if !imapserver.MatchList(box.name, '/', basePath, pattern) {
+			continue
+		}
+		data := &imap.ListData{
 			Attrs:   []imap.MailboxAttr{imap.MailboxAttrHasNoChildren},
 			Delim:   '/',
-			Mailbox: "INBOX",
-		})
-		w.WriteList(&imap.ListData{
-			Attrs:   []imap.MailboxAttr{imap.MailboxAttrHasNoChildren, imap.MailboxAttrSent},
-			Delim:   '/',
-			Mailbox: "Sent Messages",
-		})
-		w.WriteList(&imap.ListData{
-			Attrs:   []imap.MailboxAttr{imap.MailboxAttrHasNoChildren, imap.MailboxAttrDrafts},
-			Delim:   '/',
-			Mailbox: "Drafts",
-		})
+			Mailbox: box.name,
+		}
+		if box.attr != "" {
+			data.Attrs = append(data.Attrs, box.attr)
+		}
+		ret = append(ret, data)
+	}
 
-		w.WriteList(&imap.ListData{
-			Attrs:   []imap.MailboxAttr{imap.MailboxAttrHasNoChildren, imap.MailboxAttrTrash},
-			Delim:   '/',
-			Mailbox: "Deleted Messages",
-		})
-		w.WriteList(&imap.ListData{
-			Attrs:   []imap.MailboxAttr{imap.MailboxAttrHasNoChildren, imap.MailboxAttrJunk},
-			Delim:   '/',
-			Mailbox: "Junk",
-		})
+	var groups []*models.Group
+	if basePath == "" && pattern == "*" {
+		db.Instance.Table("group").Where("user_id=?", ctx.UserID).Find(&groups)
 	} else {
 		pattern = strings.ReplaceAll(pattern, "/*", "/%")
 
@@ -61,14 +61,28 @@ func matchGroup(ctx *context.Context, w *imapserver.ListWriter, basePath, patter
 
 		if hasChildren(ctx, group.ID) {
 			data.Attrs = append(data.Attrs, imap.MailboxAttrHasChildren)
+		} else {
+			data.Attrs = append(data.Attrs, imap.MailboxAttrHasNoChildren)
+		}
+		for _, attr := range strings.Fields(group.SpecialUse) {
+			data.Attrs = append(data.Attrs, imap.MailboxAttr(attr))
 		}
 
 		data.Mailbox = getLayerName(ctx, group, true)
 
-		w.WriteList(data)
-
+		ret = append(ret, data)
 	}
+	return ret
+}
 
+// isSpecialUse 是否为 RFC 6154 定义的特殊用途属性
+func isSpecialUse(attr imap.MailboxAttr) bool {
+	switch attr {
+	case imap.MailboxAttrAll, imap.MailboxAttrArchive, imap.MailboxAttrDrafts, imap.MailboxAttrFlagged,
+		imap.MailboxAttrJunk, imap.MailboxAttrSent, imap.MailboxAttrTrash, imap.MailboxAttrImportant:
+		return true
+	}
+	return false
 }
 
 func hasChildren(ctx *context.Context, id int) bool {
@@ -88,6 +102,55 @@ func getLayerName(ctx *context.Context, item *models.Group, allPath bool) string
 	return getLayerName(ctx, &parent, allPath)
 }
 
+// matchShared 其他用户和共享邮箱中有l权限的文件夹，命名空间和账号作为不可选择的上级节点。
+// 这些文件夹不返回特殊用途属性，避免客户端当作自己的已发送、已删除等文件夹使用
+func (s *serverSession) matchShared(basePath, pattern string) []*imap.ListData {
+	var ret []*imap.ListData
+	add := func(data *imap.ListData) {
+		if imapserver.MatchList(data.Mailbox, '/', basePath, pattern) {
+			ret = append(ret, data)
+		}
+	}
+	namespaces := map[string]bool{}
+	for _, owner := range acl.Owners(s.ctx, s.ctx.UserID) {
+		prefix := acl.Prefix(owner, s.ctx.UserID)
+		var folders []*imap.ListData
+		for _, data := range matchGroup(acl.Context(s.ctx, owner), "", "*") {
+			if !acl.Has(acl.Rights(s.ctx, owner, data.Mailbox, s.ctx.UserID), acl.Lookup) {
+				continue
+			}
+			data.Mailbox = prefix + data.Mailbox
+			data.Attrs = slices.DeleteFunc(data.Attrs, isSpecialUse)
+			folders = append(folders, data)
+		}
+		if len(folders) == 0 {
+			continue
+		}
+
+		namespace := acl.NamespaceOther
+		if owner.Shared == 1 {
+			namespace = acl.NamespaceShared
+		}
+		if !namespaces[namespace] {
+			namespaces[namespace] = true
+			add(noSelectNode(strings.TrimSuffix(namespace, "/")))
+		}
+		add(noSelectNode(strings.TrimSuffix(prefix, "/")))
+		for _, data := range folders {
+			add(data)
+		}
+	}
+	return ret
+}
+
+func noSelectNode(name string) *imap.ListData {
+	return &imap.ListData{
+		Attrs:   []imap.MailboxAttr{imap.MailboxAttrNoSelect, imap.MailboxAttrHasChildren},
+		Delim:   '/',
+		Mailbox: name,
+	}
+}
+
 func (s *serverSession) List(w *imapserver.ListWriter, ref string, patterns []string, options *imap.ListOptions) error {
 	log.WithContext(s.ctx).Debugf("imap server list, ref: %s ,patterns: %s ", ref, patterns)
 
@@ -98,8 +161,31 @@ func (s *serverSession) List(w *imapserver.ListWriter, ref string, patterns []st
 			Mailbox: "[PMail]",
 		})
 	}
+
+	listed := map[string]bool{}
 	for _, pattern := range patterns {
-		matchGroup(s.ctx, w, ref, pattern)
+		for _, data := range append(matchGroup(s.ctx, ref, pattern), s.matchShared(ref, pattern)...) {
+			if listed[data.Mailbox] {
+				continue
+			}
+			listed[data.Mailbox] = true
+
+			// LIST-EXTENDED：SPECIAL-USE 只返回有特殊用途的文件夹
+			if options.SelectSpecialUse && !slices.ContainsFunc(data.Attrs, isSpecialUse) {
+				continue
+			}
+			// 不支持取消订阅，所有文件夹都是已订阅状态
+			if options.SelectSubscribed || options.ReturnSubscribed {
+				data.Attrs = append(data.Attrs, imap.MailboxAttrSubscribed)
+			}
+			// LIST-STATUS
+			if options.ReturnStatus != nil && !slices.Contains(data.Attrs, imap.MailboxAttrNoSelect) {
+				data.Status, _ = s.Status(data.Mailbox, options.ReturnStatus)
+			}
+			if err := w.WriteList(data); err != nil {
+				return err
+			}
+		}
 	}
 
 	return nil
@@ -2,15 +2,31 @@ package imap_server
 
 import (
 	"github.com/Jinnrry/pmail/dto/response"
+	"github.com/Jinnrry/pmail/services/acl"
+	"github.com/Jinnrry/pmail/services/del_email"
 	"github.com/Jinnrry/pmail/services/group"
 	"github.com/Jinnrry/pmail/services/list"
-	"github.com/Jinnrry/pmail/utils/context"
 	"github.com/emersion/go-imap/v2"
 	"github.com/emersion/go-imap/v2/imapserver"
 	"github.com/spf13/cast"
+	"sort"
 )
 
 func (s *serverSession) Move(w *imapserver.MoveWriter, numSet imap.NumSet, dest string) error {
+	if s.readOnly {
+		return &imap.Error{
+			Type: imap.StatusResponseTypeNo,
+			Text: "Mailbox is read-only",
+		}
+	}
+	ref, err := s.mailboxWith(dest, acl.Insert)
+	if err != nil {
+		return err
+	}
+	// 移出邮件相当于标记删除后EXPUNGE
+	if !acl.Has(s.rights, acl.DeleteMessages+acl.Expunge) {
+		return errNoPerm
+	}
 
 	var emailList []*response.EmailResponseData
 
@@ -18,65 +34,99 @@ func (s *serverSession) Move(w *imapserver.MoveWriter, numSet imap.NumSet, dest
 	case imap.SeqSet:
 		seqSet := numSet.(imap.SeqSet)
 		for _, seq := range seqSet {
-			emailList = list.GetEmailListByGroup(s.ctx, s.currentMailbox, list.ImapListReq{
+			emailList = append(emailList, list.GetEmailListByGroup(s.box, s.currentMailbox, list.ImapListReq{
 				Star: cast.ToInt(seq.Start),
 				End:  cast.ToInt(seq.Stop),
-			}, false)
+			}, false)...)
 		}
 	case imap.UIDSet:
 		uidSet := numSet.(imap.UIDSet)
 		for _, uid := range uidSet {
-			emailList = list.GetEmailListByGroup(s.ctx, s.currentMailbox, list.ImapListReq{
+			emailList = append(emailList, list.GetEmailListByGroup(s.box, s.currentMailbox, list.ImapListReq{
 				Star: cast.ToInt(uint32(uid.Start)),
 				End:  cast.ToInt(uint32(uid.Stop)),
-			}, true)
+			}, true)...)
 		}
 	}
 
-	var mailIds []int
-	for _, email := range emailList {
-		mailIds = append(mailIds, email.Id)
+	if len(emailList) == 0 {
+		return nil
 	}
 
-	if mailIds == nil || len(mailIds) == 0 {
-		return nil
+	// 移动前记录邮件的序号，移动后按 EXPUNGE 通知客户端
+	seqNums := map[int]uint32{}
+	for _, ue := range list.GetUEListByUID(s.box, s.currentMailbox, 0, 0, nil) {
+		seqNums[ue.ID] = cast.ToUint32(ue.SerialNumber)
 	}
 
-	if group.IsDefaultBox(dest) {
-		return move2defaultbox(s.ctx, mailIds, dest)
+	var uidValidity int
+	var src, destUids []int
+	if ref.ctx.UserID != s.box.UserID {
+		uidValidity, src, destUids, err = s.moveTo(emailList, ref)
 	} else {
-		return move2userbox(s.ctx, mailIds, dest)
+		var uids []int
+		for _, email := range emailList {
+			uids = append(uids, email.UeId)
+		}
+		// 移动后的邮件在目标文件夹中使用新的UID，通过 COPYUID 告诉客户端
+		uidValidity, src, destUids, err = group.MoveUIDs(s.box, uids, ref.name)
 	}
-
-}
-
-func move2defaultbox(ctx *context.Context, mailIds []int, dest string) error {
-	err := group.Move2DefaultBox(ctx, mailIds, dest)
 	if err != nil {
 		return &imap.Error{
 			Type: imap.StatusResponseTypeNo,
 			Text: err.Error(),
 		}
 	}
-	return nil
-}
+	if len(src) == 0 {
+		return nil
+	}
 
-func move2userbox(ctx *context.Context, mailIds []int, dest string) error {
-	groupInfo, err := group.GetGroupByFullPath(ctx, dest)
-	if err != nil {
-		return &imap.Error{
-			Type: imap.StatusResponseTypeNo,
-			Text: err.Error(),
+	var expunged []expungedMessage
+	for _, uid := range src {
+		if seqNum, ok := seqNums[uid]; ok {
+			expunged = append(expunged, expungedMessage{SeqNum: seqNum, UID: imap.UID(cast.ToUint32(uid))})
 		}
 	}
-	if groupInfo == nil || groupInfo.ID == 0 {
-		return &imap.Error{
-			Type: imap.StatusResponseTypeNo,
-			Text: "Group not found",
+	sort.Slice(expunged, func(i, j int) bool { return expunged[i].SeqNum < expunged[j].SeqNum })
+	notifyExpunged(s, expunged)
+
+	if err = w.WriteCopyData(copyData(uidValidity, src, destUids)); err != nil {
+		return err
+	}
+	if w.QResync() {
+		return w.WriteVanished(expungedUIDs(expunged))
+	}
+	// 序号从大到小返回，前面的邮件被移走后不影响后面的序号
+	for i := len(expunged) - 1; i >= 0; i-- {
+		if err := w.WriteExpunge(expunged[i].SeqNum); err != nil {
+			return err
 		}
 	}
+	return nil
+}
 
-	group.MoveMailToGroup(ctx, mailIds, groupInfo.ID)
+// copyData COPYUID 响应，原UID与新UID按顺序对应
+func copyData(uidValidity int, src, dest []int) *imap.CopyData {
+	data := &imap.CopyData{UIDValidity: cast.ToUint32(uidValidity)}
+	for i := range src {
+		data.SourceUIDs.AddNum(imap.UID(cast.ToUint32(src[i])))
+		data.DestUIDs.AddNum(imap.UID(cast.ToUint32(dest[i])))
+	}
+	return data
+}
 
-	return nil
+// moveTo 在不同邮箱之间移动，复制到目标邮箱后从当前文件夹删除
+func (s *serverSession) moveTo(emailList []*response.EmailResponseData, ref *mailboxRef) (int, []int, []int, error) {
+	uidValidity, destUids, err := s.copyTo(emailList, ref)
+	if err != nil {
+		return 0, nil, nil, err
+	}
+	var uidList []int
+	for _, email := range emailList {
+		uidList = append(uidList, email.UeId)
+	}
+	if _, err = del_email.DelByUID(s.box, uidList); err != nil {
+		return 0, nil, nil, err
+	}
+	return uidValidity, uidList, destUids, nil
 }
@@ -1,9 +1,11 @@
 package imap_server
 
 import (
+	"github.com/Jinnrry/pmail/services/acl"
 	"github.com/emersion/go-imap/v2"
 )
 
+// Namespace 其他用户共享给当前用户的文件夹在 Other Users/ 下，共享邮箱在 Shared/ 下
 func (s *serverSession) Namespace() (*imap.NamespaceData, error) {
 	return &imap.NamespaceData{
 		Personal: []imap.NamespaceDescriptor{
@@ -12,5 +14,17 @@ func (s *serverSession) Namespace() (*imap.NamespaceData, error) {
 				Delim:  '/',
 			},
 		},
+		Other: []imap.NamespaceDescriptor{
+			{
+				Prefix: acl.NamespaceOther,
+				Delim:  '/',
+			},
+		},
+		Shared: []imap.NamespaceDescriptor{
+			{
+				Prefix: acl.NamespaceShared,
+				Delim:  '/',
+			},
+		},
 	}, nil
 }
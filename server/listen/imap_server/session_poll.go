@@ -9,12 +9,21 @@ import (
 
 func (s *serverSession) Poll(w *imapserver.UpdateWriter, allowExpunge bool) error {
 
+	ctx := s.ctx
+	if s.box != nil {
+		ctx = s.box
+	}
 	var ue []models.UserEmail
-	db.Instance.Table("user_email").Where("user_id=? and create >=?", s.ctx.UserID, s.connectTime).Find(&ue)
+	db.Instance.Table("user_email").Where("user_id=? and create >=?", ctx.UserID, s.connectTime).Find(&ue)
 
 	if len(ue) > 0 {
 		w.WriteNumMessages(cast.ToUint32(len(ue)))
 	}
 
+	// FETCH、STORE、SEARCH 之后不能发送 EXPUNGE，留到下一个命令
+	if allowExpunge {
+		s.collectVanished()
+		return s.writeExpunged(w)
+	}
 	return nil
 }
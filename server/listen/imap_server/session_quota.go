@@ -0,0 +1,119 @@
+package imap_server
+
+import (
+	"strings"
+
+	"github.com/Jinnrry/pmail/db"
+	"github.com/Jinnrry/pmail/models"
+	"github.com/Jinnrry/pmail/services/acl"
+	"github.com/Jinnrry/pmail/services/quota"
+	"github.com/Jinnrry/pmail/utils/context"
+	"github.com/emersion/go-imap/v2"
+	log "github.com/sirupsen/logrus"
+)
+
+// GetQuota 用户自己的配额根为""，设置了配额的域名各自是一个配额根，
+// 其他用户和共享邮箱的配额根为邮箱在命名空间中的路径，比如 Shared/support
+func (s *serverSession) GetQuota(root string) (*imap.QuotaData, error) {
+	user, prefix, err := s.quotaOwner(root)
+	if err != nil {
+		return nil, err
+	}
+	for _, q := range quota.Roots(s.ctx, user) {
+		if quotaRoot(prefix, q) == root {
+			data := quotaData(prefix, q)
+			return &data, nil
+		}
+	}
+	return nil, &imap.Error{
+		Type: imap.StatusResponseTypeNo,
+		Text: "No such quota root",
+	}
+}
+
+// GetQuotaRoot 邮箱中所有文件夹共用所有者和域名的配额根
+func (s *serverSession) GetQuotaRoot(mailbox string) ([]string, []imap.QuotaData, error) {
+	ref, err := s.mailbox(mailbox)
+	if err != nil {
+		return nil, nil, err
+	}
+	user, err := s.ownerOf(ref)
+	if err != nil {
+		return nil, nil, err
+	}
+	var roots []string
+	var data []imap.QuotaData
+	for _, q := range quota.Roots(s.ctx, user) {
+		roots = append(roots, quotaRoot(ref.prefix, q))
+		data = append(data, quotaData(ref.prefix, q))
+	}
+	return roots, data, nil
+}
+
+// quotaOwner 配额根所属的邮箱，只能查看有权限访问的邮箱的配额
+func (s *serverSession) quotaOwner(root string) (*models.User, string, error) {
+	shared := strings.HasPrefix(root, acl.NamespaceShared)
+	if !shared && !strings.HasPrefix(root, acl.NamespaceOther) {
+		user, err := s.user()
+		return user, "", err
+	}
+	account := strings.TrimPrefix(strings.TrimPrefix(root, acl.NamespaceShared), acl.NamespaceOther)
+	owner := acl.Owner(s.ctx, account, shared)
+	if owner == nil || acl.Rights(s.ctx, owner, "", s.ctx.UserID) == "" {
+		return nil, "", &imap.Error{
+			Type: imap.StatusResponseTypeNo,
+			Text: "No such quota root",
+		}
+	}
+	return owner, acl.Prefix(owner, s.ctx.UserID), nil
+}
+
+func (s *serverSession) user() (*models.User, error) {
+	var user models.User
+	has, err := db.Instance.ID(s.ctx.UserID).Get(&user)
+	if err != nil {
+		log.WithContext(s.ctx).Errorf("SQL Error:%v", err)
+	}
+	if err != nil || !has {
+		return nil, &imap.Error{
+			Type: imap.StatusResponseTypeNo,
+			Text: "User not found",
+		}
+	}
+	return &user, nil
+}
+
+// quotaRoot 其他用户和共享邮箱的用户配额根按邮箱路径命名，域名配额根不变
+func quotaRoot(prefix string, q *quota.Quota) string {
+	if prefix != "" && q.Root == quota.UserRoot {
+		return strings.TrimSuffix(prefix, "/")
+	}
+	return q.Root
+}
+
+// quotaData STORAGE 以1024字节为单位，没有限制的配额根不返回资源
+func quotaData(prefix string, q *quota.Quota) imap.QuotaData {
+	data := imap.QuotaData{
+		Root:      quotaRoot(prefix, q),
+		Resources: map[imap.QuotaResourceType]imap.QuotaResourceData{},
+	}
+	if q.Limit > 0 {
+		data.Resources[imap.QuotaResourceStorage] = imap.QuotaResourceData{
+			Usage: (q.Usage + 1023) / 1024,
+			Limit: q.Limit / 1024,
+		}
+	}
+	return data
+}
+
+// overQuota APPEND、COPY 超出目标邮箱的配额时返回 OVERQUOTA
+func (s *serverSession) overQuota(ctx *context.Context, size int64) error {
+	if q := quota.OverUserID(ctx, ctx.UserID, size); q != nil {
+		return &imap.Error{
+			Type: imap.StatusResponseTypeNo,
+			Code: imap.ResponseCodeOverQuota,
+			Text: "Quota exceeded",
+		}
+	}
+	return nil
+}
@@ -1,28 +1,45 @@
 package imap_server
 
 import (
+	"github.com/Jinnrry/pmail/services/acl"
 	"github.com/Jinnrry/pmail/services/group"
 	"github.com/emersion/go-imap/v2"
 	"strings"
 )
 
 func (s *serverSession) Rename(mailbox, newName string) error {
-	if group.IsDefaultBox(mailbox) {
+	ref, err := s.mailboxWith(mailbox, acl.DeleteMailbox)
+	if err != nil {
+		return err
+	}
+	newRef, err := s.mailboxWith(newName, acl.Create)
+	if err != nil {
+		return err
+	}
+	// 只能在同一个邮箱中重命名
+	if ref.ctx.UserID != newRef.ctx.UserID {
+		return &imap.Error{
+			Type: imap.StatusResponseTypeNo,
+			Text: "Cannot rename mailbox to another user.",
+		}
+	}
+
+	if group.IsDefaultBox(ref.name) {
 		return &imap.Error{
 			Type: imap.StatusResponseTypeNo,
 			Text: "This mailbox does not support rename.",
 		}
 	}
 
-	groupPath := strings.Split(mailbox, "/")
+	groupPath := strings.Split(ref.name, "/")
 
 	oldGroupName := groupPath[len(groupPath)-1]
 
-	newGroupPath := strings.Split(newName, "/")
+	newGroupPath := strings.Split(newRef.name, "/")
 
 	newGroupName := newGroupPath[len(newGroupPath)-1]
 
-	err := group.Rename(s.ctx, oldGroupName, newGroupName)
+	err = group.Rename(ref.ctx, oldGroupName, newGroupName)
 
 	if err != nil {
 		return &imap.Error{
@@ -30,5 +47,6 @@ func (s *serverSession) Rename(mailbox, newName string) error {
 			Text: err.Error(),
 		}
 	}
+	acl.RenameFolder(ref.ctx, ref.ctx.UserID, ref.name, newRef.name)
 	return nil
 }
@@ -15,7 +15,7 @@ func (s *serverSession) Search(kind imapserver.NumKind, criteria *imap.SearchCri
 	log.WithContext(s.ctx).Debugf("IMAP SEARCH: mailbox=%s, kind=%v, criteria=%+v", s.currentMailbox, kind, criteria)
 
 	// Use the new comprehensive search function
-	retList, err := list.SearchEmails(s.ctx, s.currentMailbox, criteria)
+	retList, err := list.SearchEmails(s.box, s.currentMailbox, criteria)
 	if err != nil {
 		log.WithContext(s.ctx).Errorf("IMAP SEARCH error: %v", err)
 		return nil, err
@@ -88,6 +88,13 @@ func (s *serverSession) Search(kind imapserver.NumKind, criteria *imap.SearchCri
 		}
 	}
 
+	// CONDSTORE: 使用了MODSEQ条件时返回结果中最大的修改序号
+	if criteria.ModSeq != nil {
+		for _, data := range retList {
+			ret.ModSeq = max(ret.ModSeq, uint64(max(data.ModSeq, 1)))
+		}
+	}
+
 	log.WithContext(s.ctx).Debugf("IMAP SEARCH result: count=%d", ret.Count)
 	return ret, nil
 }
@@ -1,7 +1,9 @@
 package imap_server
 
 import (
+	"github.com/Jinnrry/pmail/services/acl"
 	"github.com/Jinnrry/pmail/services/group"
+	"github.com/Jinnrry/pmail/services/list"
 	"github.com/emersion/go-imap/v2"
 	"github.com/spf13/cast"
 	"strings"
@@ -15,16 +17,44 @@ func (s *serverSession) Select(mailbox string, options *imap.SelectOptions) (*im
 		}
 	}
 
-	paths := strings.Split(mailbox, "/")
+	ref, err := s.mailboxWith(mailbox, acl.Read)
+	if err != nil {
+		return nil, err
+	}
+
+	paths := strings.Split(ref.name, "/")
 	s.currentMailbox = strings.Trim(paths[len(paths)-1], `"`)
-	_, data := group.GetGroupStatus(s.ctx, s.currentMailbox, []string{"MESSAGES", "UNSEEN", "UIDNEXT", "UIDVALIDITY"})
+	s.box = ref.ctx
+	s.rights = ref.rights
+	// 没有任何修改权限时按只读打开
+	s.readOnly = options.ReadOnly || !acl.HasAny(ref.rights, acl.Seen+acl.Write+acl.DeleteMessages+acl.Expunge)
+	_, data := group.GetGroupStatus(s.box, s.currentMailbox, []string{"MESSAGES", "UNSEEN", "UIDNEXT", "UIDVALIDITY", "HIGHESTMODSEQ"})
+	s.mu.Lock()
+	s.expunged, s.reported = nil, map[imap.UID]bool{}
+	s.vanishedSeq = cast.ToInt64(data["HIGHESTMODSEQ"])
+	s.mu.Unlock()
+	registerSelected(s)
+
+	flags := []imap.Flag{imap.FlagSeen, imap.FlagAnswered, imap.FlagFlagged, imap.FlagDeleted, imap.FlagDraft}
+	// 文件夹中正在使用的关键字也作为可用的标志返回给客户端
+	keywords := map[string]bool{}
+	for _, ue := range list.GetUEListByUID(s.box, s.currentMailbox, 0, 0, nil) {
+		for _, k := range strings.Fields(ue.Keywords) {
+			if !keywords[strings.ToLower(k)] {
+				keywords[strings.ToLower(k)] = true
+				flags = append(flags, imap.Flag(k))
+			}
+		}
+	}
 
 	ret := &imap.SelectData{
-		Flags:          []imap.Flag{imap.FlagSeen},
-		PermanentFlags: []imap.Flag{imap.FlagSeen},
+		Flags:          flags,
+		PermanentFlags: append(append([]imap.Flag{}, flags...), imap.FlagWildcard),
 		NumMessages:    cast.ToUint32(data["MESSAGES"]),
 		UIDNext:        imap.UID(data["UIDNEXT"]),
 		UIDValidity:    cast.ToUint32(data["UIDVALIDITY"]),
+		HighestModSeq:  cast.ToUint64(data["HIGHESTMODSEQ"]),
+		ReadOnly:       s.readOnly,
 	}
 
 	return ret, nil
@@ -1,12 +1,18 @@
 package imap_server
 
 import (
+	"github.com/Jinnrry/pmail/services/acl"
 	"github.com/Jinnrry/pmail/services/group"
 	"github.com/emersion/go-imap/v2"
 	"github.com/spf13/cast"
 )
 
 func (s *serverSession) Status(mailbox string, options *imap.StatusOptions) (*imap.StatusData, error) {
+	ref, err := s.mailboxWith(mailbox, acl.Read)
+	if err != nil {
+		return nil, err
+	}
+
 	category := []string{}
 	if options.UIDNext {
 		category = append(category, "UIDNEXT")
@@ -20,8 +26,11 @@ func (s *serverSession) Status(mailbox string, options *imap.StatusOptions) (*im
 	if options.NumUnseen {
 		category = append(category, "UNSEEN")
 	}
+	if options.HighestModSeq {
+		category = append(category, "HIGHESTMODSEQ")
+	}
 
-	_, data := group.GetGroupStatus(s.ctx, mailbox, category)
+	_, data := group.GetGroupStatus(ref.ctx, ref.name, category)
 
 	numMessages := cast.ToUint32(data["MESSAGES"])
 	numUnseen := cast.ToUint32(data["UNSEEN"])
@@ -34,6 +43,8 @@ func (s *serverSession) Status(mailbox string, options *imap.StatusOptions) (*im
 		UIDNext:     imap.UID(numUIDNext),
 		UIDValidity: numValidity,
 		NumUnseen:   &numUnseen,
+
+		HighestModSeq: cast.ToUint64(data["HIGHESTMODSEQ"]),
 	}
 
 	return ret, nil
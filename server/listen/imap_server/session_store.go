@@ -2,20 +2,27 @@ package imap_server
 
 import (
 	"github.com/Jinnrry/pmail/dto/response"
-	"github.com/Jinnrry/pmail/services/del_email"
-	"github.com/Jinnrry/pmail/services/detail"
+	"github.com/Jinnrry/pmail/models"
+	"github.com/Jinnrry/pmail/services/acl"
+	"github.com/Jinnrry/pmail/services/flag"
 	"github.com/Jinnrry/pmail/services/list"
-	"github.com/Jinnrry/pmail/utils/array"
 	"github.com/emersion/go-imap/v2"
 	"github.com/emersion/go-imap/v2/imapserver"
 	"github.com/spf13/cast"
 )
 
 func (s *serverSession) Store(w *imapserver.FetchWriter, numSet imap.NumSet, flags *imap.StoreFlags, options *imap.StoreOptions) error {
-
-	if flags.Op == imap.StoreFlagsSet {
-		return nil
+	if s.readOnly {
+		return &imap.Error{
+			Type: imap.StatusResponseTypeNo,
+			Text: "Mailbox is read-only",
+		}
 	}
+	// 替换全部标志需要修改所有标志的权限，添加和删除时忽略没有权限的标志
+	if flags.Op == imap.StoreFlagsSet && !acl.Has(s.rights, acl.Seen+acl.Write+acl.DeleteMessages) {
+		return errNoPerm
+	}
+	allowed := allowedFlags(s.rights, flags.Flags)
 
 	var emailList []*response.EmailResponseData
 
@@ -23,7 +30,7 @@ func (s *serverSession) Store(w *imapserver.FetchWriter, numSet imap.NumSet, fla
 	case imap.SeqSet:
 		seqSet := numSet.(imap.SeqSet)
 		for _, seq := range seqSet {
-			res := list.GetEmailListByGroup(s.ctx, s.currentMailbox, list.ImapListReq{
+			res := list.GetEmailListByGroup(s.box, s.currentMailbox, list.ImapListReq{
 				Star: cast.ToInt(seq.Start),
 				End:  cast.ToInt(seq.Stop),
 			}, false)
@@ -33,7 +40,7 @@ func (s *serverSession) Store(w *imapserver.FetchWriter, numSet imap.NumSet, fla
 	case imap.UIDSet:
 		uidSet := numSet.(imap.UIDSet)
 		for _, uid := range uidSet {
-			res := list.GetEmailListByGroup(s.ctx, s.currentMailbox, list.ImapListReq{
+			res := list.GetEmailListByGroup(s.box, s.currentMailbox, list.ImapListReq{
 				Star: cast.ToInt(uint32(uid.Start)),
 				End:  cast.ToInt(uint32(uid.Stop)),
 			}, true)
@@ -41,27 +48,98 @@ func (s *serverSession) Store(w *imapserver.FetchWriter, numSet imap.NumSet, fla
 		}
 	}
 
-	if array.InArray(imap.FlagSeen, flags.Flags) && flags.Op == imap.StoreFlagsAdd {
-		for _, data := range emailList {
-			detail.MakeRead(s.ctx, data.Id, flags.Op == imap.StoreFlagsAdd)
-		}
+	if options.UnchangedSince > 0 {
+		emailList = s.unchangedSince(w, numSet, emailList, options.UnchangedSince)
 	}
 
-	if array.InArray(imap.FlagDeleted, flags.Flags) && flags.Op == imap.StoreFlagsAdd {
-		for _, data := range emailList {
-			s.deleteUidList = append(s.deleteUidList, data.UeId)
-		}
+	var ueIds []int
+	for _, data := range emailList {
+		ueIds = append(ueIds, data.UeId)
 	}
-
-	err := del_email.DelByUID(s.ctx, s.deleteUidList)
-	s.deleteUidList = []int{}
-
-	if err != nil {
+	var flagList []string
+	for _, f := range allowed {
+		flagList = append(flagList, string(f))
+	}
+	op := flag.OpSet
+	switch flags.Op {
+	case imap.StoreFlagsAdd:
+		op = flag.OpAdd
+	case imap.StoreFlagsDel:
+		op = flag.OpRemove
+	}
+	if err := flag.Store(s.box, ueIds, op, flagList); err != nil {
 		return &imap.Error{
 			Type: imap.StatusResponseTypeNo,
 			Text: err.Error(),
 		}
 	}
 
+	if !flags.Silent || w.CondStore() {
+		s.writeStoreResult(w, emailList)
+	}
+
 	return nil
 }
+
+// unchangedSince 去掉修改序号大于 unchangedSince 的邮件，这些邮件通过 MODIFIED 响应码返回给客户端
+func (s *serverSession) unchangedSince(w *imapserver.FetchWriter, numSet imap.NumSet, emailList []*response.EmailResponseData, unchangedSince uint64) []*response.EmailResponseData {
+	var ret []*response.EmailResponseData
+	seqSet := imap.SeqSet{}
+	uidSet := imap.UIDSet{}
+	for _, email := range emailList {
+		if uint64(max(email.ModSeq, 1)) <= unchangedSince {
+			ret = append(ret, email)
+			continue
+		}
+		seqSet.AddNum(cast.ToUint32(email.SerialNumber))
+		uidSet.AddNum(imap.UID(cast.ToUint32(email.UeId)))
+	}
+	if _, ok := numSet.(imap.UIDSet); ok {
+		if len(uidSet) > 0 {
+			w.WriteModified(uidSet)
+		}
+	} else if len(seqSet) > 0 {
+		w.WriteModified(seqSet)
+	}
+	return ret
+}
+
+// writeStoreResult 返回修改后的标志，客户端开启CONDSTORE后同时返回UID与修改序号
+func (s *serverSession) writeStoreResult(w *imapserver.FetchWriter, emailList []*response.EmailResponseData) {
+	var uids []int
+	for _, email := range emailList {
+		uids = append(uids, email.UeId)
+	}
+	if len(uids) == 0 {
+		return
+	}
+	current := map[int]*response.UserEmailUIDData{}
+	for _, ue := range list.GetUEListByUID(s.box, s.currentMailbox, 0, 0, nil) {
+		current[ue.ID] = ue
+	}
+	for _, uid := range uids {
+		ue, ok := current[uid]
+		if !ok {
+			// 已经被删除
+			continue
+		}
+		writer := w.CreateMessage(cast.ToUint32(ue.SerialNumber))
+		if w.CondStore() {
+			writer.WriteUID(imap.UID(cast.ToUint32(ue.ID)))
+		}
+		writer.WriteFlags(imapFlags(&ue.UserEmail))
+		if w.CondStore() {
+			writer.WriteModSeq(uint64(max(ue.ModSeq, 1)))
+		}
+		writer.Close()
+	}
+}
+
+// imapFlags 邮件的全部标志
+func imapFlags(ue *models.UserEmail) []imap.Flag {
+	ret := []imap.Flag{}
+	for _, f := range flag.Flags(ue) {
+		ret = append(ret, imap.Flag(f))
+	}
+	return ret
+}
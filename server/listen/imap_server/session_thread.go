@@ -0,0 +1,95 @@
+package imap_server
+
+import (
+	"strings"
+
+	"github.com/Jinnrry/pmail/db"
+	"github.com/Jinnrry/pmail/models"
+	"github.com/Jinnrry/pmail/services/list"
+	"github.com/Jinnrry/pmail/services/thread"
+	"github.com/emersion/go-imap/v2"
+	"github.com/emersion/go-imap/v2/imapserver"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cast"
+	"xorm.io/builder"
+)
+
+// Thread 实现 RFC 5256 的 THREAD 命令，先按搜索条件筛选邮件，再按指定算法组织成会话
+func (s *serverSession) Thread(kind imapserver.NumKind, algorithm imap.ThreadAlgorithm, criteria *imap.SearchCriteria) ([]imap.ThreadData, error) {
+	retList, err := list.SearchEmails(s.box, s.currentMailbox, criteria)
+	if err != nil {
+		log.WithContext(s.ctx).Errorf("IMAP THREAD error: %v", err)
+		return nil, err
+	}
+
+	var emailIds []int
+	for _, item := range retList {
+		emailIds = append(emailIds, item.EmailID)
+	}
+	emails := map[int]*models.Email{}
+	for i := 0; i < len(emailIds); i += 500 {
+		var rows []*models.Email
+		err = db.Instance.Cols("id", "msg_id", "in_reply_to", "refs", "subject", "send_date", "create_time").
+			Where(builder.In("id", emailIds[i:min(i+500, len(emailIds))])).Find(&rows)
+		if err != nil {
+			log.WithContext(s.ctx).Errorf("IMAP THREAD error: %v", err)
+			return nil, err
+		}
+		for _, row := range rows {
+			emails[row.Id] = row
+		}
+	}
+
+	var msgs []*thread.Message
+	for _, item := range retList {
+		email := emails[item.EmailID]
+		if email == nil {
+			continue
+		}
+		// 按邮件的发送时间排序，没有Date头时使用收到的时间
+		date := email.SendDate
+		if date.IsZero() {
+			date = email.CreateTime
+		}
+		num := cast.ToUint32(item.SerialNumber)
+		if kind == imapserver.NumKindUID {
+			num = cast.ToUint32(item.ID)
+		}
+		msgs = append(msgs, &thread.Message{
+			Num:        num,
+			MsgID:      email.MsgID,
+			InReplyTo:  email.InReplyTo,
+			References: strings.Fields(email.References),
+			Subject:    email.Subject,
+			Date:       date,
+		})
+	}
+
+	var roots []*thread.Node
+	if algorithm == imap.ThreadOrderedSubject {
+		roots = thread.OrderedSubject(msgs)
+	} else {
+		roots = thread.References(msgs)
+	}
+	var ret []imap.ThreadData
+	for _, root := range roots {
+		ret = append(ret, threadData(root))
+	}
+	return ret, nil
+}
+
+// threadData 把会话树转换成 THREAD 响应，只有一个子节点的邮件连成一条链
+func threadData(node *thread.Node) imap.ThreadData {
+	var data imap.ThreadData
+	for node.Message != nil {
+		data.Chain = append(data.Chain, node.Message.Num)
+		if len(node.Children) != 1 {
+			break
+		}
+		node = node.Children[0]
+	}
+	for _, child := range node.Children {
+		data.SubThreads = append(data.SubThreads, threadData(child))
+	}
+	return data
+}
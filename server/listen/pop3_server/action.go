@@ -190,7 +190,7 @@ func (a action) Uidl(session *gopop.Session, msg string) ([]gopop.UidlItem, erro
 
 	var res []listItem
 
-	emailList, _ := list.GetEmailList(session.Ctx.(*context.Context), dto.SearchTag{Type: consts.EmailTypeReceive, Status: -1, GroupId: -1}, "", true, 0, 99999)
+	emailList, _ := list.GetEmailList(session.Ctx.(*context.Context), dto.SearchTag{Type: consts.EmailTypeReceive, Status: -1, GroupId: -1}, nil, true, 0, 99999)
 	for _, info := range emailList {
 		res = append(res, listItem{
 			Id:   cast.ToInt64(info.Id),
@@ -240,7 +240,7 @@ func (a action) List(session *gopop.Session, msg string) ([]gopop.MailInfo, erro
 		}
 		res = append(res, item)
 	} else {
-		emailList, _ := list.GetEmailList(session.Ctx.(*context.Context), dto.SearchTag{Type: consts.EmailTypeReceive, Status: -1, GroupId: -1}, "", true, 0, 99999)
+		emailList, _ := list.GetEmailList(session.Ctx.(*context.Context), dto.SearchTag{Type: consts.EmailTypeReceive, Status: -1, GroupId: -1}, nil, true, 0, 99999)
 		for _, info := range emailList {
 			item := listItem{
 				Id:   cast.ToInt64(info.Id),
@@ -0,0 +1,164 @@
+package sieve_server
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// maxLiteral 客户端发送的字符串最大长度，比脚本大小限制稍大
+const maxLiteral = 2 << 20
+
+// maxLine 命令行中非字面量部分的最大长度
+const maxLine = 8 * 1024
+
+var errSyntax = errors.New("syntax error")
+
+// readLine 读取一行命令，返回命令名称（大写）与参数，字符串参数可以是带引号的字符串或者字面量
+func readLine(r *bufio.Reader) (string, []string, error) {
+	var (
+		name  string
+		args  []string
+		count int
+	)
+	for {
+		c, err := r.ReadByte()
+		if err != nil {
+			return "", nil, err
+		}
+		count++
+		if count > maxLine {
+			return "", nil, errSyntax
+		}
+		switch {
+		case c == ' ':
+		case c == '\r':
+			c, err = r.ReadByte()
+			if err != nil {
+				return "", nil, err
+			}
+			if c != '\n' {
+				return "", nil, errSyntax
+			}
+			return name, args, nil
+		case c == '\n':
+			return name, args, nil
+		case c == '"':
+			s, err := readQuoted(r)
+			if err != nil {
+				return "", nil, err
+			}
+			args = append(args, s)
+		case c == '{':
+			s, err := readLiteral(r)
+			if err != nil {
+				return "", nil, err
+			}
+			args = append(args, s)
+		default:
+			r.UnreadByte()
+			atom, err := readAtom(r)
+			if err != nil {
+				return "", nil, err
+			}
+			if name != "" || len(args) > 0 {
+				// 参数中的原子只可能是数字，比如 HAVESPACE 的大小
+				args = append(args, atom)
+			} else {
+				name = strings.ToUpper(atom)
+			}
+		}
+	}
+}
+
+func readAtom(r *bufio.Reader) (string, error) {
+	var b strings.Builder
+	for {
+		c, err := r.ReadByte()
+		if err != nil {
+			return "", err
+		}
+		if c == ' ' || c == '\r' || c == '\n' {
+			r.UnreadByte()
+			return b.String(), nil
+		}
+		if c == '"' || c == '{' || c < 0x20 || c == 0x7f || b.Len() > 64 {
+			return "", errSyntax
+		}
+		b.WriteByte(c)
+	}
+}
+
+func readQuoted(r *bufio.Reader) (string, error) {
+	var b strings.Builder
+	for {
+		c, err := r.ReadByte()
+		if err != nil {
+			return "", err
+		}
+		switch c {
+		case '"':
+			return b.String(), nil
+		case '\\':
+			c, err = r.ReadByte()
+			if err != nil {
+				return "", err
+			}
+			if c != '"' && c != '\\' {
+				return "", errSyntax
+			}
+		case '\r', '\n', 0:
+			return "", errSyntax
+		}
+		if b.Len() >= maxLine {
+			return "", errSyntax
+		}
+		b.WriteByte(c)
+	}
+}
+
+// readLiteral 读取 {n+} 或 {n} 形式的字面量，左括号已经读取
+func readLiteral(r *bufio.Reader) (string, error) {
+	head, err := r.ReadString('}')
+	if err != nil {
+		return "", err
+	}
+	head = strings.TrimSuffix(strings.TrimSuffix(head, "}"), "+")
+	size, err := strconv.Atoi(head)
+	if err != nil || size < 0 {
+		return "", errSyntax
+	}
+	if size > maxLiteral {
+		return "", errTooLarge
+	}
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	if strings.TrimSuffix(line, "\r\n") != "" && strings.TrimSuffix(line, "\n") != "" {
+		return "", errSyntax
+	}
+	buf := make([]byte, size)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return "", err
+	}
+	return string(buf), nil
+}
+
+var errTooLarge = errors.New("literal too large")
+
+// quoteString 返回协议中的字符串，含有换行或者过长时使用字面量
+func quoteString(s string) string {
+	if len(s) > 1024 || strings.ContainsAny(s, "\r\n\x00") {
+		return fmt.Sprintf("{%d}\r\n%s", len(s), s)
+	}
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s) + `"`
+}
+
+// oneLine 错误信息中的换行替换为空格，便于使用带引号的字符串返回
+func oneLine(s string) string {
+	return strings.Join(strings.Fields(s), " ")
+}
@@ -0,0 +1,369 @@
+package sieve_server
+
+import (
+	"bufio"
+	"crypto/tls"
+	"database/sql"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Jinnrry/pmail/db"
+	"github.com/Jinnrry/pmail/models"
+	"github.com/Jinnrry/pmail/services/sieve"
+	"github.com/Jinnrry/pmail/utils/context"
+	"github.com/Jinnrry/pmail/utils/id"
+	"github.com/Jinnrry/pmail/utils/password"
+	sievelib "github.com/Jinnrry/pmail/utils/sieve"
+	log "github.com/sirupsen/logrus"
+)
+
+// idleTimeout 客户端两条命令之间的最长等待时间
+const idleTimeout = 10 * time.Minute
+
+// maxAuthFailures 连续登录失败次数超过后断开连接
+const maxAuthFailures = 3
+
+type session struct {
+	srv          *server
+	conn         net.Conn
+	r            *bufio.Reader
+	w            *bufio.Writer
+	ctx          *context.Context
+	tls          bool
+	authFailures int
+}
+
+func newSession(srv *server, conn net.Conn) *session {
+	ctx := &context.Context{}
+	ctx.SetValue(context.LogID, id.GenLogID())
+	_, isTls := conn.(*tls.Conn)
+	return &session{
+		srv:  srv,
+		conn: conn,
+		r:    bufio.NewReader(conn),
+		w:    bufio.NewWriter(conn),
+		ctx:  ctx,
+		tls:  isTls,
+	}
+}
+
+func (s *session) authenticated() bool {
+	return s.ctx.UserID > 0
+}
+
+func (s *session) serve() {
+	defer s.conn.Close()
+	s.capabilities()
+	s.w.Flush()
+	for {
+		s.conn.SetReadDeadline(time.Now().Add(idleTimeout))
+		name, args, err := readLine(s.r)
+		if err != nil {
+			if errors.Is(err, errSyntax) || errors.Is(err, errTooLarge) {
+				s.bye(err.Error())
+			} else if !errors.Is(err, io.EOF) && !errors.Is(err, net.ErrClosed) {
+				log.WithContext(s.ctx).Debugf("ManageSieve read error: %v", err)
+			}
+			return
+		}
+		if name == "" {
+			continue
+		}
+		if !s.handle(name, args) {
+			s.w.Flush()
+			return
+		}
+		if err := s.w.Flush(); err != nil {
+			return
+		}
+	}
+}
+
+func (s *session) ok(code, text string) {
+	s.response("OK", code, text)
+}
+
+func (s *session) no(code, text string) {
+	s.response("NO", code, text)
+}
+
+func (s *session) bye(text string) {
+	s.response("BYE", "", text)
+	s.w.Flush()
+}
+
+func (s *session) response(status, code, text string) {
+	s.w.WriteString(status)
+	if code != "" {
+		s.w.WriteString(" (" + code + ")")
+	}
+	if text != "" {
+		s.w.WriteString(" " + quoteString(oneLine(text)))
+	}
+	s.w.WriteString("\r\n")
+}
+
+func (s *session) capabilities() {
+	sasl := ""
+	if s.tls || s.srv.insecureAuth {
+		sasl = "PLAIN"
+	}
+	fmt.Fprintf(s.w, "\"IMPLEMENTATION\" \"PMail\"\r\n")
+	fmt.Fprintf(s.w, "\"SASL\" %s\r\n", quoteString(sasl))
+	fmt.Fprintf(s.w, "\"SIEVE\" %s\r\n", quoteString(strings.Join(sievelib.Extensions(), " ")))
+	if !s.tls && s.srv.tlsConfig != nil {
+		fmt.Fprintf(s.w, "\"STARTTLS\"\r\n")
+	}
+	fmt.Fprintf(s.w, "\"MAXREDIRECTS\" \"5\"\r\n")
+	fmt.Fprintf(s.w, "\"VERSION\" \"1.0\"\r\n")
+	s.ok("", "ManageSieve ready")
+}
+
+// handle 执行一条命令，返回false时关闭连接
+func (s *session) handle(name string, args []string) bool {
+	switch name {
+	case "CAPABILITY":
+		s.capabilities()
+		return true
+	case "LOGOUT":
+		s.ok("", "Logout complete")
+		return false
+	case "NOOP":
+		if len(args) > 0 {
+			s.ok("TAG "+quoteString(args[0]), "Done")
+		} else {
+			s.ok("", "Done")
+		}
+		return true
+	case "STARTTLS":
+		return s.startTLS()
+	case "AUTHENTICATE":
+		return s.authenticate(args)
+	}
+
+	if !s.authenticated() {
+		s.no("", "Authenticate first")
+		return true
+	}
+
+	uid := s.ctx.UserID
+	switch name {
+	case "UNAUTHENTICATE":
+		s.ctx.UserID, s.ctx.UserAccount, s.ctx.UserName = 0, "", ""
+		s.ok("", "Unauthenticated")
+	case "HAVESPACE":
+		if len(args) != 2 {
+			s.no("", "Usage: HAVESPACE name size")
+			break
+		}
+		size, err := strconv.ParseInt(args[1], 10, 64)
+		if err != nil || size < 0 {
+			s.no("", "Invalid size")
+			break
+		}
+		if size > sieve.MaxScriptSize {
+			s.no("QUOTA/MAXSIZE", "Script too large")
+			break
+		}
+		ok, err := sieve.HaveSpace(s.ctx, uid, args[0], size)
+		if err != nil {
+			s.no("TRYLATER", "Internal error")
+		} else if !ok {
+			s.no("QUOTA/MAXSCRIPTS", "Too many scripts")
+		} else {
+			s.ok("", "Putscript would succeed")
+		}
+	case "PUTSCRIPT":
+		if len(args) != 2 {
+			s.no("", "Usage: PUTSCRIPT name script")
+			break
+		}
+		s.result(sieve.Put(s.ctx, uid, args[0], args[1]), "Script saved")
+	case "CHECKSCRIPT":
+		if len(args) != 1 {
+			s.no("", "Usage: CHECKSCRIPT script")
+			break
+		}
+		s.result(sieve.Check(args[0]), "Script is valid")
+	case "LISTSCRIPTS":
+		scripts, err := sieve.List(s.ctx, uid)
+		if err != nil {
+			s.no("TRYLATER", "Internal error")
+			break
+		}
+		for _, script := range scripts {
+			s.w.WriteString(quoteString(script.Name))
+			if script.Active == 1 {
+				s.w.WriteString(" ACTIVE")
+			}
+			s.w.WriteString("\r\n")
+		}
+		s.ok("", "Listscripts completed")
+	case "SETACTIVE":
+		if len(args) != 1 {
+			s.no("", "Usage: SETACTIVE name")
+			break
+		}
+		s.result(sieve.SetActive(s.ctx, uid, args[0]), "Active script set")
+	case "GETSCRIPT":
+		if len(args) != 1 {
+			s.no("", "Usage: GETSCRIPT name")
+			break
+		}
+		script, err := sieve.Get(s.ctx, uid, args[0])
+		if err != nil {
+			s.no("TRYLATER", "Internal error")
+		} else if script == nil {
+			s.no("NONEXISTENT", "Script does not exist")
+		} else {
+			fmt.Fprintf(s.w, "{%d}\r\n%s\r\n", len(script.Content), script.Content)
+			s.ok("", "Getscript completed")
+		}
+	case "DELETESCRIPT":
+		if len(args) != 1 {
+			s.no("", "Usage: DELETESCRIPT name")
+			break
+		}
+		s.result(sieve.Delete(s.ctx, uid, args[0]), "Script deleted")
+	case "RENAMESCRIPT":
+		if len(args) != 2 {
+			s.no("", "Usage: RENAMESCRIPT old new")
+			break
+		}
+		s.result(sieve.Rename(s.ctx, uid, args[0], args[1]), "Script renamed")
+	default:
+		s.no("", "Unknown command "+name)
+	}
+	return true
+}
+
+// result 把脚本服务返回的错误转换为对应的响应码
+func (s *session) result(err error, okText string) {
+	var syntaxErr *sievelib.Error
+	switch {
+	case err == nil:
+		s.ok("", okText)
+	case errors.As(err, &syntaxErr):
+		s.no("", syntaxErr.Error())
+	case errors.Is(err, sieve.ErrNotFound):
+		s.no("NONEXISTENT", "Script does not exist")
+	case errors.Is(err, sieve.ErrActive):
+		s.no("ACTIVE", "Script is active")
+	case errors.Is(err, sieve.ErrExists):
+		s.no("ALREADYEXISTS", "Script already exists")
+	case errors.Is(err, sieve.ErrQuota):
+		s.no("QUOTA", "Quota exceeded")
+	case errors.Is(err, sieve.ErrInvalidName):
+		s.no("", "Invalid script name")
+	default:
+		log.WithContext(s.ctx).Errorf("ManageSieve error: %+v", err)
+		s.no("TRYLATER", "Internal error")
+	}
+}
+
+func (s *session) startTLS() bool {
+	if s.tls || s.srv.tlsConfig == nil {
+		s.no("", "STARTTLS not available")
+		return true
+	}
+	s.ok("", "Begin TLS negotiation now")
+	s.w.Flush()
+	conn := tls.Server(s.conn, s.srv.tlsConfig)
+	conn.SetDeadline(time.Now().Add(30 * time.Second))
+	if err := conn.Handshake(); err != nil {
+		log.WithContext(s.ctx).Debugf("ManageSieve TLS handshake error: %v", err)
+		return false
+	}
+	conn.SetDeadline(time.Time{})
+	s.conn = conn
+	s.r = bufio.NewReader(conn)
+	s.w = bufio.NewWriter(conn)
+	s.tls = true
+	s.capabilities()
+	return true
+}
+
+// authenticate 只支持 SASL PLAIN，没有初始响应时发送空的继续请求
+func (s *session) authenticate(args []string) bool {
+	if s.authenticated() {
+		s.no("", "Already authenticated")
+		return true
+	}
+	if !s.tls && !s.srv.insecureAuth {
+		s.no("ENCRYPT-NEEDED", "Use STARTTLS first")
+		return true
+	}
+	if len(args) == 0 || !strings.EqualFold(args[0], "PLAIN") {
+		s.no("", "Unsupported SASL mechanism")
+		return true
+	}
+	var response string
+	if len(args) > 1 {
+		response = args[1]
+	} else {
+		s.w.WriteString("\"\"\r\n")
+		s.w.Flush()
+		_, cont, err := readLine(s.r)
+		if err != nil || len(cont) != 1 {
+			s.no("", "Authentication failed")
+			return err == nil
+		}
+		if cont[0] == "*" {
+			s.no("", "Authentication aborted")
+			return true
+		}
+		response = cont[0]
+	}
+
+	if s.login(response) {
+		s.ok("", "Logged in")
+		return true
+	}
+	s.authFailures++
+	if s.authFailures >= maxAuthFailures {
+		s.bye("Too many authentication failures")
+		return false
+	}
+	// 登录失败时延迟响应，降低暴力破解的速度
+	time.Sleep(time.Duration(s.authFailures) * 500 * time.Millisecond)
+	s.no("", "Authentication failed")
+	return true
+}
+
+func (s *session) login(response string) bool {
+	data, err := base64.StdEncoding.DecodeString(response)
+	if err != nil {
+		return false
+	}
+	parts := strings.Split(string(data), "\x00")
+	if len(parts) != 3 {
+		return false
+	}
+	authzid, username, pwd := parts[0], parts[1], parts[2]
+	if authzid != "" && authzid != username {
+		return false
+	}
+	if strings.Contains(username, "@") {
+		username = strings.Split(username, "@")[0]
+	}
+
+	var user models.User
+	_, err = db.Instance.Where("account =? and password =? and disabled = 0", username, password.Encode(pwd)).Get(&user)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		log.WithContext(s.ctx).Errorf("%+v", err)
+	}
+	if user.ID == 0 {
+		log.WithContext(s.ctx).Info("ManageSieve login failed")
+		return false
+	}
+	s.ctx.UserID = user.ID
+	s.ctx.UserAccount = user.Account
+	s.ctx.UserName = user.Name
+	return true
+}
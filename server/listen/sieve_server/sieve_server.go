@@ -0,0 +1,102 @@
+// Package sieve_server 实现 ManageSieve 协议（RFC 5804），用于客户端上传、管理Sieve脚本
+package sieve_server
+
+import (
+	"crypto/rand"
+	"crypto/tls"
+	"errors"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/Jinnrry/pmail/config"
+	log "github.com/sirupsen/logrus"
+)
+
+type server struct {
+	listener  net.Listener
+	tlsConfig *tls.Config
+	// insecureAuth 允许未加密的连接登录，仅用于测试
+	insecureAuth bool
+
+	mu     sync.Mutex
+	conns  map[net.Conn]bool
+	closed bool
+}
+
+var instance *server
+
+// Start 启动ManageSieve服务，未配置监听地址时不启动
+func Start() {
+	if config.Instance.ManageSieveAddress == "" {
+		return
+	}
+	crt, err := tls.LoadX509KeyPair(config.Instance.SSLPublicKeyPath, config.Instance.SSLPrivateKeyPath)
+	if err != nil {
+		log.Errorf("ManageSieve Server Error: %v", err)
+		return
+	}
+	tlsConfig := &tls.Config{}
+	tlsConfig.Certificates = []tls.Certificate{crt}
+	tlsConfig.Time = time.Now
+	tlsConfig.Rand = rand.Reader
+
+	ln, err := net.Listen("tcp", config.Instance.ManageSieveAddress)
+	if err != nil {
+		log.Errorf("ManageSieve Server Error: %v", err)
+		return
+	}
+	instance = newServer(ln, tlsConfig)
+	log.Infof("ManageSieve Server Start On %s", config.Instance.ManageSieveAddress)
+	instance.serve()
+}
+
+func Stop() {
+	if instance != nil {
+		instance.close()
+		instance = nil
+	}
+}
+
+func newServer(ln net.Listener, tlsConfig *tls.Config) *server {
+	return &server{listener: ln, tlsConfig: tlsConfig, conns: map[net.Conn]bool{}}
+}
+
+func (s *server) serve() {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			log.Errorf("ManageSieve Accept Error: %v", err)
+			time.Sleep(100 * time.Millisecond)
+			continue
+		}
+		s.mu.Lock()
+		if s.closed {
+			s.mu.Unlock()
+			conn.Close()
+			return
+		}
+		s.conns[conn] = true
+		s.mu.Unlock()
+
+		go func() {
+			newSession(s, conn).serve()
+			s.mu.Lock()
+			delete(s.conns, conn)
+			s.mu.Unlock()
+		}()
+	}
+}
+
+func (s *server) close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closed = true
+	s.listener.Close()
+	for conn := range s.conns {
+		conn.Close()
+	}
+}
@@ -0,0 +1,151 @@
+package sieve_server
+
+import (
+	"bufio"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Jinnrry/pmail/db"
+	"github.com/Jinnrry/pmail/db/dbtest"
+	"github.com/Jinnrry/pmail/models"
+	"github.com/Jinnrry/pmail/utils/password"
+)
+
+type client struct {
+	t    *testing.T
+	conn net.Conn
+	r    *bufio.Reader
+}
+
+// readResponse 读取到 OK、NO 或 BYE 为止的全部行
+func (c *client) readResponse() []string {
+	c.t.Helper()
+	var lines []string
+	for {
+		line, err := c.r.ReadString('\n')
+		if err != nil {
+			c.t.Fatalf("read error: %v, lines %q", err, lines)
+		}
+		line = strings.TrimSuffix(line, "\r\n")
+		lines = append(lines, line)
+		if strings.HasPrefix(line, "OK") || strings.HasPrefix(line, "NO") || strings.HasPrefix(line, "BYE") {
+			return lines
+		}
+	}
+}
+
+func (c *client) cmd(line string) []string {
+	c.t.Helper()
+	fmt.Fprintf(c.conn, "%s\r\n", line)
+	return c.readResponse()
+}
+
+func last(lines []string) string {
+	return lines[len(lines)-1]
+}
+
+func startTestServer(t *testing.T) *client {
+	dbtest.Init(t)
+	if _, err := db.Instance.Insert(&models.User{Account: "alice", Name: "Alice", Password: password.Encode("secret")}); err != nil {
+		t.Fatal(err)
+	}
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := newServer(ln, nil)
+	srv.insecureAuth = true
+	go srv.serve()
+	t.Cleanup(srv.close)
+
+	conn, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.SetDeadline(time.Now().Add(10 * time.Second))
+	return &client{t: t, conn: conn, r: bufio.NewReader(conn)}
+}
+
+func TestSession(t *testing.T) {
+	c := startTestServer(t)
+
+	greeting := c.readResponse()
+	if !strings.Contains(strings.Join(greeting, "\n"), `"SIEVE" "body comparator-i;ascii-casemap`) || !strings.HasPrefix(last(greeting), "OK") {
+		t.Fatalf("greeting = %q", greeting)
+	}
+
+	if res := c.cmd(`LISTSCRIPTS`); !strings.HasPrefix(last(res), "NO") {
+		t.Errorf("LISTSCRIPTS before login = %q", res)
+	}
+	bad := base64.StdEncoding.EncodeToString([]byte("\x00alice\x00wrong"))
+	if res := c.cmd(`AUTHENTICATE "PLAIN" "` + bad + `"`); !strings.HasPrefix(last(res), "NO") {
+		t.Errorf("login with wrong password = %q", res)
+	}
+	// 没有初始响应时使用继续请求
+	fmt.Fprintf(c.conn, "AUTHENTICATE \"PLAIN\"\r\n")
+	if line, _ := c.r.ReadString('\n'); line != "\"\"\r\n" {
+		t.Fatalf("continuation = %q", line)
+	}
+	auth := base64.StdEncoding.EncodeToString([]byte("\x00alice@example.com\x00secret"))
+	if res := c.cmd(`"` + auth + `"`); !strings.HasPrefix(last(res), "OK") {
+		t.Fatalf("login = %q", res)
+	}
+
+	script := "require \"fileinto\";\r\nif header :contains \"subject\" \"x\" {\r\n  fileinto \"X\";\r\n}\r\n"
+	if res := c.cmd(fmt.Sprintf("PUTSCRIPT \"main\" {%d+}\r\n%s", len(script), script)); last(res) != `OK "Script saved"` {
+		t.Fatalf("PUTSCRIPT = %q", res)
+	}
+	if res := c.cmd(`CHECKSCRIPT "fileinto \"x\";"`); !strings.HasPrefix(last(res), `NO "line 1: missing require`) {
+		t.Errorf("CHECKSCRIPT = %q", res)
+	}
+	if res := c.cmd(`HAVESPACE "other" 100`); !strings.HasPrefix(last(res), "OK") {
+		t.Errorf("HAVESPACE = %q", res)
+	}
+	if res := c.cmd(`HAVESPACE "other" 999999999`); !strings.HasPrefix(last(res), "NO (QUOTA/MAXSIZE)") {
+		t.Errorf("HAVESPACE too large = %q", res)
+	}
+	if res := c.cmd(`SETACTIVE "main"`); !strings.HasPrefix(last(res), "OK") {
+		t.Fatalf("SETACTIVE = %q", res)
+	}
+	if res := c.cmd(`LISTSCRIPTS`); len(res) != 2 || res[0] != `"main" ACTIVE` {
+		t.Errorf("LISTSCRIPTS = %q", res)
+	}
+	fmt.Fprintf(c.conn, "GETSCRIPT \"main\"\r\n")
+	if line, _ := c.r.ReadString('\n'); line != fmt.Sprintf("{%d}\r\n", len(script)) {
+		t.Fatalf("GETSCRIPT literal = %q", line)
+	}
+	buf := make([]byte, len(script)+2)
+	if _, err := c.r.Read(buf); err != nil || string(buf) != script+"\r\n" {
+		t.Errorf("GETSCRIPT content = %q", buf)
+	}
+	if res := c.readResponse(); !strings.HasPrefix(last(res), "OK") {
+		t.Errorf("GETSCRIPT = %q", res)
+	}
+	if res := c.cmd(`DELETESCRIPT "main"`); !strings.HasPrefix(last(res), "NO (ACTIVE)") {
+		t.Errorf("DELETESCRIPT active = %q", res)
+	}
+	if res := c.cmd(`RENAMESCRIPT "none" "x"`); !strings.HasPrefix(last(res), "NO (NONEXISTENT)") {
+		t.Errorf("RENAMESCRIPT = %q", res)
+	}
+	if res := c.cmd(`NOOP "t1"`); last(res) != `OK (TAG "t1") "Done"` {
+		t.Errorf("NOOP = %q", res)
+	}
+	if res := c.cmd(`LOGOUT`); !strings.HasPrefix(last(res), "OK") {
+		t.Errorf("LOGOUT = %q", res)
+	}
+}
+
+func TestReadLine(t *testing.T) {
+	name, args, err := readLine(bufio.NewReader(strings.NewReader("putScript \"a\\\"b\" {3}\r\nx\ny 12\r\n")))
+	if err != nil || name != "PUTSCRIPT" || len(args) != 3 || args[0] != `a"b` || args[1] != "x\ny" || args[2] != "12" {
+		t.Errorf("readLine() = %q %q %v", name, args, err)
+	}
+	if _, _, err := readLine(bufio.NewReader(strings.NewReader("X {99999999+}\r\n"))); err != errTooLarge {
+		t.Errorf("oversize literal error = %v", err)
+	}
+}
@@ -16,7 +16,12 @@ import (
 )
 
 // The Backend implements SMTP server methods.
-type Backend struct{}
+type Backend struct {
+	// Submission 465/587端口，用于选择需要执行的milter
+	Submission bool
+	// LMTP 由前置MTA投递，不再执行milter与SPF校验
+	LMTP bool
+}
 
 func (bkd *Backend) NewSession(conn *smtp.Conn) (smtp.Session, error) {
 
@@ -25,9 +30,23 @@ func (bkd *Backend) NewSession(conn *smtp.Conn) (smtp.Session, error) {
 	ctx.SetValue(context.LogID, id.GenLogID())
 	log.WithContext(ctx).Debugf("新SMTP连接")
 
+	if bkd.LMTP {
+		return &Session{
+			RemoteAddress: remoteAddress,
+			Ctx:           ctx,
+			lmtp:          true,
+		}, nil
+	}
+
+	milters, err := newMilterChain(ctx, bkd.Submission, conn)
+	if err != nil {
+		return nil, err
+	}
+
 	return &Session{
 		RemoteAddress: remoteAddress,
 		Ctx:           ctx,
+		milters:       milters,
 	}, nil
 }
 
@@ -38,6 +57,10 @@ type Session struct {
 	From          string
 	To            []string
 	Ctx           *context.Context
+	milters       *milterChain
+	lmtp          bool
+	// size MAIL FROM中声明的邮件大小，没有声明时为0
+	size int64
 }
 
 // AuthMechanisms returns a slice of available auth mechanisms
@@ -99,19 +122,45 @@ func (s *Session) AuthPlain(username, pwd string) error {
 
 func (s *Session) Mail(from string, opts *smtp.MailOptions) error {
 	log.WithContext(s.Ctx).Debugf("Mail Success %+v %+v", from, opts)
+	if err := s.milters.Mail(from, s.Ctx.UserAccount); err != nil {
+		return err
+	}
 	s.From = from
+	if opts != nil {
+		s.size = opts.Size
+	}
 	return nil
 }
 
 func (s *Session) Rcpt(to string, opts *smtp.RcptOptions) error {
 	log.WithContext(s.Ctx).Debugf("Rcpt Success %+v", to)
 
+	if err := s.milters.Rcpt(to); err != nil {
+		return err
+	}
+	if s.lmtp || (s.Ctx.UserID == 0 && isLocalAddress(to)) {
+		// 在RCPT阶段拒绝不存在的本地收件人，由对方MTA退信。配置了catch-all别名时可以解析成功
+		if err := checkLocalRecipient(s.Ctx, to); err != nil {
+			return err
+		}
+	}
+	if s.Ctx.UserID == 0 {
+		if err := checkListPost(s.Ctx, to, s.From); err != nil {
+			return err
+		}
+		if err := checkRcptQuota(s.Ctx, to, s.size); err != nil {
+			return err
+		}
+	}
 	s.To = append(s.To, to)
 	return nil
 }
 
-func (s *Session) Reset() {}
+func (s *Session) Reset() {
+	s.milters.Reset()
+}
 
 func (s *Session) Logout() error {
+	s.milters.Close()
 	return nil
 }
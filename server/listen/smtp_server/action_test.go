@@ -0,0 +1,65 @@
+package smtp_server
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/Jinnrry/pmail/config"
+	"github.com/Jinnrry/pmail/db"
+	"github.com/Jinnrry/pmail/db/dbtest"
+	"github.com/Jinnrry/pmail/dto"
+	"github.com/Jinnrry/pmail/utils/context"
+	"github.com/emersion/go-smtp"
+)
+
+func TestRcptUnknownLocal(t *testing.T) {
+	dbtest.Init(t, func(c *config.Config) {
+		c.Domains = []string{"example.com", "example.org"}
+	})
+	dbtest.User(t, "alice", "alice")
+	aliases := []*dto.Alias{
+		{Address: "sales@example.com", Targets: []string{"alice"}},
+		{Address: "*@example.org", Targets: []string{"alice"}},
+	}
+	for _, a := range aliases {
+		if _, err := db.Instance.Insert(a.Encode()); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		to   string
+		lmtp bool
+		code int
+	}{
+		{"alice@example.com", false, 0},
+		{"alice+tag@example.com", false, 0},
+		{"sales@example.com", false, 0},
+		{"nobody@example.com", false, 550},
+		{"nobody@example.com", true, 550},
+		// 配置了catch-all的域名接收任意收件人
+		{"nobody@example.org", false, 0},
+		{"someone@remote.net", false, 0},
+		{"someone@remote.net", true, 550},
+	}
+	for _, tt := range tests {
+		s := &Session{Ctx: &context.Context{}, lmtp: tt.lmtp}
+		err := s.Rcpt(tt.to, nil)
+		code := 0
+		var smtpErr *smtp.SMTPError
+		if errors.As(err, &smtpErr) {
+			code = smtpErr.Code
+		} else if err != nil {
+			t.Fatalf("Rcpt(%s) = %v", tt.to, err)
+		}
+		if code != tt.code {
+			t.Errorf("Rcpt(%s) lmtp=%v code = %d, want %d", tt.to, tt.lmtp, code, tt.code)
+		}
+	}
+
+	// 已登录用户发信不检查本地收件人
+	s := &Session{Ctx: &context.Context{UserID: 1}}
+	if err := s.Rcpt("nobody@example.com", nil); err != nil {
+		t.Errorf("authenticated Rcpt = %v", err)
+	}
+}
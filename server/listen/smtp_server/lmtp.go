@@ -0,0 +1,91 @@
+package smtp_server
+
+import (
+	"os"
+	"strings"
+	"time"
+
+	"github.com/Jinnrry/pmail/config"
+	"github.com/Jinnrry/pmail/dto/parsemail"
+	"github.com/Jinnrry/pmail/services/alias"
+	"github.com/Jinnrry/pmail/utils/context"
+	"github.com/emersion/go-smtp"
+	log "github.com/sirupsen/logrus"
+)
+
+var instanceLmtp *smtp.Server
+
+// lmtpListenAddress 解析LMTP监听地址，支持 unix:///path、tcp://host:port、/path、host:port
+func lmtpListenAddress(address string) (network string, addr string) {
+	address = strings.TrimSpace(address)
+	switch {
+	case strings.HasPrefix(address, "unix://"):
+		return "unix", strings.TrimPrefix(address, "unix://")
+	case strings.HasPrefix(address, "tcp://"):
+		return "tcp", strings.TrimPrefix(address, "tcp://")
+	case strings.HasPrefix(address, "/"):
+		return "unix", address
+	}
+	return "tcp", address
+}
+
+// StartLMTP 启动LMTP服务（RFC 2033），用于接收前置MTA（比如Postfix）投递的邮件
+func StartLMTP() {
+	if config.Instance.LmtpAddress == "" {
+		return
+	}
+	be := &Backend{LMTP: true}
+
+	instanceLmtp = smtp.NewServer(be)
+	instanceLmtp.LMTP = true
+	instanceLmtp.Network, instanceLmtp.Addr = lmtpListenAddress(config.Instance.LmtpAddress)
+	instanceLmtp.Domain = config.Instance.Domain
+	instanceLmtp.ReadTimeout = 60 * time.Second
+	instanceLmtp.WriteTimeout = 60 * time.Second
+	instanceLmtp.MaxMessageBytes = 1024 * 1024 * 30
+	instanceLmtp.MaxRecipients = 50
+	instanceLmtp.AllowInsecureAuth = false
+
+	if instanceLmtp.Network == "unix" {
+		// 删除上次运行残留的socket文件
+		_ = os.Remove(instanceLmtp.Addr)
+	}
+
+	log.Println("Starting LMTP Server Address:", config.Instance.LmtpAddress)
+	if err := instanceLmtp.ListenAndServe(); err != nil {
+		log.Errorf("LMTP Server Error: %v", err)
+	}
+}
+
+// isLocalAddress 收件人是否属于本地域名
+func isLocalAddress(to string) bool {
+	_, domain := parsemail.BuilderUser(to).GetDomainAccount()
+	return alias.IsLocalDomain(domain)
+}
+
+// checkLocalRecipient 收件人必须是本地用户，或者匹配了别名、邮件列表、catch-all
+func checkLocalRecipient(ctx *context.Context, to string) error {
+	if !isLocalAddress(to) {
+		return &smtp.SMTPError{
+			Code:         550,
+			EnhancedCode: smtp.EnhancedCode{5, 1, 1},
+			Message:      "Recipient domain not handled here",
+		}
+	}
+	target, err := alias.ResolveAddress(ctx, to)
+	if err != nil {
+		return &smtp.SMTPError{
+			Code:         451,
+			EnhancedCode: smtp.EnhancedCode{4, 3, 0},
+			Message:      "Temporary lookup failure",
+		}
+	}
+	if target == nil {
+		return &smtp.SMTPError{
+			Code:         550,
+			EnhancedCode: smtp.EnhancedCode{5, 1, 1},
+			Message:      "User unknown",
+		}
+	}
+	return nil
+}
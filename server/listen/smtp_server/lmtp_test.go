@@ -0,0 +1,118 @@
+package smtp_server
+
+import (
+	"errors"
+	"net"
+	"testing"
+
+	"github.com/Jinnrry/pmail/db"
+	"github.com/Jinnrry/pmail/db/dbtest"
+	"github.com/Jinnrry/pmail/models"
+	"github.com/emersion/go-smtp"
+)
+
+func TestLMTPDeliveryStatus(t *testing.T) {
+	dbtest.Init(t)
+	dbtest.User(t, "alice", "alice")
+	// carol 的用量还没有用满，RCPT 阶段可以通过，存入这封邮件后超出配额
+	full := &models.User{Account: "carol", Name: "carol", Quota: 100}
+	gone := &models.User{Account: "dave", Name: "dave"}
+	if _, err := db.Instance.Insert(full, gone); err != nil {
+		t.Fatal(err)
+	}
+	e := &models.Email{Subject: "old", Size: 90}
+	db.Instance.Insert(e)
+	db.Instance.Insert(&models.UserEmail{UserID: full.ID, EmailID: e.Id})
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := smtp.NewServer(&Backend{LMTP: true})
+	srv.LMTP = true
+	srv.Domain = "localhost"
+	go srv.Serve(l)
+	t.Cleanup(func() { srv.Close() })
+
+	conn, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := smtp.NewClientLMTP(conn)
+	defer c.Close()
+	if err = c.Hello("mx.example.com"); err != nil {
+		t.Fatal(err)
+	}
+	if err = c.Mail("sender@remote.net", nil); err != nil {
+		t.Fatal(err)
+	}
+
+	rcptCode := func(to string) int {
+		var smtpErr *smtp.SMTPError
+		if err := c.Rcpt(to, nil); errors.As(err, &smtpErr) {
+			return smtpErr.Code
+		} else if err != nil {
+			t.Fatalf("Rcpt(%s) = %v", to, err)
+		}
+		return 250
+	}
+	for to, code := range map[string]int{
+		"alice@example.com":  250,
+		"carol@example.com":  250,
+		"dave@example.com":   250,
+		"nobody@example.com": 550,
+	} {
+		if got := rcptCode(to); got != code {
+			t.Errorf("RCPT %s = %d, want %d", to, got, code)
+		}
+	}
+	// RCPT 之后账号被删除，投递时找不到收件人
+	db.Instance.ID(gone.ID).Delete(&models.User{})
+
+	statuses := map[string]*smtp.SMTPError{}
+	w, err := c.LMTPData(func(rcpt string, status *smtp.SMTPError) {
+		statuses[rcpt] = status
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Write([]byte("From: sender@remote.net\r\n" +
+		"To: alice@example.com, carol@example.com, dave@example.com\r\n" +
+		"Subject: lmtp status\r\n" +
+		"Content-Type: text/plain\r\n" +
+		"\r\n" +
+		"hello\r\n"))
+	if err = w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		rcpt     string
+		code     int
+		enhanced smtp.EnhancedCode
+	}{
+		{"alice@example.com", 0, smtp.EnhancedCode{}},
+		{"carol@example.com", 552, smtp.EnhancedCode{5, 2, 2}},
+		{"dave@example.com", 451, smtp.EnhancedCode{4, 3, 0}},
+	}
+	if len(statuses) != len(tests) {
+		t.Errorf("statuses = %v", statuses)
+	}
+	for _, tt := range tests {
+		status, ok := statuses[tt.rcpt]
+		switch {
+		case !ok:
+			t.Errorf("%s: no status line", tt.rcpt)
+		case tt.code == 0 && status != nil:
+			t.Errorf("%s: status = %v, want OK", tt.rcpt, status)
+		case tt.code != 0 && (status == nil || status.Code != tt.code || status.EnhancedCode != tt.enhanced):
+			t.Errorf("%s: status = %v, want %d %v", tt.rcpt, status, tt.code, tt.enhanced)
+		}
+	}
+
+	count, err := db.Instance.Table("user_email").Join("INNER", "email", "email.id=user_email.email_id").
+		Where("email.subject='lmtp status'").Count()
+	if err != nil || count != 1 {
+		t.Errorf("delivered copies = %d %v", count, err)
+	}
+}
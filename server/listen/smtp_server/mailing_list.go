@@ -0,0 +1,30 @@
+package smtp_server
+
+import (
+	"github.com/Jinnrry/pmail/services/mailinglist"
+	"github.com/Jinnrry/pmail/utils/context"
+	"github.com/emersion/go-smtp"
+)
+
+// checkListPost 邮件列表仅限成员发帖时，在RCPT阶段拒绝非成员
+func checkListPost(ctx *context.Context, to string, from string) error {
+	list, command, err := mailinglist.Lookup(ctx, to)
+	if err != nil {
+		return &smtp.SMTPError{
+			Code:         451,
+			EnhancedCode: smtp.EnhancedCode{4, 3, 0},
+			Message:      "Temporary lookup failure",
+		}
+	}
+	if list == nil {
+		return nil
+	}
+	if err := mailinglist.CheckPost(ctx, list, command, from); err != nil {
+		return &smtp.SMTPError{
+			Code:         550,
+			EnhancedCode: smtp.EnhancedCode{5, 7, 1},
+			Message:      "Posting to this list is restricted to members",
+		}
+	}
+	return nil
+}
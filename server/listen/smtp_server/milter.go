@@ -0,0 +1,359 @@
+package smtp_server
+
+import (
+	"net"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/Jinnrry/pmail/config"
+	"github.com/Jinnrry/pmail/dto/parsemail"
+	"github.com/Jinnrry/pmail/utils/context"
+	"github.com/Jinnrry/pmail/utils/milter"
+	"github.com/emersion/go-smtp"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cast"
+)
+
+const (
+	milterFailAccept   = "accept"
+	milterFailTempFail = "tempfail"
+	milterFailReject   = "reject"
+)
+
+type milterFilter struct {
+	cfg     *config.MilterConfig
+	session *milter.Session
+	// accepted milter 返回 accept 后，本封邮件（或连接阶段返回时整条连接）不再交给它处理
+	accepted     bool
+	acceptedConn bool
+}
+
+func (f *milterFilter) name() string {
+	if f.cfg.Name != "" {
+		return f.cfg.Name
+	}
+	return f.cfg.Address
+}
+
+func (f *milterFilter) active() bool {
+	return f.session != nil && !f.accepted && !f.acceptedConn
+}
+
+// milterChain 一条SMTP连接上所有milter的会话，按配置顺序依次调用
+type milterChain struct {
+	ctx       *context.Context
+	filters   []*milterFilter
+	inMessage bool
+	// discard milter 要求静默丢弃当前邮件
+	discard bool
+	// quarantine milter 要求隔离当前邮件，值为原因
+	quarantine string
+}
+
+// newMilterChain 连接所有适用的milter并发送连接信息与HELO，没有配置milter时返回nil
+func newMilterChain(ctx *context.Context, submission bool, conn *smtp.Conn) (*milterChain, error) {
+	if config.Instance == nil || len(config.Instance.Milters) == 0 {
+		return nil, nil
+	}
+	chain := &milterChain{ctx: ctx}
+	for _, cfg := range config.Instance.Milters {
+		if cfg == nil || cfg.Address == "" {
+			continue
+		}
+		if (submission && !cfg.Submission) || (!submission && !cfg.Inbound) {
+			continue
+		}
+		filter := &milterFilter{cfg: cfg}
+		chain.filters = append(chain.filters, filter)
+
+		client, err := milter.NewClient(cfg.Address, time.Duration(cfg.Timeout)*time.Second)
+		if err == nil {
+			filter.session, err = client.NewSession()
+		}
+		if err != nil {
+			if err := chain.failed(filter, err); err != nil {
+				chain.Close()
+				return nil, err
+			}
+			continue
+		}
+	}
+	if len(chain.filters) == 0 {
+		return nil, nil
+	}
+
+	hostname, family, port, address := connInfo(conn.Conn().RemoteAddr())
+	macros := map[string]string{
+		"j":             config.Instance.Domain,
+		"{daemon_name}": "pmail",
+		"v":             "PMail",
+		"_":             hostname,
+	}
+	err := chain.each(func(f *milterFilter) (*milter.Response, error) {
+		if err := f.session.Macros(milter.StageConnect, macros); err != nil {
+			return nil, err
+		}
+		return f.session.Conn(hostname, family, port, address)
+	}, true)
+	if err == nil {
+		err = chain.each(func(f *milterFilter) (*milter.Response, error) {
+			return f.session.Helo(conn.Hostname())
+		}, true)
+	}
+	if err != nil {
+		chain.Close()
+		return nil, err
+	}
+	return chain, nil
+}
+
+func connInfo(addr net.Addr) (hostname string, family byte, port uint16, address string) {
+	switch a := addr.(type) {
+	case *net.TCPAddr:
+		family = '4'
+		if a.IP.To4() == nil {
+			family = '6'
+		}
+		return "[" + a.IP.String() + "]", family, uint16(a.Port), a.IP.String()
+	case *net.UnixAddr:
+		return "localhost", 'L', 0, a.Name
+	}
+	return "unknown", 'U', 0, ""
+}
+
+// failed milter 不可用时根据 failAction 处理，返回nil表示跳过该milter
+func (c *milterChain) failed(f *milterFilter, err error) error {
+	log.WithContext(c.ctx).Errorf("Milter %s Error: %v", f.name(), err)
+	if f.session != nil {
+		f.session.Close()
+		f.session = nil
+	}
+	switch strings.ToLower(f.cfg.FailAction) {
+	case milterFailTempFail:
+		return &smtp.SMTPError{
+			Code:         451,
+			EnhancedCode: smtp.EnhancedCode{4, 7, 1},
+			Message:      "Service temporarily unavailable, try again later",
+		}
+	case milterFailReject:
+		return &smtp.SMTPError{
+			Code:         554,
+			EnhancedCode: smtp.EnhancedCode{5, 7, 1},
+			Message:      "Service unavailable",
+		}
+	}
+	return nil
+}
+
+// each 依次调用每个milter，遇到拒绝类结论立即返回
+func (c *milterChain) each(fn func(f *milterFilter) (*milter.Response, error), connStage bool) error {
+	for _, f := range c.filters {
+		if !f.active() {
+			continue
+		}
+		resp, err := fn(f)
+		if err != nil {
+			if err := c.failed(f, err); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := c.verdict(f, resp, connStage); err != nil {
+			return err
+		}
+		if c.discard {
+			return nil
+		}
+	}
+	return nil
+}
+
+var replyCodeRe = regexp.MustCompile(`^(\d{3})[ -](?:(\d)\.(\d{1,3})\.(\d{1,3}) )?(.*)$`)
+
+// verdict 把milter结论转换为SMTP错误
+func (c *milterChain) verdict(f *milterFilter, resp *milter.Response, connStage bool) error {
+	switch resp.Action {
+	case milter.ActionAccept:
+		if connStage {
+			f.acceptedConn = true
+		} else {
+			f.accepted = true
+		}
+	case milter.ActionDiscard:
+		if !connStage {
+			log.WithContext(c.ctx).Infof("Milter %s Discard", f.name())
+			c.discard = true
+		}
+	case milter.ActionReject:
+		log.WithContext(c.ctx).Infof("Milter %s Reject", f.name())
+		return &smtp.SMTPError{
+			Code:         550,
+			EnhancedCode: smtp.EnhancedCode{5, 7, 1},
+			Message:      "Command rejected",
+		}
+	case milter.ActionTempFail:
+		log.WithContext(c.ctx).Infof("Milter %s TempFail", f.name())
+		return &smtp.SMTPError{
+			Code:         451,
+			EnhancedCode: smtp.EnhancedCode{4, 7, 1},
+			Message:      "Service temporarily unavailable, try again later",
+		}
+	case milter.ActionReplyCode:
+		log.WithContext(c.ctx).Infof("Milter %s Reply: %s", f.name(), resp.Text)
+		return replyCodeError(resp)
+	}
+	return nil
+}
+
+func replyCodeError(resp *milter.Response) error {
+	ret := &smtp.SMTPError{Code: resp.Code, EnhancedCode: smtp.NoEnhancedCode}
+	// 多行返回只取第一行
+	line, _, _ := strings.Cut(resp.Text, "\r\n")
+	m := replyCodeRe.FindStringSubmatch(line)
+	if m == nil {
+		ret.Message = line
+		return ret
+	}
+	ret.Message = m[5]
+	if m[2] != "" {
+		ret.EnhancedCode = smtp.EnhancedCode{cast.ToInt(m[2]), cast.ToInt(m[3]), cast.ToInt(m[4])}
+	}
+	return ret
+}
+
+// Mail 发送 MAIL FROM，authAccount 为登陆用户
+func (c *milterChain) Mail(from string, authAccount string) error {
+	if c == nil {
+		return nil
+	}
+	c.inMessage = true
+	macros := map[string]string{"{mail_addr}": from}
+	if authAccount != "" {
+		macros["{auth_authen}"] = authAccount
+		macros["{auth_type}"] = "PLAIN"
+	}
+	return c.each(func(f *milterFilter) (*milter.Response, error) {
+		if err := f.session.Macros(milter.StageMail, macros); err != nil {
+			return nil, err
+		}
+		return f.session.Mail(from)
+	}, false)
+}
+
+func (c *milterChain) Rcpt(to string) error {
+	if c == nil {
+		return nil
+	}
+	return c.each(func(f *milterFilter) (*milter.Response, error) {
+		if err := f.session.Macros(milter.StageRcpt, map[string]string{"{rcpt_addr}": to}); err != nil {
+			return nil, err
+		}
+		return f.session.Rcpt(to)
+	}, false)
+}
+
+// Data 依次把邮件交给每个milter，前一个milter的修改结果作为后一个milter的输入。
+// 返回修改后的邮件、收件人与发件人
+func (c *milterChain) Data(emailData []byte, from string, to []string) ([]byte, string, []string, error) {
+	if c == nil {
+		return emailData, from, to, nil
+	}
+	logID := cast.ToString(c.ctx.GetValue(context.LogID))
+	for _, f := range c.filters {
+		if !f.active() || c.discard {
+			continue
+		}
+		mods, resp, err := c.message(f, emailData, logID)
+		if err != nil {
+			if err := c.failed(f, err); err != nil {
+				return nil, from, to, err
+			}
+			continue
+		}
+		emailData = milter.ApplyModifications(emailData, mods)
+		for _, mod := range mods {
+			switch mod.Type {
+			case milter.ModifyAddRcpt:
+				to = append(to, mod.Value)
+			case milter.ModifyDelRcpt:
+				var kept []string
+				for _, r := range to {
+					if !strings.EqualFold(r, mod.Value) {
+						kept = append(kept, r)
+					}
+				}
+				to = kept
+			case milter.ModifyChangeFrom:
+				from = mod.Value
+			case milter.ModifyQuarantine:
+				log.WithContext(c.ctx).Infof("Milter %s Quarantine: %s", f.name(), mod.Value)
+				c.quarantine = mod.Value
+				if c.quarantine == "" {
+					c.quarantine = f.name()
+				}
+			}
+		}
+		if err := c.verdict(f, resp, false); err != nil {
+			return nil, from, to, err
+		}
+	}
+	c.inMessage = false
+	return emailData, from, to, nil
+}
+
+func (c *milterChain) message(f *milterFilter, emailData []byte, logID string) ([]*milter.Modification, *milter.Response, error) {
+	resp, err := f.session.DataStart()
+	if err != nil || !resp.Continue() {
+		return nil, resp, err
+	}
+	headers, body := parsemail.SplitRawMessage(emailData)
+	for _, h := range headers {
+		resp, err = f.session.Header(h.Name, h.Value)
+		if err != nil || !resp.Continue() {
+			return nil, resp, err
+		}
+	}
+	resp, err = f.session.EndOfHeaders()
+	if err != nil || !resp.Continue() {
+		return nil, resp, err
+	}
+	resp, err = f.session.Body(body)
+	if err != nil || !resp.Continue() {
+		return nil, resp, err
+	}
+	if err = f.session.Macros(milter.StageEOM, map[string]string{"i": logID}); err != nil {
+		return nil, nil, err
+	}
+	return f.session.EndOfMessage()
+}
+
+// Reset 结束当前邮件，未完成的邮件需要通知milter放弃
+func (c *milterChain) Reset() {
+	if c == nil {
+		return
+	}
+	for _, f := range c.filters {
+		if c.inMessage && f.active() {
+			if err := f.session.Abort(); err != nil {
+				_ = c.failed(f, err)
+			}
+		}
+		f.accepted = false
+	}
+	c.inMessage = false
+	c.discard = false
+	c.quarantine = ""
+}
+
+func (c *milterChain) Close() {
+	if c == nil {
+		return
+	}
+	for _, f := range c.filters {
+		if f.session != nil {
+			f.session.Close()
+			f.session = nil
+		}
+	}
+}
@@ -0,0 +1,147 @@
+package smtp_server
+
+import (
+	"fmt"
+	"net/textproto"
+	"sort"
+	"strings"
+
+	"github.com/Jinnrry/pmail/config"
+	"github.com/Jinnrry/pmail/dto/parsemail"
+	"github.com/Jinnrry/pmail/services/alias"
+	"github.com/Jinnrry/pmail/services/quota"
+	"github.com/Jinnrry/pmail/utils/context"
+	"github.com/Jinnrry/pmail/utils/send"
+	"github.com/emersion/go-smtp"
+	log "github.com/sirupsen/logrus"
+)
+
+// sendBounce 发送退信，单测中替换
+var sendBounce = send.Bounce
+
+// checkRcptQuota RCPT阶段检查收件人的配额。已经用满时返回452，对方稍后重试，
+// MAIL FROM中声明的邮件大小超过整个配额时返回552
+func checkRcptQuota(ctx *context.Context, to string, size int64) error {
+	target, err := alias.ResolveAddress(ctx, to)
+	if err != nil || target == nil {
+		return nil
+	}
+	for _, account := range target.Accounts {
+		q := quota.OverAccount(ctx, account, size)
+		if q == nil {
+			continue
+		}
+		log.WithContext(ctx).Infof("Over Quota: %s root:%q usage:%d limit:%d", account, q.Root, q.Usage, q.Limit)
+		if size > q.Limit {
+			return quotaError(552)
+		}
+		return quotaError(452)
+	}
+	return nil
+}
+
+// checkDataQuota DATA阶段按实际邮件大小检查收件账号的配额
+func checkDataQuota(ctx *context.Context, accounts []string, size int64) error {
+	for _, account := range accounts {
+		if q := quota.OverAccount(ctx, account, size); q != nil {
+			log.WithContext(ctx).Infof("Over Quota: %s root:%q usage:%d limit:%d", account, q.Root, q.Usage, q.Limit)
+			return quotaError(552)
+		}
+	}
+	return nil
+}
+
+func quotaError(code int) *smtp.SMTPError {
+	if code == 452 {
+		return &smtp.SMTPError{
+			Code:         452,
+			EnhancedCode: smtp.EnhancedCode{4, 2, 2},
+			Message:      "Mailbox full, try again later",
+		}
+	}
+	return &smtp.SMTPError{
+		Code:         552,
+		EnhancedCode: smtp.EnhancedCode{5, 2, 2},
+		Message:      "Mailbox full",
+	}
+}
+
+// splitOverQuota LMTP按收件人返回投递结果，把超出配额的收件人分出来单独返回552
+func splitOverQuota(ctx *context.Context, tos []string, size int64) ([]string, map[string]error) {
+	var accepted []string
+	rejected := map[string]error{}
+	for _, to := range tos {
+		target, err := alias.ResolveAddress(ctx, to)
+		if err == nil && target != nil {
+			if err = checkDataQuota(ctx, target.Accounts, size); err != nil {
+				rejected[to] = err
+				continue
+			}
+		}
+		accepted = append(accepted, to)
+	}
+	return accepted, rejected
+}
+
+// removeOverQuota DATA阶段按实际邮件大小检查本地账号的配额，把超出配额的账号从rcpts中去掉，
+// 返回因此没有任何投递目标的原始收件人
+func removeOverQuota(ctx *context.Context, rcpts *alias.Recipients, size int64) []string {
+	over := map[string]bool{}
+	var accepted []string
+	for _, account := range rcpts.Accounts {
+		if checkDataQuota(ctx, []string{account}, size) != nil {
+			over[account] = true
+			continue
+		}
+		accepted = append(accepted, account)
+	}
+	if len(over) == 0 {
+		return nil
+	}
+	rcpts.Accounts = accepted
+
+	var rejected []string
+	for to, target := range rcpts.Targets {
+		if len(target.External) > 0 || len(target.Lists) > 0 {
+			continue
+		}
+		delivered := false
+		for _, account := range target.Accounts {
+			if !over[account] {
+				delivered = true
+				break
+			}
+		}
+		if !delivered {
+			rejected = append(rejected, to)
+		}
+	}
+	sort.Strings(rejected)
+	return rejected
+}
+
+// bounceOverQuota 邮件已经投递给其它收件人，给信封发件人发送退信，列出超出配额没有投递的收件人。
+// 空发件人与SPF校验失败的邮件不退信，避免退信发给伪造的发件人
+func bounceOverQuota(ctx *context.Context, sender string, spfPass bool, email *parsemail.Email, rcpts []string) {
+	sender = strings.Trim(strings.TrimSpace(sender), "<>")
+	if sender == "" || !spfPass {
+		log.WithContext(ctx).Infof("Over Quota bounce skipped, sender: %q, recipients: %v", sender, rcpts)
+		return
+	}
+	text := fmt.Sprintf("Your message could not be delivered to the following recipients because their mailbox is full:\r\n\r\n%s\r\n\r\nIt has been delivered to the other recipients.\r\n",
+		strings.Join(rcpts, "\r\n"))
+	notice := &parsemail.Email{
+		From:    &parsemail.User{Name: "Mail Delivery System", EmailAddress: "postmaster@" + config.Instance.Domain},
+		To:      []*parsemail.User{{EmailAddress: sender}},
+		Subject: "Undelivered Mail Returned to Sender: " + email.Subject,
+		Text:    []byte(text),
+		MsgID:   parsemail.GenerateMsgID(config.Instance.Domain),
+		Headers: textproto.MIMEHeader{},
+	}
+	notice.Headers.Set("Auto-Submitted", "auto-replied")
+	if err, _ := sendBounce(ctx, notice); err != nil {
+		log.WithContext(ctx).Errorf("Over Quota bounce to %s error: %v", sender, err)
+		return
+	}
+	log.WithContext(ctx).Infof("Over Quota bounce sent to %s, recipients: %v", sender, rcpts)
+}
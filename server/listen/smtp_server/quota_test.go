@@ -0,0 +1,81 @@
+package smtp_server
+
+import (
+	"errors"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/Jinnrry/pmail/db"
+	"github.com/Jinnrry/pmail/db/dbtest"
+	"github.com/Jinnrry/pmail/dto/parsemail"
+	"github.com/Jinnrry/pmail/models"
+	"github.com/Jinnrry/pmail/utils/context"
+	"github.com/emersion/go-smtp"
+)
+
+func TestDataOverQuota(t *testing.T) {
+	var bounces []*parsemail.Email
+	oldBounce := sendBounce
+	sendBounce = func(ctx *context.Context, e *parsemail.Email) (error, map[string]error) {
+		bounces = append(bounces, e)
+		return nil, nil
+	}
+	t.Cleanup(func() { sendBounce = oldBounce })
+
+	dbtest.Init(t)
+	full := &models.User{Account: "alice", Name: "alice", Quota: 100}
+	free := dbtest.User(t, "bob", "bob")
+	if _, err := db.Instance.Insert(full); err != nil {
+		t.Fatal(err)
+	}
+	e := &models.Email{Subject: "old", Size: 100}
+	db.Instance.Insert(e)
+	db.Instance.Insert(&models.UserEmail{UserID: full.ID, EmailID: e.Id})
+
+	raw := "From: carol@remote.net\r\n" +
+		"To: alice@example.com, bob@example.com\r\n" +
+		"Subject: hello\r\n" +
+		"Content-Type: text/plain\r\n" +
+		"\r\n" +
+		"hello\r\n"
+	deliver := func(to ...string) error {
+		s := &Session{
+			Ctx:           &context.Context{},
+			RemoteAddress: &net.TCPAddr{IP: net.ParseIP("10.0.0.1"), Port: 25},
+			From:          "carol@remote.net",
+			To:            to,
+		}
+		return s.Data(strings.NewReader(raw))
+	}
+	received := func(user *models.User) int64 {
+		count, err := db.Instance.Table("user_email").Join("INNER", "email", "email.id=user_email.email_id").
+			Where("user_email.user_id=? and email.subject='hello'", user.ID).Count()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return count
+	}
+
+	// 只退回超出配额的收件人，其它收件人正常投递
+	if err := deliver("alice@example.com", "bob@example.com"); err != nil {
+		t.Fatal(err)
+	}
+	if received(full) != 0 || received(free) != 1 {
+		t.Errorf("received alice: %d bob: %d", received(full), received(free))
+	}
+	if len(bounces) != 1 || bounces[0].To[0].EmailAddress != "carol@remote.net" ||
+		!strings.Contains(string(bounces[0].Text), "alice@example.com") || strings.Contains(string(bounces[0].Text), "bob@example.com") {
+		t.Fatalf("bounces = %+v", bounces)
+	}
+
+	// 全部收件人都超出配额时拒收整封邮件
+	err := deliver("alice@example.com")
+	var smtpErr *smtp.SMTPError
+	if !errors.As(err, &smtpErr) || smtpErr.Code != 552 {
+		t.Errorf("Data = %v", err)
+	}
+	if len(bounces) != 1 {
+		t.Errorf("bounce sent for rejected mail")
+	}
+}
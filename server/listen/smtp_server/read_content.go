@@ -8,21 +8,35 @@ import (
 	"io"
 	"net"
 	"net/netip"
+	"sort"
 	"strings"
 	"time"
 
 	"github.com/Jinnrry/pmail/config"
+	"github.com/Jinnrry/pmail/consts"
 	"github.com/Jinnrry/pmail/db"
 	"github.com/Jinnrry/pmail/dto/parsemail"
 	"github.com/Jinnrry/pmail/hooks"
 	"github.com/Jinnrry/pmail/hooks/framework"
 	"github.com/Jinnrry/pmail/listen/imap_server"
 	"github.com/Jinnrry/pmail/models"
+	"github.com/Jinnrry/pmail/services/alias"
+	"github.com/Jinnrry/pmail/services/antivirus"
+	"github.com/Jinnrry/pmail/services/bayes"
+	"github.com/Jinnrry/pmail/services/fulltext"
+	"github.com/Jinnrry/pmail/services/mailinglist"
+	"github.com/Jinnrry/pmail/services/modseq"
+	"github.com/Jinnrry/pmail/services/quarantine"
+	"github.com/Jinnrry/pmail/services/quota"
 	"github.com/Jinnrry/pmail/services/rule"
+	"github.com/Jinnrry/pmail/services/sieve"
+	"github.com/Jinnrry/pmail/services/thread"
+	"github.com/Jinnrry/pmail/services/vacation"
 	"github.com/Jinnrry/pmail/utils/array"
 	"github.com/Jinnrry/pmail/utils/async"
 	"github.com/Jinnrry/pmail/utils/context"
 	"github.com/Jinnrry/pmail/utils/send"
+	"github.com/emersion/go-smtp"
 	"github.com/mileusna/spf"
 	log "github.com/sirupsen/logrus"
 	"github.com/spf13/cast"
@@ -39,39 +53,11 @@ func (s *Session) Data(r io.Reader) error {
 
 	ctx := s.Ctx
 
-	log.WithContext(ctx).Debugf("收到邮件")
-
-	emailData, err := io.ReadAll(r)
-	if err != nil {
-		log.WithContext(ctx).Error("邮件内容无法读取", err)
+	emailData, email, err := s.readEmail(r)
+	if err != nil || email == nil {
 		return err
 	}
 
-	log.WithContext(ctx).Debugf("%s", string(emailData))
-
-	log.WithContext(ctx).Debugf("开始执行插件ReceiveParseBefore！")
-	for _, hook := range hooks.HookList {
-		if hook == nil {
-			continue
-		}
-		hook.ReceiveParseBefore(ctx, &emailData)
-	}
-	log.WithContext(ctx).Debugf("开始执行插件ReceiveParseBefore End！")
-
-	email := parsemail.NewEmailFromReader(s.To, bytes.NewReader(emailData), len(emailData))
-
-	if s.From != "" {
-		from := parsemail.BuilderUser(s.From)
-		if email.From == nil {
-			email.From = from
-		}
-		if email.From.EmailAddress != from.EmailAddress {
-			// 协议中的from和邮件内容中的from不匹配，当成垃圾邮件处理
-			//log.WithContext(s.Ctx).Infof("垃圾邮件，拒信")
-			//return nil
-		}
-	}
-
 	// 判断是收信还是转发，只要是登陆了，都当成转发处理
 	if s.Ctx.UserID > 0 {
 		account, _ := email.From.GetDomainAccount()
@@ -92,6 +78,19 @@ func (s *Session) Data(r io.Reader) error {
 			return nil
 		}
 
+		if _, err := virusCheck(ctx, emailData, email, true); err != nil {
+			return err
+		}
+
+		if s.milters != nil && s.milters.quarantine != "" {
+			// 发信时隔离没有意义，直接拒绝
+			return &smtp.SMTPError{
+				Code:         550,
+				EnhancedCode: smtp.EnhancedCode{5, 7, 1},
+				Message:      "Message rejected: " + s.milters.quarantine,
+			}
+		}
+
 		// 转发
 		_, _, err := saveEmail(ctx, len(emailData), email, s.Ctx.UserID, 1, nil, true, true)
 		if err != nil {
@@ -139,73 +138,345 @@ func (s *Session) Data(r io.Reader) error {
 
 	} else {
 		// 收件
-		var dkimStatus, SPFStatus bool
+		_, _, err = s.receive(emailData, email)
+		return err
+	}
 
-		// DKIM校验
-		dkimStatus = parsemail.Check(ctx, bytes.NewReader(emailData))
+	return nil
+}
 
-		SPFStatus = spfCheck(s.RemoteAddress.String(), email.Sender, email.Sender.EmailAddress)
+// LMTPData LMTP投递，每个收件人单独返回投递结果
+func (s *Session) LMTPData(r io.Reader, status smtp.StatusCollector) error {
+	emailData, email, err := s.readEmail(r)
+	if err != nil || email == nil {
+		return err
+	}
 
-		log.WithContext(ctx).Debugf("开始执行插件ReceiveParseAfter！")
-		for _, hook := range hooks.HookList {
-			if hook == nil {
-				continue
+	var overQuota map[string]error
+	s.To, overQuota = splitOverQuota(s.Ctx, s.To, int64(len(emailData)))
+	for to, err := range overQuota {
+		status.SetStatus(to, err)
+	}
+	if len(s.To) == 0 {
+		return nil
+	}
+
+	rcpts, users, err := s.receive(emailData, email)
+	if err != nil {
+		return err
+	}
+	if email.MessageId == 0 && len(rcpts.External) == 0 && len(rcpts.Lists) == 0 {
+		return &smtp.SMTPError{
+			Code:         451,
+			EnhancedCode: smtp.EnhancedCode{4, 3, 0},
+			Message:      "Temporary delivery failure, try again later",
+		}
+	}
+
+	for _, to := range s.To {
+		status.SetStatus(to, deliveryStatus(rcpts.Targets[to], users))
+	}
+	return nil
+}
+
+// deliveryStatus 收件人是否已经投递到对应用户的邮箱或者转发到了外部地址
+func deliveryStatus(target *alias.Target, users []*models.User) error {
+	if target != nil {
+		if len(target.External) > 0 || len(target.Lists) > 0 {
+			return nil
+		}
+		for _, user := range users {
+			if array.InArray(strings.ToLower(user.Account), target.Accounts) {
+				return nil
 			}
-			hook.ReceiveParseAfter(ctx, email)
 		}
-		log.WithContext(ctx).Debugf("开始执行插件ReceiveParseAfter！End")
+	}
+	return &smtp.SMTPError{
+		Code:         451,
+		EnhancedCode: smtp.EnhancedCode{4, 3, 0},
+		Message:      "Temporary delivery failure, try again later",
+	}
+}
+
+// readEmail 读取并解析邮件，milter要求丢弃邮件时返回的email为nil
+func (s *Session) readEmail(r io.Reader) ([]byte, *parsemail.Email, error) {
+	ctx := s.Ctx
+
+	log.WithContext(ctx).Debugf("收到邮件")
+
+	emailData, err := io.ReadAll(r)
+	if err != nil {
+		log.WithContext(ctx).Error("邮件内容无法读取", err)
+		return nil, nil, err
+	}
+
+	log.WithContext(ctx).Debugf("%s", string(emailData))
 
-		_, formDomain := email.From.GetDomainAccount()
-		// 伪造邮件
-		if array.InArray(formDomain, config.Instance.Domains) && SPFStatus == false {
-			dkimStatus = false
-			email.Status = 3
+	emailData, s.From, s.To, err = s.milters.Data(emailData, s.From, s.To)
+	if err != nil {
+		return nil, nil, err
+	}
+	if s.milters != nil && s.milters.discard {
+		log.WithContext(ctx).Infof("Milter丢弃邮件")
+		return nil, nil, nil
+	}
+
+	log.WithContext(ctx).Debugf("开始执行插件ReceiveParseBefore！")
+	for _, hook := range hooks.HookList {
+		if hook == nil {
+			continue
 		}
+		hook.ReceiveParseBefore(ctx, &emailData)
+	}
+	log.WithContext(ctx).Debugf("开始执行插件ReceiveParseBefore End！")
 
-		users, dbEmail, _ := saveEmail(ctx, len(emailData), email, 0, 0, s.To, SPFStatus, dkimStatus)
+	email := parsemail.NewEmailFromReader(s.To, bytes.NewReader(emailData), len(emailData))
 
-		if email.MessageId > 0 {
-			log.WithContext(ctx).Debugf("开始执行邮件规则！")
-			for _, user := range users {
-				// 执行邮件规则
+	if s.From != "" {
+		from := parsemail.BuilderUser(s.From)
+		if email.From == nil {
+			email.From = from
+		}
+		if email.From.EmailAddress != from.EmailAddress {
+			// 协议中的from和邮件内容中的from不匹配，当成垃圾邮件处理
+			//log.WithContext(s.Ctx).Infof("垃圾邮件，拒信")
+			//return nil
+		}
+	}
+
+	return emailData, email, nil
+}
+
+// receive 收信流程：校验、插件、入库、执行规则、IDLE通知，返回收件人解析结果与收到邮件的用户
+func (s *Session) receive(emailData []byte, email *parsemail.Email) (*alias.Recipients, []*models.User, error) {
+	ctx := s.Ctx
+
+	var dkimStatus, SPFStatus, dkimAligned, spfAligned bool
+	_, fromDomain := email.From.GetDomainAccount()
+
+	if s.lmtp {
+		// 前置MTA已经完成了SPF校验，直接使用它添加的Authentication-Results
+		ar := parsemail.FindAuthResults(emailData, config.Instance.LmtpAuthServID)
+		spfResult := ar.Result("spf")
+		SPFStatus = spfResult == "" || spfResult == "pass" || spfResult == "none"
+		// 前置MTA给出了DMARC结果时直接使用
+		_, envelopeDomain := parsemail.BuilderUser(s.From).GetDomainAccount()
+		spfAligned = ar.Pass("dmarc") || (spfResult == "pass" && parsemail.AlignedDomain(envelopeDomain, fromDomain))
+		if ar.Result("dkim") != "" {
+			dkimStatus = ar.Pass("dkim")
+			if dkimStatus && !spfAligned {
+				// Authentication-Results中没有签名域，本地校验一次确认是否对齐
+				_, dkimAligned = parsemail.CheckAligned(ctx, bytes.NewReader(emailData), fromDomain)
+			}
+		} else {
+			dkimStatus, dkimAligned = parsemail.CheckAligned(ctx, bytes.NewReader(emailData), fromDomain)
+		}
+	} else {
+		// DKIM校验
+		dkimStatus, dkimAligned = parsemail.CheckAligned(ctx, bytes.NewReader(emailData), fromDomain)
+
+		var spfResult spf.Result
+		SPFStatus, spfResult = spfCheck(s.RemoteAddress.String(), email.Sender, email.Sender.EmailAddress)
+		_, senderDomain := email.Sender.GetDomainAccount()
+		spfAligned = spfResult == spf.Pass && parsemail.AlignedDomain(senderDomain, fromDomain)
+	}
+
+	log.WithContext(ctx).Debugf("开始执行插件ReceiveParseAfter！")
+	for _, hook := range hooks.HookList {
+		if hook == nil {
+			continue
+		}
+		hook.ReceiveParseAfter(ctx, email)
+	}
+	log.WithContext(ctx).Debugf("开始执行插件ReceiveParseAfter！End")
+
+	// 删除了带毒附件时，转发和分发使用处理后的邮件
+	relayData, err := virusCheck(ctx, emailData, email, false)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if s.milters != nil && s.milters.quarantine != "" {
+		email.Status = int(consts.EmailStatusJunk)
+	}
+
+	_, formDomain := email.From.GetDomainAccount()
+	// 伪造邮件
+	if array.InArray(formDomain, config.Instance.Domains) && SPFStatus == false {
+		dkimStatus = false
+		dkimAligned = false
+		email.Status = 3
+	}
+
+	rcpts, err := alias.Resolve(ctx, s.To)
+	if err != nil {
+		return nil, nil, &smtp.SMTPError{
+			Code:         451,
+			EnhancedCode: smtp.EnhancedCode{4, 3, 0},
+			Message:      "Temporary lookup failure",
+		}
+	}
+
+	// 超出配额的收件人不投递，全部收件人都超出配额时拒收整封邮件，否则投递给其它收件人并给发件人退信
+	if overQuota := removeOverQuota(ctx, rcpts, int64(len(emailData))); len(overQuota) > 0 {
+		if len(rcpts.Accounts) == 0 && len(rcpts.Unknown) == 0 && len(rcpts.External) == 0 && len(rcpts.Lists) == 0 {
+			return nil, nil, quotaError(552)
+		}
+		if !s.lmtp {
+			// LMTP已经按收件人返回了结果，由前置MTA退信
+			bounceOverQuota(ctx, s.From, SPFStatus, email, overQuota)
+		}
+	}
+
+	if len(rcpts.Accounts) == 0 && len(rcpts.Unknown) == 0 && (len(rcpts.External) > 0 || len(rcpts.Lists) > 0) {
+		// 没有本地收件人，不需要入库
+		relay(ctx, relayData, email, rcpts, s.From)
+		return rcpts, nil, nil
+	}
+
+	users, dbEmail, _ := saveEmail(ctx, len(emailData), email, 0, 0, rcpts, SPFStatus, dkimStatus)
+
+	if email.MessageId > 0 {
+		log.WithContext(ctx).Debugf("开始执行邮件规则！")
+		for _, user := range users {
+			email.Tag = rcpts.Tag(user.Account)
+			email.EnvelopeFrom = s.From
+			email.EnvelopeTo = envelopeTo(rcpts, user.Account)
+			email.SPFPass, email.DKIMPass = SPFStatus, dkimStatus
+			email.AuthAligned = spfAligned || dkimAligned
+			status := email.Status
+			allowed := quarantine.Trusted(ctx, user.ID, email)
+			if status == 0 && !allowed && bayes.IsSpam(ctx, user.ID, email) {
+				markJunk(ctx, email, user.ID)
+			}
+			// 垃圾邮件和伪造发件人的邮件进入隔离区，不再执行规则与自动回复
+			if reason := quarantine.Reason(email.Status); reason != "" && !allowed && quarantine.Enabled() {
+				err := quarantine.Add(ctx, user.ID, email, reason)
+				if err == nil {
+					email.Status = status
+					continue
+				}
+				log.WithContext(ctx).Errorf("Quarantine Error:%v", err)
+			}
+			// 用户启用了Sieve脚本时不再执行邮件规则
+			handled, delivered := sieve.Deliver(ctx, user, email, emailData, s.From, email.EnvelopeTo)
+			if !handled {
+				// 按优先级执行邮件规则，命中设置了停止的规则后不再执行后面的规则
 				rs := rule.GetAllRules(ctx, user.ID)
 				for _, r := range rs {
 					if rule.MatchRule(ctx, r, email) {
 						rule.DoRule(ctx, r, email, user, emailData)
+						if r.Stop {
+							break
+						}
 					}
 				}
 			}
+			// 自动回复，被Sieve脚本丢弃或拒收的邮件不回复
+			if !handled || delivered {
+				vacation.Reply(ctx, user, s.From, emailData, email)
+			}
+			email.Status = status
 		}
+	}
 
-		log.WithContext(ctx).Debugf("开始执行插件ReceiveSaveAfter！")
-		var ue []*models.UserEmail
-		err = db.Instance.Table(&models.UserEmail{}).Where("email_id=?", email.MessageId).Find(&ue)
-		if err != nil {
-			log.WithContext(ctx).Errorf("sql Error :%+v", err)
+	// 规则、Sieve脚本对邮件的修改已经完成，分配修改序号
+	modseq.Touch(ctx, nil, Eq{"email_id": email.MessageId})
+
+	log.WithContext(ctx).Debugf("开始执行插件ReceiveSaveAfter！")
+	var ue []*models.UserEmail
+	err = db.Instance.Table(&models.UserEmail{}).Where("email_id=?", email.MessageId).Find(&ue)
+	if err != nil {
+		log.WithContext(ctx).Errorf("sql Error :%+v", err)
+	}
+	as3 := async.New(ctx)
+	for _, hook := range hooks.HookList {
+		if hook == nil {
+			continue
 		}
-		as3 := async.New(ctx)
-		for _, hook := range hooks.HookList {
-			if hook == nil {
-				continue
+		as3.WaitProcess(func(hk any) {
+			hk.(framework.EmailHook).ReceiveSaveAfter(ctx, email, ue)
+		}, hook)
+	}
+	as3.Wait()
+	log.WithContext(ctx).Debugf("开始执行插件ReceiveSaveAfter！End")
+
+	// IDLE命令通知
+	for _, user := range users {
+		imap_server.IdleNotice(ctx, user.ID, dbEmail)
+	}
+
+	for _, user := range users {
+		quota.Warn(ctx, user.ID)
+	}
+
+	relay(ctx, relayData, email, rcpts, s.From)
+
+	return rcpts, users, nil
+}
+
+// markJunk 贝叶斯分类器判定为垃圾邮件，放入该用户的垃圾箱
+func markJunk(ctx *context.Context, email *parsemail.Email, userID int) {
+	log.WithContext(ctx).Infof("Bayes Spam: %s", email.Subject)
+	_, _, err := modseq.Relocate(ctx, nil, Eq{"email_id": email.MessageId, "user_id": userID}, func(ue *models.UserEmail) {
+		ue.Status = consts.EmailStatusJunk
+	})
+	if err != nil {
+		log.WithContext(ctx).Errorf("sql Error :%+v", err)
+		return
+	}
+	email.Status = int(consts.EmailStatusJunk)
+}
+
+// envelopeTo 返回投递给该账号的原始收件地址，作为Sieve脚本中的信封收件人
+func envelopeTo(rcpts *alias.Recipients, account string) []string {
+	var ret []string
+	for address, target := range rcpts.Targets {
+		for _, a := range target.Accounts {
+			if strings.EqualFold(a, account) {
+				ret = append(ret, address)
+				break
 			}
-			as3.WaitProcess(func(hk any) {
-				hk.(framework.EmailHook).ReceiveSaveAfter(ctx, email, ue)
-			}, hook)
 		}
-		as3.Wait()
-		log.WithContext(ctx).Debugf("开始执行插件ReceiveSaveAfter！End")
+	}
+	sort.Strings(ret)
+	return ret
+}
 
-		// IDLE命令通知
-		for _, user := range users {
-			imap_server.IdleNotice(ctx, user.ID, dbEmail)
+// relay 入库完成后再转发给别名中的外部地址、分发到邮件列表，被隔离、判定为垃圾邮件或者伪造发件人的邮件不转发
+func relay(ctx *context.Context, emailData []byte, email *parsemail.Email, rcpts *alias.Recipients, sender string) {
+	if len(rcpts.External) == 0 && len(rcpts.Lists) == 0 {
+		return
+	}
+	if email.Status != 0 || bayes.IsSpam(ctx, 0, email) {
+		log.WithContext(ctx).Infof("Relay skipped, status: %d, subject: %s", email.Status, email.Subject)
+		return
+	}
+	forwardExternal(ctx, emailData, rcpts)
+	// 邮件列表
+	for _, target := range rcpts.Targets {
+		for _, id := range target.Lists {
+			mailinglist.Process(ctx, id, target.Tag, sender, emailData)
 		}
-
 	}
+}
 
-	return nil
+// forwardExternal 把邮件原样转发给别名中的外部地址，信封发件人使用别名地址
+func forwardExternal(ctx *context.Context, emailData []byte, rcpts *alias.Recipients) {
+	for address, target := range rcpts.Targets {
+		if len(target.External) == 0 {
+			continue
+		}
+		err, _ := send.Redirect(ctx, emailData, target.External, address)
+		if err != nil {
+			log.WithContext(ctx).Errorf("Alias Forward Error: %s -> %v %v", address, target.External, err)
+		} else {
+			log.WithContext(ctx).Infof("Alias Forward Success: %s -> %v", address, target.External)
+		}
+	}
 }
 
-func saveEmail(ctx *context.Context, size int, email *parsemail.Email, sendUserID int, emailType int, reallyTo []string, SPFStatus, dkimStatus bool) ([]*models.User, *models.Email, error) {
+func saveEmail(ctx *context.Context, size int, email *parsemail.Email, sendUserID int, emailType int, rcpts *alias.Recipients, SPFStatus, dkimStatus bool) ([]*models.User, *models.Email, error) {
 	var dkimV, spfV int8
 	if dkimStatus {
 		dkimV = 1
@@ -226,27 +497,33 @@ func saveEmail(ctx *context.Context, size int, email *parsemail.Email, sendUserI
 	}
 
 	modelEmail := models.Email{
-		Type:         cast.ToInt8(emailType),
-		Subject:      email.Subject,
-		ReplyTo:      json2string(email.ReplyTo),
-		FromName:     email.From.Name,
-		FromAddress:  email.From.EmailAddress,
-		To:           json2string(email.To),
-		Bcc:          json2string(email.Bcc),
-		Cc:           json2string(email.Cc),
-		Text:         sql.NullString{String: string(email.Text), Valid: true},
-		Html:         sql.NullString{String: string(email.HTML), Valid: true},
-		Sender:       json2string(email.Sender),
-		Attachments:  json2string(email.Attachments),
-		Size:         email.Size,
-		SPFCheck:     spfV,
-		DKIMCheck:    dkimV,
-		SendUserID:   sendUserID,
-		SendDate:     time.Now(),
-		Status:       cast.ToInt8(email.Status),
-		CreateTime:   time.Now(),
-		CronSendTime: time.Now(),
-		MsgID:        msgID,
+		Type:          cast.ToInt8(emailType),
+		Subject:       email.Subject,
+		ReplyTo:       json2string(email.ReplyTo),
+		FromName:      email.From.Name,
+		FromAddress:   email.From.EmailAddress,
+		To:            json2string(email.To),
+		Bcc:           json2string(email.Bcc),
+		Cc:            json2string(email.Cc),
+		Text:          sql.NullString{String: string(email.Text), Valid: true},
+		Html:          sql.NullString{String: string(email.HTML), Valid: true},
+		Sender:        json2string(email.Sender),
+		Attachments:   json2string(email.Attachments),
+		Size:          email.Size,
+		SPFCheck:      spfV,
+		DKIMCheck:     dkimV,
+		SendUserID:    sendUserID,
+		SendDate:      time.Now(),
+		Status:        cast.ToInt8(email.Status),
+		CreateTime:    time.Now(),
+		CronSendTime:  time.Now(),
+		MsgID:         msgID,
+		InReplyTo:     email.InReplyTo,
+		References:    strings.Join(email.References, " "),
+		ListId:        email.ListId,
+		Precedence:    email.Precedence,
+		AutoSubmitted: email.AutoSubmitted,
+		Headers:       parsemail.EncodeHeader(email.ReceivedHeader),
 	}
 
 	_, err := db.Instance.Insert(&modelEmail)
@@ -258,6 +535,8 @@ func saveEmail(ctx *context.Context, size int, email *parsemail.Email, sendUserI
 	if modelEmail.Id > 0 {
 		email.MessageId = cast.ToInt64(modelEmail.Id)
 		email.MsgID = modelEmail.MsgID
+		thread.Assign(ctx, &modelEmail)
+		fulltext.Index(ctx, &modelEmail)
 	}
 	// 收信人信息
 	var users []*models.User
@@ -266,17 +545,9 @@ func saveEmail(ctx *context.Context, size int, email *parsemail.Email, sendUserI
 	if emailType == 0 {
 		// 找到收信人id
 		accounts := []string{}
-		// 优先取smtp协议中的收件人地址
-		if len(reallyTo) > 0 {
-			for _, s := range reallyTo {
-				account := parsemail.BuilderUser(s)
-				if account != nil {
-					acc, domain := account.GetDomainAccount()
-					if array.InArray(domain, config.Instance.Domains) && acc != "" {
-						accounts = append(accounts, strings.ToLower(acc))
-					}
-				}
-			}
+		// 优先取smtp协议中的收件人地址，已经按别名、catch-all解析为本地账号
+		if rcpts != nil {
+			accounts = append(accounts, rcpts.Accounts...)
 		} else {
 			for _, user := range append(append(email.To, email.Cc...), email.Bcc...) {
 				account, _ := user.GetDomainAccount()
@@ -299,7 +570,11 @@ func saveEmail(ctx *context.Context, size int, email *parsemail.Email, sendUserI
 
 		if len(users) > 0 {
 			for _, user := range users {
-				ue := models.UserEmail{EmailID: modelEmail.Id, UserID: user.ID, Status: cast.ToInt8(email.Status)}
+				tag := rcpts.Tag(user.Account)
+				ue := models.UserEmail{EmailID: modelEmail.Id, UserID: user.ID, Status: cast.ToInt8(email.Status), Tag: tag}
+				if email.Status == 0 {
+					ue.GroupId = subaddressGroup(ctx, user, tag)
+				}
 				_, err = db.Instance.Insert(&ue)
 				if err != nil {
 					log.WithContext(ctx).Errorf("db insert error:%+v", err.Error())
@@ -322,6 +597,7 @@ func saveEmail(ctx *context.Context, size int, email *parsemail.Email, sendUserI
 				if delErr != nil {
 					log.WithContext(ctx).Errorf("db delete error:%+v", delErr.Error())
 				}
+				fulltext.Delete(ctx, nil, modelEmail.Id)
 				return nil, nil, nil
 			}
 
@@ -348,30 +624,56 @@ func saveEmail(ctx *context.Context, size int, email *parsemail.Email, sendUserI
 	return users, &modelEmail, nil
 }
 
+// virusCheck 调用clamd扫描邮件，扫描失败返回451临时错误，让对方稍后重试。
+// 返回需要继续转发的邮件内容，删除了带毒附件时为重新生成的邮件
+func virusCheck(ctx *context.Context, emailData []byte, email *parsemail.Email, outbound bool) ([]byte, error) {
+	verdict, err := antivirus.ScanMessage(ctx, emailData)
+	if err != nil {
+		return nil, &smtp.SMTPError{
+			Code:         451,
+			EnhancedCode: smtp.EnhancedCode{4, 7, 1},
+			Message:      "Virus scan temporarily unavailable, try again later",
+		}
+	}
+	if err = antivirus.Apply(ctx, verdict, email, outbound); err != nil {
+		log.WithContext(ctx).Infof("Virus Reject: %v", err)
+		return nil, &smtp.SMTPError{
+			Code:         554,
+			EnhancedCode: smtp.EnhancedCode{5, 7, 1},
+			Message:      err.Error(),
+		}
+	}
+	if verdict != nil && verdict.Infected && verdict.Action == antivirus.ActionStrip {
+		return email.BuildBytes(ctx, false), nil
+	}
+	return emailData, nil
+}
+
 func json2string(d any) string {
 	by, _ := json.Marshal(d)
 	return string(by)
 }
 
-func spfCheck(remoteAddress string, sender *parsemail.User, senderString string) bool {
+// spfCheck SPF校验，内网地址与没有SPF记录的域名视为通过，res 为实际的校验结果，内网地址时为空
+func spfCheck(remoteAddress string, sender *parsemail.User, senderString string) (bool, spf.Result) {
 	//spf校验
 	ipAddress, _ := netip.ParseAddrPort(remoteAddress)
 
 	ip := net.ParseIP(ipAddress.Addr().String())
 	if ip.IsPrivate() {
-		return true
+		return true, ""
 	}
 
 	tmp := strings.Split(sender.EmailAddress, "@")
 	if len(tmp) < 2 {
-		return false
+		return false, spf.PermError
 	}
 
 	res := spf.CheckHost(ip, tmp[1], senderString, "")
 
 	if res == spf.None || res == spf.Pass {
 		// spf校验通过
-		return true
+		return true, res
 	}
-	return false
+	return false, res
 }
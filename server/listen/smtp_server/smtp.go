@@ -14,7 +14,7 @@ var instanceTls *smtp.Server
 var instanceTlsNew *smtp.Server
 
 func StartWithTLSNew() {
-	be := &Backend{}
+	be := &Backend{Submission: true}
 
 	instanceTlsNew = smtp.NewServer(be)
 
@@ -43,7 +43,7 @@ func StartWithTLSNew() {
 }
 
 func StartWithTLS() {
-	be := &Backend{}
+	be := &Backend{Submission: true}
 
 	instanceTls = smtp.NewServer(be)
 
@@ -109,4 +109,9 @@ func Stop() {
 	if instanceTlsNew != nil {
 		instanceTlsNew.Close()
 	}
+
+	if instanceLmtp != nil {
+		instanceLmtp.Close()
+		instanceLmtp = nil
+	}
 }
@@ -0,0 +1,43 @@
+package smtp_server
+
+import (
+	"strings"
+
+	"github.com/Jinnrry/pmail/models"
+	"github.com/Jinnrry/pmail/services/group"
+	"github.com/Jinnrry/pmail/utils/context"
+	log "github.com/sirupsen/logrus"
+)
+
+// subaddressGroup 用户开启了子地址归档时，返回标签对应的分组id，分组不存在时自动创建
+func subaddressGroup(ctx *context.Context, user *models.User, tag string) int {
+	if user.SubaddressFolder != 1 {
+		return 0
+	}
+	name := subaddressGroupName(tag)
+	if name == "" || group.IsDefaultBox(name) || strings.EqualFold(name, "INBOX") {
+		return 0
+	}
+	userCtx := &context.Context{
+		UserID:      user.ID,
+		UserAccount: user.Account,
+		UserName:    user.Name,
+		Values:      ctx.Values,
+	}
+	g, err := group.CreateGroup(userCtx, name, 0)
+	if err != nil {
+		log.WithContext(ctx).Errorf("Create Subaddress Group Error: %v", err)
+		return 0
+	}
+	return g.ID
+}
+
+// subaddressGroupName 分组名称最长10个字符，且不能包含目录分隔符
+func subaddressGroupName(tag string) string {
+	tag = strings.TrimSpace(strings.ReplaceAll(tag, "/", "_"))
+	runes := []rune(tag)
+	if len(runes) > 10 {
+		runes = runes[:10]
+	}
+	return string(runes)
+}
@@ -7,6 +7,14 @@ type User struct {
 	Password string `xorm:"char(32) notnull comment('登陆密码，两次md5加盐，md5(md5(password+pmail) +pmail2023)')" json:"-"`
 	Disabled int    `xorm:"disabled unsigned int not null default(0) comment('0启用，1禁用')"`
 	IsAdmin  int    `xorm:"is_admin unsigned int not null default(0) comment('0不是管理员，1是管理员')"`
+	// SubaddressFolder 带子地址标签的邮件自动归档到同名分组
+	SubaddressFolder int `xorm:"subaddress_folder unsigned int not null default(0) comment('0不归档，1按子地址标签归档到同名分组')"`
+	// Shared 共享邮箱，比如 support@，不能登录，通过 mailbox_acl 授权给其他用户访问
+	Shared int `xorm:"shared unsigned int not null default(0) comment('0普通用户，1共享邮箱')"`
+	// Quota 存储配额，单位字节
+	Quota int64 `xorm:"quota bigint not null default(0) comment('存储配额，单位字节，0使用默认配额，-1不限制')"`
+	// QuotaWarned 已经发送过的最高配额警告百分比，用量降到警告线以下后清零
+	QuotaWarned int `xorm:"quota_warned int not null default(0) comment('已经发送的配额警告百分比')"`
 }
 
 func (p User) TableName() string {
@@ -0,0 +1,15 @@
+package models
+
+import "time"
+
+type Alias struct {
+	Id         int       `xorm:"id int unsigned not null pk autoincr" json:"id"`
+	Address    string    `xorm:"varchar(255) notnull unique comment('别名地址，小写，*@domain表示该域名的catch-all')" json:"address"`
+	Targets    string    `xorm:"text comment('投递目标，json数组，可以是本地账号或外部邮箱地址')" json:"targets"`
+	Disabled   int       `xorm:"disabled unsigned int not null default(0) comment('0启用，1禁用')" json:"disabled"`
+	CreateTime time.Time `xorm:"create_time created" json:"create_time"`
+}
+
+func (p *Alias) TableName() string {
+	return "alias"
+}
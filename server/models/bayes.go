@@ -0,0 +1,41 @@
+package models
+
+import "time"
+
+// BayesToken 贝叶斯分类器的词库，user_id为0表示全局词库
+type BayesToken struct {
+	Id     int    `xorm:"id int unsigned not null pk autoincr" json:"id"`
+	UserId int    `xorm:"user_id int unsigned notnull default(0) unique('uk_user_token') comment('用户id，0表示全局')" json:"user_id"`
+	Token  string `xorm:"token varchar(64) notnull default('') unique('uk_user_token') comment('词')" json:"token"`
+	Spam   int    `xorm:"spam int notnull default(0) comment('包含该词的垃圾邮件数量')" json:"spam"`
+	Ham    int    `xorm:"ham int notnull default(0) comment('包含该词的正常邮件数量')" json:"ham"`
+}
+
+func (p *BayesToken) TableName() string {
+	return "bayes_token"
+}
+
+// BayesStat 训练过的邮件数量，user_id为0表示全局
+type BayesStat struct {
+	Id     int `xorm:"id int unsigned not null pk autoincr" json:"id"`
+	UserId int `xorm:"user_id int unsigned notnull unique comment('用户id，0表示全局')" json:"user_id"`
+	Spam   int `xorm:"spam int notnull default(0) comment('训练过的垃圾邮件数量')" json:"spam"`
+	Ham    int `xorm:"ham int notnull default(0) comment('训练过的正常邮件数量')" json:"ham"`
+}
+
+func (p *BayesStat) TableName() string {
+	return "bayes_stat"
+}
+
+// BayesTrained 记录每封邮件的训练结果，重复训练时跳过，类别变化时先撤销上次的训练
+type BayesTrained struct {
+	Id         int       `xorm:"id int unsigned not null pk autoincr" json:"id"`
+	UserId     int       `xorm:"user_id int unsigned notnull unique('uk_user_email') comment('用户id')" json:"user_id"`
+	EmailId    int       `xorm:"email_id int unsigned notnull unique('uk_user_email') comment('邮件id')" json:"email_id"`
+	Spam       int       `xorm:"spam tinyint(1) notnull default(0) comment('1垃圾邮件，0正常邮件')" json:"spam"`
+	UpdateTime time.Time `xorm:"update_time updated" json:"update_time"`
+}
+
+func (p *BayesTrained) TableName() string {
+	return "bayes_trained"
+}
@@ -1,11 +1,12 @@
 package models
 
 type Group struct {
-	ID       int    `xorm:"id int unsigned not null pk autoincr" json:"id"`
-	Name     string `xorm:"varchar(10) notnull default('') comment('分组名称')" json:"name"`
-	ParentId int    `xorm:"parent_id int unsigned notnull default(0) comment('父分组名称')" json:"parent_id"`
-	UserId   int    `xorm:"user_id int unsigned notnull default(0) comment('用户id')" json:"-"`
-	FullPath string `xrom:"full_path varchar(600) comment('完整路径')" json:"full_path"`
+	ID         int    `xorm:"id int unsigned not null pk autoincr" json:"id"`
+	Name       string `xorm:"varchar(10) notnull default('') comment('分组名称')" json:"name"`
+	ParentId   int    `xorm:"parent_id int unsigned notnull default(0) comment('父分组名称')" json:"parent_id"`
+	UserId     int    `xorm:"user_id int unsigned notnull default(0) comment('用户id')" json:"-"`
+	FullPath   string `xrom:"full_path varchar(600) comment('完整路径')" json:"full_path"`
+	SpecialUse string `xorm:"special_use varchar(64) notnull default('') comment('IMAP特殊用途（RFC 6154），比如\\Archive，多个用空格分隔')" json:"special_use"`
 }
 
 const (
@@ -14,8 +15,12 @@ const (
 	Drafts  = 2000000002 //草稿箱
 	Deleted = 2000000003 //垃圾箱
 	Junk    = 2000000004 //广告箱
+	All     = 2000000005 //全部邮件，IMAP中的虚拟文件夹 All Mail
 )
 
+// AllMailCond 虚拟文件夹 All Mail 在 user_email 上的筛选条件，包括除垃圾箱、广告箱与发送失败以外的全部邮件
+const AllMailCond = "(group_id > 0 or status in (0,1,4))"
+
 var GroupNameToCode = map[string]int{
 	"INBOX":            INBOX,
 	"Sent Messages":    Sent,
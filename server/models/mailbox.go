@@ -0,0 +1,28 @@
+package models
+
+// MailboxState IMAP文件夹的状态，Mailbox 与 UIDVALIDITY 的初始值相同：自定义文件夹为分组id，默认文件夹为 GroupNameToCode 中的编码
+type MailboxState struct {
+	Id            int   `xorm:"id int unsigned not null pk autoincr" json:"id"`
+	UserId        int   `xorm:"user_id int unsigned notnull unique('uk_user_mailbox') comment('用户id')" json:"user_id"`
+	Mailbox       int   `xorm:"mailbox int unsigned notnull unique('uk_user_mailbox') comment('文件夹')" json:"mailbox"`
+	UIDValidity   int   `xorm:"uid_validity int unsigned notnull default(0) comment('UIDVALIDITY')" json:"uid_validity"`
+	UIDNext       int   `xorm:"uid_next int unsigned notnull default(0) comment('下一个UID，只增不减')" json:"uid_next"`
+	HighestModSeq int64 `xorm:"highest_mod_seq bigint notnull default(0) comment('最大的修改序号')" json:"highest_mod_seq"`
+}
+
+func (p *MailboxState) TableName() string {
+	return "mailbox_state"
+}
+
+// MailboxVanished 移出文件夹或者被删除的邮件，用于 QRESYNC 返回 VANISHED
+type MailboxVanished struct {
+	Id      int   `xorm:"id int unsigned not null pk autoincr" json:"id"`
+	UserId  int   `xorm:"user_id int unsigned notnull index('idx_user_mailbox') comment('用户id')" json:"user_id"`
+	Mailbox int   `xorm:"mailbox int unsigned notnull index('idx_user_mailbox') comment('文件夹')" json:"mailbox"`
+	UID     int   `xorm:"uid int unsigned notnull comment('user_email的id')" json:"uid"`
+	ModSeq  int64 `xorm:"mod_seq bigint notnull default(0) comment('移出时的修改序号')" json:"mod_seq"`
+}
+
+func (p *MailboxVanished) TableName() string {
+	return "mailbox_vanished"
+}
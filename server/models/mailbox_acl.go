@@ -0,0 +1,17 @@
+package models
+
+import "time"
+
+// MailboxAcl 邮箱的访问授权（RFC 4314），所有者可以是共享邮箱或者普通用户
+type MailboxAcl struct {
+	Id         int       `xorm:"id int unsigned not null pk autoincr" json:"id"`
+	OwnerId    int       `xorm:"owner_id int unsigned notnull unique('uk_owner_mailbox_user') comment('邮箱所有者的用户id')" json:"owner_id"`
+	Mailbox    string    `xorm:"mailbox varchar(255) notnull default('') unique('uk_owner_mailbox_user') comment('文件夹完整路径，为空表示整个邮箱的默认权限')" json:"mailbox"`
+	UserId     int       `xorm:"user_id int unsigned notnull unique('uk_owner_mailbox_user') index comment('被授权的用户id，0表示所有用户(anyone)')" json:"user_id"`
+	Rights     string    `xorm:"rights varchar(32) notnull default('') comment('权限字母，lrswipkxtea')" json:"rights"`
+	UpdateTime time.Time `xorm:"update_time updated" json:"update_time"`
+}
+
+func (p *MailboxAcl) TableName() string {
+	return "mailbox_acl"
+}
@@ -0,0 +1,65 @@
+package models
+
+import "time"
+
+// 邮件列表发帖策略
+const (
+	ListPolicyMembers   = 0 // 仅成员可以发帖
+	ListPolicyAnyone    = 1 // 任何人都可以发帖
+	ListPolicyModerated = 2 // 发帖需要审核员审核
+)
+
+type MailingList struct {
+	Id             int       `xorm:"id int unsigned not null pk autoincr" json:"id"`
+	Address        string    `xorm:"address varchar(255) notnull unique comment('列表地址，小写')" json:"address"`
+	Name           string    `xorm:"name varchar(100) notnull default('') comment('列表名称')" json:"name"`
+	Description    string    `xorm:"description varchar(255) notnull default('') comment('列表描述')" json:"description"`
+	PostPolicy     int       `xorm:"post_policy int notnull default(0) comment('发帖策略，0仅成员，1任何人，2需要审核')" json:"post_policy"`
+	ReplyToList    int       `xorm:"reply_to_list int notnull default(0) comment('1回复时默认回复到列表')" json:"reply_to_list"`
+	AllowSubscribe int       `xorm:"allow_subscribe int notnull default(0) comment('1允许发邮件到list+subscribe自行订阅')" json:"allow_subscribe"`
+	Disabled       int       `xorm:"disabled unsigned int not null default(0) comment('0启用，1禁用')" json:"disabled"`
+	CreateTime     time.Time `xorm:"create_time created" json:"create_time"`
+}
+
+func (p *MailingList) TableName() string {
+	return "mailing_list"
+}
+
+type MailingListMember struct {
+	Id         int       `xorm:"id int unsigned not null pk autoincr" json:"id"`
+	ListId     int       `xorm:"list_id int unsigned notnull unique('uk_list_address') comment('列表id')" json:"list_id"`
+	Address    string    `xorm:"address varchar(255) notnull unique('uk_list_address') comment('成员邮箱地址，可以是本地或外部地址，小写')" json:"address"`
+	Moderator  int       `xorm:"moderator int notnull default(0) comment('1审核员')" json:"moderator"`
+	CreateTime time.Time `xorm:"create_time created" json:"create_time"`
+}
+
+func (p *MailingListMember) TableName() string {
+	return "mailing_list_member"
+}
+
+type MailingListPending struct {
+	Id         int       `xorm:"id int unsigned not null pk autoincr" json:"id"`
+	ListId     int       `xorm:"list_id int unsigned notnull index comment('列表id')" json:"list_id"`
+	Sender     string    `xorm:"sender varchar(255) notnull default('') comment('信封发件人')" json:"sender"`
+	Subject    string    `xorm:"subject varchar(1000) notnull default('') comment('邮件标题')" json:"subject"`
+	Raw        string    `xorm:"raw longtext comment('原始邮件')" json:"-"`
+	CreateTime time.Time `xorm:"create_time created" json:"create_time"`
+}
+
+func (p *MailingListPending) TableName() string {
+	return "mailing_list_pending"
+}
+
+// MailingListConfirm 待确认的订阅、退订请求，确认地址中带有token
+type MailingListConfirm struct {
+	Id         int       `xorm:"id int unsigned not null pk autoincr" json:"id"`
+	ListId     int       `xorm:"list_id int unsigned notnull index comment('列表id')" json:"list_id"`
+	Address    string    `xorm:"address varchar(255) notnull default('') comment('申请的邮箱地址，小写')" json:"address"`
+	Action     string    `xorm:"action varchar(20) notnull default('') comment('subscribe或unsubscribe')" json:"action"`
+	Token      string    `xorm:"token varchar(64) notnull unique comment('确认token')" json:"-"`
+	CreateTime time.Time `xorm:"create_time created index" json:"create_time"`
+}
+
+func (p *MailingListConfirm) TableName() string {
+	return "mailing_list_confirm"
+}
@@ -0,0 +1,32 @@
+package models
+
+import "time"
+
+// Quarantine 隔离区中的邮件，隔离期间不在用户的任何文件夹中
+type Quarantine struct {
+	Id         int       `xorm:"id int unsigned not null pk autoincr" json:"id"`
+	UserId     int       `xorm:"user_id int unsigned notnull index comment('用户id')" json:"user_id"`
+	EmailId    int       `xorm:"email_id int unsigned notnull index comment('邮件id')" json:"email_id"`
+	Reason     string    `xorm:"reason varchar(20) notnull default('') comment('隔离原因，spam垃圾邮件，forged伪造发件人')" json:"reason"`
+	Sender     string    `xorm:"sender varchar(255) notnull default('') comment('发件人地址，小写')" json:"sender"`
+	Subject    string    `xorm:"subject varchar(1000) notnull default('') comment('邮件标题')" json:"subject"`
+	Token      string    `xorm:"token varchar(64) notnull default('') comment('链接签名密钥')" json:"-"`
+	Notified   int       `xorm:"notified tinyint(1) notnull default(0) comment('1已经在摘要邮件中通知')" json:"notified"`
+	CreateTime time.Time `xorm:"create_time created index" json:"create_time"`
+}
+
+func (p *Quarantine) TableName() string {
+	return "quarantine"
+}
+
+// SenderAllow 用户信任的发件人，不会被隔离或判定为垃圾邮件
+type SenderAllow struct {
+	Id         int       `xorm:"id int unsigned not null pk autoincr" json:"id"`
+	UserId     int       `xorm:"user_id int unsigned notnull unique('uk_user_address') comment('用户id')" json:"user_id"`
+	Address    string    `xorm:"address varchar(255) notnull unique('uk_user_address') comment('发件人地址，小写')" json:"address"`
+	CreateTime time.Time `xorm:"create_time created" json:"create_time"`
+}
+
+func (p *SenderAllow) TableName() string {
+	return "sender_allow"
+}
@@ -0,0 +1,16 @@
+package models
+
+import "time"
+
+// DomainQuota 域名的存储配额，限制该域名下所有用户的总用量
+type DomainQuota struct {
+	Id          int       `xorm:"id int unsigned not null pk autoincr" json:"id"`
+	Domain      string    `xorm:"domain varchar(255) notnull unique comment('域名，小写')" json:"domain"`
+	Quota       int64     `xorm:"quota bigint notnull default(0) comment('存储配额，单位字节，0不限制')" json:"quota"`
+	QuotaWarned int       `xorm:"quota_warned int notnull default(0) comment('已经发送的配额警告百分比')" json:"quota_warned"`
+	UpdateTime  time.Time `xorm:"update_time updated" json:"update_time"`
+}
+
+func (p *DomainQuota) TableName() string {
+	return "domain_quota"
+}
@@ -0,0 +1,17 @@
+package models
+
+import "time"
+
+// ReplyTemplate 用户保存的回复模板，规则可以使用模板自动回复
+type ReplyTemplate struct {
+	Id         int       `xorm:"id int unsigned not null pk autoincr" json:"id"`
+	UserId     int       `xorm:"user_id int unsigned notnull index comment('用户id')" json:"user_id"`
+	Name       string    `xorm:"name varchar(100) notnull default('') comment('模板名称')" json:"name"`
+	Subject    string    `xorm:"subject varchar(255) notnull default('') comment('回复标题，为空时使用Re: 原标题')" json:"subject"`
+	Content    string    `xorm:"content text comment('回复内容')" json:"content"`
+	UpdateTime time.Time `xorm:"update_time updated" json:"update_time"`
+}
+
+func (p *ReplyTemplate) TableName() string {
+	return "reply_template"
+}
@@ -0,0 +1,17 @@
+package models
+
+import "time"
+
+// SieveScript 用户的Sieve脚本，每个用户最多有一个启用中的脚本
+type SieveScript struct {
+	Id         int       `xorm:"id int unsigned not null pk autoincr" json:"id"`
+	UserId     int       `xorm:"user_id int unsigned notnull unique('uk_user_name') comment('用户id')" json:"user_id"`
+	Name       string    `xorm:"name varchar(128) notnull unique('uk_user_name') comment('脚本名称')" json:"name"`
+	Content    string    `xorm:"content longtext comment('脚本内容')" json:"content"`
+	Active     int       `xorm:"active int notnull default(0) comment('1启用')" json:"active"`
+	UpdateTime time.Time `xorm:"update_time updated" json:"update_time"`
+}
+
+func (p *SieveScript) TableName() string {
+	return "sieve_script"
+}
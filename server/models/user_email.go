@@ -3,13 +3,20 @@ package models
 import "time"
 
 type UserEmail struct {
-	ID      int       `xorm:"id int unsigned not null pk autoincr"`
-	UserID  int       `xorm:"user_id int not null index('idx_eid') index comment('用户id')"`
-	EmailID int       `xorm:"email_id not null index('idx_eid') index comment('信件id')"`
-	IsRead  int8      `xorm:"is_read tinyint(1) comment('是否已读')" json:"is_read"`
-	GroupId int       `xorm:"group_id int notnull default(0) comment('分组id')'" json:"group_id"`
-	Status  int8      `xorm:"status tinyint(4) notnull default(0) comment('0未发送或收件，1已发送，2发送失败，3删除 4草稿 5广告')" json:"status"` // 0未发送或收件，1已发送，2发送失败 3删除 4草稿箱(Drafts)  5骚扰邮件(Junk)
-	Created time.Time `xorm:"create datetime created index('idx_create_time')"`
+	ID       int       `xorm:"id int unsigned not null pk autoincr"`
+	UserID   int       `xorm:"user_id int not null index('idx_eid') index comment('用户id')"`
+	EmailID  int       `xorm:"email_id not null index('idx_eid') index comment('信件id')"`
+	IsRead   int8      `xorm:"is_read tinyint(1) comment('是否已读')" json:"is_read"`
+	GroupId  int       `xorm:"group_id int notnull default(0) comment('分组id')'" json:"group_id"`
+	Status   int8      `xorm:"status tinyint(4) notnull default(0) comment('0未发送或收件，1已发送，2发送失败，3删除 4草稿 5广告')" json:"status"` // 0未发送或收件，1已发送，2发送失败 3删除 4草稿箱(Drafts)  5骚扰邮件(Junk)
+	Tag      string    `xorm:"tag varchar(64) notnull default('') comment('收件地址中的子地址标签，比如alice+github中的github')" json:"tag"`
+	Flagged  int8      `xorm:"flagged tinyint(1) notnull default(0) comment('是否星标')" json:"flagged"`
+	Answered int8      `xorm:"answered tinyint(1) notnull default(0) comment('是否已回复')" json:"answered"`
+	Draft    int8      `xorm:"draft tinyint(1) notnull default(0) comment('是否为草稿')" json:"draft"`
+	Deleted  int8      `xorm:"is_deleted tinyint(1) notnull default(0) comment('IMAP \\Deleted标志，EXPUNGE时删除')" json:"deleted"`
+	Keywords string    `xorm:"keywords varchar(1024) notnull default('') comment('IMAP关键字，空格分隔')" json:"keywords"`
+	ModSeq   int64     `xorm:"mod_seq bigint notnull default(0) comment('IMAP修改序号')" json:"mod_seq"`
+	Created  time.Time `xorm:"create datetime created index('idx_create_time')"`
 }
 
 func (p UserEmail) TableName() string {
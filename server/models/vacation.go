@@ -0,0 +1,32 @@
+package models
+
+import "time"
+
+type Vacation struct {
+	Id         int       `xorm:"id int unsigned not null pk autoincr" json:"id"`
+	UserId     int       `xorm:"user_id int unsigned notnull unique comment('用户id')" json:"user_id"`
+	Enabled    int       `xorm:"enabled int notnull default(0) comment('1开启自动回复')" json:"enabled"`
+	Subject    string    `xorm:"subject varchar(255) notnull default('') comment('回复标题，为空时使用Auto: 原标题')" json:"subject"`
+	Content    string    `xorm:"content text comment('回复内容')" json:"content"`
+	StartTime  time.Time `xorm:"start_time datetime null comment('开始时间，为空表示立即开始')" json:"start_time"`
+	EndTime    time.Time `xorm:"end_time datetime null comment('结束时间，为空表示一直有效')" json:"end_time"`
+	Interval   int       `xorm:"interval_days int notnull default(7) comment('同一发件人的回复间隔天数')" json:"interval"`
+	UpdateTime time.Time `xorm:"update_time updated" json:"update_time"`
+}
+
+func (p *Vacation) TableName() string {
+	return "vacation"
+}
+
+// VacationReply 记录给每个发件人最后一次自动回复的时间
+type VacationReply struct {
+	Id        int       `xorm:"id int unsigned not null pk autoincr" json:"id"`
+	UserId    int       `xorm:"user_id int unsigned notnull unique('uk_user_sender') comment('用户id')" json:"user_id"`
+	Sender    string    `xorm:"sender varchar(255) notnull unique('uk_user_sender') comment('发件人地址，小写')" json:"sender"`
+	Handle    string    `xorm:"handle varchar(64) notnull default('') unique('uk_user_sender') comment('区分不同的自动回复，为空表示自动回复设置，其他为Sieve vacation的:handle')" json:"handle"`
+	ReplyTime time.Time `xorm:"reply_time datetime comment('最后回复时间')" json:"reply_time"`
+}
+
+func (p *VacationReply) TableName() string {
+	return "vacation_reply"
+}
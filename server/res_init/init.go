@@ -14,10 +14,13 @@ import (
 	"github.com/Jinnrry/pmail/listen/http_server"
 	"github.com/Jinnrry/pmail/listen/imap_server"
 	"github.com/Jinnrry/pmail/listen/pop3_server"
+	"github.com/Jinnrry/pmail/listen/sieve_server"
 	"github.com/Jinnrry/pmail/listen/smtp_server"
+	"github.com/Jinnrry/pmail/services/fulltext"
 	"github.com/Jinnrry/pmail/services/setup/ssl"
 	"github.com/Jinnrry/pmail/session"
 	"github.com/Jinnrry/pmail/signal"
+	"github.com/Jinnrry/pmail/utils/context"
 	"github.com/Jinnrry/pmail/utils/file"
 	log "github.com/sirupsen/logrus"
 	// 新增：HTTP 就绪探测
@@ -46,10 +49,15 @@ func Init(serverVersion string) {
 		}
 		session.Init()
 		hooks.Init(serverVersion)
+		// 后台为历史邮件建立全文索引
+		go fulltext.Backfill(&context.Context{})
 		// smtp server start
 		go smtp_server.Start()
 		go smtp_server.StartWithTLS()
 		go smtp_server.StartWithTLSNew()
+		go smtp_server.StartLMTP()
+		// ManageSieve server start
+		go sieve_server.Start()
 		// http server start
 		go http_server.HttpsStart()
 		go http_server.HttpStart()
@@ -64,6 +72,7 @@ func Init(serverVersion string) {
 		go pop3_server.Start()
 		go pop3_server.StartWithTls()
 		// imap server start
+		go imap_server.Start()
 		go imap_server.StarTLS()
 
 		configStr, _ := json.Marshal(config.Instance)
@@ -77,6 +86,7 @@ func Init(serverVersion string) {
 			http_server.HttpStop()
 			pop3_server.Stop()
 			imap_server.Stop()
+			sieve_server.Stop()
 			hooks.Stop()
 		case <-signal.StopChan:
 			log.Infof("Server Stop!")
@@ -85,6 +95,7 @@ func Init(serverVersion string) {
 			http_server.HttpStop()
 			pop3_server.Stop()
 			imap_server.Stop()
+			sieve_server.Stop()
 			hooks.Stop()
 			return
 		}
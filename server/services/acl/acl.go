@@ -0,0 +1,252 @@
+package acl
+
+import (
+	"strings"
+
+	"github.com/Jinnrry/pmail/db"
+	"github.com/Jinnrry/pmail/models"
+	"github.com/Jinnrry/pmail/utils/context"
+	"github.com/Jinnrry/pmail/utils/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+// IMAP 命名空间前缀，其他用户共享出来的邮箱在 Other Users/ 下，共享邮箱在 Shared/ 下
+const (
+	NamespaceOther  = "Other Users/"
+	NamespaceShared = "Shared/"
+)
+
+// 权限（RFC 4314）
+const (
+	Lookup         = "l" // LIST 中可见
+	Read           = "r" // SELECT、FETCH、SEARCH、COPY 出邮件
+	Seen           = "s" // 修改 \Seen 标志
+	Write          = "w" // 修改 \Seen、\Deleted 以外的标志
+	Insert         = "i" // APPEND、COPY 进文件夹
+	Post           = "p" // 以该邮箱的地址发信
+	Create         = "k" // 创建子文件夹
+	DeleteMailbox  = "x" // 删除、重命名文件夹
+	DeleteMessages = "t" // 修改 \Deleted 标志
+	Expunge        = "e" // EXPUNGE，MOVE 出邮件
+	Admin          = "a" // 修改授权
+)
+
+// All 全部权限，邮箱所有者总是拥有全部权限
+const All = "lrswipkxtea"
+
+// Anyone 对所有用户生效的授权标识
+const Anyone = "anyone"
+
+// Normalize 校验权限字母并按 All 中的顺序去重，废弃的 c、d 分别转换为 k、xte
+func Normalize(rights string) (string, error) {
+	rights = strings.ReplaceAll(rights, "c", Create)
+	rights = strings.ReplaceAll(rights, "d", DeleteMailbox+DeleteMessages+Expunge)
+	for _, r := range rights {
+		if !strings.ContainsRune(All, r) {
+			return "", errors.New("invalid right " + string(r))
+		}
+	}
+	var ret strings.Builder
+	for _, r := range All {
+		if strings.ContainsRune(rights, r) {
+			ret.WriteRune(r)
+		}
+	}
+	return ret.String(), nil
+}
+
+// Has 是否拥有required中的全部权限
+func Has(rights, required string) bool {
+	for _, r := range required {
+		if !strings.ContainsRune(rights, r) {
+			return false
+		}
+	}
+	return true
+}
+
+// HasAny 是否拥有required中的任意一个权限
+func HasAny(rights, required string) bool {
+	return strings.ContainsAny(rights, required)
+}
+
+// Owner 按命名空间中的账号查找邮箱所有者，Shared/ 下只能是共享邮箱，Other Users/ 下只能是普通用户
+func Owner(ctx *context.Context, account string, shared bool) *models.User {
+	var user models.User
+	has, err := db.Instance.Where("LOWER(account)=? and disabled=0", strings.ToLower(account)).Get(&user)
+	if err != nil {
+		log.WithContext(ctx).Errorf("SQL Error:%v", err)
+	}
+	if !has || (user.Shared == 1) != shared {
+		return nil
+	}
+	return &user
+}
+
+// Rights 用户对邮箱中文件夹的权限，文件夹单独设置的授权优先于整个邮箱的默认授权，anyone 的授权对所有用户生效
+func Rights(ctx *context.Context, owner *models.User, mailbox string, userId int) string {
+	if owner.ID == userId && owner.Shared == 0 {
+		return All
+	}
+	var entries []*models.MailboxAcl
+	err := db.Instance.Where("owner_id=? and mailbox in (?,?) and user_id in (?,0)", owner.ID, "", mailbox, userId).Find(&entries)
+	if err != nil {
+		log.WithContext(ctx).Errorf("SQL Error:%v", err)
+		return ""
+	}
+	rights := map[int]string{}
+	for _, e := range entries {
+		if _, ok := rights[e.UserId]; !ok || e.Mailbox == mailbox {
+			rights[e.UserId] = e.Rights
+		}
+	}
+	ret, _ := Normalize(rights[userId] + rights[0])
+	return ret
+}
+
+// Entries 文件夹的授权，包括从整个邮箱继承的授权，按被授权用户id返回生效的权限
+func Entries(ctx *context.Context, ownerId int, mailbox string) map[int]string {
+	var entries []*models.MailboxAcl
+	err := db.Instance.Where("owner_id=? and mailbox in (?,?)", ownerId, "", mailbox).Find(&entries)
+	if err != nil {
+		log.WithContext(ctx).Errorf("SQL Error:%v", err)
+	}
+	ret := map[int]string{}
+	for _, e := range entries {
+		if _, ok := ret[e.UserId]; !ok || e.Mailbox == mailbox {
+			ret[e.UserId] = e.Rights
+		}
+	}
+	for userId, rights := range ret {
+		if rights == "" {
+			delete(ret, userId)
+		}
+	}
+	return ret
+}
+
+// Overrides 单独设置了对用户或者 anyone 授权的文件夹，不包括整个邮箱的默认授权
+func Overrides(ctx *context.Context, ownerId int, userId int) []string {
+	var folders []string
+	err := db.Instance.Table(&models.MailboxAcl{}).Where("owner_id=? and mailbox!='' and user_id in (?,0)", ownerId, userId).
+		Distinct("mailbox").OrderBy("mailbox").Find(&folders)
+	if err != nil {
+		log.WithContext(ctx).Errorf("SQL Error:%v", err)
+	}
+	return folders
+}
+
+// Set 设置用户对文件夹的授权，mailbox为空时设置整个邮箱的默认授权。
+// rights为空时文件夹上的授权覆盖为无权限，需要恢复继承时使用 Delete
+func Set(ctx *context.Context, ownerId int, mailbox string, userId int, rights string) error {
+	rights, err := Normalize(rights)
+	if err != nil {
+		return err
+	}
+	if mailbox == "" && rights == "" {
+		return Delete(ctx, ownerId, mailbox, userId)
+	}
+	var entry models.MailboxAcl
+	has, err := db.Instance.Where("owner_id=? and mailbox=? and user_id=?", ownerId, mailbox, userId).Get(&entry)
+	if err != nil {
+		return errors.Wrap(err)
+	}
+	entry.Rights = rights
+	if has {
+		_, err = db.Instance.ID(entry.Id).Cols("rights").Update(&entry)
+	} else {
+		entry.OwnerId, entry.Mailbox, entry.UserId = ownerId, mailbox, userId
+		_, err = db.Instance.Insert(&entry)
+	}
+	if err != nil {
+		return errors.Wrap(err)
+	}
+	return nil
+}
+
+// Delete 删除用户在文件夹上的授权，文件夹恢复使用整个邮箱的默认授权
+func Delete(ctx *context.Context, ownerId int, mailbox string, userId int) error {
+	_, err := db.Instance.Where("owner_id=? and mailbox=? and user_id=?", ownerId, mailbox, userId).Delete(&models.MailboxAcl{})
+	if err != nil {
+		return errors.Wrap(err)
+	}
+	return nil
+}
+
+// Owners 授权给用户访问的邮箱所有者，不包括用户自己
+func Owners(ctx *context.Context, userId int) []*models.User {
+	var users []*models.User
+	err := db.Instance.Where("disabled=0 and id!=? and id in (select owner_id from mailbox_acl where user_id in (?,0) and rights!='')", userId, userId).
+		OrderBy("account").Find(&users)
+	if err != nil {
+		log.WithContext(ctx).Errorf("SQL Error:%v", err)
+	}
+	return users
+}
+
+// Identifier 授权标识对应的用户id，anyone 为0，账号不存在时返回-1
+func Identifier(ctx *context.Context, identifier string) int {
+	if strings.EqualFold(identifier, Anyone) {
+		return 0
+	}
+	identifier, _, _ = strings.Cut(identifier, "@")
+	var user models.User
+	has, err := db.Instance.Where("LOWER(account)=? and shared=0", strings.ToLower(identifier)).Get(&user)
+	if err != nil {
+		log.WithContext(ctx).Errorf("SQL Error:%v", err)
+	}
+	if !has {
+		return -1
+	}
+	return user.ID
+}
+
+// IdentifierName 用户id对应的授权标识
+func IdentifierName(ctx *context.Context, userId int) string {
+	if userId == 0 {
+		return Anyone
+	}
+	var user models.User
+	_, err := db.Instance.ID(userId).Get(&user)
+	if err != nil {
+		log.WithContext(ctx).Errorf("SQL Error:%v", err)
+	}
+	return user.Account
+}
+
+// Prefix 邮箱在IMAP中的路径前缀，比如 Shared/support/，用户自己的邮箱为空
+func Prefix(owner *models.User, userId int) string {
+	if owner.ID == userId && owner.Shared == 0 {
+		return ""
+	}
+	if owner.Shared == 1 {
+		return NamespaceShared + owner.Account + "/"
+	}
+	return NamespaceOther + owner.Account + "/"
+}
+
+// Context 以邮箱所有者身份访问邮箱的上下文，沿用当前请求的日志id
+func Context(ctx *context.Context, owner *models.User) *context.Context {
+	ownerCtx := *ctx
+	ownerCtx.UserID = owner.ID
+	ownerCtx.UserAccount = owner.Account
+	ownerCtx.UserName = owner.Name
+	ownerCtx.IsAdmin = false
+	return &ownerCtx
+}
+
+// DeleteFolder 删除文件夹时一并删除文件夹上单独设置的授权
+func DeleteFolder(ctx *context.Context, ownerId int, mailbox string) {
+	_, err := db.Instance.Where("owner_id=? and mailbox=?", ownerId, mailbox).Delete(&models.MailboxAcl{})
+	if err != nil {
+		log.WithContext(ctx).Errorf("SQL Error:%v", err)
+	}
+}
+
+// RenameFolder 重命名文件夹时授权跟随文件夹
+func RenameFolder(ctx *context.Context, ownerId int, oldName, newName string) {
+	_, err := db.Instance.Where("owner_id=? and mailbox=?", ownerId, oldName).Cols("mailbox").Update(&models.MailboxAcl{Mailbox: newName})
+	if err != nil {
+		log.WithContext(ctx).Errorf("SQL Error:%v", err)
+	}
+}
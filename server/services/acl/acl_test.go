@@ -0,0 +1,84 @@
+package acl
+
+import (
+	"testing"
+
+	"github.com/Jinnrry/pmail/db"
+	"github.com/Jinnrry/pmail/db/dbtest"
+	"github.com/Jinnrry/pmail/models"
+	"github.com/Jinnrry/pmail/utils/context"
+)
+
+func TestNormalize(t *testing.T) {
+	for rights, want := range map[string]string{
+		"":       "",
+		"rl":     "lr",
+		"lrlr":   "lr",
+		"lrcd":   "lrkxte",
+		"aetxkp": "pkxtea",
+	} {
+		got, err := Normalize(rights)
+		if err != nil || got != want {
+			t.Errorf("Normalize(%q) = %q, %v, want %q", rights, got, err, want)
+		}
+	}
+	if _, err := Normalize("lrz"); err == nil {
+		t.Error("Normalize accepted an unknown right")
+	}
+}
+
+func TestRights(t *testing.T) {
+	dbtest.Init(t)
+	ctx := &context.Context{}
+	support := &models.User{Account: "support", Name: "Support", Shared: 1}
+	alice := &models.User{Account: "alice", Name: "Alice"}
+	bob := &models.User{Account: "bob", Name: "Bob"}
+	db.Instance.Insert(support)
+	db.Instance.Insert(alice)
+	db.Instance.Insert(bob)
+
+	if got := Rights(ctx, alice, "INBOX", alice.ID); got != All {
+		t.Errorf("owner rights = %q", got)
+	}
+	if got := Rights(ctx, support, "INBOX", alice.ID); got != "" {
+		t.Errorf("rights without acl = %q", got)
+	}
+
+	// 文件夹上的授权覆盖整个邮箱的授权，anyone 的授权叠加到每个用户
+	Set(ctx, support.ID, "", alice.ID, "lrs")
+	Set(ctx, support.ID, "Archive", alice.ID, "lr")
+	Set(ctx, support.ID, "", 0, "p")
+	if got := Rights(ctx, support, "INBOX", alice.ID); got != "lrsp" {
+		t.Errorf("INBOX rights = %q", got)
+	}
+	if got := Rights(ctx, support, "Archive", alice.ID); got != "lrp" {
+		t.Errorf("Archive rights = %q", got)
+	}
+	if got := Rights(ctx, support, "INBOX", bob.ID); got != "p" {
+		t.Errorf("anyone rights = %q", got)
+	}
+
+	if owners := Owners(ctx, bob.ID); len(owners) != 1 || owners[0].ID != support.ID {
+		t.Errorf("owners = %+v", owners)
+	}
+	if entries := Entries(ctx, support.ID, "Archive"); entries[alice.ID] != "lr" || entries[0] != "p" {
+		t.Errorf("entries = %+v", entries)
+	}
+
+	// 删除文件夹上的授权后恢复继承，整个邮箱的授权设为空时删除
+	Delete(ctx, support.ID, "Archive", alice.ID)
+	if got := Rights(ctx, support, "Archive", alice.ID); got != "lrsp" {
+		t.Errorf("inherited rights = %q", got)
+	}
+	Set(ctx, support.ID, "", 0, "")
+	if owners := Owners(ctx, bob.ID); len(owners) != 0 {
+		t.Errorf("owners after revoking anyone = %+v", owners)
+	}
+
+	if Identifier(ctx, "Alice@example.com") != alice.ID || Identifier(ctx, "anyone") != 0 || Identifier(ctx, "support") != -1 {
+		t.Error("Identifier resolved the wrong user")
+	}
+	if Prefix(support, alice.ID) != "Shared/support/" || Prefix(bob, alice.ID) != "Other Users/bob/" || Prefix(alice, alice.ID) != "" {
+		t.Error("wrong namespace prefix")
+	}
+}
@@ -0,0 +1,264 @@
+package alias
+
+import (
+	"encoding/json"
+	"strings"
+
+	"github.com/Jinnrry/pmail/config"
+	"github.com/Jinnrry/pmail/db"
+	"github.com/Jinnrry/pmail/models"
+	"github.com/Jinnrry/pmail/utils/context"
+	"github.com/Jinnrry/pmail/utils/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+// maxDepth 别名指向别名时的最大展开层数
+const maxDepth = 5
+
+// Target 一个收件人最终对应的投递目标
+type Target struct {
+	// Accounts 本地账号，小写
+	Accounts []string
+	// External 需要转发的外部地址
+	External []string
+	// Tag 子地址标签，比如 alice+github@domain 中的 github
+	Tag string
+	// Lists 邮件列表id
+	Lists []int
+}
+
+func (t *Target) empty() bool {
+	return len(t.Accounts) == 0 && len(t.External) == 0 && len(t.Lists) == 0
+}
+
+// Recipients 一封邮件全部收件人的解析结果
+type Recipients struct {
+	// Accounts 需要投递的本地账号，小写且已去重
+	Accounts []string
+	// External 需要转发的外部地址，已去重
+	External []string
+	// Unknown 本地域名下既不是用户也没有匹配别名的收件人
+	Unknown []string
+	// Targets 每个原始收件人对应的投递目标，非本地域名的收件人不在其中
+	Targets map[string]*Target
+	// Tags 本地账号对应的子地址标签
+	Tags map[string]string
+	// Lists 需要分发的邮件列表id，已去重
+	Lists []int
+}
+
+// Tag 返回投递给该账号时的子地址标签
+func (r *Recipients) Tag(account string) string {
+	if r == nil {
+		return ""
+	}
+	return r.Tags[strings.ToLower(account)]
+}
+
+// IsLocalDomain 是否为本机的收信域名
+func IsLocalDomain(domain string) bool {
+	for _, d := range config.Instance.Domains {
+		if strings.EqualFold(d, domain) {
+			return true
+		}
+	}
+	return strings.EqualFold(config.Instance.Domain, domain)
+}
+
+func splitAddress(address string) (string, string) {
+	address = strings.ToLower(strings.Trim(strings.TrimSpace(address), "<>"))
+	account, domain, ok := strings.Cut(address, "@")
+	if !ok {
+		return address, ""
+	}
+	return account, domain
+}
+
+// Resolve 解析SMTP收件人，依次匹配别名、本地用户、域名catch-all
+func Resolve(ctx *context.Context, addresses []string) (*Recipients, error) {
+	ret := &Recipients{Targets: map[string]*Target{}, Tags: map[string]string{}}
+	accounts := map[string]bool{}
+	external := map[string]bool{}
+	lists := map[int]bool{}
+	for _, address := range addresses {
+		_, domain := splitAddress(address)
+		if !IsLocalDomain(domain) {
+			continue
+		}
+		target, err := ResolveAddress(ctx, address)
+		if err != nil {
+			return nil, err
+		}
+		if target == nil {
+			ret.Unknown = append(ret.Unknown, address)
+			continue
+		}
+		ret.Targets[address] = target
+		for _, account := range target.Accounts {
+			if !accounts[account] {
+				accounts[account] = true
+				ret.Accounts = append(ret.Accounts, account)
+			}
+			if _, ok := ret.Tags[account]; !ok && target.Tag != "" {
+				ret.Tags[account] = target.Tag
+			}
+		}
+		for _, e := range target.External {
+			if !external[e] {
+				external[e] = true
+				ret.External = append(ret.External, e)
+			}
+		}
+		for _, id := range target.Lists {
+			if !lists[id] {
+				lists[id] = true
+				ret.Lists = append(ret.Lists, id)
+			}
+		}
+	}
+	return ret, nil
+}
+
+// ResolveAddress 解析单个本地域名收件人，找不到时返回nil
+func ResolveAddress(ctx *context.Context, address string) (*Target, error) {
+	target := &Target{}
+	if err := resolve(ctx, address, 0, map[string]bool{}, target); err != nil {
+		return nil, err
+	}
+	if target.empty() {
+		return nil, nil
+	}
+	return target, nil
+}
+
+func resolve(ctx *context.Context, address string, depth int, seen map[string]bool, target *Target) error {
+	account, domain := splitAddress(address)
+	if account == "" {
+		return nil
+	}
+	if domain == "" {
+		// 别名目标只写账号时视为本地账号
+		domain = config.Instance.Domain
+	}
+	if !IsLocalDomain(domain) {
+		if depth > 0 {
+			target.External = appendUnique(target.External, account+"@"+domain)
+		}
+		return nil
+	}
+
+	full := account + "@" + domain
+	if depth < maxDepth && !seen[full] {
+		seen[full] = true
+		found, err := expand(ctx, full, depth, seen, target)
+		if err != nil || found {
+			return err
+		}
+	}
+
+	var list models.MailingList
+	has, err := db.Instance.Where("address=? and disabled=0", full).Cols("id").Get(&list)
+	if err != nil {
+		log.WithContext(ctx).Errorf("sql Error :%+v", err)
+		return errors.Wrap(err)
+	}
+	if has {
+		if !containsInt(target.Lists, list.Id) {
+			target.Lists = append(target.Lists, list.Id)
+		}
+		return nil
+	}
+
+	exist, err := db.Instance.Table(&models.User{}).Where("LOWER(account)=?", account).Exist()
+	if err != nil {
+		log.WithContext(ctx).Errorf("sql Error :%+v", err)
+		return errors.Wrap(err)
+	}
+	if exist {
+		target.Accounts = appendUnique(target.Accounts, account)
+		return nil
+	}
+
+	// 去掉子地址标签后重新查找
+	if base, tag, ok := SplitSubaddress(account); ok {
+		before := len(target.Accounts) + len(target.External) + len(target.Lists)
+		if err := resolve(ctx, base+"@"+domain, depth, seen, target); err != nil {
+			return err
+		}
+		if len(target.Accounts)+len(target.External)+len(target.Lists) > before && target.Tag == "" {
+			// 与 user_email.tag 字段长度一致
+			if len(tag) > 64 {
+				tag = tag[:64]
+			}
+			target.Tag = tag
+		}
+		return nil
+	}
+
+	catchAll := "*@" + domain
+	if depth < maxDepth && !seen[catchAll] {
+		seen[catchAll] = true
+		_, err := expand(ctx, catchAll, depth, seen, target)
+		return err
+	}
+	return nil
+}
+
+// expand 展开别名，返回是否存在该别名
+func expand(ctx *context.Context, address string, depth int, seen map[string]bool, target *Target) (bool, error) {
+	var alias models.Alias
+	has, err := db.Instance.Where("address=? and disabled=0", address).Get(&alias)
+	if err != nil {
+		log.WithContext(ctx).Errorf("sql Error :%+v", err)
+		return false, errors.Wrap(err)
+	}
+	if !has {
+		return false, nil
+	}
+	var targets []string
+	if err := json.Unmarshal([]byte(alias.Targets), &targets); err != nil {
+		log.WithContext(ctx).Errorf("Alias %s targets error: %v", address, err)
+		return false, nil
+	}
+	for _, t := range targets {
+		if err := resolve(ctx, t, depth+1, seen, target); err != nil {
+			return true, err
+		}
+	}
+	return true, nil
+}
+
+// SubaddressSeparator 子地址分隔符，未配置时为+
+func SubaddressSeparator() string {
+	if config.Instance.SubaddressSeparator != "" {
+		return config.Instance.SubaddressSeparator
+	}
+	return "+"
+}
+
+// SplitSubaddress 拆分子地址，alice+github 返回 alice、github
+func SplitSubaddress(account string) (string, string, bool) {
+	idx := strings.IndexAny(account, SubaddressSeparator())
+	if idx <= 0 {
+		return account, "", false
+	}
+	return account[:idx], account[idx+1:], true
+}
+
+func appendUnique(list []string, item string) []string {
+	for _, v := range list {
+		if v == item {
+			return list
+		}
+	}
+	return append(list, item)
+}
+
+func containsInt(list []int, item int) bool {
+	for _, v := range list {
+		if v == item {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,129 @@
+package alias
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/Jinnrry/pmail/config"
+	"github.com/Jinnrry/pmail/db"
+	"github.com/Jinnrry/pmail/db/dbtest"
+	"github.com/Jinnrry/pmail/dto"
+	"github.com/Jinnrry/pmail/models"
+	"github.com/Jinnrry/pmail/utils/context"
+)
+
+func initTestDB(t *testing.T) {
+	dbtest.Init(t, func(c *config.Config) {
+		c.Domains = []string{"example.com", "example.org"}
+	})
+	for _, account := range []string{"alice", "bob", "admin"} {
+		if _, err := db.Instance.Insert(&models.User{Account: account, Name: account}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	aliases := []*dto.Alias{
+		{Address: "sales@example.com", Targets: []string{"alice", "bob@example.org", "partner@external.net"}},
+		{Address: "team@example.com", Targets: []string{"sales@example.com", "team@example.com"}},
+		{Address: "bob@example.com", Targets: []string{"bob@example.com", "bob@gmail.com"}},
+		{Address: "*@example.org", Targets: []string{"admin"}},
+		{Address: "off@example.com", Targets: []string{"alice"}, Disabled: 1},
+	}
+	for _, a := range aliases {
+		if _, err := db.Instance.Insert(a.Encode()); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestResolve(t *testing.T) {
+	initTestDB(t)
+	ctx := &context.Context{}
+
+	tests := []struct {
+		address  string
+		accounts []string
+		external []string
+	}{
+		{"Alice@Example.com", []string{"alice"}, nil},
+		{"sales@example.com", []string{"alice", "bob"}, []string{"partner@external.net"}},
+		{"team@example.com", []string{"alice", "bob"}, []string{"partner@external.net"}},
+		{"bob@example.com", []string{"bob"}, []string{"bob@gmail.com"}},
+		{"nobody@example.org", []string{"admin"}, nil},
+		{"alice@example.org", []string{"alice"}, nil},
+	}
+	for _, tt := range tests {
+		target, err := ResolveAddress(ctx, tt.address)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if target == nil {
+			t.Errorf("%s not resolved", tt.address)
+			continue
+		}
+		if !reflect.DeepEqual(target.Accounts, tt.accounts) || !reflect.DeepEqual(target.External, tt.external) {
+			t.Errorf("%s resolved to %v %v, want %v %v", tt.address, target.Accounts, target.External, tt.accounts, tt.external)
+		}
+	}
+
+	for _, address := range []string{"nobody@example.com", "off@example.com"} {
+		if target, _ := ResolveAddress(ctx, address); target != nil {
+			t.Errorf("%s should be unknown, got %+v", address, target)
+		}
+	}
+
+	ret, err := Resolve(ctx, []string{"sales@example.com", "alice@example.com", "nobody@example.com", "someone@remote.net"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(ret.Accounts, []string{"alice", "bob"}) {
+		t.Errorf("Accounts = %v", ret.Accounts)
+	}
+	if !reflect.DeepEqual(ret.Unknown, []string{"nobody@example.com"}) {
+		t.Errorf("Unknown = %v", ret.Unknown)
+	}
+	if len(ret.Targets) != 2 {
+		t.Errorf("Targets = %v", ret.Targets)
+	}
+}
+
+func TestResolveSubaddress(t *testing.T) {
+	initTestDB(t)
+	ctx := &context.Context{}
+	config.Instance.SubaddressSeparator = "+-"
+	if _, err := db.Instance.Insert(&models.User{Account: "no-reply", Name: "no-reply"}); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		address  string
+		accounts []string
+		tag      string
+	}{
+		{"alice+github@example.com", []string{"alice"}, "github"},
+		{"alice-news@example.com", []string{"alice"}, "news"},
+		{"no-reply@example.com", []string{"no-reply"}, ""},
+		{"sales+lead@example.com", []string{"alice", "bob"}, "lead"},
+		{"nobody+x@example.org", []string{"admin"}, "x"},
+	}
+	for _, tt := range tests {
+		target, err := ResolveAddress(ctx, tt.address)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if target == nil || !reflect.DeepEqual(target.Accounts, tt.accounts) || target.Tag != tt.tag {
+			t.Errorf("%s resolved to %+v, want %v %s", tt.address, target, tt.accounts, tt.tag)
+		}
+	}
+
+	if target, _ := ResolveAddress(ctx, "nobody+x@example.com"); target != nil {
+		t.Errorf("unknown subaddress resolved to %+v", target)
+	}
+
+	ret, err := Resolve(ctx, []string{"alice+github@example.com", "alice@example.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ret.Tag("Alice") != "github" {
+		t.Errorf("Tag(alice) = %s", ret.Tag("alice"))
+	}
+}
@@ -0,0 +1,151 @@
+package antivirus
+
+import (
+	"bytes"
+	"fmt"
+	"html"
+	"strings"
+	"time"
+
+	"github.com/Jinnrry/pmail/config"
+	"github.com/Jinnrry/pmail/consts"
+	"github.com/Jinnrry/pmail/dto/parsemail"
+	"github.com/Jinnrry/pmail/utils/clamd"
+	"github.com/Jinnrry/pmail/utils/context"
+	"github.com/Jinnrry/pmail/utils/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// ActionReject 拒收，SMTP返回554
+	ActionReject = "reject"
+	// ActionQuarantine 隔离，邮件正常入库但不进入收件箱
+	ActionQuarantine = "quarantine"
+	// ActionStrip 删除带毒附件，并在正文中添加提示
+	ActionStrip = "strip"
+)
+
+// Verdict 扫描结论
+type Verdict struct {
+	Infected bool
+	Virus    string
+	Action   string
+}
+
+// Enabled 是否配置了clamd
+func Enabled() bool {
+	return config.Instance != nil && config.Instance.ClamdAddress != ""
+}
+
+// Action 当前配置的处理方式
+func Action() string {
+	switch strings.ToLower(config.Instance.VirusAction) {
+	case ActionQuarantine:
+		return ActionQuarantine
+	case ActionStrip:
+		return ActionStrip
+	default:
+		return ActionReject
+	}
+}
+
+func newClient() (*clamd.Client, error) {
+	timeout := time.Duration(config.Instance.ClamdTimeout) * time.Second
+	return clamd.New(config.Instance.ClamdAddress, timeout)
+}
+
+// ScanMessage 扫描原始邮件，未启用时返回nil
+func ScanMessage(ctx *context.Context, raw []byte) (*Verdict, error) {
+	if !Enabled() {
+		return nil, nil
+	}
+	client, err := newClient()
+	if err != nil {
+		return nil, errors.Wrap(err)
+	}
+	ret, err := client.ScanStream(bytes.NewReader(raw))
+	if err != nil {
+		log.WithContext(ctx).Errorf("clamd scan error: %v", err)
+		return nil, errors.Wrap(err)
+	}
+	verdict := &Verdict{
+		Infected: ret.Infected,
+		Virus:    ret.Virus,
+		Action:   Action(),
+	}
+	if verdict.Infected {
+		log.WithContext(ctx).Warnf("Virus Found: %s, Action: %s", verdict.Virus, verdict.Action)
+	}
+	return verdict, nil
+}
+
+// Apply 根据扫描结论处理解析后的邮件，返回需要拒收的错误信息。
+// 发信时隔离没有意义，outbound为true时quarantine等同于reject。
+func Apply(ctx *context.Context, verdict *Verdict, email *parsemail.Email, outbound bool) error {
+	if verdict == nil || !verdict.Infected {
+		return nil
+	}
+	switch verdict.Action {
+	case ActionStrip:
+		return Strip(ctx, email, verdict.Virus)
+	case ActionQuarantine:
+		if outbound {
+			break
+		}
+		email.Status = int(consts.EmailStatusJunk)
+		return nil
+	}
+	return fmt.Errorf("message rejected: virus %s found", verdict.Virus)
+}
+
+// Strip 逐个扫描附件并删除带毒附件，整封邮件带毒但定位不到具体附件时删除全部附件
+func Strip(ctx *context.Context, email *parsemail.Email, virus string) error {
+	client, err := newClient()
+	if err != nil {
+		return errors.Wrap(err)
+	}
+
+	var kept []*parsemail.Attachment
+	var removed []string
+	for _, att := range email.Attachments {
+		ret, err := client.ScanBytes(att.Content)
+		if err != nil {
+			return errors.Wrap(err)
+		}
+		if ret.Infected {
+			removed = append(removed, fmt.Sprintf("%s (%s)", att.Filename, ret.Virus))
+			continue
+		}
+		kept = append(kept, att)
+	}
+	if len(removed) == 0 {
+		for _, att := range email.Attachments {
+			removed = append(removed, fmt.Sprintf("%s (%s)", att.Filename, virus))
+		}
+		kept = nil
+	}
+	if len(removed) == 0 {
+		// 病毒不在附件中，只能拒收
+		return fmt.Errorf("message rejected: virus %s found", virus)
+	}
+
+	log.WithContext(ctx).Infof("Strip infected attachments: %v", removed)
+	email.Attachments = kept
+	addNotice(email, removed)
+	return nil
+}
+
+func addNotice(email *parsemail.Email, removed []string) {
+	notice := "[PMail] The following attachments were removed because they contain a virus:\n"
+	for _, name := range removed {
+		notice += "  - " + name + "\n"
+	}
+	if len(email.Text) > 0 || len(email.HTML) == 0 {
+		email.Text = append([]byte(notice+"\n"), email.Text...)
+	}
+	if len(email.HTML) > 0 {
+		htmlNotice := "<div style=\"border:1px solid #e6a23c;padding:8px;margin-bottom:8px\">" +
+			strings.ReplaceAll(html.EscapeString(strings.TrimSpace(notice)), "\n", "<br>") + "</div>"
+		email.HTML = append([]byte(htmlNotice), email.HTML...)
+	}
+}
@@ -0,0 +1,129 @@
+package antivirus
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/Jinnrry/pmail/config"
+	"github.com/Jinnrry/pmail/consts"
+	"github.com/Jinnrry/pmail/dto/parsemail"
+	"github.com/Jinnrry/pmail/utils/context"
+)
+
+// startFakeClamd 启动一个只支持 INSTREAM 的 clamd，数据中包含 VIRUS 时返回 FOUND
+func startFakeClamd(t *testing.T) string {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func(conn net.Conn) {
+				defer conn.Close()
+				r := bufio.NewReader(conn)
+				if _, err := r.ReadString(0); err != nil {
+					return
+				}
+				var data bytes.Buffer
+				size := make([]byte, 4)
+				for {
+					if _, err := io.ReadFull(r, size); err != nil {
+						return
+					}
+					n := binary.BigEndian.Uint32(size)
+					if n == 0 {
+						break
+					}
+					io.CopyN(&data, r, int64(n))
+				}
+				if strings.Contains(data.String(), "VIRUS") {
+					conn.Write([]byte("stream: Test.Virus FOUND\x00"))
+				} else {
+					conn.Write([]byte("stream: OK\x00"))
+				}
+			}(conn)
+		}
+	}()
+	return ln.Addr().String()
+}
+
+func withConfig(t *testing.T, address, action string) {
+	old := config.Instance
+	config.Instance = &config.Config{ClamdAddress: address, VirusAction: action}
+	t.Cleanup(func() { config.Instance = old })
+}
+
+func newEmail() *parsemail.Email {
+	return &parsemail.Email{
+		Text: []byte("hello"),
+		Attachments: []*parsemail.Attachment{
+			{Filename: "clean.txt", Content: []byte("clean content")},
+			{Filename: "bad.exe", Content: []byte("VIRUS payload")},
+		},
+	}
+}
+
+func TestScanMessageDisabled(t *testing.T) {
+	withConfig(t, "", "")
+	verdict, err := ScanMessage(&context.Context{}, []byte("VIRUS"))
+	if err != nil || verdict != nil {
+		t.Fatalf("disabled scanner returned %+v %v", verdict, err)
+	}
+}
+
+func TestApplyReject(t *testing.T) {
+	withConfig(t, startFakeClamd(t), "")
+	ctx := &context.Context{}
+	verdict, err := ScanMessage(ctx, []byte("raw VIRUS message"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !verdict.Infected || verdict.Action != ActionReject {
+		t.Fatalf("unexpected verdict %+v", verdict)
+	}
+	if err := Apply(ctx, verdict, newEmail(), false); err == nil {
+		t.Fatal("infected message was not rejected")
+	}
+}
+
+func TestApplyQuarantine(t *testing.T) {
+	withConfig(t, startFakeClamd(t), ActionQuarantine)
+	ctx := &context.Context{}
+	email := newEmail()
+	verdict := &Verdict{Infected: true, Virus: "Test.Virus", Action: Action()}
+	if err := Apply(ctx, verdict, email, false); err != nil {
+		t.Fatal(err)
+	}
+	if email.Status != int(consts.EmailStatusJunk) {
+		t.Errorf("quarantined message status = %d", email.Status)
+	}
+	if err := Apply(ctx, verdict, newEmail(), true); err == nil {
+		t.Error("outbound quarantine should reject")
+	}
+}
+
+func TestApplyStrip(t *testing.T) {
+	withConfig(t, startFakeClamd(t), ActionStrip)
+	ctx := &context.Context{}
+	email := newEmail()
+	verdict := &Verdict{Infected: true, Virus: "Test.Virus", Action: Action()}
+	if err := Apply(ctx, verdict, email, false); err != nil {
+		t.Fatal(err)
+	}
+	if len(email.Attachments) != 1 || email.Attachments[0].Filename != "clean.txt" {
+		t.Fatalf("unexpected attachments after strip: %+v", email.Attachments)
+	}
+	if !strings.Contains(string(email.Text), "bad.exe (Test.Virus)") {
+		t.Errorf("notice missing from text: %s", email.Text)
+	}
+}
@@ -0,0 +1,357 @@
+package bayes
+
+import (
+	"html"
+	"math"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/Jinnrry/pmail/config"
+	"github.com/Jinnrry/pmail/db"
+	"github.com/Jinnrry/pmail/dto/parsemail"
+	"github.com/Jinnrry/pmail/models"
+	"github.com/Jinnrry/pmail/utils/context"
+	"github.com/Jinnrry/pmail/utils/errors"
+	"github.com/microcosm-cc/bluemonday"
+	log "github.com/sirupsen/logrus"
+	"xorm.io/builder"
+	"xorm.io/xorm"
+)
+
+const (
+	// DefaultThreshold 默认的垃圾邮件概率阈值
+	DefaultThreshold = 0.9
+	// MinTrained 训练过的垃圾邮件和正常邮件都达到该数量后才开始分类，用户自己的词库不足时使用全局词库
+	MinTrained = 20
+	// maxTokens 每封邮件最多使用的词数量
+	maxTokens = 1000
+	// interesting 分类时只使用概率偏离0.5最多的词
+	interesting = 15
+	// batchSize 每次查询的词数量
+	batchSize = 500
+)
+
+var (
+	stripPolicy = bluemonday.StrictPolicy()
+	urlRegexp   = regexp.MustCompile(`(?i)https?://([a-z0-9.-]+)`)
+)
+
+// Threshold 判定为垃圾邮件的概率阈值，大于等于1时不分类
+func Threshold() float64 {
+	if config.Instance == nil || config.Instance.BayesThreshold <= 0 {
+		return DefaultThreshold
+	}
+	return config.Instance.BayesThreshold
+}
+
+// words 把文本拆分成小写的词。中日韩文字没有空格分隔，按相邻两个字拆分
+func words(text string) []string {
+	var ret []string
+	var word, han []rune
+	flushWord := func() {
+		if n := len(word); n >= 3 && n <= 40 {
+			ret = append(ret, string(word))
+		}
+		word = word[:0]
+	}
+	flushHan := func() {
+		if len(han) == 1 {
+			ret = append(ret, string(han))
+		}
+		for i := 0; i+1 < len(han); i++ {
+			ret = append(ret, string(han[i:i+2]))
+		}
+		han = han[:0]
+	}
+	for _, r := range strings.ToLower(text) {
+		switch {
+		case unicode.In(r, unicode.Han, unicode.Hiragana, unicode.Katakana, unicode.Hangul):
+			flushWord()
+			han = append(han, r)
+		case unicode.IsLetter(r) || unicode.IsNumber(r) || r == '$' || r == '\'' || r == '-':
+			flushHan()
+			word = append(word, r)
+		default:
+			flushWord()
+			flushHan()
+		}
+	}
+	flushWord()
+	flushHan()
+	return ret
+}
+
+// Tokenize 提取邮件中用于分类的词，包括发件人、标题、正文、链接域名和附件类型
+func Tokenize(email *parsemail.Email) []string {
+	seen := map[string]bool{}
+	var ret []string
+	add := func(token string) {
+		if len(ret) >= maxTokens || len(token) > 64 || !utf8.ValidString(token) || seen[token] {
+			return
+		}
+		seen[token] = true
+		ret = append(ret, token)
+	}
+
+	if email.From != nil && email.From.EmailAddress != "" {
+		from := strings.ToLower(email.From.EmailAddress)
+		add("from:" + from)
+		if _, domain, ok := strings.Cut(from, "@"); ok {
+			add("fromdomain:" + domain)
+		}
+	}
+	for _, w := range words(email.Subject) {
+		add("subject:" + w)
+	}
+	for _, m := range urlRegexp.FindAllStringSubmatch(string(email.Text)+string(email.HTML), -1) {
+		add("url:" + strings.ToLower(m[1]))
+	}
+	for _, att := range email.Attachments {
+		add("attachment:" + strings.ToLower(att.ContentType))
+	}
+
+	body := string(email.Text)
+	if strings.TrimSpace(body) == "" {
+		body = html.UnescapeString(stripPolicy.Sanitize(string(email.HTML)))
+	}
+	for _, w := range words(body) {
+		add(w)
+	}
+	return ret
+}
+
+func getStat(ctx *context.Context, userId int) *models.BayesStat {
+	stat := &models.BayesStat{}
+	_, err := db.Instance.Where("user_id=?", userId).Get(stat)
+	if err != nil {
+		log.WithContext(ctx).Errorf("sqlERror :%v", err)
+	}
+	return stat
+}
+
+// getTokens 读取词库中已有的词
+func getTokens(session xorm.Interface, userId int, tokens []string) (map[string]*models.BayesToken, error) {
+	ret := map[string]*models.BayesToken{}
+	for i := 0; i < len(tokens); i += batchSize {
+		var rows []*models.BayesToken
+		err := session.Where(builder.Eq{"user_id": userId}.And(builder.In("token", tokens[i:min(i+batchSize, len(tokens))]))).Find(&rows)
+		if err != nil {
+			return nil, err
+		}
+		for _, row := range rows {
+			ret[row.Token] = row
+		}
+	}
+	return ret, nil
+}
+
+// Classify 返回邮件是垃圾邮件的概率，训练数据不足时返回0.5
+func Classify(ctx *context.Context, userId int, email *parsemail.Email) float64 {
+	owner := userId
+	stat := getStat(ctx, owner)
+	if stat.Spam < MinTrained || stat.Ham < MinTrained {
+		owner = 0
+		stat = getStat(ctx, owner)
+		if stat.Spam < MinTrained || stat.Ham < MinTrained {
+			return 0.5
+		}
+	}
+
+	tokens, err := getTokens(db.Instance, owner, Tokenize(email))
+	if err != nil {
+		log.WithContext(ctx).Errorf("sqlERror :%v", err)
+		return 0.5
+	}
+
+	var probs []float64
+	for _, t := range tokens {
+		if p, ok := tokenProb(t, stat); ok {
+			probs = append(probs, p)
+		}
+	}
+	return combine(probs)
+}
+
+// tokenProb Robinson修正后的单个词的垃圾邮件概率，没有出现过的词返回false
+func tokenProb(t *models.BayesToken, stat *models.BayesStat) (float64, bool) {
+	n := float64(t.Spam + t.Ham)
+	if n <= 0 {
+		return 0, false
+	}
+	spam := math.Min(1, float64(t.Spam)/float64(stat.Spam))
+	ham := math.Min(1, float64(t.Ham)/float64(stat.Ham))
+	p := spam / (spam + ham)
+	// 出现次数少的词向0.5靠拢
+	p = (0.5 + n*p) / (1 + n)
+	return math.Max(0.01, math.Min(0.99, p)), true
+}
+
+// combine 取偏离0.5最多的词计算整封邮件的概率
+func combine(probs []float64) float64 {
+	if len(probs) == 0 {
+		return 0.5
+	}
+	sort.Slice(probs, func(i, j int) bool {
+		return math.Abs(probs[i]-0.5) > math.Abs(probs[j]-0.5)
+	})
+	if len(probs) > interesting {
+		probs = probs[:interesting]
+	}
+	var spam, ham float64
+	for _, p := range probs {
+		spam += math.Log(p)
+		ham += math.Log(1 - p)
+	}
+	return 1 / (1 + math.Exp(ham-spam))
+}
+
+// IsSpam 邮件的垃圾邮件概率超过阈值时返回true
+func IsSpam(ctx *context.Context, userId int, email *parsemail.Email) bool {
+	threshold := Threshold()
+	if threshold >= 1 {
+		return false
+	}
+	p := Classify(ctx, userId, email)
+	log.WithContext(ctx).Debugf("Bayes spam probability: %.4f", p)
+	return p > threshold
+}
+
+// Train 按垃圾邮件或正常邮件训练用户词库和全局词库，已经按相同类别训练过的邮件会跳过
+func Train(ctx *context.Context, userId int, emailIds []int, spam bool) {
+	for _, id := range emailIds {
+		if err := trainEmail(ctx, userId, id, spam); err != nil {
+			log.WithContext(ctx).Errorf("Bayes Train Error:%v", err)
+		}
+	}
+}
+
+func trainEmail(ctx *context.Context, userId, emailId int, spam bool) error {
+	var trained models.BayesTrained
+	has, err := db.Instance.Where("user_id=? and email_id=?", userId, emailId).Get(&trained)
+	if err != nil {
+		return errors.Wrap(err)
+	}
+	if has && (trained.Spam == 1) == spam {
+		return nil
+	}
+
+	var email models.Email
+	found, err := db.Instance.Where("id=?", emailId).Get(&email)
+	if err != nil {
+		return errors.Wrap(err)
+	}
+	if !found {
+		return nil
+	}
+	tokens := Tokenize(parsemail.NewEmailFromModel(email))
+
+	session := db.Instance.NewSession()
+	defer session.Close()
+	if err = session.Begin(); err != nil {
+		return errors.Wrap(err)
+	}
+	for _, owner := range []int{userId, 0} {
+		// 类别变化时先撤销上次的训练
+		if has {
+			if err = update(session, owner, tokens, !spam, -1); err != nil {
+				session.Rollback()
+				return errors.Wrap(err)
+			}
+		}
+		if err = update(session, owner, tokens, spam, 1); err != nil {
+			session.Rollback()
+			return errors.Wrap(err)
+		}
+	}
+
+	trained.Spam = 0
+	if spam {
+		trained.Spam = 1
+	}
+	if has {
+		_, err = session.ID(trained.Id).Cols("spam").Update(&trained)
+	} else {
+		trained.UserId = userId
+		trained.EmailId = emailId
+		_, err = session.Insert(&trained)
+	}
+	if err != nil {
+		session.Rollback()
+		return errors.Wrap(err)
+	}
+	if err = session.Commit(); err != nil {
+		return errors.Wrap(err)
+	}
+	return nil
+}
+
+// update 把词库中这些词的垃圾邮件或正常邮件数量加上delta
+func update(session *xorm.Session, userId int, tokens []string, spam bool, delta int) error {
+	col := "ham"
+	if spam {
+		col = "spam"
+	}
+
+	stat := &models.BayesStat{}
+	has, err := session.Where("user_id=?", userId).Get(stat)
+	if err != nil {
+		return err
+	}
+	if has {
+		query := session.ID(stat.Id)
+		if delta < 0 {
+			query = query.Where(col + " > 0")
+		}
+		_, err = query.Incr(col, delta).Update(&models.BayesStat{})
+	} else if delta > 0 {
+		stat.UserId = userId
+		stat.Spam, stat.Ham = 0, 0
+		if spam {
+			stat.Spam = delta
+		} else {
+			stat.Ham = delta
+		}
+		_, err = session.Insert(stat)
+	}
+	if err != nil {
+		return err
+	}
+
+	exists, err := getTokens(session, userId, tokens)
+	if err != nil {
+		return err
+	}
+	var ids []int
+	var inserts []*models.BayesToken
+	for _, token := range tokens {
+		if t, ok := exists[token]; ok {
+			ids = append(ids, t.Id)
+		} else if delta > 0 {
+			t = &models.BayesToken{UserId: userId, Token: token}
+			if spam {
+				t.Spam = delta
+			} else {
+				t.Ham = delta
+			}
+			inserts = append(inserts, t)
+		}
+	}
+	for i := 0; i < len(ids); i += batchSize {
+		query := session.In("id", ids[i:min(i+batchSize, len(ids))])
+		if delta < 0 {
+			query = query.Where(col + " > 0")
+		}
+		if _, err = query.Incr(col, delta).Update(&models.BayesToken{}); err != nil {
+			return err
+		}
+	}
+	for i := 0; i < len(inserts); i += batchSize {
+		if _, err = session.Insert(inserts[i:min(i+batchSize, len(inserts))]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,89 @@
+package bayes
+
+import (
+	"database/sql"
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/Jinnrry/pmail/config"
+	"github.com/Jinnrry/pmail/db"
+	"github.com/Jinnrry/pmail/db/dbtest"
+	"github.com/Jinnrry/pmail/dto/parsemail"
+	"github.com/Jinnrry/pmail/models"
+	"github.com/Jinnrry/pmail/utils/context"
+)
+
+func TestWords(t *testing.T) {
+	got := words("Hello, WORLD! it's 免费领取 ok $100")
+	want := []string{"hello", "world", "it's", "免费", "费领", "领取", "$100"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("words = %q", got)
+	}
+}
+
+func TestTokenize(t *testing.T) {
+	email := &parsemail.Email{
+		From:        &parsemail.User{EmailAddress: "Shop@Spam.NET"},
+		Subject:     "Cheap pills",
+		HTML:        []byte(`<p>Buy <a href="https://pills.example/buy">now</a></p>`),
+		Attachments: []*parsemail.Attachment{{ContentType: "application/zip"}},
+	}
+	want := []string{"from:shop@spam.net", "fromdomain:spam.net", "subject:cheap", "subject:pills", "url:pills.example", "attachment:application/zip", "buy", "now"}
+	if got := Tokenize(email); !reflect.DeepEqual(got, want) {
+		t.Errorf("tokens = %q", got)
+	}
+}
+
+func insertEmail(t *testing.T, subject, text string) int {
+	e := &models.Email{Subject: subject, FromAddress: "someone@remote.net", Text: sql.NullString{String: text, Valid: true}}
+	if _, err := db.Instance.Insert(e); err != nil {
+		t.Fatal(err)
+	}
+	return e.Id
+}
+
+func TestTrainAndClassify(t *testing.T) {
+	dbtest.Init(t)
+	ctx := &context.Context{}
+
+	var spamIds, hamIds []int
+	for i := 0; i < MinTrained; i++ {
+		spamIds = append(spamIds, insertEmail(t, fmt.Sprintf("winner lottery prize %d", i), "claim your free prize money now"))
+		hamIds = append(hamIds, insertEmail(t, fmt.Sprintf("meeting notes %d", i), "agenda for the project review tomorrow"))
+	}
+	Train(ctx, 1, spamIds, true)
+	Train(ctx, 1, hamIds[1:], false)
+
+	spam := &parsemail.Email{Subject: "lottery winner", Text: []byte("free prize money")}
+	ham := &parsemail.Email{Subject: "project meeting", Text: []byte("review agenda")}
+	if p := Classify(ctx, 1, spam); p != 0.5 {
+		t.Errorf("classify without enough ham = %v", p)
+	}
+
+	// 误判后移出垃圾箱，撤销垃圾邮件的训练
+	Train(ctx, 1, []int{hamIds[0], spamIds[0]}, false)
+	Train(ctx, 1, []int{hamIds[0]}, false)
+	var stat models.BayesStat
+	db.Instance.Where("user_id=1").Get(&stat)
+	if stat.Spam != MinTrained-1 || stat.Ham != MinTrained+1 {
+		t.Errorf("stat = %+v", stat)
+	}
+	Train(ctx, 1, []int{spamIds[0]}, true)
+
+	if !IsSpam(ctx, 1, spam) {
+		t.Errorf("spam probability = %v", Classify(ctx, 1, spam))
+	}
+	if IsSpam(ctx, 1, ham) {
+		t.Errorf("ham probability = %v", Classify(ctx, 1, ham))
+	}
+	// 其他用户使用全局词库
+	if !IsSpam(ctx, 2, spam) {
+		t.Errorf("global spam probability = %v", Classify(ctx, 2, spam))
+	}
+
+	config.Instance.BayesThreshold = 1
+	if IsSpam(ctx, 1, spam) {
+		t.Error("classification should be disabled")
+	}
+}
@@ -4,6 +4,8 @@ import (
 	"github.com/Jinnrry/pmail/consts"
 	"github.com/Jinnrry/pmail/db"
 	"github.com/Jinnrry/pmail/models"
+	"github.com/Jinnrry/pmail/services/fulltext"
+	"github.com/Jinnrry/pmail/services/modseq"
 	"github.com/Jinnrry/pmail/utils/context"
 	log "github.com/sirupsen/logrus"
 	"github.com/spf13/cast"
@@ -18,6 +20,7 @@ func DelEmail(ctx *context.Context, ids []int, forcedDel bool) error {
 	if err := session.Begin(); err != nil {
 		return err
 	}
+	change := modseq.Begin(ctx, session, Eq{"user_id": ctx.UserID, "email_id": ids})
 	for _, id := range ids {
 		err := deleteOne(ctx, session, cast.ToInt64(id), forcedDel)
 		if err != nil {
@@ -25,6 +28,7 @@ func DelEmail(ctx *context.Context, ids []int, forcedDel bool) error {
 			return err
 		}
 	}
+	change.Commit()
 	return session.Commit()
 }
 
@@ -34,9 +38,10 @@ type num struct {
 
 func deleteOne(ctx *context.Context, session *xorm.Session, id int64, forcedDel bool) error {
 	if !forcedDel {
-		_, err := session.Table(&models.UserEmail{}).Where("email_id=? and user_id=?", id, ctx.UserID).Update(map[string]interface{}{
-			"status":   consts.EmailStatusDel,
-			"group_id": 0,
+		// 移到已删除文件夹的邮件使用新的UID
+		_, _, err := modseq.Relocate(ctx, session, Eq{"email_id": id, "user_id": ctx.UserID}, func(ue *models.UserEmail) {
+			ue.Status = consts.EmailStatusDel
+			ue.GroupId = 0
 		})
 		return err
 	}
@@ -55,30 +60,42 @@ func deleteOne(ctx *context.Context, session *xorm.Session, id int64, forcedDel
 	if Num.Num == 0 {
 		var email models.Email
 		_, err = session.Table(&email).Where("id=?", id).Delete(&email)
-
+		fulltext.Delete(ctx, session, cast.ToInt(id))
 	}
 	return err
 }
 
-func DelByUID(ctx *context.Context, ids []int) error {
+// DelByUID 彻底删除邮件，ids 为 user_email 的id，不存在的记录跳过。返回实际删除的id
+func DelByUID(ctx *context.Context, ids []int) ([]int, error) {
 	session := db.Instance.NewSession()
 	defer session.Close()
+	if err := session.Begin(); err != nil {
+		return nil, err
+	}
+	change := modseq.Begin(ctx, session, Eq{"user_id": ctx.UserID, "id": ids})
+	var deleted []int
 	for _, id := range ids {
 		var ue models.UserEmail
-		session.Table("user_email").Where(Eq{"id": id, "user_id": ctx.UserID}).Get(&ue)
-		if ue.ID == 0 {
-			log.WithContext(ctx).Warn("no user email found")
-			return nil
+		has, err := session.Table("user_email").Where(Eq{"id": id, "user_id": ctx.UserID}).Get(&ue)
+		if err != nil {
+			slog.Error("SQLError", slog.Any("err", err))
+			session.Rollback()
+			return nil, err
+		}
+		if !has {
+			log.WithContext(ctx).Warnf("no user email found: %d", id)
+			continue
 		}
 		emailId := ue.EmailID
 
 		// 先删除关联关系
-		_, err := session.Table(&models.UserEmail{}).Where("id=? and user_id=?", id, ctx.UserID).Delete(&ue)
+		_, err = session.Table(&models.UserEmail{}).Where("id=? and user_id=?", id, ctx.UserID).Delete(&ue)
 		if err != nil {
 			slog.Error("SQLError", slog.Any("err", err))
 			session.Rollback()
-			return err
+			return nil, err
 		}
+		deleted = append(deleted, id)
 
 		// 检查email是否还有人有权限
 		var Num num
@@ -86,7 +103,7 @@ func DelByUID(ctx *context.Context, ids []int) error {
 		if err != nil {
 			slog.Error("SQLError", slog.Any("err", err))
 			session.Rollback()
-			return err
+			return nil, err
 		}
 		if Num.Num == 0 {
 			var email models.Email
@@ -94,8 +111,26 @@ func DelByUID(ctx *context.Context, ids []int) error {
 			if err != nil {
 				slog.Error("SQLError", slog.Any("err", err))
 			}
+			fulltext.Delete(ctx, session, emailId)
 		}
 	}
-	session.Commit()
-	return nil
+	change.Commit()
+	if err := session.Commit(); err != nil {
+		return nil, err
+	}
+	return deleted, nil
+}
+
+// TrashByUID 把邮件移到已删除文件夹并去掉 \Deleted 标志，ids 为 user_email 的id。
+// 移到已删除文件夹的邮件使用新的UID，返回移出当前文件夹的原id
+func TrashByUID(ctx *context.Context, ids []int) ([]int, error) {
+	moved, _, err := modseq.Relocate(ctx, nil, Eq{"user_id": ctx.UserID, "id": ids}, func(ue *models.UserEmail) {
+		ue.Status = consts.EmailStatusDel
+		ue.GroupId = 0
+		ue.Deleted = 0
+	})
+	if err != nil {
+		log.WithContext(ctx).Errorf("SQL Error:%v", err)
+	}
+	return moved, err
 }
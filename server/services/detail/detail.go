@@ -9,6 +9,7 @@ import (
 	"github.com/Jinnrry/pmail/dto/response"
 	"github.com/Jinnrry/pmail/models"
 	"github.com/Jinnrry/pmail/services/list"
+	"github.com/Jinnrry/pmail/services/modseq"
 	"github.com/Jinnrry/pmail/utils/array"
 	"github.com/Jinnrry/pmail/utils/context"
 	"github.com/Jinnrry/pmail/utils/errors"
@@ -37,29 +38,39 @@ func GetEmailDetail(ctx *context.Context, id int, markRead bool) (*response.Emai
 	}
 
 	email.IsRead = ue.IsRead
+	email.Flagged = ue.Flagged
+	email.Answered = ue.Answered
+	email.Draft = ue.Draft
+	email.Keywords = ue.Keywords
 
 	if markRead && ue.IsRead == 0 {
 		ue.IsRead = 1
-		_, err = db.Instance.Where("id=?", ue.ID).Update(&ue)
+		_, err = db.Instance.Where("id=?", ue.ID).Cols("is_read").Update(&ue)
 		if err != nil {
 			log.WithContext(ctx).Errorf("SQL error:%+v", err)
 		}
+		modseq.Touch(ctx, nil, Eq{"id": ue.ID})
 	}
 
-	// 将内容中的cid内容替换成url
-	if email.Attachments != "" {
-		var atts []parsemail.Attachment
-		_ = json.Unmarshal([]byte(email.Attachments), &atts)
-		for _, att := range atts {
-			email.Html = sql.NullString{
-				String: strings.ReplaceAll(email.Html.String, fmt.Sprintf("cid:%s", att.ContentID), fmt.Sprintf("/attachments/%d/%s", id, att.ContentID)),
-			}
-		}
-	}
+	ReplaceCid(&email)
 
 	return &email, nil
 }
 
+// ReplaceCid 将内容中的cid内容替换成url
+func ReplaceCid(email *response.EmailResponseData) {
+	if email.Attachments == "" {
+		return
+	}
+	var atts []parsemail.Attachment
+	_ = json.Unmarshal([]byte(email.Attachments), &atts)
+	for _, att := range atts {
+		email.Html = sql.NullString{
+			String: strings.ReplaceAll(email.Html.String, fmt.Sprintf("cid:%s", att.ContentID), fmt.Sprintf("/attachments/%d/%s", email.Id, att.ContentID)),
+		}
+	}
+}
+
 func MakeRead(ctx *context.Context, emailId int, hadRead bool) {
 	ue := models.UserEmail{
 		UserID:  ctx.UserID,
@@ -70,7 +81,9 @@ func MakeRead(ctx *context.Context, emailId int, hadRead bool) {
 		ue.IsRead = 0
 	}
 
+	change := modseq.Begin(ctx, nil, Eq{"email_id": emailId, "user_id": ctx.UserID})
 	db.Instance.Where("email_id = ? and user_id=?", emailId, ctx.UserID).Cols("is_read").Update(&ue)
+	change.Commit()
 }
 
 func FindUE(ctx *context.Context, groupName string, req list.ImapListReq, uid bool) []models.UserEmail {
@@ -0,0 +1,255 @@
+// Package flag 处理邮件的IMAP标志。\Seen、\Flagged、\Answered、\Draft、\Deleted 保存在 user_email 对应的列中，
+// 其他关键字（比如 $work、$Forwarded）以空格分隔保存在 keywords 列
+package flag
+
+import (
+	"strings"
+
+	"github.com/Jinnrry/pmail/db"
+	"github.com/Jinnrry/pmail/models"
+	"github.com/Jinnrry/pmail/services/modseq"
+	"github.com/Jinnrry/pmail/utils/context"
+	"github.com/Jinnrry/pmail/utils/errors"
+	log "github.com/sirupsen/logrus"
+	"xorm.io/builder"
+)
+
+const (
+	Seen     = `\Seen`
+	Flagged  = `\Flagged`
+	Answered = `\Answered`
+	Draft    = `\Draft`
+	Deleted  = `\Deleted`
+)
+
+// Op 修改标志的方式，与IMAP STORE的 FLAGS、+FLAGS、-FLAGS 对应
+type Op int
+
+const (
+	OpSet Op = iota
+	OpAdd
+	OpRemove
+)
+
+// maxKeywordsLength keywords 列的长度
+const maxKeywordsLength = 1024
+
+// Parse 解析空格或逗号分隔的标志列表，丢弃不合法的关键字，系统标志统一大小写
+func Parse(s string) []string {
+	var ret []string
+	for _, f := range strings.FieldsFunc(s, func(r rune) bool {
+		return r == ' ' || r == ',' || r == '\t'
+	}) {
+		if f = normalize(f); f != "" {
+			ret = append(ret, f)
+		}
+	}
+	return ret
+}
+
+// normalize 系统标志转换为标准写法，不合法的关键字返回空字符串
+func normalize(f string) string {
+	if strings.HasPrefix(f, `\`) {
+		for _, sys := range []string{Seen, Flagged, Answered, Draft, Deleted} {
+			if strings.EqualFold(f, sys) {
+				return sys
+			}
+		}
+		return ""
+	}
+	if len(f) > 64 {
+		return ""
+	}
+	for _, c := range []byte(f) {
+		// RFC 3501 atom，不能包含空白、控制字符与特殊字符
+		if c <= 0x20 || c >= 0x7f || strings.IndexByte(`(){%*"\]`, c) >= 0 {
+			return ""
+		}
+	}
+	return f
+}
+
+// Apply 把标志添加到邮件记录中，只修改结构体，不写数据库。暂不支持的系统标志会被忽略
+func Apply(ue *models.UserEmail, flags []string) {
+	keywords := strings.Fields(ue.Keywords)
+	for _, f := range flags {
+		switch f = normalize(f); {
+		case f == Seen:
+			ue.IsRead = 1
+		case f == Flagged:
+			ue.Flagged = 1
+		case f == Answered:
+			ue.Answered = 1
+		case f == Draft:
+			ue.Draft = 1
+		case f == Deleted:
+			ue.Deleted = 1
+		case f == "" || strings.HasPrefix(f, `\`):
+		default:
+			exists := false
+			for _, k := range keywords {
+				if strings.EqualFold(k, f) {
+					exists = true
+					break
+				}
+			}
+			if !exists && len(strings.Join(append(keywords, f), " ")) <= maxKeywordsLength {
+				keywords = append(keywords, f)
+			}
+		}
+	}
+	ue.Keywords = strings.Join(keywords, " ")
+}
+
+// Remove 从邮件记录中去掉标志，只修改结构体，不写数据库
+func Remove(ue *models.UserEmail, flags []string) {
+	var keywords []string
+	for _, k := range strings.Fields(ue.Keywords) {
+		remove := false
+		for _, f := range flags {
+			if strings.EqualFold(k, f) {
+				remove = true
+				break
+			}
+		}
+		if !remove {
+			keywords = append(keywords, k)
+		}
+	}
+	ue.Keywords = strings.Join(keywords, " ")
+
+	for _, f := range flags {
+		switch normalize(f) {
+		case Seen:
+			ue.IsRead = 0
+		case Flagged:
+			ue.Flagged = 0
+		case Answered:
+			ue.Answered = 0
+		case Draft:
+			ue.Draft = 0
+		case Deleted:
+			ue.Deleted = 0
+		}
+	}
+}
+
+// Set 用 flags 替换邮件记录中的全部标志，只修改结构体，不写数据库
+func Set(ue *models.UserEmail, flags []string) {
+	ue.IsRead = 0
+	ue.Flagged = 0
+	ue.Answered = 0
+	ue.Draft = 0
+	ue.Deleted = 0
+	ue.Keywords = ""
+	Apply(ue, flags)
+}
+
+// Flags 邮件记录当前的全部标志，系统标志在前
+func Flags(ue *models.UserEmail) []string {
+	var ret []string
+	if ue.IsRead == 1 {
+		ret = append(ret, Seen)
+	}
+	if ue.Answered == 1 {
+		ret = append(ret, Answered)
+	}
+	if ue.Flagged == 1 {
+		ret = append(ret, Flagged)
+	}
+	if ue.Deleted == 1 {
+		ret = append(ret, Deleted)
+	}
+	if ue.Draft == 1 {
+		ret = append(ret, Draft)
+	}
+	return append(ret, strings.Fields(ue.Keywords)...)
+}
+
+// Has 邮件记录是否有某个标志，关键字不区分大小写
+func Has(ue *models.UserEmail, f string) bool {
+	for _, v := range Flags(ue) {
+		if strings.EqualFold(v, f) {
+			return true
+		}
+	}
+	return false
+}
+
+// Store 按照 op 修改当前用户邮件的标志，ueIds 为 user_email 的id。只有标志发生变化的邮件才会写数据库并分配新的修改序号
+func Store(ctx *context.Context, ueIds []int, op Op, flags []string) error {
+	if len(ueIds) == 0 {
+		return nil
+	}
+	var ues []models.UserEmail
+	err := db.Instance.Where(builder.Eq{"id": ueIds, "user_id": ctx.UserID}).Find(&ues)
+	if err != nil {
+		log.WithContext(ctx).Errorf("sql Error :%+v", err)
+		return errors.Wrap(err)
+	}
+
+	var changed []int
+	for _, ue := range ues {
+		before := ue
+		switch op {
+		case OpAdd:
+			Apply(&ue, flags)
+		case OpRemove:
+			Remove(&ue, flags)
+		default:
+			Set(&ue, flags)
+		}
+		if before.IsRead == ue.IsRead && before.Flagged == ue.Flagged && before.Answered == ue.Answered &&
+			before.Draft == ue.Draft && before.Deleted == ue.Deleted && before.Keywords == ue.Keywords {
+			continue
+		}
+		_, err = db.Instance.Table(&models.UserEmail{}).Where("id=?", ue.ID).
+			Update(map[string]interface{}{"is_read": ue.IsRead, "flagged": ue.Flagged, "answered": ue.Answered, "draft": ue.Draft, "is_deleted": ue.Deleted, "keywords": ue.Keywords})
+		if err != nil {
+			log.WithContext(ctx).Errorf("sql Error :%+v", err)
+			return errors.Wrap(err)
+		}
+		changed = append(changed, ue.ID)
+	}
+	if len(changed) > 0 {
+		modseq.Touch(ctx, nil, builder.Eq{"id": changed})
+	}
+	return nil
+}
+
+// StoreByEmail 与 Store 相同，emailIds 为 email 表的id，用于web接口
+func StoreByEmail(ctx *context.Context, emailIds []int, op Op, flags []string) error {
+	if len(emailIds) == 0 {
+		return nil
+	}
+	var ueIds []int
+	err := db.Instance.Table(&models.UserEmail{}).Where(builder.Eq{"email_id": emailIds, "user_id": ctx.UserID}).Cols("id").Find(&ueIds)
+	if err != nil {
+		log.WithContext(ctx).Errorf("sql Error :%+v", err)
+		return errors.Wrap(err)
+	}
+	return Store(ctx, ueIds, op, flags)
+}
+
+// Add 给用户收到的邮件添加标志
+func Add(ctx *context.Context, userId int, emailId int64, flags []string) error {
+	var ue models.UserEmail
+	has, err := db.Instance.Where("email_id=? and user_id=?", emailId, userId).Get(&ue)
+	if err != nil {
+		log.WithContext(ctx).Errorf("sql Error :%+v", err)
+		return errors.Wrap(err)
+	}
+	if !has {
+		return nil
+	}
+	Apply(&ue, flags)
+	change := modseq.Begin(ctx, nil, builder.Eq{"email_id": emailId, "user_id": userId})
+	_, err = db.Instance.Table(&models.UserEmail{}).Where("email_id=? and user_id=?", emailId, userId).
+		Update(map[string]interface{}{"is_read": ue.IsRead, "flagged": ue.Flagged, "answered": ue.Answered, "draft": ue.Draft, "is_deleted": ue.Deleted, "keywords": ue.Keywords})
+	change.Commit()
+	if err != nil {
+		log.WithContext(ctx).Errorf("sql Error :%+v", err)
+		return errors.Wrap(err)
+	}
+	return nil
+}
@@ -0,0 +1,232 @@
+// Package fulltext 邮件全文索引，使用各个数据库自带的全文检索：SQLite FTS5、PostgreSQL tsvector、MySQL ngram FULLTEXT。
+// 中日韩文字没有空格分隔，SQLite 与 PostgreSQL 写入索引前按两个字一组切分，MySQL 由 ngram 分词器处理。
+// 邮件入库时调用 Index，删除邮件时调用 Delete，历史邮件由 Backfill 在后台补全
+package fulltext
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"html"
+	"strings"
+	"sync/atomic"
+
+	"github.com/Jinnrry/pmail/config"
+	"github.com/Jinnrry/pmail/db"
+	"github.com/Jinnrry/pmail/models"
+	"github.com/Jinnrry/pmail/utils/context"
+	"github.com/microcosm-cc/bluemonday"
+	log "github.com/sirupsen/logrus"
+	"xorm.io/builder"
+)
+
+// backfillBatch 补全历史邮件索引时每批处理的邮件数量
+const backfillBatch = 100
+
+var (
+	stripPolicy = bluemonday.StrictPolicy()
+	backfilling atomic.Bool
+)
+
+// execer xorm 的 Engine 与 Session，删除邮件时需要和删除操作使用同一个 Session
+type execer interface {
+	Exec(sqlOrArgs ...any) (sql.Result, error)
+}
+
+func dbType() string {
+	return config.Instance.DbType
+}
+
+// idColumn 索引表中邮件id的列名
+func idColumn() string {
+	if dbType() == config.DBTypeSQLite {
+		return "rowid"
+	}
+	return "email_id"
+}
+
+// Fields 返回邮件写入索引的两列内容，header 为主题、发件人与收件人，body 为正文与附件名
+func Fields(email *models.Email) (header, body string) {
+	var users []struct{ Name, EmailAddress string }
+	for _, field := range []string{email.To, email.Cc, email.Bcc} {
+		var list []struct{ Name, EmailAddress string }
+		_ = json.Unmarshal([]byte(field), &list)
+		users = append(users, list...)
+	}
+	headers := []string{email.Subject, email.FromName, email.FromAddress}
+	for _, u := range users {
+		headers = append(headers, u.Name, u.EmailAddress)
+	}
+
+	bodies := []string{email.Text.String}
+	if email.Html.Valid {
+		bodies = append(bodies, html.UnescapeString(stripPolicy.Sanitize(email.Html.String)))
+	}
+	var attachments []struct{ Filename string }
+	_ = json.Unmarshal([]byte(email.Attachments), &attachments)
+	for _, att := range attachments {
+		bodies = append(bodies, att.Filename)
+	}
+	return strings.Join(headers, "\n"), strings.Join(bodies, "\n")
+}
+
+// Index 写入或更新一封邮件的索引
+func Index(ctx *context.Context, email *models.Email) {
+	if email == nil || email.Id == 0 {
+		return
+	}
+	if err := index(db.Instance, email); err != nil {
+		log.WithContext(ctx).Errorf("FullText Index Error:%v", err)
+	}
+}
+
+func index(session execer, email *models.Email) error {
+	header, body := Fields(email)
+	bigram := dbType() != config.DBTypeMySQL
+	header, body = document(header, bigram), document(body, bigram)
+
+	var err error
+	switch dbType() {
+	case config.DBTypeSQLite:
+		_, err = session.Exec("DELETE FROM email_fts WHERE rowid=?", email.Id)
+		if err == nil {
+			_, err = session.Exec("INSERT INTO email_fts(rowid, header, body) VALUES (?,?,?)", email.Id, header, body)
+		}
+	case config.DBTypePostgres:
+		_, err = session.Exec(`INSERT INTO email_fts(email_id, header, body, tsv) VALUES (?,?,?,setweight(to_tsvector('simple',?),'A') || setweight(to_tsvector('simple',?),'B'))
+			ON CONFLICT (email_id) DO UPDATE SET header=EXCLUDED.header, body=EXCLUDED.body, tsv=EXCLUDED.tsv`,
+			email.Id, header, body, header, body)
+	case config.DBTypeMySQL:
+		_, err = session.Exec("INSERT INTO email_fts(email_id, header, body) VALUES (?,?,?) ON DUPLICATE KEY UPDATE header=VALUES(header), body=VALUES(body)",
+			email.Id, header, body)
+	}
+	return err
+}
+
+// Delete 删除邮件的索引，session 为删除邮件时使用的 Session，为空时使用 db.Instance
+func Delete(ctx *context.Context, session execer, emailIds ...int) {
+	if len(emailIds) == 0 {
+		return
+	}
+	if session == nil {
+		session = db.Instance
+	}
+	where, params, _ := builder.ToSQL(builder.In(idColumn(), emailIds))
+	_, err := session.Exec(append([]any{"DELETE FROM email_fts WHERE " + where}, params...)...)
+	if err != nil {
+		log.WithContext(ctx).Errorf("FullText Delete Error:%v", err)
+	}
+}
+
+// Match 返回匹配搜索词的子查询，结果列为 email_id 与 score，score 越大越相关。
+// 每个搜索词作为一个短语，多个搜索词同时匹配，最后一个词按前缀匹配。
+// bodyOnly 为 true 时只搜索正文与附件名。搜索词中没有可以检索的内容时 ok 为 false
+func Match(terms []string, bodyOnly bool) (query string, params []any, ok bool) {
+	bigram := dbType() != config.DBTypeMySQL
+	var phrases [][]string
+	for _, term := range terms {
+		if tokens := queryTokens(term, bigram); len(tokens) > 0 {
+			phrases = append(phrases, tokens)
+		}
+	}
+	if len(phrases) == 0 {
+		return "", nil, false
+	}
+
+	var parts []string
+	switch dbType() {
+	case config.DBTypeSQLite:
+		for _, tokens := range phrases {
+			parts = append(parts, fmt.Sprintf(`"%s"*`, strings.Join(tokens, " ")))
+		}
+		expr := strings.Join(parts, " AND ")
+		if bodyOnly {
+			expr = "body : (" + expr + ")"
+		}
+		// bm25 越小越相关，主题与地址的权重高于正文
+		return "SELECT rowid AS email_id, -bm25(email_fts, 5.0, 1.0) AS score FROM email_fts WHERE email_fts MATCH ?", []any{expr}, true
+	case config.DBTypePostgres:
+		// 只搜索正文时限定为正文的权重 B
+		exact, prefix := "", ":*"
+		if bodyOnly {
+			exact, prefix = ":B", ":*B"
+		}
+		for _, tokens := range phrases {
+			lexemes := make([]string, len(tokens))
+			for i, token := range tokens {
+				lexemes[i] = token + exact
+			}
+			lexemes[len(tokens)-1] = tokens[len(tokens)-1] + prefix
+			parts = append(parts, "("+strings.Join(lexemes, " <-> ")+")")
+		}
+		return "SELECT email_id, ts_rank(tsv, q) AS score FROM email_fts, to_tsquery('simple', ?) q WHERE tsv @@ q",
+			[]any{strings.Join(parts, " & ")}, true
+	case config.DBTypeMySQL:
+		for _, tokens := range phrases {
+			parts = append(parts, fmt.Sprintf(`+"%s"`, strings.Join(tokens, " ")))
+		}
+		columns := "header, body"
+		if bodyOnly {
+			columns = "body"
+		}
+		expr := strings.Join(parts, " ")
+		return fmt.Sprintf("SELECT email_id, MATCH(%s) AGAINST (? IN BOOLEAN MODE) AS score FROM email_fts WHERE MATCH(%s) AGAINST (? IN BOOLEAN MODE)", columns, columns),
+			[]any{expr, expr}, true
+	}
+	return "", nil, false
+}
+
+// Filter 返回 emailIds 中匹配搜索词的邮件，搜索词中没有可以检索的内容时 ok 为 false
+func Filter(ctx *context.Context, emailIds []int, terms []string, bodyOnly bool) (matched map[int]bool, ok bool) {
+	query, params, ok := Match(terms, bodyOnly)
+	if !ok {
+		return nil, false
+	}
+	matched = map[int]bool{}
+	for start := 0; start < len(emailIds); start += 500 {
+		end := min(start+500, len(emailIds))
+		where, inParams, _ := builder.ToSQL(builder.In("f.email_id", emailIds[start:end]))
+		var ids []int
+		err := db.Instance.SQL(db.WithContext(ctx, "SELECT f.email_id FROM ("+query+") f WHERE "+where), append(append([]any{}, params...), inParams...)...).Find(&ids)
+		if err != nil {
+			log.WithContext(ctx).Errorf("FullText Search Error:%v", err)
+		}
+		for _, id := range ids {
+			matched[id] = true
+		}
+	}
+	return matched, true
+}
+
+// Backfill 为还没有索引的历史邮件建立索引，在后台运行
+func Backfill(ctx *context.Context) {
+	if !backfilling.CompareAndSwap(false, true) {
+		return
+	}
+	defer backfilling.Store(false)
+
+	total := 0
+	lastId := 0
+	for {
+		var emails []*models.Email
+		err := db.Instance.Where(fmt.Sprintf("id > ? and not exists (select 1 from email_fts f where f.%s=email.id)", idColumn()), lastId).
+			Asc("id").Limit(backfillBatch).Find(&emails)
+		if err != nil {
+			log.WithContext(ctx).Errorf("FullText Backfill Error:%v", err)
+			return
+		}
+		if len(emails) == 0 {
+			break
+		}
+		for _, email := range emails {
+			if err = index(db.Instance, email); err != nil {
+				log.WithContext(ctx).Errorf("FullText Index Error:%v", err)
+			}
+			lastId = email.Id
+		}
+		total += len(emails)
+	}
+	if total > 0 {
+		log.WithContext(ctx).Infof("FullText Backfill Finished. Num: %d", total)
+	}
+}
@@ -0,0 +1,122 @@
+package fulltext
+
+import (
+	"database/sql"
+	"reflect"
+	"testing"
+
+	"github.com/Jinnrry/pmail/db"
+	"github.com/Jinnrry/pmail/db/dbtest"
+	"github.com/Jinnrry/pmail/models"
+	"github.com/Jinnrry/pmail/utils/context"
+)
+
+func TestDocument(t *testing.T) {
+	if got := words("Hello, bob@Remote.net 你好世界abc"); !reflect.DeepEqual(got, []string{"hello", "bob", "remote", "net", "你好世界", "abc"}) {
+		t.Errorf("words = %q", got)
+	}
+	if got := document("你好世界 ok 好", true); got != "你好 好世 世界 界 ok 好" {
+		t.Errorf("document = %q", got)
+	}
+	if got := document("你好世界", false); got != "你好世界" {
+		t.Errorf("document = %q", got)
+	}
+	if got := queryTokens("好世界", true); !reflect.DeepEqual(got, []string{"好世", "世界"}) {
+		t.Errorf("queryTokens = %q", got)
+	}
+}
+
+func search(t *testing.T, ids []int, keyword string, bodyOnly bool) []int {
+	matched, ok := Filter(&context.Context{}, ids, []string{keyword}, bodyOnly)
+	if !ok {
+		t.Fatalf("Filter(%q) not ok", keyword)
+	}
+	var ret []int
+	for _, id := range ids {
+		if matched[id] {
+			ret = append(ret, id)
+		}
+	}
+	return ret
+}
+
+func TestIndex(t *testing.T) {
+	dbtest.Init(t)
+	ctx := &context.Context{}
+	emails := []*models.Email{
+		{Subject: "Quarterly report", FromName: "Bob", FromAddress: "bob@remote.net", To: `[{"Name":"Alice","EmailAddress":"alice@example.com"}]`,
+			Text: sql.NullString{String: "numbers attached", Valid: true}, Attachments: `[{"Filename":"budget-2026.xlsx"}]`},
+		{Subject: "周会通知", FromAddress: "carol@remote.net",
+			Html: sql.NullString{String: "<p class=\"notice\">明天下午<b>三点</b>开会 &amp; report</p>", Valid: true}},
+		{Subject: "Lunch", FromAddress: "dave@remote.net", Text: sql.NullString{String: "see the report", Valid: true}},
+	}
+	var ids []int
+	for _, e := range emails {
+		if _, err := db.Instance.Insert(e); err != nil {
+			t.Fatal(err)
+		}
+		Index(ctx, e)
+		ids = append(ids, e.Id)
+	}
+
+	tests := []struct {
+		keyword  string
+		bodyOnly bool
+		want     []int
+	}{
+		{"report", false, ids},
+		{"report", true, ids[1:]},
+		{"quarter", false, ids[:1]},
+		{"bob@remote.net", false, ids[:1]},
+		{"alice", false, ids[:1]},
+		{"budget", true, ids[:1]},
+		{"下午三点", false, ids[1:2]},
+		{"会", false, ids[1:2]},
+		{"周会", true, nil},
+		{"notice", false, nil},
+	}
+	for _, tt := range tests {
+		if got := search(t, ids, tt.keyword, tt.bodyOnly); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("search(%q, %v) = %v, want %v", tt.keyword, tt.bodyOnly, got, tt.want)
+		}
+	}
+	if _, _, ok := Match([]string{"&&"}, false); ok {
+		t.Errorf("Match without words")
+	}
+
+	// 删除后不再匹配，更新后匹配新内容
+	Delete(ctx, nil, ids[2])
+	emails[0].Subject = "Annual plan"
+	Index(ctx, emails[0])
+	if got := search(t, ids, "report", false); !reflect.DeepEqual(got, ids[1:2]) {
+		t.Errorf("after delete = %v", got)
+	}
+	if got := search(t, ids, "annual", false); !reflect.DeepEqual(got, ids[:1]) {
+		t.Errorf("after update = %v", got)
+	}
+
+	// 补全没有索引的历史邮件
+	Backfill(ctx)
+	if got := search(t, ids, "lunch", false); !reflect.DeepEqual(got, ids[2:]) {
+		t.Errorf("after backfill = %v", got)
+	}
+}
+
+func TestRank(t *testing.T) {
+	dbtest.Init(t)
+	ctx := &context.Context{}
+	body := &models.Email{Subject: "Hello", Text: sql.NullString{String: "invoice", Valid: true}}
+	subject := &models.Email{Subject: "Invoice", Text: sql.NullString{String: "hello", Valid: true}}
+	for _, e := range []*models.Email{body, subject} {
+		db.Instance.Insert(e)
+		Index(ctx, e)
+	}
+	query, params, _ := Match([]string{"invoice"}, false)
+	var ids []int
+	if err := db.Instance.SQL("select email_id from ("+query+") f order by score desc", params...).Find(&ids); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(ids, []int{subject.Id, body.Id}) {
+		t.Errorf("rank = %v", ids)
+	}
+}
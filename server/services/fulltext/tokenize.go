@@ -0,0 +1,97 @@
+package fulltext
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// maxColumnLength 每一列索引内容的最大长度，PostgreSQL 的 tsvector 不能超过1MB
+const maxColumnLength = 300 << 10
+
+// isCJK 中日韩文字之间没有空格，数据库的分词器无法切分
+func isCJK(r rune) bool {
+	return unicode.In(r, unicode.Han, unicode.Hiragana, unicode.Katakana, unicode.Hangul)
+}
+
+// words 把文本切分为小写的词，字母与数字以外的字符都作为分隔符，连续的中日韩文字作为一个词
+func words(s string) []string {
+	var ret []string
+	var b strings.Builder
+	cjk := false
+	flush := func() {
+		if b.Len() > 0 {
+			ret = append(ret, b.String())
+			b.Reset()
+		}
+	}
+	for _, r := range strings.ToLower(s) {
+		switch {
+		case isCJK(r):
+			if !cjk {
+				flush()
+			}
+			cjk = true
+			b.WriteRune(r)
+		case unicode.IsLetter(r) || unicode.IsDigit(r):
+			if cjk {
+				flush()
+			}
+			cjk = false
+			b.WriteRune(r)
+		default:
+			flush()
+		}
+	}
+	flush()
+	return ret
+}
+
+// bigrams 把连续的中日韩文字切分为两个字一组的词，"你好世界" 切分为 "你好 好世 世界"
+func bigrams(word string) []string {
+	runes := []rune(word)
+	if len(runes) < 2 || !isCJK(runes[0]) {
+		return []string{word}
+	}
+	ret := make([]string, 0, len(runes)-1)
+	for i := 0; i+1 < len(runes); i++ {
+		ret = append(ret, string(runes[i:i+2]))
+	}
+	return ret
+}
+
+// document 生成写入索引的内容。cjkBigram 为 true 时中日韩文字按两个字一组切分，
+// 并额外写入最后一个字，使得单个字的前缀查询可以匹配到任意位置
+func document(s string, cjkBigram bool) string {
+	var b strings.Builder
+	for _, word := range words(s) {
+		if b.Len() > maxColumnLength {
+			break
+		}
+		tokens := []string{word}
+		if r, _ := utf8.DecodeRuneInString(word); cjkBigram && isCJK(r) && utf8.RuneCountInString(word) > 1 {
+			_, size := utf8.DecodeLastRuneInString(word)
+			tokens = append(bigrams(word), word[len(word)-size:])
+		}
+		for _, token := range tokens {
+			if b.Len() > 0 {
+				b.WriteByte(' ')
+			}
+			b.WriteString(token)
+		}
+	}
+	return b.String()
+}
+
+// queryTokens 把一个搜索词切分为短语中按顺序排列的词
+func queryTokens(term string, cjkBigram bool) []string {
+	var ret []string
+	for _, word := range words(term) {
+		if cjkBigram {
+			ret = append(ret, bigrams(word)...)
+		} else {
+			ret = append(ret, word)
+		}
+	}
+	return ret
+}
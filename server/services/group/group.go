@@ -7,7 +7,8 @@ import (
 	"github.com/Jinnrry/pmail/db"
 	"github.com/Jinnrry/pmail/dto"
 	"github.com/Jinnrry/pmail/models"
-	"github.com/Jinnrry/pmail/services/del_email"
+	"github.com/Jinnrry/pmail/services/bayes"
+	"github.com/Jinnrry/pmail/services/modseq"
 	"github.com/Jinnrry/pmail/utils/array"
 	"github.com/Jinnrry/pmail/utils/context"
 	"github.com/Jinnrry/pmail/utils/errors"
@@ -53,6 +54,15 @@ func Rename(ctx *context.Context, oldName, newName string) error {
 	return err
 }
 
+// SetSpecialUse 设置文件夹的IMAP特殊用途（RFC 6154），多个用途用空格分隔
+func SetSpecialUse(ctx *context.Context, groupId int, specialUse string) error {
+	_, err := db.Instance.Table("group").Where("id = ? and user_id = ?", groupId, ctx.UserID).Update(map[string]interface{}{"special_use": specialUse})
+	if err != nil {
+		log.WithContext(ctx).Errorf("SQL Error:%v", err)
+	}
+	return err
+}
+
 func GetGroupByName(ctx *context.Context, name string) (*models.Group, error) {
 	var group models.Group
 	db.Instance.Table("group").Where("name = ? and user_id = ?", name, ctx.UserID).Get(&group)
@@ -85,11 +95,15 @@ func DelGroup(ctx *context.Context, groupId int) (bool, error) {
 		return false, errors.Wrap(err)
 	}
 
-	_, err = trans.Exec(db.WithContext(ctx, fmt.Sprintf("update user_email set group_id=0 where group_id in (%s)", array.Join(allGroupIds, ","))))
+	// 分组中的邮件移回收件箱，使用新的UID
+	_, _, err = modseq.Relocate(ctx, trans, builder.Eq{"user_id": ctx.UserID, "group_id": allGroupIds}, func(ue *models.UserEmail) {
+		ue.GroupId = 0
+	})
 	if err != nil {
 		trans.Rollback()
 		return false, errors.Wrap(err)
 	}
+	modseq.Drop(ctx, trans, ctx.UserID, allGroupIds...)
 
 	trans.Commit()
 
@@ -121,20 +135,87 @@ func GetGroupInfoList(ctx *context.Context) []*GroupItem {
 
 // MoveMailToGroup 将某封邮件移动到某个分组中
 func MoveMailToGroup(ctx *context.Context, mailId []int, groupId int) bool {
-	res, err := db.Instance.Exec(db.WithContext(ctx,
-		fmt.Sprintf("update user_email set group_id=? where email_id in (%s) and user_id =?", array.Join(mailId, ","))),
-		groupId, ctx.UserID)
+	junkIds := JunkMailIds(ctx, mailId)
+	_, _, err := modseq.Relocate(ctx, nil, builder.Eq{"user_id": ctx.UserID, "email_id": mailId}, groupUpdate(groupId))
 	if err != nil {
 		log.WithContext(ctx).Errorf("SQL Error:%+v", err)
 		return false
 	}
-	rowNum, err := res.RowsAffected()
+	if len(junkIds) > 0 {
+		bayes.Train(ctx, ctx.UserID, junkIds, false)
+	}
+	return true
+}
+
+// groupUpdate 移到自定义文件夹，垃圾箱中的邮件同时移出垃圾箱，否则在自定义文件夹中看不到
+func groupUpdate(groupId int) func(ue *models.UserEmail) {
+	return func(ue *models.UserEmail) {
+		ue.GroupId = groupId
+		if ue.Status == consts.EmailStatusJunk {
+			ue.Status = 0
+		}
+	}
+}
+
+// defaultBoxUpdate 移到默认文件夹，不是默认文件夹时返回nil
+func defaultBoxUpdate(groupName string) func(ue *models.UserEmail) {
+	var status int8
+	switch groupName {
+	case "INBOX":
+		status = consts.EmailTypeReceive
+	case "Sent Messages":
+		status = consts.EmailStatusSent
+	case "Drafts":
+		status = consts.EmailStatusDrafts
+	case "Deleted Messages":
+		status = consts.EmailStatusDel
+	case "Junk":
+		status = consts.EmailStatusJunk
+	default:
+		return nil
+	}
+	return func(ue *models.UserEmail) {
+		ue.GroupId = 0
+		ue.Status = status
+	}
+}
+
+// MoveUIDs IMAP MOVE，把当前邮箱中的邮件移到默认文件夹或者自定义文件夹，uids 为 user_email 的id。
+// 返回目标文件夹的 UIDVALIDITY、移动的原UID与对应的新UID
+func MoveUIDs(ctx *context.Context, uids []int, dest string) (int, []int, []int, error) {
+	update := defaultBoxUpdate(dest)
+	uidValidity := models.GroupNameToCode[dest]
+	if update == nil {
+		groupInfo, err := GetGroupByFullPath(ctx, dest)
+		if err != nil {
+			return 0, nil, nil, err
+		}
+		if groupInfo == nil || groupInfo.ID == 0 {
+			return 0, nil, nil, errors2.New("Group not found")
+		}
+		update = groupUpdate(groupInfo.ID)
+		uidValidity = groupInfo.ID
+	}
+
+	var rows []*models.UserEmail
+	err := db.Instance.Table(&models.UserEmail{}).Cols("email_id", "status").Where(builder.Eq{"user_id": ctx.UserID, "id": uids}).Find(&rows)
 	if err != nil {
-		log.WithContext(ctx).Errorf("SQL Error:%+v", err)
-		return false
+		return 0, nil, nil, errors.Wrap(err)
+	}
+	var mailIds, junkIds []int
+	for _, ue := range rows {
+		mailIds = append(mailIds, ue.EmailID)
+		if ue.Status == consts.EmailStatusJunk {
+			junkIds = append(junkIds, ue.EmailID)
+		}
 	}
 
-	return rowNum > 0
+	src, destUids, err := modseq.Relocate(ctx, nil, builder.Eq{"user_id": ctx.UserID, "id": uids}, update)
+	if err != nil {
+		return 0, nil, nil, errors.Wrap(err)
+	}
+	TrainJunk(ctx, mailIds, junkIds, dest)
+	return uidValidity, src, destUids, nil
 }
 
 func buildChildren(ctx *context.Context, parentId int) []*GroupItem {
@@ -190,7 +271,7 @@ func IsDefaultBox(box string) bool {
 func GetGroupStatus(ctx *context.Context, groupName string, params []string) (string, map[string]int) {
 	retMap := map[string]int{}
 
-	if !IsDefaultBox(groupName) {
+	if !IsDefaultBox(groupName) && groupName != "All Mail" {
 		groupNames := strings.Split(groupName, "/")
 		groupName = groupNames[len(groupNames)-1]
 
@@ -217,11 +298,8 @@ func GetGroupStatus(ctx *context.Context, groupName string, params []string) (st
 			switch param {
 			case "MESSAGES":
 				db.Instance.Table("user_email").Select("count(1)").Where("group_id=?", group.ID).Get(&value)
-			case "UIDNEXT":
-				db.Instance.Table("user_email").Select("id").Where("group_id=?", group.ID).OrderBy("id desc").Get(&value)
-				value += 1
-			case "UIDVALIDITY":
-				value = group.ID
+			case "UIDNEXT", "UIDVALIDITY", "HIGHESTMODSEQ":
+				value = mailboxState(ctx, group.ID, param)
 			case "UNSEEN":
 				db.Instance.Table("user_email").Select("count(1)").Where("group_id=? and is_read=0", group.ID).Get(&value)
 			}
@@ -241,10 +319,12 @@ func GetGroupStatus(ctx *context.Context, groupName string, params []string) (st
 		switch param {
 		case "MESSAGES":
 			value = getGroupNum(ctx, groupName, false)
-		case "UIDNEXT":
-			value = getNextUID(ctx, groupName)
-		case "UIDVALIDITY":
-			value = models.GroupNameToCode[groupName]
+		case "UIDNEXT", "UIDVALIDITY", "HIGHESTMODSEQ":
+			if groupName == "All Mail" {
+				value = allMailState(ctx, param)
+			} else {
+				value = mailboxState(ctx, models.GroupNameToCode[groupName], param)
+			}
 		case "UNSEEN":
 			value = getGroupNum(ctx, groupName, true)
 		default:
@@ -261,21 +341,39 @@ func GetGroupStatus(ctx *context.Context, groupName string, params []string) (st
 
 }
 
-func getNextUID(ctx *context.Context, groupName string) int {
-	var lastId int
-	switch groupName {
-	case "INBOX":
-		db.Instance.Table("user_email").Select("id").Where("user_id=? and group_id=0 and status = 0", ctx.UserID).OrderBy("id desc").Get(&lastId)
-	case "Sent Messages":
-		db.Instance.Table("user_email").Select("id").Where("user_id=? and group_id=0 and status = 1", ctx.UserID).OrderBy("id desc").Get(&lastId)
-	case "Drafts":
-		db.Instance.Table("user_email").Select("id").Where("user_id=? and group_id=0 and status = 4", ctx.UserID).OrderBy("id desc").Get(&lastId)
-	case "Deleted Messages":
-		db.Instance.Table("user_email").Select("id").Where("user_id=? and status = 3", ctx.UserID).OrderBy("id desc").Get(&lastId)
-	case "Junk":
-		db.Instance.Table("user_email").Select("id").Where("user_id=? and group_id=0 and status = 5", ctx.UserID).OrderBy("id desc").Get(&lastId)
+// mailboxState 从 mailbox_state 中读取文件夹的 UIDNEXT、UIDVALIDITY 与 HIGHESTMODSEQ
+func mailboxState(ctx *context.Context, mailbox int, param string) int {
+	state, err := modseq.State(ctx, nil, ctx.UserID, mailbox)
+	if err != nil {
+		log.WithContext(ctx).Errorf("SQL Error:%v", err)
+		return 0
+	}
+	switch param {
+	case "UIDNEXT":
+		return state.UIDNext
+	case "UIDVALIDITY":
+		return state.UIDValidity
+	case "HIGHESTMODSEQ":
+		return int(state.HighestModSeq)
 	}
-	return lastId + 1
+	return 0
+}
+
+// allMailState 虚拟文件夹 All Mail 的 UIDNEXT 与 UIDVALIDITY。All Mail 中邮件的修改序号属于各自所在的文件夹，
+// 所以 HIGHESTMODSEQ 返回0（NOMODSEQ）
+func allMailState(ctx *context.Context, param string) int {
+	switch param {
+	case "UIDNEXT":
+		var maxId int
+		_, err := db.Instance.Table(&models.UserEmail{}).Select("max(id)").Where("user_id=?", ctx.UserID).Get(&maxId)
+		if err != nil {
+			log.WithContext(ctx).Errorf("SQL Error:%v", err)
+		}
+		return maxId + 1
+	case "UIDVALIDITY":
+		return models.All
+	}
+	return 0
 }
 
 func getGroupNum(ctx *context.Context, groupName string, mustUnread bool) int {
@@ -311,53 +409,53 @@ func getGroupNum(ctx *context.Context, groupName string, mustUnread bool) int {
 		} else {
 			db.Instance.Table("user_email").Select("count(1)").Where("user_id=? and status=5", ctx.UserID).Get(&count)
 		}
+	case "All Mail":
+		if mustUnread {
+			db.Instance.Table("user_email").Select("count(1)").Where("user_id=? and is_read=0 and "+models.AllMailCond, ctx.UserID).Get(&count)
+		} else {
+			db.Instance.Table("user_email").Select("count(1)").Where("user_id=? and "+models.AllMailCond, ctx.UserID).Get(&count)
+		}
 	}
 	return count
 }
 
+// JunkMailIds 返回当前在垃圾箱中的邮件
+func JunkMailIds(ctx *context.Context, mailIds []int) []int {
+	var ret []int
+	err := db.Instance.Table(&models.UserEmail{}).Cols("email_id").Where(builder.Eq{
+		"user_id":  ctx.UserID,
+		"email_id": mailIds,
+		"status":   consts.EmailStatusJunk,
+	}).Find(&ret)
+	if err != nil {
+		log.WithContext(ctx).Errorf("SQL Error:%+v", err)
+	}
+	return ret
+}
+
+// TrainJunk 用户整理邮件时训练贝叶斯分类器，移入垃圾箱的邮件是垃圾邮件，从垃圾箱移到收件箱或自定义文件夹的邮件是正常邮件
+func TrainJunk(ctx *context.Context, mailIds []int, junkIds []int, dest string) {
+	if dest == "Junk" {
+		bayes.Train(ctx, ctx.UserID, mailIds, true)
+	} else if dest == "INBOX" || !IsDefaultBox(dest) {
+		bayes.Train(ctx, ctx.UserID, junkIds, false)
+	}
+}
+
 func Move2DefaultBox(ctx *context.Context, mailIds []int, groupName string) error {
-	switch groupName {
-	case "Deleted Messages":
-		err := del_email.DelEmail(ctx, mailIds, false)
-		if err != nil {
-			return err
-		}
-	case "INBOX":
-		_, err := db.Instance.Table(&models.UserEmail{}).Where(builder.Eq{
-			"user_id":  ctx.UserID,
-			"email_id": mailIds,
-		}).Update(map[string]interface{}{
-			"status":   consts.EmailTypeReceive,
-			"group_id": 0,
-		})
-		return err
-	case "Sent Messages":
-		_, err := db.Instance.Table(&models.UserEmail{}).Where(builder.Eq{
-			"user_id":  ctx.UserID,
-			"email_id": mailIds,
-		}).Update(map[string]interface{}{
-			"status":   consts.EmailStatusSent,
-			"group_id": 0,
-		})
-		return err
-	case "Drafts":
-		_, err := db.Instance.Table(&models.UserEmail{}).Where(builder.Eq{
-			"user_id":  ctx.UserID,
-			"email_id": mailIds,
-		}).Update(map[string]interface{}{
-			"status":   consts.EmailStatusDrafts,
-			"group_id": 0,
-		})
-		return err
-	case "Junk":
-		_, err := db.Instance.Table(&models.UserEmail{}).Where(builder.Eq{
-			"user_id":  ctx.UserID,
-			"email_id": mailIds,
-		}).Update(map[string]interface{}{
-			"status":   consts.EmailStatusJunk,
-			"group_id": 0,
-		})
-		return err
+	junkIds := JunkMailIds(ctx, mailIds)
+	err := move2DefaultBox(ctx, mailIds, groupName)
+	if err == nil {
+		TrainJunk(ctx, mailIds, junkIds, groupName)
 	}
-	return nil
+	return err
+}
+
+func move2DefaultBox(ctx *context.Context, mailIds []int, groupName string) error {
+	update := defaultBoxUpdate(groupName)
+	if update == nil {
+		return nil
+	}
+	_, _, err := modseq.Relocate(ctx, nil, builder.Eq{"user_id": ctx.UserID, "email_id": mailIds}, update)
+	return err
 }
@@ -7,6 +7,8 @@ import (
 	"github.com/Jinnrry/pmail/db"
 	"github.com/Jinnrry/pmail/dto/response"
 	"github.com/Jinnrry/pmail/models"
+	"github.com/Jinnrry/pmail/services/flag"
+	"github.com/Jinnrry/pmail/services/fulltext"
 	"github.com/Jinnrry/pmail/utils/context"
 	"github.com/emersion/go-imap/v2"
 	log "github.com/sirupsen/logrus"
@@ -90,12 +92,17 @@ func SearchEmails(ctx *context.Context, groupName string, criteria *imap.SearchC
 		baseList = filterBySeqNumSets(baseList, criteria.SeqNum)
 	}
 
+	// Filter by mod-sequence (CONDSTORE)
+	if criteria.ModSeq != nil {
+		baseList = filterByModSeq(baseList, criteria.ModSeq.ModSeq)
+	}
+
 	// For more complex filters, we need to fetch email data
 	if needsEmailData(criteria) {
 		baseList = filterWithEmailData(ctx, baseList, criteria)
 	}
 
-	// Filter by flags (is_read status in this implementation)
+	// Filter by flags and keywords
 	if len(criteria.Flag) > 0 || len(criteria.NotFlag) > 0 {
 		baseList = filterByFlags(baseList, criteria.Flag, criteria.NotFlag)
 	}
@@ -131,7 +138,8 @@ func isEmptyCriteria(criteria *imap.SearchCriteria) bool {
 		criteria.Larger == 0 &&
 		criteria.Smaller == 0 &&
 		len(criteria.Not) == 0 &&
-		len(criteria.Or) == 0
+		len(criteria.Or) == 0 &&
+		criteria.ModSeq == nil
 }
 
 // needsEmailData checks if we need to load email data for filtering
@@ -161,6 +169,17 @@ func filterByUIDSets(list []*response.UserEmailUIDData, uidSets []imap.UIDSet) [
 	return result
 }
 
+// filterByModSeq keeps the messages whose mod-sequence is greater than or equal to modSeq
+func filterByModSeq(list []*response.UserEmailUIDData, modSeq uint64) []*response.UserEmailUIDData {
+	var result []*response.UserEmailUIDData
+	for _, item := range list {
+		if uint64(max(item.ModSeq, 1)) >= modSeq {
+			result = append(result, item)
+		}
+	}
+	return result
+}
+
 // filterBySeqNumSets filters the list by sequence number sets
 func filterBySeqNumSets(list []*response.UserEmailUIDData, seqSets []imap.SeqSet) []*response.UserEmailUIDData {
 	var result []*response.UserEmailUIDData
@@ -182,8 +201,8 @@ func filterByFlags(list []*response.UserEmailUIDData, flags []imap.Flag, notFlag
 		match := true
 
 		// Check required flags
-		for _, flag := range flags {
-			if !hasFlag(item, flag) {
+		for _, f := range flags {
+			if !hasFlag(item, f) {
 				match = false
 				break
 			}
@@ -191,8 +210,8 @@ func filterByFlags(list []*response.UserEmailUIDData, flags []imap.Flag, notFlag
 
 		// Check flags that should NOT be present
 		if match {
-			for _, flag := range notFlags {
-				if hasFlag(item, flag) {
+			for _, f := range notFlags {
+				if hasFlag(item, f) {
 					match = false
 					break
 				}
@@ -207,21 +226,14 @@ func filterByFlags(list []*response.UserEmailUIDData, flags []imap.Flag, notFlag
 }
 
 // hasFlag checks if a message has a specific flag
-func hasFlag(item *response.UserEmailUIDData, flag imap.Flag) bool {
-	switch flag {
-	case imap.FlagSeen:
-		return item.IsRead == 1
-	case imap.FlagDeleted:
-		return item.Status == 3
+func hasFlag(item *response.UserEmailUIDData, f imap.Flag) bool {
+	switch f {
 	case imap.FlagDraft:
-		return item.Status == 4
+		return item.Draft == 1 || item.Status == 4
 	case imap.FlagJunk:
-		return item.Status == 5
-	// For flags we don't track, return false
-	case imap.FlagAnswered, imap.FlagFlagged:
-		return false
+		return item.Status == 5 || flag.Has(&item.UserEmail, string(f))
 	default:
-		return false
+		return flag.Has(&item.UserEmail, string(f))
 	}
 }
 
@@ -239,6 +251,37 @@ func filterWithEmailData(ctx *context.Context, list []*response.UserEmailUIDData
 		ueMap[item.EmailID] = item
 	}
 
+	// BODY and TEXT are answered by the full-text index, patterns without
+	// indexable words fall back to in-memory matching
+	rest := *criteria
+	rest.Body, rest.Text = nil, nil
+	for _, search := range []struct {
+		patterns []string
+		bodyOnly bool
+		fallback *[]string
+	}{{criteria.Body, true, &rest.Body}, {criteria.Text, false, &rest.Text}} {
+		for _, pattern := range search.patterns {
+			matched, ok := fulltext.Filter(ctx, emailIDs, []string{pattern}, search.bodyOnly)
+			if !ok {
+				*search.fallback = append(*search.fallback, pattern)
+				continue
+			}
+			var filtered []*response.UserEmailUIDData
+			var filteredIDs []int
+			for _, item := range list {
+				if matched[item.EmailID] {
+					filtered = append(filtered, item)
+					filteredIDs = append(filteredIDs, item.EmailID)
+				}
+			}
+			list, emailIDs = filtered, filteredIDs
+		}
+	}
+	criteria = &rest
+	if len(list) == 0 || !needsEmailData(criteria) {
+		return list
+	}
+
 	// Fetch emails from database
 	var emails []models.Email
 	err := db.Instance.Table("email").In("id", emailIDs).Find(&emails)
@@ -2,11 +2,11 @@ package list
 
 import (
 	"fmt"
-	"github.com/Jinnrry/pmail/consts"
 	"github.com/Jinnrry/pmail/db"
 	"github.com/Jinnrry/pmail/dto"
 	"github.com/Jinnrry/pmail/dto/response"
 	"github.com/Jinnrry/pmail/models"
+	"github.com/Jinnrry/pmail/services/search"
 	"github.com/Jinnrry/pmail/utils/array"
 	"github.com/Jinnrry/pmail/utils/context"
 	log "github.com/sirupsen/logrus"
@@ -14,19 +14,20 @@ import (
 )
 import . "xorm.io/builder"
 
-func GetEmailList(ctx *context.Context, tagInfo dto.SearchTag, keyword string, pop3List bool, offset, limit int) (emailList []*response.EmailResponseData, total int64) {
-	return getList(ctx, tagInfo, keyword, pop3List, offset, limit)
+// GetEmailList 文件夹中的邮件列表，filter 为编译后的搜索语句，没有搜索条件时为空
+func GetEmailList(ctx *context.Context, tagInfo dto.SearchTag, filter *search.Filter, pop3List bool, offset, limit int) (emailList []*response.EmailResponseData, total int64) {
+	return getList(ctx, tagInfo, filter, pop3List, offset, limit)
 }
 
-func getList(ctx *context.Context, tagInfo dto.SearchTag, keyword string, pop3List bool, offset, limit int) (emailList []*response.EmailResponseData, total int64) {
-	querySQL, queryParams := genSQL(ctx, false, tagInfo, keyword, pop3List, offset, limit)
+func getList(ctx *context.Context, tagInfo dto.SearchTag, filter *search.Filter, pop3List bool, offset, limit int) (emailList []*response.EmailResponseData, total int64) {
+	querySQL, queryParams := genSQL(ctx, false, tagInfo, filter, pop3List, offset, limit)
 
 	err := db.Instance.SQL(querySQL, queryParams...).Find(&emailList)
 	if err != nil {
 		log.WithContext(ctx).Errorf("SQL ERROR: %s ,Error:%s", querySQL, err)
 	}
 
-	totalSQL, totalParams := genSQL(ctx, true, tagInfo, keyword, pop3List, offset, limit)
+	totalSQL, totalParams := genSQL(ctx, true, tagInfo, filter, pop3List, offset, limit)
 
 	_, err = db.Instance.SQL(totalSQL, totalParams...).Get(&total)
 	if err != nil {
@@ -36,71 +37,38 @@ func getList(ctx *context.Context, tagInfo dto.SearchTag, keyword string, pop3Li
 	return emailList, total
 }
 
-func genSQL(ctx *context.Context, count bool, tagInfo dto.SearchTag, keyword string, pop3List bool, offset, limit int) (string, []any) {
-	sqlParams := []any{ctx.UserID}
+func genSQL(ctx *context.Context, count bool, tagInfo dto.SearchTag, filter *search.Filter, pop3List bool, offset, limit int) (string, []any) {
+	var sqlParams []any
 	sql := "select "
 
 	if count {
-		sql += `count(1) from email e left join user_email ue on e.id=ue.email_id where ue.user_id = ? `
+		sql += `count(1) from email e left join user_email ue on e.id=ue.email_id `
 	} else if pop3List {
-		sql += `e.id,e.size from email e left join user_email ue on e.id=ue.email_id where ue.user_id = ? `
+		sql += `e.id,e.size from email e left join user_email ue on e.id=ue.email_id `
 	} else {
-		sql += `e.*,ue.is_read from email e left join user_email ue on e.id=ue.email_id where ue.user_id = ? `
-	}
-
-	if tagInfo.Status != -1 {
-		switch tagInfo.Status {
-		case consts.EmailStatusDel:
-			sql += " and (ue.status =? or ue.group_id=?) "
-			sqlParams = append(sqlParams, tagInfo.Status, models.Deleted)
-		case consts.EmailStatusDrafts:
-			sql += " and (ue.status =? or ue.group_id=?) "
-			sqlParams = append(sqlParams, tagInfo.Status, models.Drafts)
-		case consts.EmailStatusJunk:
-			sql += " and (ue.status =? or ue.group_id=?) "
-			sqlParams = append(sqlParams, tagInfo.Status, models.Junk)
-		}
-
-	} else if tagInfo.Status == -1 {
-		if tagInfo.Type != 1 {
-			sql += " and ue.status = 0"
-		} else {
-			// 发件箱不展示已删除的邮件
-			sql += " and ue.status != 3"
-		}
+		sql += `e.*,ue.is_read,ue.flagged,ue.answered,ue.draft,ue.keywords from email e left join user_email ue on e.id=ue.email_id `
 	}
 
-	if tagInfo.Type == consts.EmailTypeReceive {
-		sql += " and type =? "
-		sqlParams = append(sqlParams, tagInfo.Type)
-	} else if tagInfo.Type == consts.EmailTypeSend {
-		sql += " and (type =? or ue.group_id=?)"
-		sqlParams = append(sqlParams, tagInfo.Type, models.Sent)
+	// 全文搜索的结果按相关度排序
+	order := " order by e.id desc"
+	if filter != nil && filter.Rank != "" && !count && !pop3List {
+		sql += "inner join (" + filter.Rank + ") fts on fts.email_id=e.id "
+		sqlParams = append(sqlParams, filter.RankParams...)
+		order = " order by fts.score desc, e.id desc"
 	}
 
-	if tagInfo.GroupId != -1 {
-		if tagInfo.GroupId > 0 {
-			sql += " and ue.group_id=? "
-			sqlParams = append(sqlParams, tagInfo.GroupId)
-		} else if tagInfo.GroupId == 0 && tagInfo.Status == -1 {
-			sql += " and (ue.group_id=? or ue.group_id=0)"
-			sqlParams = append(sqlParams, models.INBOX)
-		}
-	} else {
-		sql += " and (ue.group_id=0 or ue.group_id =?) "
-		sqlParams = append(sqlParams, models.INBOX)
-	}
+	sql += "where ue.user_id = ? "
+	sqlParams = append(sqlParams, ctx.UserID)
 
-	if keyword != "" {
-		sql += " and (subject like ? or text like ? )"
-		sqlParams = append(sqlParams, "%"+keyword+"%", "%"+keyword+"%")
-	}
+	cond, params := genCond(tagInfo, filter)
+	sql += cond
+	sqlParams = append(sqlParams, params...)
 
 	if limit == 0 {
 		limit = 10
 	}
 
-	sql += " order by e.id desc"
+	sql += order
 
 	if limit < 10000 {
 		sql += fmt.Sprintf(" LIMIT %d OFFSET %d ", limit, offset)
@@ -110,6 +78,64 @@ func genSQL(ctx *context.Context, count bool, tagInfo dto.SearchTag, keyword str
 
 }
 
+// genCond 生成文件夹与搜索语句的筛选条件，搜索语句中有 in: 时不再限定当前的文件夹
+func genCond(tagInfo dto.SearchTag, filter *search.Filter) (string, []any) {
+	cond := NewCond()
+	if filter == nil || !filter.HasFolder {
+		cond = cond.And(search.FolderCond(tagInfo))
+	}
+	if filter != nil {
+		cond = cond.And(filter.Cond)
+	}
+	sql, params, err := ToSQL(cond)
+	if err != nil || sql == "" {
+		return "", nil
+	}
+	return " and " + sql, params
+}
+
+// GetThreadList 按会话分组的邮件列表，每个会话只返回文件夹中最新的一封邮件，ThreadCount为会话在文件夹中的邮件数量
+func GetThreadList(ctx *context.Context, tagInfo dto.SearchTag, filter *search.Filter, offset, limit int) (emailList []*response.EmailResponseData, total int64) {
+	cond, params := genCond(tagInfo, filter)
+	params = append([]any{ctx.UserID}, params...)
+	if limit == 0 {
+		limit = 10
+	}
+
+	// 没有分配会话的邮件（thread_id为0）各自作为一个会话
+	_, err := db.Instance.SQL(db.WithContext(ctx, `select count(distinct case when e.thread_id=0 then e.id else e.thread_id end) from email e left join user_email ue on e.id=ue.email_id where ue.user_id = ? `+cond), params...).Get(&total)
+	if err != nil {
+		log.WithContext(ctx).Errorf("SQL ERROR: %s", err)
+	}
+
+	var threads []struct {
+		Id  int
+		Num int
+	}
+	err = db.Instance.SQL(db.WithContext(ctx, fmt.Sprintf(`select max(e.id) as id,count(1) as num from email e left join user_email ue on e.id=ue.email_id where ue.user_id = ? %s group by case when e.thread_id=0 then e.id else e.thread_id end order by max(e.id) desc LIMIT %d OFFSET %d`, cond, limit, offset)), params...).Find(&threads)
+	if err != nil {
+		log.WithContext(ctx).Errorf("SQL ERROR: %s", err)
+	}
+	if len(threads) == 0 {
+		return emailList, total
+	}
+
+	num := map[int]int{}
+	var ids []int
+	for _, t := range threads {
+		num[t.Id] = t.Num
+		ids = append(ids, t.Id)
+	}
+	err = db.Instance.SQL(db.WithContext(ctx, fmt.Sprintf(`select e.*,ue.is_read,ue.flagged,ue.answered,ue.draft,ue.keywords from email e left join user_email ue on e.id=ue.email_id where ue.user_id = ? and e.id in (%s) order by e.id desc`, array.Join(ids, ","))), ctx.UserID).Find(&emailList)
+	if err != nil {
+		log.WithContext(ctx).Errorf("SQL ERROR: %s", err)
+	}
+	for _, email := range emailList {
+		email.ThreadCount = num[email.Id]
+	}
+	return emailList, total
+}
+
 type statRes struct {
 	Total int64
 	Size  int64
@@ -134,7 +160,7 @@ type ImapListReq struct {
 
 func GetUEListByUID(ctx *context.Context, groupName string, star, end int, uidList []int) []*response.UserEmailUIDData {
 	var ue []*response.UserEmailUIDData
-	sql := "SELECT id,email_id, is_read, ROW_NUMBER() OVER (ORDER BY id) AS serial_number FROM `user_email` WHERE user_id = ? "
+	sql := "SELECT id,email_id, is_read, flagged, answered, draft, is_deleted, keywords, mod_seq, ROW_NUMBER() OVER (ORDER BY id) AS serial_number FROM `user_email` WHERE user_id = ? "
 
 	params := []any{ctx.UserID}
 
@@ -166,6 +192,8 @@ func GetUEListByUID(ctx *context.Context, groupName string, star, end int, uidLi
 	case "Junk":
 		sql += " and status =?"
 		params = append(params, 5)
+	case "All Mail":
+		sql += " and " + models.AllMailCond
 	default:
 		groupNames := strings.Split(groupName, "/")
 		groupName = groupNames[len(groupNames)-1]
@@ -187,12 +215,12 @@ func GetUEListByUID(ctx *context.Context, groupName string, star, end int, uidLi
 func getEmailListByUidList(ctx *context.Context, groupName string, req ImapListReq, uid bool) []*response.EmailResponseData {
 	var ret []*response.EmailResponseData
 	var ue []*response.UserEmailUIDData
-	sql := fmt.Sprintf("SELECT id,email_id, is_read, ROW_NUMBER() OVER (ORDER BY id) AS serial_number FROM `user_email` WHERE (user_id = ? and id in (%s) and status = ?)", array.Join(req.UidList, ","))
+	sql := fmt.Sprintf("SELECT id,email_id, is_read, flagged, answered, draft, is_deleted, keywords, mod_seq, ROW_NUMBER() OVER (ORDER BY id) AS serial_number FROM `user_email` WHERE (user_id = ? and id in (%s) and status = ?)", array.Join(req.UidList, ","))
 	if req.Star > 0 && req.End != 0 {
-		sql = fmt.Sprintf("SELECT id,email_id, is_read, ROW_NUMBER() OVER (ORDER BY id) AS serial_number FROM `user_email` WHERE (user_id = ? and id >=%d and id <= %d and status = ?)", req.Star, req.End)
+		sql = fmt.Sprintf("SELECT id,email_id, is_read, flagged, answered, draft, is_deleted, keywords, mod_seq, ROW_NUMBER() OVER (ORDER BY id) AS serial_number FROM `user_email` WHERE (user_id = ? and id >=%d and id <= %d and status = ?)", req.Star, req.End)
 	}
 	if req.Star > 0 && req.End == 0 {
-		sql = fmt.Sprintf("SELECT id,email_id, is_read, ROW_NUMBER() OVER (ORDER BY id) AS serial_number FROM `user_email` WHERE (user_id = ? and id >=%d and status = ?)", req.Star)
+		sql = fmt.Sprintf("SELECT id,email_id, is_read, flagged, answered, draft, is_deleted, keywords, mod_seq, ROW_NUMBER() OVER (ORDER BY id) AS serial_number FROM `user_email` WHERE (user_id = ? and id >=%d and status = ?)", req.Star)
 	}
 
 	var err error
@@ -207,6 +235,8 @@ func getEmailListByUidList(ctx *context.Context, groupName string, req ImapListR
 		err = db.Instance.SQL(sql, ctx.UserID, 3).Find(&ue)
 	case "Junk":
 		err = db.Instance.SQL(sql, ctx.UserID, 5).Find(&ue)
+	case "All Mail":
+		err = db.Instance.SQL(strings.Replace(sql, "status = ?", models.AllMailCond, 1), ctx.UserID).Find(&ue)
 	default:
 		groupNames := strings.Split(groupName, "/")
 		groupName = groupNames[len(groupNames)-1]
@@ -218,7 +248,7 @@ func getEmailListByUidList(ctx *context.Context, groupName string, req ImapListR
 		}
 		err = db.Instance.
 			SQL(fmt.Sprintf(
-				"SELECT * from (SELECT id,email_id, is_read, ROW_NUMBER() OVER (ORDER BY id) AS serial_number FROM `user_email` WHERE (user_id = ? and group_id = ?)) a WHERE serial_number in (%s)",
+				"SELECT * from (SELECT id,email_id, is_read, flagged, answered, draft, is_deleted, keywords, mod_seq, ROW_NUMBER() OVER (ORDER BY id) AS serial_number FROM `user_email` WHERE (user_id = ? and group_id = ?)) a WHERE serial_number in (%s)",
 				array.Join(req.UidList, ","))).
 			Find(&ue, ctx.UserID, group.ID)
 	}
@@ -236,8 +266,14 @@ func getEmailListByUidList(ctx *context.Context, groupName string, req ImapListR
 	_ = db.Instance.Table("email").Select("*").Where(Eq{"id": emailIds}).Find(&ret)
 	for i, data := range ret {
 		ret[i].IsRead = ueMap[data.Id].IsRead
+		ret[i].Flagged = ueMap[data.Id].Flagged
+		ret[i].Answered = ueMap[data.Id].Answered
+		ret[i].Draft = ueMap[data.Id].Draft
+		ret[i].Deleted = ueMap[data.Id].Deleted
+		ret[i].Keywords = ueMap[data.Id].Keywords
 		ret[i].SerialNumber = ueMap[data.Id].SerialNumber
 		ret[i].UeId = ueMap[data.Id].ID
+		ret[i].ModSeq = ueMap[data.Id].ModSeq
 	}
 
 	return ret
@@ -255,12 +291,12 @@ func GetEmailListByGroup(ctx *context.Context, groupName string, req ImapListReq
 	var ret []*response.EmailResponseData
 	var ue []*response.UserEmailUIDData
 
-	sql := fmt.Sprintf("SELECT * from (SELECT id,email_id, is_read, ROW_NUMBER() OVER (ORDER BY id) AS serial_number FROM `user_email` WHERE (user_id = ? and status = ? and group_id=0 )) a WHERE serial_number in (%s)", array.Join(req.UidList, ","))
+	sql := fmt.Sprintf("SELECT * from (SELECT id,email_id, is_read, flagged, answered, draft, is_deleted, keywords, mod_seq, ROW_NUMBER() OVER (ORDER BY id) AS serial_number FROM `user_email` WHERE (user_id = ? and status = ? and group_id=0 )) a WHERE serial_number in (%s)", array.Join(req.UidList, ","))
 	if req.Star > 0 && req.End == 0 {
-		sql = fmt.Sprintf("SELECT * from (SELECT id,email_id, is_read, ROW_NUMBER() OVER (ORDER BY id) AS serial_number FROM `user_email` WHERE (user_id = ? and status = ? and group_id=0 )) a WHERE serial_number >= %d", req.Star)
+		sql = fmt.Sprintf("SELECT * from (SELECT id,email_id, is_read, flagged, answered, draft, is_deleted, keywords, mod_seq, ROW_NUMBER() OVER (ORDER BY id) AS serial_number FROM `user_email` WHERE (user_id = ? and status = ? and group_id=0 )) a WHERE serial_number >= %d", req.Star)
 	}
 	if req.Star > 0 && req.End > 0 {
-		sql = fmt.Sprintf("SELECT * from (SELECT id,email_id, is_read, ROW_NUMBER() OVER (ORDER BY id) AS serial_number FROM `user_email` WHERE (user_id = ? and status = ? and group_id=0 )) a WHERE serial_number >= %d and serial_number <=%d", req.Star, req.End)
+		sql = fmt.Sprintf("SELECT * from (SELECT id,email_id, is_read, flagged, answered, draft, is_deleted, keywords, mod_seq, ROW_NUMBER() OVER (ORDER BY id) AS serial_number FROM `user_email` WHERE (user_id = ? and status = ? and group_id=0 )) a WHERE serial_number >= %d and serial_number <=%d", req.Star, req.End)
 	}
 
 	switch groupName {
@@ -274,6 +310,8 @@ func GetEmailListByGroup(ctx *context.Context, groupName string, req ImapListReq
 		db.Instance.SQL(sql, ctx.UserID, 3).Find(&ue)
 	case "Junk":
 		db.Instance.SQL(sql, ctx.UserID, 5).Find(&ue)
+	case "All Mail":
+		db.Instance.SQL(strings.Replace(sql, "status = ? and group_id=0", models.AllMailCond, 1), ctx.UserID).Find(&ue)
 	default:
 		groupNames := strings.Split(groupName, "/")
 		groupName = groupNames[len(groupNames)-1]
@@ -285,7 +323,7 @@ func GetEmailListByGroup(ctx *context.Context, groupName string, req ImapListReq
 		}
 		db.Instance.
 			SQL(fmt.Sprintf(
-				"SELECT * from (SELECT id,email_id, is_read, ROW_NUMBER() OVER (ORDER BY id) AS serial_number FROM `user_email` WHERE (user_id = ? and group_id = ?)) a WHERE serial_number in (%s)",
+				"SELECT * from (SELECT id,email_id, is_read, flagged, answered, draft, is_deleted, keywords, mod_seq, ROW_NUMBER() OVER (ORDER BY id) AS serial_number FROM `user_email` WHERE (user_id = ? and group_id = ?)) a WHERE serial_number in (%s)",
 				array.Join(req.UidList, ","))).
 			Find(&ue, ctx.UserID, group.ID)
 	}
@@ -300,8 +338,14 @@ func GetEmailListByGroup(ctx *context.Context, groupName string, req ImapListReq
 	_ = db.Instance.Table("email").Select("*").Where(Eq{"id": emailIds}).Find(&ret)
 	for i, data := range ret {
 		ret[i].IsRead = ueMap[data.Id].IsRead
+		ret[i].Flagged = ueMap[data.Id].Flagged
+		ret[i].Answered = ueMap[data.Id].Answered
+		ret[i].Draft = ueMap[data.Id].Draft
+		ret[i].Deleted = ueMap[data.Id].Deleted
+		ret[i].Keywords = ueMap[data.Id].Keywords
 		ret[i].SerialNumber = ueMap[data.Id].SerialNumber
 		ret[i].UeId = ueMap[data.Id].ID
+		ret[i].ModSeq = ueMap[data.Id].ModSeq
 	}
 
 	return ret
@@ -1 +1,40 @@
 package list
+
+import (
+	"testing"
+
+	"github.com/Jinnrry/pmail/db"
+	"github.com/Jinnrry/pmail/db/dbtest"
+	"github.com/Jinnrry/pmail/dto"
+	"github.com/Jinnrry/pmail/models"
+	"github.com/Jinnrry/pmail/utils/context"
+)
+
+func TestGetThreadList(t *testing.T) {
+	dbtest.Init(t)
+	alice := dbtest.User(t, "alice", "Alice")
+	// 两封同一会话的邮件，以及两封没有分配会话的系统通知
+	emails := []*models.Email{
+		{Subject: "hello", ThreadId: 1},
+		{Subject: "Re: hello", ThreadId: 1},
+		{Subject: "Quarantine digest"},
+		{Subject: "Quota warning"},
+	}
+	for _, e := range emails {
+		if _, err := db.Instance.Insert(e); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := db.Instance.Insert(&models.UserEmail{UserID: alice.ID, EmailID: e.Id}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	ctx := &context.Context{UserID: alice.ID}
+	list, total := GetThreadList(ctx, dto.SearchTag{Type: -1, Status: -1, GroupId: -1}, nil, 0, 10)
+	if total != 3 || len(list) != 3 {
+		t.Fatalf("total = %d list = %d", total, len(list))
+	}
+	if list[0].Subject != "Quota warning" || list[0].ThreadCount != 1 || list[2].Subject != "Re: hello" || list[2].ThreadCount != 2 {
+		t.Errorf("list = %s %d, %s %d", list[0].Subject, list[0].ThreadCount, list[2].Subject, list[2].ThreadCount)
+	}
+}
@@ -0,0 +1,401 @@
+package mailinglist
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"mime"
+	"strings"
+	"time"
+
+	"github.com/Jinnrry/pmail/config"
+	"github.com/Jinnrry/pmail/db"
+	"github.com/Jinnrry/pmail/dto/parsemail"
+	"github.com/Jinnrry/pmail/models"
+	"github.com/Jinnrry/pmail/services/alias"
+	"github.com/Jinnrry/pmail/utils/context"
+	"github.com/Jinnrry/pmail/utils/errors"
+	"github.com/Jinnrry/pmail/utils/send"
+	log "github.com/sirupsen/logrus"
+)
+
+// 发到 list+命令@domain 的邮件不会分发，而是作为命令处理
+const (
+	CommandSubscribe   = "subscribe"
+	CommandUnsubscribe = "unsubscribe"
+	CommandBounces     = "bounces"
+	// CommandConfirm 确认地址为 list+confirm-token@domain
+	CommandConfirm = "confirm"
+)
+
+// confirmTTL 订阅、退订请求的确认期限
+const confirmTTL = 3 * 24 * time.Hour
+
+// sendNotice 发送订阅、审核通知，测试时替换
+var sendNotice = send.Send
+
+// ErrPostNotAllowed 发件人没有发帖权限
+var ErrPostNotAllowed = errors.New("posting to this list is restricted to members")
+
+// GetByID 查询启用中的邮件列表，不存在时返回nil
+func GetByID(ctx *context.Context, id int) (*models.MailingList, error) {
+	var list models.MailingList
+	has, err := db.Instance.Where("id=? and disabled=0", id).Get(&list)
+	if err != nil {
+		log.WithContext(ctx).Errorf("sql Error :%+v", err)
+		return nil, errors.Wrap(err)
+	}
+	if !has {
+		return nil, nil
+	}
+	return &list, nil
+}
+
+// Lookup 根据收件地址查找邮件列表，地址可以带有命令，比如 team+unsubscribe@domain
+func Lookup(ctx *context.Context, address string) (*models.MailingList, string, error) {
+	address = strings.ToLower(strings.Trim(strings.TrimSpace(address), "<>"))
+	account, domain, ok := strings.Cut(address, "@")
+	if !ok || !alias.IsLocalDomain(domain) {
+		return nil, "", nil
+	}
+	tag := ""
+	for {
+		var list models.MailingList
+		has, err := db.Instance.Where("address=? and disabled=0", account+"@"+domain).Get(&list)
+		if err != nil {
+			log.WithContext(ctx).Errorf("sql Error :%+v", err)
+			return nil, "", errors.Wrap(err)
+		}
+		if has {
+			return &list, tag, nil
+		}
+		base, t, ok := alias.SplitSubaddress(account)
+		if !ok || tag != "" {
+			return nil, "", nil
+		}
+		account, tag = base, t
+	}
+}
+
+// commandAddress 列表的命令地址，比如 team+unsubscribe@domain
+func commandAddress(list *models.MailingList, command string) string {
+	account, domain, _ := strings.Cut(list.Address, "@")
+	return account + alias.SubaddressSeparator()[:1] + command + "@" + domain
+}
+
+// BounceAddress 分发时使用的信封发件人，退信会回到这个地址而不是原发件人
+func BounceAddress(list *models.MailingList) string {
+	return commandAddress(list, CommandBounces)
+}
+
+func normalize(address string) string {
+	return strings.ToLower(strings.Trim(strings.TrimSpace(address), "<>"))
+}
+
+// IsMember 地址是否为列表成员
+func IsMember(ctx *context.Context, listID int, address string) bool {
+	has, err := db.Instance.Table(&models.MailingListMember{}).Where("list_id=? and address=?", listID, normalize(address)).Exist()
+	if err != nil {
+		log.WithContext(ctx).Errorf("sql Error :%+v", err)
+	}
+	return has
+}
+
+// IsModerator 本地账号是否为列表的审核员
+func IsModerator(ctx *context.Context, listID int, account string) bool {
+	var addresses []string
+	for _, domain := range config.Instance.Domains {
+		addresses = append(addresses, strings.ToLower(account+"@"+domain))
+	}
+	has, err := db.Instance.Table(&models.MailingListMember{}).Where("list_id=? and moderator=1", listID).In("address", addresses).Exist()
+	if err != nil {
+		log.WithContext(ctx).Errorf("sql Error :%+v", err)
+	}
+	return has
+}
+
+// CheckPost 在RCPT阶段检查发件人是否可以向列表发帖，命令地址不做限制
+func CheckPost(ctx *context.Context, list *models.MailingList, command string, sender string) error {
+	if command != "" || list.PostPolicy != models.ListPolicyMembers {
+		return nil
+	}
+	if sender == "" || !IsMember(ctx, list.Id, sender) {
+		return ErrPostNotAllowed
+	}
+	return nil
+}
+
+// Process 处理发给列表的邮件：订阅、退订、退信或者发帖
+func Process(ctx *context.Context, listID int, command string, sender string, raw []byte) {
+	list, err := GetByID(ctx, listID)
+	if err != nil || list == nil {
+		return
+	}
+	sender = normalize(sender)
+
+	switch command {
+	case "":
+	case CommandSubscribe:
+		subscribe(ctx, list, sender)
+		return
+	case CommandUnsubscribe:
+		unsubscribe(ctx, list, sender)
+		return
+	case CommandBounces:
+		log.WithContext(ctx).Infof("Mailing list %s bounce received", list.Address)
+		return
+	default:
+		if token, ok := strings.CutPrefix(command, CommandConfirm+"-"); ok {
+			confirm(ctx, list, token)
+			return
+		}
+		log.WithContext(ctx).Infof("Mailing list %s unknown command %s", list.Address, command)
+		return
+	}
+
+	if sender == "" {
+		// 空发件人一般是退信，不能再分发
+		log.WithContext(ctx).Infof("Mailing list %s drop message with null sender", list.Address)
+		return
+	}
+
+	switch list.PostPolicy {
+	case models.ListPolicyMembers:
+		if !IsMember(ctx, list.Id, sender) {
+			log.WithContext(ctx).Infof("Mailing list %s drop post from non member %s", list.Address, sender)
+			return
+		}
+	case models.ListPolicyModerated:
+		hold(ctx, list, sender, raw)
+		return
+	}
+
+	if err := Distribute(ctx, list, raw); err != nil {
+		log.WithContext(ctx).Errorf("Mailing list %s distribute error: %v", list.Address, err)
+	}
+}
+
+// Distribute 添加列表头后通过发信流程投递给全部成员
+func Distribute(ctx *context.Context, list *models.MailingList, raw []byte) error {
+	headers, body := parsemail.SplitRawMessage(raw)
+	if strings.EqualFold(parsemail.GetRawHeader(headers, "X-Loop"), list.Address) {
+		log.WithContext(ctx).Infof("Mailing list %s loop detected", list.Address)
+		return nil
+	}
+
+	var members []string
+	err := db.Instance.Table(&models.MailingListMember{}).Where("list_id=?", list.Id).Cols("address").Find(&members)
+	if err != nil {
+		return errors.Wrap(err)
+	}
+	if len(members) == 0 {
+		return nil
+	}
+
+	data := parsemail.DkimSign(AddListHeaders(list, headers, body))
+	err, _ = send.Redirect(ctx, data, members, BounceAddress(list))
+	if err != nil {
+		return err
+	}
+	log.WithContext(ctx).Infof("Mailing list %s distributed to %d members", list.Address, len(members))
+	return nil
+}
+
+// AddListHeaders 添加 RFC 2369、RFC 2919 定义的列表头
+func AddListHeaders(list *models.MailingList, headers []*parsemail.RawHeader, body []byte) []byte {
+	name := list.Name
+	if name == "" {
+		name, _, _ = strings.Cut(list.Address, "@")
+	}
+	listID := strings.Replace(list.Address, "@", ".", 1)
+
+	// 去掉上游列表的头，避免重复
+	for _, h := range []string{"List-Id", "List-Post", "List-Unsubscribe", "List-Subscribe", "List-Help", "List-Owner", "List-Archive"} {
+		headers = parsemail.DelRawHeader(headers, h)
+	}
+	headers = append(headers,
+		&parsemail.RawHeader{Name: "List-Id", Value: fmt.Sprintf(" %s <%s>", mime.QEncoding.Encode("utf-8", name), listID)},
+		&parsemail.RawHeader{Name: "List-Post", Value: fmt.Sprintf(" <mailto:%s>", list.Address)},
+		&parsemail.RawHeader{Name: "List-Unsubscribe", Value: fmt.Sprintf(" <mailto:%s>", commandAddress(list, CommandUnsubscribe))},
+	)
+	if list.AllowSubscribe == 1 {
+		headers = append(headers, &parsemail.RawHeader{Name: "List-Subscribe", Value: fmt.Sprintf(" <mailto:%s>", commandAddress(list, CommandSubscribe))})
+	}
+	headers = parsemail.SetRawHeader(headers, "Precedence", "list")
+	headers = parsemail.SetRawHeader(headers, "X-Loop", list.Address)
+	if list.ReplyToList == 1 {
+		headers = parsemail.SetRawHeader(headers, "Reply-To", fmt.Sprintf("<%s>", list.Address))
+	}
+	return parsemail.JoinRawMessage(headers, body)
+}
+
+func subscribe(ctx *context.Context, list *models.MailingList, sender string) {
+	if list.AllowSubscribe != 1 || sender == "" {
+		log.WithContext(ctx).Infof("Mailing list %s subscribe from %s ignored", list.Address, sender)
+		return
+	}
+	if IsMember(ctx, list.Id, sender) {
+		return
+	}
+	requestConfirm(ctx, list, sender, CommandSubscribe)
+}
+
+func unsubscribe(ctx *context.Context, list *models.MailingList, sender string) {
+	if sender == "" || !IsMember(ctx, list.Id, sender) {
+		return
+	}
+	requestConfirm(ctx, list, sender, CommandUnsubscribe)
+}
+
+// requestConfirm 保存待确认的请求，并把确认地址发给申请的地址。信封发件人可以伪造，
+// 只有能收到确认邮件的人才能完成订阅或退订
+func requestConfirm(ctx *context.Context, list *models.MailingList, sender string, action string) {
+	_, err := db.Instance.Where("create_time < ?", time.Now().Add(-confirmTTL)).Delete(&models.MailingListConfirm{})
+	if err != nil {
+		log.WithContext(ctx).Errorf("sql Error :%+v", err)
+	}
+	// 已经有待确认的请求时不再重复发送，避免被用来向第三方发送大量邮件
+	has, err := db.Instance.Where("list_id=? and address=? and action=?", list.Id, sender, action).Exist(&models.MailingListConfirm{})
+	if err != nil {
+		log.WithContext(ctx).Errorf("sql Error :%+v", err)
+		return
+	}
+	if has {
+		log.WithContext(ctx).Infof("Mailing list %s %s from %s already pending", list.Address, action, sender)
+		return
+	}
+
+	token := make([]byte, 16)
+	if _, err := rand.Read(token); err != nil {
+		log.WithContext(ctx).Errorf("rand Error :%+v", err)
+		return
+	}
+	c := &models.MailingListConfirm{ListId: list.Id, Address: sender, Action: action, Token: hex.EncodeToString(token)}
+	if _, err := db.Instance.Insert(c); err != nil {
+		log.WithContext(ctx).Errorf("sql Error :%+v", err)
+		return
+	}
+	notify(ctx, list, []string{sender}, "Please confirm your "+action+" request",
+		fmt.Sprintf("We received a request to %s %s to %s.\n\nTo confirm, send an email to %s within %d days.\n\nIf you did not make this request, you can ignore this message.\n",
+			action, sender, list.Address, commandAddress(list, CommandConfirm+"-"+c.Token), int(confirmTTL.Hours()/24)))
+}
+
+// confirm 完成确认地址对应的订阅或退订请求
+func confirm(ctx *context.Context, list *models.MailingList, token string) {
+	var c models.MailingListConfirm
+	has, err := db.Instance.Where("list_id=? and token=? and create_time >= ?", list.Id, token, time.Now().Add(-confirmTTL)).Get(&c)
+	if err != nil {
+		log.WithContext(ctx).Errorf("sql Error :%+v", err)
+		return
+	}
+	if !has {
+		log.WithContext(ctx).Infof("Mailing list %s unknown or expired confirmation", list.Address)
+		return
+	}
+	if _, err = db.Instance.ID(c.Id).Delete(&models.MailingListConfirm{}); err != nil {
+		log.WithContext(ctx).Errorf("sql Error :%+v", err)
+		return
+	}
+
+	switch c.Action {
+	case CommandSubscribe:
+		if list.AllowSubscribe != 1 {
+			return
+		}
+		if !IsMember(ctx, list.Id, c.Address) {
+			_, err = db.Instance.Insert(&models.MailingListMember{ListId: list.Id, Address: c.Address})
+			if err != nil {
+				log.WithContext(ctx).Errorf("sql Error :%+v", err)
+				return
+			}
+		}
+		notify(ctx, list, []string{c.Address}, "Subscribed to "+list.Address,
+			fmt.Sprintf("You are now subscribed to %s.\n\nTo unsubscribe, send an email to %s.\n", list.Address, commandAddress(list, CommandUnsubscribe)))
+	case CommandUnsubscribe:
+		num, err := db.Instance.Where("list_id=? and address=?", list.Id, c.Address).Delete(&models.MailingListMember{})
+		if err != nil {
+			log.WithContext(ctx).Errorf("sql Error :%+v", err)
+			return
+		}
+		if num > 0 {
+			notify(ctx, list, []string{c.Address}, "Unsubscribed from "+list.Address,
+				fmt.Sprintf("You have been removed from %s.\n", list.Address))
+		}
+	}
+}
+
+// hold 保存待审核的邮件并通知审核员
+func hold(ctx *context.Context, list *models.MailingList, sender string, raw []byte) {
+	email := parsemail.NewEmailFromReader(nil, strings.NewReader(string(raw)), len(raw))
+	pending := &models.MailingListPending{
+		ListId:  list.Id,
+		Sender:  sender,
+		Subject: email.Subject,
+		Raw:     string(raw),
+	}
+	if _, err := db.Instance.Insert(pending); err != nil {
+		log.WithContext(ctx).Errorf("sql Error :%+v", err)
+		return
+	}
+	log.WithContext(ctx).Infof("Mailing list %s hold post %d from %s", list.Address, pending.Id, sender)
+
+	var moderators []string
+	err := db.Instance.Table(&models.MailingListMember{}).Where("list_id=? and moderator=1", list.Id).Cols("address").Find(&moderators)
+	if err != nil {
+		log.WithContext(ctx).Errorf("sql Error :%+v", err)
+	}
+	notify(ctx, list, moderators, "Message awaiting moderation: "+email.Subject,
+		fmt.Sprintf("A message from %s to %s is awaiting moderation.\n\nSubject: %s\n\nPlease approve or reject it in the PMail web console.\n", sender, list.Address, email.Subject))
+}
+
+// Moderate 审核待发布的邮件，通过时分发给全部成员
+func Moderate(ctx *context.Context, pendingID int, approve bool) error {
+	var pending models.MailingListPending
+	has, err := db.Instance.ID(pendingID).Get(&pending)
+	if err != nil {
+		return errors.Wrap(err)
+	}
+	if !has {
+		return errors.New("pending message not found")
+	}
+	if approve {
+		list, err := GetByID(ctx, pending.ListId)
+		if err != nil {
+			return err
+		}
+		if list == nil {
+			return errors.New("mailing list not found")
+		}
+		if err := Distribute(ctx, list, []byte(pending.Raw)); err != nil {
+			return err
+		}
+	}
+	_, err = db.Instance.ID(pending.Id).Delete(&models.MailingListPending{})
+	if err != nil {
+		return errors.Wrap(err)
+	}
+	return nil
+}
+
+func notify(ctx *context.Context, list *models.MailingList, to []string, subject, text string) {
+	if len(to) == 0 {
+		return
+	}
+	name := list.Name
+	if name == "" {
+		name = list.Address
+	}
+	email := &parsemail.Email{
+		// 使用退信地址发送通知，避免对方的自动回复再次进入列表
+		From:    &parsemail.User{Name: name, EmailAddress: BounceAddress(list)},
+		Subject: fmt.Sprintf("[%s] %s", name, subject),
+		Text:    []byte(text),
+		MsgID:   parsemail.GenerateMsgID(config.Instance.Domain),
+	}
+	for _, address := range to {
+		email.To = append(email.To, &parsemail.User{EmailAddress: address})
+	}
+	if err, _ := sendNotice(ctx, email); err != nil {
+		log.WithContext(ctx).Errorf("Mailing list %s notify error: %v", list.Address, err)
+	}
+}
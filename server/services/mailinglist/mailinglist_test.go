@@ -0,0 +1,234 @@
+package mailinglist
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Jinnrry/pmail/db"
+	"github.com/Jinnrry/pmail/db/dbtest"
+	"github.com/Jinnrry/pmail/dto/parsemail"
+	"github.com/Jinnrry/pmail/models"
+	"github.com/Jinnrry/pmail/services/alias"
+	"github.com/Jinnrry/pmail/utils/context"
+)
+
+func initTestDB(t *testing.T, notices *[]*parsemail.Email) *models.MailingList {
+	oldSend := sendNotice
+	sendNotice = func(ctx *context.Context, e *parsemail.Email) (error, map[string]error) {
+		if notices != nil {
+			*notices = append(*notices, e)
+		}
+		return nil, nil
+	}
+	t.Cleanup(func() { sendNotice = oldSend })
+	dbtest.Init(t)
+	list := &models.MailingList{Address: "team@example.com", Name: "Team", ReplyToList: 1}
+	if _, err := db.Instance.Insert(list); err != nil {
+		t.Fatal(err)
+	}
+	members := []*models.MailingListMember{
+		{ListId: list.Id, Address: "alice@example.com", Moderator: 1},
+		{ListId: list.Id, Address: "partner@external.net"},
+	}
+	if _, err := db.Instance.Insert(members); err != nil {
+		t.Fatal(err)
+	}
+	return list
+}
+
+func TestLookup(t *testing.T) {
+	list := initTestDB(t, nil)
+	ctx := &context.Context{}
+
+	tests := []struct {
+		address string
+		found   bool
+		command string
+	}{
+		{"Team@Example.com", true, ""},
+		{"<team+unsubscribe@example.com>", true, CommandUnsubscribe},
+		{"team+bounces@example.com", true, CommandBounces},
+		{"team@example.org", false, ""},
+		{"other@example.com", false, ""},
+	}
+	for _, tt := range tests {
+		l, command, err := Lookup(ctx, tt.address)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if (l != nil) != tt.found || command != tt.command {
+			t.Errorf("Lookup(%s) = %+v %s", tt.address, l, command)
+		}
+	}
+
+	target, err := alias.ResolveAddress(ctx, "team+subscribe@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if target == nil || len(target.Lists) != 1 || target.Lists[0] != list.Id || target.Tag != CommandSubscribe {
+		t.Errorf("ResolveAddress() = %+v", target)
+	}
+}
+
+func TestCheckPost(t *testing.T) {
+	list := initTestDB(t, nil)
+	ctx := &context.Context{}
+
+	if err := CheckPost(ctx, list, "", "Partner@external.net"); err != nil {
+		t.Errorf("member post rejected: %v", err)
+	}
+	if err := CheckPost(ctx, list, "", "stranger@external.net"); err != ErrPostNotAllowed {
+		t.Errorf("non member post accepted")
+	}
+	if err := CheckPost(ctx, list, CommandSubscribe, "stranger@external.net"); err != nil {
+		t.Errorf("subscribe rejected: %v", err)
+	}
+	list.PostPolicy = models.ListPolicyModerated
+	if err := CheckPost(ctx, list, "", "stranger@external.net"); err != nil {
+		t.Errorf("moderated post rejected: %v", err)
+	}
+
+	if !IsModerator(ctx, list.Id, "alice") || IsModerator(ctx, list.Id, "bob") {
+		t.Errorf("IsModerator error")
+	}
+}
+
+func TestProcessHold(t *testing.T) {
+	var notices []*parsemail.Email
+	list := initTestDB(t, &notices)
+	ctx := &context.Context{}
+	db.Instance.ID(list.Id).Cols("post_policy").Update(&models.MailingList{PostPolicy: models.ListPolicyModerated})
+
+	raw := []byte("From: stranger@external.net\r\nTo: team@example.com\r\nSubject: hello\r\n\r\nhi\r\n")
+	Process(ctx, list.Id, "", "stranger@external.net", raw)
+
+	var pending []*models.MailingListPending
+	if err := db.Instance.Find(&pending); err != nil {
+		t.Fatal(err)
+	}
+	if len(pending) != 1 || pending[0].Subject != "hello" || pending[0].Raw != string(raw) {
+		t.Fatalf("pending = %+v", pending)
+	}
+	if len(notices) != 1 || notices[0].To[0].EmailAddress != "alice@example.com" || notices[0].From.EmailAddress != "team+bounces@example.com" {
+		t.Errorf("moderator notice = %+v", notices)
+	}
+
+	if err := Moderate(ctx, pending[0].Id, false); err != nil {
+		t.Fatal(err)
+	}
+	if n, _ := db.Instance.Count(&models.MailingListPending{}); n != 0 {
+		t.Errorf("rejected message still pending")
+	}
+}
+
+// confirmCommand 从确认邮件中取出确认地址对应的命令
+func confirmCommand(t *testing.T, notice *parsemail.Email) string {
+	_, after, ok := strings.Cut(string(notice.Text), "send an email to ")
+	if !ok {
+		t.Fatalf("no confirm address in %q", notice.Text)
+	}
+	address, _, _ := strings.Cut(after, " ")
+	_, command, err := Lookup(&context.Context{}, address)
+	if err != nil || !strings.HasPrefix(command, CommandConfirm+"-") {
+		t.Fatalf("confirm address %s command %q %v", address, command, err)
+	}
+	return command
+}
+
+func TestSubscribe(t *testing.T) {
+	var notices []*parsemail.Email
+	list := initTestDB(t, &notices)
+	ctx := &context.Context{}
+
+	// 未开启自助订阅
+	Process(ctx, list.Id, CommandSubscribe, "new@external.net", nil)
+	if IsMember(ctx, list.Id, "new@external.net") || len(notices) != 0 {
+		t.Errorf("subscribe should be ignored")
+	}
+
+	db.Instance.ID(list.Id).Cols("allow_subscribe").Update(&models.MailingList{AllowSubscribe: 1})
+	Process(ctx, list.Id, CommandSubscribe, "New@external.net", nil)
+	Process(ctx, list.Id, CommandSubscribe, "new@external.net", nil)
+	if IsMember(ctx, list.Id, "new@external.net") {
+		t.Fatal("subscribed without confirmation")
+	}
+	if len(notices) != 1 || notices[0].To[0].EmailAddress != "new@external.net" {
+		t.Fatalf("confirm notices = %+v", notices)
+	}
+	command := confirmCommand(t, notices[0])
+
+	Process(ctx, list.Id, CommandConfirm+"-0123", "new@external.net", nil)
+	if IsMember(ctx, list.Id, "new@external.net") {
+		t.Fatal("subscribed with wrong token")
+	}
+	Process(ctx, list.Id, command, "", nil)
+	if !IsMember(ctx, list.Id, "new@external.net") || len(notices) != 2 {
+		t.Errorf("confirm failed, notices = %d", len(notices))
+	}
+	if n, _ := db.Instance.Count(&models.MailingListConfirm{}); n != 0 {
+		t.Errorf("confirmation not removed")
+	}
+
+	// 过期的请求不能再确认
+	Process(ctx, list.Id, CommandSubscribe, "late@external.net", nil)
+	command = confirmCommand(t, notices[2])
+	db.Instance.Exec("update mailing_list_confirm set create_time=?", time.Now().Add(-confirmTTL-time.Hour))
+	Process(ctx, list.Id, command, "late@external.net", nil)
+	if IsMember(ctx, list.Id, "late@external.net") {
+		t.Errorf("expired confirmation accepted")
+	}
+}
+
+func TestUnsubscribe(t *testing.T) {
+	var notices []*parsemail.Email
+	list := initTestDB(t, &notices)
+	ctx := &context.Context{}
+
+	Process(ctx, list.Id, CommandUnsubscribe, "stranger@external.net", nil)
+	if len(notices) != 0 {
+		t.Errorf("confirmation sent to non member")
+	}
+
+	db.Instance.Insert(&models.MailingListMember{ListId: list.Id, Address: "leave@external.net"})
+	Process(ctx, list.Id, CommandUnsubscribe, "<Leave@external.net>", nil)
+	if !IsMember(ctx, list.Id, "leave@external.net") {
+		t.Fatal("unsubscribed without confirmation")
+	}
+	if len(notices) != 1 || notices[0].To[0].EmailAddress != "leave@external.net" {
+		t.Fatalf("confirm notices = %+v", notices)
+	}
+	Process(ctx, list.Id, confirmCommand(t, notices[0]), "leave@external.net", nil)
+	if IsMember(ctx, list.Id, "leave@external.net") {
+		t.Errorf("unsubscribe failed")
+	}
+	if len(notices) != 2 || notices[1].To[0].EmailAddress != "leave@external.net" {
+		t.Errorf("unsubscribe notice = %+v", notices)
+	}
+}
+
+func TestAddListHeaders(t *testing.T) {
+	list := &models.MailingList{Address: "team@example.com", Name: "Team", ReplyToList: 1, AllowSubscribe: 1}
+	headers, body := parsemail.SplitRawMessage([]byte("From: a@b.com\r\nList-Id: <old.example.net>\r\nSubject: hi\r\n\r\nbody\r\n"))
+	ret := string(AddListHeaders(list, headers, body))
+
+	for _, want := range []string{
+		"List-Id: Team <team.example.com>\r\n",
+		"List-Post: <mailto:team@example.com>\r\n",
+		"List-Unsubscribe: <mailto:team+unsubscribe@example.com>\r\n",
+		"List-Subscribe: <mailto:team+subscribe@example.com>\r\n",
+		"Precedence: list\r\n",
+		"X-Loop: team@example.com\r\n",
+		"Reply-To: <team@example.com>\r\n",
+	} {
+		if !strings.Contains(ret, want) {
+			t.Errorf("missing header %q in %q", want, ret)
+		}
+	}
+	if strings.Contains(ret, "old.example.net") {
+		t.Errorf("upstream List-Id not removed")
+	}
+	if !strings.HasSuffix(ret, "\r\n\r\nbody\r\n") {
+		t.Errorf("body changed: %q", ret)
+	}
+}
@@ -0,0 +1,361 @@
+// Package modseq 维护IMAP CONDSTORE/QRESYNC（RFC 7162）需要的文件夹状态。
+// 每个文件夹有一个只增不减的修改序号，邮件的标志变化、移入文件夹时分配新的序号并保存在 user_email.mod_seq，
+// 移出文件夹或者被删除的邮件在 mailbox_vanished 中留下记录。
+//
+// 修改 user_email 前调用 Begin，修改后调用 Change.Commit；新插入的邮件调用 Touch
+package modseq
+
+import (
+	"strings"
+
+	"github.com/Jinnrry/pmail/consts"
+	"github.com/Jinnrry/pmail/db"
+	"github.com/Jinnrry/pmail/models"
+	"github.com/Jinnrry/pmail/utils/context"
+	log "github.com/sirupsen/logrus"
+	"xorm.io/builder"
+	"xorm.io/xorm"
+)
+
+// chunkSize in 条件中最多的id数量
+const chunkSize = 500
+
+// Mailbox 邮件所在的IMAP文件夹，自定义文件夹为分组id，默认文件夹为 models.GroupNameToCode 中的编码。
+// 不在任何IMAP文件夹中（比如发送失败的邮件）时返回0
+func Mailbox(groupId int, status int8) int {
+	if groupId > 0 {
+		return groupId
+	}
+	switch status {
+	case consts.EmailStatusWait:
+		return models.INBOX
+	case consts.EmailStatusSent:
+		return models.Sent
+	case consts.EmailStatusDel:
+		return models.Deleted
+	case consts.EmailStatusDrafts:
+		return models.Drafts
+	case consts.EmailStatusJunk:
+		return models.Junk
+	}
+	return 0
+}
+
+// MailboxByName IMAP文件夹名称对应的文件夹，与 list.GetEmailListByGroup 相同，自定义文件夹按最后一级名称查找。
+// 文件夹不存在时返回0
+func MailboxByName(ctx *context.Context, name string) int {
+	if code, ok := models.GroupNameToCode[name]; ok {
+		return code
+	}
+	var group models.Group
+	paths := strings.Split(name, "/")
+	_, err := db.Instance.Table("group").Where("user_id=? and name=?", ctx.UserID, paths[len(paths)-1]).Get(&group)
+	if err != nil {
+		log.WithContext(ctx).Errorf("SQL Error:%v", err)
+	}
+	return group.ID
+}
+
+// Cond 文件夹中邮件在 user_email 上的筛选条件
+func Cond(mailbox int) builder.Cond {
+	if name, ok := models.GroupCodeToName[mailbox]; ok {
+		var status int8
+		switch name {
+		case "Sent Messages":
+			status = consts.EmailStatusSent
+		case "Drafts":
+			status = consts.EmailStatusDrafts
+		case "Deleted Messages":
+			status = consts.EmailStatusDel
+		case "Junk":
+			status = consts.EmailStatusJunk
+		}
+		return builder.Or(builder.Eq{"group_id": 0, "status": status}, builder.Eq{"group_id": mailbox})
+	}
+	return builder.Eq{"group_id": mailbox}
+}
+
+// State 返回文件夹的状态，第一次访问时创建：UIDVALIDITY 沿用文件夹编号，UIDNEXT 为文件夹中最大的UID加一。
+// UIDNEXT 只增不减，邮件被删除后也不会变小
+func State(ctx *context.Context, session xorm.Interface, userId int, mailbox int) (*models.MailboxState, error) {
+	if session == nil {
+		session = db.Instance
+	}
+	var state models.MailboxState
+	has, err := session.Where("user_id=? and mailbox=?", userId, mailbox).Get(&state)
+	if err != nil {
+		return nil, err
+	}
+
+	var maxId int
+	_, err = session.Table(&models.UserEmail{}).Select("coalesce(max(id),0)").Where(builder.Eq{"user_id": userId}.And(Cond(mailbox))).Get(&maxId)
+	if err != nil {
+		return nil, err
+	}
+
+	if !has {
+		var maxModSeq int64
+		_, err = session.Table(&models.UserEmail{}).Select("coalesce(max(mod_seq),0)").Where(builder.Eq{"user_id": userId}.And(Cond(mailbox))).Get(&maxModSeq)
+		if err != nil {
+			return nil, err
+		}
+		state = models.MailboxState{UserId: userId, Mailbox: mailbox, UIDValidity: mailbox, UIDNext: maxId + 1, HighestModSeq: max(maxModSeq, 1)}
+		if _, err = session.Insert(&state); err != nil {
+			// 并发创建时唯一索引冲突，重新读取
+			if has, _ = session.Where("user_id=? and mailbox=?", userId, mailbox).Get(&state); !has {
+				return nil, err
+			}
+		}
+	}
+
+	if maxId >= state.UIDNext {
+		state.UIDNext = maxId + 1
+		_, err = session.Table(&models.MailboxState{}).Where("id=? and uid_next<?", state.Id, state.UIDNext).Update(map[string]any{"uid_next": state.UIDNext})
+		if err != nil {
+			log.WithContext(ctx).Errorf("SQL Error:%v", err)
+		}
+	}
+	return &state, nil
+}
+
+// next 给文件夹分配一个新的修改序号
+func next(ctx *context.Context, session xorm.Interface, userId int, mailbox int) (int64, error) {
+	state, err := State(ctx, session, userId, mailbox)
+	if err != nil {
+		return 0, err
+	}
+	_, err = session.Exec("update mailbox_state set highest_mod_seq=highest_mod_seq+1 where id=?", state.Id)
+	if err != nil {
+		return 0, err
+	}
+	var modSeq int64
+	_, err = session.Table(&models.MailboxState{}).Select("highest_mod_seq").Where("id=?", state.Id).Get(&modSeq)
+	return modSeq, err
+}
+
+type row struct {
+	ID      int  `xorm:"id"`
+	UserID  int  `xorm:"user_id"`
+	GroupId int  `xorm:"group_id"`
+	Status  int8 `xorm:"status"`
+}
+
+type key struct {
+	userId  int
+	mailbox int
+}
+
+// Change 一次对 user_email 的修改
+type Change struct {
+	ctx     *context.Context
+	session xorm.Interface
+	before  []row
+}
+
+// Begin 记录 cond 选中的邮件修改前所在的文件夹，cond 为 user_email 上的条件。
+// session 需要与修改使用同一个，为空时使用 db.Instance
+func Begin(ctx *context.Context, session xorm.Interface, cond builder.Cond) *Change {
+	if session == nil {
+		session = db.Instance
+	}
+	c := &Change{ctx: ctx, session: session}
+	err := session.Table(&models.UserEmail{}).Select("id,user_id,group_id,status").Where(cond).Find(&c.before)
+	if err != nil {
+		log.WithContext(ctx).Errorf("SQL Error:%v", err)
+	}
+	return c
+}
+
+// Commit 修改完成后调用。被删除或者移到其他文件夹的邮件在原文件夹中记录为 VANISHED，
+// 其余邮件在当前所在的文件夹中分配新的修改序号
+func (c *Change) Commit() {
+	if c == nil || len(c.before) == 0 {
+		return
+	}
+	ids := make([]int, 0, len(c.before))
+	for _, r := range c.before {
+		ids = append(ids, r.ID)
+	}
+	after := map[int]row{}
+	for _, chunk := range chunks(ids) {
+		var rows []row
+		err := c.session.Table(&models.UserEmail{}).Select("id,user_id,group_id,status").Where(builder.In("id", chunk)).Find(&rows)
+		if err != nil {
+			log.WithContext(c.ctx).Errorf("SQL Error:%v", err)
+			return
+		}
+		for _, r := range rows {
+			after[r.ID] = r
+		}
+	}
+
+	vanished := map[key][]int{}
+	touched := map[key][]int{}
+	for _, r := range c.before {
+		oldBox := Mailbox(r.GroupId, r.Status)
+		a, ok := after[r.ID]
+		newBox := 0
+		if ok {
+			newBox = Mailbox(a.GroupId, a.Status)
+		}
+		if oldBox != 0 && oldBox != newBox {
+			vanished[key{r.UserID, oldBox}] = append(vanished[key{r.UserID, oldBox}], r.ID)
+		}
+		if newBox != 0 {
+			touched[key{a.UserID, newBox}] = append(touched[key{a.UserID, newBox}], r.ID)
+		}
+	}
+
+	for k, uids := range vanished {
+		modSeq, err := next(c.ctx, c.session, k.userId, k.mailbox)
+		if err != nil {
+			log.WithContext(c.ctx).Errorf("ModSeq Error:%v", err)
+			continue
+		}
+		rows := make([]*models.MailboxVanished, 0, len(uids))
+		for _, uid := range uids {
+			rows = append(rows, &models.MailboxVanished{UserId: k.userId, Mailbox: k.mailbox, UID: uid, ModSeq: modSeq})
+		}
+		if _, err = c.session.Insert(rows); err != nil {
+			log.WithContext(c.ctx).Errorf("SQL Error:%v", err)
+		}
+	}
+	for k, uids := range touched {
+		modSeq, err := next(c.ctx, c.session, k.userId, k.mailbox)
+		if err != nil {
+			log.WithContext(c.ctx).Errorf("ModSeq Error:%v", err)
+			continue
+		}
+		for _, chunk := range chunks(uids) {
+			_, err = c.session.Table(&models.UserEmail{}).Where(builder.In("id", chunk)).Update(map[string]any{"mod_seq": modSeq})
+			if err != nil {
+				log.WithContext(c.ctx).Errorf("SQL Error:%v", err)
+			}
+		}
+	}
+}
+
+// Touch 给 cond 选中的邮件分配新的修改序号，用于新插入的邮件以及只修改标志的情况
+func Touch(ctx *context.Context, session xorm.Interface, cond builder.Cond) {
+	Begin(ctx, session, cond).Commit()
+}
+
+// Relocate 把 cond 选中的邮件移到 update 设置的文件夹。邮件移入文件夹后需要大于 UIDNEXT 的新UID，
+// 所以移到其他文件夹的邮件插入新的 user_email 记录并删除原记录，原UID在原文件夹中记录为 VANISHED；
+// 所在文件夹不变的邮件原地更新。session 为空时在新的事务中执行。返回移动的原UID与对应的新UID
+func Relocate(ctx *context.Context, session *xorm.Session, cond builder.Cond, update func(ue *models.UserEmail)) ([]int, []int, error) {
+	if session == nil {
+		session = db.Instance.NewSession()
+		defer session.Close()
+		if err := session.Begin(); err != nil {
+			return nil, nil, err
+		}
+		src, dest, err := Relocate(ctx, session, cond, update)
+		if err != nil {
+			session.Rollback()
+			return nil, nil, err
+		}
+		return src, dest, session.Commit()
+	}
+
+	var rows []*models.UserEmail
+	if err := session.Table(&models.UserEmail{}).Where(cond).Asc("id").Find(&rows); err != nil {
+		return nil, nil, err
+	}
+	var moved []*models.UserEmail
+	var src []int
+	for _, ue := range rows {
+		groupId, status := ue.GroupId, ue.Status
+		update(ue)
+		if Mailbox(groupId, status) != Mailbox(ue.GroupId, ue.Status) {
+			moved = append(moved, ue)
+			src = append(src, ue.ID)
+		} else if groupId != ue.GroupId || status != ue.Status {
+			change := Begin(ctx, session, builder.Eq{"id": ue.ID})
+			if _, err := session.ID(ue.ID).Cols("group_id", "status").Update(ue); err != nil {
+				return nil, nil, err
+			}
+			change.Commit()
+		}
+	}
+	if len(moved) == 0 {
+		return nil, nil, nil
+	}
+
+	change := Begin(ctx, session, builder.In("id", src))
+	for _, chunk := range chunks(src) {
+		if _, err := session.Where(builder.In("id", chunk)).Delete(&models.UserEmail{}); err != nil {
+			return nil, nil, err
+		}
+	}
+	change.Commit()
+
+	dest := make([]int, 0, len(moved))
+	for _, ue := range moved {
+		ue.ID, ue.ModSeq = 0, 0
+		// 保留原来的创建时间
+		if _, err := session.NoAutoTime().Insert(ue); err != nil {
+			return nil, nil, err
+		}
+		dest = append(dest, ue.ID)
+	}
+	Touch(ctx, session, builder.In("id", dest))
+	return src, dest, nil
+}
+
+// Vanished 返回 modSeq 之后移出文件夹的UID，已经移回文件夹的邮件不返回
+func Vanished(ctx *context.Context, userId int, mailbox int, modSeq int64) ([]int, error) {
+	var uids []int
+	err := db.Instance.Table(&models.MailboxVanished{}).Select("distinct uid").
+		Where("user_id=? and mailbox=? and mod_seq>?", userId, mailbox, modSeq).
+		And(builder.NotIn("uid", builder.Select("id").From("user_email").Where(builder.Eq{"user_id": userId}.And(Cond(mailbox))))).
+		OrderBy("uid").Find(&uids)
+	return uids, err
+}
+
+// VanishedSince 返回 modSeq 之后在文件夹中记录为 VANISHED 的UID与其中最大的修改序号，包括已经移回文件夹的邮件。
+// 用于选中文件夹的IMAP会话发现其他途径（网页端、规则等）移走的邮件
+func VanishedSince(ctx *context.Context, userId int, mailbox int, modSeq int64) ([]int, int64, error) {
+	var rows []*models.MailboxVanished
+	err := db.Instance.Where("user_id=? and mailbox=? and mod_seq>?", userId, mailbox, modSeq).Asc("uid").Find(&rows)
+	if err != nil {
+		return nil, modSeq, err
+	}
+	var uids []int
+	for _, r := range rows {
+		if len(uids) == 0 || uids[len(uids)-1] != r.UID {
+			uids = append(uids, r.UID)
+		}
+		modSeq = max(modSeq, r.ModSeq)
+	}
+	return uids, modSeq, nil
+}
+
+// Drop 删除文件夹的状态，自定义文件夹被删除时调用
+func Drop(ctx *context.Context, session xorm.Interface, userId int, mailboxes ...int) {
+	if session == nil {
+		session = db.Instance
+	}
+	if len(mailboxes) == 0 {
+		return
+	}
+	cond := builder.Eq{"user_id": userId}.And(builder.In("mailbox", mailboxes))
+	if _, err := session.Where(cond).Delete(&models.MailboxState{}); err != nil {
+		log.WithContext(ctx).Errorf("SQL Error:%v", err)
+	}
+	if _, err := session.Where(cond).Delete(&models.MailboxVanished{}); err != nil {
+		log.WithContext(ctx).Errorf("SQL Error:%v", err)
+	}
+}
+
+func chunks(ids []int) [][]int {
+	var ret [][]int
+	for len(ids) > chunkSize {
+		ret = append(ret, ids[:chunkSize])
+		ids = ids[chunkSize:]
+	}
+	if len(ids) > 0 {
+		ret = append(ret, ids)
+	}
+	return ret
+}
@@ -0,0 +1,142 @@
+package modseq
+
+import (
+	"testing"
+
+	"github.com/Jinnrry/pmail/db"
+	"github.com/Jinnrry/pmail/db/dbtest"
+	"github.com/Jinnrry/pmail/models"
+	"github.com/Jinnrry/pmail/utils/context"
+	"xorm.io/builder"
+)
+
+// initTestDB 创建用户与一个自定义文件夹，收件箱和文件夹中各有一封邮件，文件夹中的邮件UID更大
+func initTestDB(t *testing.T) (*context.Context, *models.Group, *models.UserEmail, *models.UserEmail) {
+	dbtest.Init(t)
+	alice := dbtest.User(t, "alice", "Alice")
+	group := &models.Group{Name: "shop", UserId: alice.ID}
+	if _, err := db.Instance.Insert(group); err != nil {
+		t.Fatal(err)
+	}
+	inbox := &models.UserEmail{UserID: alice.ID, EmailID: 1}
+	filed := &models.UserEmail{UserID: alice.ID, EmailID: 2, GroupId: group.ID}
+	if _, err := db.Instance.Insert(inbox, filed); err != nil {
+		t.Fatal(err)
+	}
+	ctx := &context.Context{UserID: alice.ID}
+	Touch(ctx, nil, builder.In("id", inbox.ID, filed.ID))
+	return ctx, group, inbox, filed
+}
+
+func state(t *testing.T, ctx *context.Context, mailbox int) *models.MailboxState {
+	s, err := State(ctx, nil, ctx.UserID, mailbox)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func moveTo(t *testing.T, ctx *context.Context, ue *models.UserEmail, groupId int) {
+	change := Begin(ctx, nil, builder.Eq{"id": ue.ID})
+	if _, err := db.Instance.ID(ue.ID).Cols("group_id").Update(&models.UserEmail{GroupId: groupId}); err != nil {
+		t.Fatal(err)
+	}
+	change.Commit()
+}
+
+func TestRelocate(t *testing.T) {
+	ctx, group, inbox, filed := initTestDB(t)
+	before := state(t, ctx, group.ID)
+	if before.UIDNext != filed.ID+1 {
+		t.Fatalf("UIDNEXT = %d, want %d", before.UIDNext, filed.ID+1)
+	}
+
+	src, dest, err := Relocate(ctx, nil, builder.Eq{"id": inbox.ID}, func(ue *models.UserEmail) {
+		ue.GroupId = group.ID
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(src) != 1 || src[0] != inbox.ID || len(dest) != 1 || dest[0] < before.UIDNext {
+		t.Fatalf("Relocate = %v %v, UIDNEXT was %d", src, dest, before.UIDNext)
+	}
+
+	var moved models.UserEmail
+	if has, _ := db.Instance.ID(dest[0]).Get(&moved); !has || moved.GroupId != group.ID || moved.EmailID != inbox.EmailID || moved.ModSeq <= before.HighestModSeq {
+		t.Errorf("moved email = %+v", moved)
+	}
+	if has, _ := db.Instance.ID(inbox.ID).Exist(&models.UserEmail{}); has {
+		t.Error("old uid still exists")
+	}
+	if after := state(t, ctx, group.ID); after.UIDNext != dest[0]+1 || after.HighestModSeq != moved.ModSeq {
+		t.Errorf("state = %+v", after)
+	}
+	if vanished, err := Vanished(ctx, ctx.UserID, models.INBOX, 0); err != nil || len(vanished) != 1 || vanished[0] != inbox.ID {
+		t.Errorf("inbox vanished = %v %v", vanished, err)
+	}
+
+	// 文件夹不变时原地更新，不分配新的UID
+	src, dest, err = Relocate(ctx, nil, builder.Eq{"id": filed.ID}, func(ue *models.UserEmail) {
+		ue.GroupId = group.ID
+	})
+	if err != nil || len(src) != 0 || len(dest) != 0 {
+		t.Errorf("Relocate in place = %v %v %v", src, dest, err)
+	}
+}
+
+func TestVanishedMovedBack(t *testing.T) {
+	ctx, group, inbox, _ := initTestDB(t)
+	since := state(t, ctx, models.INBOX).HighestModSeq
+
+	moveTo(t, ctx, inbox, group.ID)
+	if vanished, err := Vanished(ctx, ctx.UserID, models.INBOX, since); err != nil || len(vanished) != 1 || vanished[0] != inbox.ID {
+		t.Fatalf("vanished = %v %v", vanished, err)
+	}
+
+	// 移回收件箱后UID重新出现，QRESYNC不能再报告为 VANISHED
+	moveTo(t, ctx, inbox, 0)
+	if vanished, err := Vanished(ctx, ctx.UserID, models.INBOX, since); err != nil || len(vanished) != 0 {
+		t.Errorf("vanished after move back = %v %v", vanished, err)
+	}
+	uids, modSeq, err := VanishedSince(ctx, ctx.UserID, models.INBOX, since)
+	if err != nil || len(uids) != 1 || uids[0] != inbox.ID || modSeq <= since {
+		t.Errorf("VanishedSince = %v %d %v", uids, modSeq, err)
+	}
+}
+
+func TestCommitFolderChange(t *testing.T) {
+	ctx, group, inbox, filed := initTestDB(t)
+	inboxBefore := state(t, ctx, models.INBOX)
+	groupBefore := state(t, ctx, group.ID)
+
+	// 一次修改中一封邮件移出文件夹，另一封只修改标志
+	change := Begin(ctx, nil, builder.In("id", inbox.ID, filed.ID))
+	db.Instance.ID(inbox.ID).Cols("group_id").Update(&models.UserEmail{GroupId: group.ID})
+	db.Instance.ID(filed.ID).Cols("is_read").Update(&models.UserEmail{IsRead: 1})
+	change.Commit()
+
+	inboxAfter := state(t, ctx, models.INBOX)
+	groupAfter := state(t, ctx, group.ID)
+	if inboxAfter.HighestModSeq != inboxBefore.HighestModSeq+1 || groupAfter.HighestModSeq != groupBefore.HighestModSeq+1 {
+		t.Errorf("highest modseq inbox %d -> %d, group %d -> %d",
+			inboxBefore.HighestModSeq, inboxAfter.HighestModSeq, groupBefore.HighestModSeq, groupAfter.HighestModSeq)
+	}
+
+	var rows []*models.MailboxVanished
+	if err := db.Instance.Find(&rows); err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 1 || rows[0].Mailbox != models.INBOX || rows[0].UID != inbox.ID || rows[0].ModSeq != inboxAfter.HighestModSeq {
+		t.Errorf("vanished rows = %+v", rows)
+	}
+
+	var ues []*models.UserEmail
+	if err := db.Instance.In("id", inbox.ID, filed.ID).Find(&ues); err != nil {
+		t.Fatal(err)
+	}
+	for _, ue := range ues {
+		if ue.ModSeq != groupAfter.HighestModSeq {
+			t.Errorf("uid %d mod_seq = %d, want %d", ue.ID, ue.ModSeq, groupAfter.HighestModSeq)
+		}
+	}
+}
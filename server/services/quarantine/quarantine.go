@@ -0,0 +1,378 @@
+package quarantine
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"html"
+	"strings"
+	"time"
+
+	"github.com/Jinnrry/pmail/config"
+	"github.com/Jinnrry/pmail/consts"
+	"github.com/Jinnrry/pmail/db"
+	"github.com/Jinnrry/pmail/dto/parsemail"
+	"github.com/Jinnrry/pmail/models"
+	"github.com/Jinnrry/pmail/services/bayes"
+	"github.com/Jinnrry/pmail/services/fulltext"
+	"github.com/Jinnrry/pmail/services/modseq"
+	"github.com/Jinnrry/pmail/services/thread"
+	"github.com/Jinnrry/pmail/utils/context"
+	"github.com/Jinnrry/pmail/utils/errors"
+	log "github.com/sirupsen/logrus"
+	"xorm.io/builder"
+)
+
+// DefaultDays 隔离邮件默认保留天数
+const DefaultDays = 30
+
+// 隔离原因
+const (
+	ReasonSpam   = "spam"
+	ReasonForged = "forged"
+)
+
+// 链接中的操作
+const (
+	ActionRelease = "release"
+	ActionAllow   = "allow"
+)
+
+// Enabled 是否启用了隔离区
+func Enabled() bool {
+	return config.Instance != nil && config.Instance.QuarantineEnabled
+}
+
+// Days 隔离邮件的保留天数
+func Days() int {
+	if config.Instance == nil || config.Instance.QuarantineDays <= 0 {
+		return DefaultDays
+	}
+	return config.Instance.QuarantineDays
+}
+
+// Reason 按收信时判定的邮件状态返回隔离原因，不需要隔离时返回空字符串
+func Reason(status int) string {
+	switch int8(status) {
+	case consts.EmailStatusDel:
+		return ReasonForged
+	case consts.EmailStatusJunk:
+		return ReasonSpam
+	}
+	return ""
+}
+
+// Allowed 发件人是否在用户的信任列表中
+func Allowed(ctx *context.Context, userId int, sender string) bool {
+	sender = strings.ToLower(strings.TrimSpace(sender))
+	if sender == "" {
+		return false
+	}
+	has, err := db.Instance.Where("user_id=? and address=?", userId, sender).Exist(&models.SenderAllow{})
+	if err != nil {
+		log.WithContext(ctx).Errorf("sqlERror :%v", err)
+	}
+	return has
+}
+
+// Add 把收到的邮件从用户的邮箱中移到隔离区
+func Add(ctx *context.Context, userId int, email *parsemail.Email, reason string) error {
+	token := make([]byte, 16)
+	if _, err := rand.Read(token); err != nil {
+		return errors.Wrap(err)
+	}
+	q := &models.Quarantine{
+		UserId:  userId,
+		EmailId: int(email.MessageId),
+		Reason:  reason,
+		Subject: email.Subject,
+		Token:   hex.EncodeToString(token),
+	}
+	if email.From != nil {
+		q.Sender = strings.ToLower(email.From.EmailAddress)
+	}
+
+	session := db.Instance.NewSession()
+	defer session.Close()
+	if err := session.Begin(); err != nil {
+		return errors.Wrap(err)
+	}
+	_, err := session.Where("email_id=? and user_id=?", email.MessageId, userId).Delete(&models.UserEmail{})
+	if err != nil {
+		session.Rollback()
+		return errors.Wrap(err)
+	}
+	if _, err = session.Insert(q); err != nil {
+		session.Rollback()
+		return errors.Wrap(err)
+	}
+	if err = session.Commit(); err != nil {
+		return errors.Wrap(err)
+	}
+	log.WithContext(ctx).Infof("Quarantine: user %d email %d reason %s", userId, email.MessageId, reason)
+	return nil
+}
+
+// Get 读取隔离记录，userId为0时不检查所属用户
+func Get(ctx *context.Context, userId, id int) *models.Quarantine {
+	q := &models.Quarantine{}
+	query := db.Instance.Where("id=?", id)
+	if userId > 0 {
+		query = query.And("user_id=?", userId)
+	}
+	has, err := query.Get(q)
+	if err != nil {
+		log.WithContext(ctx).Errorf("sqlERror :%v", err)
+	}
+	if !has {
+		return nil
+	}
+	return q
+}
+
+// List 用户隔离区中的邮件，按时间倒序
+func List(ctx *context.Context, userId int) ([]*models.Quarantine, error) {
+	ret := []*models.Quarantine{}
+	err := db.Instance.Where("user_id=?", userId).Desc("id").Find(&ret)
+	if err != nil {
+		return nil, errors.Wrap(err)
+	}
+	return ret, nil
+}
+
+// Release 把邮件放回用户的收件箱，垃圾邮件同时按正常邮件训练分类器
+func Release(ctx *context.Context, q *models.Quarantine) error {
+	has, err := db.Instance.Where("id=?", q.EmailId).Exist(&models.Email{})
+	if err != nil {
+		return errors.Wrap(err)
+	}
+
+	session := db.Instance.NewSession()
+	defer session.Close()
+	if err = session.Begin(); err != nil {
+		return errors.Wrap(err)
+	}
+	if _, err = session.ID(q.Id).Delete(&models.Quarantine{}); err != nil {
+		session.Rollback()
+		return errors.Wrap(err)
+	}
+	if has {
+		if _, err = session.Insert(&models.UserEmail{UserID: q.UserId, EmailID: q.EmailId}); err != nil {
+			session.Rollback()
+			return errors.Wrap(err)
+		}
+	}
+	if err = session.Commit(); err != nil {
+		return errors.Wrap(err)
+	}
+	if !has {
+		return errors.New("email not found")
+	}
+	modseq.Touch(ctx, nil, builder.Eq{"email_id": q.EmailId, "user_id": q.UserId})
+
+	if q.Reason == ReasonSpam {
+		bayes.Train(ctx, q.UserId, []int{q.EmailId}, false)
+	}
+	return nil
+}
+
+// Trusted 邮件的发件人是否在用户的信任列表中。信任列表按邮件头中的From匹配，From可以任意伪造，
+// 因此只有SPF或DKIM通过并且与From的域名对齐时才生效
+func Trusted(ctx *context.Context, userId int, email *parsemail.Email) bool {
+	if !email.AuthAligned || email.From == nil {
+		return false
+	}
+	return Allowed(ctx, userId, email.From.EmailAddress)
+}
+
+// ErrForged 伪造发件人的邮件只能放回，不能信任其发件人
+var ErrForged = errors.New("forged sender can not be allowed")
+
+// Allow 信任发件人，并放回该发件人所有被隔离的邮件，伪造发件人的邮件除外
+func Allow(ctx *context.Context, q *models.Quarantine) error {
+	if q.Reason == ReasonForged {
+		return ErrForged
+	}
+	if q.Sender != "" {
+		if !Allowed(ctx, q.UserId, q.Sender) {
+			_, err := db.Instance.Insert(&models.SenderAllow{UserId: q.UserId, Address: q.Sender})
+			if err != nil {
+				return errors.Wrap(err)
+			}
+		}
+		var others []*models.Quarantine
+		err := db.Instance.Where("user_id=? and sender=? and id<>? and reason<>?", q.UserId, q.Sender, q.Id, ReasonForged).Find(&others)
+		if err != nil {
+			return errors.Wrap(err)
+		}
+		for _, other := range others {
+			if err = Release(ctx, other); err != nil {
+				log.WithContext(ctx).Errorf("Quarantine Release Error:%v", err)
+			}
+		}
+	}
+	return Release(ctx, q)
+}
+
+// Sign 链接的签名，每条隔离记录使用单独的随机密钥，不同操作的签名不同
+func Sign(q *models.Quarantine, action string) string {
+	mac := hmac.New(sha256.New, []byte(q.Token))
+	fmt.Fprintf(mac, "%s:%d:%d", action, q.Id, q.UserId)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Verify 校验链接的签名
+func Verify(q *models.Quarantine, action, sign string) bool {
+	if q == nil || q.Token == "" {
+		return false
+	}
+	return hmac.Equal([]byte(Sign(q, action)), []byte(strings.ToLower(sign)))
+}
+
+// webURL Web后台的访问地址
+func webURL() string {
+	if config.Instance.HttpsEnabled == 2 {
+		if config.Instance.HttpPort > 0 && config.Instance.HttpPort != 80 {
+			return fmt.Sprintf("http://%s:%d", config.Instance.WebDomain, config.Instance.HttpPort)
+		}
+		return "http://" + config.Instance.WebDomain
+	}
+	if config.Instance.HttpsPort > 0 && config.Instance.HttpsPort != 443 {
+		return fmt.Sprintf("https://%s:%d", config.Instance.WebDomain, config.Instance.HttpsPort)
+	}
+	return "https://" + config.Instance.WebDomain
+}
+
+// Link 一键操作的链接
+func Link(q *models.Quarantine, action string) string {
+	return fmt.Sprintf("%s/api/quarantine/link/%s?id=%d&sign=%s", webURL(), action, q.Id, Sign(q, action))
+}
+
+// Digest 给隔离区有新邮件的用户发送摘要邮件，每封隔离邮件只通知一次
+func Digest(ctx *context.Context) {
+	var items []*models.Quarantine
+	err := db.Instance.Where("notified=0").Asc("user_id", "id").Find(&items)
+	if err != nil {
+		log.WithContext(ctx).Errorf("sqlERror :%v", err)
+		return
+	}
+	byUser := map[int][]*models.Quarantine{}
+	var userIds []int
+	for _, q := range items {
+		if _, ok := byUser[q.UserId]; !ok {
+			userIds = append(userIds, q.UserId)
+		}
+		byUser[q.UserId] = append(byUser[q.UserId], q)
+	}
+
+	for _, userId := range userIds {
+		var user models.User
+		has, err := db.Instance.Where("id=? and disabled=0", userId).Get(&user)
+		if err != nil {
+			log.WithContext(ctx).Errorf("sqlERror :%v", err)
+			continue
+		}
+		if has {
+			if err = sendDigest(ctx, &user, byUser[userId]); err != nil {
+				log.WithContext(ctx).Errorf("Quarantine Digest Error:%v", err)
+				continue
+			}
+		}
+		var ids []int
+		for _, q := range byUser[userId] {
+			ids = append(ids, q.Id)
+		}
+		_, err = db.Instance.In("id", ids).Cols("notified").Update(&models.Quarantine{Notified: 1})
+		if err != nil {
+			log.WithContext(ctx).Errorf("sqlERror :%v", err)
+		}
+	}
+}
+
+// sendDigest 摘要邮件直接投递到用户的收件箱，不经过SMTP，避免摘要本身被过滤或隔离
+func sendDigest(ctx *context.Context, user *models.User, items []*models.Quarantine) error {
+	days := Days()
+	var text, body strings.Builder
+	fmt.Fprintf(&text, "%d new message(s) were quarantined. They will be deleted after %d days unless you release them.\n\n", len(items), days)
+	fmt.Fprintf(&body, "<p>%d new message(s) were quarantined. They will be deleted after %d days unless you release them.</p><table>", len(items), days)
+	for _, q := range items {
+		fmt.Fprintf(&text, "From: %s\nSubject: %s\nReason: %s\nRelease: %s\n",
+			q.Sender, q.Subject, q.Reason, Link(q, ActionRelease))
+		fmt.Fprintf(&body, `<tr><td>%s</td><td>%s</td><td>%s</td><td><a href="%s">Release</a></td>`,
+			html.EscapeString(q.Sender), html.EscapeString(q.Subject), q.Reason, html.EscapeString(Link(q, ActionRelease)))
+		// 伪造发件人的邮件不提供信任链接，否则信任的是被冒充的地址
+		if q.Reason == ReasonForged {
+			text.WriteString("\n")
+			body.WriteString("<td></td></tr>")
+			continue
+		}
+		fmt.Fprintf(&text, "Allow sender: %s\n\n", Link(q, ActionAllow))
+		fmt.Fprintf(&body, `<td><a href="%s">Allow sender</a></td></tr>`, html.EscapeString(Link(q, ActionAllow)))
+	}
+	body.WriteString("</table>")
+
+	to, _ := json.Marshal([]*parsemail.User{{Name: user.Name, EmailAddress: user.Account + "@" + config.Instance.Domain}})
+	email := &models.Email{
+		Type:         consts.EmailTypeReceive,
+		Subject:      fmt.Sprintf("Quarantine digest: %d new message(s)", len(items)),
+		FromName:     "PMail Quarantine",
+		FromAddress:  "postmaster@" + config.Instance.Domain,
+		To:           string(to),
+		Text:         sql.NullString{String: text.String(), Valid: true},
+		Html:         sql.NullString{String: body.String(), Valid: true},
+		Size:         text.Len() + body.Len(),
+		SPFCheck:     1,
+		DKIMCheck:    1,
+		SendDate:     time.Now(),
+		CronSendTime: time.Now(),
+		MsgID:        parsemail.GenerateMsgID(config.Instance.Domain),
+	}
+	if _, err := db.Instance.Insert(email); err != nil {
+		return errors.Wrap(err)
+	}
+	thread.Assign(ctx, email)
+	ue := &models.UserEmail{UserID: user.ID, EmailID: email.Id}
+	if _, err := db.Instance.Insert(ue); err != nil {
+		return errors.Wrap(err)
+	}
+	modseq.Touch(ctx, nil, builder.Eq{"id": ue.ID})
+	return nil
+}
+
+// Expire 删除超过保留天数的隔离邮件，邮件没有其他用户引用时一并删除
+func Expire(ctx *context.Context) {
+	var items []*models.Quarantine
+	err := db.Instance.Where("create_time < ?", time.Now().AddDate(0, 0, -Days())).Find(&items)
+	if err != nil {
+		log.WithContext(ctx).Errorf("sqlERror :%v", err)
+		return
+	}
+	for _, q := range items {
+		if _, err = db.Instance.ID(q.Id).Delete(&models.Quarantine{}); err != nil {
+			log.WithContext(ctx).Errorf("sqlERror :%v", err)
+			continue
+		}
+		used, err := db.Instance.Where("email_id=?", q.EmailId).Exist(&models.UserEmail{})
+		if err == nil && !used {
+			used, err = db.Instance.Where("email_id=?", q.EmailId).Exist(&models.Quarantine{})
+		}
+		if err != nil {
+			log.WithContext(ctx).Errorf("sqlERror :%v", err)
+			continue
+		}
+		if !used {
+			_, err = db.Instance.Where(builder.Eq{"id": q.EmailId}).Delete(&models.Email{})
+			if err != nil {
+				log.WithContext(ctx).Errorf("sqlERror :%v", err)
+			}
+			fulltext.Delete(ctx, nil, q.EmailId)
+		}
+	}
+	if len(items) > 0 {
+		log.WithContext(ctx).Infof("Quarantine expired: %d", len(items))
+	}
+}
@@ -0,0 +1,148 @@
+package quarantine
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Jinnrry/pmail/config"
+	"github.com/Jinnrry/pmail/db"
+	"github.com/Jinnrry/pmail/db/dbtest"
+	"github.com/Jinnrry/pmail/dto/parsemail"
+	"github.com/Jinnrry/pmail/models"
+	"github.com/Jinnrry/pmail/utils/context"
+)
+
+func initTestDB(t *testing.T) *models.User {
+	dbtest.Init(t, func(c *config.Config) {
+		c.WebDomain = "mail.example.com"
+		c.QuarantineEnabled = true
+	})
+	return dbtest.User(t, "alice", "Alice")
+}
+
+func receive(t *testing.T, user *models.User, from, subject string) *parsemail.Email {
+	e := &models.Email{Subject: subject, FromAddress: from}
+	if _, err := db.Instance.Insert(e); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Instance.Insert(&models.UserEmail{UserID: user.ID, EmailID: e.Id, Status: 5}); err != nil {
+		t.Fatal(err)
+	}
+	return &parsemail.Email{MessageId: int64(e.Id), Subject: subject, From: &parsemail.User{EmailAddress: from}}
+}
+
+func inbox(t *testing.T, user *models.User) int64 {
+	count, err := db.Instance.Where("user_id=? and status=0", user.ID).Count(&models.UserEmail{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return count
+}
+
+func TestQuarantine(t *testing.T) {
+	user := initTestDB(t)
+	ctx := &context.Context{}
+
+	first := receive(t, user, "Shop@Spam.net", "offer 1")
+	second := receive(t, user, "shop@spam.net", "offer 2")
+	forged := receive(t, user, "admin@example.com", "reset password")
+	for _, e := range []*parsemail.Email{first, second} {
+		if err := Add(ctx, user.ID, e, ReasonSpam); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := Add(ctx, user.ID, forged, Reason(3)); err != nil {
+		t.Fatal(err)
+	}
+	if count, _ := db.Instance.Where("user_id=?", user.ID).Count(&models.UserEmail{}); count != 0 {
+		t.Errorf("quarantined emails still in mailbox: %d", count)
+	}
+
+	items, _ := List(ctx, user.ID)
+	if len(items) != 3 || items[0].Reason != ReasonForged || items[2].Sender != "shop@spam.net" {
+		t.Fatalf("items = %+v", items)
+	}
+
+	Digest(ctx)
+	var digest models.Email
+	if has, _ := db.Instance.Where("from_address='postmaster@example.com'").Get(&digest); !has {
+		t.Fatal("digest not delivered")
+	}
+	// 摘要需要修改序号，否则CONDSTORE客户端不会同步
+	var digestUE models.UserEmail
+	if has, _ := db.Instance.Where("email_id=?", digest.Id).Get(&digestUE); !has || digestUE.ModSeq == 0 {
+		t.Errorf("digest user_email = %+v", digestUE)
+	}
+	link := Link(items[0], ActionRelease)
+	if !strings.HasPrefix(link, "https://mail.example.com/api/quarantine/link/release?id=") || !strings.Contains(digest.Text.String, link) {
+		t.Errorf("digest = %s", digest.Text.String)
+	}
+	// 伪造发件人的邮件没有信任链接
+	if strings.Contains(digest.Text.String, Link(items[0], ActionAllow)) || !strings.Contains(digest.Text.String, Link(items[1], ActionAllow)) {
+		t.Errorf("digest allow links = %s", digest.Text.String)
+	}
+	if err := Allow(ctx, items[0]); err != ErrForged {
+		t.Errorf("Allow(forged) = %v", err)
+	}
+	Digest(ctx)
+	if count, _ := db.Instance.Where("from_address='postmaster@example.com'").Count(&models.Email{}); count != 1 {
+		t.Errorf("digest count = %d", count)
+	}
+
+	q := items[0]
+	if !Verify(q, ActionRelease, Sign(q, ActionRelease)) || Verify(q, ActionAllow, Sign(q, ActionRelease)) || Verify(q, ActionRelease, "00") {
+		t.Error("signature check failed")
+	}
+	if err := Release(ctx, q); err != nil {
+		t.Fatal(err)
+	}
+	if Get(ctx, user.ID, q.Id) != nil || inbox(t, user) != 2 {
+		t.Errorf("release failed, inbox = %d", inbox(t, user))
+	}
+
+	if err := Allow(ctx, items[1]); err != nil {
+		t.Fatal(err)
+	}
+	if !Allowed(ctx, user.ID, "SHOP@spam.net") || inbox(t, user) != 4 {
+		t.Errorf("allow failed, inbox = %d", inbox(t, user))
+	}
+	// 信任列表只对通过认证并与From对齐的邮件生效
+	email := &parsemail.Email{From: &parsemail.User{EmailAddress: "shop@spam.net"}}
+	if Trusted(ctx, user.ID, email) {
+		t.Error("unauthenticated sender trusted")
+	}
+	email.AuthAligned = true
+	if !Trusted(ctx, user.ID, email) {
+		t.Error("aligned sender not trusted")
+	}
+	if items, _ = List(ctx, user.ID); len(items) != 0 {
+		t.Errorf("items = %+v", items)
+	}
+}
+
+func TestExpire(t *testing.T) {
+	user := initTestDB(t)
+	ctx := &context.Context{}
+	e := receive(t, user, "shop@spam.net", "offer")
+	if err := Add(ctx, user.ID, e, ReasonSpam); err != nil {
+		t.Fatal(err)
+	}
+
+	Expire(ctx)
+	if count, _ := db.Instance.Count(&models.Quarantine{}); count != 1 {
+		t.Errorf("fresh quarantine expired")
+	}
+
+	_, err := db.Instance.Exec("update quarantine set create_time=?", time.Now().AddDate(0, 0, -Days()-1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	Expire(ctx)
+	if count, _ := db.Instance.Count(&models.Quarantine{}); count != 0 {
+		t.Errorf("quarantine count = %d", count)
+	}
+	if has, _ := db.Instance.Where("id=?", e.MessageId).Exist(&models.Email{}); has {
+		t.Error("expired email not deleted")
+	}
+}
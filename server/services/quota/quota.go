@@ -0,0 +1,368 @@
+package quota
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"html"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Jinnrry/pmail/config"
+	"github.com/Jinnrry/pmail/consts"
+	"github.com/Jinnrry/pmail/db"
+	"github.com/Jinnrry/pmail/dto/parsemail"
+	"github.com/Jinnrry/pmail/models"
+	"github.com/Jinnrry/pmail/services/fulltext"
+	"github.com/Jinnrry/pmail/services/modseq"
+	"github.com/Jinnrry/pmail/services/thread"
+	"github.com/Jinnrry/pmail/utils/context"
+	"github.com/Jinnrry/pmail/utils/errors"
+	log "github.com/sirupsen/logrus"
+	"xorm.io/builder"
+)
+
+// DefaultWarnPercents 默认的配额警告百分比
+var DefaultWarnPercents = []int{80, 95}
+
+// Unlimited 用户配额设置为该值时不限制，即使配置了默认配额
+const Unlimited int64 = -1
+
+// UserRoot 用户自己的配额根，域名配额根的名称为域名
+const UserRoot = ""
+
+// Quota 一个配额根的用量，单位字节
+type Quota struct {
+	Root  string `json:"root"`
+	Usage int64  `json:"usage"`
+	Limit int64  `json:"limit"` // 0表示不限制
+}
+
+// Exceeded 已经用满，或者再存入size字节后超出配额
+func (q *Quota) Exceeded(size int64) bool {
+	return q.Limit > 0 && (q.Usage >= q.Limit || q.Usage+size > q.Limit)
+}
+
+// Percent 用量占配额的百分比，不限制时为0
+func (q *Quota) Percent() int {
+	if q.Limit <= 0 {
+		return 0
+	}
+	return int(q.Usage * 100 / q.Limit)
+}
+
+// UserLimit 用户的配额，用户没有单独设置时使用默认配额，0表示不限制
+func UserLimit(user *models.User) int64 {
+	if user.Quota == Unlimited {
+		return 0
+	}
+	if user.Quota > 0 {
+		return user.Quota
+	}
+	if config.Instance == nil || config.Instance.QuotaDefault < 0 {
+		return 0
+	}
+	return config.Instance.QuotaDefault
+}
+
+// Usage 用户所有文件夹中邮件的大小之和，同一封邮件在多个文件夹中时重复计算
+func Usage(ctx *context.Context, userId int) int64 {
+	var usage int64
+	_, err := db.Instance.SQL("select coalesce(sum(e.size),0) from user_email ue join email e on e.id=ue.email_id where ue.user_id=?", userId).Get(&usage)
+	if err != nil {
+		log.WithContext(ctx).Errorf("SQL Error:%v", err)
+	}
+	return usage
+}
+
+// domainUsageTTL 域名用量的缓存时间。统计域名用量需要扫描全部邮件，
+// 域名配额是所有用户共用的粗粒度限制，短时间内的误差可以接受
+const domainUsageTTL = time.Minute
+
+var domainUsageCache struct {
+	sync.Mutex
+	usage   int64
+	updated time.Time
+}
+
+// domainUsage 域名下所有用户的用量，PMail的账号在所有域名下共用，因此统计全部用户。结果缓存domainUsageTTL
+func domainUsage(ctx *context.Context) int64 {
+	domainUsageCache.Lock()
+	defer domainUsageCache.Unlock()
+	if !domainUsageCache.updated.IsZero() && time.Since(domainUsageCache.updated) < domainUsageTTL {
+		return domainUsageCache.usage
+	}
+	return refreshDomainUsage(ctx)
+}
+
+// refreshDomainUsage 重新统计域名用量并更新缓存，调用方需持有domainUsageCache的锁
+func refreshDomainUsage(ctx *context.Context) int64 {
+	var usage int64
+	_, err := db.Instance.SQL("select coalesce(sum(e.size),0) from user_email ue join email e on e.id=ue.email_id").Get(&usage)
+	if err != nil {
+		log.WithContext(ctx).Errorf("SQL Error:%v", err)
+		return domainUsageCache.usage
+	}
+	domainUsageCache.usage = usage
+	domainUsageCache.updated = time.Now()
+	return usage
+}
+
+// User 用户配额根的用量
+func User(ctx *context.Context, user *models.User) *Quota {
+	return &Quota{Root: UserRoot, Usage: Usage(ctx, user.ID), Limit: UserLimit(user)}
+}
+
+// Domains 设置了配额的域名
+func Domains(ctx *context.Context) []*models.DomainQuota {
+	var list []*models.DomainQuota
+	err := db.Instance.Where("quota>0").OrderBy("domain").Find(&list)
+	if err != nil {
+		log.WithContext(ctx).Errorf("SQL Error:%v", err)
+	}
+	return list
+}
+
+// Domain 域名配额根的用量，没有设置配额时返回nil
+func Domain(ctx *context.Context, domain string) *Quota {
+	var dq models.DomainQuota
+	has, err := db.Instance.Where("domain=? and quota>0", strings.ToLower(domain)).Get(&dq)
+	if err != nil {
+		log.WithContext(ctx).Errorf("SQL Error:%v", err)
+	}
+	if !has {
+		return nil
+	}
+	return &Quota{Root: dq.Domain, Usage: domainUsage(ctx), Limit: dq.Quota}
+}
+
+// Roots 用户的所有配额根，第一个为用户自己的配额
+func Roots(ctx *context.Context, user *models.User) []*Quota {
+	ret := []*Quota{User(ctx, user)}
+	domains := Domains(ctx)
+	if len(domains) == 0 {
+		return ret
+	}
+	usage := domainUsage(ctx)
+	for _, dq := range domains {
+		ret = append(ret, &Quota{Root: dq.Domain, Usage: usage, Limit: dq.Quota})
+	}
+	return ret
+}
+
+// Over 存入size字节后超出的配额根，没有超出时返回nil
+func Over(ctx *context.Context, user *models.User, size int64) *Quota {
+	for _, q := range Roots(ctx, user) {
+		if q.Exceeded(size) {
+			return q
+		}
+	}
+	return nil
+}
+
+// OverUserID 同Over，按用户id查找用户
+func OverUserID(ctx *context.Context, userId int, size int64) *Quota {
+	user := getUser(ctx, "id=?", userId)
+	if user == nil {
+		return nil
+	}
+	return Over(ctx, user, size)
+}
+
+// OverAccount 同Over，按账号查找用户，账号不存在时返回nil
+func OverAccount(ctx *context.Context, account string, size int64) *Quota {
+	user := getUser(ctx, "LOWER(account)=?", strings.ToLower(account))
+	if user == nil {
+		return nil
+	}
+	return Over(ctx, user, size)
+}
+
+func getUser(ctx *context.Context, query string, args ...any) *models.User {
+	var user models.User
+	has, err := db.Instance.Where(query, args...).Get(&user)
+	if err != nil {
+		log.WithContext(ctx).Errorf("SQL Error:%v", err)
+	}
+	if !has {
+		return nil
+	}
+	return &user
+}
+
+// SetUser 设置用户配额，0使用默认配额，-1不限制
+func SetUser(ctx *context.Context, userId int, limit int64) error {
+	if limit < Unlimited {
+		return errors.New("invalid quota")
+	}
+	_, err := db.Instance.ID(userId).Cols("quota").Update(&models.User{Quota: limit})
+	if err != nil {
+		return errors.Wrap(err)
+	}
+	Warn(ctx, userId)
+	return nil
+}
+
+// SetDomain 设置域名配额，0表示删除配额
+func SetDomain(ctx *context.Context, domain string, limit int64) error {
+	domain = strings.ToLower(domain)
+	if limit < 0 {
+		return errors.New("invalid quota")
+	}
+	if limit == 0 {
+		_, err := db.Instance.Where("domain=?", domain).Delete(&models.DomainQuota{})
+		if err != nil {
+			return errors.Wrap(err)
+		}
+		return nil
+	}
+	var dq models.DomainQuota
+	has, err := db.Instance.Where("domain=?", domain).Get(&dq)
+	if err != nil {
+		return errors.Wrap(err)
+	}
+	dq.Domain = domain
+	dq.Quota = limit
+	if has {
+		_, err = db.Instance.ID(dq.Id).Cols("quota").Update(&dq)
+	} else {
+		_, err = db.Instance.Insert(&dq)
+	}
+	if err != nil {
+		return errors.Wrap(err)
+	}
+	// 修改配额时重新统计，立即按新的配额检查警告线
+	domainUsageCache.Lock()
+	usage := refreshDomainUsage(ctx)
+	domainUsageCache.Unlock()
+	warnDomain(ctx, &dq, usage)
+	return nil
+}
+
+// warnPercents 配置的警告百分比，从小到大
+func warnPercents() []int {
+	percents := DefaultWarnPercents
+	if config.Instance != nil && len(config.Instance.QuotaWarnPercents) > 0 {
+		percents = config.Instance.QuotaWarnPercents
+	}
+	ret := append([]int{}, percents...)
+	sort.Ints(ret)
+	return ret
+}
+
+// warnLevel 用量达到的最高警告百分比，没有达到任何警告线时为0
+func warnLevel(q *Quota) int {
+	level := 0
+	if q.Limit <= 0 {
+		return level
+	}
+	for _, p := range warnPercents() {
+		if p > 0 && q.Usage*100 >= q.Limit*int64(p) {
+			level = p
+		}
+	}
+	return level
+}
+
+// Warn 用量超过新的警告线时给用户发送警告邮件，域名配额的警告发给管理员。
+// 每条警告线只发送一次，用量降到警告线以下后重新计算
+func Warn(ctx *context.Context, userId int) {
+	user := getUser(ctx, "id=?", userId)
+	if user == nil {
+		return
+	}
+	q := User(ctx, user)
+	level := warnLevel(q)
+	if level != user.QuotaWarned {
+		if level > user.QuotaWarned {
+			if err := sendWarning(ctx, user, q, level); err != nil {
+				log.WithContext(ctx).Errorf("Quota Warning Error:%v", err)
+				return
+			}
+		}
+		_, err := db.Instance.ID(user.ID).Cols("quota_warned").Update(&models.User{QuotaWarned: level})
+		if err != nil {
+			log.WithContext(ctx).Errorf("SQL Error:%v", err)
+		}
+	}
+
+	domains := Domains(ctx)
+	if len(domains) == 0 {
+		return
+	}
+	usage := domainUsage(ctx)
+	for _, dq := range domains {
+		warnDomain(ctx, dq, usage)
+	}
+}
+
+func warnDomain(ctx *context.Context, dq *models.DomainQuota, usage int64) {
+	q := &Quota{Root: dq.Domain, Usage: usage, Limit: dq.Quota}
+	level := warnLevel(q)
+	if level == dq.QuotaWarned {
+		return
+	}
+	if level > dq.QuotaWarned {
+		var admins []*models.User
+		err := db.Instance.Where("is_admin=1 and disabled=0").Find(&admins)
+		if err != nil {
+			log.WithContext(ctx).Errorf("SQL Error:%v", err)
+			return
+		}
+		for _, admin := range admins {
+			if err = sendWarning(ctx, admin, q, level); err != nil {
+				log.WithContext(ctx).Errorf("Quota Warning Error:%v", err)
+			}
+		}
+	}
+	_, err := db.Instance.ID(dq.Id).Cols("quota_warned").Update(&models.DomainQuota{QuotaWarned: level})
+	if err != nil {
+		log.WithContext(ctx).Errorf("SQL Error:%v", err)
+	}
+}
+
+// FormatSize 按MB显示存储大小
+func FormatSize(size int64) string {
+	return fmt.Sprintf("%.1f MB", float64(size)/1024/1024)
+}
+
+// sendWarning 警告邮件直接投递到用户的收件箱，不经过SMTP，也不受配额限制
+func sendWarning(ctx *context.Context, user *models.User, q *Quota, level int) error {
+	owner := "Your mailbox"
+	if q.Root != UserRoot {
+		owner = "The domain " + q.Root
+	}
+	text := fmt.Sprintf("%s is using %s of its %s storage quota (%d%%). When the quota is full, new mail will be rejected. Please delete messages you no longer need.\n",
+		owner, FormatSize(q.Usage), FormatSize(q.Limit), q.Percent())
+	body := "<p>" + html.EscapeString(text) + "</p>"
+
+	to, _ := json.Marshal([]*parsemail.User{{Name: user.Name, EmailAddress: user.Account + "@" + config.Instance.Domain}})
+	email := &models.Email{
+		Type:         consts.EmailTypeReceive,
+		Subject:      fmt.Sprintf("Storage quota warning: %d%% used", level),
+		FromName:     "PMail Quota",
+		FromAddress:  "postmaster@" + config.Instance.Domain,
+		To:           string(to),
+		Text:         sql.NullString{String: text, Valid: true},
+		Html:         sql.NullString{String: body, Valid: true},
+		Size:         len(text) + len(body),
+		SPFCheck:     1,
+		DKIMCheck:    1,
+		SendDate:     time.Now(),
+		CronSendTime: time.Now(),
+		MsgID:        parsemail.GenerateMsgID(config.Instance.Domain),
+	}
+	if _, err := db.Instance.Insert(email); err != nil {
+		return errors.Wrap(err)
+	}
+	thread.Assign(ctx, email)
+	fulltext.Index(ctx, email)
+	ue := &models.UserEmail{UserID: user.ID, EmailID: email.Id}
+	if _, err := db.Instance.Insert(ue); err != nil {
+		return errors.Wrap(err)
+	}
+	modseq.Touch(ctx, nil, builder.Eq{"id": ue.ID})
+	return nil
+}
@@ -0,0 +1,171 @@
+package quota
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Jinnrry/pmail/config"
+	"github.com/Jinnrry/pmail/db"
+	"github.com/Jinnrry/pmail/db/dbtest"
+	"github.com/Jinnrry/pmail/models"
+	"github.com/Jinnrry/pmail/services/fulltext"
+	"github.com/Jinnrry/pmail/utils/context"
+)
+
+func initTestDB(t *testing.T) *models.User {
+	dbtest.Init(t, func(c *config.Config) {
+		c.QuotaDefault = 1000
+	})
+	return dbtest.User(t, "alice", "Alice")
+}
+
+func store(t *testing.T, user *models.User, size int) {
+	e := &models.Email{Subject: "test", Size: size}
+	if _, err := db.Instance.Insert(e); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Instance.Insert(&models.UserEmail{UserID: user.ID, EmailID: e.Id}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func warnings(t *testing.T, userId int) int64 {
+	count, err := db.Instance.Table("user_email").Join("INNER", "email", "email.id=user_email.email_id").
+		Where("user_email.user_id=? and email.from_name='PMail Quota'", userId).Count()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return count
+}
+
+func TestQuota(t *testing.T) {
+	user := initTestDB(t)
+	ctx := &context.Context{}
+
+	store(t, user, 500)
+	if q := User(ctx, user); q.Usage != 500 || q.Limit != 1000 {
+		t.Errorf("default quota = %+v", q)
+	}
+	if Over(ctx, user, 500) != nil || Over(ctx, user, 501) == nil {
+		t.Error("default quota not enforced")
+	}
+
+	// 用户单独设置的配额优先于默认配额
+	if err := SetUser(ctx, user.ID, Unlimited); err != nil {
+		t.Fatal(err)
+	}
+	if q := OverAccount(ctx, "Alice", 1<<30); q != nil {
+		t.Errorf("unlimited user is over quota: %+v", q)
+	}
+	if err := SetUser(ctx, user.ID, 600); err != nil {
+		t.Fatal(err)
+	}
+	if q := OverAccount(ctx, "alice", 101); q == nil || q.Root != UserRoot {
+		t.Errorf("user quota = %+v", q)
+	}
+
+	// 域名配额限制所有用户的总用量
+	bob := &models.User{Account: "bob", Name: "Bob", Quota: Unlimited}
+	db.Instance.Insert(bob)
+	store(t, bob, 300)
+	if err := SetDomain(ctx, "Example.com", 900); err != nil {
+		t.Fatal(err)
+	}
+	if q := Over(ctx, bob, 101); q == nil || q.Root != "example.com" || q.Usage < 800 {
+		t.Errorf("domain quota = %+v", q)
+	}
+	if roots := Roots(ctx, bob); len(roots) != 2 || roots[1].Root != "example.com" {
+		t.Errorf("roots = %+v", roots)
+	}
+	if err := SetDomain(ctx, "example.com", 0); err != nil {
+		t.Fatal(err)
+	}
+	if q := Over(ctx, bob, 1<<30); q != nil {
+		t.Errorf("deleted domain quota still enforced: %+v", q)
+	}
+}
+
+func TestWarn(t *testing.T) {
+	user := initTestDB(t)
+	ctx := &context.Context{}
+	config.Instance.QuotaDefault = 100000
+	admin := &models.User{Account: "admin", Name: "Admin", IsAdmin: 1, Quota: Unlimited}
+	db.Instance.Insert(admin)
+
+	store(t, user, 70000)
+	Warn(ctx, user.ID)
+	if warnings(t, user.ID) != 0 {
+		t.Error("warned below threshold")
+	}
+
+	store(t, user, 10000)
+	Warn(ctx, user.ID)
+	Warn(ctx, user.ID)
+	if warnings(t, user.ID) != 1 {
+		t.Errorf("warnings at 80%% = %d", warnings(t, user.ID))
+	}
+	var warning models.Email
+	db.Instance.Where("from_name='PMail Quota'").Get(&warning)
+	if warning.ThreadId != warning.Id {
+		t.Errorf("warning thread_id = %d, id = %d", warning.ThreadId, warning.Id)
+	}
+	if matched, ok := fulltext.Filter(ctx, []int{warning.Id}, []string{"quota"}, false); !ok || !matched[warning.Id] {
+		t.Error("warning not indexed")
+	}
+
+	store(t, user, 15000)
+	Warn(ctx, user.ID)
+	if warnings(t, user.ID) != 2 {
+		t.Errorf("warnings at 95%% = %d", warnings(t, user.ID))
+	}
+
+	// 提高配额后用量降到警告线以下，再次超过时重新警告
+	if err := SetUser(ctx, user.ID, 1000000); err != nil {
+		t.Fatal(err)
+	}
+	db.Instance.ID(user.ID).Get(user)
+	if user.QuotaWarned != 0 {
+		t.Errorf("quota_warned = %d", user.QuotaWarned)
+	}
+	if err := SetUser(ctx, user.ID, 100000); err != nil {
+		t.Fatal(err)
+	}
+	if warnings(t, user.ID) != 3 {
+		t.Errorf("warnings after lowering quota = %d", warnings(t, user.ID))
+	}
+
+	// 域名配额的警告发给管理员
+	if err := SetDomain(ctx, "example.com", 110000); err != nil {
+		t.Fatal(err)
+	}
+	if warnings(t, admin.ID) != 1 {
+		t.Errorf("admin warnings = %d", warnings(t, admin.ID))
+	}
+}
+
+func TestDomainUsageCache(t *testing.T) {
+	user := initTestDB(t)
+	ctx := &context.Context{}
+	if err := SetDomain(ctx, "example.com", 1000); err != nil {
+		t.Fatal(err)
+	}
+
+	// 缓存期内新存入的邮件不会重新统计
+	store(t, user, 400)
+	if q := Domain(ctx, "example.com"); q.Usage != 0 {
+		t.Errorf("cached usage = %d", q.Usage)
+	}
+	domainUsageCache.updated = time.Now().Add(-domainUsageTTL)
+	if q := Domain(ctx, "example.com"); q.Usage != 400 {
+		t.Errorf("usage after expiry = %d", q.Usage)
+	}
+
+	// 修改配额时重新统计
+	store(t, user, 300)
+	if err := SetDomain(ctx, "example.com", 800); err != nil {
+		t.Fatal(err)
+	}
+	if q := Over(ctx, user, 101); q == nil || q.Root != "example.com" || q.Usage != 700 {
+		t.Errorf("domain quota = %+v", q)
+	}
+}
@@ -0,0 +1,48 @@
+package match
+
+import (
+	"strings"
+
+	"github.com/Jinnrry/pmail/dto/parsemail"
+	"github.com/Jinnrry/pmail/utils/context"
+)
+
+// AffixMatch 前缀或者后缀匹配
+type AffixMatch struct {
+	Rule       string
+	Field      string
+	Header     string
+	IgnoreCase bool
+	Suffix     bool
+}
+
+func NewStartsWithMatch(field, rule string) *AffixMatch {
+	return &AffixMatch{
+		Rule:  rule,
+		Field: field,
+	}
+}
+
+func NewEndsWithMatch(field, rule string) *AffixMatch {
+	return &AffixMatch{
+		Rule:   rule,
+		Field:  field,
+		Suffix: true,
+	}
+}
+
+func (r *AffixMatch) Match(ctx *context.Context, email *parsemail.Email) bool {
+	rule := r.Rule
+	if r.IgnoreCase {
+		rule = strings.ToLower(rule)
+	}
+	return anyValue(r.Field, r.Header, email, func(content string) bool {
+		if r.IgnoreCase {
+			content = strings.ToLower(content)
+		}
+		if r.Suffix {
+			return strings.HasSuffix(content, rule)
+		}
+		return strings.HasPrefix(content, rule)
+	})
+}
@@ -1,16 +1,41 @@
 package match
 
 import (
+	"mime"
+	"strings"
+
 	"github.com/Jinnrry/pmail/dto/parsemail"
 	"github.com/Jinnrry/pmail/utils/context"
+	"github.com/emersion/go-message"
+	"github.com/spf13/cast"
+)
+
+const (
+	RuleTypeRegex      = "regex"
+	RuleTypeContains   = "contains"
+	RuleTypeEq         = "equal"
+	RuleTypeStartsWith = "starts_with"
+	RuleTypeEndsWith   = "ends_with"
+	// 数值比较
+	RuleTypeGt = "gt"
+	RuleTypeGe = "ge"
+	RuleTypeLt = "lt"
+	RuleTypeLe = "le"
 )
 
+// 条件组类型
 const (
-	RuleTypeRegex    = "regex"
-	RuleTypeContains = "contains"
-	RuleTypeEq       = "equal"
+	GroupAll = "all"
+	GroupAny = "any"
+	GroupNot = "not"
 )
 
+// Fields 支持匹配的字段
+var Fields = []string{
+	"From", "Subject", "To", "Cc", "Bcc", "ReplyTo", "Text", "Html", "Content", "Sender", "Tag",
+	"Header", "EnvelopeTo", "Size", "AttachmentName", "AttachmentType", "HasAttachment", "SPF", "DKIM", "ListId",
+}
+
 type Match interface {
 	Match(ctx *context.Context, email *parsemail.Email) bool
 }
@@ -46,6 +71,8 @@ func getFieldContent(field string, email *parsemail.Email) string {
 		return string(email.HTML)
 	case "Sender":
 		return email.Sender.EmailAddress
+	case "Tag":
+		return email.Tag
 	case "Content":
 		b := string(email.HTML)
 		b2 := string(email.Text)
@@ -53,3 +80,76 @@ func getFieldContent(field string, email *parsemail.Email) string {
 	}
 	return ""
 }
+
+var wordDecoder = &mime.WordDecoder{CharsetReader: message.CharsetReader}
+
+func passFail(pass bool) string {
+	if pass {
+		return "pass"
+	}
+	return "fail"
+}
+
+// getFieldValues 返回字段的全部取值，任意一个取值满足条件即为匹配
+func getFieldValues(field, header string, email *parsemail.Email) []string {
+	switch field {
+	case "Header":
+		var ret []string
+		for _, v := range email.ReceivedHeader.Values(header) {
+			if decoded, err := wordDecoder.DecodeHeader(v); err == nil {
+				v = decoded
+			}
+			ret = append(ret, strings.TrimSpace(v))
+		}
+		return ret
+	case "ListId":
+		v := email.ListId
+		if v == "" {
+			v = parsemail.ParseListId(email.ReceivedHeader.Get("List-Id"))
+		}
+		if v == "" {
+			return nil
+		}
+		return []string{v}
+	case "EnvelopeTo":
+		return email.EnvelopeTo
+	case "Size":
+		return []string{cast.ToString(email.Size)}
+	case "AttachmentName", "AttachmentType":
+		var ret []string
+		for _, a := range email.Attachments {
+			if a.ContentID != "" {
+				continue
+			}
+			if field == "AttachmentName" {
+				ret = append(ret, a.Filename)
+			} else {
+				ret = append(ret, a.ContentType)
+			}
+		}
+		return ret
+	case "HasAttachment":
+		for _, a := range email.Attachments {
+			// 正文中引用的内嵌图片不算附件
+			if a.ContentID == "" {
+				return []string{"true"}
+			}
+		}
+		return []string{"false"}
+	case "SPF":
+		return []string{passFail(email.SPFPass)}
+	case "DKIM":
+		return []string{passFail(email.DKIMPass)}
+	}
+	return []string{getFieldContent(field, email)}
+}
+
+// anyValue 字段的任意一个取值满足条件时返回true
+func anyValue(field, header string, email *parsemail.Email, fn func(string) bool) bool {
+	for _, v := range getFieldValues(field, header, email) {
+		if fn(v) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,101 @@
+package match
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/Jinnrry/pmail/dto"
+	"github.com/Jinnrry/pmail/utils/array"
+)
+
+// maxDepth 条件组最多嵌套的层数
+const maxDepth = 8
+
+// Build 根据规则条件创建匹配器，不支持的条件返回nil，在条件组中会被忽略
+func Build(v *dto.Value) Match {
+	if v == nil {
+		return nil
+	}
+	if v.Op != "" {
+		var children []Match
+		for _, c := range v.Children {
+			if m := Build(c); m != nil {
+				children = append(children, m)
+			}
+		}
+		return NewGroupMatch(v.Op, children)
+	}
+
+	switch v.Type {
+	case RuleTypeRegex:
+		m := NewRegexMatch(v.Field, v.Rule)
+		m.Header, m.IgnoreCase = v.Header, v.IgnoreCase
+		return m
+	case RuleTypeContains:
+		m := NewContainsMatch(v.Field, v.Rule)
+		m.Header, m.IgnoreCase = v.Header, v.IgnoreCase
+		return m
+	case RuleTypeEq:
+		m := NewEqualMatch(v.Field, v.Rule)
+		m.Header, m.IgnoreCase = v.Header, v.IgnoreCase
+		return m
+	case RuleTypeStartsWith:
+		m := NewStartsWithMatch(v.Field, v.Rule)
+		m.Header, m.IgnoreCase = v.Header, v.IgnoreCase
+		return m
+	case RuleTypeEndsWith:
+		m := NewEndsWithMatch(v.Field, v.Rule)
+		m.Header, m.IgnoreCase = v.Header, v.IgnoreCase
+		return m
+	case RuleTypeGt, RuleTypeGe, RuleTypeLt, RuleTypeLe:
+		m := NewNumericMatch(v.Field, v.Type, v.Rule)
+		m.Header = v.Header
+		return m
+	}
+	return nil
+}
+
+// Validate 校验规则条件，保存规则前调用
+func Validate(values []*dto.Value) error {
+	return validate(values, 0)
+}
+
+func validate(values []*dto.Value, depth int) error {
+	if depth > maxDepth {
+		return fmt.Errorf("condition groups nested too deep")
+	}
+	for _, v := range values {
+		if v == nil {
+			return fmt.Errorf("empty condition")
+		}
+		if v.Op != "" {
+			if !array.InArray(v.Op, []string{GroupAll, GroupAny, GroupNot}) {
+				return fmt.Errorf("unknown condition group %s", v.Op)
+			}
+			if err := validate(v.Children, depth+1); err != nil {
+				return err
+			}
+			continue
+		}
+		if !array.InArray(v.Field, Fields) {
+			return fmt.Errorf("params error! Rule Field Error!")
+		}
+		if v.Field == "Header" && strings.TrimSpace(v.Header) == "" {
+			return fmt.Errorf("header name is empty")
+		}
+		switch v.Type {
+		case RuleTypeEq, RuleTypeContains, RuleTypeStartsWith, RuleTypeEndsWith:
+		case RuleTypeRegex:
+			if _, err := (&RegexMatch{Rule: v.Rule, IgnoreCase: v.IgnoreCase}).compile(); err != nil {
+				return fmt.Errorf("invalid regex %s: %v", v.Rule, err)
+			}
+		case RuleTypeGt, RuleTypeGe, RuleTypeLt, RuleTypeLe:
+			if _, ok := ParseNumber(v.Rule); !ok {
+				return fmt.Errorf("%s is not a number", v.Rule)
+			}
+		default:
+			return fmt.Errorf("unknown rule type %s", v.Type)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,104 @@
+package match
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/Jinnrry/pmail/dto"
+	"github.com/Jinnrry/pmail/dto/parsemail"
+	"github.com/Jinnrry/pmail/utils/context"
+)
+
+const testMail = "From: Bob <bob@remote.net>\r\n" +
+	"To: alice@example.com\r\n" +
+	"Subject: =?utf-8?B?5rWL6K+VIFtQUl0gZml4IGJ1Zw==?=\r\n" +
+	"List-Id: Dev list <dev.remote.net>\r\n" +
+	"X-Spam-Score: 7.5\r\n" +
+	"Content-Type: multipart/mixed; boundary=b1\r\n" +
+	"\r\n" +
+	"--b1\r\n" +
+	"Content-Type: text/plain; charset=utf-8\r\n" +
+	"\r\n" +
+	"Please review the Invoice\r\n" +
+	"--b1\r\n" +
+	"Content-Type: application/pdf\r\n" +
+	"Content-Disposition: attachment; filename=\"invoice-42.pdf\"\r\n" +
+	"Content-Transfer-Encoding: base64\r\n" +
+	"\r\n" +
+	"JVBERi0xLjQK\r\n" +
+	"--b1--\r\n"
+
+func testEmail() *parsemail.Email {
+	email := parsemail.NewEmailFromReader([]string{"alice+work@example.com"}, strings.NewReader(testMail), len(testMail))
+	email.EnvelopeTo = []string{"alice+work@example.com"}
+	email.SPFPass = true
+	return email
+}
+
+func TestBuild(t *testing.T) {
+	email := testEmail()
+	ctx := &context.Context{}
+	tests := []struct {
+		name string
+		v    *dto.Value
+		want bool
+	}{
+		{"header decoded", &dto.Value{Field: "Header", Header: "subject", Type: RuleTypeContains, Rule: "[PR]"}, true},
+		{"header missing", &dto.Value{Field: "Header", Header: "X-None", Type: RuleTypeContains, Rule: ""}, false},
+		{"case sensitive", &dto.Value{Field: "Text", Type: RuleTypeContains, Rule: "invoice"}, false},
+		{"ignore case", &dto.Value{Field: "Text", Type: RuleTypeContains, Rule: "invoice", IgnoreCase: true}, true},
+		{"starts with", &dto.Value{Field: "From", Type: RuleTypeStartsWith, Rule: "BOB@", IgnoreCase: true}, true},
+		{"ends with", &dto.Value{Field: "From", Type: RuleTypeEndsWith, Rule: "@remote.net"}, true},
+		{"envelope to", &dto.Value{Field: "EnvelopeTo", Type: RuleTypeEq, Rule: "alice+work@example.com"}, true},
+		{"size gt", &dto.Value{Field: "Size", Type: RuleTypeGt, Rule: "100"}, true},
+		{"size le", &dto.Value{Field: "Size", Type: RuleTypeLe, Rule: "1K"}, true},
+		{"size lt", &dto.Value{Field: "Size", Type: RuleTypeLt, Rule: "100"}, false},
+		{"header numeric", &dto.Value{Field: "Header", Header: "X-Spam-Score", Type: RuleTypeGe, Rule: "7.5"}, true},
+		{"attachment name", &dto.Value{Field: "AttachmentName", Type: RuleTypeRegex, Rule: `^invoice-\d+\.PDF$`, IgnoreCase: true}, true},
+		{"attachment type", &dto.Value{Field: "AttachmentType", Type: RuleTypeEq, Rule: "application/pdf"}, true},
+		{"has attachment", &dto.Value{Field: "HasAttachment", Type: RuleTypeEq, Rule: "true"}, true},
+		{"spf", &dto.Value{Field: "SPF", Type: RuleTypeEq, Rule: "pass"}, true},
+		{"dkim", &dto.Value{Field: "DKIM", Type: RuleTypeEq, Rule: "pass"}, false},
+		{"list id", &dto.Value{Field: "ListId", Type: RuleTypeEq, Rule: "dev.remote.net"}, true},
+		{"any", &dto.Value{Op: GroupAny, Children: []*dto.Value{
+			{Field: "DKIM", Type: RuleTypeEq, Rule: "pass"},
+			{Field: "SPF", Type: RuleTypeEq, Rule: "pass"},
+		}}, true},
+		{"not all", &dto.Value{Op: GroupNot, Children: []*dto.Value{
+			{Field: "SPF", Type: RuleTypeEq, Rule: "pass"},
+			{Field: "DKIM", Type: RuleTypeEq, Rule: "pass"},
+		}}, true},
+		{"nested", &dto.Value{Op: GroupAll, Children: []*dto.Value{
+			{Field: "HasAttachment", Type: RuleTypeEq, Rule: "true"},
+			{Op: GroupNot, Children: []*dto.Value{{Field: "From", Type: RuleTypeEndsWith, Rule: "@example.com"}}},
+			{Op: GroupAny, Children: []*dto.Value{}},
+		}}, false},
+		{"unknown type ignored", &dto.Value{Op: GroupAll, Children: []*dto.Value{{Field: "From", Type: "foo"}}}, true},
+	}
+	for _, tt := range tests {
+		if got := Build(tt.v).Match(ctx, email); got != tt.want {
+			t.Errorf("%s: Match() = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		values []*dto.Value
+		err    string
+	}{
+		{[]*dto.Value{{Field: "Subject", Type: RuleTypeEq}}, ""},
+		{[]*dto.Value{{Field: "Body", Type: RuleTypeEq}}, "Rule Field Error"},
+		{[]*dto.Value{{Field: "Header", Type: RuleTypeEq}}, "header name is empty"},
+		{[]*dto.Value{{Field: "Size", Type: RuleTypeGt, Rule: "big"}}, "not a number"},
+		{[]*dto.Value{{Field: "Subject", Type: RuleTypeRegex, Rule: "("}}, "invalid regex"},
+		{[]*dto.Value{{Op: "xor"}}, "unknown condition group"},
+		{[]*dto.Value{{Op: GroupAny, Children: []*dto.Value{{Field: "Subject", Type: "like"}}}}, "unknown rule type"},
+	}
+	for _, tt := range tests {
+		err := Validate(tt.values)
+		if (tt.err == "" && err != nil) || (tt.err != "" && (err == nil || !strings.Contains(err.Error(), tt.err))) {
+			t.Errorf("Validate(%+v) = %v, want %q", tt.values[0], err, tt.err)
+		}
+	}
+}
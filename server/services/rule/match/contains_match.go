@@ -7,8 +7,10 @@ import (
 )
 
 type ContainsMatch struct {
-	Rule  string
-	Field string
+	Rule       string
+	Field      string
+	Header     string
+	IgnoreCase bool
 }
 
 func NewContainsMatch(field, rule string) *ContainsMatch {
@@ -19,6 +21,10 @@ func NewContainsMatch(field, rule string) *ContainsMatch {
 }
 
 func (r *ContainsMatch) Match(ctx *context.Context, email *parsemail.Email) bool {
-	content := getFieldContent(r.Field, email)
-	return strings.Contains(content, r.Rule)
+	return anyValue(r.Field, r.Header, email, func(content string) bool {
+		if r.IgnoreCase {
+			return strings.Contains(strings.ToLower(content), strings.ToLower(r.Rule))
+		}
+		return strings.Contains(content, r.Rule)
+	})
 }
@@ -1,13 +1,17 @@
 package match
 
 import (
+	"strings"
+
 	"github.com/Jinnrry/pmail/dto/parsemail"
 	"github.com/Jinnrry/pmail/utils/context"
 )
 
 type EqualMatch struct {
-	Rule  string
-	Field string
+	Rule       string
+	Field      string
+	Header     string
+	IgnoreCase bool
 }
 
 func NewEqualMatch(field, rule string) *EqualMatch {
@@ -18,6 +22,10 @@ func NewEqualMatch(field, rule string) *EqualMatch {
 }
 
 func (r *EqualMatch) Match(ctx *context.Context, email *parsemail.Email) bool {
-	content := getFieldContent(r.Field, email)
-	return content == r.Rule
+	return anyValue(r.Field, r.Header, email, func(content string) bool {
+		if r.IgnoreCase {
+			return strings.EqualFold(content, r.Rule)
+		}
+		return content == r.Rule
+	})
 }
@@ -0,0 +1,43 @@
+package match
+
+import (
+	"github.com/Jinnrry/pmail/dto/parsemail"
+	"github.com/Jinnrry/pmail/utils/context"
+)
+
+// GroupMatch 条件组，all 要求全部满足，any 满足任意一个即可，not 在全部满足时返回false
+type GroupMatch struct {
+	Op       string
+	Children []Match
+}
+
+func NewGroupMatch(op string, children []Match) *GroupMatch {
+	return &GroupMatch{
+		Op:       op,
+		Children: children,
+	}
+}
+
+func (r *GroupMatch) all(ctx *context.Context, email *parsemail.Email) bool {
+	for _, m := range r.Children {
+		if !m.Match(ctx, email) {
+			return false
+		}
+	}
+	return true
+}
+
+func (r *GroupMatch) Match(ctx *context.Context, email *parsemail.Email) bool {
+	switch r.Op {
+	case GroupAny:
+		for _, m := range r.Children {
+			if m.Match(ctx, email) {
+				return true
+			}
+		}
+		return false
+	case GroupNot:
+		return !r.all(ctx, email)
+	}
+	return r.all(ctx, email)
+}
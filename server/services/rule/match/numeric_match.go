@@ -0,0 +1,73 @@
+package match
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/Jinnrry/pmail/dto/parsemail"
+	"github.com/Jinnrry/pmail/utils/context"
+)
+
+// NumericMatch 数值比较，字段取值不是数字时不匹配
+type NumericMatch struct {
+	Rule   string
+	Field  string
+	Header string
+	Type   string
+}
+
+func NewNumericMatch(field, ruleType, rule string) *NumericMatch {
+	return &NumericMatch{
+		Rule:  rule,
+		Field: field,
+		Type:  ruleType,
+	}
+}
+
+// ParseNumber 解析数字，支持K、M、G后缀，比如邮件大小 10M
+func ParseNumber(s string) (float64, bool) {
+	s = strings.TrimSpace(s)
+	unit := 1.0
+	if s != "" {
+		switch s[len(s)-1] {
+		case 'k', 'K':
+			unit = 1024
+		case 'm', 'M':
+			unit = 1024 * 1024
+		case 'g', 'G':
+			unit = 1024 * 1024 * 1024
+		}
+		if unit > 1 {
+			s = s[:len(s)-1]
+		}
+	}
+	n, err := strconv.ParseFloat(strings.TrimSpace(s), 64)
+	if err != nil {
+		return 0, false
+	}
+	return n * unit, true
+}
+
+func (r *NumericMatch) Match(ctx *context.Context, email *parsemail.Email) bool {
+	rule, ok := ParseNumber(r.Rule)
+	if !ok {
+		return false
+	}
+	return anyValue(r.Field, r.Header, email, func(content string) bool {
+		v, ok := ParseNumber(content)
+		if !ok {
+			return false
+		}
+		switch r.Type {
+		case RuleTypeGt:
+			return v > rule
+		case RuleTypeGe:
+			return v >= rule
+		case RuleTypeLt:
+			return v < rule
+		case RuleTypeLe:
+			return v <= rule
+		}
+		return false
+	})
+}
@@ -1,15 +1,22 @@
 package match
 
 import (
+	"time"
+
 	"github.com/Jinnrry/pmail/dto/parsemail"
 	"github.com/Jinnrry/pmail/utils/context"
 	"github.com/dlclark/regexp2"
 	log "github.com/sirupsen/logrus"
 )
 
+// regexTimeout 防止恶意正则表达式在大邮件上执行过久
+const regexTimeout = time.Second
+
 type RegexMatch struct {
-	Rule  string
-	Field string
+	Rule       string
+	Field      string
+	Header     string
+	IgnoreCase bool
 }
 
 func NewRegexMatch(field, rule string) *RegexMatch {
@@ -19,14 +26,26 @@ func NewRegexMatch(field, rule string) *RegexMatch {
 	}
 }
 
-func (r *RegexMatch) Match(ctx *context.Context, email *parsemail.Email) bool {
-	content := getFieldContent(r.Field, email)
-	re := regexp2.MustCompile(r.Rule, 0)
-	match, err := re.MatchString(content)
+func (r *RegexMatch) compile() (*regexp2.Regexp, error) {
+	var opts regexp2.RegexOptions
+	if r.IgnoreCase {
+		opts |= regexp2.IgnoreCase
+	}
+	return regexp2.Compile(r.Rule, opts)
+}
 
+func (r *RegexMatch) Match(ctx *context.Context, email *parsemail.Email) bool {
+	re, err := r.compile()
 	if err != nil {
 		log.WithContext(ctx).Errorf("rule regex error %v", err)
+		return false
 	}
-
-	return match
+	re.MatchTimeout = regexTimeout
+	return anyValue(r.Field, r.Header, email, func(content string) bool {
+		match, err := re.MatchString(content)
+		if err != nil {
+			log.WithContext(ctx).Errorf("rule regex error %v", err)
+		}
+		return match
+	})
 }
@@ -0,0 +1,282 @@
+package rule
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/Jinnrry/pmail/db"
+	"github.com/Jinnrry/pmail/dto"
+	"github.com/Jinnrry/pmail/dto/parsemail"
+	"github.com/Jinnrry/pmail/models"
+	"github.com/Jinnrry/pmail/services/list"
+	"github.com/Jinnrry/pmail/utils/async"
+	"github.com/Jinnrry/pmail/utils/context"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cast"
+	. "xorm.io/builder"
+)
+
+// batchSize 每次从数据库读取的邮件数量
+const batchSize = 100
+
+// pageSize 分页读取范围内邮件id时每页的数量
+const pageSize = 1000
+
+// Scope 对已有邮件执行规则时的范围
+type Scope struct {
+	// Tag 邮件所在的文件夹，与邮件列表接口的tag参数含义相同
+	Tag dto.SearchTag
+	// Start End 按收信时间过滤，零值表示不限
+	Start time.Time
+	End   time.Time
+}
+
+// Matched 一封命中规则的邮件
+type Matched struct {
+	Id       int    `json:"id"`
+	Subject  string `json:"subject"`
+	From     string `json:"from"`
+	Datetime string `json:"datetime"`
+}
+
+// GetRule 读取用户的规则，不存在时返回nil
+func GetRule(ctx *context.Context, userId, id int) *dto.Rule {
+	var r models.Rule
+	has, err := db.Instance.Where("id=? and user_id=?", id, userId).Get(&r)
+	if err != nil {
+		log.WithContext(ctx).Errorf("sqlERror :%v", err)
+	}
+	if !has {
+		return nil
+	}
+	return (&dto.Rule{}).Decode(&r)
+}
+
+// fromModel 把数据库中的邮件转换成规则匹配使用的结构，Header条件使用入库时保存的原始邮件头
+func fromModel(e *models.Email, ue *models.UserEmail) *parsemail.Email {
+	email := parsemail.NewEmailFromModel(*e)
+	if email.Sender == nil {
+		email.Sender = &parsemail.User{}
+	}
+	email.Size = e.Size
+	email.SPFPass = e.SPFCheck == 1
+	email.DKIMPass = e.DKIMCheck == 1
+	if ue != nil {
+		email.Tag = ue.Tag
+		email.Status = int(ue.Status)
+	}
+	return email
+}
+
+// scopeIds 返回范围内全部邮件的id，从新到旧排列
+func scopeIds(ctx *context.Context, scope *Scope) []int {
+	ids := []int{}
+	seen := map[int]bool{}
+	for offset := 0; ; offset += pageSize {
+		items, _ := list.GetEmailList(ctx, scope.Tag, nil, true, offset, pageSize)
+		for _, item := range items {
+			// 分页期间收到的新邮件会让后面的页重复前一页的邮件
+			if !seen[item.Id] {
+				seen[item.Id] = true
+				ids = append(ids, item.Id)
+			}
+		}
+		if len(items) < pageSize {
+			break
+		}
+	}
+	if scope.Start.IsZero() && scope.End.IsZero() {
+		return ids
+	}
+
+	ret := []int{}
+	for i := 0; i < len(ids); i += batchSize {
+		cond := In("id", ids[i:min(i+batchSize, len(ids))])
+		if !scope.Start.IsZero() {
+			cond = cond.And(Gte{"create_time": scope.Start})
+		}
+		if !scope.End.IsZero() {
+			cond = cond.And(Lt{"create_time": scope.End})
+		}
+		var found []int
+		err := db.Instance.Table(&models.Email{}).Cols("id").Where(cond).Desc("id").Find(&found)
+		if err != nil {
+			log.WithContext(ctx).Errorf("sqlERror :%v", err)
+			continue
+		}
+		ret = append(ret, found...)
+	}
+	return ret
+}
+
+// scan 按顺序遍历邮件，先取出全部id再分批读取，执行动作移动邮件后不会影响遍历
+func scan(ctx *context.Context, ids []int, fn func(email *parsemail.Email)) {
+	for i := 0; i < len(ids); i += batchSize {
+		batch := ids[i:min(i+batchSize, len(ids))]
+
+		var emails []*models.Email
+		err := db.Instance.Where(In("id", batch)).Desc("id").Find(&emails)
+		if err != nil {
+			log.WithContext(ctx).Errorf("sqlERror :%v", err)
+			continue
+		}
+
+		var ues []*models.UserEmail
+		err = db.Instance.Where(And(In("email_id", batch), Eq{"user_id": ctx.UserID})).Find(&ues)
+		if err != nil {
+			log.WithContext(ctx).Errorf("sqlERror :%v", err)
+		}
+		ueMap := map[int]*models.UserEmail{}
+		for _, ue := range ues {
+			ueMap[ue.EmailID] = ue
+		}
+
+		for _, e := range emails {
+			fn(fromModel(e, ueMap[e.Id]))
+		}
+	}
+}
+
+// Test 用规则匹配范围内的已有邮件，不执行任何动作。返回范围内的邮件数量、命中数量和最多limit封命中的邮件
+func Test(ctx *context.Context, r *dto.Rule, scope *Scope, limit int) (int, int, []*Matched) {
+	ids := scopeIds(ctx, scope)
+	count := 0
+	ret := []*Matched{}
+	scan(ctx, ids, func(email *parsemail.Email) {
+		if !MatchRule(ctx, r, email) {
+			return
+		}
+		count++
+		if len(ret) < limit {
+			ret = append(ret, &Matched{
+				Id:       cast.ToInt(email.MessageId),
+				Subject:  email.Subject,
+				From:     email.From.EmailAddress,
+				Datetime: email.Date,
+			})
+		}
+	})
+	return len(ids), count, ret
+}
+
+// 后台任务状态
+const (
+	JobRunning  = "running"
+	JobFinished = "finished"
+	JobFailed   = "failed"
+)
+
+// Job 对已有邮件执行规则的后台任务，每个用户同时只能有一个任务
+type Job struct {
+	Id        int64  `json:"id"`
+	RuleId    int    `json:"rule_id"`
+	RuleName  string `json:"rule_name"`
+	Status    string `json:"status"`
+	Total     int    `json:"total"`
+	Processed int    `json:"processed"`
+	Matched   int    `json:"matched"`
+	Error     string `json:"error"`
+	StartTime string `json:"start_time"`
+	EndTime   string `json:"end_time"`
+}
+
+var (
+	jobsMu sync.Mutex
+	// jobs 每个用户最近一次的任务
+	jobs  = map[int]*Job{}
+	jobId int64
+)
+
+// GetJob 返回用户最近一次任务的进度，没有任务时返回nil
+func GetJob(userId int) *Job {
+	jobsMu.Lock()
+	defer jobsMu.Unlock()
+	job, ok := jobs[userId]
+	if !ok {
+		return nil
+	}
+	ret := *job
+	return &ret
+}
+
+func updateJob(job *Job, fn func(job *Job)) {
+	jobsMu.Lock()
+	defer jobsMu.Unlock()
+	fn(job)
+}
+
+// Apply 在后台对范围内的已有邮件执行规则动作，已有邮件没有信封发件人，模板回复动作不会发送回复。
+// 转发使用入库时保存的原始邮件头与重新构建的正文
+func Apply(ctx *context.Context, r *dto.Rule, scope *Scope) (*Job, error) {
+	var user models.User
+	has, err := db.Instance.Where("id=?", ctx.UserID).Get(&user)
+	if err != nil {
+		return nil, err
+	}
+	if !has {
+		return nil, errors.New("user not found")
+	}
+
+	jobsMu.Lock()
+	if job, ok := jobs[ctx.UserID]; ok && job.Status == JobRunning {
+		jobsMu.Unlock()
+		return nil, errors.New("a rule job is already running")
+	}
+	jobId++
+	job := &Job{
+		Id:        jobId,
+		RuleId:    r.Id,
+		RuleName:  r.Name,
+		Status:    JobRunning,
+		StartTime: time.Now().Format("2006-01-02 15:04:05"),
+	}
+	jobs[ctx.UserID] = job
+	ret := *job
+	jobsMu.Unlock()
+
+	// 请求结束后继续执行，不能使用请求的context
+	jobCtx := &context.Context{
+		UserID:      ctx.UserID,
+		UserAccount: ctx.UserAccount,
+		UserName:    ctx.UserName,
+		Lang:        ctx.Lang,
+	}
+	jobCtx.SetValue(context.LogID, ctx.GetValue(context.LogID))
+
+	async.New(jobCtx).Process(func(params any) {
+		done := false
+		defer func() {
+			updateJob(job, func(job *Job) {
+				job.Status = JobFinished
+				if !done {
+					job.Status = JobFailed
+					job.Error = "rule job interrupted"
+				}
+				job.EndTime = time.Now().Format("2006-01-02 15:04:05")
+			})
+		}()
+
+		ids := scopeIds(jobCtx, scope)
+		updateJob(job, func(job *Job) {
+			job.Total = len(ids)
+		})
+
+		scan(jobCtx, ids, func(email *parsemail.Email) {
+			matched := MatchRule(jobCtx, r, email)
+			if matched {
+				DoRule(jobCtx, r, email, &user, email.StoredRawMessage(jobCtx))
+			}
+			updateJob(job, func(job *Job) {
+				job.Processed++
+				if matched {
+					job.Matched++
+				}
+			})
+		})
+		done = true
+		log.WithContext(jobCtx).Infof("Rule %s applied to %d emails", r.Name, len(ids))
+	}, nil)
+
+	return &ret, nil
+}
@@ -0,0 +1,72 @@
+package rule
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Jinnrry/pmail/db"
+	"github.com/Jinnrry/pmail/db/dbtest"
+	"github.com/Jinnrry/pmail/dto"
+	"github.com/Jinnrry/pmail/models"
+	"github.com/Jinnrry/pmail/services/rule/match"
+	"github.com/Jinnrry/pmail/utils/context"
+)
+
+func TestRetroactiveRule(t *testing.T) {
+	dbtest.Init(t)
+	alice := &models.User{Account: "alice", Name: "Alice"}
+	if _, err := db.Instance.Insert(alice); err != nil {
+		t.Fatal(err)
+	}
+	for _, subject := range []string{"invoice 1", "hello", "invoice 2"} {
+		e := &models.Email{Subject: subject, FromAddress: "shop@remote.net", To: `[{"EmailAddress":"alice@example.com"}]`,
+			Headers: "X-Shop-Order: " + subject + "\r\n\r\n"}
+		if _, err := db.Instance.Insert(e); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := db.Instance.Insert(&models.UserEmail{UserID: alice.ID, EmailID: e.Id}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	ctx := &context.Context{UserID: alice.ID}
+	r := &dto.Rule{Name: "invoice", UserId: alice.ID,
+		Rules:   []*dto.Value{{Field: "Subject", Type: match.RuleTypeContains, Rule: "invoice"}},
+		Actions: []*dto.RuleAction{{Action: dto.READ}},
+	}
+	scope := &Scope{Tag: dto.SearchTag{Type: -1, Status: -1, GroupId: -1}}
+
+	total, matched, lst := Test(ctx, r, scope, 1)
+	if total != 3 || matched != 2 || len(lst) != 1 || lst[0].Subject != "invoice 2" {
+		t.Errorf("test = %d %d %+v", total, matched, lst)
+	}
+	if count, _ := db.Instance.Where("is_read=1").Count(&models.UserEmail{}); count != 0 {
+		t.Errorf("dry run changed %d emails", count)
+	}
+
+	future := &Scope{Tag: scope.Tag, Start: time.Now().AddDate(0, 0, 1)}
+	if total, _, _ := Test(ctx, r, future, 10); total != 0 {
+		t.Errorf("date range total = %d", total)
+	}
+
+	// 入库时保存的原始邮件头可以用于匹配
+	header := &dto.Rule{Rules: []*dto.Value{{Field: "Header", Header: "X-Shop-Order", Type: match.RuleTypeEq, Rule: "hello"}}}
+	if _, matched, _ := Test(ctx, header, scope, 10); matched != 1 {
+		t.Errorf("header matched = %d", matched)
+	}
+
+	job, err := Apply(ctx, r, scope)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 100 && job.Status == JobRunning; i++ {
+		time.Sleep(10 * time.Millisecond)
+		job = GetJob(alice.ID)
+	}
+	if job.Status != JobFinished || job.Total != 3 || job.Processed != 3 || job.Matched != 2 {
+		t.Errorf("job = %+v", job)
+	}
+	if count, _ := db.Instance.Where("is_read=1").Count(&models.UserEmail{}); count != 2 {
+		t.Errorf("read emails = %d", count)
+	}
+}
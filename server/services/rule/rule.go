@@ -1,8 +1,18 @@
 package rule
 
 import (
+	"bytes"
+	"encoding/json"
 	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
 	"strings"
+	"sync"
+	"syscall"
+	"time"
+	"unicode"
 
 	"github.com/Jinnrry/pmail/config"
 	"github.com/Jinnrry/pmail/consts"
@@ -10,11 +20,16 @@ import (
 	"github.com/Jinnrry/pmail/dto"
 	"github.com/Jinnrry/pmail/dto/parsemail"
 	"github.com/Jinnrry/pmail/models"
+	"github.com/Jinnrry/pmail/services/flag"
+	"github.com/Jinnrry/pmail/services/modseq"
 	"github.com/Jinnrry/pmail/services/rule/match"
+	"github.com/Jinnrry/pmail/services/vacation"
+	"github.com/Jinnrry/pmail/utils/async"
 	"github.com/Jinnrry/pmail/utils/context"
 	"github.com/Jinnrry/pmail/utils/send"
 	log "github.com/sirupsen/logrus"
 	"github.com/spf13/cast"
+	"xorm.io/builder"
 )
 
 func GetAllRules(ctx *context.Context, userId int) []*dto.Rule {
@@ -23,7 +38,7 @@ func GetAllRules(ctx *context.Context, userId int) []*dto.Rule {
 	if userId == 0 {
 		return nil
 	} else {
-		err = db.Instance.Where("user_id=?", userId).Decr("sort").Find(&res)
+		err = db.Instance.Where("user_id=?", userId).Desc("sort").Asc("id").Find(&res)
 	}
 
 	if err != nil {
@@ -37,35 +52,23 @@ func GetAllRules(ctx *context.Context, userId int) []*dto.Rule {
 	return ret
 }
 
+// MatchRule 规则中的条件全部满足时返回true，条件可以是嵌套的条件组
 func MatchRule(ctx *context.Context, rule *dto.Rule, email *parsemail.Email) bool {
-
-	for _, r := range rule.Rules {
-		var m match.Match
-
-		switch r.Type {
-		case match.RuleTypeRegex:
-			m = match.NewRegexMatch(r.Field, r.Rule)
-		case match.RuleTypeContains:
-			m = match.NewContainsMatch(r.Field, r.Rule)
-		case match.RuleTypeEq:
-			m = match.NewEqualMatch(r.Field, r.Rule)
-		}
-		if m == nil {
-			continue
-		}
-
-		if !m.Match(ctx, email) {
-			return false
-		}
-	}
-
-	return true
+	return match.Build(&dto.Value{Op: match.GroupAll, Children: rule.Rules}).Match(ctx, email)
 }
 
 func DoRule(ctx *context.Context, rule *dto.Rule, email *parsemail.Email, user *models.User, rawEmailData []byte) {
 	log.WithContext(ctx).Debugf("执行规则:%s", rule.Name)
 
-	switch rule.Action {
+	change := modseq.Begin(ctx, nil, builder.Eq{"email_id": email.MessageId, "user_id": rule.UserId})
+	for _, action := range rule.GetActions() {
+		doAction(ctx, rule, action, email, user, rawEmailData)
+	}
+	change.Commit()
+}
+
+func doAction(ctx *context.Context, rule *dto.Rule, action *dto.RuleAction, email *parsemail.Email, user *models.User, rawEmailData []byte) {
+	switch action.Action {
 	case dto.READ:
 		if email.MessageId > 0 {
 			_, err := db.Instance.Table(&models.UserEmail{}).Where("email_id=? and user_id=?", email.MessageId, rule.UserId).Cols("is_read").Update(map[string]interface{}{"is_read": 1})
@@ -74,21 +77,244 @@ func DoRule(ctx *context.Context, rule *dto.Rule, email *parsemail.Email, user *
 			}
 		}
 	case dto.DELETE:
-		_, err := db.Instance.Table(&models.UserEmail{}).Where("email_id=? and user_id=?", email.MessageId, rule.UserId).Cols("status").Update(map[string]interface{}{"status": consts.EmailStatusDel})
-		if err != nil {
-			log.WithContext(ctx).Errorf("sqlERror :%v", err)
-		}
+		relocate(ctx, rule, email, func(ue *models.UserEmail) {
+			ue.Status = consts.EmailStatusDel
+		})
 	case dto.FORWARD:
-		err := doForward(ctx, email, rule.Params, user, rawEmailData)
-		if err != nil {
-			log.WithContext(ctx).Errorf("Forward Error:%v", err)
-		} else {
-			log.WithContext(ctx).Infof("Forward Success:%s@%s -> %s", user.Account, config.Instance.Domains[0], rule.Params)
+		// 转发到多个地址，原邮件仍然保留在收件人的邮箱中
+		for _, address := range SplitAddresses(action.Params) {
+			err := doForward(ctx, email, address, user, rawEmailData)
+			if err != nil {
+				log.WithContext(ctx).Errorf("Forward Error:%v", err)
+			} else {
+				log.WithContext(ctx).Infof("Forward Success:%s@%s -> %s", user.Account, config.Instance.Domains[0], address)
+			}
 		}
 	case dto.MOVE:
-		doMove(ctx, rule, email, user)
+		doMove(ctx, rule, action.Params, email)
+	case dto.FLAG:
+		if email.MessageId > 0 {
+			if err := flag.Add(ctx, rule.UserId, email.MessageId, flag.Parse(action.Params)); err != nil {
+				log.WithContext(ctx).Errorf("Flag Error:%v", err)
+			}
+		}
+	case dto.REPLY:
+		doReply(ctx, rule, cast.ToInt(action.Params), email, user, rawEmailData)
+	case dto.WEBHOOK:
+		doWebhook(ctx, rule, action.Params, email, user)
+	}
+}
+
+// SplitAddresses 拆分逗号、分号或空白分隔的多个地址
+func SplitAddresses(params string) []string {
+	return strings.FieldsFunc(params, func(r rune) bool {
+		return r == ',' || r == ';' || unicode.IsSpace(r)
+	})
+}
+
+// GetTemplate 读取用户的回复模板，不存在时返回nil
+func GetTemplate(ctx *context.Context, userId, id int) *models.ReplyTemplate {
+	var tpl models.ReplyTemplate
+	has, err := db.Instance.Where("id=? and user_id=?", id, userId).Get(&tpl)
+	if err != nil {
+		log.WithContext(ctx).Errorf("sqlERror :%v", err)
+	}
+	if !has {
+		return nil
+	}
+	return &tpl
+}
+
+// doReply 使用回复模板自动回复发件人，与自动回复一样遵守RFC 3834，同一发件人每天最多回复一次
+func doReply(ctx *context.Context, rule *dto.Rule, templateId int, email *parsemail.Email, user *models.User, rawEmailData []byte) {
+	tpl := GetTemplate(ctx, rule.UserId, templateId)
+	if tpl == nil {
+		log.WithContext(ctx).Warnf("reply template %d not found", templateId)
+		return
 	}
+	subject := tpl.Subject
+	if subject == "" {
+		subject = "Re: " + email.Subject
+	}
+	vacation.Respond(ctx, user, email.EnvelopeFrom, rawEmailData, email, &vacation.Options{
+		Subject: subject,
+		Content: tpl.Content,
+		Days:    1,
+		Handle:  fmt.Sprintf("rule-template-%d", tpl.Id),
+	})
+}
+
+// webhookClient 请求Webhook使用的客户端，只允许连接公网地址，测试中可以替换
+var webhookClient = &http.Client{
+	Timeout: 10 * time.Second,
+	Transport: &http.Transport{
+		DialContext: (&net.Dialer{
+			Timeout: 5 * time.Second,
+			Control: webhookControl,
+		}).DialContext,
+		TLSHandshakeTimeout: 5 * time.Second,
+	},
+	CheckRedirect: func(req *http.Request, via []*http.Request) error {
+		if len(via) >= 3 {
+			return fmt.Errorf("too many redirects")
+		}
+		if !ValidWebhook(req.URL.String()) {
+			return fmt.Errorf("invalid redirect url: %s", req.URL)
+		}
+		return nil
+	},
+}
 
+// webhooks 正在执行的Webhook请求
+var webhooks sync.WaitGroup
+
+// webhookControl 建立连接前检查解析后的IP，禁止访问本机和内网地址，重定向后的连接同样会经过这里
+func webhookControl(network, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	ip := net.ParseIP(host)
+	if ip == nil || blockedIP(ip) {
+		return fmt.Errorf("webhook address %s is not allowed", host)
+	}
+	return nil
+}
+
+// blockedNets net.IP 的方法没有覆盖的特殊地址段
+var blockedNets = parseCIDRs(
+	"0.0.0.0/8",     // 本网络，0.x.x.x 在Linux上会连接到本机
+	"100.64.0.0/10", // 运营商级NAT
+	"192.0.0.0/24",  // IETF协议分配
+	"198.18.0.0/15", // 网络测试
+	"64:ff9b::/96",  // NAT64，可以映射到任意IPv4地址
+)
+
+func parseCIDRs(cidrs ...string) []*net.IPNet {
+	var ret []*net.IPNet
+	for _, cidr := range cidrs {
+		_, n, err := net.ParseCIDR(cidr)
+		if err != nil {
+			panic(err)
+		}
+		ret = append(ret, n)
+	}
+	return ret
+}
+
+// blockedIP 本机、内网、链路本地、未指定地址以及 blockedNets 中的地址
+func blockedIP(ip net.IP) bool {
+	if ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsMulticast() || ip.IsUnspecified() {
+		return true
+	}
+	for _, n := range blockedNets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+type webhookPayload struct {
+	Rule          string   `json:"rule"`
+	Account       string   `json:"account"`
+	EmailId       int64    `json:"email_id"`
+	MessageId     string   `json:"message_id"`
+	From          string   `json:"from"`
+	To            []string `json:"to"`
+	Cc            []string `json:"cc"`
+	Subject       string   `json:"subject"`
+	Date          string   `json:"date"`
+	Size          int      `json:"size"`
+	Tag           string   `json:"tag"`
+	HasAttachment bool     `json:"has_attachment"`
+}
+
+func addresses(users []*parsemail.User) []string {
+	ret := []string{}
+	for _, u := range users {
+		ret = append(ret, u.EmailAddress)
+	}
+	return ret
+}
+
+// ValidWebhook Webhook地址只允许http与https，不能是本机或者内网地址
+func ValidWebhook(rawUrl string) bool {
+	u, err := url.Parse(rawUrl)
+	if err != nil || u.Hostname() == "" {
+		return false
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return false
+	}
+	if strings.EqualFold(u.Hostname(), "localhost") {
+		return false
+	}
+	if ip := net.ParseIP(u.Hostname()); ip != nil && blockedIP(ip) {
+		return false
+	}
+	return true
+}
+
+// doWebhook 把邮件摘要以JSON格式POST到指定地址，不包含邮件正文。
+// 请求在后台执行，不阻塞收信流程
+func doWebhook(ctx *context.Context, rule *dto.Rule, rawUrl string, email *parsemail.Email, user *models.User) {
+	if !ValidWebhook(rawUrl) {
+		log.WithContext(ctx).Errorf("Webhook Error:invalid webhook url: %s", rawUrl)
+		return
+	}
+	payload := webhookPayload{
+		Rule:          rule.Name,
+		Account:       user.Account,
+		EmailId:       email.MessageId,
+		MessageId:     email.MsgID,
+		To:            addresses(email.To),
+		Cc:            addresses(email.Cc),
+		Subject:       email.Subject,
+		Date:          email.Date,
+		Size:          email.Size,
+		Tag:           email.Tag,
+		HasAttachment: len(email.Attachments) > 0,
+	}
+	if email.From != nil {
+		payload.From = email.From.EmailAddress
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		log.WithContext(ctx).Errorf("Webhook Error:%v", err)
+		return
+	}
+
+	// 收信结束后继续执行，不能使用收信的context
+	jobCtx := &context.Context{UserID: user.ID, UserAccount: user.Account}
+	jobCtx.SetValue(context.LogID, ctx.GetValue(context.LogID))
+	webhooks.Add(1)
+	async.New(jobCtx).Process(func(params any) {
+		defer webhooks.Done()
+		if err := postWebhook(rawUrl, body); err != nil {
+			log.WithContext(jobCtx).Errorf("Webhook Error:%v", err)
+		}
+	}, nil)
+}
+
+func postWebhook(rawUrl string, body []byte) error {
+	req, err := http.NewRequest(http.MethodPost, rawUrl, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "PMail")
+	resp, err := webhookClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 4096))
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook %s response status %d", rawUrl, resp.StatusCode)
+	}
+	return nil
 }
 
 func doForward(ctx *context.Context, email *parsemail.Email, forwardAddress string, user *models.User, rawEmailData []byte) error {
@@ -163,9 +389,9 @@ func isLocalDomain(domain string) bool {
 	return strings.EqualFold(domain, config.Instance.Domain)
 }
 
-func doMove(ctx *context.Context, rule *dto.Rule, email *parsemail.Email, user *models.User) {
+func doMove(ctx *context.Context, rule *dto.Rule, params string, email *parsemail.Email) {
 
-	groupId := cast.ToInt(rule.Params)
+	groupId := cast.ToInt(params)
 	switch groupId {
 	case models.INBOX:
 		_, err := db.Instance.Table(&models.Email{}).Where("id=?", email.MessageId).
@@ -173,51 +399,40 @@ func doMove(ctx *context.Context, rule *dto.Rule, email *parsemail.Email, user *
 		if err != nil {
 			log.WithContext(ctx).Errorf("sqlERror :%v", err)
 		}
-
-		_, err = db.Instance.Table(&models.UserEmail{}).Where("email_id=? and user_id=?", email.MessageId, rule.UserId).
-			Cols("group_id", "status").Update(map[string]interface{}{"group_id": 0, "status": 0})
-		if err != nil {
-			log.WithContext(ctx).Errorf("sqlERror :%v", err)
-		}
+		relocate(ctx, rule, email, boxUpdate(0, 0))
 	case models.Sent:
 		_, err := db.Instance.Table(&models.Email{}).Where("id=?", email.MessageId).
 			Cols("type").Update(map[string]interface{}{"type": consts.EmailTypeSend})
 		if err != nil {
 			log.WithContext(ctx).Errorf("sqlERror :%v", err)
 		}
-
-		_, err = db.Instance.Table(&models.UserEmail{}).Where("email_id=? and user_id=?", email.MessageId, rule.UserId).
-			Cols("group_id", "status").Update(map[string]interface{}{"group_id": 0, "status": 0})
-		if err != nil {
-			log.WithContext(ctx).Errorf("sqlERror :%v", err)
-		}
-
+		relocate(ctx, rule, email, boxUpdate(0, 0))
 	case models.Drafts:
-		_, err := db.Instance.Table(&models.UserEmail{}).Where("email_id=? and user_id=?", email.MessageId, rule.UserId).
-			Cols("group_id", "status").Update(map[string]interface{}{"group_id": 0, "status": consts.EmailStatusDrafts})
-		if err != nil {
-			log.WithContext(ctx).Errorf("sqlERror :%v", err)
-		}
-
+		relocate(ctx, rule, email, boxUpdate(0, consts.EmailStatusDrafts))
 	case models.Deleted:
-		_, err := db.Instance.Table(&models.UserEmail{}).Where("email_id=? and user_id=?", email.MessageId, rule.UserId).
-			Cols("group_id", "status").Update(map[string]interface{}{"group_id": 0, "status": consts.EmailStatusDel})
-		if err != nil {
-			log.WithContext(ctx).Errorf("sqlERror :%v", err)
-		}
+		relocate(ctx, rule, email, boxUpdate(0, consts.EmailStatusDel))
 	case models.Junk:
-		_, err := db.Instance.Table(&models.UserEmail{}).Where("email_id=? and user_id=?", email.MessageId, rule.UserId).
-			Cols("group_id", "status").Update(map[string]interface{}{"group_id": 0, "status": consts.EmailStatusJunk})
-		if err != nil {
-			log.WithContext(ctx).Errorf("sqlERror :%v", err)
-		}
-
+		relocate(ctx, rule, email, boxUpdate(0, consts.EmailStatusJunk))
 	default:
+		relocate(ctx, rule, email, func(ue *models.UserEmail) {
+			ue.GroupId = groupId
+		})
+	}
 
-		_, err := db.Instance.Table(&models.UserEmail{}).Where("email_id=? and user_id=?", email.MessageId, rule.UserId).Cols("group_id").Update(map[string]interface{}{"group_id": groupId})
-		if err != nil {
-			log.WithContext(ctx).Errorf("sqlERror :%v", err)
-		}
+}
+
+// boxUpdate 设置邮件的分组与状态
+func boxUpdate(groupId int, status int8) func(ue *models.UserEmail) {
+	return func(ue *models.UserEmail) {
+		ue.GroupId = groupId
+		ue.Status = status
 	}
+}
 
+// relocate 修改规则命中的邮件所在的文件夹，移到其他文件夹的邮件使用新的UID
+func relocate(ctx *context.Context, rule *dto.Rule, email *parsemail.Email, update func(ue *models.UserEmail)) {
+	_, _, err := modseq.Relocate(ctx, nil, builder.Eq{"email_id": email.MessageId, "user_id": rule.UserId}, update)
+	if err != nil {
+		log.WithContext(ctx).Errorf("sqlERror :%v", err)
+	}
 }
@@ -0,0 +1,185 @@
+package rule
+
+import (
+	stdctx "context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/Jinnrry/pmail/db"
+	"github.com/Jinnrry/pmail/db/dbtest"
+	"github.com/Jinnrry/pmail/dto"
+	"github.com/Jinnrry/pmail/dto/parsemail"
+	"github.com/Jinnrry/pmail/models"
+	"github.com/Jinnrry/pmail/services/modseq"
+	"github.com/Jinnrry/pmail/utils/context"
+	"github.com/spf13/cast"
+)
+
+func TestDoRuleActions(t *testing.T) {
+	dbtest.Init(t)
+	alice := &models.User{Account: "alice", Name: "Alice"}
+	bob := &models.User{Account: "bob", Name: "Bob"}
+	carol := &models.User{Account: "carol", Name: "Carol"}
+	if _, err := db.Instance.Insert(alice, bob, carol); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Instance.Insert(&models.UserEmail{UserID: alice.ID, EmailID: 7}); err != nil {
+		t.Fatal(err)
+	}
+
+	var payload webhookPayload
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.Header.Get("Content-Type") != "application/json" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		json.NewDecoder(r.Body).Decode(&payload)
+	}))
+	defer srv.Close()
+	// 测试服务器监听在本机，默认的客户端会拒绝连接
+	if err := postWebhook(srv.URL, []byte("{}")); err == nil {
+		t.Error("webhook to loopback address should fail")
+	}
+	old := webhookClient
+	webhookClient = &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx stdctx.Context, network, addr string) (net.Conn, error) {
+			return net.Dial(network, srv.Listener.Addr().String())
+		},
+	}}
+	defer func() { webhookClient = old }()
+
+	email := &parsemail.Email{
+		MessageId: 7,
+		Subject:   "invoice",
+		From:      &parsemail.User{EmailAddress: "shop@remote.net"},
+		To:        []*parsemail.User{{EmailAddress: "alice@example.com"}},
+	}
+	r := &dto.Rule{Name: "invoice", UserId: alice.ID, Actions: []*dto.RuleAction{
+		{Action: dto.FLAG, Params: `\flagged $invoice \Seen bad"word`},
+		{Action: dto.FORWARD, Params: "bob@example.com; carol@example.com"},
+		{Action: dto.WEBHOOK, Params: "http://hooks.example.net/mail"},
+	}}
+	DoRule(&context.Context{}, r, email, alice, nil)
+	webhooks.Wait()
+
+	var ue models.UserEmail
+	if _, err := db.Instance.Where("email_id=7 and user_id=?", alice.ID).Get(&ue); err != nil {
+		t.Fatal(err)
+	}
+	if ue.Flagged != 1 || ue.IsRead != 1 || ue.Keywords != "$invoice" {
+		t.Errorf("flags = %d %d %q", ue.Flagged, ue.IsRead, ue.Keywords)
+	}
+	count, err := db.Instance.Where("email_id=7").Count(&models.UserEmail{})
+	if err != nil || count != 3 {
+		t.Errorf("forward copies = %d %v", count, err)
+	}
+	if payload.Rule != "invoice" || payload.Account != "alice" || payload.From != "shop@remote.net" || len(payload.To) != 1 {
+		t.Errorf("webhook payload = %+v", payload)
+	}
+
+	for _, u := range []string{"file:///etc/passwd", "http://127.0.0.1:8080/hook", "http://localhost/hook", "http://10.0.0.1/", "http://[::1]/", "http://169.254.169.254/latest"} {
+		if ValidWebhook(u) {
+			t.Errorf("ValidWebhook(%q) = true", u)
+		}
+	}
+	if !ValidWebhook("https://hooks.example.net/mail") {
+		t.Error("public webhook rejected")
+	}
+}
+
+func TestBlockedIP(t *testing.T) {
+	tests := []struct {
+		ip      string
+		blocked bool
+	}{
+		{"127.0.0.1", true},
+		{"10.1.2.3", true},
+		{"172.16.0.1", true},
+		{"192.168.1.1", true},
+		{"169.254.169.254", true},
+		{"0.0.0.0", true},
+		{"0.1.2.3", true},
+		{"100.64.0.1", true},
+		{"100.127.255.254", true},
+		{"192.0.0.8", true},
+		{"198.18.0.1", true},
+		{"198.19.255.255", true},
+		{"224.0.0.1", true},
+		{"::", true},
+		{"::1", true},
+		{"fe80::1", true},
+		{"fc00::1", true},
+		{"::ffff:127.0.0.1", true},
+		{"64:ff9b::7f00:1", true},
+		{"64:ff9b::a00:1", true},
+		{"8.8.8.8", false},
+		{"100.128.0.1", false},
+		{"192.0.2.1", false},
+		{"198.20.0.1", false},
+		{"2001:4860:4860::8888", false},
+	}
+	for _, tt := range tests {
+		if got := blockedIP(net.ParseIP(tt.ip)); got != tt.blocked {
+			t.Errorf("blockedIP(%s) = %v", tt.ip, got)
+		}
+	}
+	if ValidWebhook("http://100.64.0.1/hook") || ValidWebhook("http://[64:ff9b::7f00:1]/hook") {
+		t.Error("special-purpose webhook address accepted")
+	}
+}
+
+func TestGetAllRulesOrder(t *testing.T) {
+	dbtest.Init(t)
+	for _, r := range []*dto.Rule{{Name: "low", Sort: 1}, {Name: "high", Sort: 9, Stop: true}, {Name: "mid", Sort: 5}} {
+		m := r.Encode()
+		m.UserId = 1
+		if _, err := db.Instance.Insert(m); err != nil {
+			t.Fatal(err)
+		}
+	}
+	rules := GetAllRules(&context.Context{}, 1)
+	if len(rules) != 3 || rules[0].Name != "high" || rules[2].Name != "low" || !rules[0].Stop {
+		t.Errorf("rules = %+v", rules)
+	}
+}
+
+func TestMoveRuleNewUID(t *testing.T) {
+	dbtest.Init(t)
+	alice := &models.User{Account: "alice", Name: "Alice"}
+	if _, err := db.Instance.Insert(alice); err != nil {
+		t.Fatal(err)
+	}
+	group := &models.Group{Name: "shop", UserId: alice.ID}
+	if _, err := db.Instance.Insert(group); err != nil {
+		t.Fatal(err)
+	}
+	// 收件箱中的邮件比文件夹中已有的邮件更早，原UID小于目标文件夹的 UIDNEXT
+	inbox := &models.UserEmail{UserID: alice.ID, EmailID: 7}
+	filed := &models.UserEmail{UserID: alice.ID, EmailID: 8, GroupId: group.ID}
+	if _, err := db.Instance.Insert(inbox, filed); err != nil {
+		t.Fatal(err)
+	}
+	ctx := &context.Context{UserID: alice.ID}
+	state, err := modseq.State(ctx, nil, alice.ID, group.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := &dto.Rule{Name: "shop", UserId: alice.ID, Actions: []*dto.RuleAction{{Action: dto.MOVE, Params: cast.ToString(group.ID)}}}
+	DoRule(ctx, r, &parsemail.Email{MessageId: 7}, alice, nil)
+
+	var ue models.UserEmail
+	if _, err := db.Instance.Where("email_id=7 and user_id=?", alice.ID).Get(&ue); err != nil {
+		t.Fatal(err)
+	}
+	if ue.GroupId != group.ID || ue.ID < state.UIDNext {
+		t.Errorf("moved email = group %d uid %d, UIDNEXT was %d", ue.GroupId, ue.ID, state.UIDNext)
+	}
+	vanished, err := modseq.Vanished(ctx, alice.ID, models.INBOX, 0)
+	if err != nil || len(vanished) != 1 || vanished[0] != inbox.ID {
+		t.Errorf("inbox vanished = %v %v", vanished, err)
+	}
+}
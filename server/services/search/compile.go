@@ -0,0 +1,244 @@
+package search
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Jinnrry/pmail/consts"
+	"github.com/Jinnrry/pmail/db"
+	"github.com/Jinnrry/pmail/dto"
+	"github.com/Jinnrry/pmail/models"
+	"github.com/Jinnrry/pmail/services/fulltext"
+	"github.com/Jinnrry/pmail/utils/context"
+	log "github.com/sirupsen/logrus"
+	"xorm.io/builder"
+)
+
+// Filter 编译后的搜索条件，列名使用 e(email) 与 ue(user_email) 别名
+type Filter struct {
+	Cond builder.Cond
+	// HasFolder 搜索语句中指定了 in:，此时不再限定当前所在的文件夹
+	HasFolder bool
+	// Rank 全文搜索词的相关度子查询，结果列为 email_id 与 score，没有全文搜索词时为空
+	Rank       string
+	RankParams []any
+}
+
+// Folders 文件夹名称，同时识别 IMAP 中的文件夹名称
+var Folders = map[string]dto.SearchTag{
+	"inbox":            {Type: 0, Status: -1, GroupId: 0},
+	"sent":             {Type: 1, Status: -1},
+	"sent messages":    {Type: 1, Status: -1},
+	"drafts":           {Type: 0, Status: consts.EmailStatusDrafts},
+	"draft":            {Type: 0, Status: consts.EmailStatusDrafts},
+	"trash":            {Type: -1, Status: consts.EmailStatusDel},
+	"deleted":          {Type: -1, Status: consts.EmailStatusDel},
+	"deleted messages": {Type: -1, Status: consts.EmailStatusDel},
+	"junk":             {Type: -1, Status: consts.EmailStatusJunk},
+	"spam":             {Type: -1, Status: consts.EmailStatusJunk},
+}
+
+var dateLayouts = []string{"2006-01-02", "2006/01/02", "2006-1-2", "2006/1/2"}
+
+// FolderCond 文件夹的筛选条件，与网页端各个文件夹的 dto.SearchTag 对应
+func FolderCond(tag dto.SearchTag) builder.Cond {
+	cond := builder.NewCond()
+	switch tag.Status {
+	case -1:
+		if tag.Type != 1 {
+			cond = cond.And(builder.Eq{"ue.status": 0})
+		} else {
+			// 发件箱不展示已删除的邮件
+			cond = cond.And(builder.Neq{"ue.status": consts.EmailStatusDel})
+		}
+	case consts.EmailStatusDel:
+		cond = cond.And(builder.Or(builder.Eq{"ue.status": tag.Status}, builder.Eq{"ue.group_id": models.Deleted}))
+	case consts.EmailStatusDrafts:
+		cond = cond.And(builder.Or(builder.Eq{"ue.status": tag.Status}, builder.Eq{"ue.group_id": models.Drafts}))
+	case consts.EmailStatusJunk:
+		cond = cond.And(builder.Or(builder.Eq{"ue.status": tag.Status}, builder.Eq{"ue.group_id": models.Junk}))
+	}
+
+	if tag.Type == consts.EmailTypeReceive {
+		cond = cond.And(builder.Eq{"e.type": tag.Type})
+	} else if tag.Type == consts.EmailTypeSend {
+		cond = cond.And(builder.Or(builder.Eq{"e.type": tag.Type}, builder.Eq{"ue.group_id": models.Sent}))
+	}
+
+	if tag.GroupId > 0 {
+		cond = cond.And(builder.Eq{"ue.group_id": tag.GroupId})
+	} else if tag.GroupId == -1 || (tag.GroupId == 0 && tag.Status == -1) {
+		cond = cond.And(builder.In("ue.group_id", 0, models.INBOX))
+	}
+	return cond
+}
+
+// Compile 把搜索语句编译为查询条件，文件夹不存在、日期或大小格式错误时返回 *Error。
+// 搜索语句为空时返回 nil
+func (q *Query) Compile(ctx *context.Context) (*Filter, error) {
+	if q == nil || len(q.Terms) == 0 {
+		return nil, nil
+	}
+	filter := &Filter{Cond: builder.NewCond()}
+	var texts []string
+	for _, term := range q.Terms {
+		if term.Key == "" && !term.Negate {
+			texts = append(texts, term.Value)
+			continue
+		}
+		if term.Key == KeyIn && !term.Negate {
+			filter.HasFolder = true
+		}
+		cond, err := compileTerm(ctx, term)
+		if err != nil {
+			return nil, err
+		}
+		if cond == nil {
+			continue
+		}
+		if term.Negate {
+			cond = builder.Not{cond}
+		}
+		filter.Cond = filter.Cond.And(cond)
+	}
+
+	if len(texts) > 0 {
+		if match, params, ok := fulltext.Match(texts, false); ok {
+			filter.Cond = filter.Cond.And(builder.Expr("e.id in (select email_id from ("+match+") fts)", params...))
+			filter.Rank, filter.RankParams = match, params
+		}
+		// 只有符号的搜索词无法使用全文索引
+		for _, text := range texts {
+			if _, _, ok := fulltext.Match([]string{text}, false); !ok {
+				filter.Cond = filter.Cond.And(like(text, "e.subject", "e.text"))
+			}
+		}
+	}
+	return filter, nil
+}
+
+func compileTerm(ctx *context.Context, term *Term) (builder.Cond, error) {
+	value := term.Value
+	switch term.Key {
+	case "":
+		if match, params, ok := fulltext.Match([]string{value}, false); ok {
+			return builder.Expr("e.id in (select email_id from ("+match+") fts)", params...), nil
+		}
+		return like(value, "e.subject", "e.text"), nil
+	case KeyFrom:
+		return like(value, "e.from_address", "e.from_name"), nil
+	case KeyTo:
+		return like(value, "e."+db.Instance.Quote("to"), "e.cc", "e.bcc"), nil
+	case KeySubject:
+		return like(value, "e.subject"), nil
+	case KeyHas:
+		if strings.ToLower(value) != "attachment" {
+			return nil, termError(term, "unknown has: value %q, expected attachment", value)
+		}
+		// 没有附件时保存的是空数组或者 null
+		return builder.And(builder.NotNull{"e.attachments"}, builder.NotIn("e.attachments", "", "[]", "null")), nil
+	case KeyIs:
+		switch strings.ToLower(value) {
+		case "unread":
+			return builder.Or(builder.Eq{"ue.is_read": 0}, builder.IsNull{"ue.is_read"}), nil
+		case "read":
+			return builder.Eq{"ue.is_read": 1}, nil
+		case "starred", "flagged":
+			return builder.Eq{"ue.flagged": 1}, nil
+		case "answered":
+			return builder.Eq{"ue.answered": 1}, nil
+		}
+		return nil, termError(term, "unknown is: value %q, expected unread, read, starred or answered", value)
+	case KeyBefore, KeyAfter:
+		date, err := parseDate(value)
+		if err != nil {
+			return nil, termError(term, "invalid date %q, expected YYYY-MM-DD", value)
+		}
+		if term.Key == KeyBefore {
+			return builder.Lt{"e.send_date": date}, nil
+		}
+		return builder.Gte{"e.send_date": date}, nil
+	case KeyLarger, KeySmaller:
+		size, err := parseSize(value)
+		if err != nil {
+			return nil, termError(term, "invalid size %q, expected a number with optional K, M or G", value)
+		}
+		if term.Key == KeyLarger {
+			return builder.Gt{"e.size": size}, nil
+		}
+		return builder.Lt{"e.size": size}, nil
+	case KeyIn:
+		lower := strings.ToLower(value)
+		if lower == "anywhere" || lower == "all" {
+			return nil, nil
+		}
+		if tag, ok := Folders[lower]; ok {
+			return FolderCond(tag), nil
+		}
+		// 自定义文件夹，可以使用完整路径，不区分大小写
+		var group models.Group
+		has, err := db.Instance.Table("group").Where(builder.Eq{"user_id": ctx.UserID}.And(
+			builder.Or(builder.Expr("lower(name)=?", lower), builder.Expr("lower(full_path)=?", lower)))).Get(&group)
+		if err != nil {
+			log.WithContext(ctx).Errorf("SQL Error:%v", err)
+		}
+		if !has {
+			return nil, termError(term, "unknown folder %q", value)
+		}
+		return FolderCond(dto.SearchTag{Type: -1, Status: -1, GroupId: group.ID}), nil
+	}
+	return nil, termError(term, "unknown search key %q", term.Key)
+}
+
+func termError(term *Term, format string, args ...any) error {
+	return &Error{Pos: term.Pos, Msg: fmt.Sprintf(format, args...)}
+}
+
+// like 任意一列包含 value，不区分大小写
+func like(value string, columns ...string) builder.Cond {
+	cond := builder.NewCond()
+	for _, column := range columns {
+		cond = cond.Or(builder.Expr("lower("+column+") like ?", "%"+strings.ToLower(value)+"%"))
+	}
+	return cond
+}
+
+// parseDate 解析本地时区的日期
+func parseDate(value string) (time.Time, error) {
+	var err error
+	for _, layout := range dateLayouts {
+		var t time.Time
+		if t, err = time.ParseInLocation(layout, value, time.Local); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, err
+}
+
+// parseSize 解析字节数，支持 K、M、G 单位
+func parseSize(value string) (int64, error) {
+	upper := strings.TrimSuffix(strings.ToUpper(value), "B")
+	unit := int64(1)
+	switch {
+	case strings.HasSuffix(upper, "K"):
+		unit = 1 << 10
+	case strings.HasSuffix(upper, "M"):
+		unit = 1 << 20
+	case strings.HasSuffix(upper, "G"):
+		unit = 1 << 30
+	}
+	if unit > 1 {
+		upper = upper[:len(upper)-1]
+	}
+	n, err := strconv.ParseFloat(upper, 64)
+	if err != nil {
+		return 0, err
+	}
+	if n < 0 {
+		return 0, errors.New("negative size")
+	}
+	return int64(n * float64(unit)), nil
+}
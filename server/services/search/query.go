@@ -0,0 +1,163 @@
+// Package search 解析网页端的搜索语句，语法与 Gmail 类似：
+//
+//	from:bob to:alice subject:"周报" has:attachment is:unread
+//	before:2026-01-01 after:2025/12/01 larger:1M in:sent -in:trash "quoted phrase" -word
+//
+// 没有前缀的词与引号中的短语使用全文索引搜索，"-" 表示排除。
+// 解析结果由 Compile 编译为 xorm.io/builder 的参数化查询条件
+package search
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// 支持的搜索前缀
+const (
+	KeyFrom    = "from"
+	KeyTo      = "to"
+	KeySubject = "subject"
+	KeyHas     = "has"
+	KeyIs      = "is"
+	KeyBefore  = "before"
+	KeyAfter   = "after"
+	KeyLarger  = "larger"
+	KeySmaller = "smaller"
+	KeyIn      = "in"
+)
+
+var keys = map[string]bool{
+	KeyFrom: true, KeyTo: true, KeySubject: true, KeyHas: true, KeyIs: true,
+	KeyBefore: true, KeyAfter: true, KeyLarger: true, KeySmaller: true, KeyIn: true,
+}
+
+// Error 搜索语句的语法错误，Pos 为出错位置，从1开始按字符计算
+type Error struct {
+	Pos int
+	Msg string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("position %d: %s", e.Pos, e.Msg)
+}
+
+// Term 搜索语句中的一个条件，Key 为空时 Value 是全文搜索词
+type Term struct {
+	Key    string
+	Value  string
+	Negate bool
+	// Pos 条件在搜索语句中的位置，用于报告编译错误
+	Pos int
+}
+
+// Query 解析后的搜索语句，多个条件同时满足
+type Query struct {
+	Terms []*Term
+}
+
+// Parse 解析搜索语句。不认识的前缀（比如 "re:meeting"）作为普通的搜索词
+func Parse(query string) (*Query, error) {
+	p := &parser{src: []rune(query)}
+	q := &Query{}
+	for {
+		p.skipSpace()
+		if p.eof() {
+			return q, nil
+		}
+		term, err := p.term()
+		if err != nil {
+			return nil, err
+		}
+		q.Terms = append(q.Terms, term)
+	}
+}
+
+type parser struct {
+	src []rune
+	pos int
+}
+
+func (p *parser) eof() bool {
+	return p.pos >= len(p.src)
+}
+
+func (p *parser) skipSpace() {
+	for !p.eof() && unicode.IsSpace(p.src[p.pos]) {
+		p.pos++
+	}
+}
+
+func (p *parser) errorf(pos int, format string, args ...any) error {
+	return &Error{Pos: pos + 1, Msg: fmt.Sprintf(format, args...)}
+}
+
+func (p *parser) term() (*Term, error) {
+	term := &Term{Pos: p.pos + 1}
+	if p.src[p.pos] == '-' && p.pos+1 < len(p.src) && !unicode.IsSpace(p.src[p.pos+1]) {
+		term.Negate = true
+		p.pos++
+	}
+
+	if p.src[p.pos] == '"' {
+		value, err := p.quoted()
+		if err != nil {
+			return nil, err
+		}
+		term.Value = value
+		return term, nil
+	}
+
+	start := p.pos
+	word := p.word()
+	if i := strings.IndexByte(word, ':'); i > 0 && keys[strings.ToLower(word[:i])] {
+		term.Key = strings.ToLower(word[:i])
+		// 前缀后面紧跟引号时，值为引号中的内容
+		p.pos = start + len([]rune(word[:i])) + 1
+		if !p.eof() && p.src[p.pos] == '"' {
+			value, err := p.quoted()
+			if err != nil {
+				return nil, err
+			}
+			term.Value = value
+		} else {
+			term.Value = p.word()
+		}
+		if strings.TrimSpace(term.Value) == "" {
+			return nil, p.errorf(start, "missing value after %s:", term.Key)
+		}
+		return term, nil
+	}
+	term.Value = word
+	return term, nil
+}
+
+// word 读取到下一个空白字符为止
+func (p *parser) word() string {
+	start := p.pos
+	for !p.eof() && !unicode.IsSpace(p.src[p.pos]) {
+		p.pos++
+	}
+	return string(p.src[start:p.pos])
+}
+
+// quoted 读取引号中的内容，引号中可以用 \" 表示引号本身
+func (p *parser) quoted() (string, error) {
+	start := p.pos
+	p.pos++
+	var b strings.Builder
+	for !p.eof() {
+		r := p.src[p.pos]
+		p.pos++
+		switch {
+		case r == '\\' && !p.eof() && p.src[p.pos] == '"':
+			b.WriteRune('"')
+			p.pos++
+		case r == '"':
+			return b.String(), nil
+		default:
+			b.WriteRune(r)
+		}
+	}
+	return "", p.errorf(start, "unterminated quote")
+}
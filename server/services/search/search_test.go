@@ -0,0 +1,162 @@
+package search
+
+import (
+	"database/sql"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/Jinnrry/pmail/consts"
+	"github.com/Jinnrry/pmail/db"
+	"github.com/Jinnrry/pmail/db/dbtest"
+	"github.com/Jinnrry/pmail/dto"
+	"github.com/Jinnrry/pmail/models"
+	"github.com/Jinnrry/pmail/services/fulltext"
+	"github.com/Jinnrry/pmail/utils/context"
+	"xorm.io/builder"
+)
+
+func TestParse(t *testing.T) {
+	q, err := Parse(`from:bob  -is:read subject:"weekly report" "a \"b\"" -spam re:meeting SUBJECT:Hi -`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Term{
+		{Key: KeyFrom, Value: "bob", Pos: 1},
+		{Key: KeyIs, Value: "read", Negate: true, Pos: 11},
+		{Key: KeySubject, Value: "weekly report", Pos: 20},
+		{Value: `a "b"`, Pos: 44},
+		{Value: "spam", Negate: true, Pos: 54},
+		{Value: "re:meeting", Pos: 60},
+		{Key: KeySubject, Value: "Hi", Pos: 71},
+		{Value: "-", Pos: 82},
+	}
+	var got []Term
+	for _, term := range q.Terms {
+		got = append(got, *term)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Parse = %+v", got)
+	}
+
+	for query, msg := range map[string]string{
+		`subject:"unterminated`: "position 9: unterminated quote",
+		`hello from:`:           "position 7: missing value after from:",
+		`from:""`:               "position 1: missing value after from:",
+	} {
+		if _, err := Parse(query); err == nil || err.Error() != msg {
+			t.Errorf("Parse(%q) error = %v", query, err)
+		}
+	}
+}
+
+func TestParseSize(t *testing.T) {
+	for value, want := range map[string]int64{"100": 100, "2k": 2048, "1.5MB": 3 << 19, "1G": 1 << 30} {
+		if got, err := parseSize(value); err != nil || got != want {
+			t.Errorf("parseSize(%q) = %d %v", value, got, err)
+		}
+	}
+	if _, err := parseSize("big"); err == nil {
+		t.Errorf("parseSize(big) no error")
+	}
+}
+
+func TestCompile(t *testing.T) {
+	dbtest.Init(t)
+	ctx := &context.Context{UserID: 1}
+	day := func(d int) time.Time { return time.Date(2026, 3, d, 12, 0, 0, 0, time.Local) }
+	group := &models.Group{Name: "Work", UserId: 1, FullPath: "Work"}
+	db.Instance.Insert(group)
+
+	add := func(e *models.Email, ue models.UserEmail) int {
+		if _, err := db.Instance.Insert(e); err != nil {
+			t.Fatal(err)
+		}
+		fulltext.Index(ctx, e)
+		ue.UserID, ue.EmailID = 1, e.Id
+		db.Instance.Insert(&ue)
+		return e.Id
+	}
+	report := add(&models.Email{Subject: "Weekly report", FromName: "Bob", FromAddress: "bob@remote.net", To: `[{"EmailAddress":"alice@example.com"}]`,
+		Text: sql.NullString{String: "see attached", Valid: true}, Attachments: `[{"Filename":"report.pdf"}]`, Size: 2 << 20, SendDate: day(1)},
+		models.UserEmail{IsRead: 1})
+	lunch := add(&models.Email{Subject: "Lunch?", FromAddress: "carol@remote.net", Cc: `[{"EmailAddress":"alice@example.com"}]`,
+		Text: sql.NullString{String: "pizza", Valid: true}, Attachments: "null", Size: 1000, SendDate: day(5)},
+		models.UserEmail{})
+	sent := add(&models.Email{Type: 1, Subject: "Re: Weekly report", FromAddress: "alice@example.com", To: `[{"EmailAddress":"bob@remote.net"}]`,
+		Attachments: "[]", Size: 500, SendDate: day(10)},
+		models.UserEmail{IsRead: 1})
+	work := add(&models.Email{Subject: "Plan", FromAddress: "dave@remote.net", SendDate: day(3)},
+		models.UserEmail{GroupId: group.ID})
+	trash := add(&models.Email{Subject: "Old report", FromAddress: "bob@remote.net", SendDate: day(2)},
+		models.UserEmail{Status: consts.EmailStatusDel})
+
+	inbox := dto.SearchTag{Type: 0, Status: -1, GroupId: 0}
+	tests := []struct {
+		query string
+		tag   dto.SearchTag
+		want  []int
+	}{
+		{"", inbox, []int{lunch, report}},
+		{"report", inbox, []int{report}},
+		{"-report", inbox, []int{lunch}},
+		{"from:BOB", inbox, []int{report}},
+		{"to:alice", inbox, []int{lunch, report}},
+		{"subject:lunch", inbox, []int{lunch}},
+		{"has:attachment", inbox, []int{report}},
+		{"is:unread", inbox, []int{lunch}},
+		{"-is:unread", inbox, []int{report}},
+		{"after:2026-03-02 before:2026/03/06", inbox, []int{lunch}},
+		{"larger:1M", inbox, []int{report}},
+		{"smaller:1k", inbox, []int{lunch}},
+		{"in:sent", inbox, []int{sent}},
+		{"in:work", inbox, []int{work}},
+		{"in:trash report", inbox, []int{trash}},
+		{"in:anywhere report", inbox, []int{trash, sent, report}},
+		{"in:anywhere -in:trash from:bob", inbox, []int{report}},
+		{`"weekly report"`, dto.SearchTag{Type: 1, Status: -1}, []int{sent}},
+		{"?", inbox, []int{lunch}},
+	}
+	for _, tt := range tests {
+		q, err := Parse(tt.query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		filter, err := q.Compile(ctx)
+		if err != nil {
+			t.Fatalf("Compile(%q) = %v", tt.query, err)
+		}
+		cond := builder.NewCond()
+		if filter == nil || !filter.HasFolder {
+			cond = cond.And(FolderCond(tt.tag))
+		}
+		if filter != nil {
+			cond = cond.And(filter.Cond)
+		}
+		where, params, err := builder.ToSQL(builder.Eq{"ue.user_id": 1}.And(cond))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []int
+		err = db.Instance.SQL("select e.id from email e left join user_email ue on e.id=ue.email_id where "+where+" order by e.id desc", params...).Find(&got)
+		if err != nil {
+			t.Fatalf("%q: %v", tt.query, err)
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%q = %v, want %v", tt.query, got, tt.want)
+		}
+	}
+
+	for query, msg := range map[string]string{
+		"in:nowhere":        `position 1: unknown folder "nowhere"`,
+		"a before:tomorrow": `position 3: invalid date "tomorrow", expected YYYY-MM-DD`,
+		"has:link":          `position 1: unknown has: value "link", expected attachment`,
+		"is:new":            `position 1: unknown is: value "new", expected unread, read, starred or answered`,
+		"larger:lots":       `position 1: invalid size "lots", expected a number with optional K, M or G`,
+	} {
+		q, _ := Parse(query)
+		if _, err := q.Compile(ctx); err == nil || err.Error() != msg {
+			t.Errorf("Compile(%q) error = %v", query, err)
+		}
+	}
+}
@@ -0,0 +1,281 @@
+package sieve
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/Jinnrry/pmail/db"
+	"github.com/Jinnrry/pmail/dto"
+	"github.com/Jinnrry/pmail/models"
+	"github.com/Jinnrry/pmail/services/flag"
+	"github.com/Jinnrry/pmail/services/rule"
+	"github.com/Jinnrry/pmail/services/rule/match"
+	"github.com/Jinnrry/pmail/utils/context"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cast"
+)
+
+// quote 把字符串转换为Sieve的带引号字符串
+func quote(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s) + `"`
+}
+
+// quoteWildcard 转义 :matches 中的通配符
+func quoteWildcard(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `*`, `\*`, `?`, `\?`).Replace(s)
+}
+
+// sizeTest 邮件大小比较转换为 size :over/:under
+func sizeTest(v *dto.Value) (string, bool) {
+	n, ok := match.ParseNumber(v.Rule)
+	if !ok {
+		return "", false
+	}
+	size := int64(n)
+	switch v.Type {
+	case match.RuleTypeGt:
+		return fmt.Sprintf("size :over %d", size), true
+	case match.RuleTypeGe:
+		return fmt.Sprintf("size :over %d", size-1), true
+	case match.RuleTypeLt:
+		return fmt.Sprintf("size :under %d", size), true
+	case match.RuleTypeLe:
+		return fmt.Sprintf("size :under %d", size+1), true
+	}
+	return "", false
+}
+
+// convertTest 把规则中的条件转换为Sieve测试，无法转换时返回false。
+// 原规则默认区分大小写，这里对应使用 i;octet
+func convertTest(v *dto.Value, require map[string]bool) (string, bool) {
+	if v.Op != "" {
+		var tests []string
+		for _, c := range v.Children {
+			t, ok := convertTest(c, require)
+			if !ok {
+				return "", false
+			}
+			tests = append(tests, t)
+		}
+		switch {
+		case v.Op == match.GroupAny && len(tests) == 0:
+			return "false", true
+		case len(tests) == 0:
+			if v.Op == match.GroupNot {
+				return "false", true
+			}
+			return "true", true
+		}
+		list := tests[0]
+		if len(tests) > 1 {
+			op := "allof"
+			if v.Op == match.GroupAny {
+				op = "anyof"
+			}
+			list = op + "(" + strings.Join(tests, ", ") + ")"
+		}
+		if v.Op == match.GroupNot {
+			return "not " + list, true
+		}
+		return list, true
+	}
+
+	if v.Field == "Size" {
+		return sizeTest(v)
+	}
+	key := v.Rule
+	var matchType string
+	switch v.Type {
+	case match.RuleTypeEq:
+		matchType = ":is"
+	case match.RuleTypeContains:
+		matchType = ":contains"
+	case match.RuleTypeStartsWith:
+		matchType, key = ":matches", quoteWildcard(key)+"*"
+	case match.RuleTypeEndsWith:
+		matchType, key = ":matches", "*"+quoteWildcard(key)
+	case match.RuleTypeRegex:
+		matchType = ":regex"
+		require["regex"] = true
+	default:
+		return "", false
+	}
+	comparator := "i;octet"
+	if v.IgnoreCase {
+		comparator = "i;ascii-casemap"
+	}
+	opts := matchType + " :comparator " + quote(comparator)
+	k := quote(key)
+	switch v.Field {
+	case "From":
+		return "address :all " + opts + ` "from" ` + k, true
+	case "To", "Cc", "Bcc":
+		// 原规则匹配的是逗号拼接后的全部地址，这里改为匹配其中任意一个地址
+		return "address :all " + opts + " " + quote(strings.ToLower(v.Field)) + " " + k, true
+	case "ReplyTo":
+		return "address :all " + opts + ` "reply-to" ` + k, true
+	case "Sender":
+		require["envelope"] = true
+		return "envelope :all " + opts + ` "from" ` + k, true
+	case "EnvelopeTo":
+		require["envelope"] = true
+		return "envelope :all " + opts + ` "to" ` + k, true
+	case "Subject":
+		return "header " + opts + ` "subject" ` + k, true
+	case "Header":
+		return "header " + opts + " " + quote(v.Header) + " " + k, true
+	case "ListId":
+		// List-Id 头中还带有描述，只能按包含匹配
+		if v.Type != match.RuleTypeEq && v.Type != match.RuleTypeContains {
+			return "", false
+		}
+		return `header :contains :comparator ` + quote(comparator) + ` "list-id" ` + k, true
+	case "Text":
+		require["body"] = true
+		return `body :content "text/plain" ` + opts + " " + k, true
+	case "Html":
+		require["body"] = true
+		return `body :content "text/html" ` + opts + " " + k, true
+	case "Content":
+		require["body"] = true
+		return "body :text " + opts + " " + k, true
+	case "Tag":
+		require["envelope"] = true
+		require["subaddress"] = true
+		return "envelope :detail " + opts + ` "to" ` + k, true
+	}
+	// 附件、SPF、DKIM 在Sieve中没有对应的测试
+	return "", false
+}
+
+// mailboxName 规则中的分组id转换为文件夹名称
+func mailboxName(ctx *context.Context, groupId int) string {
+	if name, ok := models.GroupCodeToName[groupId]; ok {
+		return name
+	}
+	var info models.Group
+	has, err := db.Instance.Table("group").Where("id=?", groupId).Get(&info)
+	if err != nil {
+		log.WithContext(ctx).Errorf("sql Error :%+v", err)
+	}
+	if !has {
+		return ""
+	}
+	if info.FullPath != "" {
+		return info.FullPath
+	}
+	return info.Name
+}
+
+// oneLine 去掉注释中的换行
+func oneLine(s string) string {
+	return strings.NewReplacer("\r", " ", "\n", " ").Replace(s)
+}
+
+// quoteList 把多个字符串转换为Sieve的字符串列表
+func quoteList(list []string) string {
+	var quoted []string
+	for _, s := range list {
+		quoted = append(quoted, quote(s))
+	}
+	return "[" + strings.Join(quoted, ", ") + "]"
+}
+
+// convertAction 把规则的一个动作转换为Sieve动作，无法转换时返回false
+func convertAction(ctx *context.Context, userId int, a *dto.RuleAction, require map[string]bool) (string, bool) {
+	switch a.Action {
+	case dto.READ:
+		require["imap4flags"] = true
+		return `addflag "\\Seen";`, true
+	case dto.DELETE:
+		require["fileinto"] = true
+		return `fileinto "Deleted Messages";`, true
+	case dto.MOVE:
+		name := mailboxName(ctx, cast.ToInt(a.Params))
+		if name == "" {
+			return "", false
+		}
+		require["fileinto"] = true
+		return "fileinto " + quote(name) + ";", true
+	case dto.FORWARD:
+		var lines []string
+		for _, address := range rule.SplitAddresses(a.Params) {
+			lines = append(lines, "redirect :copy "+quote(address)+";")
+		}
+		if len(lines) == 0 {
+			return "", false
+		}
+		require["copy"] = true
+		return strings.Join(lines, "\n    "), true
+	case dto.FLAG:
+		flags := flag.Parse(a.Params)
+		if len(flags) == 0 {
+			return "", false
+		}
+		require["imap4flags"] = true
+		return "addflag " + quoteList(flags) + ";", true
+	case dto.REPLY:
+		tpl := rule.GetTemplate(ctx, userId, cast.ToInt(a.Params))
+		if tpl == nil {
+			return "", false
+		}
+		require["vacation"] = true
+		action := "vacation :days 1"
+		if tpl.Subject != "" {
+			action += " :subject " + quote(tpl.Subject)
+		}
+		return action + " :handle " + quote(fmt.Sprintf("rule-template-%d", tpl.Id)) + " " + quote(tpl.Content) + ";", true
+	}
+	return "", false
+}
+
+// ConvertRules 把收信规则转换为等价的Sieve脚本，规则按照优先级从高到低排列
+func ConvertRules(ctx *context.Context, rules []*dto.Rule) string {
+	rules = append([]*dto.Rule{}, rules...)
+	sort.SliceStable(rules, func(i, j int) bool {
+		return rules[i].Sort > rules[j].Sort
+	})
+
+	require := map[string]bool{}
+	var body strings.Builder
+	for _, r := range rules {
+		var actions []string
+		for _, a := range r.GetActions() {
+			if action, ok := convertAction(ctx, r.UserId, a, require); ok {
+				actions = append(actions, action)
+			} else if a.Action == dto.WEBHOOK {
+				actions = append(actions, "# webhook "+oneLine(a.Params)+" cannot be expressed in Sieve")
+			}
+		}
+		if len(actions) == 0 {
+			continue
+		}
+		if r.Stop {
+			actions = append(actions, "stop;")
+		}
+
+		name := oneLine(r.Name)
+		condition, ok := convertTest(&dto.Value{Op: match.GroupAll, Children: r.Rules}, require)
+		if !ok {
+			body.WriteString("\n# " + name + ": conditions cannot be expressed in Sieve, skipped\n")
+			continue
+		}
+
+		body.WriteString("\n# " + name + "\n")
+		body.WriteString("if " + condition + " {\n    " + strings.Join(actions, "\n    ") + "\n}\n")
+	}
+
+	var exts []string
+	for ext := range require {
+		exts = append(exts, quote(ext))
+	}
+	sort.Strings(exts)
+	var ret strings.Builder
+	ret.WriteString("# Converted from mail rules\n")
+	if len(exts) > 0 {
+		ret.WriteString("require [" + strings.Join(exts, ", ") + "];\n")
+	}
+	ret.WriteString(body.String())
+	return ret.String()
+}
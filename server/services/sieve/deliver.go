@@ -0,0 +1,272 @@
+package sieve
+
+import (
+	"fmt"
+	"net/textproto"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/Jinnrry/pmail/config"
+	"github.com/Jinnrry/pmail/consts"
+	"github.com/Jinnrry/pmail/db"
+	"github.com/Jinnrry/pmail/dto/parsemail"
+	"github.com/Jinnrry/pmail/models"
+	"github.com/Jinnrry/pmail/services/alias"
+	"github.com/Jinnrry/pmail/services/flag"
+	"github.com/Jinnrry/pmail/services/group"
+	"github.com/Jinnrry/pmail/services/modseq"
+	"github.com/Jinnrry/pmail/services/vacation"
+	"github.com/Jinnrry/pmail/utils/context"
+	"github.com/Jinnrry/pmail/utils/send"
+	sievelib "github.com/Jinnrry/pmail/utils/sieve"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cast"
+	"xorm.io/builder"
+)
+
+// redirectedHeader 转发时添加的头，防止两个用户的脚本互相转发形成循环
+const redirectedHeader = "X-Sieve-Redirected-By"
+
+// 发送转发与拒信，测试时替换
+var (
+	sendRedirect = send.Redirect
+	sendNotice   = send.Bounce
+)
+
+// mailboxAliases 客户端常用的文件夹名称
+var mailboxAliases = map[string]string{
+	"trash": "Deleted Messages",
+	"spam":  "Junk",
+	"sent":  "Sent Messages",
+}
+
+// placement 邮件在用户邮箱中的位置
+type placement struct {
+	groupId int
+	status  int8
+}
+
+// Deliver 邮件入库后执行用户启用中的Sieve脚本。
+// 用户没有启用脚本或者脚本无法编译时 handled 为 false，调用方继续执行普通规则；
+// delivered 表示邮件是否仍然保存在用户的邮箱中
+func Deliver(ctx *context.Context, user *models.User, email *parsemail.Email, raw []byte, envelopeFrom string, envelopeTo []string) (handled bool, delivered bool) {
+	if email == nil || email.MessageId <= 0 {
+		return false, false
+	}
+	script, err := GetActive(ctx, user.ID)
+	if err != nil || script == nil {
+		return false, false
+	}
+	compiled, err := sievelib.Compile(script.Content)
+	if err != nil {
+		log.WithContext(ctx).Errorf("Sieve script %s of %s compile error: %v", script.Name, user.Account, err)
+		return false, false
+	}
+	res, err := compiled.Run(sievelib.NewMessage(raw, envelopeFrom, envelopeTo), &sievelib.Options{
+		SubaddressSeparator: alias.SubaddressSeparator(),
+	})
+	if err != nil {
+		// 运行出错时按照 RFC 5228 执行隐式保存
+		log.WithContext(ctx).Errorf("Sieve script %s of %s runtime error: %v", script.Name, user.Account, err)
+	}
+
+	var ue models.UserEmail
+	has, err := db.Instance.Where("email_id=? and user_id=?", email.MessageId, user.ID).Get(&ue)
+	if err != nil {
+		log.WithContext(ctx).Errorf("sql Error :%+v", err)
+		return true, true
+	}
+	if !has {
+		return true, false
+	}
+
+	userCtx := &context.Context{
+		UserID:      user.ID,
+		UserAccount: user.Account,
+		UserName:    user.Name,
+		Values:      ctx.Values,
+	}
+	delivered = file(userCtx, &ue, res)
+
+	for _, r := range res.Redirects {
+		redirect(ctx, user, raw, r.Address)
+	}
+	if res.Reject != nil {
+		reject(ctx, user, email, raw, envelopeFrom, res.Reject)
+	}
+	if res.Vacation != nil {
+		reason := res.Vacation.Reason
+		if res.Vacation.Mime {
+			// :mime 时回复内容带有MIME头，这里只保留正文
+			_, body := parsemail.SplitRawMessage([]byte(reason))
+			reason = string(body)
+		}
+		vacation.Respond(ctx, user, envelopeFrom, raw, email, &vacation.Options{
+			Subject:   res.Vacation.Subject,
+			Content:   reason,
+			From:      res.Vacation.From,
+			Addresses: res.Vacation.Addresses,
+			Days:      res.Vacation.Days,
+			Handle:    res.Vacation.Handle,
+		})
+	}
+	return true, delivered
+}
+
+// file 按照 keep 与 fileinto 放置邮件，第一个位置复用已有的记录，其他位置新增记录。
+// 没有任何位置时删除记录，返回邮件是否仍然保存在邮箱中
+func file(ctx *context.Context, ue *models.UserEmail, res *sievelib.Result) bool {
+	type target struct {
+		place *placement
+		flags []string
+	}
+	var targets []target
+	seen := map[placement]bool{}
+	if res.Keep != nil {
+		// 隐式保存与 keep 不改变邮件原来的位置，比如已经被判定为垃圾邮件
+		p := &placement{groupId: ue.GroupId, status: ue.Status}
+		seen[*p] = true
+		targets = append(targets, target{place: p, flags: res.Keep.Flags})
+	}
+	for _, f := range res.FileInto {
+		p := resolveMailbox(ctx, f.Mailbox, f.Create)
+		if seen[*p] {
+			continue
+		}
+		seen[*p] = true
+		targets = append(targets, target{place: p, flags: f.Flags})
+	}
+
+	if len(targets) == 0 {
+		_, err := db.Instance.ID(ue.ID).Delete(&models.UserEmail{})
+		if err != nil {
+			log.WithContext(ctx).Errorf("sql Error :%+v", err)
+		}
+		return false
+	}
+
+	for i, t := range targets {
+		row := models.UserEmail{
+			UserID:  ue.UserID,
+			EmailID: ue.EmailID,
+			GroupId: t.place.groupId,
+			Status:  t.place.status,
+			Tag:     ue.Tag,
+		}
+		// Sieve 的标志是完整的标志集合，覆盖邮件原有的标志
+		flag.Apply(&row, t.flags)
+		var err error
+		if i == 0 {
+			_, err = db.Instance.Table(&models.UserEmail{}).Where("id=?", ue.ID).
+				Update(map[string]interface{}{"is_read": row.IsRead, "flagged": row.Flagged, "answered": row.Answered, "draft": row.Draft, "is_deleted": row.Deleted,
+					"keywords": row.Keywords})
+			if err == nil {
+				// 移到其他文件夹的邮件使用新的UID
+				_, _, err = modseq.Relocate(ctx, nil, builder.Eq{"id": ue.ID}, func(u *models.UserEmail) {
+					u.GroupId = row.GroupId
+					u.Status = row.Status
+				})
+			}
+		} else {
+			_, err = db.Instance.Insert(&row)
+		}
+		if err != nil {
+			log.WithContext(ctx).Errorf("sql Error :%+v", err)
+		}
+	}
+	return true
+}
+
+// resolveMailbox 把 fileinto 的文件夹名称转换为分组，文件夹不存在且不能创建时保存到收件箱
+func resolveMailbox(ctx *context.Context, mailbox string, create bool) *placement {
+	mailbox = strings.Trim(strings.TrimSpace(mailbox), "/")
+	if strings.EqualFold(mailbox, "INBOX") || mailbox == "" {
+		return &placement{}
+	}
+	if name, ok := mailboxAliases[strings.ToLower(mailbox)]; ok {
+		mailbox = name
+	}
+	switch models.GroupNameToCode[mailbox] {
+	case models.Sent:
+		return &placement{status: consts.EmailStatusSent}
+	case models.Drafts:
+		return &placement{status: consts.EmailStatusDrafts}
+	case models.Deleted:
+		return &placement{status: consts.EmailStatusDel}
+	case models.Junk:
+		return &placement{status: consts.EmailStatusJunk}
+	}
+
+	info, err := group.GetGroupByFullPath(ctx, mailbox)
+	if err == nil && info != nil && info.ID > 0 {
+		return &placement{groupId: info.ID}
+	}
+	if create {
+		parentId := 0
+		for _, name := range strings.Split(mailbox, "/") {
+			if name == "" || utf8.RuneCountInString(name) > 10 {
+				parentId = 0
+				break
+			}
+			info, err = group.CreateGroup(ctx, name, parentId)
+			if err != nil {
+				log.WithContext(ctx).Errorf("Sieve create mailbox %s error: %v", mailbox, err)
+				parentId = 0
+				break
+			}
+			parentId = info.ID
+		}
+		if parentId > 0 {
+			return &placement{groupId: parentId}
+		}
+	}
+	log.WithContext(ctx).Warnf("Sieve fileinto %s: mailbox not found, keep in INBOX", mailbox)
+	return &placement{}
+}
+
+// redirect 原样转发邮件，信封发件人使用用户自己的地址
+func redirect(ctx *context.Context, user *models.User, raw []byte, address string) {
+	from := user.Account + "@" + config.Instance.Domains[0]
+	headers, body := parsemail.SplitRawMessage(raw)
+	for _, h := range headers {
+		if strings.EqualFold(h.Name, redirectedHeader) && strings.EqualFold(strings.TrimSpace(h.Value), from) {
+			log.WithContext(ctx).Warnf("Sieve redirect loop detected: %s -> %s", from, address)
+			return
+		}
+	}
+	headers = append([]*parsemail.RawHeader{{Name: redirectedHeader, Value: " " + from}}, headers...)
+	err, _ := sendRedirect(ctx, parsemail.JoinRawMessage(headers, body), []string{address}, from)
+	if err != nil {
+		log.WithContext(ctx).Errorf("Sieve redirect error: %s -> %s %v", from, address, err)
+	} else {
+		log.WithContext(ctx).Infof("Sieve redirect success: %s -> %s", from, address)
+	}
+}
+
+// reject 以空信封发件人给信封发件人发送拒收通知（RFC 5429）。SPF校验失败、伪造、垃圾邮件以及
+// 自动生成的邮件不发送，避免通知发给伪造的发件人
+func reject(ctx *context.Context, user *models.User, email *parsemail.Email, raw []byte, envelopeFrom string, r *sievelib.Reject) {
+	sender := strings.Trim(strings.TrimSpace(envelopeFrom), "<>")
+	if sender == "" || vacation.Automated(sender, raw) {
+		return
+	}
+	if !email.SPFPass || email.Status == 3 || email.Status == int(consts.EmailStatusJunk) {
+		log.WithContext(ctx).Infof("Sieve reject notice to %s skipped, spf: %v status: %d", sender, email.SPFPass, email.Status)
+		return
+	}
+	notice := &parsemail.Email{
+		From:    &parsemail.User{Name: "Mail Delivery System", EmailAddress: "postmaster@" + config.Instance.Domain},
+		To:      []*parsemail.User{{EmailAddress: sender}},
+		Subject: "Rejected: " + email.Subject,
+		Text: []byte(fmt.Sprintf("Your message to %s was rejected by the recipient.\r\n\r\n%s\r\n",
+			user.Account+"@"+config.Instance.Domains[0], r.Reason)),
+		MsgID:   parsemail.GenerateMsgID(config.Instance.Domain),
+		Headers: textproto.MIMEHeader{},
+	}
+	notice.Headers.Set("Auto-Submitted", "auto-replied")
+	if err, _ := sendNotice(ctx, notice); err != nil {
+		log.WithContext(ctx).Errorf("Sieve reject notice to %s error: %v", sender, err)
+		return
+	}
+	log.WithContext(ctx).Infof("Sieve reject notice sent to %s, email id %s", sender, cast.ToString(email.MessageId))
+}
@@ -0,0 +1,221 @@
+package sieve
+
+import (
+	oerrors "errors"
+	"unicode/utf8"
+
+	"github.com/Jinnrry/pmail/db"
+	"github.com/Jinnrry/pmail/models"
+	"github.com/Jinnrry/pmail/utils/context"
+	"github.com/Jinnrry/pmail/utils/errors"
+	sievelib "github.com/Jinnrry/pmail/utils/sieve"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// MaxScriptSize 单个脚本的最大字节数
+	MaxScriptSize = 1 << 20
+	// MaxScripts 每个用户最多保存的脚本数量
+	MaxScripts = 32
+	// MaxNameLength 脚本名称的最大长度
+	MaxNameLength = 128
+)
+
+// 脚本管理的错误，ManageSieve会转换为对应的响应码
+var (
+	ErrNotFound    = oerrors.New("script not found")
+	ErrExists      = oerrors.New("script already exists")
+	ErrActive      = oerrors.New("active script cannot be deleted")
+	ErrQuota       = oerrors.New("too many scripts or script too large")
+	ErrInvalidName = oerrors.New("invalid script name")
+)
+
+// ValidName 名称不能为空，不能包含控制字符
+func ValidName(name string) bool {
+	if name == "" || !utf8.ValidString(name) || utf8.RuneCountInString(name) > MaxNameLength {
+		return false
+	}
+	for _, r := range name {
+		if r < 0x20 || r == 0x7f || (r >= 0x80 && r <= 0x9f) || r == 0x2028 || r == 0x2029 {
+			return false
+		}
+	}
+	return true
+}
+
+// List 返回用户全部脚本，按名称排序
+func List(ctx *context.Context, userId int) ([]*models.SieveScript, error) {
+	var ret []*models.SieveScript
+	err := db.Instance.Where("user_id=?", userId).Asc("name").Find(&ret)
+	if err != nil {
+		log.WithContext(ctx).Errorf("sql Error :%+v", err)
+		return nil, errors.Wrap(err)
+	}
+	return ret, nil
+}
+
+// Get 查询脚本，不存在时返回nil
+func Get(ctx *context.Context, userId int, name string) (*models.SieveScript, error) {
+	var script models.SieveScript
+	has, err := db.Instance.Where("user_id=? and name=?", userId, name).Get(&script)
+	if err != nil {
+		log.WithContext(ctx).Errorf("sql Error :%+v", err)
+		return nil, errors.Wrap(err)
+	}
+	if !has {
+		return nil, nil
+	}
+	return &script, nil
+}
+
+// GetActive 查询用户启用中的脚本，没有时返回nil
+func GetActive(ctx *context.Context, userId int) (*models.SieveScript, error) {
+	var script models.SieveScript
+	has, err := db.Instance.Where("user_id=? and active=1", userId).Get(&script)
+	if err != nil {
+		log.WithContext(ctx).Errorf("sql Error :%+v", err)
+		return nil, errors.Wrap(err)
+	}
+	if !has {
+		return nil, nil
+	}
+	return &script, nil
+}
+
+// Check 校验脚本语法
+func Check(content string) error {
+	_, err := sievelib.Compile(content)
+	return err
+}
+
+// HaveSpace 保存名为name、大小为size的脚本后是否仍然在限制以内
+func HaveSpace(ctx *context.Context, userId int, name string, size int64) (bool, error) {
+	if size > MaxScriptSize {
+		return false, nil
+	}
+	old, err := Get(ctx, userId, name)
+	if err != nil {
+		return false, err
+	}
+	if old != nil {
+		return true, nil
+	}
+	count, err := db.Instance.Where("user_id=?", userId).Count(&models.SieveScript{})
+	if err != nil {
+		log.WithContext(ctx).Errorf("sql Error :%+v", err)
+		return false, errors.Wrap(err)
+	}
+	return count < MaxScripts, nil
+}
+
+// Put 校验并保存脚本，同名脚本会被覆盖，不改变启用状态
+func Put(ctx *context.Context, userId int, name, content string) error {
+	if !ValidName(name) {
+		return ErrInvalidName
+	}
+	ok, err := HaveSpace(ctx, userId, name, int64(len(content)))
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return ErrQuota
+	}
+	if err := Check(content); err != nil {
+		return err
+	}
+	old, err := Get(ctx, userId, name)
+	if err != nil {
+		return err
+	}
+	if old != nil {
+		_, err = db.Instance.ID(old.Id).Cols("content").Update(&models.SieveScript{Content: content})
+	} else {
+		_, err = db.Instance.Insert(&models.SieveScript{UserId: userId, Name: name, Content: content})
+	}
+	if err != nil {
+		log.WithContext(ctx).Errorf("sql Error :%+v", err)
+		return errors.Wrap(err)
+	}
+	return nil
+}
+
+// SetActive 启用指定脚本并停用其他脚本，name为空时停用全部脚本
+func SetActive(ctx *context.Context, userId int, name string) error {
+	if name != "" {
+		script, err := Get(ctx, userId, name)
+		if err != nil {
+			return err
+		}
+		if script == nil {
+			return ErrNotFound
+		}
+	}
+	session := db.Instance.NewSession()
+	defer session.Close()
+	if err := session.Begin(); err != nil {
+		return errors.Wrap(err)
+	}
+	_, err := session.Table(&models.SieveScript{}).Where("user_id=? and active=1", userId).Update(map[string]interface{}{"active": 0})
+	if err == nil && name != "" {
+		_, err = session.Table(&models.SieveScript{}).Where("user_id=? and name=?", userId, name).Update(map[string]interface{}{"active": 1})
+	}
+	if err != nil {
+		session.Rollback()
+		log.WithContext(ctx).Errorf("sql Error :%+v", err)
+		return errors.Wrap(err)
+	}
+	if err := session.Commit(); err != nil {
+		return errors.Wrap(err)
+	}
+	return nil
+}
+
+// Delete 删除脚本，启用中的脚本不能删除
+func Delete(ctx *context.Context, userId int, name string) error {
+	script, err := Get(ctx, userId, name)
+	if err != nil {
+		return err
+	}
+	if script == nil {
+		return ErrNotFound
+	}
+	if script.Active == 1 {
+		return ErrActive
+	}
+	_, err = db.Instance.ID(script.Id).Delete(&models.SieveScript{})
+	if err != nil {
+		log.WithContext(ctx).Errorf("sql Error :%+v", err)
+		return errors.Wrap(err)
+	}
+	return nil
+}
+
+// Rename 重命名脚本，启用状态保持不变
+func Rename(ctx *context.Context, userId int, oldName, newName string) error {
+	if !ValidName(newName) {
+		return ErrInvalidName
+	}
+	script, err := Get(ctx, userId, oldName)
+	if err != nil {
+		return err
+	}
+	if script == nil {
+		return ErrNotFound
+	}
+	if oldName == newName {
+		return nil
+	}
+	exists, err := Get(ctx, userId, newName)
+	if err != nil {
+		return err
+	}
+	if exists != nil {
+		return ErrExists
+	}
+	_, err = db.Instance.ID(script.Id).Cols("name").Update(&models.SieveScript{Name: newName})
+	if err != nil {
+		log.WithContext(ctx).Errorf("sql Error :%+v", err)
+		return errors.Wrap(err)
+	}
+	return nil
+}
@@ -0,0 +1,299 @@
+package sieve
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/Jinnrry/pmail/consts"
+	"github.com/Jinnrry/pmail/db"
+	"github.com/Jinnrry/pmail/db/dbtest"
+	"github.com/Jinnrry/pmail/dto"
+	"github.com/Jinnrry/pmail/dto/parsemail"
+	"github.com/Jinnrry/pmail/models"
+	"github.com/Jinnrry/pmail/utils/context"
+	sievelib "github.com/Jinnrry/pmail/utils/sieve"
+	"github.com/spf13/cast"
+)
+
+const testRaw = "From: Bob <bob@remote.net>\r\n" +
+	"To: alice@example.com\r\n" +
+	"Subject: weekly report\r\n" +
+	"List-Id: <dev.remote.net>\r\n" +
+	"\r\n" +
+	"numbers are up\r\n"
+
+type sent struct {
+	redirects [][]byte
+	notices   []*parsemail.Email
+}
+
+func initTestDB(t *testing.T) (*models.User, *sent) {
+	s := &sent{}
+	oldRedirect, oldNotice := sendRedirect, sendNotice
+	sendRedirect = func(ctx *context.Context, raw []byte, to []string, from string) (error, map[string]error) {
+		s.redirects = append(s.redirects, raw)
+		return nil, nil
+	}
+	sendNotice = func(ctx *context.Context, e *parsemail.Email) (error, map[string]error) {
+		s.notices = append(s.notices, e)
+		return nil, nil
+	}
+	t.Cleanup(func() { sendRedirect, sendNotice = oldRedirect, oldNotice })
+	dbtest.Init(t)
+	user := dbtest.User(t, "alice", "Alice")
+	return user, s
+}
+
+// receive 模拟收信入库，返回邮件与其在用户邮箱中的记录
+func receive(t *testing.T, user *models.User) *parsemail.Email {
+	e := &models.Email{Subject: "weekly report", FromAddress: "bob@remote.net"}
+	if _, err := db.Instance.Insert(e); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Instance.Insert(&models.UserEmail{UserID: user.ID, EmailID: e.Id}); err != nil {
+		t.Fatal(err)
+	}
+	return &parsemail.Email{
+		MessageId: int64(e.Id),
+		From:      &parsemail.User{EmailAddress: "bob@remote.net"},
+		To:        []*parsemail.User{{EmailAddress: "alice@example.com"}},
+		Subject:   "weekly report",
+	}
+}
+
+func userEmails(t *testing.T, user *models.User, email *parsemail.Email) []*models.UserEmail {
+	var ret []*models.UserEmail
+	err := db.Instance.Where("user_id=? and email_id=?", user.ID, email.MessageId).Asc("id").Find(&ret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ret
+}
+
+func TestScripts(t *testing.T) {
+	user, _ := initTestDB(t)
+	ctx := &context.Context{}
+
+	if err := Put(ctx, user.ID, "main", `fileinto "x";`); err == nil {
+		t.Errorf("invalid script saved")
+	}
+	if err := Put(ctx, user.ID, "bad\nname", `keep;`); err != ErrInvalidName {
+		t.Errorf("Put() invalid name error = %v", err)
+	}
+	if err := Put(ctx, user.ID, "main", `keep;`); err != nil {
+		t.Fatal(err)
+	}
+	if err := Put(ctx, user.ID, "other", `discard;`); err != nil {
+		t.Fatal(err)
+	}
+	if err := SetActive(ctx, user.ID, "main"); err != nil {
+		t.Fatal(err)
+	}
+	if err := SetActive(ctx, user.ID, "other"); err != nil {
+		t.Fatal(err)
+	}
+	active, _ := GetActive(ctx, user.ID)
+	if active == nil || active.Name != "other" {
+		t.Fatalf("active = %+v", active)
+	}
+	if err := Delete(ctx, user.ID, "other"); err != ErrActive {
+		t.Errorf("Delete() active error = %v", err)
+	}
+	if err := Rename(ctx, user.ID, "other", "main"); err != ErrExists {
+		t.Errorf("Rename() exists error = %v", err)
+	}
+	if err := Rename(ctx, user.ID, "other", "renamed"); err != nil {
+		t.Fatal(err)
+	}
+	active, _ = GetActive(ctx, user.ID)
+	if active == nil || active.Name != "renamed" {
+		t.Errorf("rename should keep active, got %+v", active)
+	}
+	if err := SetActive(ctx, user.ID, ""); err != nil {
+		t.Fatal(err)
+	}
+	if err := Delete(ctx, user.ID, "renamed"); err != nil {
+		t.Fatal(err)
+	}
+	list, _ := List(ctx, user.ID)
+	if len(list) != 1 || list[0].Name != "main" {
+		t.Errorf("List() = %+v", list)
+	}
+	if ok, _ := HaveSpace(ctx, user.ID, "big", MaxScriptSize+1); ok {
+		t.Errorf("HaveSpace() accepted oversize script")
+	}
+}
+
+func activate(t *testing.T, user *models.User, script string) {
+	t.Helper()
+	ctx := &context.Context{}
+	if err := Put(ctx, user.ID, "test", script); err != nil {
+		t.Fatal(err)
+	}
+	if err := SetActive(ctx, user.ID, "test"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDeliver(t *testing.T) {
+	user, s := initTestDB(t)
+	ctx := &context.Context{}
+
+	email := receive(t, user)
+	if handled, _ := Deliver(ctx, user, email, []byte(testRaw), "bob@remote.net", []string{"alice@example.com"}); handled {
+		t.Errorf("Deliver() handled without active script")
+	}
+
+	activate(t, user, `require ["fileinto", "imap4flags", "mailbox", "copy"];
+if header :contains "list-id" "dev.remote.net" {
+	fileinto :copy "Junk";
+	fileinto :create :flags "\\Seen" "Lists/dev";
+	redirect :copy "archive@remote.net";
+}`)
+	handled, delivered := Deliver(ctx, user, email, []byte(testRaw), "bob@remote.net", []string{"alice@example.com"})
+	if !handled || !delivered {
+		t.Fatalf("Deliver() = %v %v", handled, delivered)
+	}
+	rows := userEmails(t, user, email)
+	// fileinto 取消了隐式保存，第一个位置复用原记录
+	if len(rows) != 2 {
+		t.Fatalf("rows = %d", len(rows))
+	}
+	if rows[0].Status != consts.EmailStatusJunk || rows[0].IsRead != 0 {
+		t.Errorf("junk copy wrong: %+v", rows[0])
+	}
+	var dev models.Group
+	db.Instance.Table("group").Where("name=? and user_id=?", "dev", user.ID).Get(&dev)
+	if dev.ID == 0 || rows[1].GroupId != dev.ID || rows[1].IsRead != 1 {
+		t.Errorf("fileinto :create wrong: %+v %+v", dev, rows[1])
+	}
+	if len(s.redirects) != 1 || !strings.HasPrefix(string(s.redirects[0]), redirectedHeader+": alice@example.com\r\n") {
+		t.Errorf("redirects = %q", s.redirects)
+	}
+
+	// 已经被自己转发过的邮件不再转发
+	redirected := s.redirects[0]
+	email = receive(t, user)
+	Deliver(ctx, user, email, redirected, "bob@remote.net", []string{"alice@example.com"})
+	if len(s.redirects) != 1 {
+		t.Errorf("redirect loop not detected")
+	}
+
+	activate(t, user, `require "reject"; reject "not interested";`)
+	direct := strings.Replace(testRaw, "List-Id: <dev.remote.net>\r\n", "", 1)
+	email = receive(t, user)
+	email.SPFPass = true
+	_, delivered = Deliver(ctx, user, email, []byte(direct), "bob@remote.net", []string{"alice@example.com"})
+	if delivered || len(userEmails(t, user, email)) != 0 {
+		t.Errorf("rejected email still delivered")
+	}
+	if len(s.notices) != 1 || s.notices[0].To[0].EmailAddress != "bob@remote.net" || !strings.Contains(string(s.notices[0].Text), "not interested") ||
+		s.notices[0].From.EmailAddress != "postmaster@example.com" {
+		t.Errorf("notices = %+v", s.notices)
+	}
+
+	// SPF校验失败、垃圾邮件、群发或自动生成的邮件以及系统发件人不发送拒收通知
+	tests := []struct {
+		name   string
+		spf    bool
+		status int
+		raw    string
+		from   string
+	}{
+		{"spf fail", false, 0, direct, "bob@remote.net"},
+		{"forged", true, 3, direct, "bob@remote.net"},
+		{"junk", true, int(consts.EmailStatusJunk), direct, "bob@remote.net"},
+		{"list", true, 0, testRaw, "bob@remote.net"},
+		{"auto", true, 0, "Auto-Submitted: auto-replied\r\n" + direct, "bob@remote.net"},
+		{"daemon", true, 0, direct, "MAILER-DAEMON@remote.net"},
+	}
+	for _, tt := range tests {
+		email = receive(t, user)
+		email.SPFPass, email.Status = tt.spf, tt.status
+		Deliver(ctx, user, email, []byte(tt.raw), tt.from, []string{"alice@example.com"})
+		if len(s.notices) != 1 {
+			t.Fatalf("%s: reject notice sent", tt.name)
+		}
+	}
+}
+
+func TestConvertRules(t *testing.T) {
+	user, _ := initTestDB(t)
+	ctx := &context.Context{UserID: user.ID}
+
+	rules := []*dto.Rule{
+		{Name: "read", Action: dto.READ, Sort: 1, Rules: []*dto.Value{{Field: "Subject", Type: "contains", Rule: "report"}}},
+		{Name: "junk", Action: dto.MOVE, Params: "2000000004", Sort: 2, Rules: []*dto.Value{
+			{Field: "From", Type: "equal", Rule: "bob@remote.net"},
+			{Field: "Content", Type: "regex", Rule: `num\w+`},
+		}},
+		{Name: "forward", Action: dto.FORWARD, Params: "copy@remote.net", Rules: []*dto.Value{{Op: "any", Children: []*dto.Value{
+			{Field: "To", Type: "equal", Rule: "carol@example.com"},
+			{Op: "not", Children: []*dto.Value{{Field: "Subject", Type: "starts_with", Rule: "WEEKLY", IgnoreCase: true}}},
+			{Field: "Size", Type: "gt", Rule: "1M"},
+		}}}},
+		{Name: "pdf", Action: dto.DELETE, Rules: []*dto.Value{{Field: "HasAttachment", Type: "equal", Rule: "true"}}},
+	}
+	script := ConvertRules(ctx, rules)
+	compiled, err := sievelib.Compile(script)
+	if err != nil {
+		t.Fatalf("converted script does not compile: %v\n%s", err, script)
+	}
+	res, err := compiled.Run(sievelib.NewMessage([]byte(testRaw), "bob@remote.net", []string{"alice@example.com"}), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.FileInto) != 1 || res.FileInto[0].Mailbox != "Junk" || len(res.FileInto[0].Flags) != 0 {
+		t.Errorf("FileInto = %+v", res.FileInto)
+	}
+	if res.Keep != nil || len(res.Redirects) != 0 {
+		t.Errorf("result = %+v", res)
+	}
+	if !strings.Contains(script, "# pdf: conditions cannot be expressed in Sieve, skipped") {
+		t.Errorf("rule without Sieve equivalent not skipped:\n%s", script)
+	}
+	if strings.Index(script, "# junk") > strings.Index(script, "# read") {
+		t.Errorf("rules not ordered by sort:\n%s", script)
+	}
+}
+
+func TestConvertRuleActions(t *testing.T) {
+	user, _ := initTestDB(t)
+	ctx := &context.Context{UserID: user.ID}
+	tpl := &models.ReplyTemplate{UserId: user.ID, Name: "thanks", Content: "Thanks, I got it."}
+	if _, err := db.Instance.Insert(tpl); err != nil {
+		t.Fatal(err)
+	}
+
+	rules := []*dto.Rule{
+		{Name: "report", UserId: user.ID, Sort: 2, Stop: true, Rules: []*dto.Value{{Field: "Subject", Type: "contains", Rule: "report"}},
+			Actions: []*dto.RuleAction{
+				{Action: dto.FLAG, Params: `\Flagged $work`},
+				{Action: dto.FORWARD, Params: "a@remote.net, b@remote.net"},
+				{Action: dto.REPLY, Params: cast.ToString(tpl.Id)},
+				{Action: dto.WEBHOOK, Params: "https://hook.remote.net/mail"},
+			}},
+		{Name: "never", UserId: user.ID, Sort: 1, Action: dto.DELETE, Rules: []*dto.Value{{Field: "Subject", Type: "contains", Rule: "report"}}},
+	}
+	script := ConvertRules(ctx, rules)
+	compiled, err := sievelib.Compile(script)
+	if err != nil {
+		t.Fatalf("converted script does not compile: %v\n%s", err, script)
+	}
+	res, err := compiled.Run(sievelib.NewMessage([]byte(testRaw), "bob@remote.net", []string{"alice@example.com"}), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Keep == nil || len(res.Keep.Flags) != 2 || len(res.FileInto) != 0 {
+		t.Errorf("stop not respected or flags missing: %+v %+v", res.Keep, res.FileInto)
+	}
+	if len(res.Redirects) != 2 || res.Redirects[1].Address != "b@remote.net" {
+		t.Errorf("Redirects = %+v", res.Redirects)
+	}
+	if res.Vacation == nil || res.Vacation.Reason != tpl.Content || res.Vacation.Handle != "rule-template-1" {
+		t.Errorf("Vacation = %+v", res.Vacation)
+	}
+	if !strings.Contains(script, "# webhook https://hook.remote.net/mail cannot be expressed in Sieve") {
+		t.Errorf("webhook comment missing:\n%s", script)
+	}
+}
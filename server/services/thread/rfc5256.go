@@ -0,0 +1,283 @@
+package thread
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+// Message 参与 IMAP THREAD 计算的一封邮件，Num 为序号或UID
+type Message struct {
+	Num        uint32
+	MsgID      string
+	InReplyTo  string
+	References []string
+	Subject    string
+	Date       time.Time
+}
+
+// Node 会话树中的节点，Message 为空时是没有对应邮件的占位节点
+type Node struct {
+	Message  *Message
+	Children []*Node
+
+	parent *Node
+}
+
+// date 节点的排序时间，占位节点使用第一个子节点的时间
+func (n *Node) date() (time.Time, uint32) {
+	if n.Message != nil {
+		return n.Message.Date, n.Message.Num
+	}
+	if len(n.Children) > 0 {
+		return n.Children[0].date()
+	}
+	return time.Time{}, 0
+}
+
+// subject 节点的主题，占位节点使用第一个子节点的主题
+func (n *Node) subject() string {
+	if n.Message != nil {
+		return n.Message.Subject
+	}
+	if len(n.Children) > 0 {
+		return n.Children[0].subject()
+	}
+	return ""
+}
+
+func (n *Node) hasDescendant(other *Node) bool {
+	for _, child := range n.Children {
+		if child == other || child.hasDescendant(other) {
+			return true
+		}
+	}
+	return false
+}
+
+func (n *Node) addChild(child *Node) {
+	if child.parent != nil {
+		child.parent.removeChild(child)
+	}
+	child.parent = n
+	n.Children = append(n.Children, child)
+}
+
+func (n *Node) removeChild(child *Node) {
+	for i, c := range n.Children {
+		if c == child {
+			n.Children = append(n.Children[:i], n.Children[i+1:]...)
+			break
+		}
+	}
+	child.parent = nil
+}
+
+// sortNodes 按时间排序，时间相同时按序号排序
+func sortNodes(nodes []*Node) {
+	sort.SliceStable(nodes, func(i, j int) bool {
+		di, ni := nodes[i].date()
+		dj, nj := nodes[j].date()
+		if !di.Equal(dj) {
+			return di.Before(dj)
+		}
+		return ni < nj
+	})
+}
+
+func sortTree(nodes []*Node) {
+	for _, n := range nodes {
+		sortTree(n.Children)
+	}
+	sortNodes(nodes)
+}
+
+// OrderedSubject 实现 RFC 5256 的 ORDEREDSUBJECT 算法：按基础主题分组，
+// 每组中最早的邮件作为父节点，其余邮件按时间排列作为它的子节点
+func OrderedSubject(msgs []*Message) []*Node {
+	groups := map[string]*Node{}
+	var roots []*Node
+	sorted := append([]*Message{}, msgs...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		if !sorted[i].Date.Equal(sorted[j].Date) {
+			return sorted[i].Date.Before(sorted[j].Date)
+		}
+		return sorted[i].Num < sorted[j].Num
+	})
+	for _, m := range sorted {
+		base, _ := BaseSubject(m.Subject)
+		node := &Node{Message: m}
+		if root, ok := groups[base]; ok {
+			root.addChild(node)
+			continue
+		}
+		groups[base] = node
+		roots = append(roots, node)
+	}
+	return roots
+}
+
+// References 实现 RFC 5256 的 REFERENCES 算法（即 JWZ 算法）
+func References(msgs []*Message) []*Node {
+	idTable := map[string]*Node{}
+
+	// 1. 按引用关系建立父子关系
+	for _, m := range msgs {
+		id := m.MsgID
+		if id == "" || (idTable[id] != nil && idTable[id].Message != nil) {
+			// 没有或者重复的Message-ID，生成一个唯一id
+			id = fmt.Sprintf("<dup-%d-%p>", m.Num, m)
+		}
+		node := idTable[id]
+		if node == nil {
+			node = &Node{}
+			idTable[id] = node
+		}
+		node.Message = m
+
+		refs := m.References
+		if len(refs) == 0 && m.InReplyTo != "" {
+			refs = []string{m.InReplyTo}
+		}
+		var prev *Node
+		for _, ref := range refs {
+			cur := idTable[ref]
+			if cur == nil {
+				cur = &Node{}
+				idTable[ref] = cur
+			}
+			// 已有父节点时不修改，避免产生环
+			if prev != nil && cur.parent == nil && cur != prev && !cur.hasDescendant(prev) {
+				prev.addChild(cur)
+			}
+			prev = cur
+		}
+		// 最后一个引用是这封邮件的父节点，覆盖之前建立的关系
+		if node.parent != nil {
+			node.parent.removeChild(node)
+		}
+		if prev != nil && prev != node && !node.hasDescendant(prev) {
+			prev.addChild(node)
+		}
+	}
+
+	// 2. 没有父节点的节点组成根集合
+	var roots []*Node
+	seen := map[*Node]bool{}
+	for _, node := range idTable {
+		if node.parent == nil && !seen[node] {
+			seen[node] = true
+			roots = append(roots, node)
+		}
+	}
+	// map 的遍历顺序不固定，先按邮件排序保证结果稳定
+	sortTree(roots)
+
+	// 3. 删除占位节点
+	roots = prune(roots, true)
+
+	// 4. 排序根集合
+	sortTree(roots)
+
+	// 5. 按基础主题合并根集合
+	roots = groupBySubject(roots)
+
+	// 6. 排序所有子节点
+	sortTree(roots)
+	return roots
+}
+
+// prune 删除没有子节点的占位节点，把占位节点的子节点提升到上一层，
+// 根集合中有多个子节点的占位节点保留
+func prune(nodes []*Node, isRoot bool) []*Node {
+	var ret []*Node
+	for _, n := range nodes {
+		n.Children = prune(n.Children, false)
+		if n.Message != nil {
+			ret = append(ret, n)
+			continue
+		}
+		if len(n.Children) == 0 {
+			continue
+		}
+		if isRoot && len(n.Children) > 1 {
+			ret = append(ret, n)
+			continue
+		}
+		children := n.Children
+		n.Children = nil
+		for _, child := range children {
+			child.parent = n.parent
+			ret = append(ret, child)
+		}
+	}
+	for _, n := range ret {
+		for _, child := range n.Children {
+			child.parent = n
+		}
+	}
+	return ret
+}
+
+func groupBySubject(roots []*Node) []*Node {
+	type entry struct {
+		node    *Node
+		isReply bool
+	}
+	table := map[string]*entry{}
+	for _, root := range roots {
+		base, isReply := BaseSubject(root.subject())
+		if base == "" {
+			continue
+		}
+		old, ok := table[base]
+		// 占位节点以及不是回复的邮件优先
+		if !ok || (old.node.Message != nil && root.Message == nil) ||
+			(old.isReply && !isReply && (old.node.Message == nil) == (root.Message == nil)) {
+			table[base] = &entry{node: root, isReply: isReply}
+		}
+	}
+
+	var ret []*Node
+	for _, root := range roots {
+		if root.parent != nil {
+			// 已经合并到其他节点下
+			continue
+		}
+		base, isReply := BaseSubject(root.subject())
+		e, ok := table[base]
+		if base == "" || !ok || e.node == root {
+			ret = append(ret, root)
+			continue
+		}
+		other := e.node
+		switch {
+		case other.Message == nil && root.Message == nil:
+			for _, child := range append([]*Node{}, root.Children...) {
+				other.addChild(child)
+			}
+		case other.Message == nil:
+			other.addChild(root)
+		case !e.isReply && isReply:
+			other.addChild(root)
+		default:
+			// 都是回复或者都不是回复，新建占位节点作为共同的父节点
+			dummy := &Node{}
+			replaced := false
+			for i, n := range ret {
+				if n == other {
+					ret[i] = dummy
+					replaced = true
+				}
+			}
+			if !replaced {
+				ret = append(ret, dummy)
+			}
+			dummy.addChild(other)
+			dummy.addChild(root)
+			e.node = dummy
+			e.isReply = false
+		}
+	}
+	return ret
+}
@@ -0,0 +1,67 @@
+package thread
+
+import (
+	"regexp"
+	"strings"
+	"unicode/utf8"
+)
+
+// maxBaseSubjectLength base_subject 列的长度
+const maxBaseSubjectLength = 255
+
+var (
+	// subj-trailer，比如 "(fwd)"
+	subjectTrailer = regexp.MustCompile(`(?i)(\s*\(fwd\))+\s*$`)
+	// subj-leader，比如 "[list] Re:"、"Fwd[2]:"，也识别中文客户端的"回复："、"转发："
+	subjectLeader = regexp.MustCompile(`(?i)^(\[[^\[\]]*\]\s*)*(re|fwd?|回复|答复|转发)\s*(\[[^\[\]]*\])?\s*[:：]\s*`)
+	subjectBlob   = regexp.MustCompile(`^\[[^\[\]]*\]\s*`)
+	// subj-fwd，比如 "[Fwd: xxx]"
+	subjectFwd = regexp.MustCompile(`(?i)^\[fwd:(.*)\]$`)
+)
+
+// BaseSubject 按 RFC 5256 计算基础主题，去掉回复、转发前缀与列表标签，返回小写结果。
+// isReply 表示原主题带有回复或转发标记
+func BaseSubject(subject string) (base string, isReply bool) {
+	base = strings.Join(strings.Fields(subject), " ")
+	for {
+		trimmed := subjectTrailer.ReplaceAllString(base, "")
+		if trimmed != base {
+			isReply = true
+			base = trimmed
+		}
+
+		for {
+			if loc := subjectLeader.FindStringIndex(base); loc != nil {
+				isReply = true
+				base = base[loc[1]:]
+				continue
+			}
+			// 只有去掉标签后还有内容时才去掉标签，避免主题整个是"[xxx]"时变成空字符串
+			if loc := subjectBlob.FindStringIndex(base); loc != nil && loc[1] < len(base) {
+				base = base[loc[1]:]
+				continue
+			}
+			break
+		}
+
+		if m := subjectFwd.FindStringSubmatch(base); m != nil {
+			isReply = true
+			base = strings.TrimSpace(m[1])
+			continue
+		}
+		break
+	}
+	return strings.ToLower(base), isReply
+}
+
+// truncate 截断到数据库字段的长度，不截断多字节字符
+func truncate(s string, max int) string {
+	if len(s) <= max {
+		return s
+	}
+	s = s[:max]
+	for !utf8.ValidString(s) {
+		s = s[:len(s)-1]
+	}
+	return s
+}
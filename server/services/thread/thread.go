@@ -0,0 +1,164 @@
+// Package thread 计算邮件所属的会话。邮件入库时按 Message-ID、In-Reply-To、References
+// 找到相关邮件并加入同一个会话，会话id保存在 email.thread_id 中，等于会话中第一封邮件的id。
+// IMAP THREAD 命令使用的 RFC 5256 算法见 rfc5256.go
+package thread
+
+import (
+	"encoding/json"
+	"strings"
+	"time"
+
+	"github.com/Jinnrry/pmail/db"
+	"github.com/Jinnrry/pmail/dto/response"
+	"github.com/Jinnrry/pmail/models"
+	"github.com/Jinnrry/pmail/utils/context"
+	log "github.com/sirupsen/logrus"
+	"xorm.io/builder"
+)
+
+// subjectWindow 没有引用头的回复只和这段时间内的邮件按主题归并
+const subjectWindow = 30 * 24 * time.Hour
+
+// Assign 在邮件入库后计算并保存邮件所属的会话。
+// 引用头中的邮件、同一个Message-ID的其他副本、以及回复这封邮件的已有邮件属于同一个会话，
+// 命中多个会话时合并为id最小的会话；没有引用关系的回复按主题与参与人归并，否则新建会话
+func Assign(ctx *context.Context, email *models.Email) {
+	if email == nil || email.Id == 0 {
+		return
+	}
+	base, isReply := BaseSubject(email.Subject)
+	email.BaseSubject = truncate(base, maxBaseSubjectLength)
+	email.ThreadId = email.Id
+
+	threads := related(ctx, email)
+	if len(threads) > 0 {
+		email.ThreadId = threads[0]
+		if len(threads) > 1 {
+			_, err := db.Instance.Table(&models.Email{}).In("thread_id", threads[1:]).
+				Update(map[string]any{"thread_id": threads[0]})
+			if err != nil {
+				log.WithContext(ctx).Errorf("Thread Merge Error:%v", err)
+			}
+		}
+	} else if isReply && email.BaseSubject != "" {
+		if id := bySubject(ctx, email); id > 0 {
+			email.ThreadId = id
+		}
+	}
+
+	_, err := db.Instance.ID(email.Id).Cols("thread_id", "base_subject").Update(email)
+	if err != nil {
+		log.WithContext(ctx).Errorf("Thread Assign Error:%v", err)
+	}
+}
+
+// related 返回与邮件有引用关系的已有会话，从小到大排列
+func related(ctx *context.Context, email *models.Email) []int {
+	ids := strings.Fields(email.References)
+	if email.InReplyTo != "" {
+		ids = append(ids, email.InReplyTo)
+	}
+	if email.MsgID != "" {
+		ids = append(ids, email.MsgID)
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+	cond := builder.In("msg_id", ids)
+	if email.MsgID != "" {
+		cond = cond.Or(builder.Eq{"in_reply_to": email.MsgID})
+	}
+
+	var threads []int
+	err := db.Instance.Table(&models.Email{}).Select("distinct thread_id").
+		Where(builder.Neq{"id": email.Id}.And(builder.Gt{"thread_id": 0}, cond)).
+		Asc("thread_id").Find(&threads)
+	if err != nil {
+		log.WithContext(ctx).Errorf("SQL Error:%v", err)
+	}
+	return threads
+}
+
+// bySubject 查找主题相同、发件人是这封邮件参与人的最近一封邮件的会话
+func bySubject(ctx *context.Context, email *models.Email) int {
+	participants := Participants(email)
+	if len(participants) == 0 {
+		return 0
+	}
+	var candidate models.Email
+	has, err := db.Instance.Cols("id", "thread_id").
+		Where(builder.Eq{"base_subject": email.BaseSubject}.And(
+			builder.Neq{"id": email.Id},
+			builder.Gt{"thread_id": 0},
+			builder.Gte{"create_time": time.Now().Add(-subjectWindow)},
+			builder.In("lower(from_address)", participants),
+		)).Desc("id").Get(&candidate)
+	if err != nil {
+		log.WithContext(ctx).Errorf("SQL Error:%v", err)
+	}
+	if !has {
+		return 0
+	}
+	return candidate.ThreadId
+}
+
+// Participants 返回邮件的发件人、收件人与抄送人地址，小写
+func Participants(email *models.Email) []string {
+	var users []struct{ EmailAddress string }
+	var cc []struct{ EmailAddress string }
+	_ = json.Unmarshal([]byte(email.To), &users)
+	_ = json.Unmarshal([]byte(email.Cc), &cc)
+	users = append(users, cc...)
+	users = append(users, struct{ EmailAddress string }{email.FromAddress})
+
+	var ret []string
+	seen := map[string]bool{}
+	for _, u := range users {
+		address := strings.ToLower(strings.TrimSpace(u.EmailAddress))
+		if address != "" && !seen[address] {
+			seen[address] = true
+			ret = append(ret, address)
+		}
+	}
+	return ret
+}
+
+// Of 返回用户能看到的邮件所属的会话，邮件不存在时返回0
+func Of(ctx *context.Context, emailId int) int {
+	var email models.Email
+	has, err := db.Instance.Table("email").Alias("e").Select("e.id,e.thread_id").
+		Join("INNER", []string{"user_email", "ue"}, "e.id=ue.email_id").
+		Where("ue.user_id=? and e.id=?", ctx.UserID, emailId).Get(&email)
+	if err != nil {
+		log.WithContext(ctx).Errorf("SQL Error:%v", err)
+	}
+	if !has {
+		return 0
+	}
+	if email.ThreadId == 0 {
+		return email.Id
+	}
+	return email.ThreadId
+}
+
+// List 返回会话中用户能看到的全部邮件，包括各个文件夹中的邮件，按时间先后排列
+func List(ctx *context.Context, threadId int) []*response.EmailResponseData {
+	var rows []*response.EmailResponseData
+	err := db.Instance.SQL(db.WithContext(ctx, `select e.*,ue.is_read,ue.id as ue_id,ue.status as ue_status,ue.group_id from email e
+		inner join user_email ue on e.id=ue.email_id where ue.user_id=? and (e.thread_id=? or e.id=?) order by e.id asc,ue.id asc`),
+		ctx.UserID, threadId, threadId).Find(&rows)
+	if err != nil {
+		log.WithContext(ctx).Errorf("SQL Error:%v", err)
+	}
+
+	// 同一封邮件复制到了多个文件夹时只返回一次
+	ret := []*response.EmailResponseData{}
+	seen := map[int]bool{}
+	for _, row := range rows {
+		if !seen[row.Id] {
+			seen[row.Id] = true
+			ret = append(ret, row)
+		}
+	}
+	return ret
+}
@@ -0,0 +1,177 @@
+package thread
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Jinnrry/pmail/db"
+	"github.com/Jinnrry/pmail/db/dbtest"
+	"github.com/Jinnrry/pmail/models"
+	"github.com/Jinnrry/pmail/utils/context"
+)
+
+func TestBaseSubject(t *testing.T) {
+	tests := []struct {
+		subject string
+		base    string
+		isReply bool
+	}{
+		{"Hello  World", "hello world", false},
+		{"Re: Hello", "hello", true},
+		{"RE: Fwd: [dev] Re[2]: Hello (fwd)", "hello", true},
+		{"[dev] Hello", "hello", false},
+		{"[Fwd: Hello]", "hello", true},
+		{"回复：你好", "你好", true},
+		{"[dev]", "[dev]", false},
+		{"Remember", "remember", false},
+	}
+	for _, tt := range tests {
+		base, isReply := BaseSubject(tt.subject)
+		if base != tt.base || isReply != tt.isReply {
+			t.Errorf("BaseSubject(%q) = %q %v", tt.subject, base, isReply)
+		}
+	}
+}
+
+// format 把会话树格式化为 THREAD 响应的形式
+func format(nodes []*Node) string {
+	var b strings.Builder
+	for _, n := range nodes {
+		b.WriteString("(")
+		var nums []string
+		for n.Message != nil {
+			nums = append(nums, fmt.Sprint(n.Message.Num))
+			if len(n.Children) != 1 {
+				break
+			}
+			n = n.Children[0]
+		}
+		b.WriteString(strings.Join(nums, " "))
+		if len(nums) > 0 && len(n.Children) > 0 {
+			b.WriteString(" ")
+		}
+		b.WriteString(format(n.Children))
+		b.WriteString(")")
+	}
+	return b.String()
+}
+
+func testMessages() []*Message {
+	start := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	msg := func(num uint32, id, subject string, refs ...string) *Message {
+		return &Message{Num: num, MsgID: id, Subject: subject, References: refs, Date: start.Add(time.Duration(num) * time.Hour)}
+	}
+	return []*Message{
+		msg(1, "a", "Hello"),
+		msg(2, "b", "Re: Hello", "a"),
+		msg(3, "c", "Re: Hello", "a", "b"),
+		// 父邮件不在结果中
+		msg(4, "d", "Re: Hello", "a", "x"),
+		msg(5, "e", "Report"),
+		// 没有引用头的回复按主题归并
+		msg(6, "f", "Re: Report"),
+		msg(7, "g", "Other"),
+		// 两封主题相同但都不是回复的邮件
+		msg(8, "h", "News"),
+		msg(9, "i", "News"),
+	}
+}
+
+func TestReferences(t *testing.T) {
+	got := format(References(testMessages()))
+	want := "(1 (2 3)(4))(5 6)(7)((8)(9))"
+	if got != want {
+		t.Errorf("References = %s, want %s", got, want)
+	}
+}
+
+func TestReferencesLoop(t *testing.T) {
+	msgs := []*Message{
+		{Num: 1, MsgID: "a", Subject: "x", References: []string{"b"}},
+		{Num: 2, MsgID: "b", Subject: "y", References: []string{"a"}},
+	}
+	if got := format(References(msgs)); got != "(1 2)" && got != "(2 1)" {
+		t.Errorf("References = %s", got)
+	}
+}
+
+func TestOrderedSubject(t *testing.T) {
+	got := format(OrderedSubject(testMessages()))
+	want := "(1 (2)(3)(4))(5 6)(7)(8 9)"
+	if got != want {
+		t.Errorf("OrderedSubject = %s, want %s", got, want)
+	}
+}
+
+func deliver(t *testing.T, e *models.Email) *models.Email {
+	if _, err := db.Instance.Insert(e); err != nil {
+		t.Fatal(err)
+	}
+	Assign(&context.Context{}, e)
+	return e
+}
+
+func TestAssign(t *testing.T) {
+	dbtest.Init(t)
+	alice := `[{"EmailAddress":"alice@example.com"}]`
+
+	first := deliver(t, &models.Email{Subject: "Plan", FromAddress: "bob@remote.net", To: alice, MsgID: "1@remote.net"})
+	if first.ThreadId != first.Id {
+		t.Errorf("new thread = %d", first.ThreadId)
+	}
+	reply := deliver(t, &models.Email{Subject: "Re: Plan", FromAddress: "alice@example.com", MsgID: "2@example.com",
+		InReplyTo: "1@remote.net", References: "1@remote.net"})
+	// 子邮件先于父邮件到达
+	child := deliver(t, &models.Email{Subject: "Re: Other", FromAddress: "carol@remote.net", MsgID: "4@remote.net", InReplyTo: "3@remote.net"})
+	parent := deliver(t, &models.Email{Subject: "Other", FromAddress: "dave@remote.net", MsgID: "3@remote.net"})
+	if reply.ThreadId != first.Id || parent.ThreadId != child.Id {
+		t.Errorf("reply thread = %d, parent thread = %d", reply.ThreadId, parent.ThreadId)
+	}
+
+	// 同时引用两个会话，合并为一个
+	merged := deliver(t, &models.Email{Subject: "Re: Plan", FromAddress: "bob@remote.net", MsgID: "5@remote.net",
+		References: "2@example.com 3@remote.net"})
+	if merged.ThreadId != first.Id {
+		t.Errorf("merged thread = %d", merged.ThreadId)
+	}
+	if count, _ := db.Instance.Where("thread_id=?", first.Id).Count(&models.Email{}); count != 5 {
+		t.Errorf("thread size = %d", count)
+	}
+
+	// 没有引用头的回复，只和参与人相同的邮件归并
+	bySubject := deliver(t, &models.Email{Subject: "RE: plan", FromAddress: "alice@example.com", To: `[{"EmailAddress":"bob@remote.net"}]`})
+	stranger := deliver(t, &models.Email{Subject: "Re: Plan", FromAddress: "eve@remote.net", To: `[{"EmailAddress":"frank@remote.net"}]`})
+	notReply := deliver(t, &models.Email{Subject: "Plan", FromAddress: "bob@remote.net", To: alice})
+	if bySubject.ThreadId != first.Id || stranger.ThreadId != stranger.Id || notReply.ThreadId != notReply.Id {
+		t.Errorf("subject threads = %d %d %d", bySubject.ThreadId, stranger.ThreadId, notReply.ThreadId)
+	}
+
+	var stored models.Email
+	db.Instance.ID(bySubject.Id).Get(&stored)
+	if stored.ThreadId != first.Id || stored.BaseSubject != "plan" {
+		t.Errorf("stored = %d %q", stored.ThreadId, stored.BaseSubject)
+	}
+}
+
+func TestList(t *testing.T) {
+	dbtest.Init(t)
+	ctx := &context.Context{UserID: 1}
+	first := deliver(t, &models.Email{Subject: "Plan", MsgID: "1@remote.net"})
+	reply := deliver(t, &models.Email{Subject: "Re: Plan", MsgID: "2@example.com", InReplyTo: "1@remote.net"})
+	other := deliver(t, &models.Email{Subject: "Plan", MsgID: "3@remote.net", InReplyTo: "1@remote.net"})
+	db.Instance.Insert(&models.UserEmail{UserID: 1, EmailID: first.Id})
+	db.Instance.Insert(&models.UserEmail{UserID: 1, EmailID: reply.Id, Status: 1})
+	db.Instance.Insert(&models.UserEmail{UserID: 1, EmailID: reply.Id, GroupId: 9})
+	// 其他用户的邮件不可见
+	db.Instance.Insert(&models.UserEmail{UserID: 2, EmailID: other.Id})
+
+	if Of(ctx, other.Id) != 0 || Of(ctx, reply.Id) != first.Id {
+		t.Errorf("Of = %d %d", Of(ctx, other.Id), Of(ctx, reply.Id))
+	}
+	lst := List(ctx, first.Id)
+	if len(lst) != 2 || lst[0].Id != first.Id || lst[1].Id != reply.Id || lst[1].UeStatus != 1 {
+		t.Errorf("List = %+v", lst)
+	}
+}
@@ -0,0 +1,261 @@
+package vacation
+
+import (
+	"fmt"
+	"net/textproto"
+	"strings"
+	"time"
+
+	"github.com/Jinnrry/pmail/config"
+	"github.com/Jinnrry/pmail/consts"
+	"github.com/Jinnrry/pmail/db"
+	"github.com/Jinnrry/pmail/dto/parsemail"
+	"github.com/Jinnrry/pmail/models"
+	"github.com/Jinnrry/pmail/services/alias"
+	"github.com/Jinnrry/pmail/utils/context"
+	"github.com/Jinnrry/pmail/utils/errors"
+	"github.com/Jinnrry/pmail/utils/send"
+	log "github.com/sirupsen/logrus"
+)
+
+// DefaultInterval 同一发件人默认7天只回复一次，RFC 3834 建议不低于7天
+const DefaultInterval = 7
+
+// sendReply 发送自动回复，测试时替换
+var sendReply = send.Send
+
+// Get 查询用户的自动回复设置，没有设置时返回默认值
+func Get(ctx *context.Context, userID int) (*models.Vacation, error) {
+	v := &models.Vacation{}
+	has, err := db.Instance.Where("user_id=?", userID).Get(v)
+	if err != nil {
+		log.WithContext(ctx).Errorf("sql Error :%+v", err)
+		return nil, errors.Wrap(err)
+	}
+	if !has {
+		return &models.Vacation{UserId: userID, Interval: DefaultInterval}, nil
+	}
+	return v, nil
+}
+
+// Save 保存用户的自动回复设置
+func Save(ctx *context.Context, v *models.Vacation) error {
+	if v.Interval < 1 {
+		v.Interval = 1
+	}
+	var old models.Vacation
+	has, err := db.Instance.Where("user_id=?", v.UserId).Get(&old)
+	if err != nil {
+		return errors.Wrap(err)
+	}
+	if has {
+		v.Id = old.Id
+		_, err = db.Instance.ID(v.Id).Cols("enabled", "subject", "content", "start_time", "end_time", "interval_days").Update(v)
+	} else {
+		_, err = db.Instance.Insert(v)
+	}
+	if err != nil {
+		log.WithContext(ctx).Errorf("sql Error :%+v", err)
+		return errors.Wrap(err)
+	}
+	if !has || old.Content != v.Content || old.Subject != v.Subject {
+		// 回复内容变化后重新计算回复间隔
+		_, err = db.Instance.Where("user_id=? and handle=''", v.UserId).Delete(&models.VacationReply{})
+		if err != nil {
+			return errors.Wrap(err)
+		}
+	}
+	return nil
+}
+
+// active 当前时间是否在自动回复的有效期内，开始、结束时间为零值时不限制
+func active(v *models.Vacation, now time.Time) bool {
+	if v.Enabled != 1 {
+		return false
+	}
+	if !v.StartTime.IsZero() && now.Before(v.StartTime) {
+		return false
+	}
+	if !v.EndTime.IsZero() && now.After(v.EndTime) {
+		return false
+	}
+	return true
+}
+
+// suppressed 按 RFC 3834 判断是否不应自动回复：自动生成的邮件、邮件列表、群发邮件
+func suppressed(headers []*parsemail.RawHeader) bool {
+	if v := strings.ToLower(parsemail.GetRawHeader(headers, "Auto-Submitted")); v != "" && v != "no" {
+		return true
+	}
+	switch strings.ToLower(parsemail.GetRawHeader(headers, "Precedence")) {
+	case "bulk", "list", "junk":
+		return true
+	}
+	for _, name := range []string{"List-Id", "List-Post", "List-Unsubscribe", "List-Help", "Feedback-ID"} {
+		if parsemail.GetRawHeader(headers, name) != "" {
+			return true
+		}
+	}
+	// Exchange 的自动回复抑制头
+	suppress := strings.ToLower(parsemail.GetRawHeader(headers, "X-Auto-Response-Suppress"))
+	if strings.Contains(suppress, "all") || strings.Contains(suppress, "oof") {
+		return true
+	}
+	return false
+}
+
+// Automated 发件人是系统地址或者邮件是自动生成、群发的邮件，不应自动回复或发送拒收通知
+func Automated(sender string, raw []byte) bool {
+	if automatedSender(strings.ToLower(strings.Trim(strings.TrimSpace(sender), "<>"))) {
+		return true
+	}
+	headers, _ := parsemail.SplitRawMessage(raw)
+	return suppressed(headers)
+}
+
+// automatedSender 系统发件人不需要回复，比如退信与邮件列表管理地址
+func automatedSender(sender string) bool {
+	if sender == "" {
+		return true
+	}
+	account, _, _ := strings.Cut(sender, "@")
+	switch account {
+	case "mailer-daemon", "postmaster", "listserv", "majordomo", "noreply", "no-reply", "do-not-reply":
+		return true
+	}
+	return strings.HasPrefix(account, "owner-") || strings.HasSuffix(account, "-request") || strings.HasPrefix(account, "bounce")
+}
+
+// Options 一次自动回复的参数，自动回复设置与 Sieve vacation 动作共用
+type Options struct {
+	Subject string
+	Content string
+	// From 回复的发件人，为空时使用邮件中出现的用户地址
+	From string
+	// Addresses 用户的其他地址，邮件直接发给这些地址时也需要回复
+	Addresses []string
+	// Days 同一发件人的回复间隔天数
+	Days int
+	// Handle 区分不同的自动回复，分别计算回复间隔
+	Handle string
+}
+
+// recipientAddress 用户的地址需要直接出现在To或Cc中，返回该地址作为回复的发件人
+func recipientAddress(user *models.User, email *parsemail.Email, addresses []string) string {
+	for _, u := range append(append([]*parsemail.User{}, email.To...), email.Cc...) {
+		if u == nil {
+			continue
+		}
+		for _, a := range addresses {
+			if strings.EqualFold(strings.TrimSpace(a), u.EmailAddress) {
+				return strings.ToLower(u.EmailAddress)
+			}
+		}
+		account, domain := u.GetDomainAccount()
+		if !alias.IsLocalDomain(domain) {
+			continue
+		}
+		if base, _, ok := alias.SplitSubaddress(account); ok && !strings.EqualFold(account, user.Account) {
+			account = base
+		}
+		if strings.EqualFold(account, user.Account) {
+			return strings.ToLower(user.Account + "@" + domain)
+		}
+	}
+	return ""
+}
+
+// Reply 收信后按用户的自动回复设置给发件人回复，sender为信封发件人
+func Reply(ctx *context.Context, user *models.User, sender string, raw []byte, email *parsemail.Email) {
+	v, err := Get(ctx, user.ID)
+	if err != nil || !active(v, time.Now()) {
+		return
+	}
+	Respond(ctx, user, sender, raw, email, &Options{Subject: v.Subject, Content: v.Content, Days: v.Interval})
+}
+
+// Respond 检查 RFC 3834 的限制与回复间隔后发送自动回复，返回是否已发送
+func Respond(ctx *context.Context, user *models.User, sender string, raw []byte, email *parsemail.Email, opts *Options) bool {
+	if email == nil || email.Status == 3 || email.Status == int(consts.EmailStatusJunk) {
+		return false
+	}
+	sender = strings.ToLower(strings.Trim(strings.TrimSpace(sender), "<>"))
+	if automatedSender(sender) {
+		return false
+	}
+	headers, _ := parsemail.SplitRawMessage(raw)
+	if suppressed(headers) {
+		log.WithContext(ctx).Debugf("Vacation reply suppressed for %s", sender)
+		return false
+	}
+	from := recipientAddress(user, email, opts.Addresses)
+	if from == "" {
+		return false
+	}
+	if _, domain, _ := strings.Cut(sender, "@"); alias.IsLocalDomain(domain) {
+		if account, _, _ := strings.Cut(sender, "@"); strings.EqualFold(account, user.Account) {
+			return false
+		}
+	}
+	for _, a := range opts.Addresses {
+		if strings.EqualFold(strings.TrimSpace(a), sender) {
+			return false
+		}
+	}
+
+	// 回复间隔
+	days := opts.Days
+	if days < 1 {
+		days = 1
+	}
+	var last models.VacationReply
+	has, err := db.Instance.Where("user_id=? and sender=? and handle=?", user.ID, sender, opts.Handle).Get(&last)
+	if err != nil {
+		log.WithContext(ctx).Errorf("sql Error :%+v", err)
+		return false
+	}
+	now := time.Now()
+	if has && now.Sub(last.ReplyTime) < time.Duration(days)*24*time.Hour {
+		return false
+	}
+	if has {
+		_, err = db.Instance.ID(last.Id).Cols("reply_time").Update(&models.VacationReply{ReplyTime: now})
+	} else {
+		_, err = db.Instance.Insert(&models.VacationReply{UserId: user.ID, Sender: sender, Handle: opts.Handle, ReplyTime: now})
+	}
+	if err != nil {
+		log.WithContext(ctx).Errorf("sql Error :%+v", err)
+		return false
+	}
+
+	subject := opts.Subject
+	if subject == "" {
+		subject = "Auto: " + email.Subject
+	}
+	fromUser := &parsemail.User{Name: user.Name, EmailAddress: from}
+	if opts.From != "" {
+		if u := parsemail.BuilderUser(opts.From); u != nil && u.EmailAddress != "" {
+			fromUser = u
+		}
+	}
+	reply := &parsemail.Email{
+		From:    fromUser,
+		To:      []*parsemail.User{{EmailAddress: sender}},
+		Subject: subject,
+		Text:    []byte(opts.Content),
+		MsgID:   parsemail.GenerateMsgID(config.Instance.Domain),
+		Headers: textproto.MIMEHeader{},
+	}
+	reply.Headers.Set("Auto-Submitted", "auto-replied")
+	reply.Headers.Set("X-Auto-Response-Suppress", "All")
+	if email.MsgID != "" {
+		reply.Headers.Set("In-Reply-To", fmt.Sprintf("<%s>", email.MsgID))
+		reply.Headers.Set("References", fmt.Sprintf("<%s>", email.MsgID))
+	}
+	if err, _ := sendReply(ctx, reply); err != nil {
+		log.WithContext(ctx).Errorf("Vacation reply to %s error: %v", sender, err)
+		return false
+	}
+	log.WithContext(ctx).Infof("Vacation reply sent: %s -> %s", fromUser.EmailAddress, sender)
+	return true
+}
@@ -0,0 +1,159 @@
+package vacation
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Jinnrry/pmail/db"
+	"github.com/Jinnrry/pmail/db/dbtest"
+	"github.com/Jinnrry/pmail/dto/parsemail"
+	"github.com/Jinnrry/pmail/models"
+	"github.com/Jinnrry/pmail/utils/context"
+)
+
+func initTestDB(t *testing.T, replies *[]*parsemail.Email) *models.User {
+	oldSend := sendReply
+	sendReply = func(ctx *context.Context, e *parsemail.Email) (error, map[string]error) {
+		*replies = append(*replies, e)
+		return nil, nil
+	}
+	t.Cleanup(func() { sendReply = oldSend })
+	dbtest.Init(t)
+	return dbtest.User(t, "alice", "Alice")
+}
+
+func testEmail() *parsemail.Email {
+	return &parsemail.Email{
+		From:    &parsemail.User{EmailAddress: "bob@remote.net"},
+		To:      []*parsemail.User{{EmailAddress: "Alice@example.com"}},
+		Subject: "Meeting",
+		MsgID:   "abc@remote.net",
+	}
+}
+
+func TestReply(t *testing.T) {
+	var replies []*parsemail.Email
+	user := initTestDB(t, &replies)
+	ctx := &context.Context{}
+	raw := []byte("From: bob@remote.net\r\nTo: alice@example.com\r\nSubject: Meeting\r\n\r\nhi\r\n")
+
+	// 未开启
+	Reply(ctx, user, "bob@remote.net", raw, testEmail())
+	if len(replies) != 0 {
+		t.Fatalf("reply sent while disabled")
+	}
+
+	err := Save(ctx, &models.Vacation{UserId: user.ID, Enabled: 1, Content: "I am away", Interval: 7})
+	if err != nil {
+		t.Fatal(err)
+	}
+	Reply(ctx, user, "<Bob@remote.net>", raw, testEmail())
+	if len(replies) != 1 {
+		t.Fatalf("replies = %d", len(replies))
+	}
+	r := replies[0]
+	if r.From.EmailAddress != "alice@example.com" || r.To[0].EmailAddress != "bob@remote.net" || r.Subject != "Auto: Meeting" {
+		t.Errorf("reply = %+v", r)
+	}
+	if r.Headers.Get("Auto-Submitted") != "auto-replied" || r.Headers.Get("In-Reply-To") != "<abc@remote.net>" {
+		t.Errorf("reply headers = %v", r.Headers)
+	}
+
+	// 回复间隔内不再回复
+	Reply(ctx, user, "bob@remote.net", raw, testEmail())
+	if len(replies) != 1 {
+		t.Errorf("reply sent twice within interval")
+	}
+	db.Instance.Where("user_id=?", user.ID).Cols("reply_time").Update(&models.VacationReply{ReplyTime: time.Now().Add(-8 * 24 * time.Hour)})
+	Reply(ctx, user, "bob@remote.net", raw, testEmail())
+	if len(replies) != 2 {
+		t.Errorf("reply not sent after interval")
+	}
+}
+
+func TestReplySuppressed(t *testing.T) {
+	var replies []*parsemail.Email
+	user := initTestDB(t, &replies)
+	ctx := &context.Context{}
+	err := Save(ctx, &models.Vacation{UserId: user.ID, Enabled: 1, Content: "I am away", Interval: 7})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		sender string
+		raw    string
+		email  *parsemail.Email
+	}{
+		{"auto submitted", "bob@remote.net", "Auto-Submitted: auto-replied\r\n\r\n", testEmail()},
+		{"bulk", "bob@remote.net", "Precedence: bulk\r\n\r\n", testEmail()},
+		{"list", "bob@remote.net", "List-Id: <dev.remote.net>\r\n\r\n", testEmail()},
+		{"exchange", "bob@remote.net", "X-Auto-Response-Suppress: OOF, DR\r\n\r\n", testEmail()},
+		{"null sender", "", "\r\n", testEmail()},
+		{"daemon", "MAILER-DAEMON@remote.net", "\r\n", testEmail()},
+		{"list owner", "owner-dev@remote.net", "\r\n", testEmail()},
+		{"not addressed", "bob@remote.net", "\r\n", &parsemail.Email{To: []*parsemail.User{{EmailAddress: "sales@example.com"}}}},
+		{"junk", "bob@remote.net", "\r\n", &parsemail.Email{To: []*parsemail.User{{EmailAddress: "alice@example.com"}}, Status: 5}},
+	}
+	for _, tt := range tests {
+		Reply(ctx, user, tt.sender, []byte(tt.raw), tt.email)
+		if len(replies) != 0 {
+			t.Errorf("%s: reply should be suppressed", tt.name)
+			replies = nil
+		}
+	}
+
+	// 有效期外不回复
+	err = Save(ctx, &models.Vacation{UserId: user.ID, Enabled: 1, Content: "I am away", EndTime: time.Now().Add(-time.Hour)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	Reply(ctx, user, "bob@remote.net", []byte("\r\n"), testEmail())
+	if len(replies) != 0 {
+		t.Errorf("reply sent after end time")
+	}
+
+	// 不设置时间时一直有效
+	err = Save(ctx, &models.Vacation{UserId: user.ID, Enabled: 1, Content: "I am away"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	v, _ := Get(ctx, user.ID)
+	if !active(v, time.Now()) {
+		t.Errorf("vacation without dates should be active, got %+v", v)
+	}
+}
+
+func TestRespondHandle(t *testing.T) {
+	var replies []*parsemail.Email
+	user := initTestDB(t, &replies)
+	ctx := &context.Context{}
+	raw := []byte("From: bob@remote.net\r\nTo: alice@example.com\r\nSubject: Meeting\r\n\r\nhi\r\n")
+
+	opts := &Options{Subject: "Away", Content: "back monday", Days: 1, Handle: "h1"}
+	if !Respond(ctx, user, "bob@remote.net", raw, testEmail(), opts) {
+		t.Fatalf("first reply not sent")
+	}
+	if Respond(ctx, user, "bob@remote.net", raw, testEmail(), opts) {
+		t.Errorf("reply sent twice for the same handle")
+	}
+	// 不同的handle分别计算回复间隔
+	opts2 := &Options{Content: "other", Days: 1, Handle: "h2", From: "Alice <alice@example.com>"}
+	if !Respond(ctx, user, "bob@remote.net", raw, testEmail(), opts2) {
+		t.Errorf("reply with another handle not sent")
+	}
+	// 发给其他地址的邮件只有在 addresses 中时才回复
+	email := testEmail()
+	email.To = []*parsemail.User{{EmailAddress: "alice@other.org"}}
+	if Respond(ctx, user, "carol@remote.net", raw, email, opts) {
+		t.Errorf("reply sent for unknown recipient")
+	}
+	opts.Addresses = []string{"alice@other.org"}
+	if !Respond(ctx, user, "carol@remote.net", raw, email, opts) {
+		t.Errorf("reply not sent for listed address")
+	}
+	if len(replies) != 3 || replies[0].Subject != "Away" || replies[1].From.Name != "Alice" || replies[2].From.EmailAddress != "alice@other.org" {
+		t.Errorf("replies = %+v", replies)
+	}
+}
@@ -0,0 +1,15 @@
+package imap
+
+type IDData struct {
+	Name        string
+	Version     string
+	OS          string
+	OSVersion   string
+	Vendor      string
+	SupportURL  string
+	Address     string
+	Date        string
+	Command     string
+	Arguments   string
+	Environment string
+}
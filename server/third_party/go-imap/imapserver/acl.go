@@ -0,0 +1,141 @@
+package imapserver
+
+import (
+	"sort"
+
+	"github.com/emersion/go-imap/v2"
+	"github.com/emersion/go-imap/v2/internal/imapwire"
+)
+
+// SessionACL is an IMAP session which supports ACL.
+type SessionACL interface {
+	Session
+
+	// Authenticated state
+	SetACL(mailbox string, ri imap.RightsIdentifier, rm imap.RightModification, rs imap.RightSet) error
+	DeleteACL(mailbox string, ri imap.RightsIdentifier) error
+	GetACL(mailbox string) (*imap.ACLData, error)
+	ListRights(mailbox string, ri imap.RightsIdentifier) (*imap.ListRightsData, error)
+	MyRights(mailbox string) (*imap.MyRightsData, error)
+}
+
+func (c *Conn) aclSession() (SessionACL, error) {
+	if err := c.checkState(imap.ConnStateAuthenticated); err != nil {
+		return nil, err
+	}
+	session, ok := c.session.(SessionACL)
+	if !ok {
+		return nil, newClientBugError("ACL is not supported")
+	}
+	return session, nil
+}
+
+func (c *Conn) handleSetACL(dec *imapwire.Decoder) error {
+	var mailbox, ri, rights string
+	if !dec.ExpectSP() || !dec.ExpectMailbox(&mailbox) || !dec.ExpectSP() || !dec.ExpectAString(&ri) ||
+		!dec.ExpectSP() || !dec.ExpectAString(&rights) || !dec.ExpectCRLF() {
+		return dec.Err()
+	}
+
+	session, err := c.aclSession()
+	if err != nil {
+		return err
+	}
+
+	rm := imap.RightModificationReplace
+	if len(rights) > 0 && (rights[0] == '+' || rights[0] == '-') {
+		rm = imap.RightModification(rights[0])
+		rights = rights[1:]
+	}
+	return session.SetACL(mailbox, imap.RightsIdentifier(ri), rm, imap.RightSet(rights))
+}
+
+func (c *Conn) handleDeleteACL(dec *imapwire.Decoder) error {
+	var mailbox, ri string
+	if !dec.ExpectSP() || !dec.ExpectMailbox(&mailbox) || !dec.ExpectSP() || !dec.ExpectAString(&ri) || !dec.ExpectCRLF() {
+		return dec.Err()
+	}
+
+	session, err := c.aclSession()
+	if err != nil {
+		return err
+	}
+	return session.DeleteACL(mailbox, imap.RightsIdentifier(ri))
+}
+
+func (c *Conn) handleGetACL(dec *imapwire.Decoder) error {
+	var mailbox string
+	if !dec.ExpectSP() || !dec.ExpectMailbox(&mailbox) || !dec.ExpectCRLF() {
+		return dec.Err()
+	}
+
+	session, err := c.aclSession()
+	if err != nil {
+		return err
+	}
+	data, err := session.GetACL(mailbox)
+	if err != nil {
+		return err
+	}
+
+	// Sort identifiers to get a stable output
+	identifiers := make([]string, 0, len(data.Rights))
+	for ri := range data.Rights {
+		identifiers = append(identifiers, string(ri))
+	}
+	sort.Strings(identifiers)
+
+	enc := newResponseEncoder(c)
+	defer enc.end()
+	enc.Atom("*").SP().Atom("ACL").SP().Mailbox(data.Mailbox)
+	for _, ri := range identifiers {
+		enc.SP().String(ri).SP().String(data.Rights[imap.RightsIdentifier(ri)].String())
+	}
+	return enc.CRLF()
+}
+
+func (c *Conn) handleListRights(dec *imapwire.Decoder) error {
+	var mailbox, ri string
+	if !dec.ExpectSP() || !dec.ExpectMailbox(&mailbox) || !dec.ExpectSP() || !dec.ExpectAString(&ri) || !dec.ExpectCRLF() {
+		return dec.Err()
+	}
+
+	session, err := c.aclSession()
+	if err != nil {
+		return err
+	}
+	data, err := session.ListRights(mailbox, imap.RightsIdentifier(ri))
+	if err != nil {
+		return err
+	}
+
+	enc := newResponseEncoder(c)
+	defer enc.end()
+	enc.Atom("*").SP().Atom("LISTRIGHTS").SP().Mailbox(data.Mailbox).SP().String(string(data.Identifier))
+	enc.SP().String(data.Required.String())
+	for _, rs := range data.Optional {
+		enc.SP().String(rs.String())
+	}
+	return enc.CRLF()
+}
+
+func (c *Conn) handleMyRights(dec *imapwire.Decoder) error {
+	var mailbox string
+	if !dec.ExpectSP() || !dec.ExpectMailbox(&mailbox) || !dec.ExpectCRLF() {
+		return dec.Err()
+	}
+
+	session, err := c.aclSession()
+	if err != nil {
+		return err
+	}
+	data, err := session.MyRights(mailbox)
+	if err != nil {
+		return err
+	}
+
+	enc := newResponseEncoder(c)
+	defer enc.end()
+	enc.Atom("*").SP().Atom("MYRIGHTS").SP().Mailbox(data.Mailbox).SP().String(data.Rights.String())
+	return enc.CRLF()
+}
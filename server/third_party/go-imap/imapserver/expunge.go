@@ -0,0 +1,85 @@
+package imapserver
+
+import (
+	"github.com/emersion/go-imap/v2"
+	"github.com/emersion/go-imap/v2/internal/imapwire"
+)
+
+func (c *Conn) handleExpunge(dec *imapwire.Decoder) error {
+	if !dec.ExpectCRLF() {
+		return dec.Err()
+	}
+	return c.expunge(nil)
+}
+
+func (c *Conn) handleUIDExpunge(dec *imapwire.Decoder) error {
+	var uidSet imap.UIDSet
+	if !dec.ExpectSP() || !dec.ExpectUIDSet(&uidSet) || !dec.ExpectCRLF() {
+		return dec.Err()
+	}
+	return c.expunge(&uidSet)
+}
+
+func (c *Conn) expunge(uids *imap.UIDSet) error {
+	if err := c.checkState(imap.ConnStateSelected); err != nil {
+		return err
+	}
+	if c.readOnly {
+		return &imap.Error{
+			Type: imap.StatusResponseTypeNo,
+			Text: "Mailbox is read-only",
+		}
+	}
+	w := &ExpungeWriter{conn: c}
+	return c.session.Expunge(w, uids)
+}
+
+func (c *Conn) writeExpunge(seqNum uint32) error {
+	enc := newResponseEncoder(c)
+	defer enc.end()
+	enc.Atom("*").SP().Number(seqNum).SP().Atom("EXPUNGE")
+	return enc.CRLF()
+}
+
+// writeVanished writes a VANISHED response, earlier is set for the messages
+// expunged before the current command.
+func (c *Conn) writeVanished(uids imap.UIDSet, earlier bool) error {
+	enc := newResponseEncoder(c)
+	defer enc.end()
+	enc.Atom("*").SP().Atom("VANISHED").SP()
+	if earlier {
+		enc.Special('(').Atom("EARLIER").Special(')').SP()
+	}
+	enc.NumSet(uids)
+	return enc.CRLF()
+}
+
+// ExpungeWriter writes EXPUNGE updates.
+type ExpungeWriter struct {
+	conn *Conn
+}
+
+// WriteExpunge notifies the client that the message with the provided sequence
+// number has been deleted.
+func (w *ExpungeWriter) WriteExpunge(seqNum uint32) error {
+	if w.conn == nil {
+		return nil
+	}
+	return w.conn.writeExpunge(seqNum)
+}
+
+// QResync returns true if the client has enabled QRESYNC, in which case
+// expunged messages must be reported with WriteVanished instead of
+// WriteExpunge.
+func (w *ExpungeWriter) QResync() bool {
+	return w.conn != nil && w.conn.isEnabled(imap.CapQResync)
+}
+
+// WriteVanished notifies the client that the messages with the provided UIDs
+// have been deleted.
+func (w *ExpungeWriter) WriteVanished(uids imap.UIDSet) error {
+	if w.conn == nil || len(uids) == 0 {
+		return nil
+	}
+	return w.conn.writeVanished(uids, false)
+}
@@ -0,0 +1,101 @@
+package imapserver
+
+import (
+	"sort"
+
+	"github.com/emersion/go-imap/v2"
+	"github.com/emersion/go-imap/v2/internal/imapwire"
+)
+
+// SessionQuota is an IMAP session which supports QUOTA.
+type SessionQuota interface {
+	Session
+
+	// Authenticated state
+	GetQuota(root string) (*imap.QuotaData, error)
+	// GetQuotaRoot returns the quota roots of a mailbox, and the quota data
+	// for each root.
+	GetQuotaRoot(mailbox string) ([]string, []imap.QuotaData, error)
+}
+
+func (c *Conn) handleGetQuota(dec *imapwire.Decoder) error {
+	var root string
+	if !dec.ExpectSP() || !dec.ExpectAString(&root) || !dec.ExpectCRLF() {
+		return dec.Err()
+	}
+
+	if err := c.checkState(imap.ConnStateAuthenticated); err != nil {
+		return err
+	}
+
+	session, ok := c.session.(SessionQuota)
+	if !ok {
+		return newClientBugError("QUOTA is not supported")
+	}
+
+	data, err := session.GetQuota(root)
+	if err != nil {
+		return err
+	}
+
+	return c.writeQuota(data)
+}
+
+func (c *Conn) handleGetQuotaRoot(dec *imapwire.Decoder) error {
+	var mailbox string
+	if !dec.ExpectSP() || !dec.ExpectMailbox(&mailbox) || !dec.ExpectCRLF() {
+		return dec.Err()
+	}
+
+	if err := c.checkState(imap.ConnStateAuthenticated); err != nil {
+		return err
+	}
+
+	session, ok := c.session.(SessionQuota)
+	if !ok {
+		return newClientBugError("QUOTA is not supported")
+	}
+
+	roots, data, err := session.GetQuotaRoot(mailbox)
+	if err != nil {
+		return err
+	}
+
+	if err := c.writeQuotaRoot(mailbox, roots); err != nil {
+		return err
+	}
+	for i := range data {
+		if err := c.writeQuota(&data[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *Conn) writeQuotaRoot(mailbox string, roots []string) error {
+	enc := newResponseEncoder(c)
+	defer enc.end()
+	enc.Atom("*").SP().Atom("QUOTAROOT").SP().Mailbox(mailbox)
+	for _, root := range roots {
+		enc.SP().String(root)
+	}
+	return enc.CRLF()
+}
+
+func (c *Conn) writeQuota(data *imap.QuotaData) error {
+	// Sort resources to get a stable output
+	types := make([]string, 0, len(data.Resources))
+	for typ := range data.Resources {
+		types = append(types, string(typ))
+	}
+	sort.Strings(types)
+
+	enc := newResponseEncoder(c)
+	defer enc.end()
+	enc.Atom("*").SP().Atom("QUOTA").SP().String(data.Root).SP()
+	enc.List(len(types), func(i int) {
+		res := data.Resources[imap.QuotaResourceType(types[i])]
+		enc.Atom(types[i]).SP().Number64(res.Usage).SP().Number64(res.Limit)
+	})
+	return enc.CRLF()
+}
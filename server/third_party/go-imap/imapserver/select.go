@@ -0,0 +1,305 @@
+package imapserver
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/emersion/go-imap/v2"
+	"github.com/emersion/go-imap/v2/internal/imapwire"
+)
+
+func (c *Conn) handleSelect(tag string, dec *imapwire.Decoder, readOnly bool) error {
+	var mailbox string
+	if !dec.ExpectSP() || !dec.ExpectMailbox(&mailbox) {
+		return dec.Err()
+	}
+
+	options := imap.SelectOptions{ReadOnly: readOnly}
+	if dec.SP() {
+		err := dec.ExpectList(func() error {
+			return readSelectParam(dec, &options)
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	if !dec.ExpectCRLF() {
+		return dec.Err()
+	}
+
+	if err := c.checkState(imap.ConnStateAuthenticated); err != nil {
+		return err
+	}
+
+	caps := c.server.options.caps()
+	if options.CondStore && !caps.Has(imap.CapCondStore) {
+		return newClientBugError("CONDSTORE is not supported")
+	}
+	if options.QResync != nil && !c.isEnabled(imap.CapQResync) {
+		return newClientBugError("QRESYNC must be enabled first")
+	}
+	if options.CondStore {
+		c.enableCondStore()
+	}
+
+	if c.state == imap.ConnStateSelected {
+		if err := c.session.Unselect(); err != nil {
+			return err
+		}
+		c.state = imap.ConnStateAuthenticated
+		err := c.writeStatusResp("", &imap.StatusResponse{
+			Type: imap.StatusResponseTypeOK,
+			Code: "CLOSED",
+			Text: "Previous mailbox is now closed",
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	data, err := c.session.Select(mailbox, &options)
+	if err != nil {
+		return err
+	}
+	readOnly = readOnly || data.ReadOnly
+
+	if err := c.writeExists(data.NumMessages); err != nil {
+		return err
+	}
+	if !c.enabled.Has(imap.CapIMAP4rev2) {
+		if err := c.writeObsoleteRecent(); err != nil {
+			return err
+		}
+	}
+	if err := c.writeUIDValidity(data.UIDValidity); err != nil {
+		return err
+	}
+	if err := c.writeUIDNext(data.UIDNext); err != nil {
+		return err
+	}
+	if err := c.writeFlags(data.Flags); err != nil {
+		return err
+	}
+	if err := c.writePermanentFlags(data.PermanentFlags); err != nil {
+		return err
+	}
+	if data.List != nil {
+		if err := c.writeList(data.List); err != nil {
+			return err
+		}
+	}
+	if caps.Has(imap.CapCondStore) {
+		if err := c.writeHighestModSeq(data.HighestModSeq); err != nil {
+			return err
+		}
+	}
+
+	c.state = imap.ConnStateSelected
+	c.readOnly = readOnly
+
+	if qresync := options.QResync; qresync != nil && qresync.UIDValidity == data.UIDValidity && data.HighestModSeq > 0 {
+		if err := c.resync(qresync); err != nil {
+			return err
+		}
+	}
+	// TODO: forbid write commands in read-only mode
+
+	cmdName := "SELECT"
+	if options.ReadOnly {
+		cmdName = "EXAMINE"
+	}
+	code := imap.ResponseCode("READ-WRITE")
+	if readOnly {
+		code = "READ-ONLY"
+	}
+	return c.writeStatusResp(tag, &imap.StatusResponse{
+		Type: imap.StatusResponseTypeOK,
+		Code: code,
+		Text: fmt.Sprintf("%v completed", cmdName),
+	})
+}
+
+// resync reports the changes since the QRESYNC parameters of SELECT or
+// EXAMINE: VANISHED (EARLIER) for the expunged messages, then FETCH responses
+// for the changed ones.
+func (c *Conn) resync(qresync *imap.SelectQResync) error {
+	uids := qresync.KnownUIDs
+	if len(uids) == 0 {
+		uids = imap.UIDSet{imap.UIDRange{Start: 1, Stop: 0}}
+	}
+	w := &FetchWriter{conn: c}
+	return c.session.Fetch(w, uids, &imap.FetchOptions{
+		UID:          true,
+		Flags:        true,
+		ModSeq:       true,
+		ChangedSince: qresync.ModSeq,
+		Vanished:     true,
+	})
+}
+
+func readSelectParam(dec *imapwire.Decoder, options *imap.SelectOptions) error {
+	var name string
+	if !dec.ExpectAtom(&name) {
+		return dec.Err()
+	}
+	switch strings.ToUpper(name) {
+	case "CONDSTORE":
+		options.CondStore = true
+	case "QRESYNC":
+		var qresync imap.SelectQResync
+		if !dec.ExpectSP() {
+			return dec.Err()
+		}
+		i := 0
+		err := dec.ExpectList(func() error {
+			defer func() { i++ }()
+			switch i {
+			case 0:
+				if !dec.ExpectNumber(&qresync.UIDValidity) {
+					return dec.Err()
+				}
+			case 1:
+				if !dec.ExpectModSeq(&qresync.ModSeq) {
+					return dec.Err()
+				}
+			case 2:
+				isList, err := dec.List(func() error {
+					return readSeqMatch(dec, &qresync)
+				})
+				if err != nil {
+					return err
+				} else if !isList && !dec.ExpectUIDSet(&qresync.KnownUIDs) {
+					return dec.Err()
+				}
+			case 3:
+				if qresync.SeqMatch != nil {
+					return newClientBugError("Unexpected QRESYNC parameter")
+				}
+				return dec.ExpectList(func() error {
+					return readSeqMatch(dec, &qresync)
+				})
+			default:
+				return newClientBugError("Too many QRESYNC parameters")
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		if i < 2 {
+			return newClientBugError("Missing QRESYNC parameters")
+		}
+		options.QResync = &qresync
+	default:
+		return newClientBugError("Unknown SELECT parameter")
+	}
+	return nil
+}
+
+// readSeqMatch reads the content of a seq-match-data list.
+func readSeqMatch(dec *imapwire.Decoder, qresync *imap.SelectQResync) error {
+	var (
+		seqSet imap.NumSet
+		match  imap.SelectQResyncSeqMatch
+	)
+	if !dec.ExpectNumSet(imapwire.NumKindSeq, &seqSet) || !dec.ExpectSP() || !dec.ExpectUIDSet(&match.UIDs) {
+		return dec.Err()
+	}
+	seqNums, ok := seqSet.(imap.SeqSet)
+	if !ok {
+		return newClientBugError("Invalid QRESYNC sequence set")
+	}
+	match.SeqNums = seqNums
+	qresync.SeqMatch = &match
+	return nil
+}
+
+func (c *Conn) handleUnselect(dec *imapwire.Decoder, expunge bool) error {
+	if !dec.ExpectCRLF() {
+		return dec.Err()
+	}
+
+	if err := c.checkState(imap.ConnStateSelected); err != nil {
+		return err
+	}
+
+	// CLOSE doesn't expunge a mailbox opened with EXAMINE
+	if expunge && !c.readOnly {
+		w := &ExpungeWriter{}
+		if err := c.session.Expunge(w, nil); err != nil {
+			return err
+		}
+	}
+
+	if err := c.session.Unselect(); err != nil {
+		return err
+	}
+
+	c.state = imap.ConnStateAuthenticated
+	return nil
+}
+
+func (c *Conn) writeExists(numMessages uint32) error {
+	enc := newResponseEncoder(c)
+	defer enc.end()
+	return enc.Atom("*").SP().Number(numMessages).SP().Atom("EXISTS").CRLF()
+}
+
+func (c *Conn) writeObsoleteRecent() error {
+	enc := newResponseEncoder(c)
+	defer enc.end()
+	return enc.Atom("*").SP().Number(0).SP().Atom("RECENT").CRLF()
+}
+
+func (c *Conn) writeUIDValidity(uidValidity uint32) error {
+	enc := newResponseEncoder(c)
+	defer enc.end()
+	enc.Atom("*").SP().Atom("OK").SP()
+	enc.Special('[').Atom("UIDVALIDITY").SP().Number(uidValidity).Special(']')
+	enc.SP().Text("UIDs valid")
+	return enc.CRLF()
+}
+
+func (c *Conn) writeHighestModSeq(modSeq uint64) error {
+	enc := newResponseEncoder(c)
+	defer enc.end()
+	enc.Atom("*").SP().Atom("OK").SP()
+	if modSeq == 0 {
+		enc.Special('[').Atom("NOMODSEQ").Special(']')
+		enc.SP().Text("Sorry, this mailbox format doesn't support modsequences")
+	} else {
+		enc.Special('[').Atom("HIGHESTMODSEQ").SP().ModSeq(modSeq).Special(']')
+		enc.SP().Text("Highest")
+	}
+	return enc.CRLF()
+}
+
+func (c *Conn) writeUIDNext(uidNext imap.UID) error {
+	enc := newResponseEncoder(c)
+	defer enc.end()
+	enc.Atom("*").SP().Atom("OK").SP()
+	enc.Special('[').Atom("UIDNEXT").SP().UID(uidNext).Special(']')
+	enc.SP().Text("Predicted next UID")
+	return enc.CRLF()
+}
+
+func (c *Conn) writeFlags(flags []imap.Flag) error {
+	enc := newResponseEncoder(c)
+	defer enc.end()
+	enc.Atom("*").SP().Atom("FLAGS").SP().List(len(flags), func(i int) {
+		enc.Flag(flags[i])
+	})
+	return enc.CRLF()
+}
+
+func (c *Conn) writePermanentFlags(flags []imap.Flag) error {
+	enc := newResponseEncoder(c)
+	defer enc.end()
+	enc.Atom("*").SP().Atom("OK").SP()
+	enc.Special('[').Atom("PERMANENTFLAGS").SP().List(len(flags), func(i int) {
+		enc.Flag(flags[i])
+	}).Special(']')
+	enc.SP().Text("Permanent flags")
+	return enc.CRLF()
+}
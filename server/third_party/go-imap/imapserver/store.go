@@ -0,0 +1,130 @@
+package imapserver
+
+import (
+	"strings"
+
+	"github.com/emersion/go-imap/v2"
+	"github.com/emersion/go-imap/v2/internal"
+	"github.com/emersion/go-imap/v2/internal/imapwire"
+)
+
+func (c *Conn) handleStore(tag string, dec *imapwire.Decoder, numKind NumKind) error {
+	var (
+		numSet  imap.NumSet
+		item    string
+		options imap.StoreOptions
+	)
+	if !dec.ExpectSP() || !dec.ExpectNumSet(numKind.wire(), &numSet) || !dec.ExpectSP() {
+		return dec.Err()
+	}
+	isList, err := dec.List(func() error {
+		return readStoreModifier(dec, &options)
+	})
+	if err != nil {
+		return err
+	} else if isList && !dec.ExpectSP() {
+		return dec.Err()
+	}
+	if !dec.ExpectAtom(&item) || !dec.ExpectSP() {
+		return dec.Err()
+	}
+	var flags []imap.Flag
+	isList, err = dec.List(func() error {
+		flag, err := internal.ExpectFlag(dec)
+		if err != nil {
+			return err
+		}
+		flags = append(flags, flag)
+		return nil
+	})
+	if err != nil {
+		return err
+	} else if !isList {
+		for {
+			flag, err := internal.ExpectFlag(dec)
+			if err != nil {
+				return err
+			}
+			flags = append(flags, flag)
+
+			if !dec.SP() {
+				break
+			}
+		}
+	}
+	if !dec.ExpectCRLF() {
+		return dec.Err()
+	}
+
+	item = strings.ToUpper(item)
+	silent := strings.HasSuffix(item, ".SILENT")
+	item = strings.TrimSuffix(item, ".SILENT")
+
+	var op imap.StoreFlagsOp
+	switch {
+	case strings.HasPrefix(item, "+"):
+		op = imap.StoreFlagsAdd
+		item = strings.TrimPrefix(item, "+")
+	case strings.HasPrefix(item, "-"):
+		op = imap.StoreFlagsDel
+		item = strings.TrimPrefix(item, "-")
+	default:
+		op = imap.StoreFlagsSet
+	}
+
+	if item != "FLAGS" {
+		return newClientBugError("STORE can only change FLAGS")
+	}
+
+	if err := c.checkState(imap.ConnStateSelected); err != nil {
+		return err
+	}
+
+	if options.UnchangedSince > 0 {
+		if !c.server.options.caps().Has(imap.CapCondStore) {
+			return newClientBugError("CONDSTORE is not supported")
+		}
+		c.enableCondStore()
+	}
+
+	w := &FetchWriter{conn: c}
+	err = c.session.Store(w, numSet, &imap.StoreFlags{
+		Op:     op,
+		Silent: silent,
+		Flags:  flags,
+	}, &options)
+	if err != nil {
+		return err
+	}
+
+	if err := c.poll("STORE"); err != nil {
+		return err
+	}
+	resp := &imap.StatusResponse{
+		Type: imap.StatusResponseTypeOK,
+		Text: "STORE completed",
+	}
+	if w.modified != nil && !isNumSetEmpty(w.modified) {
+		// RFC 7162 section 3.1.3: messages which failed the UNCHANGEDSINCE
+		// test are listed in the MODIFIED response code
+		resp.Code = imap.ResponseCode("MODIFIED " + w.modified.String())
+		resp.Text = "Conditional STORE failed"
+	}
+	return c.writeStatusResp(tag, resp)
+}
+
+func readStoreModifier(dec *imapwire.Decoder, options *imap.StoreOptions) error {
+	var name string
+	if !dec.ExpectAtom(&name) {
+		return dec.Err()
+	}
+	switch strings.ToUpper(name) {
+	case "UNCHANGEDSINCE":
+		if !dec.ExpectSP() || !dec.ExpectModSeq(&options.UnchangedSince) {
+			return dec.Err()
+		}
+	default:
+		return newClientBugError("Unknown STORE modifier")
+	}
+	return nil
+}
@@ -0,0 +1,88 @@
+package imapserver
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/emersion/go-imap/v2"
+	"github.com/emersion/go-imap/v2/internal/imapwire"
+)
+
+func (c *Conn) handleThread(dec *imapwire.Decoder, numKind NumKind) error {
+	var algorithm, charset string
+	if !dec.ExpectSP() || !dec.ExpectAtom(&algorithm) || !dec.ExpectSP() || !dec.ExpectAString(&charset) || !dec.ExpectSP() {
+		return dec.Err()
+	}
+
+	var criteria imap.SearchCriteria
+	for {
+		if err := readSearchKey(&criteria, dec); err != nil {
+			return fmt.Errorf("in search-key: %w", err)
+		}
+		if !dec.SP() {
+			break
+		}
+	}
+	if !dec.ExpectCRLF() {
+		return dec.Err()
+	}
+
+	if err := c.checkState(imap.ConnStateSelected); err != nil {
+		return err
+	}
+
+	session, ok := c.session.(SessionThread)
+	alg := imap.ThreadAlgorithm(strings.ToUpper(algorithm))
+	caps := c.server.options.caps()
+	if !ok || !caps.Has(imap.Cap("THREAD="+string(alg))) {
+		return &imap.Error{
+			Type: imap.StatusResponseTypeBad,
+			Text: "Unsupported threading algorithm",
+		}
+	}
+	switch strings.ToUpper(charset) {
+	case "US-ASCII", "UTF-8":
+		// nothing to do
+	default:
+		return &imap.Error{
+			Type: imap.StatusResponseTypeNo,
+			Code: imap.ResponseCodeBadCharset,
+			Text: "Only US-ASCII and UTF-8 are supported THREAD charsets",
+		}
+	}
+
+	threads, err := session.Thread(numKind, alg, &criteria)
+	if err != nil {
+		return err
+	}
+
+	enc := newResponseEncoder(c)
+	defer enc.end()
+	enc.Atom("*").SP().Atom("THREAD")
+	if len(threads) > 0 {
+		enc.SP()
+	}
+	for i := range threads {
+		writeThread(enc.Encoder, &threads[i])
+	}
+	return enc.CRLF()
+}
+
+func writeThread(enc *imapwire.Encoder, thread *imap.ThreadData) {
+	enc.Special('(')
+	for i, num := range thread.Chain {
+		if i > 0 {
+			enc.SP()
+		}
+		enc.Number(num)
+	}
+	// thread-members = nz-number *(SP nz-number) [SP thread-nested], nested
+	// thread lists are not separated by spaces
+	if len(thread.Chain) > 0 && len(thread.SubThreads) > 0 {
+		enc.SP()
+	}
+	for i := range thread.SubThreads {
+		writeThread(enc, &thread.SubThreads[i])
+	}
+	enc.Special(')')
+}
@@ -0,0 +1,48 @@
+package imap
+
+// SelectOptions contains options for the SELECT or EXAMINE command.
+type SelectOptions struct {
+	ReadOnly  bool
+	CondStore bool // requires CONDSTORE
+
+	QResync *SelectQResync // requires QRESYNC
+}
+
+// SelectQResync contains the QRESYNC parameters of the SELECT or EXAMINE
+// command.
+type SelectQResync struct {
+	UIDValidity uint32
+	ModSeq      uint64
+	KnownUIDs   UIDSet // optional
+	SeqMatch    *SelectQResyncSeqMatch
+}
+
+// SelectQResyncSeqMatch is a list of known sequence numbers and their UIDs.
+type SelectQResyncSeqMatch struct {
+	SeqNums SeqSet
+	UIDs    UIDSet
+}
+
+// SelectData is the data returned by a SELECT command.
+//
+// In the old RFC 2060, PermanentFlags, UIDNext and UIDValidity are optional.
+type SelectData struct {
+	// Flags defined for this mailbox
+	Flags []Flag
+	// Flags that the client can change permanently
+	PermanentFlags []Flag
+	// Number of messages in this mailbox (aka. "EXISTS")
+	NumMessages uint32
+	UIDNext     UID
+	UIDValidity uint32
+
+	List *ListData // requires IMAP4rev2
+
+	// requires CONDSTORE, zero means the mailbox doesn't support persistent
+	// mod-sequences
+	HighestModSeq uint64
+
+	// Open the mailbox read-only even for SELECT, e.g. when the ACL doesn't
+	// allow the user to change it
+	ReadOnly bool
+}
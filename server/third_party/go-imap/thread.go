@@ -0,0 +1,19 @@
+package imap
+
+// ThreadAlgorithm is a threading algorithm.
+type ThreadAlgorithm string
+
+const (
+	ThreadOrderedSubject ThreadAlgorithm = "ORDEREDSUBJECT"
+	ThreadReferences     ThreadAlgorithm = "REFERENCES"
+)
+
+// ThreadData is a thread returned by the THREAD command.
+//
+// Chain contains messages where each message is the parent of the next one.
+// SubThreads are the children of the last message of Chain. If Chain is
+// empty, SubThreads are siblings without a common parent message.
+type ThreadData struct {
+	Chain      []uint32
+	SubThreads []ThreadData
+}
@@ -0,0 +1,172 @@
+package clamd
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/Jinnrry/pmail/utils/errors"
+)
+
+// chunkSize INSTREAM 每个数据块的大小，clamd 默认 StreamMaxLength 为 25M，这里分块发送
+const chunkSize = 64 * 1024
+
+// Client clamd 客户端，每次扫描新建一条连接，可以并发使用
+type Client struct {
+	network string
+	address string
+	timeout time.Duration
+}
+
+// Result 扫描结果
+type Result struct {
+	Infected bool
+	Virus    string // 病毒名称，比如 Eicar-Signature
+	Raw      string // clamd 原始返回
+}
+
+// New 创建客户端，address 支持以下格式：
+//
+//	tcp://127.0.0.1:3310
+//	unix:///var/run/clamav/clamd.ctl
+//	127.0.0.1:3310
+//	/var/run/clamav/clamd.ctl
+func New(address string, timeout time.Duration) (*Client, error) {
+	address = strings.TrimSpace(address)
+	if address == "" {
+		return nil, errors.New("clamd address is empty")
+	}
+	if timeout <= 0 {
+		timeout = 30 * time.Second
+	}
+
+	c := &Client{timeout: timeout}
+	switch {
+	case strings.HasPrefix(address, "tcp://"):
+		c.network, c.address = "tcp", strings.TrimPrefix(address, "tcp://")
+	case strings.HasPrefix(address, "unix://"):
+		c.network, c.address = "unix", strings.TrimPrefix(address, "unix://")
+	case strings.HasPrefix(address, "/"):
+		c.network, c.address = "unix", address
+	default:
+		c.network, c.address = "tcp", address
+	}
+	if c.address == "" {
+		return nil, errors.New("clamd address is empty")
+	}
+	return c, nil
+}
+
+func (c *Client) dial() (net.Conn, error) {
+	conn, err := net.DialTimeout(c.network, c.address, c.timeout)
+	if err != nil {
+		return nil, err
+	}
+	_ = conn.SetDeadline(time.Now().Add(c.timeout))
+	return conn, nil
+}
+
+// Ping 检查 clamd 是否可用
+func (c *Client) Ping() error {
+	conn, err := c.dial()
+	if err != nil {
+		return errors.Wrap(err)
+	}
+	defer conn.Close()
+
+	if _, err = conn.Write([]byte("zPING\x00")); err != nil {
+		return errors.Wrap(err)
+	}
+	reply, err := readReply(conn)
+	if err != nil {
+		return errors.Wrap(err)
+	}
+	if reply != "PONG" {
+		return fmt.Errorf("clamd ping unexpected reply: %s", reply)
+	}
+	return nil
+}
+
+// ScanStream 使用 INSTREAM 命令扫描数据流
+func (c *Client) ScanStream(r io.Reader) (*Result, error) {
+	conn, err := c.dial()
+	if err != nil {
+		return nil, errors.Wrap(err)
+	}
+	defer conn.Close()
+
+	if _, err = conn.Write([]byte("zINSTREAM\x00")); err != nil {
+		return nil, errors.Wrap(err)
+	}
+
+	buf := make([]byte, chunkSize)
+	size := make([]byte, 4)
+	for {
+		n, rerr := r.Read(buf)
+		if n > 0 {
+			binary.BigEndian.PutUint32(size, uint32(n))
+			if _, err = conn.Write(size); err != nil {
+				return nil, errors.Wrap(err)
+			}
+			if _, err = conn.Write(buf[:n]); err != nil {
+				return nil, errors.Wrap(err)
+			}
+		}
+		if rerr == io.EOF {
+			break
+		}
+		if rerr != nil {
+			return nil, errors.Wrap(rerr)
+		}
+	}
+	// 长度为0的块表示数据结束
+	binary.BigEndian.PutUint32(size, 0)
+	if _, err = conn.Write(size); err != nil {
+		return nil, errors.Wrap(err)
+	}
+
+	reply, err := readReply(conn)
+	if err != nil {
+		return nil, errors.Wrap(err)
+	}
+	return parseReply(reply)
+}
+
+// ScanBytes 扫描一段内存数据
+func (c *Client) ScanBytes(data []byte) (*Result, error) {
+	return c.ScanStream(bytes.NewReader(data))
+}
+
+// readReply 读取以 \0 结尾的返回
+func readReply(conn net.Conn) (string, error) {
+	reply, err := bufio.NewReader(conn).ReadString(0)
+	if err != nil && err != io.EOF {
+		return "", err
+	}
+	return strings.TrimSpace(strings.TrimRight(reply, "\x00")), nil
+}
+
+// parseReply 解析 INSTREAM 的返回，格式为：
+//
+//	stream: OK
+//	stream: Eicar-Signature FOUND
+//	INSTREAM size limit exceeded. ERROR
+func parseReply(reply string) (*Result, error) {
+	ret := &Result{Raw: reply}
+	body := strings.TrimSpace(strings.TrimPrefix(reply, "stream:"))
+	switch {
+	case strings.HasSuffix(body, "FOUND"):
+		ret.Infected = true
+		ret.Virus = strings.TrimSpace(strings.TrimSuffix(body, "FOUND"))
+		return ret, nil
+	case body == "OK":
+		return ret, nil
+	default:
+		return nil, fmt.Errorf("clamd error: %s", reply)
+	}
+}
@@ -0,0 +1,151 @@
+package clamd
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+const eicar = `X5O!P%@AP[4\PZX54(P^)7CC)7}$EICAR-STANDARD-ANTIVIRUS-TEST-FILE!$H+H*`
+
+// fakeClamd 模拟 clamd 的 PING 和 INSTREAM 命令，数据中包含 EICAR 特征时返回 FOUND
+func fakeClamd(t *testing.T, ln net.Listener) {
+	t.Helper()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func(conn net.Conn) {
+				defer conn.Close()
+				r := bufio.NewReader(conn)
+				cmd, err := r.ReadString(0)
+				if err != nil {
+					return
+				}
+				switch cmd {
+				case "zPING\x00":
+					conn.Write([]byte("PONG\x00"))
+				case "zINSTREAM\x00":
+					var data bytes.Buffer
+					size := make([]byte, 4)
+					for {
+						if _, err := io.ReadFull(r, size); err != nil {
+							return
+						}
+						n := binary.BigEndian.Uint32(size)
+						if n == 0 {
+							break
+						}
+						if _, err := io.CopyN(&data, r, int64(n)); err != nil {
+							return
+						}
+					}
+					if strings.Contains(data.String(), "EICAR-STANDARD-ANTIVIRUS-TEST-FILE") {
+						conn.Write([]byte("stream: Eicar-Signature FOUND\x00"))
+					} else {
+						conn.Write([]byte("stream: OK\x00"))
+					}
+				default:
+					conn.Write([]byte("UNKNOWN COMMAND\x00"))
+				}
+			}(conn)
+		}
+	}()
+}
+
+func TestScanStreamTCP(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	fakeClamd(t, ln)
+
+	c, err := New("tcp://"+ln.Addr().String(), time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Ping(); err != nil {
+		t.Fatalf("ping error: %v", err)
+	}
+
+	ret, err := c.ScanBytes([]byte("hello world"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ret.Infected {
+		t.Errorf("clean data reported as infected: %+v", ret)
+	}
+
+	// 大于一个数据块，确认分块发送正确
+	big := bytes.Repeat([]byte("a"), chunkSize*2+10)
+	big = append(big, []byte(eicar)...)
+	ret, err = c.ScanBytes(big)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ret.Infected || ret.Virus != "Eicar-Signature" {
+		t.Errorf("eicar not detected: %+v", ret)
+	}
+}
+
+func TestScanStreamUnix(t *testing.T) {
+	sock := filepath.Join(t.TempDir(), "clamd.sock")
+	ln, err := net.Listen("unix", sock)
+	if err != nil {
+		t.Skipf("unix socket not available: %v", err)
+	}
+	defer ln.Close()
+	fakeClamd(t, ln)
+
+	c, err := New("unix://"+sock, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ret, err := c.ScanBytes([]byte(eicar))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ret.Infected {
+		t.Errorf("eicar not detected: %+v", ret)
+	}
+}
+
+func TestParseReply(t *testing.T) {
+	if _, err := parseReply("INSTREAM size limit exceeded. ERROR"); err == nil {
+		t.Error("expected error for size limit reply")
+	}
+	ret, err := parseReply("stream: Win.Test.EICAR_HDB-1 FOUND")
+	if err != nil || !ret.Infected || ret.Virus != "Win.Test.EICAR_HDB-1" {
+		t.Errorf("unexpected result %+v %v", ret, err)
+	}
+}
+
+func TestNewAddress(t *testing.T) {
+	cases := map[string][2]string{
+		"tcp://127.0.0.1:3310":             {"tcp", "127.0.0.1:3310"},
+		"127.0.0.1:3310":                   {"tcp", "127.0.0.1:3310"},
+		"unix:///var/run/clamav/clamd.ctl": {"unix", "/var/run/clamav/clamd.ctl"},
+		"/var/run/clamav/clamd.ctl":        {"unix", "/var/run/clamav/clamd.ctl"},
+	}
+	for in, want := range cases {
+		c, err := New(in, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if c.network != want[0] || c.address != want[1] {
+			t.Errorf("%s => %s %s", in, c.network, c.address)
+		}
+	}
+	if _, err := New("", 0); err == nil {
+		t.Error("expected error for empty address")
+	}
+}
@@ -6,6 +6,8 @@ import (
 
 const (
 	LogID = "LogID"
+	// OriginUserID 通过共享邮箱访问时登录用户的id，UserID 为共享邮箱的id
+	OriginUserID = "OriginUserID"
 )
 
 type Context struct {
@@ -0,0 +1,76 @@
+package milter
+
+import (
+	"strings"
+
+	"github.com/Jinnrry/pmail/dto/parsemail"
+)
+
+// formatValue milter 返回的值不带前导空格，写回邮件时补上
+func formatValue(value string) string {
+	value = strings.ReplaceAll(value, "\r\n", "\n")
+	value = strings.ReplaceAll(value, "\n", "\r\n")
+	if value != "" && value[0] != ' ' && value[0] != '\t' {
+		value = " " + value
+	}
+	return value
+}
+
+// ApplyModifications 把 header 与 body 修改应用到原始邮件上，收发件人修改与隔离由调用方处理
+func ApplyModifications(raw []byte, mods []*Modification) []byte {
+	changed := false
+	for _, mod := range mods {
+		switch mod.Type {
+		case ModifyAddHeader, ModifyInsertHeader, ModifyChangeHeader, ModifyReplaceBody:
+			changed = true
+		}
+	}
+	if !changed {
+		return raw
+	}
+
+	headers, body := parsemail.SplitRawMessage(raw)
+	var newBody []byte
+	replaceBody := false
+	for _, mod := range mods {
+		switch mod.Type {
+		case ModifyAddHeader:
+			headers = append(headers, &parsemail.RawHeader{Name: mod.Name, Value: formatValue(mod.Value)})
+		case ModifyInsertHeader:
+			idx := int(mod.Index)
+			if idx > len(headers) {
+				idx = len(headers)
+			}
+			h := &parsemail.RawHeader{Name: mod.Name, Value: formatValue(mod.Value)}
+			headers = append(headers[:idx], append([]*parsemail.RawHeader{h}, headers[idx:]...)...)
+		case ModifyChangeHeader:
+			// Index 从 1 开始，表示同名头的第几个
+			n := 0
+			for i, h := range headers {
+				if !strings.EqualFold(h.Name, mod.Name) {
+					continue
+				}
+				n++
+				if uint32(n) != mod.Index && !(mod.Index == 0 && n == 1) {
+					continue
+				}
+				if mod.Value == "" {
+					headers = append(headers[:i], headers[i+1:]...)
+				} else {
+					h.Value = formatValue(mod.Value)
+				}
+				break
+			}
+			if n < int(mod.Index) && mod.Value != "" {
+				headers = append(headers, &parsemail.RawHeader{Name: mod.Name, Value: formatValue(mod.Value)})
+			}
+		case ModifyReplaceBody:
+			replaceBody = true
+			newBody = append(newBody, mod.Body...)
+		}
+	}
+	if replaceBody {
+		body = newBody
+	}
+	return parsemail.JoinRawMessage(headers, body)
+}
@@ -0,0 +1,533 @@
+package milter
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/Jinnrry/pmail/utils/errors"
+)
+
+// Sendmail milter 协议 v6 的 MTA 端实现
+// 协议说明：https://github.com/emersion/go-milter/blob/master/milter-protocol.txt
+
+const protocolVersion = 6
+
+// MTA -> milter 命令
+const (
+	cmdOptNeg  = 'O'
+	cmdMacro   = 'D'
+	cmdConnect = 'C'
+	cmdHelo    = 'H'
+	cmdMail    = 'M'
+	cmdRcpt    = 'R'
+	cmdData    = 'T'
+	cmdHeader  = 'L'
+	cmdEOH     = 'N'
+	cmdBody    = 'B'
+	cmdEOM     = 'E'
+	cmdAbort   = 'A'
+	cmdQuit    = 'Q'
+)
+
+// milter -> MTA 返回
+const (
+	respAccept     = 'a'
+	respContinue   = 'c'
+	respDiscard    = 'd'
+	respReject     = 'r'
+	respTempFail   = 't'
+	respReplyCode  = 'y'
+	respSkip       = 's'
+	respProgress   = 'p'
+	respQuarantine = 'q'
+	respAddHeader  = 'h'
+	respInsHeader  = 'i'
+	respChgHeader  = 'm'
+	respReplBody   = 'b'
+	respAddRcpt    = '+'
+	respDelRcpt    = '-'
+	respChgFrom    = 'e'
+)
+
+// 允许 milter 执行的修改操作
+const (
+	actAddHeaders = 1 << iota
+	actChangeBody
+	actAddRcpt
+	actDelRcpt
+	actChangeHeaders
+	actQuarantine
+	actChangeFrom
+)
+
+const allActions = actAddHeaders | actChangeBody | actAddRcpt | actDelRcpt | actChangeHeaders | actQuarantine | actChangeFrom
+
+// milter 协商时可以要求的协议选项
+const (
+	optNoConnect  = 0x1
+	optNoHelo     = 0x2
+	optNoMail     = 0x4
+	optNoRcpt     = 0x8
+	optNoBody     = 0x10
+	optNoHeaders  = 0x20
+	optNoEOH      = 0x40
+	optNoReplyHdr = 0x80
+	optNoData     = 0x200
+	optSkip       = 0x400
+	optNoReplyCon = 0x1000
+	optNoReplyHel = 0x2000
+	optNoReplyMai = 0x4000
+	optNoReplyRcp = 0x8000
+	optNoReplyDat = 0x10000
+	optNoReplyEOH = 0x40000
+	optNoReplyBod = 0x80000
+	optLeadSpace  = 0x100000
+)
+
+const allProtocol = 0x1FFFFF
+
+// 宏所属的阶段
+const (
+	StageConnect = 'C'
+	StageHelo    = 'H'
+	StageMail    = 'M'
+	StageRcpt    = 'R'
+	StageEOM     = 'E'
+)
+
+// bodyChunkSize 每个 BODY 包的最大长度
+const bodyChunkSize = 65535
+
+// Action 表示 milter 对当前阶段的处理结论
+type Action int
+
+const (
+	ActionContinue Action = iota
+	ActionAccept
+	ActionDiscard
+	ActionReject
+	ActionTempFail
+	ActionReplyCode
+)
+
+// Response milter 的返回
+type Response struct {
+	Action Action
+	Code   int    // ActionReplyCode 时有效
+	Text   string // ActionReplyCode 时的完整返回，比如 "550 5.7.1 Spam"
+}
+
+// Continue 返回结论是否允许继续处理
+func (r *Response) Continue() bool {
+	return r == nil || r.Action == ActionContinue
+}
+
+// ModifyType 邮件修改类型
+type ModifyType byte
+
+const (
+	ModifyAddHeader    ModifyType = respAddHeader
+	ModifyInsertHeader ModifyType = respInsHeader
+	ModifyChangeHeader ModifyType = respChgHeader
+	ModifyReplaceBody  ModifyType = respReplBody
+	ModifyAddRcpt      ModifyType = respAddRcpt
+	ModifyDelRcpt      ModifyType = respDelRcpt
+	ModifyChangeFrom   ModifyType = respChgFrom
+	ModifyQuarantine   ModifyType = respQuarantine
+)
+
+// Modification EOM 阶段 milter 要求的修改
+type Modification struct {
+	Type  ModifyType
+	Index uint32 // insert/change header 使用
+	Name  string // header 名称
+	Value string // header 值、收件人、发件人、隔离原因
+	Body  []byte // replace body 使用
+}
+
+// Client milter 服务地址
+type Client struct {
+	network string
+	address string
+	timeout time.Duration
+}
+
+// NewClient address 格式与 clamd 相同：tcp://host:port、unix:///path、host:port、/path
+func NewClient(address string, timeout time.Duration) (*Client, error) {
+	address = strings.TrimSpace(address)
+	if timeout <= 0 {
+		timeout = 10 * time.Second
+	}
+	c := &Client{timeout: timeout}
+	switch {
+	case strings.HasPrefix(address, "tcp://"):
+		c.network, c.address = "tcp", strings.TrimPrefix(address, "tcp://")
+	case strings.HasPrefix(address, "inet:"):
+		// postfix 风格：inet:host:port
+		c.network, c.address = "tcp", strings.TrimPrefix(address, "inet:")
+	case strings.HasPrefix(address, "unix://"):
+		c.network, c.address = "unix", strings.TrimPrefix(address, "unix://")
+	case strings.HasPrefix(address, "unix:"):
+		c.network, c.address = "unix", strings.TrimPrefix(address, "unix:")
+	case strings.HasPrefix(address, "/"):
+		c.network, c.address = "unix", address
+	default:
+		c.network, c.address = "tcp", address
+	}
+	if c.address == "" {
+		return nil, errors.New("milter address is empty")
+	}
+	return c, nil
+}
+
+// Session 一条 SMTP 连接对应一个 milter 会话
+type Session struct {
+	conn     net.Conn
+	timeout  time.Duration
+	actions  uint32
+	protocol uint32
+	// skipBody milter 返回 SKIP 后不再发送剩余的 body
+	skipBody bool
+}
+
+// NewSession 连接 milter 并完成协议协商
+func (c *Client) NewSession() (*Session, error) {
+	conn, err := net.DialTimeout(c.network, c.address, c.timeout)
+	if err != nil {
+		return nil, errors.Wrap(err)
+	}
+	s := &Session{conn: conn, timeout: c.timeout}
+	if err = s.negotiate(); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *Session) negotiate() error {
+	data := make([]byte, 12)
+	binary.BigEndian.PutUint32(data[0:], protocolVersion)
+	binary.BigEndian.PutUint32(data[4:], allActions)
+	binary.BigEndian.PutUint32(data[8:], allProtocol)
+	if err := s.write(cmdOptNeg, data); err != nil {
+		return err
+	}
+	cmd, resp, err := s.read()
+	if err != nil {
+		return err
+	}
+	if cmd != cmdOptNeg || len(resp) < 12 {
+		return fmt.Errorf("milter negotiate unexpected response %q", cmd)
+	}
+	version := binary.BigEndian.Uint32(resp[0:])
+	if version < 2 || version > protocolVersion {
+		return fmt.Errorf("milter protocol version %d not supported", version)
+	}
+	s.actions = binary.BigEndian.Uint32(resp[4:]) & allActions
+	s.protocol = binary.BigEndian.Uint32(resp[8:]) & allProtocol
+	return nil
+}
+
+func (s *Session) write(cmd byte, data []byte) error {
+	_ = s.conn.SetDeadline(time.Now().Add(s.timeout))
+	packet := make([]byte, 5+len(data))
+	binary.BigEndian.PutUint32(packet, uint32(len(data)+1))
+	packet[4] = cmd
+	copy(packet[5:], data)
+	_, err := s.conn.Write(packet)
+	if err != nil {
+		return errors.Wrap(err)
+	}
+	return nil
+}
+
+func (s *Session) read() (byte, []byte, error) {
+	_ = s.conn.SetDeadline(time.Now().Add(s.timeout))
+	head := make([]byte, 4)
+	if _, err := io.ReadFull(s.conn, head); err != nil {
+		return 0, nil, errors.Wrap(err)
+	}
+	size := binary.BigEndian.Uint32(head)
+	if size == 0 || size > 64*1024*1024 {
+		return 0, nil, fmt.Errorf("milter invalid packet size %d", size)
+	}
+	data := make([]byte, size)
+	if _, err := io.ReadFull(s.conn, data); err != nil {
+		return 0, nil, errors.Wrap(err)
+	}
+	return data[0], data[1:], nil
+}
+
+// readResponse 读取阶段结论，忽略 progress 包
+func (s *Session) readResponse() (*Response, error) {
+	for {
+		cmd, data, err := s.read()
+		if err != nil {
+			return nil, err
+		}
+		if cmd == respProgress {
+			continue
+		}
+		return parseResponse(cmd, data)
+	}
+}
+
+func parseResponse(cmd byte, data []byte) (*Response, error) {
+	switch cmd {
+	case respContinue:
+		return &Response{Action: ActionContinue}, nil
+	case respAccept:
+		return &Response{Action: ActionAccept}, nil
+	case respDiscard:
+		return &Response{Action: ActionDiscard}, nil
+	case respReject:
+		return &Response{Action: ActionReject}, nil
+	case respTempFail:
+		return &Response{Action: ActionTempFail}, nil
+	case respSkip:
+		return &Response{Action: ActionContinue}, nil
+	case respReplyCode:
+		text := string(bytes.TrimRight(data, "\x00"))
+		code := 0
+		if len(text) >= 3 {
+			fmt.Sscanf(text[:3], "%d", &code)
+		}
+		if code < 400 || code > 599 {
+			return nil, fmt.Errorf("milter invalid reply code %q", text)
+		}
+		return &Response{Action: ActionReplyCode, Code: code, Text: text}, nil
+	}
+	return nil, fmt.Errorf("milter unexpected response %q", cmd)
+}
+
+// send 发送一个阶段的命令，noSend 为真时跳过，noReply 为真时不等待结论
+func (s *Session) send(cmd byte, data []byte, noSend, noReply uint32) (*Response, error) {
+	if s.protocol&noSend != 0 {
+		return &Response{Action: ActionContinue}, nil
+	}
+	if err := s.write(cmd, data); err != nil {
+		return nil, err
+	}
+	if noReply != 0 && s.protocol&noReply != 0 {
+		return &Response{Action: ActionContinue}, nil
+	}
+	return s.readResponse()
+}
+
+// Macros 发送某个阶段使用的宏
+func (s *Session) Macros(stage byte, macros map[string]string) error {
+	if len(macros) == 0 {
+		return nil
+	}
+	data := []byte{stage}
+	for k, v := range macros {
+		data = appendCString(data, k)
+		data = appendCString(data, v)
+	}
+	return s.write(cmdMacro, data)
+}
+
+// Conn 发送连接信息，family 为 '4'、'6'、'L'(unix) 或 'U'(未知)
+func (s *Session) Conn(hostname string, family byte, port uint16, address string) (*Response, error) {
+	data := appendCString(nil, hostname)
+	data = append(data, family)
+	if family != 'U' {
+		data = binary.BigEndian.AppendUint16(data, port)
+		data = appendCString(data, address)
+	}
+	return s.send(cmdConnect, data, optNoConnect, optNoReplyCon)
+}
+
+// Helo 发送 HELO/EHLO 域名
+func (s *Session) Helo(name string) (*Response, error) {
+	return s.send(cmdHelo, appendCString(nil, name), optNoHelo, optNoReplyHel)
+}
+
+// Mail 发送 MAIL FROM，args 为 ESMTP 参数
+func (s *Session) Mail(from string, args ...string) (*Response, error) {
+	data := appendCString(nil, "<"+from+">")
+	for _, arg := range args {
+		data = appendCString(data, arg)
+	}
+	return s.send(cmdMail, data, optNoMail, optNoReplyMai)
+}
+
+// Rcpt 发送 RCPT TO
+func (s *Session) Rcpt(to string, args ...string) (*Response, error) {
+	data := appendCString(nil, "<"+to+">")
+	for _, arg := range args {
+		data = appendCString(data, arg)
+	}
+	return s.send(cmdRcpt, data, optNoRcpt, optNoReplyRcp)
+}
+
+// DataStart 发送 DATA 命令
+func (s *Session) DataStart() (*Response, error) {
+	s.skipBody = false
+	return s.send(cmdData, nil, optNoData, optNoReplyDat)
+}
+
+// Header 发送一个邮件头
+func (s *Session) Header(name, value string) (*Response, error) {
+	if s.protocol&optLeadSpace == 0 {
+		value = strings.TrimLeft(value, " \t")
+	}
+	data := appendCString(nil, name)
+	data = appendCString(data, value)
+	return s.send(cmdHeader, data, optNoHeaders, optNoReplyHdr)
+}
+
+// EndOfHeaders 邮件头发送完毕
+func (s *Session) EndOfHeaders() (*Response, error) {
+	return s.send(cmdEOH, nil, optNoEOH, optNoReplyEOH)
+}
+
+// Body 分块发送邮件正文
+func (s *Session) Body(body []byte) (*Response, error) {
+	for len(body) > 0 && !s.skipBody {
+		n := len(body)
+		if n > bodyChunkSize {
+			n = bodyChunkSize
+		}
+		if s.protocol&optNoBody != 0 {
+			return &Response{Action: ActionContinue}, nil
+		}
+		if err := s.write(cmdBody, body[:n]); err != nil {
+			return nil, err
+		}
+		body = body[n:]
+		if s.protocol&optNoReplyBod != 0 {
+			continue
+		}
+		cmd, data, err := s.read()
+		for err == nil && cmd == respProgress {
+			cmd, data, err = s.read()
+		}
+		if err != nil {
+			return nil, err
+		}
+		if cmd == respSkip && s.protocol&optSkip != 0 {
+			s.skipBody = true
+			break
+		}
+		resp, err := parseResponse(cmd, data)
+		if err != nil {
+			return nil, err
+		}
+		if !resp.Continue() {
+			return resp, nil
+		}
+	}
+	return &Response{Action: ActionContinue}, nil
+}
+
+// EndOfMessage 邮件发送完毕，返回 milter 要求的修改以及最终结论
+func (s *Session) EndOfMessage() ([]*Modification, *Response, error) {
+	if err := s.write(cmdEOM, nil); err != nil {
+		return nil, nil, err
+	}
+	var mods []*Modification
+	for {
+		cmd, data, err := s.read()
+		if err != nil {
+			return nil, nil, err
+		}
+		switch cmd {
+		case respProgress:
+			continue
+		case respAddHeader, respChgHeader, respInsHeader:
+			mod := &Modification{Type: ModifyType(cmd)}
+			if cmd != respAddHeader {
+				if len(data) < 4 {
+					return nil, nil, fmt.Errorf("milter invalid header modification")
+				}
+				mod.Index = binary.BigEndian.Uint32(data)
+				data = data[4:]
+			}
+			fields := splitCStrings(data)
+			if len(fields) < 2 {
+				return nil, nil, fmt.Errorf("milter invalid header modification")
+			}
+			mod.Name, mod.Value = fields[0], fields[1]
+			if s.allowed(mod.Type) {
+				mods = append(mods, mod)
+			}
+		case respReplBody:
+			mod := &Modification{Type: ModifyReplaceBody, Body: append([]byte{}, data...)}
+			if s.allowed(mod.Type) {
+				mods = append(mods, mod)
+			}
+		case respAddRcpt, respDelRcpt, respChgFrom, respQuarantine:
+			fields := splitCStrings(data)
+			mod := &Modification{Type: ModifyType(cmd)}
+			if len(fields) > 0 {
+				mod.Value = strings.Trim(fields[0], "<>")
+				if cmd == respQuarantine {
+					mod.Value = fields[0]
+				}
+			}
+			if s.allowed(mod.Type) {
+				mods = append(mods, mod)
+			}
+		default:
+			resp, err := parseResponse(cmd, data)
+			if err != nil {
+				return nil, nil, err
+			}
+			return mods, resp, nil
+		}
+	}
+}
+
+func (s *Session) allowed(t ModifyType) bool {
+	switch t {
+	case ModifyAddHeader, ModifyInsertHeader:
+		return s.actions&actAddHeaders != 0
+	case ModifyChangeHeader:
+		return s.actions&actChangeHeaders != 0
+	case ModifyReplaceBody:
+		return s.actions&actChangeBody != 0
+	case ModifyAddRcpt:
+		return s.actions&actAddRcpt != 0
+	case ModifyDelRcpt:
+		return s.actions&actDelRcpt != 0
+	case ModifyChangeFrom:
+		return s.actions&actChangeFrom != 0
+	case ModifyQuarantine:
+		return s.actions&actQuarantine != 0
+	}
+	return false
+}
+
+// Abort 放弃当前邮件，连接仍然可以继续处理下一封
+func (s *Session) Abort() error {
+	return s.write(cmdAbort, nil)
+}
+
+// Close 结束会话
+func (s *Session) Close() error {
+	_ = s.write(cmdQuit, nil)
+	return s.conn.Close()
+}
+
+func appendCString(dst []byte, s string) []byte {
+	dst = append(dst, s...)
+	return append(dst, 0)
+}
+
+func splitCStrings(data []byte) []string {
+	data = bytes.TrimRight(data, "\x00")
+	if len(data) == 0 {
+		return nil
+	}
+	var ret []string
+	for _, p := range bytes.Split(data, []byte{0}) {
+		ret = append(ret, string(p))
+	}
+	return ret
+}
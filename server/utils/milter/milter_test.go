@@ -0,0 +1,174 @@
+package milter
+
+import (
+	"encoding/binary"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Jinnrry/pmail/dto/parsemail"
+)
+
+func readPacket(conn net.Conn) (byte, []byte, error) {
+	head := make([]byte, 4)
+	if _, err := io.ReadFull(conn, head); err != nil {
+		return 0, nil, err
+	}
+	data := make([]byte, binary.BigEndian.Uint32(head))
+	if _, err := io.ReadFull(conn, data); err != nil {
+		return 0, nil, err
+	}
+	return data[0], data[1:], nil
+}
+
+func writePacket(conn net.Conn, cmd byte, data []byte) {
+	packet := make([]byte, 5+len(data))
+	binary.BigEndian.PutUint32(packet, uint32(len(data)+1))
+	packet[4] = cmd
+	copy(packet[5:], data)
+	conn.Write(packet)
+}
+
+// startFakeMilter 拒绝发给 spam@ 的收件人，EOM 时添加一个头并替换正文
+func startFakeMilter(t *testing.T) string {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func(conn net.Conn) {
+				defer conn.Close()
+				var body []byte
+				for {
+					cmd, data, err := readPacket(conn)
+					if err != nil {
+						return
+					}
+					switch cmd {
+					case cmdOptNeg:
+						resp := make([]byte, 12)
+						binary.BigEndian.PutUint32(resp[0:], 6)
+						binary.BigEndian.PutUint32(resp[4:], actAddHeaders|actChangeBody|actQuarantine)
+						binary.BigEndian.PutUint32(resp[8:], optNoHelo|optNoReplyHdr)
+						writePacket(conn, cmdOptNeg, resp)
+					case cmdMacro, cmdAbort, cmdHeader:
+						// 不需要回复
+					case cmdHelo:
+						t.Error("HELO should not be sent when NOHELO is negotiated")
+						return
+					case cmdRcpt:
+						if strings.Contains(string(data), "spam@") {
+							writePacket(conn, respReplyCode, []byte("550 5.7.1 No spam please\x00"))
+						} else {
+							writePacket(conn, respContinue, nil)
+						}
+					case cmdBody:
+						body = append(body, data...)
+						writePacket(conn, respContinue, nil)
+					case cmdEOM:
+						writePacket(conn, respAddHeader, []byte("X-Milter\x00checked\x00"))
+						writePacket(conn, respReplBody, []byte(strings.ToUpper(string(body))))
+						writePacket(conn, respChgFrom, []byte("<bounce@example.com>\x00"))
+						writePacket(conn, respAccept, nil)
+					case cmdQuit:
+						return
+					default:
+						writePacket(conn, respContinue, nil)
+					}
+				}
+			}(conn)
+		}
+	}()
+	return ln.Addr().String()
+}
+
+func TestSession(t *testing.T) {
+	client, err := NewClient("tcp://"+startFakeMilter(t), 2*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := client.NewSession()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	if err = s.Macros(StageConnect, map[string]string{"j": "example.com"}); err != nil {
+		t.Fatal(err)
+	}
+	resp, err := s.Conn("[127.0.0.1]", '4', 25, "127.0.0.1")
+	if err != nil || !resp.Continue() {
+		t.Fatalf("connect: %+v %v", resp, err)
+	}
+	if resp, err = s.Helo("mx.example.org"); err != nil || !resp.Continue() {
+		t.Fatalf("helo: %+v %v", resp, err)
+	}
+	if resp, err = s.Mail("a@example.org"); err != nil || !resp.Continue() {
+		t.Fatalf("mail: %+v %v", resp, err)
+	}
+	resp, err = s.Rcpt("spam@example.com")
+	if err != nil || resp.Action != ActionReplyCode || resp.Code != 550 {
+		t.Fatalf("rcpt should be rejected: %+v %v", resp, err)
+	}
+	if resp, err = s.Rcpt("user@example.com"); err != nil || !resp.Continue() {
+		t.Fatalf("rcpt: %+v %v", resp, err)
+	}
+
+	raw := []byte("Subject: test\r\nFrom: a@example.org\r\n\r\nhello world\r\n")
+	if resp, err = s.DataStart(); err != nil || !resp.Continue() {
+		t.Fatalf("data: %+v %v", resp, err)
+	}
+	headers, body := parsemail.SplitRawMessage(raw)
+	for _, h := range headers {
+		if resp, err = s.Header(h.Name, h.Value); err != nil || !resp.Continue() {
+			t.Fatalf("header: %+v %v", resp, err)
+		}
+	}
+	if resp, err = s.EndOfHeaders(); err != nil || !resp.Continue() {
+		t.Fatalf("eoh: %+v %v", resp, err)
+	}
+	if resp, err = s.Body(body); err != nil || !resp.Continue() {
+		t.Fatalf("body: %+v %v", resp, err)
+	}
+	mods, resp, err := s.EndOfMessage()
+	if err != nil || resp.Action != ActionAccept {
+		t.Fatalf("eom: %+v %v", resp, err)
+	}
+	// 未协商 CHGFROM，发件人修改应被忽略
+	if len(mods) != 2 {
+		t.Fatalf("unexpected modifications %+v", mods)
+	}
+
+	ret := string(ApplyModifications(raw, mods))
+	want := "Subject: test\r\nFrom: a@example.org\r\nX-Milter: checked\r\n\r\nHELLO WORLD\r\n"
+	if ret != want {
+		t.Errorf("ApplyModifications() = %q, want %q", ret, want)
+	}
+}
+
+func TestApplyModifications(t *testing.T) {
+	raw := []byte("Received: a\r\nX-Spam: old\r\n\tfolded\r\nX-Spam: second\r\n\r\nbody")
+	mods := []*Modification{
+		{Type: ModifyInsertHeader, Index: 0, Name: "X-First", Value: "1"},
+		{Type: ModifyChangeHeader, Index: 2, Name: "x-spam", Value: "new"},
+		{Type: ModifyChangeHeader, Index: 1, Name: "X-Spam", Value: ""},
+	}
+	ret := string(ApplyModifications(raw, mods))
+	want := "X-First: 1\r\nReceived: a\r\nX-Spam: new\r\n\r\nbody"
+	if ret != want {
+		t.Errorf("ApplyModifications() = %q, want %q", ret, want)
+	}
+
+	// 没有header与body修改时保持原样
+	if got := ApplyModifications(raw, []*Modification{{Type: ModifyQuarantine}}); string(got) != string(raw) {
+		t.Errorf("message changed without modifications")
+	}
+}
@@ -43,6 +43,16 @@ func ForwardRaw(ctx *context.Context, e *parsemail.Email, rawEmailData []byte, f
 	return forwardData(ctx, config.Instance.Domains[0], rawEmailData, forwardAddress, from)
 }
 
+// Redirect 原样投递邮件，from为信封发件人，用于别名等不修改邮件内容的转发
+func Redirect(ctx *context.Context, rawEmailData []byte, to []string, from string) (error, map[string]error) {
+	var users []*parsemail.User
+	for _, address := range to {
+		users = append(users, &parsemail.User{EmailAddress: address})
+	}
+	_, fromDomain := parsemail.BuilderUser(from).GetDomainAccount()
+	return doSend(ctx, fromDomain, rawEmailData, users, from)
+}
+
 func forwardData(ctx *context.Context, fromDomain string, data []byte, forwardAddress string, from string) error {
 	var to []*parsemail.User
 	to = []*parsemail.User{
@@ -66,6 +76,12 @@ func Send(ctx *context.Context, e *parsemail.Email) (error, map[string]error) {
 
 }
 
+// Bounce 发送退信，信封发件人为空（RFC 5321 4.5.5），对方不会再对退信退信
+func Bounce(ctx *context.Context, e *parsemail.Email) (error, map[string]error) {
+	_, fromDomain := e.From.GetDomainAccount()
+	return doSend(ctx, fromDomain, e.BuildBytes(ctx, true), e.To, "")
+}
+
 func doSend(ctx *context.Context, fromDomain string, data []byte, to []*parsemail.User, from string) (error, map[string]error) {
 
 	// 按域名整理
@@ -0,0 +1,637 @@
+package sieve
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Script 编译后的脚本，可以并发运行
+type Script struct {
+	requires map[string]bool
+	commands []command
+}
+
+type command interface {
+	exec(rt *runtime) error
+}
+
+type test interface {
+	eval(rt *runtime) (bool, error)
+}
+
+type tagSpec struct {
+	param  argKind // 带参数时参数的类型
+	hasArg bool
+	single bool // 参数必须是单个字符串
+	group  string
+	ext    string
+}
+
+var (
+	comparatorSpecs = map[string]tagSpec{
+		"comparator": {hasArg: true, param: argStrings, single: true, group: "comparator"},
+	}
+	matchTypeSpecs = map[string]tagSpec{
+		"is":       {group: "match"},
+		"contains": {group: "match"},
+		"matches":  {group: "match"},
+		"regex":    {group: "match", ext: "regex"},
+	}
+	addressPartSpecs = map[string]tagSpec{
+		"all":       {group: "address-part"},
+		"localpart": {group: "address-part"},
+		"domain":    {group: "address-part"},
+		"user":      {group: "address-part", ext: "subaddress"},
+		"detail":    {group: "address-part", ext: "subaddress"},
+	}
+	bodyTransformSpecs = map[string]tagSpec{
+		"raw":     {group: "transform"},
+		"text":    {group: "transform"},
+		"content": {hasArg: true, param: argStrings, group: "transform"},
+	}
+)
+
+func mergeSpecs(specs ...map[string]tagSpec) map[string]tagSpec {
+	ret := map[string]tagSpec{}
+	for _, s := range specs {
+		for k, v := range s {
+			ret[k] = v
+		}
+	}
+	return ret
+}
+
+type compiler struct {
+	requires map[string]bool
+}
+
+func errorf(line int, format string, args ...any) error {
+	return &Error{Line: line, Msg: fmt.Sprintf(format, args...)}
+}
+
+// Compile 解析并校验脚本
+func Compile(src string) (*Script, error) {
+	raw, err := parse(src)
+	if err != nil {
+		return nil, err
+	}
+	c := &compiler{requires: map[string]bool{}}
+	i := 0
+	for ; i < len(raw) && raw[i].name == "require"; i++ {
+		if err := c.require(raw[i]); err != nil {
+			return nil, err
+		}
+	}
+	cmds, err := c.block(raw[i:])
+	if err != nil {
+		return nil, err
+	}
+	return &Script{requires: c.requires, commands: cmds}, nil
+}
+
+func (c *compiler) require(rc *rawCommand) error {
+	if len(rc.args) != 1 || rc.args[0].kind != argStrings || rc.tests != nil || rc.block != nil {
+		return errorf(rc.line, "require expects a string list")
+	}
+	for _, ext := range rc.args[0].strs {
+		ext = strings.ToLower(ext)
+		if !extensions[ext] {
+			return errorf(rc.line, "unsupported extension %q", ext)
+		}
+		c.requires[ext] = true
+	}
+	return nil
+}
+
+func (c *compiler) need(ext string, line int) error {
+	if !c.requires[ext] {
+		return errorf(line, "missing require %q", ext)
+	}
+	return nil
+}
+
+// splitArgs 拆分标签参数与位置参数，标签必须在位置参数之前
+func (c *compiler) splitArgs(name string, line int, args []arg, specs map[string]tagSpec) (map[string]*arg, []arg, error) {
+	tags := map[string]*arg{}
+	groups := map[string]string{}
+	i := 0
+	for ; i < len(args) && args[i].kind == argTag; i++ {
+		t := args[i].tag
+		spec, ok := specs[t]
+		if !ok {
+			return nil, nil, errorf(args[i].line, "unknown tag :%s for %s", t, name)
+		}
+		if spec.ext != "" {
+			if err := c.need(spec.ext, args[i].line); err != nil {
+				return nil, nil, err
+			}
+		}
+		if _, dup := tags[t]; dup {
+			return nil, nil, errorf(args[i].line, "duplicate tag :%s", t)
+		}
+		if spec.group != "" {
+			if other, ok := groups[spec.group]; ok {
+				return nil, nil, errorf(args[i].line, "tag :%s conflicts with :%s", t, other)
+			}
+			groups[spec.group] = t
+		}
+		val := &arg{kind: argTag, tag: t, line: args[i].line}
+		if spec.hasArg {
+			i++
+			if i >= len(args) || args[i].kind != spec.param || (spec.single && len(args[i].strs) != 1) {
+				return nil, nil, errorf(line, "tag :%s of %s expects an argument", t, name)
+			}
+			p := args[i]
+			val = &p
+		}
+		tags[t] = val
+	}
+	pos := args[i:]
+	for _, a := range pos {
+		if a.kind == argTag {
+			return nil, nil, errorf(a.line, "unexpected tag :%s for %s", a.tag, name)
+		}
+	}
+	return tags, pos, nil
+}
+
+type posKind int
+
+const (
+	posString posKind = iota
+	posStringList
+	posNumber
+)
+
+func expectArgs(name string, line int, pos []arg, kinds ...posKind) error {
+	if len(pos) != len(kinds) {
+		return errorf(line, "%s expects %d arguments but got %d", name, len(kinds), len(pos))
+	}
+	for i, k := range kinds {
+		switch k {
+		case posNumber:
+			if pos[i].kind != argNumber {
+				return errorf(pos[i].line, "%s expects a number", name)
+			}
+		case posString:
+			if pos[i].kind != argStrings || len(pos[i].strs) != 1 {
+				return errorf(pos[i].line, "%s expects a string", name)
+			}
+		case posStringList:
+			if pos[i].kind != argStrings {
+				return errorf(pos[i].line, "%s expects a string list", name)
+			}
+		}
+	}
+	return nil
+}
+
+func (c *compiler) matcher(tags map[string]*arg, keys []string, line int) (*matcher, error) {
+	m := &matcher{comparator: "i;ascii-casemap", matchType: "is"}
+	for _, mt := range []string{"is", "contains", "matches", "regex"} {
+		if tags[mt] != nil {
+			m.matchType = mt
+		}
+	}
+	if a := tags["comparator"]; a != nil {
+		m.comparator = strings.ToLower(a.strs[0])
+		switch m.comparator {
+		case "i;octet", "i;ascii-casemap":
+		case "i;ascii-numeric":
+			if err := c.need("comparator-i;ascii-numeric", line); err != nil {
+				return nil, err
+			}
+			if m.matchType != "is" {
+				return nil, errorf(line, "comparator i;ascii-numeric only supports :is")
+			}
+		default:
+			return nil, errorf(line, "unsupported comparator %q", m.comparator)
+		}
+	}
+	if m.matchType == "regex" && !c.requires["variables"] {
+		for _, k := range keys {
+			if _, err := regexp.Compile(k); err != nil {
+				return nil, errorf(line, "invalid regex %q", k)
+			}
+		}
+	}
+	return m, nil
+}
+
+func addressPart(tags map[string]*arg) string {
+	for _, p := range []string{"localpart", "domain", "user", "detail"} {
+		if tags[p] != nil {
+			return p
+		}
+	}
+	return "all"
+}
+
+func (c *compiler) block(raw []*rawCommand) ([]command, error) {
+	var ret []command
+	for i := 0; i < len(raw); i++ {
+		rc := raw[i]
+		switch rc.name {
+		case "if":
+			ic := &ifCommand{}
+			b, err := c.branch(rc)
+			if err != nil {
+				return nil, err
+			}
+			ic.branches = append(ic.branches, b)
+			for i+1 < len(raw) && (raw[i+1].name == "elsif" || raw[i+1].name == "else") {
+				i++
+				if raw[i].name == "elsif" {
+					b, err := c.branch(raw[i])
+					if err != nil {
+						return nil, err
+					}
+					ic.branches = append(ic.branches, b)
+					continue
+				}
+				if len(raw[i].args) > 0 || raw[i].tests != nil || raw[i].block == nil {
+					return nil, errorf(raw[i].line, "else expects a block")
+				}
+				ic.elseBlock, err = c.block(raw[i].block)
+				if err != nil {
+					return nil, err
+				}
+				break
+			}
+			ret = append(ret, ic)
+		case "elsif", "else":
+			return nil, errorf(rc.line, "%s without if", rc.name)
+		case "require":
+			return nil, errorf(rc.line, "require must come before other commands")
+		default:
+			if rc.block != nil {
+				return nil, errorf(rc.line, "%s does not take a block", rc.name)
+			}
+			if rc.tests != nil {
+				return nil, errorf(rc.line, "%s does not take a test", rc.name)
+			}
+			cmd, err := c.command(rc)
+			if err != nil {
+				return nil, err
+			}
+			ret = append(ret, cmd)
+		}
+	}
+	return ret, nil
+}
+
+func (c *compiler) branch(rc *rawCommand) (*ifBranch, error) {
+	if len(rc.args) > 0 || len(rc.tests) != 1 || rc.block == nil {
+		return nil, errorf(rc.line, "%s expects a test and a block", rc.name)
+	}
+	t, err := c.test(rc.tests[0])
+	if err != nil {
+		return nil, err
+	}
+	block, err := c.block(rc.block)
+	if err != nil {
+		return nil, err
+	}
+	return &ifBranch{test: t, block: block}, nil
+}
+
+func (c *compiler) flagsTag(tags map[string]*arg) ([]string, bool) {
+	if a := tags["flags"]; a != nil {
+		return a.strs, true
+	}
+	return nil, false
+}
+
+func (c *compiler) command(rc *rawCommand) (command, error) {
+	name, line := rc.name, rc.line
+	switch name {
+	case "stop":
+		return &stopCommand{}, expectArgs(name, line, rc.args)
+	case "discard":
+		return &discardCommand{}, expectArgs(name, line, rc.args)
+	case "keep":
+		tags, pos, err := c.splitArgs(name, line, rc.args, map[string]tagSpec{
+			"flags": {hasArg: true, param: argStrings, ext: "imap4flags"},
+		})
+		if err != nil {
+			return nil, err
+		}
+		cmd := &keepCommand{}
+		cmd.flags, cmd.hasFlags = c.flagsTag(tags)
+		return cmd, expectArgs(name, line, pos)
+	case "redirect":
+		tags, pos, err := c.splitArgs(name, line, rc.args, map[string]tagSpec{
+			"copy": {ext: "copy"},
+		})
+		if err != nil {
+			return nil, err
+		}
+		if err := expectArgs(name, line, pos, posString); err != nil {
+			return nil, err
+		}
+		return &redirectCommand{address: pos[0].strs[0], copy: tags["copy"] != nil}, nil
+	case "fileinto":
+		if err := c.need("fileinto", line); err != nil {
+			return nil, err
+		}
+		tags, pos, err := c.splitArgs(name, line, rc.args, map[string]tagSpec{
+			"copy":   {ext: "copy"},
+			"flags":  {hasArg: true, param: argStrings, ext: "imap4flags"},
+			"create": {ext: "mailbox"},
+		})
+		if err != nil {
+			return nil, err
+		}
+		if err := expectArgs(name, line, pos, posString); err != nil {
+			return nil, err
+		}
+		cmd := &fileintoCommand{mailbox: pos[0].strs[0], copy: tags["copy"] != nil, create: tags["create"] != nil}
+		cmd.flags, cmd.hasFlags = c.flagsTag(tags)
+		return cmd, nil
+	case "reject", "ereject":
+		if err := c.need(name, line); err != nil {
+			return nil, err
+		}
+		if err := expectArgs(name, line, rc.args, posString); err != nil {
+			return nil, err
+		}
+		return &rejectCommand{reason: rc.args[0].strs[0], extended: name == "ereject"}, nil
+	case "vacation":
+		return c.vacation(rc)
+	case "set":
+		return c.set(rc)
+	case "setflag", "addflag", "removeflag":
+		if err := c.need("imap4flags", line); err != nil {
+			return nil, err
+		}
+		cmd := &flagCommand{action: name}
+		switch len(rc.args) {
+		case 2:
+			if err := expectArgs(name, line, rc.args, posString, posStringList); err != nil {
+				return nil, err
+			}
+			cmd.variable = strings.ToLower(rc.args[0].strs[0])
+			if !validVariableName(cmd.variable) {
+				return nil, errorf(line, "invalid variable name %q", cmd.variable)
+			}
+			cmd.flags = rc.args[1].strs
+		default:
+			if err := expectArgs(name, line, rc.args, posStringList); err != nil {
+				return nil, err
+			}
+			cmd.flags = rc.args[0].strs
+		}
+		return cmd, nil
+	}
+	return nil, errorf(line, "unknown command %s", name)
+}
+
+func (c *compiler) vacation(rc *rawCommand) (command, error) {
+	name, line := rc.name, rc.line
+	if err := c.need("vacation", line); err != nil {
+		return nil, err
+	}
+	tags, pos, err := c.splitArgs(name, line, rc.args, map[string]tagSpec{
+		"days":      {hasArg: true, param: argNumber},
+		"subject":   {hasArg: true, param: argStrings, single: true},
+		"from":      {hasArg: true, param: argStrings, single: true},
+		"addresses": {hasArg: true, param: argStrings},
+		"mime":      {},
+		"handle":    {hasArg: true, param: argStrings, single: true},
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := expectArgs(name, line, pos, posString); err != nil {
+		return nil, err
+	}
+	cmd := &vacationCommand{days: 7, reason: pos[0].strs[0], mime: tags["mime"] != nil}
+	if a := tags["days"]; a != nil {
+		cmd.days = int(a.num)
+	}
+	if a := tags["subject"]; a != nil {
+		cmd.subject = a.strs[0]
+	}
+	if a := tags["from"]; a != nil {
+		cmd.from = a.strs[0]
+	}
+	if a := tags["addresses"]; a != nil {
+		cmd.addresses = a.strs
+	}
+	if a := tags["handle"]; a != nil {
+		cmd.handle = a.strs[0]
+	}
+	return cmd, nil
+}
+
+// setModifiers 按优先级从高到低排列
+var setModifiers = map[string]int{
+	"lower":         40,
+	"upper":         40,
+	"lowerfirst":    30,
+	"upperfirst":    30,
+	"quotewildcard": 20,
+	"length":        10,
+}
+
+func (c *compiler) set(rc *rawCommand) (command, error) {
+	name, line := rc.name, rc.line
+	if err := c.need("variables", line); err != nil {
+		return nil, err
+	}
+	cmd := &setCommand{}
+	levels := map[int]string{}
+	i := 0
+	for ; i < len(rc.args) && rc.args[i].kind == argTag; i++ {
+		m := rc.args[i].tag
+		level, ok := setModifiers[m]
+		if !ok {
+			return nil, errorf(line, "unknown modifier :%s", m)
+		}
+		if other, ok := levels[level]; ok {
+			return nil, errorf(line, "modifier :%s conflicts with :%s", m, other)
+		}
+		levels[level] = m
+	}
+	for _, level := range []int{40, 30, 20, 10} {
+		if m, ok := levels[level]; ok {
+			cmd.modifiers = append(cmd.modifiers, m)
+		}
+	}
+	if err := expectArgs(name, line, rc.args[i:], posString, posString); err != nil {
+		return nil, err
+	}
+	cmd.name = strings.ToLower(rc.args[i].strs[0])
+	if !validVariableName(cmd.name) {
+		return nil, errorf(line, "invalid variable name %q", cmd.name)
+	}
+	cmd.value = rc.args[i+1].strs[0]
+	return cmd, nil
+}
+
+func validVariableName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for i := 0; i < len(name); i++ {
+		if !isIdentChar(name[i], i == 0) {
+			return false
+		}
+	}
+	return true
+}
+
+func (c *compiler) tests(raw []*rawTest) ([]test, error) {
+	var ret []test
+	for _, r := range raw {
+		t, err := c.test(r)
+		if err != nil {
+			return nil, err
+		}
+		ret = append(ret, t)
+	}
+	return ret, nil
+}
+
+func (c *compiler) test(rt *rawTest) (test, error) {
+	name, line := rt.name, rt.line
+	switch name {
+	case "true", "false":
+		if len(rt.args) > 0 || rt.tests != nil {
+			return nil, errorf(line, "%s takes no arguments", name)
+		}
+		return constTest(name == "true"), nil
+	case "not":
+		if len(rt.args) > 0 || len(rt.tests) != 1 {
+			return nil, errorf(line, "not expects one test")
+		}
+		t, err := c.test(rt.tests[0])
+		return &notTest{test: t}, err
+	case "allof", "anyof":
+		if len(rt.args) > 0 || len(rt.tests) == 0 {
+			return nil, errorf(line, "%s expects a test list", name)
+		}
+		tests, err := c.tests(rt.tests)
+		return &listTest{all: name == "allof", tests: tests}, err
+	}
+	if rt.tests != nil {
+		return nil, errorf(line, "%s does not take a test", name)
+	}
+
+	switch name {
+	case "address", "envelope":
+		if name == "envelope" {
+			if err := c.need("envelope", line); err != nil {
+				return nil, err
+			}
+		}
+		tags, pos, err := c.splitArgs(name, line, rt.args, mergeSpecs(comparatorSpecs, matchTypeSpecs, addressPartSpecs))
+		if err != nil {
+			return nil, err
+		}
+		if err := expectArgs(name, line, pos, posStringList, posStringList); err != nil {
+			return nil, err
+		}
+		m, err := c.matcher(tags, pos[1].strs, line)
+		if err != nil {
+			return nil, err
+		}
+		t := &addressTest{envelope: name == "envelope", matcher: m, part: addressPart(tags), headers: pos[0].strs, keys: pos[1].strs}
+		if t.envelope {
+			for _, p := range t.headers {
+				if p = strings.ToLower(p); p != "from" && p != "to" {
+					return nil, errorf(line, "unsupported envelope part %q", p)
+				}
+			}
+		}
+		return t, nil
+	case "header", "string":
+		if name == "string" {
+			if err := c.need("variables", line); err != nil {
+				return nil, err
+			}
+		}
+		tags, pos, err := c.splitArgs(name, line, rt.args, mergeSpecs(comparatorSpecs, matchTypeSpecs))
+		if err != nil {
+			return nil, err
+		}
+		if err := expectArgs(name, line, pos, posStringList, posStringList); err != nil {
+			return nil, err
+		}
+		m, err := c.matcher(tags, pos[1].strs, line)
+		if err != nil {
+			return nil, err
+		}
+		return &headerTest{str: name == "string", matcher: m, sources: pos[0].strs, keys: pos[1].strs}, nil
+	case "exists":
+		if err := expectArgs(name, line, rt.args, posStringList); err != nil {
+			return nil, err
+		}
+		return &existsTest{headers: rt.args[0].strs}, nil
+	case "size":
+		tags, pos, err := c.splitArgs(name, line, rt.args, map[string]tagSpec{
+			"over":  {group: "size"},
+			"under": {group: "size"},
+		})
+		if err != nil {
+			return nil, err
+		}
+		if len(tags) != 1 {
+			return nil, errorf(line, "size expects :over or :under")
+		}
+		if err := expectArgs(name, line, pos, posNumber); err != nil {
+			return nil, err
+		}
+		return &sizeTest{over: tags["over"] != nil, limit: pos[0].num}, nil
+	case "body":
+		if err := c.need("body", line); err != nil {
+			return nil, err
+		}
+		tags, pos, err := c.splitArgs(name, line, rt.args, mergeSpecs(comparatorSpecs, matchTypeSpecs, bodyTransformSpecs))
+		if err != nil {
+			return nil, err
+		}
+		if err := expectArgs(name, line, pos, posStringList); err != nil {
+			return nil, err
+		}
+		m, err := c.matcher(tags, pos[0].strs, line)
+		if err != nil {
+			return nil, err
+		}
+		t := &bodyTest{matcher: m, transform: "text", keys: pos[0].strs}
+		if tags["raw"] != nil {
+			t.transform = "raw"
+		} else if a := tags["content"]; a != nil {
+			t.transform, t.contentTypes = "content", a.strs
+		}
+		return t, nil
+	case "hasflag":
+		if err := c.need("imap4flags", line); err != nil {
+			return nil, err
+		}
+		tags, pos, err := c.splitArgs(name, line, rt.args, mergeSpecs(comparatorSpecs, matchTypeSpecs))
+		if err != nil {
+			return nil, err
+		}
+		t := &hasflagTest{}
+		switch len(pos) {
+		case 2:
+			if err := expectArgs(name, line, pos, posStringList, posStringList); err != nil {
+				return nil, err
+			}
+			for _, v := range pos[0].strs {
+				t.variables = append(t.variables, strings.ToLower(v))
+			}
+			t.flags = pos[1].strs
+		default:
+			if err := expectArgs(name, line, pos, posStringList); err != nil {
+				return nil, err
+			}
+			t.flags = pos[0].strs
+		}
+		t.matcher, err = c.matcher(tags, t.flags, line)
+		return t, err
+	}
+	return nil, errorf(line, "unknown test %s", name)
+}
@@ -0,0 +1,566 @@
+package sieve
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/mail"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+type runtime struct {
+	script       *Script
+	msg          Message
+	opts         Options
+	vars         map[string]string
+	matchVars    []string
+	flags        []string
+	res          *Result
+	cancelKeep   bool
+	explicitKeep bool
+	stopped      bool
+}
+
+// Run 对邮件执行脚本。运行出错时返回隐式保存的结果与错误，调用方应按保存处理
+func (s *Script) Run(msg Message, opts *Options) (*Result, error) {
+	rt := &runtime{script: s, msg: msg, vars: map[string]string{}, res: &Result{}}
+	if opts != nil {
+		rt.opts = *opts
+	}
+	if rt.opts.SubaddressSeparator == "" {
+		rt.opts.SubaddressSeparator = "+"
+	}
+	if rt.opts.MaxRedirects <= 0 {
+		rt.opts.MaxRedirects = 5
+	}
+
+	if err := rt.run(s.commands); err != nil {
+		return &Result{Keep: &Keep{}}, err
+	}
+	res := rt.res
+	if res.Reject != nil && (rt.explicitKeep || len(res.FileInto) > 0 || len(res.Redirects) > 0 || res.Vacation != nil) {
+		return &Result{Keep: &Keep{}}, errors.New("reject cannot be combined with keep, fileinto, redirect or vacation")
+	}
+	if !rt.cancelKeep && res.Keep == nil {
+		res.Keep = &Keep{Flags: rt.flags}
+	}
+	return res, nil
+}
+
+func (rt *runtime) run(cmds []command) error {
+	for _, cmd := range cmds {
+		if rt.stopped {
+			return nil
+		}
+		if err := cmd.exec(rt); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// expand 展开 ${name} 与 ${0}..${9}，只有 require variables 时生效
+func (rt *runtime) expand(s string) string {
+	if !rt.script.requires["variables"] || !strings.Contains(s, "${") {
+		return s
+	}
+	var sb strings.Builder
+	for {
+		start := strings.Index(s, "${")
+		if start < 0 {
+			sb.WriteString(s)
+			return sb.String()
+		}
+		end := strings.IndexByte(s[start:], '}')
+		if end < 0 {
+			sb.WriteString(s)
+			return sb.String()
+		}
+		name := strings.ToLower(s[start+2 : start+end])
+		sb.WriteString(s[:start])
+		if n, err := strconv.Atoi(name); err == nil && name != "" && name[0] >= '0' && name[0] <= '9' {
+			if n < len(rt.matchVars) {
+				sb.WriteString(rt.matchVars[n])
+			}
+		} else if validVariableName(name) {
+			sb.WriteString(rt.vars[name])
+		} else {
+			// 不是合法的变量名时原样保留
+			sb.WriteString(s[start : start+end+1])
+		}
+		s = s[start+end+1:]
+	}
+}
+
+func (rt *runtime) expandList(list []string) []string {
+	ret := make([]string, len(list))
+	for i, s := range list {
+		ret[i] = rt.expand(s)
+	}
+	return ret
+}
+
+// matchAny 任意一个值匹配任意一个key即为真，:matches 与 :regex 成功时更新匹配变量
+func (rt *runtime) matchAny(m *matcher, values, keys []string) (bool, error) {
+	keys = rt.expandList(keys)
+	for _, v := range values {
+		for _, k := range keys {
+			ok, caps, err := m.match(v, k)
+			if err != nil {
+				return false, err
+			}
+			if ok {
+				if m.matchType == "matches" || m.matchType == "regex" {
+					if len(caps) > 10 {
+						caps = caps[:10]
+					}
+					rt.matchVars = caps
+				}
+				return true, nil
+			}
+		}
+	}
+	return false, nil
+}
+
+// normalizeFlags 拆分空格分隔的标记并去重，忽略大小写
+func normalizeFlags(list []string) []string {
+	var ret []string
+	seen := map[string]bool{}
+	for _, s := range list {
+		for _, f := range strings.Fields(s) {
+			key := asciiLower(f)
+			if !seen[key] {
+				seen[key] = true
+				ret = append(ret, f)
+			}
+		}
+	}
+	return ret
+}
+
+func (rt *runtime) flagsOf(variable string) []string {
+	if variable == "" {
+		return rt.flags
+	}
+	return normalizeFlags([]string{rt.vars[variable]})
+}
+
+func (rt *runtime) setFlags(variable string, flags []string) {
+	if variable == "" {
+		rt.flags = flags
+		return
+	}
+	rt.vars[variable] = strings.Join(flags, " ")
+}
+
+type ifBranch struct {
+	test  test
+	block []command
+}
+
+type ifCommand struct {
+	branches  []*ifBranch
+	elseBlock []command
+}
+
+func (c *ifCommand) exec(rt *runtime) error {
+	for _, b := range c.branches {
+		ok, err := b.test.eval(rt)
+		if err != nil {
+			return err
+		}
+		if ok {
+			return rt.run(b.block)
+		}
+	}
+	return rt.run(c.elseBlock)
+}
+
+type stopCommand struct{}
+
+func (c *stopCommand) exec(rt *runtime) error {
+	rt.stopped = true
+	return nil
+}
+
+type keepCommand struct {
+	flags    []string
+	hasFlags bool
+}
+
+func (c *keepCommand) exec(rt *runtime) error {
+	flags := rt.flags
+	if c.hasFlags {
+		flags = normalizeFlags(rt.expandList(c.flags))
+	}
+	rt.explicitKeep = true
+	rt.res.Keep = &Keep{Flags: flags}
+	return nil
+}
+
+type discardCommand struct{}
+
+func (c *discardCommand) exec(rt *runtime) error {
+	rt.cancelKeep = true
+	rt.res.Discard = true
+	return nil
+}
+
+type redirectCommand struct {
+	address string
+	copy    bool
+}
+
+func (c *redirectCommand) exec(rt *runtime) error {
+	address := strings.TrimSpace(rt.expand(c.address))
+	addr, err := mail.ParseAddress(address)
+	if err != nil {
+		return fmt.Errorf("redirect: invalid address %q", address)
+	}
+	if !c.copy {
+		rt.cancelKeep = true
+	}
+	for _, r := range rt.res.Redirects {
+		if strings.EqualFold(r.Address, addr.Address) {
+			return nil
+		}
+	}
+	if len(rt.res.Redirects) >= rt.opts.MaxRedirects {
+		return errors.New("too many redirects")
+	}
+	rt.res.Redirects = append(rt.res.Redirects, &Redirect{Address: addr.Address})
+	return nil
+}
+
+type fileintoCommand struct {
+	mailbox  string
+	copy     bool
+	create   bool
+	flags    []string
+	hasFlags bool
+}
+
+func (c *fileintoCommand) exec(rt *runtime) error {
+	mailbox := rt.expand(c.mailbox)
+	if mailbox == "" {
+		return errors.New("fileinto: empty mailbox name")
+	}
+	flags := rt.flags
+	if c.hasFlags {
+		flags = normalizeFlags(rt.expandList(c.flags))
+	}
+	if !c.copy {
+		rt.cancelKeep = true
+	}
+	for _, f := range rt.res.FileInto {
+		if f.Mailbox == mailbox {
+			f.Flags = flags
+			f.Create = f.Create || c.create
+			return nil
+		}
+	}
+	rt.res.FileInto = append(rt.res.FileInto, &FileInto{Mailbox: mailbox, Flags: flags, Create: c.create})
+	return nil
+}
+
+type rejectCommand struct {
+	reason   string
+	extended bool
+}
+
+func (c *rejectCommand) exec(rt *runtime) error {
+	if rt.res.Reject != nil {
+		return errors.New("reject used more than once")
+	}
+	rt.cancelKeep = true
+	rt.res.Reject = &Reject{Reason: rt.expand(c.reason), Extended: c.extended}
+	return nil
+}
+
+type vacationCommand struct {
+	days      int
+	subject   string
+	from      string
+	addresses []string
+	mime      bool
+	handle    string
+	reason    string
+}
+
+func (c *vacationCommand) exec(rt *runtime) error {
+	if rt.res.Vacation != nil {
+		return errors.New("vacation used more than once")
+	}
+	days := c.days
+	if days < 1 {
+		days = 1
+	}
+	if days > 365 {
+		days = 365
+	}
+	v := &Vacation{
+		Days:      days,
+		Subject:   rt.expand(c.subject),
+		From:      rt.expand(c.from),
+		Addresses: rt.expandList(c.addresses),
+		Mime:      c.mime,
+		Handle:    rt.expand(c.handle),
+		Reason:    rt.expand(c.reason),
+	}
+	if v.Handle == "" {
+		// 没有 :handle 时用回复内容区分不同的 vacation 动作
+		sum := sha1.Sum([]byte(c.subject + "\x00" + c.reason))
+		v.Handle = hex.EncodeToString(sum[:8])
+	}
+	rt.res.Vacation = v
+	return nil
+}
+
+type setCommand struct {
+	modifiers []string
+	name      string
+	value     string
+}
+
+func (c *setCommand) exec(rt *runtime) error {
+	value := rt.expand(c.value)
+	for _, m := range c.modifiers {
+		switch m {
+		case "lower":
+			value = strings.ToLower(value)
+		case "upper":
+			value = strings.ToUpper(value)
+		case "lowerfirst", "upperfirst":
+			r, size := utf8.DecodeRuneInString(value)
+			if size > 0 {
+				if m == "lowerfirst" {
+					r = unicode.ToLower(r)
+				} else {
+					r = unicode.ToUpper(r)
+				}
+				value = string(r) + value[size:]
+			}
+		case "quotewildcard":
+			value = quoteWildcard(value)
+		case "length":
+			value = strconv.Itoa(utf8.RuneCountInString(value))
+		}
+	}
+	rt.vars[c.name] = value
+	return nil
+}
+
+type flagCommand struct {
+	action   string
+	variable string
+	flags    []string
+}
+
+func (c *flagCommand) exec(rt *runtime) error {
+	flags := normalizeFlags(rt.expandList(c.flags))
+	switch c.action {
+	case "setflag":
+		rt.setFlags(c.variable, flags)
+	case "addflag":
+		rt.setFlags(c.variable, normalizeFlags(append(append([]string{}, rt.flagsOf(c.variable)...), flags...)))
+	case "removeflag":
+		remove := map[string]bool{}
+		for _, f := range flags {
+			remove[asciiLower(f)] = true
+		}
+		var ret []string
+		for _, f := range rt.flagsOf(c.variable) {
+			if !remove[asciiLower(f)] {
+				ret = append(ret, f)
+			}
+		}
+		rt.setFlags(c.variable, ret)
+	}
+	return nil
+}
+
+type constTest bool
+
+func (t constTest) eval(rt *runtime) (bool, error) {
+	return bool(t), nil
+}
+
+type notTest struct {
+	test test
+}
+
+func (t *notTest) eval(rt *runtime) (bool, error) {
+	ok, err := t.test.eval(rt)
+	return !ok, err
+}
+
+type listTest struct {
+	all   bool
+	tests []test
+}
+
+func (t *listTest) eval(rt *runtime) (bool, error) {
+	for _, sub := range t.tests {
+		ok, err := sub.eval(rt)
+		if err != nil {
+			return false, err
+		}
+		if ok != t.all {
+			return ok, nil
+		}
+	}
+	return t.all, nil
+}
+
+type addressTest struct {
+	envelope bool
+	matcher  *matcher
+	part     string
+	headers  []string
+	keys     []string
+}
+
+// parseAddresses 解析地址头，无法解析时把整个值当作一个地址
+func parseAddresses(value string) []string {
+	list, err := mail.ParseAddressList(value)
+	if err != nil {
+		return []string{strings.Trim(strings.TrimSpace(value), "<>")}
+	}
+	var ret []string
+	for _, a := range list {
+		ret = append(ret, a.Address)
+	}
+	return ret
+}
+
+// addressPartOf 取地址的指定部分，:detail 在没有子地址时返回false
+func (rt *runtime) addressPartOf(address, part string) (string, bool) {
+	if part == "all" {
+		return address, true
+	}
+	local, domain := address, ""
+	if idx := strings.LastIndexByte(address, '@'); idx >= 0 {
+		local, domain = address[:idx], address[idx+1:]
+	}
+	switch part {
+	case "localpart":
+		return local, true
+	case "domain":
+		return domain, true
+	}
+	idx := strings.IndexAny(local, rt.opts.SubaddressSeparator)
+	switch part {
+	case "user":
+		if idx < 0 {
+			return local, true
+		}
+		return local[:idx], true
+	case "detail":
+		if idx < 0 {
+			return "", false
+		}
+		return local[idx+1:], true
+	}
+	return address, true
+}
+
+func (t *addressTest) eval(rt *runtime) (bool, error) {
+	var values []string
+	for _, h := range rt.expandList(t.headers) {
+		if t.envelope {
+			for _, v := range rt.msg.Envelope(strings.ToLower(h)) {
+				if p, ok := rt.addressPartOf(v, t.part); ok {
+					values = append(values, p)
+				}
+			}
+			continue
+		}
+		for _, hv := range rt.msg.Header(h) {
+			for _, v := range parseAddresses(hv) {
+				if p, ok := rt.addressPartOf(v, t.part); ok {
+					values = append(values, p)
+				}
+			}
+		}
+	}
+	return rt.matchAny(t.matcher, values, t.keys)
+}
+
+type headerTest struct {
+	str     bool
+	matcher *matcher
+	sources []string
+	keys    []string
+}
+
+func (t *headerTest) eval(rt *runtime) (bool, error) {
+	var values []string
+	if t.str {
+		values = rt.expandList(t.sources)
+	} else {
+		for _, h := range rt.expandList(t.sources) {
+			values = append(values, rt.msg.Header(h)...)
+		}
+	}
+	return rt.matchAny(t.matcher, values, t.keys)
+}
+
+type existsTest struct {
+	headers []string
+}
+
+func (t *existsTest) eval(rt *runtime) (bool, error) {
+	for _, h := range rt.expandList(t.headers) {
+		if len(rt.msg.Header(h)) == 0 {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+type sizeTest struct {
+	over  bool
+	limit int64
+}
+
+func (t *sizeTest) eval(rt *runtime) (bool, error) {
+	if t.over {
+		return rt.msg.Size() > t.limit, nil
+	}
+	return rt.msg.Size() < t.limit, nil
+}
+
+type bodyTest struct {
+	matcher      *matcher
+	transform    string
+	contentTypes []string
+	keys         []string
+}
+
+func (t *bodyTest) eval(rt *runtime) (bool, error) {
+	values := rt.msg.Body(t.transform, rt.expandList(t.contentTypes))
+	return rt.matchAny(t.matcher, values, t.keys)
+}
+
+type hasflagTest struct {
+	matcher   *matcher
+	variables []string
+	flags     []string
+}
+
+func (t *hasflagTest) eval(rt *runtime) (bool, error) {
+	var values []string
+	if len(t.variables) == 0 {
+		values = rt.flags
+	} else {
+		for _, v := range t.variables {
+			values = append(values, rt.flagsOf(v)...)
+		}
+	}
+	return rt.matchAny(t.matcher, values, normalizeFlags(rt.expandList(t.flags)))
+}
@@ -0,0 +1,255 @@
+package sieve
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+type tokenType int
+
+const (
+	tokEOF tokenType = iota
+	tokIdent
+	tokTag
+	tokNumber
+	tokString
+	tokLBracket
+	tokRBracket
+	tokLParen
+	tokRParen
+	tokLBrace
+	tokRBrace
+	tokComma
+	tokSemicolon
+)
+
+type token struct {
+	typ  tokenType
+	text string
+	num  int64
+	line int
+}
+
+func (t token) String() string {
+	switch t.typ {
+	case tokEOF:
+		return "end of script"
+	case tokString:
+		return strconv.Quote(t.text)
+	case tokTag:
+		return ":" + t.text
+	}
+	return t.text
+}
+
+// Error 脚本语法或者语义错误，包含出错的行号
+type Error struct {
+	Line int
+	Msg  string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("line %d: %s", e.Line, e.Msg)
+}
+
+type lexer struct {
+	src  string
+	pos  int
+	line int
+}
+
+func (l *lexer) errorf(format string, args ...any) error {
+	return &Error{Line: l.line, Msg: fmt.Sprintf(format, args...)}
+}
+
+// skip 跳过空白与注释
+func (l *lexer) skip() error {
+	for l.pos < len(l.src) {
+		c := l.src[l.pos]
+		switch {
+		case c == '\n':
+			l.line++
+			l.pos++
+		case c == ' ' || c == '\t' || c == '\r':
+			l.pos++
+		case c == '#':
+			for l.pos < len(l.src) && l.src[l.pos] != '\n' {
+				l.pos++
+			}
+		case c == '/' && strings.HasPrefix(l.src[l.pos:], "/*"):
+			end := strings.Index(l.src[l.pos+2:], "*/")
+			if end < 0 {
+				return l.errorf("unterminated comment")
+			}
+			l.line += strings.Count(l.src[l.pos:l.pos+2+end], "\n")
+			l.pos += end + 4
+		default:
+			return nil
+		}
+	}
+	return nil
+}
+
+func isIdentChar(c byte, first bool) bool {
+	if c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') {
+		return true
+	}
+	return !first && c >= '0' && c <= '9'
+}
+
+func (l *lexer) next() (token, error) {
+	if err := l.skip(); err != nil {
+		return token{}, err
+	}
+	if l.pos >= len(l.src) {
+		return token{typ: tokEOF, line: l.line}, nil
+	}
+	line := l.line
+	c := l.src[l.pos]
+	single := map[byte]tokenType{
+		'[': tokLBracket, ']': tokRBracket, '(': tokLParen, ')': tokRParen,
+		'{': tokLBrace, '}': tokRBrace, ',': tokComma, ';': tokSemicolon,
+	}
+	if typ, ok := single[c]; ok {
+		l.pos++
+		return token{typ: typ, text: string(c), line: line}, nil
+	}
+
+	switch {
+	case c == '"':
+		s, err := l.quoted()
+		return token{typ: tokString, text: s, line: line}, err
+	case c == ':':
+		l.pos++
+		start := l.pos
+		for l.pos < len(l.src) && isIdentChar(l.src[l.pos], l.pos == start) {
+			l.pos++
+		}
+		if start == l.pos {
+			return token{}, l.errorf("invalid tag")
+		}
+		return token{typ: tokTag, text: strings.ToLower(l.src[start:l.pos]), line: line}, nil
+	case c >= '0' && c <= '9':
+		return l.number()
+	case isIdentChar(c, true):
+		start := l.pos
+		for l.pos < len(l.src) && isIdentChar(l.src[l.pos], false) {
+			l.pos++
+		}
+		word := strings.ToLower(l.src[start:l.pos])
+		if word == "text" && l.pos < len(l.src) && l.src[l.pos] == ':' {
+			l.pos++
+			s, err := l.multiline()
+			return token{typ: tokString, text: s, line: line}, err
+		}
+		return token{typ: tokIdent, text: word, line: line}, nil
+	}
+	return token{}, l.errorf("unexpected character %q", c)
+}
+
+func (l *lexer) number() (token, error) {
+	line := l.line
+	start := l.pos
+	for l.pos < len(l.src) && l.src[l.pos] >= '0' && l.src[l.pos] <= '9' {
+		l.pos++
+	}
+	n, err := strconv.ParseInt(l.src[start:l.pos], 10, 64)
+	if err != nil {
+		return token{}, l.errorf("invalid number")
+	}
+	if l.pos < len(l.src) {
+		switch l.src[l.pos] {
+		case 'K', 'k':
+			n <<= 10
+			l.pos++
+		case 'M', 'm':
+			n <<= 20
+			l.pos++
+		case 'G', 'g':
+			n <<= 30
+			l.pos++
+		}
+	}
+	return token{typ: tokNumber, num: n, text: l.src[start:l.pos], line: line}, nil
+}
+
+// quoted 双引号字符串，只有 \" 与 \\ 是转义，其余 \x 等价于 x
+func (l *lexer) quoted() (string, error) {
+	l.pos++
+	var sb strings.Builder
+	for l.pos < len(l.src) {
+		c := l.src[l.pos]
+		switch c {
+		case '"':
+			l.pos++
+			return normalizeNewlines(sb.String()), nil
+		case '\\':
+			l.pos++
+			if l.pos >= len(l.src) {
+				return "", l.errorf("unterminated string")
+			}
+			c = l.src[l.pos]
+		case '\n':
+			l.line++
+		}
+		sb.WriteByte(c)
+		l.pos++
+	}
+	return "", l.errorf("unterminated string")
+}
+
+// multiline text: 字符串，以只有一个点的行结束，行首的 .. 表示 .
+func (l *lexer) multiline() (string, error) {
+	// text: 后面到行尾只能是空白或者注释
+	for l.pos < len(l.src) && (l.src[l.pos] == ' ' || l.src[l.pos] == '\t') {
+		l.pos++
+	}
+	if l.pos < len(l.src) && l.src[l.pos] == '#' {
+		for l.pos < len(l.src) && l.src[l.pos] != '\n' {
+			l.pos++
+		}
+	}
+	if l.pos < len(l.src) && l.src[l.pos] == '\r' {
+		l.pos++
+	}
+	if l.pos >= len(l.src) || l.src[l.pos] != '\n' {
+		return "", l.errorf("text: must be followed by a line break")
+	}
+	l.pos++
+	l.line++
+
+	var lines []string
+	for l.pos < len(l.src) {
+		end := strings.IndexByte(l.src[l.pos:], '\n')
+		var line string
+		if end < 0 {
+			line = l.src[l.pos:]
+			l.pos = len(l.src)
+		} else {
+			line = l.src[l.pos : l.pos+end]
+			l.pos += end + 1
+		}
+		l.line++
+		line = strings.TrimSuffix(line, "\r")
+		if line == "." {
+			if len(lines) == 0 {
+				return "", nil
+			}
+			return strings.Join(lines, "\r\n") + "\r\n", nil
+		}
+		if strings.HasPrefix(line, "..") {
+			line = line[1:]
+		}
+		lines = append(lines, line)
+	}
+	return "", l.errorf("unterminated multi-line string")
+}
+
+func normalizeNewlines(s string) string {
+	if !strings.Contains(s, "\n") {
+		return s
+	}
+	s = strings.ReplaceAll(s, "\r\n", "\n")
+	return strings.ReplaceAll(s, "\n", "\r\n")
+}
@@ -0,0 +1,170 @@
+package sieve
+
+import (
+	"regexp"
+	"strings"
+)
+
+// matcher 比较器与匹配类型，默认为 i;ascii-casemap 与 :is
+type matcher struct {
+	comparator string
+	matchType  string
+}
+
+// asciiLower i;ascii-casemap 只对ASCII字母做大小写转换
+func asciiLower(s string) string {
+	for i := 0; i < len(s); i++ {
+		if s[i] >= 'A' && s[i] <= 'Z' {
+			b := []byte(s)
+			for j := i; j < len(b); j++ {
+				if b[j] >= 'A' && b[j] <= 'Z' {
+					b[j] += 'a' - 'A'
+				}
+			}
+			return string(b)
+		}
+	}
+	return s
+}
+
+// numericValue i;ascii-numeric 取开头的数字，没有数字时视为正无穷，返回去掉前导0的数字串
+func numericValue(s string) (string, bool) {
+	end := 0
+	for end < len(s) && s[end] >= '0' && s[end] <= '9' {
+		end++
+	}
+	if end == 0 {
+		return "", false
+	}
+	v := strings.TrimLeft(s[:end], "0")
+	if v == "" {
+		v = "0"
+	}
+	return v, true
+}
+
+// match 判断一个值是否匹配一个key，返回 :matches 与 :regex 的匹配变量
+func (m *matcher) match(value, key string) (bool, []string, error) {
+	fold := m.comparator == "i;ascii-casemap"
+	switch m.matchType {
+	case "is":
+		if m.comparator == "i;ascii-numeric" {
+			a, okA := numericValue(value)
+			b, okB := numericValue(key)
+			return okA == okB && a == b, nil, nil
+		}
+		if fold {
+			return asciiLower(value) == asciiLower(key), nil, nil
+		}
+		return value == key, nil, nil
+	case "contains":
+		if fold {
+			return strings.Contains(asciiLower(value), asciiLower(key)), nil, nil
+		}
+		return strings.Contains(value, key), nil, nil
+	case "matches":
+		caps, ok := globMatch(key, value, fold)
+		if !ok {
+			return false, nil, nil
+		}
+		return true, append([]string{value}, caps...), nil
+	case "regex":
+		expr := key
+		if fold {
+			expr = "(?i)" + expr
+		}
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			return false, nil, err
+		}
+		caps := re.FindStringSubmatch(value)
+		if caps == nil {
+			return false, nil, nil
+		}
+		return true, caps, nil
+	}
+	return false, nil, nil
+}
+
+type globToken struct {
+	wildcard rune // 0 表示普通字符
+	ch       rune
+}
+
+// globMatch :matches 通配符匹配，* 匹配任意个字符，? 匹配一个字符，\ 转义。
+// 通配符按贪婪方式展开，返回每个通配符匹配到的内容
+func globMatch(pattern, value string, fold bool) ([]string, bool) {
+	if fold {
+		pattern = asciiLower(pattern)
+	}
+	var tokens []globToken
+	pr := []rune(pattern)
+	for i := 0; i < len(pr); i++ {
+		switch pr[i] {
+		case '*', '?':
+			tokens = append(tokens, globToken{wildcard: pr[i]})
+		case '\\':
+			if i+1 < len(pr) {
+				i++
+			}
+			tokens = append(tokens, globToken{ch: pr[i]})
+		default:
+			tokens = append(tokens, globToken{ch: pr[i]})
+		}
+	}
+
+	orig := []rune(value)
+	str := orig
+	if fold {
+		str = []rune(asciiLower(value))
+	}
+
+	// 失败位置缓存，避免多个 * 时指数级回溯
+	failed := map[[2]int]bool{}
+	var caps []string
+	var rec func(ti, si int) bool
+	rec = func(ti, si int) bool {
+		if ti == len(tokens) {
+			return si == len(str)
+		}
+		key := [2]int{ti, si}
+		if failed[key] {
+			return false
+		}
+		t := tokens[ti]
+		switch t.wildcard {
+		case '*':
+			for end := len(str); end >= si; end-- {
+				caps = append(caps, string(orig[si:end]))
+				if rec(ti+1, end) {
+					return true
+				}
+				caps = caps[:len(caps)-1]
+			}
+		case '?':
+			if si < len(str) {
+				caps = append(caps, string(orig[si:si+1]))
+				if rec(ti+1, si+1) {
+					return true
+				}
+				caps = caps[:len(caps)-1]
+			}
+		default:
+			if si < len(str) && str[si] == t.ch && rec(ti+1, si+1) {
+				return true
+			}
+		}
+		failed[key] = true
+		return false
+	}
+	if !rec(0, 0) {
+		return nil, false
+	}
+	return caps, true
+}
+
+// quoteWildcard set :quotewildcard，转义 :matches 的特殊字符
+func quoteWildcard(s string) string {
+	r := strings.NewReplacer(`\`, `\\`, `*`, `\*`, `?`, `\?`)
+	return r.Replace(s)
+}
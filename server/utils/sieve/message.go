@@ -0,0 +1,110 @@
+package sieve
+
+import (
+	"bytes"
+	"io"
+	"mime"
+	"strings"
+
+	"github.com/Jinnrry/pmail/dto/parsemail"
+	"github.com/emersion/go-message"
+)
+
+type rawMessage struct {
+	raw     []byte
+	headers []*parsemail.RawHeader
+	body    []byte
+	from    string
+	to      []string
+}
+
+// NewMessage 根据原始邮件与信封创建 Message，from 为空表示空发件人
+func NewMessage(raw []byte, from string, to []string) Message {
+	headers, body := parsemail.SplitRawMessage(raw)
+	return &rawMessage{raw: raw, headers: headers, body: body, from: from, to: to}
+}
+
+var wordDecoder = &mime.WordDecoder{CharsetReader: message.CharsetReader}
+
+func (m *rawMessage) Header(name string) []string {
+	var ret []string
+	for _, h := range m.headers {
+		if !strings.EqualFold(h.Name, name) {
+			continue
+		}
+		// 去掉折行后解码 RFC 2047 编码
+		v := strings.TrimSpace(strings.NewReplacer("\r\n", "", "\n", "").Replace(h.Value))
+		if decoded, err := wordDecoder.DecodeHeader(v); err == nil {
+			v = decoded
+		}
+		ret = append(ret, v)
+	}
+	return ret
+}
+
+func (m *rawMessage) Envelope(part string) []string {
+	switch part {
+	case "from":
+		return []string{strings.Trim(m.from, "<>")}
+	case "to":
+		return m.to
+	}
+	return nil
+}
+
+func (m *rawMessage) Size() int64 {
+	return int64(len(m.raw))
+}
+
+// contentTypeMatch "" 匹配全部，"text" 匹配 text/*，其他需要完整匹配
+func contentTypeMatch(contentType string, types []string) bool {
+	for _, t := range types {
+		t = strings.ToLower(t)
+		switch {
+		case t == "":
+			return true
+		case strings.Contains(t, "/"):
+			if contentType == t {
+				return true
+			}
+		default:
+			if strings.HasPrefix(contentType, t+"/") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (m *rawMessage) Body(transform string, contentTypes []string) []string {
+	if transform == "raw" {
+		return []string{string(m.body)}
+	}
+	if transform == "text" {
+		contentTypes = []string{"text"}
+	}
+	entity, err := message.Read(bytes.NewReader(m.raw))
+	if entity == nil {
+		if err != nil && contentTypeMatch("text/plain", contentTypes) {
+			return []string{string(m.body)}
+		}
+		return nil
+	}
+	var ret []string
+	entity.Walk(func(path []int, part *message.Entity, err error) error {
+		if err != nil {
+			return nil
+		}
+		contentType, _, _ := part.Header.ContentType()
+		if contentType == "" {
+			contentType = "text/plain"
+		}
+		if strings.HasPrefix(contentType, "multipart/") || !contentTypeMatch(contentType, contentTypes) {
+			return nil
+		}
+		data, _ := io.ReadAll(part.Body)
+		ret = append(ret, string(data))
+		return nil
+	})
+	return ret
+}
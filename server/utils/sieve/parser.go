@@ -0,0 +1,217 @@
+package sieve
+
+import "fmt"
+
+type argKind int
+
+const (
+	argTag argKind = iota
+	argNumber
+	argStrings
+)
+
+// arg 命令或者测试的参数，字符串与字符串列表统一保存为列表
+type arg struct {
+	kind argKind
+	tag  string
+	num  int64
+	strs []string
+	line int
+}
+
+type rawTest struct {
+	name  string
+	args  []arg
+	tests []*rawTest
+	line  int
+}
+
+type rawCommand struct {
+	name  string
+	args  []arg
+	tests []*rawTest
+	block []*rawCommand
+	line  int
+}
+
+type parser struct {
+	lex *lexer
+	tok token
+}
+
+// maxNesting 防止恶意脚本过深的嵌套
+const maxNesting = 32
+
+func (p *parser) advance() error {
+	t, err := p.lex.next()
+	if err != nil {
+		return err
+	}
+	p.tok = t
+	return nil
+}
+
+func (p *parser) errorf(format string, args ...any) error {
+	return &Error{Line: p.tok.line, Msg: fmt.Sprintf(format, args...)}
+}
+
+func parse(src string) ([]*rawCommand, error) {
+	p := &parser{lex: &lexer{src: src, line: 1}}
+	if err := p.advance(); err != nil {
+		return nil, err
+	}
+	cmds, err := p.commands(0)
+	if err != nil {
+		return nil, err
+	}
+	if p.tok.typ != tokEOF {
+		return nil, p.errorf("unexpected %s", p.tok)
+	}
+	return cmds, nil
+}
+
+func (p *parser) commands(depth int) ([]*rawCommand, error) {
+	if depth > maxNesting {
+		return nil, p.errorf("too many nested blocks")
+	}
+	var ret []*rawCommand
+	for p.tok.typ == tokIdent {
+		cmd, err := p.command(depth)
+		if err != nil {
+			return nil, err
+		}
+		ret = append(ret, cmd)
+	}
+	return ret, nil
+}
+
+func (p *parser) command(depth int) (*rawCommand, error) {
+	cmd := &rawCommand{name: p.tok.text, line: p.tok.line}
+	if err := p.advance(); err != nil {
+		return nil, err
+	}
+	args, tests, err := p.arguments(depth)
+	if err != nil {
+		return nil, err
+	}
+	cmd.args, cmd.tests = args, tests
+
+	switch p.tok.typ {
+	case tokSemicolon:
+		return cmd, p.advance()
+	case tokLBrace:
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+		cmd.block, err = p.commands(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+		if p.tok.typ != tokRBrace {
+			return nil, p.errorf("expected } but found %s", p.tok)
+		}
+		if cmd.block == nil {
+			cmd.block = []*rawCommand{}
+		}
+		return cmd, p.advance()
+	}
+	return nil, p.errorf("expected ; or { after %s but found %s", cmd.name, p.tok)
+}
+
+// arguments 解析参数以及可选的测试或者测试列表
+func (p *parser) arguments(depth int) ([]arg, []*rawTest, error) {
+	var args []arg
+	for {
+		switch p.tok.typ {
+		case tokTag:
+			args = append(args, arg{kind: argTag, tag: p.tok.text, line: p.tok.line})
+		case tokNumber:
+			args = append(args, arg{kind: argNumber, num: p.tok.num, line: p.tok.line})
+		case tokString:
+			args = append(args, arg{kind: argStrings, strs: []string{p.tok.text}, line: p.tok.line})
+		case tokLBracket:
+			a, err := p.stringList()
+			if err != nil {
+				return nil, nil, err
+			}
+			args = append(args, a)
+		case tokIdent:
+			t, err := p.test(depth + 1)
+			if err != nil {
+				return nil, nil, err
+			}
+			return args, []*rawTest{t}, nil
+		case tokLParen:
+			tests, err := p.testList(depth + 1)
+			return args, tests, err
+		default:
+			return args, nil, nil
+		}
+		if err := p.advance(); err != nil {
+			return nil, nil, err
+		}
+	}
+}
+
+func (p *parser) stringList() (arg, error) {
+	a := arg{kind: argStrings, line: p.tok.line, strs: []string{}}
+	for {
+		if err := p.advance(); err != nil {
+			return a, err
+		}
+		if p.tok.typ != tokString {
+			return a, p.errorf("expected string in list but found %s", p.tok)
+		}
+		a.strs = append(a.strs, p.tok.text)
+		if err := p.advance(); err != nil {
+			return a, err
+		}
+		switch p.tok.typ {
+		case tokComma:
+			continue
+		case tokRBracket:
+			return a, nil
+		}
+		return a, p.errorf("expected , or ] but found %s", p.tok)
+	}
+}
+
+func (p *parser) test(depth int) (*rawTest, error) {
+	if depth > maxNesting {
+		return nil, p.errorf("too many nested tests")
+	}
+	if p.tok.typ != tokIdent {
+		return nil, p.errorf("expected test but found %s", p.tok)
+	}
+	t := &rawTest{name: p.tok.text, line: p.tok.line}
+	if err := p.advance(); err != nil {
+		return nil, err
+	}
+	args, tests, err := p.arguments(depth)
+	if err != nil {
+		return nil, err
+	}
+	t.args, t.tests = args, tests
+	return t, nil
+}
+
+func (p *parser) testList(depth int) ([]*rawTest, error) {
+	var tests []*rawTest
+	for {
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+		t, err := p.test(depth)
+		if err != nil {
+			return nil, err
+		}
+		tests = append(tests, t)
+		switch p.tok.typ {
+		case tokComma:
+			continue
+		case tokRParen:
+			return tests, p.advance()
+		}
+		return nil, p.errorf("expected , or ) but found %s", p.tok)
+	}
+}
@@ -0,0 +1,101 @@
+// Package sieve 实现 Sieve 邮件过滤语言（RFC 5228）以及常用扩展：
+// fileinto、reject/ereject（RFC 5429）、envelope、imap4flags（RFC 5232）、
+// body（RFC 5173）、variables（RFC 5229）、vacation（RFC 5230）、copy（RFC 3894）、
+// subaddress（RFC 5233）、mailbox（RFC 5490 中的 :create）、regex。
+// 解释器只计算需要执行的动作，投递、转发等由调用方完成。
+package sieve
+
+import "sort"
+
+// extensions 支持的扩展，ManageSieve 的 SIEVE 能力也使用这个列表
+var extensions = map[string]bool{
+	"fileinto":                   true,
+	"reject":                     true,
+	"ereject":                    true,
+	"envelope":                   true,
+	"imap4flags":                 true,
+	"body":                       true,
+	"variables":                  true,
+	"vacation":                   true,
+	"copy":                       true,
+	"subaddress":                 true,
+	"mailbox":                    true,
+	"regex":                      true,
+	"comparator-i;octet":         true,
+	"comparator-i;ascii-casemap": true,
+	"comparator-i;ascii-numeric": true,
+}
+
+// Extensions 返回支持的扩展列表
+func Extensions() []string {
+	var ret []string
+	for ext := range extensions {
+		ret = append(ret, ext)
+	}
+	sort.Strings(ret)
+	return ret
+}
+
+// Message 脚本运行时需要的邮件信息
+type Message interface {
+	// Header 返回全部同名头解码后的值
+	Header(name string) []string
+	// Envelope 返回信封的 from 或者 to
+	Envelope(part string) []string
+	// Size 邮件大小，单位字节
+	Size() int64
+	// Body 按 body 扩展的转换方式返回正文，transform 为 raw、text、content
+	Body(transform string, contentTypes []string) []string
+}
+
+// Options 运行参数
+type Options struct {
+	// SubaddressSeparator 子地址分隔符，用于 :user 与 :detail，默认为 +
+	SubaddressSeparator string
+	// MaxRedirects 单次运行最多允许的 redirect 数量，默认为 5
+	MaxRedirects int
+}
+
+// Keep 保存到收件箱
+type Keep struct {
+	Flags []string
+}
+
+// FileInto 保存到指定文件夹
+type FileInto struct {
+	Mailbox string
+	Flags   []string
+	Create  bool
+}
+
+// Redirect 转发到其他地址
+type Redirect struct {
+	Address string
+}
+
+// Reject 拒收并给发件人回复原因，Extended 为 ereject
+type Reject struct {
+	Reason   string
+	Extended bool
+}
+
+// Vacation 自动回复
+type Vacation struct {
+	Days      int
+	Subject   string
+	From      string
+	Addresses []string
+	Mime      bool
+	Handle    string
+	Reason    string
+}
+
+// Result 脚本执行结果。没有任何动作时 Keep 不为空，表示隐式保存
+type Result struct {
+	Keep      *Keep
+	FileInto  []*FileInto
+	Redirects []*Redirect
+	Reject    *Reject
+	Vacation  *Vacation
+	Discard   bool
+}
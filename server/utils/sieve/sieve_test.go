@@ -0,0 +1,204 @@
+package sieve
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const testMail = "Return-Path: <bob@remote.net>\r\n" +
+	"From: Bob <bob@remote.net>\r\n" +
+	"To: alice+github@example.com, carol@example.com\r\n" +
+	"Subject: =?utf-8?B?5rWL6K+VIFtQUl0gZml4IGJ1Zw==?=\r\n" +
+	"List-Id: dev <dev.remote.net>\r\n" +
+	"X-Spam-Score: 12\r\n" +
+	"Content-Type: multipart/alternative; boundary=b1\r\n" +
+	"\r\n" +
+	"--b1\r\n" +
+	"Content-Type: text/plain; charset=utf-8\r\n" +
+	"\r\n" +
+	"please review the patch\r\n" +
+	"--b1\r\n" +
+	"Content-Type: text/html; charset=utf-8\r\n" +
+	"\r\n" +
+	"<p>please review</p>\r\n" +
+	"--b1--\r\n"
+
+func run(t *testing.T, script string) *Result {
+	t.Helper()
+	s, err := Compile(script)
+	if err != nil {
+		t.Fatalf("Compile() error: %v", err)
+	}
+	msg := NewMessage([]byte(testMail), "bob@remote.net", []string{"alice+github@example.com"})
+	res, err := s.Run(msg, nil)
+	if err != nil {
+		t.Fatalf("Run() error: %v", err)
+	}
+	return res
+}
+
+func TestCompileErrors(t *testing.T) {
+	tests := []struct {
+		script string
+		msg    string
+	}{
+		{`fileinto "a";`, `missing require "fileinto"`},
+		{`require "foo";`, `unsupported extension`},
+		{`keep; require "fileinto";`, `require must come before`},
+		{`if true { keep; } else { keep; } elsif true { keep; }`, `elsif without if`},
+		{`if header :is "a" { keep; }`, `expects 2 arguments`},
+		{`if header :is :contains "a" "b" { keep; }`, `conflicts`},
+		{`if size 100 { keep; }`, `:over or :under`},
+		{`keep`, `expected ; or {`},
+		{`if true { keep; `, `expected }`},
+		{"require \"vacation\";\nvacation :days \"x\" \"away\";", `line 2`},
+		{`require ["regex"]; if header :regex "subject" "(" { keep; }`, `invalid regex`},
+		{`if header :comparator "i;ascii-numeric" :is "x" "1" { keep; }`, `comparator-i;ascii-numeric`},
+		{`foo;`, `unknown command`},
+	}
+	for _, tt := range tests {
+		_, err := Compile(tt.script)
+		if err == nil || !strings.Contains(err.Error(), tt.msg) {
+			t.Errorf("Compile(%q) error = %v, want %q", tt.script, err, tt.msg)
+		}
+	}
+}
+
+func TestImplicitKeep(t *testing.T) {
+	res := run(t, `# nothing to do
+if false { discard; }`)
+	if res.Keep == nil || res.Discard {
+		t.Errorf("expected implicit keep, got %+v", res)
+	}
+	res = run(t, `discard;`)
+	if res.Keep != nil || !res.Discard {
+		t.Errorf("discard should cancel implicit keep, got %+v", res)
+	}
+}
+
+func TestTests(t *testing.T) {
+	tests := []struct {
+		test string
+		want bool
+	}{
+		{`header :contains "subject" "[PR]"`, true},
+		{`header :is "Subject" "测试 [PR] fix bug"`, true},
+		{`header :matches "subject" "*PR]*"`, true},
+		{`header :matches "subject" "PR*"`, false},
+		{`header :is :comparator "i;octet" "x-spam-score" "12"`, true},
+		{`address :domain :is "from" "REMOTE.net"`, true},
+		{`address :localpart :is "to" "carol"`, true},
+		{`address :all :comparator "i;octet" :is "from" "Bob@remote.net"`, false},
+		{`address :user "to" "alice"`, true},
+		{`address :detail "to" "github"`, true},
+		{`envelope :detail "to" "github"`, true},
+		{`envelope :is "from" "bob@remote.net"`, true},
+		{`exists ["list-id", "from"]`, true},
+		{`exists ["list-id", "x-none"]`, false},
+		{`size :over 100`, true},
+		{`size :under 100`, false},
+		{`not exists "x-none"`, true},
+		{`allof(true, header :contains "from" "bob")`, true},
+		{`anyof(false, header :contains "from" "alice")`, false},
+		{`body :contains "review the patch"`, true},
+		{`body :content "text/html" :contains "<p>"`, true},
+		{`body :content "text/html" :contains "the patch"`, false},
+		{`body :raw :contains "--b1"`, true},
+		{`header :regex "subject" "^.*fix (b.g)$"`, true},
+		{`header :value "subject" "x"`, false},
+	}
+	for _, tt := range tests {
+		script := `require ["envelope", "body", "regex", "subaddress", "fileinto"];
+if ` + tt.test + ` { fileinto "yes"; }`
+		if strings.Contains(tt.test, ":value") {
+			if _, err := Compile(script); err == nil {
+				t.Errorf("%s: unsupported tag accepted", tt.test)
+			}
+			continue
+		}
+		res := run(t, script)
+		got := len(res.FileInto) == 1
+		if got != tt.want {
+			t.Errorf("%s = %v, want %v", tt.test, got, tt.want)
+		}
+	}
+}
+
+func TestActions(t *testing.T) {
+	res := run(t, `require ["fileinto", "copy", "imap4flags", "mailbox"];
+addflag "\\Seen";
+if header :contains "list-id" "dev.remote.net" {
+	fileinto :create "Lists/dev";
+	fileinto :copy :flags ["\\Flagged", "$work"] "Work";
+	redirect :copy "archive@example.org";
+	stop;
+}
+fileinto "Never";`)
+	want := []*FileInto{
+		{Mailbox: "Lists/dev", Flags: []string{`\Seen`}, Create: true},
+		{Mailbox: "Work", Flags: []string{`\Flagged`, "$work"}},
+	}
+	if !reflect.DeepEqual(res.FileInto, want) {
+		t.Errorf("FileInto = %+v", res.FileInto)
+	}
+	if res.Keep != nil {
+		t.Errorf("fileinto without :copy should cancel implicit keep")
+	}
+	if len(res.Redirects) != 1 || res.Redirects[0].Address != "archive@example.org" {
+		t.Errorf("Redirects = %+v", res.Redirects)
+	}
+
+	res = run(t, `require "reject"; reject "go away";`)
+	if res.Reject == nil || res.Reject.Reason != "go away" || res.Keep != nil {
+		t.Errorf("reject result = %+v", res)
+	}
+
+	s, _ := Compile(`require ["reject", "fileinto"]; fileinto "a"; reject "no";`)
+	res, err := s.Run(NewMessage([]byte(testMail), "", nil), nil)
+	if err == nil || res.Keep == nil || len(res.FileInto) != 0 {
+		t.Errorf("reject with fileinto should fail with implicit keep, got %+v %v", res, err)
+	}
+}
+
+func TestVariables(t *testing.T) {
+	res := run(t, `require ["variables", "fileinto", "vacation"];
+if header :matches "list-id" "*<*.remote.net>" {
+	set :upperfirst "list" "${2}";
+	fileinto "Lists/${list}";
+}
+set "name" "Alice";
+set :length "len" "${name}";
+vacation :days 3 :subject "Re: ${len}" :handle "h1" text:
+I am away.
+..
+.
+;`)
+	if len(res.FileInto) != 1 || res.FileInto[0].Mailbox != "Lists/Dev" {
+		t.Errorf("FileInto = %+v", res.FileInto)
+	}
+	v := res.Vacation
+	if v == nil || v.Days != 3 || v.Subject != "Re: 5" || v.Handle != "h1" || v.Reason != "I am away.\r\n.\r\n" {
+		t.Errorf("Vacation = %+v", v)
+	}
+}
+
+func TestGlobMatch(t *testing.T) {
+	tests := []struct {
+		pattern, value string
+		ok             bool
+		caps           []string
+	}{
+		{"*@*", "a@b@c", true, []string{"a@b", "c"}},
+		{"a?c", "ABC", true, []string{"B"}},
+		{`a\*`, "a*", true, nil},
+		{`a\*`, "ab", false, nil},
+		{"*x*y*z*", strings.Repeat("x", 40), false, nil},
+	}
+	for _, tt := range tests {
+		caps, ok := globMatch(tt.pattern, tt.value, true)
+		if ok != tt.ok || (ok && !reflect.DeepEqual(caps, tt.caps)) {
+			t.Errorf("globMatch(%q, %q) = %v %v", tt.pattern, tt.value, caps, ok)
+		}
+	}
+}